@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/eliorerz/ovim-updated/pkg/backup"
+	"github.com/eliorerz/ovim-updated/pkg/config"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+const (
+	exportUsage = "usage: ovim-server export [file]"
+	importUsage = "usage: ovim-server import [-on-conflict fail|skip|overwrite] file"
+)
+
+// runExport implements the "export" subcommand. The snapshot is written to the given file, or to
+// out without one.
+func runExport(cfg *config.Config, args []string, out io.Writer) error {
+	if len(args) > 1 {
+		return errors.New(exportUsage)
+	}
+
+	s, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	snapshot, err := backup.Export(s)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return writeSnapshot(out, snapshot)
+	}
+	file, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	if err := writeSnapshot(file, snapshot); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// runImport implements the "import" subcommand. The file "-" reads the snapshot from in.
+func runImport(cfg *config.Config, args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	onConflict := flags.String("on-conflict", string(backup.ConflictFail), "what to do with records that already exist: fail, skip or overwrite")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errors.New(importUsage)
+	}
+	policy, err := backup.ParseConflictPolicy(*onConflict)
+	if err != nil {
+		return err
+	}
+
+	var snapshot backup.Snapshot
+	if path := flags.Arg(0); path == "-" {
+		err = json.NewDecoder(in).Decode(&snapshot)
+	} else {
+		err = readSnapshot(path, &snapshot)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	s, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	result, err := backup.Import(s, &snapshot, policy)
+	if err != nil {
+		// A new database holds the seeded admin user, which most snapshots contain as well
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			return fmt.Errorf("%w, use -on-conflict skip or overwrite to import into a database with existing records", err)
+		}
+		return err
+	}
+	return printImportResult(out, result)
+}
+
+// openDatabase opens the configured database. Unlike the server, it never falls back to the
+// in-memory storage, whose data would be lost on exit.
+func openDatabase(cfg *config.Config) (storage.Storage, error) {
+	if cfg.Database.URL == "" {
+		return nil, fmt.Errorf("database URL is not configured, set %s", config.EnvDatabaseURL)
+	}
+	if path, ok := storage.SQLitePath(cfg.Database.URL); ok {
+		return storage.NewSQLiteStorage(path)
+	}
+	return storage.NewPostgresStorage(cfg.Database.URL)
+}
+
+func writeSnapshot(w io.Writer, snapshot *backup.Snapshot) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(snapshot)
+}
+
+func readSnapshot(path string, snapshot *backup.Snapshot) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewDecoder(file).Decode(snapshot)
+}
+
+func printImportResult(out io.Writer, result *backup.Result) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tCREATED\tOVERWRITTEN\tSKIPPED")
+	for _, row := range []struct {
+		kind   string
+		counts backup.Counts
+	}{
+		{"organizations", result.Organizations},
+		{"vdcs", result.VDCs},
+		{"catalogs", result.Catalogs},
+		{"templates", result.Templates},
+		{"vms", result.VMs},
+		{"users", result.Users},
+		{"catalog sources", result.CatalogSources},
+	} {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", row.kind, row.counts.Created, row.counts.Overwritten, row.counts.Skipped)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	for _, conflict := range result.Conflicts {
+		fmt.Fprintf(out, "skipped %s %s: %s\n", conflict.Kind, conflict.ID, conflict.Reason)
+	}
+	return nil
+}
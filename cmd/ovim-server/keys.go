@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/config"
+)
+
+// setupSigningKeys loads the configured signing keys into the token manager, rotating them
+// first if due, and returns the key manager reloading them
+func setupSigningKeys(ctx context.Context, cfg config.JWTKeysConfig, tokens *auth.TokenManager, clientset kubernetes.Interface) (*auth.KeyManager, error) {
+	var source auth.KeySource
+	if cfg.Secret != "" {
+		if clientset == nil {
+			return nil, fmt.Errorf("signing keys in secret %s need Kubernetes integration", cfg.Secret)
+		}
+		namespace, name, _ := cfg.SecretName()
+		source = &auth.SecretKeySource{Client: clientset, Namespace: namespace, Name: name}
+	} else {
+		source = &auth.DirKeySource{Dir: cfg.Dir}
+	}
+
+	keys := auth.NewKeyManager(tokens, source, cfg.ReloadInterval)
+	if cfg.Rotation > 0 {
+		if err := keys.SetRotation(cfg.Algorithm, cfg.Rotation); err != nil {
+			return nil, err
+		}
+	}
+	if err := keys.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -27,12 +27,14 @@ import (
 	"github.com/eliorerz/ovim-updated/pkg/kubevirt"
 	"github.com/eliorerz/ovim-updated/pkg/storage"
 	tlsutils "github.com/eliorerz/ovim-updated/pkg/tls"
+	"github.com/eliorerz/ovim-updated/pkg/trash"
 	"github.com/eliorerz/ovim-updated/pkg/version"
 )
 
 const (
 	defaultPort             = "8080"
 	gracefulShutdownTimeout = 30 * time.Second
+	tokenPurgeInterval      = time.Hour
 )
 
 func main() {
@@ -47,16 +49,45 @@ func main() {
 		os.Exit(0)
 	}
 
-	klog.Info("Starting OVIM Backend Server")
-
 	cfg, err := config.Load(*configPath)
 	if err != nil {
 		klog.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Subcommands run instead of the server
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "migrate":
+			if err := runMigrate(cfg, flag.Args()[1:], os.Stdout); err != nil {
+				klog.Fatalf("Migration failed: %v", err)
+			}
+			os.Exit(0)
+		case "export":
+			if err := runExport(cfg, flag.Args()[1:], os.Stdout); err != nil {
+				klog.Fatalf("Export failed: %v", err)
+			}
+			os.Exit(0)
+		case "import":
+			if err := runImport(cfg, flag.Args()[1:], os.Stdin, os.Stdout); err != nil {
+				klog.Fatalf("Import failed: %v", err)
+			}
+			os.Exit(0)
+		default:
+			klog.Fatalf("Unknown command %q", flag.Arg(0))
+		}
+	}
+
+	klog.Info("Starting OVIM Backend Server")
+
 	// Initialize storage based on configuration
 	var storageImpl storage.Storage
-	if cfg.Database.URL != "" && cfg.Database.URL != config.DefaultDatabaseURL {
+	if path, ok := storage.SQLitePath(cfg.Database.URL); ok {
+		klog.Infof("Initializing SQLite storage at %s", path)
+		storageImpl, err = storage.NewSQLiteStorage(path)
+		if err != nil {
+			klog.Fatalf("Failed to initialize SQLite storage: %v", err)
+		}
+	} else if cfg.Database.URL != "" && cfg.Database.URL != config.DefaultDatabaseURL {
 		klog.Info("Initializing PostgreSQL storage")
 		storageImpl, err = storage.NewPostgresStorage(cfg.Database.URL)
 		if err != nil {
@@ -203,9 +234,25 @@ func main() {
 		provisioner = kubevirt.NewMockClient()
 	}
 
+	// Purge expired items from the trash in the background until shutdown
+	purgerCtx, stopPurger := context.WithCancel(context.Background())
+	defer stopPurger()
+	go trash.NewPurger(storageImpl, k8sClient, provisioner, cfg.Trash.Retention).Run(purgerCtx, cfg.Trash.PurgeInterval)
+	go purgeExpiredTokens(purgerCtx, storageImpl, cfg.Auth.Lockout.Window)
+
 	server := api.NewServer(cfg, storageImpl, provisioner, k8sClient, kubernetesClient, eventRecorder)
 	handler := server.Handler()
 
+	// Sign tokens with the configured keys instead of the JWT secret, reloading them until shutdown
+	if cfg.Auth.JWTKeys.Enabled() {
+		keyManager, err := setupSigningKeys(purgerCtx, cfg.Auth.JWTKeys, server.TokenManager(), kubernetesClient)
+		if err != nil {
+			klog.Fatalf("Failed to load JWT signing keys: %v", err)
+		}
+		go keyManager.Run(purgerCtx)
+		klog.Info("Tokens are signed with asymmetric keys")
+	}
+
 	// Channel to collect server errors
 	serverErrors := make(chan error, 1)
 
@@ -287,6 +334,37 @@ func main() {
 	if err := mainSrv.Shutdown(ctx); err != nil {
 		klog.Errorf("Server forced to shutdown: %v", err)
 	}
+	if err := server.Close(); err != nil {
+		klog.Errorf("Failed to close server: %v", err)
+	}
 
 	klog.Info("Servers exited")
 }
+
+// purgeExpiredTokens removes expired refresh tokens and revoked access tokens, and the failed
+// logins older than the attempt window, every tokenPurgeInterval until the context is cancelled
+func purgeExpiredTokens(ctx context.Context, store storage.Storage, loginAttemptWindow time.Duration) {
+	ticker := time.NewTicker(tokenPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := store.PurgeExpiredTokens(time.Now())
+		if err != nil {
+			klog.Errorf("Failed to purge expired tokens: %v", err)
+		} else if purged > 0 {
+			klog.V(2).Infof("Purged %d expired tokens", purged)
+		}
+		purged, err = store.PurgeLoginAttempts(time.Now().Add(-loginAttemptWindow))
+		if err != nil {
+			klog.Errorf("Failed to purge login attempts: %v", err)
+		} else if purged > 0 {
+			klog.V(2).Infof("Purged %d login attempts", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/config"
 )
 
 func TestMainFunctionality(t *testing.T) {
@@ -342,3 +347,105 @@ func TestServerIntegration(t *testing.T) {
 		assert.Equal(t, "info", config["logLevel"])
 	})
 }
+
+func TestRunMigrateArguments(t *testing.T) {
+	cfg := &config.Config{Database: config.DatabaseConfig{URL: "postgres://invalid"}}
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "missing command", args: nil},
+		{name: "unknown command", args: []string{"sideways"}},
+		{name: "extra argument to up", args: []string{"up", "2"}},
+		{name: "invalid steps", args: []string{"down", "zero"}},
+		{name: "non-positive steps", args: []string{"down", "0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := runMigrate(cfg, tt.args, &out)
+			assert.Error(t, err)
+			assert.Empty(t, out.String())
+		})
+	}
+
+	t.Run("missing database URL", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runMigrate(&config.Config{}, []string{"status"}, &out)
+		assert.ErrorContains(t, err, config.EnvDatabaseURL)
+	})
+}
+
+func TestRunMigrateSQLite(t *testing.T) {
+	cfg := &config.Config{Database: config.DatabaseConfig{URL: "sqlite://" + filepath.Join(t.TempDir(), "ovim.db")}}
+
+	var out bytes.Buffer
+	require.NoError(t, runMigrate(cfg, []string{"up"}, &out))
+	assert.Contains(t, out.String(), "Applied")
+
+	out.Reset()
+	require.NoError(t, runMigrate(cfg, []string{"up"}, &out))
+	assert.Contains(t, out.String(), "Database schema is up to date")
+
+	out.Reset()
+	require.NoError(t, runMigrate(cfg, []string{"down"}, &out))
+	assert.Contains(t, out.String(), "Rolled back")
+}
+
+func TestRunImportArguments(t *testing.T) {
+	cfg := &config.Config{Database: config.DatabaseConfig{URL: "postgres://invalid"}}
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "missing file", args: nil},
+		{name: "two files", args: []string{"a.json", "b.json"}},
+		{name: "unknown flag", args: []string{"-force", "a.json"}},
+		{name: "unknown conflict policy", args: []string{"-on-conflict", "merge", "a.json"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := runImport(cfg, tt.args, &bytes.Buffer{}, &out)
+			assert.Error(t, err)
+			assert.Empty(t, out.String())
+		})
+	}
+
+	t.Run("extra argument to export", func(t *testing.T) {
+		assert.Error(t, runExport(cfg, []string{"a.json", "b.json"}, &bytes.Buffer{}))
+	})
+
+	t.Run("missing database URL", func(t *testing.T) {
+		err := runExport(&config.Config{}, nil, &bytes.Buffer{})
+		assert.ErrorContains(t, err, config.EnvDatabaseURL)
+	})
+}
+
+func TestRunExportImportSQLite(t *testing.T) {
+	dir := t.TempDir()
+	source := &config.Config{Database: config.DatabaseConfig{URL: "sqlite://" + filepath.Join(dir, "source.db")}}
+	target := &config.Config{Database: config.DatabaseConfig{URL: "sqlite://" + filepath.Join(dir, "target.db")}}
+	snapshotPath := filepath.Join(dir, "snapshot.json")
+
+	require.NoError(t, runExport(source, []string{snapshotPath}, &bytes.Buffer{}))
+
+	// Both databases hold the seeded admin user
+	var out bytes.Buffer
+	err := runImport(target, []string{snapshotPath}, &bytes.Buffer{}, &out)
+	assert.ErrorContains(t, err, "-on-conflict")
+
+	require.NoError(t, runImport(target, []string{"-on-conflict", "overwrite", snapshotPath}, &bytes.Buffer{}, &out))
+	assert.Contains(t, out.String(), "OVERWRITTEN")
+
+	// Exporting to stdout and importing from stdin
+	var snapshot bytes.Buffer
+	require.NoError(t, runExport(source, nil, &snapshot))
+	out.Reset()
+	require.NoError(t, runImport(target, []string{"-on-conflict", "skip", "-"}, &snapshot, &out))
+	assert.Contains(t, out.String(), "users")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/eliorerz/ovim-updated/pkg/config"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+	"github.com/eliorerz/ovim-updated/pkg/storage/migrations"
+)
+
+const migrateUsage = "usage: ovim-server migrate status|up|down [steps]"
+
+// runMigrate implements the "migrate" subcommand
+func runMigrate(cfg *config.Config, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	if cfg.Database.URL == "" {
+		return fmt.Errorf("database URL is not configured, set %s", config.EnvDatabaseURL)
+	}
+
+	command := args[0]
+	steps := 1
+	switch command {
+	case "status", "up":
+		if len(args) > 1 {
+			return errors.New(migrateUsage)
+		}
+	case "down":
+		if len(args) > 2 {
+			return errors.New(migrateUsage)
+		}
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+			steps = n
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q, %s", command, migrateUsage)
+	}
+
+	var migrator *migrations.Migrator
+	var err error
+	if path, ok := storage.SQLitePath(cfg.Database.URL); ok {
+		migrator, err = storage.NewSQLiteMigrator(path)
+	} else {
+		migrator, err = storage.NewPostgresMigrator(cfg.Database.URL)
+	}
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up()
+		printMigrations(out, "Applied", applied)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(out, "Database schema is up to date")
+		}
+		return nil
+	case "down":
+		reverted, err := migrator.Down(steps)
+		printMigrations(out, "Rolled back", reverted)
+		if err != nil {
+			return err
+		}
+		if len(reverted) == 0 {
+			fmt.Fprintln(out, "No applied migrations to roll back")
+		}
+		return nil
+	default:
+		return printStatus(out, migrator)
+	}
+}
+
+func printMigrations(out io.Writer, action string, applied []migrations.Migration) {
+	for _, migration := range applied {
+		fmt.Fprintf(out, "%s migration %03d (%s)\n", action, migration.Version, migration.Description)
+	}
+}
+
+func printStatus(out io.Writer, migrator *migrations.Migrator) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tDESCRIPTION\tSTATUS\tAPPLIED AT")
+	for _, status := range statuses {
+		state, appliedAt := "pending", "-"
+		if status.Applied {
+			state = "applied"
+			appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		if status.Unknown {
+			state = "unknown (newer than this binary)"
+		}
+		fmt.Fprintf(w, "%03d\t%s\t%s\t%s\n", status.Version, status.Description, state, appliedAt)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return migrator.Check()
+}
@@ -288,7 +288,7 @@ func (r *OrganizationReconciler) handleOrgDeletion(ctx context.Context, org *ovi
 
 	// Remove from database
 	if r.Storage != nil {
-		if err := r.Storage.DeleteOrganization(org.Name); err != nil {
+		if err := r.Storage.PurgeOrganization(org.Name); err != nil && err != storage.ErrNotFound {
 			logger.Error(err, "unable to delete organization from database")
 			// Don't block deletion for database issues
 		}
@@ -311,11 +311,14 @@ func (r *OrganizationReconciler) syncToDatabase(ctx context.Context, org *ovimv1
 	if r.Storage == nil {
 		return nil // No database configured
 	}
+	if _, trashed := org.Annotations[ovimv1.TrashedAnnotation]; trashed {
+		return nil // Database record is in the trash
+	}
 
 	logger := log.FromContext(ctx)
 
 	// Check if organization exists in database
-	_, err := r.Storage.GetOrganization(org.Name)
+	existing, err := r.Storage.GetOrganization(org.Name)
 	if err != nil && err != storage.ErrNotFound {
 		return err
 	}
@@ -329,6 +332,12 @@ func (r *OrganizationReconciler) syncToDatabase(ctx context.Context, org *ovimv1
 		CRName:      org.Name,
 		CRNamespace: org.Namespace,
 	}
+	if existing != nil {
+		// Settings only kept in the database survive the sync, and the version makes a concurrent
+		// change of them fail the update, which is retried
+		dbOrg.RequireMFA = existing.RequireMFA
+		dbOrg.ResourceVersion = existing.ResourceVersion
+	}
 
 	if err == storage.ErrNotFound {
 		// Create new organization
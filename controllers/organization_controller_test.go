@@ -159,6 +159,9 @@ func (m *MockStorage) GetUserByUsername(username string) (*models.User, error) {
 	return nil, storage.ErrNotFound
 }
 func (m *MockStorage) GetUserByID(id string) (*models.User, error) { return nil, storage.ErrNotFound }
+func (m *MockStorage) GetUserByExternalID(source, externalID string) (*models.User, error) {
+	return nil, storage.ErrNotFound
+}
 func (m *MockStorage) ListUsersByOrg(orgID string) ([]*models.User, error) {
 	return []*models.User{}, nil
 }
@@ -175,6 +178,15 @@ func (m *MockStorage) ListTemplates() ([]*models.Template, error)     { return [
 func (m *MockStorage) ListTemplatesByOrg(orgID string) ([]*models.Template, error) {
 	return []*models.Template{}, nil
 }
+func (m *MockStorage) CreateCatalog(catalog *models.Catalog) error { return nil }
+func (m *MockStorage) GetCatalog(id string) (*models.Catalog, error) {
+	return nil, storage.ErrNotFound
+}
+func (m *MockStorage) UpdateCatalog(catalog *models.Catalog) error { return nil }
+func (m *MockStorage) DeleteCatalog(id string) error               { return nil }
+func (m *MockStorage) ListCatalogs(orgID string) ([]*models.Catalog, error) {
+	return []*models.Catalog{}, nil
+}
 func (m *MockStorage) CreateVM(vm *models.VirtualMachine) error { return nil }
 func (m *MockStorage) GetVM(id string) (*models.VirtualMachine, error) {
 	return nil, storage.ErrNotFound
@@ -197,6 +209,120 @@ func (m *MockStorage) DeleteOrganizationCatalogSource(id string) error { return
 func (m *MockStorage) ListOrganizationCatalogSources(orgID string) ([]*models.OrganizationCatalogSource, error) {
 	return []*models.OrganizationCatalogSource{}, nil
 }
+func (m *MockStorage) QueryUsers(opts storage.ListOptions) ([]*models.User, string, int, error) {
+	return []*models.User{}, "", 0, nil
+}
+func (m *MockStorage) QueryOrganizations(opts storage.ListOptions) ([]*models.Organization, string, int, error) {
+	return []*models.Organization{}, "", 0, nil
+}
+func (m *MockStorage) QueryVDCs(opts storage.ListOptions) ([]*models.VirtualDataCenter, string, int, error) {
+	return []*models.VirtualDataCenter{}, "", 0, nil
+}
+func (m *MockStorage) QueryTemplates(opts storage.ListOptions) ([]*models.Template, string, int, error) {
+	return []*models.Template{}, "", 0, nil
+}
+func (m *MockStorage) QueryVMs(opts storage.ListOptions) ([]*models.VirtualMachine, string, int, error) {
+	return []*models.VirtualMachine{}, "", 0, nil
+}
+func (m *MockStorage) ListDeletedOrganizations() ([]*models.Organization, error) {
+	return []*models.Organization{}, nil
+}
+func (m *MockStorage) RestoreOrganization(id string) error { return nil }
+func (m *MockStorage) PurgeOrganization(id string) error   { return nil }
+func (m *MockStorage) ListDeletedVDCs() ([]*models.VirtualDataCenter, error) {
+	return []*models.VirtualDataCenter{}, nil
+}
+func (m *MockStorage) RestoreVDC(id string) error { return nil }
+func (m *MockStorage) PurgeVDC(id string) error   { return nil }
+func (m *MockStorage) ListDeletedVMs() ([]*models.VirtualMachine, error) {
+	return []*models.VirtualMachine{}, nil
+}
+func (m *MockStorage) RestoreVM(id string) error { return nil }
+func (m *MockStorage) PurgeVM(id string) error   { return nil }
+func (m *MockStorage) CreateRefreshToken(token *models.RefreshToken) error {
+	return nil
+}
+func (m *MockStorage) GetRefreshTokenByHash(hash string) (*models.RefreshToken, error) {
+	return nil, storage.ErrNotFound
+}
+func (m *MockStorage) RevokeRefreshToken(id string) error               { return nil }
+func (m *MockStorage) RevokeSession(sessionID string) error             { return nil }
+func (m *MockStorage) RevokeUserTokens(userID string) error             { return nil }
+func (m *MockStorage) RevokeToken(token *models.RevokedToken) error     { return nil }
+func (m *MockStorage) IsTokenRevoked(id string) (bool, error)           { return false, nil }
+func (m *MockStorage) PurgeExpiredTokens(before time.Time) (int, error) { return 0, nil }
+func (m *MockStorage) CreatePasswordResetToken(token *models.PasswordResetToken) error {
+	return nil
+}
+func (m *MockStorage) GetPasswordResetTokenByHash(hash string) (*models.PasswordResetToken, error) {
+	return nil, storage.ErrNotFound
+}
+func (m *MockStorage) UsePasswordResetToken(id string, usedAt time.Time) error { return nil }
+func (m *MockStorage) CreateAPIToken(token *models.APIToken) error             { return nil }
+func (m *MockStorage) ListAPITokens(userID string) ([]*models.APIToken, error) {
+	return nil, nil
+}
+func (m *MockStorage) GetAPIToken(id string) (*models.APIToken, error) { return nil, nil }
+func (m *MockStorage) GetAPITokenByHash(hash string) (*models.APIToken, error) {
+	return nil, nil
+}
+func (m *MockStorage) UpdateAPIToken(token *models.APIToken) error          { return nil }
+func (m *MockStorage) TouchAPIToken(id string, usedAt time.Time) error      { return nil }
+func (m *MockStorage) DeleteAPIToken(id string) error                       { return nil }
+func (m *MockStorage) ListOrgRoles(orgID string) ([]*models.OrgRole, error) { return nil, nil }
+func (m *MockStorage) GetOrgRole(id string) (*models.OrgRole, error)        { return nil, nil }
+func (m *MockStorage) GetOrgRoleByName(orgID, name string) (*models.OrgRole, error) {
+	return nil, nil
+}
+func (m *MockStorage) CreateOrgRole(role *models.OrgRole) error                   { return nil }
+func (m *MockStorage) UpdateOrgRole(role *models.OrgRole) error                   { return nil }
+func (m *MockStorage) DeleteOrgRole(id string) error                              { return nil }
+func (m *MockStorage) CreateOrgMembership(membership *models.OrgMembership) error { return nil }
+func (m *MockStorage) GetOrgMembership(userID, orgID string) (*models.OrgMembership, error) {
+	return nil, storage.ErrNotFound
+}
+func (m *MockStorage) ListOrgMemberships(userID, orgID string) ([]*models.OrgMembership, error) {
+	return []*models.OrgMembership{}, nil
+}
+func (m *MockStorage) UpdateOrgMembership(membership *models.OrgMembership) error    { return nil }
+func (m *MockStorage) DeleteOrgMembership(userID, orgID string) error                { return nil }
+func (m *MockStorage) CreateImpersonation(impersonation *models.Impersonation) error { return nil }
+func (m *MockStorage) ListImpersonations(userID string) ([]*models.Impersonation, error) {
+	return []*models.Impersonation{}, nil
+}
+func (m *MockStorage) GetImpersonation(id string) (*models.Impersonation, error) {
+	return nil, storage.ErrNotFound
+}
+func (m *MockStorage) EndImpersonation(id string, endedAt time.Time) error  { return nil }
+func (m *MockStorage) CreateInvitation(invitation *models.Invitation) error { return nil }
+func (m *MockStorage) GetInvitation(id string) (*models.Invitation, error) {
+	return nil, storage.ErrNotFound
+}
+func (m *MockStorage) ListInvitations(orgID string) ([]*models.Invitation, error) {
+	return []*models.Invitation{}, nil
+}
+func (m *MockStorage) RenewInvitation(id, tokenID string, expiresAt time.Time) error  { return nil }
+func (m *MockStorage) AcceptInvitation(id, userID string, acceptedAt time.Time) error { return nil }
+func (m *MockStorage) RevokeInvitation(id string, revokedAt time.Time) error          { return nil }
+func (m *MockStorage) CreateAuditRecord(record *models.AuditRecord) error             { return nil }
+func (m *MockStorage) QueryAuditRecords(opts storage.ListOptions) ([]*models.AuditRecord, string, int, error) {
+	return []*models.AuditRecord{}, "", 0, nil
+}
+func (m *MockStorage) GetLoginAttempt(id string) (*models.LoginAttempt, error) {
+	return nil, storage.ErrNotFound
+}
+func (m *MockStorage) RecordLoginFailure(id string, at, since time.Time) (*models.LoginAttempt, error) {
+	return nil, nil
+}
+func (m *MockStorage) LockLogin(id string, until time.Time) error       { return nil }
+func (m *MockStorage) ResetLoginAttempts(id string) error               { return nil }
+func (m *MockStorage) PurgeLoginAttempts(before time.Time) (int, error) { return 0, nil }
+func (m *MockStorage) WithTx(fn func(tx storage.Storage) error) error {
+	return fn(m)
+}
+func (m *MockStorage) Watch(ctx context.Context) (<-chan storage.ChangeEvent, error) {
+	return nil, nil
+}
 func (m *MockStorage) Ping() error  { return nil }
 func (m *MockStorage) Close() error { return nil }
 
@@ -613,6 +739,7 @@ func TestOrganizationReconciler_SyncToDatabase_UpdateExisting(t *testing.T) {
 		Name:        "test-org",
 		DisplayName: func(s string) *string { return &s }("Old Name"),
 		IsEnabled:   false,
+		RequireMFA:  true,
 	}
 	err := mockStorage.CreateOrganization(existingOrg)
 	require.NoError(t, err)
@@ -640,6 +767,7 @@ func TestOrganizationReconciler_SyncToDatabase_UpdateExisting(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "Updated Organization", dbOrg.Name)
 	assert.True(t, dbOrg.IsEnabled)
+	assert.True(t, dbOrg.RequireMFA, "settings kept only in the database are preserved")
 }
 
 func TestOrganizationReconciler_SyncToDatabase_Error(t *testing.T) {
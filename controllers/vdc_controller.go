@@ -748,7 +748,7 @@ func (r *VirtualDataCenterReconciler) handleVDCDeletion(ctx context.Context, vdc
 
 	// Remove from database
 	if r.Storage != nil {
-		if err := r.Storage.DeleteVDC(vdc.Name); err != nil {
+		if err := r.Storage.PurgeVDC(vdc.Name); err != nil && err != storage.ErrNotFound {
 			logger.Error(err, "unable to delete VDC from database")
 			// Don't block deletion for database issues
 		}
@@ -770,6 +770,9 @@ func (r *VirtualDataCenterReconciler) syncVDCToDatabase(ctx context.Context, vdc
 	if r.Storage == nil {
 		return nil // No database configured
 	}
+	if _, trashed := vdc.Annotations[ovimv1.TrashedAnnotation]; trashed {
+		return nil // Database record is in the trash
+	}
 
 	logger := log.FromContext(ctx)
 
@@ -157,7 +157,7 @@ func (r *VMReconciler) reconcileVM(ctx context.Context, vm *models.VirtualMachin
 			logger.Info("Deleted VM from KubeVirt")
 		}
 		// Remove from database
-		if err := r.Storage.DeleteVM(vm.ID); err != nil {
+		if err := r.Storage.PurgeVM(vm.ID); err != nil {
 			logger.Error(err, "failed to delete VM from database")
 		}
 	}
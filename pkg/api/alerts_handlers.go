@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/eliorerz/ovim-updated/pkg/authz"
 )
 
 // AlertsHandlers handles alerts-related API endpoints
@@ -49,6 +51,10 @@ type AlertNotification struct {
 
 // GetAlertSummary handles GET /alerts/summary
 func (h *AlertsHandlers) GetAlertSummary(c *gin.Context) {
+	if !authorizeOwnOrganization(c, authz.AlertList) {
+		return
+	}
+
 	// TODO: Implement actual alerting system
 	// For now, return empty/mock data since no alerting system is implemented yet
 	summary := &AlertSummary{
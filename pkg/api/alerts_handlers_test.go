@@ -3,12 +3,13 @@ package api
 import (
 	"encoding/json"
 	"net/http"
-	"net/http/httptest"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
 )
 
 func TestAlertsHandlers_GetAlertSummary(t *testing.T) {
@@ -40,10 +41,7 @@ func TestAlertsHandlers_GetAlertSummary(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			handlers := NewAlertsHandlers()
 
-			req := httptest.NewRequest(http.MethodGet, "/alerts/summary", nil)
-			w := httptest.NewRecorder()
-			c, _ := gin.CreateTestContext(w)
-			c.Request = req
+			c, w := setupGinContext(http.MethodGet, "/alerts/summary", nil, "user1", "user", models.RoleOrgUser, "org1")
 
 			handlers.GetAlertSummary(c)
 
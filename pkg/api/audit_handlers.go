@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// AuditHandlers handles the requests on the audit trail of mutating API calls
+type AuditHandlers struct {
+	storage storage.Storage
+}
+
+// NewAuditHandlers creates a new audit handlers instance
+func NewAuditHandlers(storage storage.Storage) *AuditHandlers {
+	return &AuditHandlers{
+		storage: storage,
+	}
+}
+
+// List handles listing audit records, newest first unless sorted otherwise. Records are filtered
+// by actor, resource, organization and a time range of RFC 3339 timestamps, since inclusive and
+// until exclusive. Organization admins only see the records of their organization.
+func (h *AuditHandlers) List(c *gin.Context) {
+	opts, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if opts.Sort == "" {
+		opts.Sort = "-created_at"
+	}
+	opts.OrgID = c.Query("org_id")
+	opts.ActorID = c.Query("actor_id")
+	opts.ResourceType = c.Query("resource_type")
+	opts.ResourceID = c.Query("resource_id")
+	for name, bound := range map[string]*time.Time{"since": &opts.Since, "until": &opts.Until} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		if *bound, err = time.Parse(time.RFC3339, value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + " time, expected RFC 3339"})
+			return
+		}
+	}
+
+	scope, ok := authorizeList(c, authz.AuditList)
+	if !ok {
+		return
+	}
+	if scope != authz.ScopeGlobal {
+		_, _, _, userOrgID, _ := auth.GetUserFromContext(c)
+		opts.OrgID = userOrgID
+	}
+
+	records, nextCursor, total, err := h.storage.QueryAuditRecords(opts)
+	if err != nil {
+		klog.Errorf("Failed to list audit records: %v", err)
+		respondListError(c, err, "Failed to list audit records")
+		return
+	}
+
+	klog.V(6).Infof("Listed %d audit records", len(records))
+	c.JSON(http.StatusOK, gin.H{
+		"records":     records,
+		"total":       total,
+		"next_cursor": nextCursor,
+	})
+}
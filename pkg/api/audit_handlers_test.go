@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// setupAuditStorage returns the role storage with calls of two organizations recorded an hour
+// apart, starting at start
+func setupAuditStorage(t *testing.T, start time.Time) storage.Storage {
+	store := setupRoleStorage(t)
+	records := []*models.AuditRecord{
+		{ID: "audit-1", ActorID: "user-1", OrgID: "org-1", ResourceType: "vm", ResourceID: "vm-1"},
+		{ID: "audit-2", ActorID: "user-2", OrgID: "org-1", ResourceType: "vdc", ResourceID: "vdc-1"},
+		{ID: "audit-3", ActorID: "user-1", OrgID: "org-2", ResourceType: "vm", ResourceID: "vm-2"},
+	}
+	for i, record := range records {
+		record.Method, record.Route, record.StatusCode, record.Outcome = http.MethodPut, APIPrefix+"/vms/:id", http.StatusOK, models.AuditOutcomeSuccess
+		record.CreatedAt = start.Add(time.Duration(i) * time.Hour)
+		require.NoError(t, store.CreateAuditRecord(record))
+	}
+	return store
+}
+
+func TestAuditHandlers_List(t *testing.T) {
+	start := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name           string
+		role           string
+		orgID          string
+		query          string
+		expectedStatus int
+		expectedIDs    []string
+		expectedTotal  int
+	}{
+		{"system admin sees every record, newest first", models.RoleSystemAdmin, "", "", http.StatusOK, []string{"audit-3", "audit-2", "audit-1"}, 3},
+		{"system admin filters by organization", models.RoleSystemAdmin, "", "?org_id=org-2", http.StatusOK, []string{"audit-3"}, 1},
+		{"org admin only sees its organization", models.RoleOrgAdmin, "org-1", "?org_id=org-2", http.StatusOK, []string{"audit-2", "audit-1"}, 2},
+		{"filter by actor", models.RoleSystemAdmin, "", "?actor_id=user-1", http.StatusOK, []string{"audit-3", "audit-1"}, 2},
+		{"filter by resource", models.RoleSystemAdmin, "", "?resource_type=vm&resource_id=vm-1", http.StatusOK, []string{"audit-1"}, 1},
+		{"filter by time", models.RoleSystemAdmin, "", "?since=2026-01-05T11:00:00Z&until=2026-01-05T12:00:00Z", http.StatusOK, []string{"audit-2"}, 1},
+		{"oldest first", models.RoleSystemAdmin, "", "?sort=created_at&limit=2", http.StatusOK, []string{"audit-1", "audit-2"}, 3},
+		{"invalid time", models.RoleSystemAdmin, "", "?since=yesterday", http.StatusBadRequest, nil, 0},
+		{"invalid sort", models.RoleSystemAdmin, "", "?sort=name", http.StatusBadRequest, nil, 0},
+		{"org user", models.RoleOrgUser, "org-1", "", http.StatusForbidden, nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := setupAuditStorage(t, start)
+			handlers := NewAuditHandlers(store)
+
+			c, w := roleContext(store, http.MethodGet, "/audit"+tt.query, nil, tt.role, tt.orgID, gin.Params{})
+			handlers.List(c)
+
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var response struct {
+				Records []*models.AuditRecord `json:"records"`
+				Total   int                   `json:"total"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			ids := make([]string, len(response.Records))
+			for i, record := range response.Records {
+				ids[i] = record.ID
+			}
+			assert.Equal(t, tt.expectedIDs, ids)
+			assert.Equal(t, tt.expectedTotal, response.Total, "the total counts the records of every page")
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/eliorerz/ovim-updated/pkg/audit"
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+	"github.com/eliorerz/ovim-updated/pkg/util"
+)
+
+// auditMaxBodySize is the largest request and response body the audit trail reads. Larger
+// requests, such as backup imports, are recorded without their body.
+const auditMaxBodySize = 64 << 10
+
+// auditedMethods are the methods of mutating calls
+var auditedMethods = map[string]bool{
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// auditSkippedOperations are the mutating calls whose records would only bury the others:
+// refreshing a session happens every few minutes, and validating a resource allocation changes
+// nothing
+var auditSkippedOperations = map[string]bool{
+	"POST " + APIPrefix + "/auth/refresh":                         true,
+	"POST " + APIPrefix + "/organizations/:id/resources/validate": true,
+}
+
+// auditResource is a kind of resource of the API and how to load one, to record what a call
+// changed. get returns nil for a resource that does not exist.
+type auditResource struct {
+	kind string
+	get  func(s storage.Storage, id string) interface{}
+}
+
+// auditResources are the resources of the collections of routes, such as vms in /vms/:id
+var auditResources = map[string]auditResource{
+	"organizations": {"organization", func(s storage.Storage, id string) interface{} {
+		return found(s.GetOrganization(id))
+	}},
+	"users": {"user", func(s storage.Storage, id string) interface{} {
+		return found(s.GetUserByID(id))
+	}},
+	"vdcs": {"vdc", func(s storage.Storage, id string) interface{} {
+		return found(s.GetVDC(id))
+	}},
+	"vms": {"vm", func(s storage.Storage, id string) interface{} {
+		return found(s.GetVM(id))
+	}},
+	"roles": {"role", func(s storage.Storage, id string) interface{} {
+		return found(s.GetOrgRole(id))
+	}},
+	"catalogs": {"catalog", func(s storage.Storage, id string) interface{} {
+		return found(s.GetCatalog(id))
+	}},
+	"catalog-sources": {"catalog_source", func(s storage.Storage, id string) interface{} {
+		return found(s.GetOrganizationCatalogSource(id))
+	}},
+	"invitations": {"invitation", func(s storage.Storage, id string) interface{} {
+		return found(s.GetInvitation(id))
+	}},
+	"tokens": {"api_token", func(s storage.Storage, id string) interface{} {
+		return found(s.GetAPIToken(id))
+	}},
+	// The users and groups of the SCIM service
+	"Users": {"user", func(s storage.Storage, id string) interface{} {
+		return found(s.GetUserByID(id))
+	}},
+	"Groups": {"group", nil},
+}
+
+// found returns a record loaded from the storage, or nil if it could not be
+func found[T any](record *T, err error) interface{} {
+	if err != nil || record == nil {
+		return nil
+	}
+	return record
+}
+
+// AuditRecorder records every mutating API call in the storage and in the audit sink, if any
+type AuditRecorder struct {
+	storage storage.Storage
+	sink    audit.Sink
+}
+
+// NewAuditRecorder creates an audit recorder writing records to the storage and to sink, which
+// may be nil
+func NewAuditRecorder(storage storage.Storage, sink audit.Sink) *AuditRecorder {
+	return &AuditRecorder{
+		storage: storage,
+		sink:    sink,
+	}
+}
+
+// Close closes the audit sink
+func (r *AuditRecorder) Close() error {
+	if r.sink == nil {
+		return nil
+	}
+	return r.sink.Close()
+}
+
+// auditTarget is the resource a call acts on
+type auditTarget struct {
+	resource *auditResource
+	id       string
+	// self is set for the calls of users on their own profile
+	self bool
+}
+
+// Middleware records the mutating calls of every route once they are handled: who made them and
+// for whom, the organization and resource they targeted, their redacted request body, the
+// attributes of the resource they changed and their outcome. Records are written after the
+// response, so that failing to write one never fails the call.
+func (r *AuditRecorder) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if !auditedMethods[c.Request.Method] || route == "" || auditSkippedOperations[c.Request.Method+" "+route] {
+			c.Next()
+			return
+		}
+
+		startedAt := time.Now()
+		request := readAuditRequest(c)
+		target := resolveAuditTarget(c, route)
+		var before interface{}
+		if target.resource != nil && target.resource.get != nil && target.id != "" {
+			before = target.resource.get(r.storage, target.id)
+		}
+
+		writer := &auditResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		record := &models.AuditRecord{
+			Method:     c.Request.Method,
+			Route:      route,
+			Path:       c.Request.URL.Path,
+			StatusCode: writer.Status(),
+			ClientIP:   c.ClientIP(),
+			CreatedAt:  startedAt,
+		}
+		if request != nil {
+			record.Request = models.JSONBMap(audit.Redact(request).(map[string]interface{}))
+		}
+		r.setActor(c, record)
+		r.setOutcome(record, writer.body.Bytes())
+		r.setTarget(c, record, target, before, writer.body.Bytes())
+		r.write(record)
+	}
+}
+
+// setActor sets who made the call, and who impersonated them
+func (r *AuditRecorder) setActor(c *gin.Context, record *models.AuditRecord) {
+	if userID, username, role, _, ok := auth.GetUserFromContext(c); ok {
+		record.ActorID, record.ActorUsername, record.ActorRole = userID, username, role
+	} else if strings.HasPrefix(record.Route, SCIMPrefix+"/") {
+		// Identity providers share the SCIM token, they are no user of OVIM
+		record.ActorUsername = "scim"
+	}
+	if impersonator, ok := auth.GetImpersonatorFromContext(c); ok {
+		record.ImpersonatorID, record.ImpersonatorUsername = impersonator.Subject, impersonator.Username
+	}
+}
+
+// setOutcome sets how the call ended from its response, with the error it responded with
+func (r *AuditRecorder) setOutcome(record *models.AuditRecord, response []byte) {
+	switch {
+	case record.StatusCode < http.StatusBadRequest:
+		record.Outcome = models.AuditOutcomeSuccess
+		return
+	case record.StatusCode == http.StatusUnauthorized || record.StatusCode == http.StatusForbidden:
+		record.Outcome = models.AuditOutcomeDenied
+	default:
+		record.Outcome = models.AuditOutcomeFailure
+	}
+
+	// API errors are {"error": ...} and SCIM errors {"detail": ...}
+	var body struct {
+		Error  string `json:"error"`
+		Detail string `json:"detail"`
+	}
+	if json.Unmarshal(response, &body) == nil {
+		record.Error = body.Error
+		if record.Error == "" {
+			record.Error = body.Detail
+		}
+	}
+}
+
+// setTarget sets the resource the call acted on, what it changed and its organization: the one
+// of the route, or else of the resource, or else the one the actor acted in
+func (r *AuditRecorder) setTarget(c *gin.Context, record *models.AuditRecord, target auditTarget, before interface{}, response []byte) {
+	var after interface{}
+	if target.resource != nil {
+		record.ResourceType = target.resource.kind
+		if target.self {
+			target.id = c.GetString(auth.ContextKeyUserID)
+		} else if target.id == "" && record.Outcome == models.AuditOutcomeSuccess {
+			// The ID of a created resource is in the response
+			target.id = createdID(response)
+		}
+		record.ResourceID = target.id
+
+		if !target.self && target.resource.get != nil && target.id != "" && record.Outcome == models.AuditOutcomeSuccess {
+			after = target.resource.get(r.storage, target.id)
+			beforeAttributes, afterAttributes := auditAttributes(before), auditAttributes(after)
+			if changes := audit.Diff(beforeAttributes, afterAttributes); changes != nil {
+				record.Changes = models.JSONBMap(changes)
+			}
+		}
+	}
+
+	switch {
+	case strings.Contains(record.Route, "/organizations/:id"):
+		record.OrgID = c.Param("id")
+	case record.ResourceType == "organization":
+		record.OrgID = record.ResourceID
+	default:
+		record.OrgID = resourceOrgID(after)
+		if record.OrgID == "" {
+			record.OrgID = resourceOrgID(before)
+		}
+		if record.OrgID == "" {
+			record.OrgID = c.GetString(auth.ContextKeyOrgID)
+		}
+	}
+}
+
+// write stores the record and writes it to the sink, logging the failures
+func (r *AuditRecorder) write(record *models.AuditRecord) {
+	id, err := util.GenerateID(16)
+	if err != nil {
+		klog.Errorf("Failed to generate ID for audit record of %s %s: %v", record.Method, record.Path, err)
+		return
+	}
+	record.ID = id
+
+	if err := r.storage.CreateAuditRecord(record); err != nil {
+		klog.Errorf("Failed to store audit record of %s %s by %q: %v", record.Method, record.Path, record.ActorUsername, err)
+	}
+	if r.sink != nil {
+		if err := r.sink.Write(record); err != nil {
+			klog.Errorf("Failed to write audit record %s to the audit log: %v", record.ID, err)
+		}
+	}
+}
+
+// resolveAuditTarget returns the resource of the last collection of a route, such as the user
+// of /organizations/:id/users/:userId. Calls on the profile act on the user making them.
+func resolveAuditTarget(c *gin.Context, route string) auditTarget {
+	var target auditTarget
+	segments := strings.Split(strings.Trim(route, "/"), "/")
+	for i, segment := range segments {
+		if segment == "profile" {
+			users := auditResources["users"]
+			target = auditTarget{resource: &users, self: true}
+			continue
+		}
+		resource, ok := auditResources[segment]
+		if !ok {
+			continue
+		}
+		target = auditTarget{resource: &resource}
+		if i+1 < len(segments) && strings.HasPrefix(segments[i+1], ":") {
+			target.id = c.Param(segments[i+1][1:])
+		}
+	}
+	return target
+}
+
+// readAuditRequest returns the JSON object of the request body and puts the body back for the
+// handler. Bodies that are too large or no JSON object are not recorded.
+func readAuditRequest(c *gin.Context) map[string]interface{} {
+	if c.Request.Body == nil || c.Request.ContentLength > auditMaxBodySize {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, auditMaxBodySize+1))
+	c.Request.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), c.Request.Body), c.Request.Body}
+	if err != nil || len(body) > auditMaxBodySize {
+		return nil
+	}
+
+	var request map[string]interface{}
+	if json.Unmarshal(body, &request) != nil {
+		return nil
+	}
+	return request
+}
+
+// createdID returns the ID of the resource a creation responded with, either the response
+// itself or the single object it holds with an ID
+func createdID(response []byte) string {
+	var body map[string]interface{}
+	if json.Unmarshal(response, &body) != nil {
+		return ""
+	}
+	if id, ok := body["id"].(string); ok {
+		return id
+	}
+
+	names := make([]string, 0, len(body))
+	for name := range body {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if object, ok := body[name].(map[string]interface{}); ok {
+			if id, ok := object["id"].(string); ok {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// auditAttributes returns a resource as a JSON object, or nil for no resource
+func auditAttributes(resource interface{}) map[string]interface{} {
+	if resource == nil {
+		return nil
+	}
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil
+	}
+	var attributes map[string]interface{}
+	if json.Unmarshal(data, &attributes) != nil {
+		return nil
+	}
+	return attributes
+}
+
+// resourceOrgID returns the organization of a resource, if it has one
+func resourceOrgID(resource interface{}) string {
+	orgID, _ := auditAttributes(resource)["org_id"].(string)
+	return orgID
+}
+
+// auditResponseWriter keeps the beginning of the response body, to record the error or the ID of
+// the created resource it holds
+type auditResponseWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditResponseWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *auditResponseWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *auditResponseWriter) capture(data []byte) {
+	if room := auditMaxBodySize - w.body.Len(); room > 0 {
+		w.body.Write(data[:min(len(data), room)])
+	}
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/audit"
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// recordingSink keeps the records it is given, or fails with err
+type recordingSink struct {
+	records []*models.AuditRecord
+	err     error
+}
+
+func (s *recordingSink) Write(record *models.AuditRecord) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+// auditCaller is the user the routes of auditRouter authenticate
+type auditCaller struct {
+	userID, username, role, orgID string
+	impersonator                  *auth.Actor
+}
+
+// auditRouter returns a router recording the calls on the user routes, made by caller
+func auditRouter(store storage.Storage, sink audit.Sink, caller auditCaller) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(NewAuditRecorder(store, sink).Middleware())
+
+	handlers := NewUserHandlers(store)
+	protected := router.Group(APIPrefix, func(c *gin.Context) {
+		c.Set(auth.ContextKeyUserID, caller.userID)
+		c.Set(auth.ContextKeyUsername, caller.username)
+		c.Set(auth.ContextKeyRole, caller.role)
+		c.Set(auth.ContextKeyOrgID, caller.orgID)
+		if caller.impersonator != nil {
+			c.Set(auth.ContextKeyImpersonator, caller.impersonator)
+		}
+	}, authz.NewAuthorizer(store).Middleware())
+	protected.GET("/users", handlers.List)
+	protected.POST("/users", handlers.Create)
+	protected.PUT("/users/:id", handlers.Update)
+	protected.DELETE("/users/:id", handlers.Delete)
+	protected.POST("/auth/refresh", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "refreshed"})
+	})
+	return router
+}
+
+func serveAudited(router *gin.Engine, method, url string, body interface{}) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, url, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func auditRecords(t *testing.T, store storage.Storage) []*models.AuditRecord {
+	records, _, _, err := store.QueryAuditRecords(storage.ListOptions{})
+	require.NoError(t, err)
+	return records
+}
+
+var auditAdmin = auditCaller{userID: "admin-1", username: "admin", role: models.RoleSystemAdmin}
+
+func TestAuditRecorder_RecordsCreation(t *testing.T) {
+	store := setupRoleStorage(t)
+	sink := &recordingSink{}
+	router := auditRouter(store, sink, auditAdmin)
+
+	w := serveAudited(router, http.MethodPost, APIPrefix+"/users", CreateUserRequest{
+		Username: "alice", Email: "alice@example.com", Password: "correct-horse-battery", Role: models.RoleOrgUser, OrgID: stringPtr("org-1"),
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var user models.User
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &user))
+
+	records := auditRecords(t, store)
+	require.Len(t, records, 1)
+	record := records[0]
+	assert.NotEmpty(t, record.ID)
+	assert.Equal(t, "admin-1", record.ActorID)
+	assert.Equal(t, "admin", record.ActorUsername)
+	assert.Equal(t, models.RoleSystemAdmin, record.ActorRole)
+	assert.Equal(t, http.MethodPost, record.Method)
+	assert.Equal(t, APIPrefix+"/users", record.Route)
+	assert.Equal(t, "user", record.ResourceType)
+	assert.Equal(t, user.ID, record.ResourceID, "the ID of a created resource is taken from the response")
+	assert.Equal(t, "org-1", record.OrgID, "the organization is the one of the resource")
+	assert.Equal(t, http.StatusCreated, record.StatusCode)
+	assert.Equal(t, models.AuditOutcomeSuccess, record.Outcome)
+	assert.Equal(t, "alice", record.Request["username"])
+	assert.Equal(t, audit.Redacted, record.Request["password"])
+	assert.Equal(t, map[string]interface{}{"new": "alice"}, record.Changes["username"])
+	assert.NotContains(t, record.Changes, "updated_at")
+
+	require.Len(t, sink.records, 1)
+	assert.Equal(t, record.ID, sink.records[0].ID)
+}
+
+func TestAuditRecorder_RecordsChanges(t *testing.T) {
+	store := setupRoleStorage(t)
+	impersonator := &auth.Actor{Subject: "root-1", Username: "root"}
+	router := auditRouter(store, nil, auditCaller{userID: "admin-1", username: "admin", role: models.RoleSystemAdmin, impersonator: impersonator})
+
+	w := serveAudited(router, http.MethodPut, APIPrefix+"/users/user-op", UpdateUserRequest{Email: "operator@example.com"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	records := auditRecords(t, store)
+	require.Len(t, records, 1)
+	record := records[0]
+	assert.Equal(t, "user-op", record.ResourceID)
+	assert.Equal(t, APIPrefix+"/users/:id", record.Route)
+	assert.Equal(t, APIPrefix+"/users/user-op", record.Path)
+	assert.Equal(t, "root-1", record.ImpersonatorID)
+	assert.Equal(t, "root", record.ImpersonatorUsername)
+	assert.Equal(t, models.JSONBMap{
+		"email": map[string]interface{}{"old": "op@example.com", "new": "operator@example.com"},
+	}, record.Changes)
+
+	w = serveAudited(router, http.MethodDelete, APIPrefix+"/users/user-op", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	records, _, _, err := store.QueryAuditRecords(storage.ListOptions{Sort: "-created_at", Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, http.MethodDelete, records[0].Method)
+	assert.Equal(t, "org-1", records[0].OrgID, "the organization of a deleted resource is the one it had")
+	assert.Equal(t, map[string]interface{}{"old": "operator@example.com"}, records[0].Changes["email"])
+}
+
+func TestAuditRecorder_RecordsFailures(t *testing.T) {
+	tests := []struct {
+		name            string
+		caller          auditCaller
+		userID          string
+		body            interface{}
+		expectedStatus  int
+		expectedOutcome string
+		expectedError   string
+	}{
+		{"denied", auditCaller{userID: "user-op", username: "op", role: models.RoleOrgUser, orgID: "org-1"}, "user-op", UpdateUserRequest{Role: models.RoleSystemAdmin}, http.StatusForbidden, models.AuditOutcomeDenied, "Insufficient permissions"},
+		{"missing user", auditAdmin, "user-9", UpdateUserRequest{Email: "user9@example.com"}, http.StatusNotFound, models.AuditOutcomeFailure, "User not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := setupRoleStorage(t)
+			router := auditRouter(store, nil, tt.caller)
+
+			w := serveAudited(router, http.MethodPut, APIPrefix+"/users/"+tt.userID, tt.body)
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+
+			records := auditRecords(t, store)
+			require.Len(t, records, 1)
+			assert.Equal(t, tt.expectedStatus, records[0].StatusCode)
+			assert.Equal(t, tt.expectedOutcome, records[0].Outcome)
+			assert.Equal(t, tt.expectedError, records[0].Error)
+			assert.Equal(t, tt.userID, records[0].ResourceID)
+			assert.Empty(t, records[0].Changes)
+		})
+	}
+}
+
+func TestAuditRecorder_SkipsCalls(t *testing.T) {
+	store := setupRoleStorage(t)
+	router := auditRouter(store, &recordingSink{err: errors.New("disk full")}, auditAdmin)
+
+	assert.Equal(t, http.StatusOK, serveAudited(router, http.MethodGet, APIPrefix+"/users", nil).Code)
+	assert.Equal(t, http.StatusOK, serveAudited(router, http.MethodPost, APIPrefix+"/auth/refresh", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serveAudited(router, http.MethodPost, APIPrefix+"/unknown", nil).Code)
+	assert.Empty(t, auditRecords(t, store), "reads, refreshes and unknown routes are not recorded")
+
+	// Failing sinks do not fail calls
+	w := serveAudited(router, http.MethodPut, APIPrefix+"/users/user-op", UpdateUserRequest{Email: "operator@example.com"})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Len(t, auditRecords(t, store), 1)
+}
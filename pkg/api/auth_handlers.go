@@ -2,7 +2,11 @@ package api
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -11,6 +15,7 @@ import (
 	"github.com/eliorerz/ovim-updated/pkg/auth"
 	"github.com/eliorerz/ovim-updated/pkg/models"
 	"github.com/eliorerz/ovim-updated/pkg/storage"
+	"github.com/eliorerz/ovim-updated/pkg/util"
 )
 
 // AuthHandlers handles authentication-related requests
@@ -18,6 +23,11 @@ type AuthHandlers struct {
 	storage      storage.Storage
 	tokenManager *auth.TokenManager
 	oidcProvider *auth.OIDCProvider
+	ldapProvider *auth.LDAPProvider
+	loginLimiter *LoginLimiter
+	passwords    *PasswordManager
+	mfa          *MFAManager
+	invitations  *InvitationManager
 }
 
 // NewAuthHandlers creates a new auth handlers instance
@@ -26,19 +36,105 @@ func NewAuthHandlers(storage storage.Storage, tokenManager *auth.TokenManager, o
 		storage:      storage,
 		tokenManager: tokenManager,
 		oidcProvider: oidcProvider,
+		passwords:    NewPasswordManager(storage, auth.DefaultPasswordPolicy(), 0),
+		mfa:          NewMFAManager(storage, "", nil),
 	}
 }
 
+// SetLDAPProvider sets the LDAP server users can log in with, nil disables LDAP logins
+func (h *AuthHandlers) SetLDAPProvider(provider *auth.LDAPProvider) {
+	h.ldapProvider = provider
+}
+
+// SetLoginLimiter sets the limiter protecting password logins against guessing
+func (h *AuthHandlers) SetLoginLimiter(limiter *LoginLimiter) {
+	h.loginLimiter = limiter
+}
+
+// SetPasswordManager sets the password manager enforcing the password policy
+func (h *AuthHandlers) SetPasswordManager(passwords *PasswordManager) {
+	h.passwords = passwords
+}
+
+// SetMFAManager sets the MFA manager verifying second factors
+func (h *AuthHandlers) SetMFAManager(mfa *MFAManager) {
+	h.mfa = mfa
+}
+
 // LoginRequest represents a login request
 type LoginRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
 }
 
-// LoginResponse represents a login response
+// LoginResponse represents a login response. Token is a short-lived access token, the refresh
+// token gets a new one from /auth/refresh until the session expires. While MustChangePassword
+// is set the token only allows changing the password, and while MFAEnrollmentRequired is set
+// enrolling a second factor.
 type LoginResponse struct {
-	Token string       `json:"token"`
-	User  *models.User `json:"user"`
+	Token                 string       `json:"token"`
+	ExpiresAt             time.Time    `json:"expires_at"`
+	RefreshToken          string       `json:"refresh_token"`
+	RefreshExpiresAt      time.Time    `json:"refresh_expires_at"`
+	MustChangePassword    bool         `json:"must_change_password,omitempty"`
+	MFAEnrollmentRequired bool         `json:"mfa_enrollment_required,omitempty"`
+	User                  *models.User `json:"user"`
+}
+
+// MFAChallengeResponse is the response to the password login of a user with a second factor.
+// The MFA token is exchanged for the tokens of the login at /auth/mfa/verify.
+type MFAChallengeResponse struct {
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// VerifyMFARequest represents the second factor of a login, a TOTP code or a recovery code
+type VerifyMFARequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// ChangePasswordRequest represents a request to change the password of the current user
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// ResetPasswordRequest represents a request to set a new password with a reset token
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// MFACodeRequest represents a request confirmed with a code of the second factor
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// MFAStatusResponse describes the second factor of the current user. Pending is set between
+// enrolling and activating it.
+type MFAStatusResponse struct {
+	Enabled                bool `json:"enabled"`
+	Pending                bool `json:"pending"`
+	Required               bool `json:"required"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// MFAEnrollResponse returns the TOTP secret to add to an authenticator app
+type MFAEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// MFARecoveryCodesResponse returns recovery codes, which are not shown again
+type MFARecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// RefreshRequest represents a request for new tokens
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
 // Login handles user authentication
@@ -50,11 +146,16 @@ func (h *AuthHandlers) Login(c *gin.Context) {
 		return
 	}
 
+	if h.throttled(c, req.Username) {
+		return
+	}
+
 	// Get user by username
 	user, err := h.storage.GetUserByUsername(req.Username)
 	if err != nil {
 		if err == storage.ErrNotFound {
 			klog.V(4).Infof("Login attempt for non-existent user: %s", req.Username)
+			h.loginFailed(c, req.Username)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 			return
 		}
@@ -63,6 +164,14 @@ func (h *AuthHandlers) Login(c *gin.Context) {
 		return
 	}
 
+	// Users of identity providers have no local password
+	if user.PasswordHash == "" {
+		klog.V(4).Infof("Password login attempt for user without a local password: %s", req.Username)
+		h.loginFailed(c, req.Username)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
 	// Verify password
 	valid, err := auth.VerifyPassword(req.Password, user.PasswordHash)
 	if err != nil {
@@ -73,42 +182,245 @@ func (h *AuthHandlers) Login(c *gin.Context) {
 
 	if !valid {
 		klog.V(4).Infof("Invalid password for user: %s", req.Username)
+		h.loginFailed(c, req.Username)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
-	// Generate JWT token
-	orgID := ""
-	if user.OrgID != nil {
-		orgID = *user.OrgID
+	if accountDisabled(c, user) {
+		return
+	}
+
+	// Failed logins are only forgotten once the second factor is verified too
+	if user.MFAEnabled {
+		token, expiresAt, err := h.tokenManager.GenerateMFAChallenge(user.ID, user.Username)
+		if err != nil {
+			klog.Errorf("Failed to generate MFA challenge for user %s: %v", req.Username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		klog.V(4).Infof("User %s logged in with a password, waiting for the second factor", user.Username)
+		c.JSON(http.StatusOK, MFAChallengeResponse{MFARequired: true, MFAToken: token, ExpiresAt: expiresAt})
+		return
 	}
 
-	token, err := h.tokenManager.GenerateToken(user.ID, user.Username, user.Role, orgID)
+	h.completeLogin(c, user)
+}
+
+// VerifyMFA handles the second step of the login of a user with a second factor, exchanging
+// the MFA token of the first step and a code for the tokens of the login
+func (h *AuthHandlers) VerifyMFA(c *gin.Context) {
+	var req VerifyMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		klog.V(4).Infof("Invalid MFA verification request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	challenge, err := h.tokenManager.ValidateMFAChallenge(req.MFAToken)
 	if err != nil {
-		klog.Errorf("Failed to generate token for user %s: %v", req.Username, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		klog.V(4).Infof("Invalid MFA token: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
 		return
 	}
 
-	// Prepare user response (without password hash)
-	userResponse := *user
-	userResponse.PasswordHash = ""
+	// Codes are guessed like passwords, and count towards the same lockout
+	if h.throttled(c, challenge.Username) {
+		return
+	}
+
+	user, err := h.storage.GetUserByID(challenge.UserID)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+			return
+		}
+		klog.Errorf("Failed to get user %s: %v", challenge.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if err := h.mfa.Verify(user, req.Code); err != nil {
+		if err == errInvalidMFACode || err == errMFANotEnrolled {
+			klog.V(4).Infof("Invalid MFA code for user: %s", user.Username)
+			h.loginFailed(c, user.Username)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid MFA code"})
+			return
+		}
+		klog.Errorf("Failed to verify MFA code of user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	h.completeLogin(c, user)
+}
+
+// completeLogin starts the session of a user who gave every factor
+func (h *AuthHandlers) completeLogin(c *gin.Context, user *models.User) {
+	if accountDisabled(c, user) {
+		return
+	}
+
+	if h.loginLimiter != nil {
+		if err := h.loginLimiter.Succeed(user.Username); err != nil {
+			klog.Errorf("Failed to reset login attempts of user %s: %v", user.Username, err)
+		}
+	}
+
+	response, err := h.startSession(user)
+	if err != nil {
+		klog.Errorf("Failed to generate token for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
 
 	klog.Infof("User %s logged in successfully (role: %s)", user.Username, user.Role)
 
-	c.JSON(http.StatusOK, LoginResponse{
-		Token: token,
-		User:  &userResponse,
+	c.JSON(http.StatusOK, response)
+}
+
+// accountDisabled responds and returns true if the user is disabled and may not log in
+func accountDisabled(c *gin.Context, user *models.User) bool {
+	if !user.Disabled {
+		return false
+	}
+	klog.V(4).Infof("Login attempt for disabled user: %s", user.Username)
+	c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+	return true
+}
+
+// throttled responds and returns true while the logins of the user from the client have to wait
+// after failed ones
+func (h *AuthHandlers) throttled(c *gin.Context, username string) bool {
+	if h.loginLimiter == nil {
+		return false
+	}
+	wait, err := h.loginLimiter.Check(username, c.ClientIP())
+	if err != nil {
+		klog.Errorf("Failed to check login attempts of user %s: %v", username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return true
+	}
+	if wait <= 0 {
+		return false
+	}
+
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	klog.V(4).Infof("Login of user %s from %s throttled for %ds", username, c.ClientIP(), retryAfter)
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Too many failed login attempts, try again later",
+		"retry_after": retryAfter,
 	})
+	return true
 }
 
-// Logout handles user logout
-func (h *AuthHandlers) Logout(c *gin.Context) {
-	// In a stateless JWT system, logout is typically handled client-side
-	// by simply discarding the token. We could implement token blacklisting
-	// here if needed in the future.
+// loginFailed counts a failed login, the login is rejected either way
+func (h *AuthHandlers) loginFailed(c *gin.Context, username string) {
+	if h.loginLimiter == nil {
+		return
+	}
+	if err := h.loginLimiter.Fail(c.Request.Context(), username, c.ClientIP()); err != nil {
+		klog.Errorf("Failed to record failed login of user %s: %v", username, err)
+	}
+}
+
+// Refresh handles exchanging a refresh token for new tokens. The refresh token is replaced by a
+// new one, and presenting a replaced token again revokes the whole session since only a copy
+// of the token can have been used in the meantime.
+func (h *AuthHandlers) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		klog.V(4).Infof("Invalid refresh request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	current, err := h.storage.GetRefreshTokenByHash(auth.HashRefreshToken(req.RefreshToken))
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		klog.Errorf("Failed to get refresh token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
 
+	if current.RevokedAt != nil {
+		h.revokeReusedSession(current)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	if time.Now().After(current.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token expired"})
+		return
+	}
+
+	// The new tokens carry the current role and organization of the user
+	user, err := h.storage.GetUserByID(current.UserID)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		klog.Errorf("Failed to get user %s: %v", current.UserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if accountDisabled(c, user) {
+		return
+	}
+
+	response, next, err := h.issueTokens(user, current.SessionID, current.ExpiresAt)
+	if err != nil {
+		klog.Errorf("Failed to generate token for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	err = h.storage.WithTx(func(tx storage.Storage) error {
+		if err := tx.RevokeRefreshToken(current.ID); err != nil {
+			return err
+		}
+		return tx.CreateRefreshToken(next)
+	})
+	if err != nil {
+		if err == storage.ErrConflict {
+			// Another request rotated the token first
+			h.revokeReusedSession(current)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		klog.Errorf("Failed to rotate refresh token of user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	klog.V(4).Infof("Refreshed tokens of user %s (session %s)", user.Username, current.SessionID)
+	c.JSON(http.StatusOK, response)
+}
+
+// revokeReusedSession ends a session whose refresh token was used more than once
+func (h *AuthHandlers) revokeReusedSession(token *models.RefreshToken) {
+	klog.Warningf("Refresh token of session %s of user %s was reused, revoking the session", token.SessionID, token.UserID)
+	if err := h.storage.RevokeSession(token.SessionID); err != nil {
+		klog.Errorf("Failed to revoke session %s: %v", token.SessionID, err)
+	}
+}
+
+// Logout handles user logout by revoking the session of the access token, so that neither the
+// access token nor any refresh token of the session can be used again
+func (h *AuthHandlers) Logout(c *gin.Context) {
 	userID, username, _, _, ok := auth.GetUserFromContext(c)
+	if claims, found := auth.GetClaimsFromContext(c); found {
+		if err := h.revokeClaims(claims); err != nil {
+			klog.Errorf("Failed to revoke tokens of user %s (%s): %v", username, userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+	}
+
 	if ok {
 		klog.Infof("User %s (%s) logged out", username, userID)
 	}
@@ -118,6 +430,364 @@ func (h *AuthHandlers) Logout(c *gin.Context) {
 	})
 }
 
+// revokeClaims revokes an access token and its session, or ends the impersonation it was
+// issued for
+func (h *AuthHandlers) revokeClaims(claims *auth.Claims) error {
+	return h.storage.WithTx(func(tx storage.Storage) error {
+		if claims.ID != "" && claims.ExpiresAt != nil {
+			revoked := &models.RevokedToken{ID: claims.ID, UserID: claims.UserID, ExpiresAt: claims.ExpiresAt.Time}
+			if err := tx.RevokeToken(revoked); err != nil {
+				return err
+			}
+		}
+		if claims.Actor != nil && claims.Actor.ImpersonationID != "" {
+			err := tx.EndImpersonation(claims.Actor.ImpersonationID, time.Now())
+			if err != nil && err != storage.ErrConflict && err != storage.ErrNotFound {
+				return err
+			}
+		}
+		if claims.SessionID != "" {
+			return tx.RevokeSession(claims.SessionID)
+		}
+		return nil
+	})
+}
+
+// startSession issues the tokens of a new login session of the user
+func (h *AuthHandlers) startSession(user *models.User) (*LoginResponse, error) {
+	sessionID, err := util.GenerateID(32)
+	if err != nil {
+		return nil, err
+	}
+
+	response, refreshToken, err := h.issueTokens(user, sessionID, time.Now().Add(h.tokenManager.RefreshDuration()))
+	if err != nil {
+		return nil, err
+	}
+	if err := h.storage.CreateRefreshToken(refreshToken); err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return response, nil
+}
+
+// issueTokens creates an access token and a refresh token for a session. The returned refresh
+// token record still has to be stored.
+func (h *AuthHandlers) issueTokens(user *models.User, sessionID string, sessionExpires time.Time) (*LoginResponse, *models.RefreshToken, error) {
+	orgID := ""
+	if user.OrgID != nil {
+		orgID = *user.OrgID
+	}
+
+	enrollMFA, err := h.mfa.EnrollmentRequired(user)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to check MFA requirement: %w", err)
+	}
+	claims := &auth.Claims{
+		UserID:                 user.ID,
+		Username:               user.Username,
+		Role:                   user.Role,
+		OrgID:                  orgID,
+		SessionID:              sessionID,
+		PasswordChangeRequired: h.passwords.ChangeRequired(user),
+		MFAEnrollmentRequired:  enrollMFA,
+	}
+	accessToken, err := h.tokenManager.GenerateClaimsToken(claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	refreshToken, refreshHash, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	refreshID, err := util.GenerateID(32)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Prepare user response (without password hash)
+	userResponse := *user
+	userResponse.PasswordHash = ""
+
+	response := &LoginResponse{
+		Token:                 accessToken,
+		ExpiresAt:             claims.ExpiresAt.Time,
+		RefreshToken:          refreshToken,
+		RefreshExpiresAt:      sessionExpires,
+		MustChangePassword:    claims.PasswordChangeRequired,
+		MFAEnrollmentRequired: claims.MFAEnrollmentRequired,
+		User:                  &userResponse,
+	}
+	record := &models.RefreshToken{
+		ID:                   refreshID,
+		UserID:               user.ID,
+		SessionID:            sessionID,
+		TokenHash:            refreshHash,
+		AccessTokenID:        claims.ID,
+		AccessTokenExpiresAt: claims.ExpiresAt.Time,
+		ExpiresAt:            sessionExpires,
+	}
+	return response, record, nil
+}
+
+// currentUser returns the current user, or responds and returns false
+func (h *AuthHandlers) currentUser(c *gin.Context) (*models.User, bool) {
+	userID, _, _, _, ok := auth.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, false
+	}
+	user, err := h.storage.GetUserByID(userID)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			return nil, false
+		}
+		klog.Errorf("Failed to get user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return nil, false
+	}
+	return user, true
+}
+
+// ChangePassword handles changing the password of the current user. It ends every session of
+// the user and starts a new one, whose tokens are returned.
+func (h *AuthHandlers) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.PasswordHash == "" {
+		respondPasswordError(c, errNoPassword)
+		return
+	}
+
+	// The current password is guessed like at login, by whoever got hold of a session
+	if h.throttled(c, user.Username) {
+		return
+	}
+	valid, err := auth.VerifyPassword(req.CurrentPassword, user.PasswordHash)
+	if err != nil && err != auth.ErrPasswordTooShort && err != auth.ErrPasswordTooLong {
+		klog.Errorf("Password verification error for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if !valid {
+		klog.V(4).Infof("Invalid current password for user: %s", user.Username)
+		h.loginFailed(c, user.Username)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	if err := h.passwords.Change(user, req.NewPassword); err != nil {
+		respondPasswordError(c, err)
+		return
+	}
+
+	response, err := h.startSession(user)
+	if err != nil {
+		klog.Errorf("Failed to generate token for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	klog.Infof("User %s changed the password", user.Username)
+	c.JSON(http.StatusOK, response)
+}
+
+// ResetPassword handles setting a new password with a reset token issued by an administrator.
+// It ends every session of the user and lifts a lockout after failed logins.
+func (h *AuthHandlers) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	user, err := h.passwords.Reset(req.Token, req.NewPassword)
+	if err != nil {
+		respondPasswordError(c, err)
+		return
+	}
+
+	if h.loginLimiter != nil {
+		if err := h.loginLimiter.Succeed(user.Username); err != nil {
+			klog.Errorf("Failed to reset login attempts of user %s: %v", user.Username, err)
+		}
+	}
+
+	klog.Infof("User %s reset the password", user.Username)
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
+
+// respondPasswordError responds to a password that could not be set
+func respondPasswordError(c *gin.Context, err error) {
+	var policyErr *auth.PasswordPolicyError
+	switch {
+	case errors.As(err, &policyErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": policyErr.Error(), "violations": policyErr.Violations})
+	case err == auth.ErrPasswordReused:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password was used recently, choose another one"})
+	case err == errInvalidResetToken:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+	case err == errNoPassword:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The password of the user is managed by its identity provider"})
+	default:
+		klog.Errorf("Failed to set password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set password"})
+	}
+}
+
+// GetMFA handles getting the second factor of the current user
+func (h *AuthHandlers) GetMFA(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	required, err := h.mfa.Required(user)
+	if err != nil {
+		klog.Errorf("Failed to check MFA requirement of user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get MFA status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, MFAStatusResponse{
+		Enabled:                user.MFAEnabled,
+		Pending:                !user.MFAEnabled && user.MFASecret != "",
+		Required:               required,
+		RecoveryCodesRemaining: len(user.MFARecoveryCodes),
+	})
+}
+
+// EnrollMFA handles starting the enrollment of a second factor for the current user. A new
+// secret replaces a pending one.
+func (h *AuthHandlers) EnrollMFA(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	secret, uri, err := h.mfa.Enroll(user)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	klog.Infof("User %s started MFA enrollment", user.Username)
+	c.JSON(http.StatusOK, MFAEnrollResponse{Secret: secret, OTPAuthURI: uri})
+}
+
+// ActivateMFA handles enabling the pending second factor of the current user with a TOTP code,
+// and returns the recovery codes once. Tokens limited to the enrollment are replaced by
+// refreshing them.
+func (h *AuthHandlers) ActivateMFA(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	codes, err := h.mfa.Activate(user, req.Code)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	klog.Infof("User %s enabled MFA", user.Username)
+	c.JSON(http.StatusOK, MFARecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// RegenerateRecoveryCodes handles replacing the recovery codes of the current user, confirmed
+// with a TOTP code
+func (h *AuthHandlers) RegenerateRecoveryCodes(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	user, ok := h.currentUser(c)
+	if !ok || h.throttled(c, user.Username) {
+		return
+	}
+
+	codes, err := h.mfa.RegenerateRecoveryCodes(user, req.Code)
+	if err != nil {
+		h.mfaFailed(c, user, err)
+		return
+	}
+
+	klog.Infof("User %s regenerated MFA recovery codes", user.Username)
+	c.JSON(http.StatusOK, MFARecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableMFA handles removing the second factor of the current user, confirmed with a TOTP
+// code or a recovery code. A pending enrollment is cancelled without a code.
+func (h *AuthHandlers) DisableMFA(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.MFAEnabled {
+		var req MFACodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A code of the second factor is required"})
+			return
+		}
+		if h.throttled(c, user.Username) {
+			return
+		}
+		if err := h.mfa.Verify(user, req.Code); err != nil {
+			h.mfaFailed(c, user, err)
+			return
+		}
+	}
+
+	if err := h.mfa.Disable(user); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	klog.Infof("User %s disabled MFA", user.Username)
+	c.JSON(http.StatusOK, gin.H{"message": "MFA disabled successfully"})
+}
+
+// mfaFailed responds to a second factor that could not be verified. Wrong codes count as
+// failed logins, whoever got hold of a session must not guess them freely.
+func (h *AuthHandlers) mfaFailed(c *gin.Context, user *models.User, err error) {
+	if err == errInvalidMFACode {
+		klog.V(4).Infof("Invalid MFA code for user: %s", user.Username)
+		h.loginFailed(c, user.Username)
+	}
+	respondMFAError(c, err)
+}
+
+// respondMFAError responds to a second factor that could not be changed
+func respondMFAError(c *gin.Context, err error) {
+	switch err {
+	case errInvalidMFACode:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid MFA code"})
+	case errMFAEnabled:
+		c.JSON(http.StatusConflict, gin.H{"error": "MFA is enabled already, disable it first"})
+	case errMFANotEnrolled:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is not enrolled"})
+	case errNoPassword:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "MFA of the user is managed by its identity provider"})
+	default:
+		klog.Errorf("Failed to update MFA: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update MFA"})
+	}
+}
+
 // GetOIDCAuthURL handles OIDC authentication initiation
 func (h *AuthHandlers) GetOIDCAuthURL(c *gin.Context) {
 	if h.oidcProvider == nil {
@@ -156,15 +826,52 @@ func (h *AuthHandlers) HandleOIDCCallback(c *gin.Context) {
 		return
 	}
 
+	userInfo, ok := h.exchangeOIDCCode(c, req.Code)
+	if !ok {
+		return
+	}
+
+	// Create or update user in our system, with the role and organization given by the mapping rules
+	user, err := h.getOrCreateOIDCUser(userInfo, h.oidcProvider.MapUserInfo(userInfo))
+	if err != nil {
+		if err == errAccountExists {
+			klog.Warningf("OIDC identity %s collides with an account of another identity, refusing the login", userInfo.Subject)
+			c.JSON(http.StatusConflict, gin.H{"error": "Another account already uses this username or email"})
+			return
+		}
+		klog.Errorf("Failed to create/update OIDC user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user account"})
+		return
+	}
+	if accountDisabled(c, user) {
+		return
+	}
+
+	// Generate our own tokens for the user
+	response, err := h.startSession(user)
+	if err != nil {
+		klog.Errorf("Failed to generate JWT token for OIDC user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate authentication token"})
+		return
+	}
+
+	klog.Infof("OIDC user %s logged in successfully (role: %s)", user.Username, user.Role)
+
+	c.JSON(http.StatusOK, response)
+}
+
+// exchangeOIDCCode exchanges an authorization code for the verified information of the user, or
+// responds and returns false
+func (h *AuthHandlers) exchangeOIDCCode(c *gin.Context, code string) (*auth.UserInfo, bool) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	// Exchange code for tokens
-	token, err := h.oidcProvider.ExchangeCode(ctx, req.Code)
+	token, err := h.oidcProvider.ExchangeCode(ctx, code)
 	if err != nil {
 		klog.Errorf("Failed to exchange OIDC code: %v", err)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to authenticate with OIDC provider"})
-		return
+		return nil, false
 	}
 
 	// Extract and verify ID token
@@ -172,14 +879,14 @@ func (h *AuthHandlers) HandleOIDCCallback(c *gin.Context) {
 	if !ok {
 		klog.Error("No ID token found in OIDC response")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid OIDC response"})
-		return
+		return nil, false
 	}
 
 	idToken, err := h.oidcProvider.VerifyIDToken(ctx, rawIDToken)
 	if err != nil {
 		klog.Errorf("Failed to verify OIDC ID token: %v", err)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid ID token"})
-		return
+		return nil, false
 	}
 
 	// Get user info from ID token
@@ -187,11 +894,16 @@ func (h *AuthHandlers) HandleOIDCCallback(c *gin.Context) {
 	if err != nil {
 		klog.Errorf("Failed to extract user info from ID token: %v", err)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to extract user information"})
-		return
+		return nil, false
 	}
+	return userInfo, true
+}
 
-	// Map OIDC user to OVIM user
-	ovimRole := h.oidcProvider.MapOIDCRolesToOVIM(userInfo)
+// getOrCreateOIDCUser creates or updates a user from OIDC information. The mapped role is
+// applied on every login, and so is the mapped organization when the rules manage organizations.
+// Users are matched by their subject, as OIDC users or as users the identity provider
+// provisioned through SCIM with the subject as their externalId, never by username.
+func (h *AuthHandlers) getOrCreateOIDCUser(userInfo *auth.UserInfo, mapping *auth.OIDCMapping) (*models.User, error) {
 	username := userInfo.PreferredUsername
 	if username == "" {
 		username = userInfo.Email
@@ -200,61 +912,26 @@ func (h *AuthHandlers) HandleOIDCCallback(c *gin.Context) {
 		username = userInfo.Subject
 	}
 
-	// Create or update user in our system
-	user, err := h.getOrCreateOIDCUser(userInfo, ovimRole)
+	orgID, err := h.mappedOrganization(username, mapping)
 	if err != nil {
-		klog.Errorf("Failed to create/update OIDC user: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user account"})
-		return
-	}
-
-	// Generate our own JWT token for the user
-	orgID := ""
-	if user.OrgID != nil {
-		orgID = *user.OrgID
+		return nil, err
 	}
 
-	jwtToken, err := h.tokenManager.GenerateToken(user.ID, user.Username, user.Role, orgID)
+	user, err := h.externalUser(username, models.AuthSourceOIDC, userInfo.Subject, models.AuthSourceSCIM)
 	if err != nil {
-		klog.Errorf("Failed to generate JWT token for OIDC user %s: %v", user.Username, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate authentication token"})
-		return
-	}
-
-	// Prepare user response (without password hash)
-	userResponse := *user
-	userResponse.PasswordHash = ""
-
-	klog.Infof("OIDC user %s logged in successfully (role: %s)", user.Username, user.Role)
-
-	c.JSON(http.StatusOK, LoginResponse{
-		Token: jwtToken,
-		User:  &userResponse,
-	})
-}
-
-// getOrCreateOIDCUser creates or updates a user from OIDC information
-func (h *AuthHandlers) getOrCreateOIDCUser(userInfo *auth.UserInfo, role string) (*models.User, error) {
-	username := userInfo.PreferredUsername
-	if username == "" {
-		username = userInfo.Email
-	}
-	if username == "" {
-		username = userInfo.Subject
-	}
-
-	// Try to find existing user
-	user, err := h.storage.GetUserByUsername(username)
-	if err != nil && err != storage.ErrNotFound {
 		return nil, err
 	}
 
 	if user != nil {
 		// Update existing user
+		previousRole, previousOrgID := user.Role, user.OrgID
 		user.Email = userInfo.Email
-		user.Role = role
+		user.Role = mapping.Role
+		if mapping.OrgManaged {
+			user.OrgID = orgID
+		}
 		// Don't update password hash for OIDC users
-		return user, h.storage.UpdateUser(user)
+		return user, updateUserAccess(h.storage, user, previousRole, previousOrgID)
 	}
 
 	// Create new user
@@ -262,20 +939,161 @@ func (h *AuthHandlers) getOrCreateOIDCUser(userInfo *auth.UserInfo, role string)
 		ID:           userInfo.Subject, // Use OIDC subject as user ID
 		Username:     username,
 		Email:        userInfo.Email,
-		Role:         role,
+		Role:         mapping.Role,
+		OrgID:        orgID,
 		PasswordHash: "", // No password for OIDC users
+		AuthSource:   models.AuthSourceOIDC,
+		ExternalID:   userInfo.Subject,
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
 	}
 
-	// For org_admin and user roles, we might want to assign them to a default org
-	// This depends on your business logic
-	if role != "system_admin" {
-		// You might want to extract organization from OIDC claims
-		// For now, we'll leave OrgID as nil
+	return user, createExternalUser(h.storage, user)
+}
+
+// errAccountExists is returned when an identity of a source has the username, email or ID of an
+// account of another source or subject, which it must not take over
+var errAccountExists = errors.New("an account of another identity has this username")
+
+// externalUser returns the user of the subject of an identity source, looked up in source and
+// then in the linked sources, or nil if the subject has none yet. It returns errAccountExists if
+// another account has the username.
+func (h *AuthHandlers) externalUser(username, source, subject string, linked ...string) (*models.User, error) {
+	for _, source := range append([]string{source}, linked...) {
+		user, err := h.storage.GetUserByExternalID(source, subject)
+		if err == nil {
+			return user, nil
+		}
+		if err != storage.ErrNotFound {
+			return nil, err
+		}
+	}
+
+	if _, err := h.storage.GetUserByUsername(username); err == nil {
+		return nil, errAccountExists
+	} else if err != storage.ErrNotFound {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// createExternalUser creates the user of an identity, reporting the accounts it collides with as
+// errAccountExists
+func createExternalUser(s storage.Storage, user *models.User) error {
+	if err := s.CreateUser(user); err != nil {
+		if err == storage.ErrAlreadyExists {
+			return errAccountExists
+		}
+		return err
+	}
+	return nil
+}
+
+// LDAPLogin handles the login of a user of the LDAP server. Users are created on their first
+// login, and their role and organization are updated from their groups on every login.
+func (h *AuthHandlers) LDAPLogin(c *gin.Context) {
+	if h.ldapProvider == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "LDAP authentication is not configured"})
+		return
+	}
+
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		klog.V(4).Infof("Invalid LDAP login request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if h.throttled(c, req.Username) {
+		return
+	}
+
+	ldapUser, err := h.ldapProvider.Authenticate(req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidLDAPCredentials) {
+			klog.V(4).Infof("Invalid LDAP credentials for user: %s", req.Username)
+			h.loginFailed(c, req.Username)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			return
+		}
+		klog.Errorf("Failed to authenticate LDAP user %s: %v", req.Username, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to authenticate with LDAP server"})
+		return
+	}
+
+	user, err := h.getOrCreateLDAPUser(ldapUser, h.ldapProvider.MapUser(ldapUser))
+	if err != nil {
+		if err == errAccountExists {
+			klog.Warningf("LDAP entry %s collides with an account of another identity, refusing the login", ldapUser.DN)
+			c.JSON(http.StatusConflict, gin.H{"error": "Another account already uses this username or email"})
+			return
+		}
+		klog.Errorf("Failed to create/update LDAP user %s: %v", ldapUser.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user account"})
+		return
 	}
 
-	return user, h.storage.CreateUser(user)
+	h.completeLogin(c, user)
+}
+
+// getOrCreateLDAPUser creates or updates a user from the LDAP server like getOrCreateOIDCUser.
+// Users are matched by the DN of their entry, accounts of other sources are never taken over.
+func (h *AuthHandlers) getOrCreateLDAPUser(ldapUser *auth.LDAPUser, mapping *auth.OIDCMapping) (*models.User, error) {
+	orgID, err := h.mappedOrganization(ldapUser.Username, mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := h.externalUser(ldapUser.Username, models.AuthSourceLDAP, ldapUser.DN)
+	if err != nil {
+		return nil, err
+	}
+
+	if user != nil {
+		previousRole, previousOrgID := user.Role, user.OrgID
+		user.Email = ldapUser.Email
+		user.Role = mapping.Role
+		if mapping.OrgManaged {
+			user.OrgID = orgID
+		}
+		return user, updateUserAccess(h.storage, user, previousRole, previousOrgID)
+	}
+
+	userID, err := util.GenerateID(16)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	user = &models.User{
+		ID:         userID,
+		Username:   ldapUser.Username,
+		Email:      ldapUser.Email,
+		Role:       mapping.Role,
+		OrgID:      orgID,
+		AuthSource: models.AuthSourceLDAP,
+		ExternalID: ldapUser.DN,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	klog.Infof("Creating user %s for LDAP entry %s", user.Username, ldapUser.DN)
+	return user, createExternalUser(h.storage, user)
+}
+
+// mappedOrganization returns the organization the mapping rules give a user, or nil if they
+// give none or it does not exist
+func (h *AuthHandlers) mappedOrganization(username string, mapping *auth.OIDCMapping) (*string, error) {
+	if mapping.OrgID == "" {
+		return nil, nil
+	}
+	if _, err := h.storage.GetOrganization(mapping.OrgID); err != nil {
+		if err == storage.ErrNotFound {
+			klog.Warningf("Mapping rules give user %s unknown organization %s, leaving the user without one", username, mapping.OrgID)
+			return nil, nil
+		}
+		return nil, err
+	}
+	orgID := mapping.OrgID
+	return &orgID, nil
 }
 
 // GetAuthInfo returns information about available authentication methods
@@ -283,7 +1101,21 @@ func (h *AuthHandlers) GetAuthInfo(c *gin.Context) {
 	authInfo := gin.H{
 		"local_auth_enabled": true,
 		"oidc_enabled":       h.oidcProvider != nil,
+		"ldap_enabled":       h.ldapProvider != nil,
+		"mfa_enabled":        true,
+	}
+	if len(h.tokenManager.JWKS().Keys) > 0 {
+		authInfo["jwks_uri"] = JWKSPath
 	}
 
 	c.JSON(http.StatusOK, authInfo)
 }
+
+// GetJWKS returns the public keys access tokens are verified with, so that other services can
+// verify them without sharing a secret. The set is empty while tokens are signed with the JWT
+// secret.
+func (h *AuthHandlers) GetJWKS(c *gin.Context) {
+	// Relying parties refetch the set for unknown key IDs, the cache only spares them requests
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.tokenManager.JWKS())
+}
@@ -17,8 +17,11 @@ import (
 	"golang.org/x/oauth2"
 
 	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/auth/ldaptest"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
 	"github.com/eliorerz/ovim-updated/pkg/models"
 	"github.com/eliorerz/ovim-updated/pkg/storage"
+	"github.com/eliorerz/ovim-updated/pkg/util"
 )
 
 // MockOIDCProvider for testing OIDC functionality
@@ -60,9 +63,9 @@ func (m *MockOIDCProvider) GetUserInfo(ctx context.Context, idToken *oidc.IDToke
 	return args.Get(0).(*auth.UserInfo), args.Error(1)
 }
 
-func (m *MockOIDCProvider) MapOIDCRolesToOVIM(userInfo *auth.UserInfo) string {
+func (m *MockOIDCProvider) MapUserInfo(userInfo *auth.UserInfo) *auth.OIDCMapping {
 	args := m.Called(userInfo)
-	return args.String(0)
+	return args.Get(0).(*auth.OIDCMapping)
 }
 
 func TestAuthHandlers_Login(t *testing.T) {
@@ -92,6 +95,8 @@ func TestAuthHandlers_Login(t *testing.T) {
 					PasswordHash: passwordHash,
 				}
 				mockStorage.On("GetUserByUsername", "testuser").Return(user, nil)
+				mockStorage.On("ListOrgMemberships", "user-1", "").Return([]*models.OrgMembership{}, nil)
+				mockStorage.On("CreateRefreshToken", mock.AnythingOfType("*models.RefreshToken")).Return(nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectToken:    true,
@@ -318,10 +323,36 @@ func TestAuthHandlers_GetAuthInfo(t *testing.T) {
 
 			assert.Equal(t, true, response["local_auth_enabled"])
 			assert.Equal(t, tt.expectOIDC, response["oidc_enabled"])
+			assert.Equal(t, false, response["ldap_enabled"])
 		})
 	}
 }
 
+func TestAuthHandlers_GetJWKS(t *testing.T) {
+	tokenManager := auth.NewTokenManager("test-secret", time.Hour)
+	handlers := NewAuthHandlers(new(MockStorage), tokenManager, nil)
+	router := gin.New()
+	router.GET(JWKSPath, handlers.GetJWKS)
+	router.GET("/auth/info", handlers.GetAuthInfo)
+
+	var jwks auth.JSONWebKeySet
+	w := serveJSON(router, http.MethodGet, JWKSPath, "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &jwks))
+	assert.Empty(t, jwks.Keys, "the secret is not published")
+	assert.NotContains(t, serveJSON(router, http.MethodGet, "/auth/info", "", nil).Body.String(), "jwks_uri")
+
+	key, err := auth.GenerateSigningKey(auth.SigningAlgorithmES256, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, tokenManager.SetSigningKeys([]*auth.SigningKey{key}, 0))
+	w = serveJSON(router, http.MethodGet, JWKSPath, "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Cache-Control"))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &jwks))
+	assert.Equal(t, []auth.JSONWebKey{key.JWK()}, jwks.Keys)
+	assert.Contains(t, serveJSON(router, http.MethodGet, "/auth/info", "", nil).Body.String(), `"jwks_uri":"/.well-known/jwks.json"`)
+}
+
 func TestNewAuthHandlers(t *testing.T) {
 	mockStorage := new(MockStorage)
 	tokenManager := auth.NewTokenManager("test-secret", 24*time.Hour)
@@ -406,3 +437,532 @@ func TestLoginRequest_Validation(t *testing.T) {
 		})
 	}
 }
+
+// setupSessionRouter serves the auth endpoints the way the server does, plus a protected /me
+// endpoint, with a memory storage holding the org user alice
+func setupSessionRouter(t *testing.T) (*gin.Engine, storage.Storage) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+	passwordHash, err := auth.HashPassword("alicepassword")
+	require.NoError(t, err)
+	require.NoError(t, store.CreateUser(&models.User{
+		ID:           "alice",
+		Username:     "alice",
+		Email:        "alice@example.com",
+		PasswordHash: passwordHash,
+		Role:         models.RoleOrgUser,
+		OrgID:        stringPtr("org-1"),
+	}))
+
+	tokenManager := auth.NewTokenManager("test-secret", time.Minute)
+	middleware := auth.NewMiddleware(tokenManager)
+	middleware.SetRevocationList(store)
+	handlers := NewAuthHandlers(store, tokenManager, nil)
+
+	router := gin.New()
+	router.POST("/auth/login", handlers.Login)
+	router.POST("/auth/refresh", handlers.Refresh)
+	router.POST("/auth/logout", middleware.RequireAuth(), handlers.Logout)
+	router.GET("/me", middleware.RequireAuth(), func(c *gin.Context) {
+		_, username, role, _, _ := auth.GetUserFromContext(c)
+		c.JSON(http.StatusOK, gin.H{"username": username, "role": role})
+	})
+	return router, store
+}
+
+// serveJSON sends a request with an optional bearer token and JSON body to the router
+func serveJSON(router *gin.Engine, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var payload bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&payload).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// loginAlice logs alice in and returns her tokens
+func loginAlice(t *testing.T, router *gin.Engine) LoginResponse {
+	t.Helper()
+	w := serveJSON(router, http.MethodPost, "/auth/login", "", LoginRequest{Username: "alice", Password: "alicepassword"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.NotEmpty(t, response.Token)
+	require.NotEmpty(t, response.RefreshToken)
+	return response
+}
+
+// refreshTokens exchanges a refresh token, returning the response code and the new tokens
+func refreshTokens(t *testing.T, router *gin.Engine, refreshToken string) (int, LoginResponse) {
+	t.Helper()
+	w := serveJSON(router, http.MethodPost, "/auth/refresh", "", RefreshRequest{RefreshToken: refreshToken})
+	var response LoginResponse
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	}
+	return w.Code, response
+}
+
+func TestAuthHandlers_Refresh(t *testing.T) {
+	t.Run("rotation", func(t *testing.T) {
+		router, _ := setupSessionRouter(t)
+		login := loginAlice(t, router)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), login.ExpiresAt, 5*time.Second)
+		assert.WithinDuration(t, time.Now().Add(auth.DefaultRefreshTokenDuration), login.RefreshExpiresAt, 5*time.Second)
+
+		code, first := refreshTokens(t, router, login.RefreshToken)
+		require.Equal(t, http.StatusOK, code)
+		assert.NotEqual(t, login.Token, first.Token)
+		assert.NotEqual(t, login.RefreshToken, first.RefreshToken)
+		assert.Equal(t, "alice", first.User.Username)
+		assert.Empty(t, first.User.PasswordHash)
+		assert.Equal(t, login.RefreshExpiresAt.Unix(), first.RefreshExpiresAt.Unix(), "refreshing does not extend the session")
+		assert.Equal(t, http.StatusOK, serveJSON(router, http.MethodGet, "/me", first.Token, nil).Code)
+
+		code, second := refreshTokens(t, router, first.RefreshToken)
+		require.Equal(t, http.StatusOK, code)
+
+		// Using a replaced refresh token again ends the session
+		code, _ = refreshTokens(t, router, login.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		code, _ = refreshTokens(t, router, second.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		w := serveJSON(router, http.MethodGet, "/me", second.Token, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), "Token has been revoked")
+	})
+
+	t.Run("current user", func(t *testing.T) {
+		router, store := setupSessionRouter(t)
+		login := loginAlice(t, router)
+
+		user, err := store.GetUserByID("alice")
+		require.NoError(t, err)
+		user.Email = "alice@example.org"
+		require.NoError(t, store.UpdateUser(user))
+
+		code, refreshed := refreshTokens(t, router, login.RefreshToken)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "alice@example.org", refreshed.User.Email)
+
+		require.NoError(t, store.DeleteUser("alice"))
+		code, _ = refreshTokens(t, router, refreshed.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("expired session", func(t *testing.T) {
+		router, store := setupSessionRouter(t)
+		require.NoError(t, store.CreateRefreshToken(&models.RefreshToken{
+			ID:                   "expired",
+			UserID:               "alice",
+			SessionID:            "expired-session",
+			TokenHash:            auth.HashRefreshToken("expired-token"),
+			AccessTokenID:        "expired-access",
+			AccessTokenExpiresAt: time.Now().Add(-time.Hour),
+			ExpiresAt:            time.Now().Add(-time.Minute),
+		}))
+
+		code, _ := refreshTokens(t, router, "expired-token")
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		router, _ := setupSessionRouter(t)
+		code, _ := refreshTokens(t, router, "unknown-token")
+		assert.Equal(t, http.StatusUnauthorized, code)
+		assert.Equal(t, http.StatusBadRequest, serveJSON(router, http.MethodPost, "/auth/refresh", "", gin.H{}).Code)
+	})
+}
+
+func TestAuthHandlers_LogoutRevokesSession(t *testing.T) {
+	router, _ := setupSessionRouter(t)
+	login := loginAlice(t, router)
+	other := loginAlice(t, router)
+
+	w := serveJSON(router, http.MethodPost, "/auth/logout", login.Token, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, http.StatusUnauthorized, serveJSON(router, http.MethodGet, "/me", login.Token, nil).Code)
+	code, _ := refreshTokens(t, router, login.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// Other sessions of the user are not affected
+	assert.Equal(t, http.StatusOK, serveJSON(router, http.MethodGet, "/me", other.Token, nil).Code)
+	code, _ = refreshTokens(t, router, other.RefreshToken)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestAuthHandlers_GetOrCreateOIDCUser(t *testing.T) {
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+	for _, orgID := range []string{"acme", "globex"} {
+		require.NoError(t, store.CreateOrganization(&models.Organization{ID: orgID, Name: orgID, Namespace: "org-" + orgID, CRName: orgID}))
+	}
+	handlers := NewAuthHandlers(store, auth.NewTokenManager("test-secret", time.Minute), nil)
+	userInfo := &auth.UserInfo{Subject: "sub-1", PreferredUsername: "carol", Email: "carol@example.com"}
+
+	// New users land in the mapped organization
+	user, err := handlers.getOrCreateOIDCUser(userInfo, &auth.OIDCMapping{Role: models.RoleOrgUser, OrgID: "acme", OrgManaged: true})
+	require.NoError(t, err)
+	assert.Equal(t, "sub-1", user.ID)
+	assert.Equal(t, models.RoleOrgUser, user.Role)
+	require.NotNil(t, user.OrgID)
+	assert.Equal(t, "acme", *user.OrgID)
+
+	require.NoError(t, store.CreateRefreshToken(&models.RefreshToken{
+		ID: "refresh-1", UserID: "sub-1", SessionID: "session-1", TokenHash: "hash-1", AccessTokenID: "access-1",
+		AccessTokenExpiresAt: time.Now().Add(time.Minute), ExpiresAt: time.Now().Add(time.Hour),
+	}))
+
+	// Group changes in the identity provider move the user and revoke the old tokens
+	user, err = handlers.getOrCreateOIDCUser(userInfo, &auth.OIDCMapping{Role: models.RoleOrgAdmin, OrgID: "globex", OrgManaged: true})
+	require.NoError(t, err)
+	stored, err := store.GetUserByID("sub-1")
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleOrgAdmin, stored.Role)
+	assert.Equal(t, "globex", *stored.OrgID)
+	revoked, err := store.IsTokenRevoked("access-1")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	// Without organization rules the organization is left to administrators
+	_, err = handlers.getOrCreateOIDCUser(userInfo, &auth.OIDCMapping{Role: models.RoleOrgAdmin})
+	require.NoError(t, err)
+	stored, err = store.GetUserByID("sub-1")
+	require.NoError(t, err)
+	assert.Equal(t, "globex", *stored.OrgID)
+
+	// Users whose groups no longer give an organization leave it, unknown organizations are not assigned
+	_, err = handlers.getOrCreateOIDCUser(userInfo, &auth.OIDCMapping{Role: models.RoleOrgUser, OrgManaged: true})
+	require.NoError(t, err)
+	stored, err = store.GetUserByID("sub-1")
+	require.NoError(t, err)
+	assert.Nil(t, stored.OrgID)
+
+	_, err = handlers.getOrCreateOIDCUser(userInfo, &auth.OIDCMapping{Role: models.RoleOrgUser, OrgID: "missing", OrgManaged: true})
+	require.NoError(t, err)
+	stored, err = store.GetUserByID("sub-1")
+	require.NoError(t, err)
+	assert.Nil(t, stored.OrgID)
+	assert.Equal(t, models.AuthSourceOIDC, stored.AuthSource)
+	assert.Equal(t, "sub-1", stored.ExternalID)
+}
+
+func TestAuthHandlers_GetOrCreateOIDCUser_Identities(t *testing.T) {
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+	handlers := NewAuthHandlers(store, auth.NewTokenManager("test-secret", time.Minute), nil)
+	mapping := &auth.OIDCMapping{Role: models.RoleOrgUser}
+	require.NoError(t, store.CreateUser(&models.User{ID: "user-local", Username: "alice", Email: "alice@example.com", PasswordHash: "hash", Role: models.RoleOrgAdmin}))
+	require.NoError(t, store.CreateUser(&models.User{ID: "user-ldap", Username: "bob", Email: "bob@example.com", Role: models.RoleOrgAdmin,
+		AuthSource: models.AuthSourceLDAP, ExternalID: "uid=bob,ou=people,dc=example,dc=com"}))
+	require.NoError(t, store.CreateUser(&models.User{ID: "user-scim", Username: "carol", Email: "carol@example.com", Role: models.RoleOrgMember,
+		AuthSource: models.AuthSourceSCIM, ExternalID: "sub-carol"}))
+
+	tests := []struct {
+		name       string
+		userInfo   *auth.UserInfo
+		expectedID string
+		expected   error
+	}{
+		{"local account of the same username", &auth.UserInfo{Subject: "sub-alice", PreferredUsername: "alice", Email: "alice@idp.example.com"}, "", errAccountExists},
+		{"LDAP account of the same username", &auth.UserInfo{Subject: "sub-bob", PreferredUsername: "bob", Email: "bob@idp.example.com"}, "", errAccountExists},
+		{"account of the same email", &auth.UserInfo{Subject: "sub-dave", PreferredUsername: "dave", Email: "bob@example.com"}, "", errAccountExists},
+		{"account of the same ID", &auth.UserInfo{Subject: "user-local", PreferredUsername: "erin", Email: "erin@example.com"}, "", errAccountExists},
+		{"SCIM account of the subject", &auth.UserInfo{Subject: "sub-carol", PreferredUsername: "carol.smith", Email: "carol@example.com"}, "user-scim", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := handlers.getOrCreateOIDCUser(tt.userInfo, mapping)
+			assert.Equal(t, tt.expected, err)
+			if tt.expected == nil {
+				assert.Equal(t, tt.expectedID, user.ID)
+			}
+		})
+	}
+
+	// Refused identities change nothing
+	bob, err := store.GetUserByID("user-ldap")
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleOrgAdmin, bob.Role)
+	assert.Equal(t, "bob@example.com", bob.Email)
+}
+
+func TestAuthHandlers_LDAPLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := ldaptest.NewServer(
+		ldaptest.Entry{DN: "cn=ovim,dc=example,dc=com", Password: "service-secret"},
+		ldaptest.Entry{DN: "uid=dave,ou=people,dc=example,dc=com", Password: "dave-secret", Attributes: map[string][]string{
+			"uid": {"dave"}, "mail": {"dave@example.com"},
+			"memberOf": {"cn=team-acme,ou=groups,dc=example,dc=com"},
+		}},
+		ldaptest.Entry{DN: "uid=alice,ou=people,dc=example,dc=com", Password: "ldap-secret", Attributes: map[string][]string{
+			"uid": {"alice"}, "mail": {"alice@example.com"},
+		}},
+	)
+	defer server.Close()
+	provider, err := auth.NewLDAPProvider(&auth.LDAPConfig{
+		Enabled:       true,
+		URL:           server.URL,
+		BindDN:        "cn=ovim,dc=example,dc=com",
+		BindPassword:  "service-secret",
+		UserBaseDN:    "ou=people,dc=example,dc=com",
+		GroupMappings: []auth.LDAPGroupMapping{{Group: "team-(.+)", Match: auth.MatchRegex, Role: models.RoleOrgAdmin, OrgID: "$1"}},
+	})
+	require.NoError(t, err)
+
+	router, store := setupSessionRouter(t)
+	require.NoError(t, store.CreateOrganization(&models.Organization{ID: "acme", Name: "acme", Namespace: "org-acme", CRName: "acme"}))
+	handlers := NewAuthHandlers(store, auth.NewTokenManager("test-secret", time.Minute), nil)
+	router.POST("/auth/ldap/login", handlers.LDAPLogin)
+	router.GET("/auth/info", handlers.GetAuthInfo)
+
+	// LDAP logins are only served once a provider is set
+	w := serveJSON(router, http.MethodPost, "/auth/ldap/login", "", LoginRequest{Username: "dave", Password: "dave-secret"})
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+	handlers.SetLDAPProvider(provider)
+	w = serveJSON(router, http.MethodGet, "/auth/info", "", nil)
+	assert.Contains(t, w.Body.String(), `"ldap_enabled":true`)
+
+	// The first login provisions the user from the directory
+	w = serveJSON(router, http.MethodPost, "/auth/ldap/login", "", LoginRequest{Username: "dave", Password: "dave-secret"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.NotEmpty(t, response.Token)
+	assert.Equal(t, "dave", response.User.Username)
+	stored, err := store.GetUserByUsername("dave")
+	require.NoError(t, err)
+	assert.Equal(t, "dave@example.com", stored.Email)
+	assert.Equal(t, models.RoleOrgAdmin, stored.Role)
+	assert.Equal(t, "acme", util.StringValue(stored.OrgID))
+	assert.Empty(t, stored.PasswordHash)
+
+	// Later logins update the user from the groups, and keep the ID
+	server.SetEntries(
+		ldaptest.Entry{DN: "cn=ovim,dc=example,dc=com", Password: "service-secret"},
+		ldaptest.Entry{DN: "uid=dave,ou=people,dc=example,dc=com", Password: "dave-secret", Attributes: map[string][]string{"uid": {"dave"}, "mail": {"dave@example.com"}}},
+	)
+	w = serveJSON(router, http.MethodPost, "/auth/ldap/login", "", LoginRequest{Username: "dave", Password: "dave-secret"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	updated, err := store.GetUserByUsername("dave")
+	require.NoError(t, err)
+	assert.Equal(t, stored.ID, updated.ID)
+	assert.Equal(t, models.RoleOrgUser, updated.Role)
+	assert.Nil(t, updated.OrgID)
+
+	// Directory users have no local password
+	w = serveJSON(router, http.MethodPost, "/auth/login", "", LoginRequest{Username: "dave", Password: "dave-secret"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serveJSON(router, http.MethodPost, "/auth/ldap/login", "", LoginRequest{Username: "dave", Password: "wrong"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// A directory user cannot take over the local account of the same name
+	server.SetEntries(
+		ldaptest.Entry{DN: "cn=ovim,dc=example,dc=com", Password: "service-secret"},
+		ldaptest.Entry{DN: "uid=alice,ou=people,dc=example,dc=com", Password: "ldap-secret", Attributes: map[string][]string{"uid": {"alice"}}},
+	)
+	w = serveJSON(router, http.MethodPost, "/auth/ldap/login", "", LoginRequest{Username: "alice", Password: "ldap-secret"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	alice, err := store.GetUserByUsername("alice")
+	require.NoError(t, err)
+	assert.Equal(t, "org-1", util.StringValue(alice.OrgID))
+
+	// Nor accounts without a password from other identity sources
+	require.NoError(t, store.CreateUser(&models.User{ID: "sub-erin", Username: "erin", Email: "erin@example.com", Role: models.RoleOrgAdmin,
+		AuthSource: models.AuthSourceOIDC, ExternalID: "sub-erin"}))
+	server.SetEntries(
+		ldaptest.Entry{DN: "cn=ovim,dc=example,dc=com", Password: "service-secret"},
+		ldaptest.Entry{DN: "uid=erin,ou=people,dc=example,dc=com", Password: "ldap-secret", Attributes: map[string][]string{"uid": {"erin"}}},
+	)
+	w = serveJSON(router, http.MethodPost, "/auth/ldap/login", "", LoginRequest{Username: "erin", Password: "ldap-secret"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	erin, err := store.GetUserByID("sub-erin")
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleOrgAdmin, erin.Role)
+
+	// Directory users are matched by their entry
+	dave, err := store.GetUserByExternalID(models.AuthSourceLDAP, "uid=dave,ou=people,dc=example,dc=com")
+	require.NoError(t, err)
+	assert.Equal(t, stored.ID, dave.ID)
+
+	// An unreachable server is not a failed login
+	server.Close()
+	w = serveJSON(router, http.MethodPost, "/auth/ldap/login", "", LoginRequest{Username: "dave", Password: "dave-secret"})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+// setupPasswordRouter returns a router serving the password endpoints for alice and an
+// administrator. passwords creates the password manager, the default one is used if nil.
+func setupPasswordRouter(t *testing.T, passwords func(storage.Storage) *PasswordManager) (*gin.Engine, storage.Storage) {
+	router, store := setupSessionRouter(t)
+	adminHash, err := auth.HashPassword("adminpassword")
+	require.NoError(t, err)
+	require.NoError(t, store.CreateUser(&models.User{ID: "admin", Username: "admin", Email: "admin@example.com", PasswordHash: adminHash, Role: models.RoleSystemAdmin}))
+
+	tokenManager := auth.NewTokenManager("test-secret", time.Minute)
+	middleware := auth.NewMiddleware(tokenManager)
+	middleware.SetRevocationList(store)
+	authHandlers := NewAuthHandlers(store, tokenManager, nil)
+	userHandlers := NewUserHandlers(store)
+	if passwords != nil {
+		authHandlers.SetPasswordManager(passwords(store))
+		userHandlers.SetPasswordManager(passwords(store))
+	}
+
+	router = gin.New()
+	router.POST("/auth/login", authHandlers.Login)
+	router.POST("/auth/password-reset", authHandlers.ResetPassword)
+	protected := router.Group("/", middleware.RequireAuth(), middleware.RequirePasswordChange("/profile/password"), authz.NewAuthorizer(store).Middleware())
+	protected.PUT("/profile/password", authHandlers.ChangePassword)
+	protected.POST("/users/:id/password-reset", userHandlers.CreatePasswordReset)
+	protected.GET("/me", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{})
+	})
+	return router, store
+}
+
+func TestAuthHandlers_ChangePassword(t *testing.T) {
+	policy := auth.PasswordPolicy{MinLength: 10, RequireDigit: true, History: 2}
+	router, store := setupPasswordRouter(t, func(store storage.Storage) *PasswordManager {
+		return NewPasswordManager(store, policy, 0)
+	})
+	login := loginAlice(t, router)
+
+	change := func(token, current, password string) *httptest.ResponseRecorder {
+		return serveJSON(router, http.MethodPut, "/profile/password", token, ChangePasswordRequest{CurrentPassword: current, NewPassword: password})
+	}
+
+	assert.Equal(t, http.StatusForbidden, change(login.Token, "wrongpassword", "newpassword1").Code)
+	w := change(login.Token, "alicepassword", "short1")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "must be at least 10 characters")
+	w = change(login.Token, "alicepassword", "longpassword")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "must contain a digit")
+
+	w = change(login.Token, "alicepassword", "newpassword1")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var changed LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &changed))
+	assert.Equal(t, http.StatusUnauthorized, serveJSON(router, http.MethodGet, "/me", login.Token, nil).Code, "other sessions end")
+	assert.Equal(t, http.StatusOK, serveJSON(router, http.MethodGet, "/me", changed.Token, nil).Code)
+
+	user, err := store.GetUserByID("alice")
+	require.NoError(t, err)
+	require.NotNil(t, user.PasswordChangedAt)
+	assert.Len(t, user.PasswordHistory, 1)
+
+	// The history covers the current and the previous password
+	assert.Equal(t, http.StatusBadRequest, change(changed.Token, "newpassword1", "newpassword1").Code)
+	assert.Equal(t, http.StatusBadRequest, change(changed.Token, "newpassword1", "alicepassword").Code)
+	w = change(changed.Token, "newpassword1", "newpassword2")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &changed))
+	w = change(changed.Token, "newpassword2", "newpassword3")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &changed))
+	assert.Equal(t, http.StatusOK, change(changed.Token, "newpassword3", "newpassword1").Code, "passwords beyond the history can be chosen again")
+}
+
+func TestAuthHandlers_MustChangePassword(t *testing.T) {
+	router, store := setupPasswordRouter(t, nil)
+	user, err := store.GetUserByID("alice")
+	require.NoError(t, err)
+	user.MustChangePassword = true
+	require.NoError(t, store.UpdateUser(user))
+
+	login := loginAlice(t, router)
+	assert.True(t, login.MustChangePassword)
+	w := serveJSON(router, http.MethodGet, "/me", login.Token, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "the token only allows changing the password")
+	assert.Contains(t, w.Body.String(), "password_change_required")
+
+	w = serveJSON(router, http.MethodPut, "/profile/password", login.Token, ChangePasswordRequest{CurrentPassword: "alicepassword", NewPassword: "newpassword"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var changed LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &changed))
+	assert.False(t, changed.MustChangePassword)
+	assert.Equal(t, http.StatusOK, serveJSON(router, http.MethodGet, "/me", changed.Token, nil).Code)
+
+	t.Run("expired password", func(t *testing.T) {
+		router, store := setupPasswordRouter(t, func(store storage.Storage) *PasswordManager {
+			return NewPasswordManager(store, auth.PasswordPolicy{MaxAge: time.Hour}, 0)
+		})
+		user, err := store.GetUserByID("alice")
+		require.NoError(t, err)
+		changedAt := time.Now().Add(-2 * time.Hour)
+		user.PasswordChangedAt = &changedAt
+		require.NoError(t, store.UpdateUser(user))
+
+		assert.True(t, loginAlice(t, router).MustChangePassword)
+	})
+}
+
+func TestAuthHandlers_ResetPassword(t *testing.T) {
+	router, store := setupPasswordRouter(t, nil)
+	loginAs := func(username, password string) string {
+		w := serveJSON(router, http.MethodPost, "/auth/login", "", LoginRequest{Username: username, Password: password})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response LoginResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Token
+	}
+	adminSession := loginAs("admin", "adminpassword")
+	aliceSession := loginAlice(t, router)
+
+	issue := func(token, userID string) (int, string) {
+		w := serveJSON(router, http.MethodPost, "/users/"+userID+"/password-reset", token, nil)
+		var response struct {
+			ResetToken string `json:"reset_token"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response.ResetToken
+	}
+	reset := func(token, password string) int {
+		return serveJSON(router, http.MethodPost, "/auth/password-reset", "", ResetPasswordRequest{Token: token, NewPassword: password}).Code
+	}
+
+	code, _ := issue(aliceSession.Token, "admin")
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = issue(adminSession, "missing")
+	assert.Equal(t, http.StatusNotFound, code)
+	require.NoError(t, store.CreateUser(&models.User{ID: "oidc-user", Username: "oidc-user", Email: "oidc@example.com", Role: models.RoleOrgUser, OrgID: stringPtr("org-1")}))
+	code, _ = issue(adminSession, "oidc-user")
+	assert.Equal(t, http.StatusBadRequest, code, "users without a password log in through their identity provider")
+
+	code, older := issue(adminSession, "alice")
+	require.Equal(t, http.StatusCreated, code)
+	code, token := issue(adminSession, "alice")
+	require.Equal(t, http.StatusCreated, code)
+	require.NotEmpty(t, token)
+
+	assert.Equal(t, http.StatusBadRequest, reset("unknown", "resetpassword"))
+	assert.Equal(t, http.StatusBadRequest, reset(token, "short"))
+	require.Equal(t, http.StatusOK, reset(token, "resetpassword"))
+	assert.Equal(t, http.StatusBadRequest, reset(token, "otherpassword"), "tokens are used once")
+	assert.Equal(t, http.StatusBadRequest, reset(older, "otherpassword"), "older tokens expire with the password change")
+
+	assert.Equal(t, http.StatusUnauthorized, serveJSON(router, http.MethodGet, "/me", aliceSession.Token, nil).Code, "sessions end")
+	assert.NotEmpty(t, loginAs("alice", "resetpassword"))
+
+	t.Run("expired token", func(t *testing.T) {
+		passwords := NewPasswordManager(store, auth.DefaultPasswordPolicy(), time.Hour)
+		user, err := store.GetUserByID("alice")
+		require.NoError(t, err)
+		token, _, err := passwords.IssueResetToken(user, "admin")
+		require.NoError(t, err)
+
+		passwords.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+		_, err = passwords.Reset(token, "laterpassword")
+		assert.Equal(t, errInvalidResetToken, err)
+	})
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/eliorerz/ovim-updated/pkg/authz"
+)
+
+// authorize checks that the authenticated user may perform the verb on the resource, responding
+// with 401, 403 or 500 otherwise. It returns false if the handler must stop.
+func authorize(c *gin.Context, verb authz.Verb, resource authz.Resource) bool {
+	return respondAuthzError(c, verb, authz.Authorize(c, verb, resource))
+}
+
+// authorizeList checks that the authenticated user may list with the verb and returns how far the
+// listing reaches. Below the global scope the user must belong to an organization, which the
+// handler filters on. It returns false if the handler must stop.
+func authorizeList(c *gin.Context, verb authz.Verb) (authz.Scope, bool) {
+	scope, err := authz.AuthorizeList(c, verb)
+	if !respondAuthzError(c, verb, err) {
+		return authz.ScopeNone, false
+	}
+	if subject, _ := authz.SubjectFromContext(c); scope != authz.ScopeGlobal && subject.OrgID == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "User not associated with any organization"})
+		return authz.ScopeNone, false
+	}
+	return scope, true
+}
+
+// respondAuthzError writes the response for an authorization error. It returns false if there
+// was one.
+func respondAuthzError(c *gin.Context, verb authz.Verb, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, authz.ErrUnauthenticated):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
+	case errors.Is(err, authz.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions", "verb": verb})
+	default:
+		klog.Errorf("Failed to check permission %s: %v", verb, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+	}
+	return false
+}
+
+// authorizeOwnOrganization checks that the user may perform the verb within its own organization,
+// for endpoints that do not act on the resources of a particular organization
+func authorizeOwnOrganization(c *gin.Context, verb authz.Verb) bool {
+	subject, _ := authz.SubjectFromContext(c)
+	return authorize(c, verb, authz.InOrganization(subject.OrgID))
+}
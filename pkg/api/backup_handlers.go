@@ -0,0 +1,97 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
+	"github.com/eliorerz/ovim-updated/pkg/backup"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// BackupHandlers handles exporting and importing the whole database as a snapshot
+type BackupHandlers struct {
+	storage storage.Storage
+}
+
+// NewBackupHandlers creates a new backup handlers instance
+func NewBackupHandlers(storage storage.Storage) *BackupHandlers {
+	return &BackupHandlers{
+		storage: storage,
+	}
+}
+
+// Export handles downloading a snapshot of every record, password hashes included
+func (h *BackupHandlers) Export(c *gin.Context) {
+	username, ok := h.authorizeBackup(c, authz.BackupExport)
+	if !ok {
+		return
+	}
+
+	snapshot, err := backup.Export(h.storage)
+	if err != nil {
+		klog.Errorf("Failed to export data: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+		return
+	}
+
+	klog.Infof("Data exported by user %s", username)
+	filename := fmt.Sprintf("ovim-export-%s.json", snapshot.ExportedAt.Format("20060102-150405"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.JSON(http.StatusOK, snapshot)
+}
+
+// Import handles restoring a snapshot. The on_conflict query parameter chooses what happens to
+// records that already exist: fail (the default), skip or overwrite.
+func (h *BackupHandlers) Import(c *gin.Context) {
+	username, ok := h.authorizeBackup(c, authz.BackupImport)
+	if !ok {
+		return
+	}
+
+	policy, err := backup.ParseConflictPolicy(c.Query("on_conflict"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var snapshot backup.Snapshot
+	if err := c.ShouldBindJSON(&snapshot); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid snapshot", "details": err.Error()})
+		return
+	}
+
+	start := time.Now()
+	result, err := backup.Import(h.storage, &snapshot, policy)
+	if err != nil {
+		switch {
+		case errors.Is(err, backup.ErrUnsupportedFormat), errors.Is(err, storage.ErrInvalidInput):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid snapshot", "details": err.Error()})
+		case errors.Is(err, storage.ErrAlreadyExists):
+			c.JSON(http.StatusConflict, gin.H{"error": "Snapshot conflicts with existing data", "details": err.Error()})
+		default:
+			klog.Errorf("Failed to import data: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import data"})
+		}
+		return
+	}
+
+	klog.Infof("Data imported by user %s with conflict policy %s in %s", username, policy, time.Since(start))
+	c.JSON(http.StatusOK, result)
+}
+
+// authorizeBackup checks that the user may perform the verb on the data of every organization, and
+// returns the name of the user
+func (h *BackupHandlers) authorizeBackup(c *gin.Context, verb authz.Verb) (string, bool) {
+	if !authorize(c, verb, authz.Global()) {
+		return "", false
+	}
+	_, username, _, _, _ := auth.GetUserFromContext(c)
+	return username, true
+}
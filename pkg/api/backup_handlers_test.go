@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/backup"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// setupBackupStorage returns a storage with an organization and a user of that organization
+func setupBackupStorage(t *testing.T) storage.Storage {
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+	require.NoError(t, store.CreateOrganization(&models.Organization{ID: "backup-org", Name: "Backup Org", CRName: "backup-org"}))
+	require.NoError(t, store.CreateUser(&models.User{ID: "backup-user", Username: "backup-user", Email: "backup@example.com", PasswordHash: "hash", Role: models.RoleOrgUser, OrgID: stringPtr("backup-org")}))
+	return store
+}
+
+func TestBackupHandlers_Export(t *testing.T) {
+	handlers := NewBackupHandlers(setupBackupStorage(t))
+
+	t.Run("system admin", func(t *testing.T) {
+		c, w := setupGinContext(http.MethodGet, "/admin/export", nil, "admin", "admin", models.RoleSystemAdmin, "")
+		handlers.Export(c)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Disposition"), "attachment; filename=\"ovim-export-")
+		var snapshot backup.Snapshot
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &snapshot))
+		assert.Equal(t, backup.FormatVersion, snapshot.FormatVersion)
+		require.Len(t, snapshot.Organizations, 1)
+		require.Len(t, snapshot.Users, 1)
+		assert.Equal(t, "hash", snapshot.Users[0].PasswordHash)
+	})
+
+	t.Run("org admin", func(t *testing.T) {
+		c, w := setupGinContext(http.MethodGet, "/admin/export", nil, "org-admin", "org-admin", models.RoleOrgAdmin, "backup-org")
+		handlers.Export(c)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestBackupHandlers_Import(t *testing.T) {
+	snapshot, err := backup.Export(setupBackupStorage(t))
+	require.NoError(t, err)
+
+	t.Run("empty target", func(t *testing.T) {
+		target, err := storage.NewMemoryStorageForTest()
+		require.NoError(t, err)
+		handlers := NewBackupHandlers(target)
+
+		c, w := setupGinContext(http.MethodPost, "/admin/import", snapshot, "admin", "admin", models.RoleSystemAdmin, "")
+		handlers.Import(c)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var result backup.Result
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, backup.Counts{Created: 1}, result.Organizations)
+		assert.Equal(t, backup.Counts{Created: 1}, result.Users)
+
+		user, err := target.GetUserByUsername("backup-user")
+		require.NoError(t, err)
+		assert.Equal(t, "hash", user.PasswordHash)
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		handlers := NewBackupHandlers(setupBackupStorage(t))
+
+		c, w := setupGinContext(http.MethodPost, "/admin/import", snapshot, "admin", "admin", models.RoleSystemAdmin, "")
+		handlers.Import(c)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		c, w = setupGinContext(http.MethodPost, "/admin/import?on_conflict=skip", snapshot, "admin", "admin", models.RoleSystemAdmin, "")
+		handlers.Import(c)
+		require.Equal(t, http.StatusOK, w.Code)
+		var result backup.Result
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, backup.Counts{Skipped: 1}, result.Organizations)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		handlers := NewBackupHandlers(setupBackupStorage(t))
+
+		c, w := setupGinContext(http.MethodPost, "/admin/import?on_conflict=merge", snapshot, "admin", "admin", models.RoleSystemAdmin, "")
+		handlers.Import(c)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		c, w = setupGinContext(http.MethodPost, "/admin/import", map[string]interface{}{"format_version": 99}, "admin", "admin", models.RoleSystemAdmin, "")
+		handlers.Import(c)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		c, w = setupGinContext(http.MethodPost, "/admin/import", snapshot, "user", "user", models.RoleOrgUser, "backup-org")
+		handlers.Import(c)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"k8s.io/klog/v2"
 
 	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
 	"github.com/eliorerz/ovim-updated/pkg/catalog"
 	"github.com/eliorerz/ovim-updated/pkg/models"
 	"github.com/eliorerz/ovim-updated/pkg/storage"
@@ -34,15 +35,13 @@ func (h *CatalogHandlers) ListTemplates(c *gin.Context) {
 	category := c.Query("category") // Operating System, Database, Application, etc.
 
 	// Get user info from context
-	_, _, role, userOrgID, ok := auth.GetUserFromContext(c)
+	_, _, _, userOrgID, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
 	}
 
-	// Check permissions based on role
-	if role != models.RoleSystemAdmin && role != models.RoleOrgAdmin && role != models.RoleOrgUser {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	if !authorize(c, authz.CatalogView, authz.InOrganization(userOrgID)) {
 		return
 	}
 
@@ -98,6 +97,17 @@ func (h *CatalogHandlers) GetTemplate(c *gin.Context) {
 		return
 	}
 
+	// Global templates are part of every organization's catalog
+	resource := authz.InOrganization(template.OrgID)
+	if template.OrgID == "" {
+		_, _, _, userOrgID, _ := auth.GetUserFromContext(c)
+		resource = authz.InOrganization(userOrgID)
+	}
+	if !authorize(c, authz.CatalogView, resource) {
+		return
+	}
+
+	setETag(c, template.ResourceVersion)
 	c.JSON(http.StatusOK, template)
 }
 
@@ -109,21 +119,10 @@ func (h *CatalogHandlers) ListTemplatesByOrg(c *gin.Context) {
 		return
 	}
 
-	// Get user info from context
-	_, _, role, userOrgID, ok := auth.GetUserFromContext(c)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
+	if !authorize(c, authz.CatalogView, authz.InOrganization(orgID)) {
 		return
 	}
 
-	// Check permissions - only system admin can access any org, others can only access their own
-	if role != models.RoleSystemAdmin {
-		if userOrgID == "" || userOrgID != orgID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Can only access templates for your own organization"})
-			return
-		}
-	}
-
 	templates, err := h.storage.ListTemplatesByOrg(orgID)
 	if err != nil {
 		klog.Errorf("Failed to list templates for organization %s: %v", orgID, err)
@@ -142,15 +141,13 @@ func (h *CatalogHandlers) ListTemplatesByOrg(c *gin.Context) {
 // GetCatalogSources handles retrieving available catalog sources for the user
 func (h *CatalogHandlers) GetCatalogSources(c *gin.Context) {
 	// Get user info from context
-	_, _, role, userOrgID, ok := auth.GetUserFromContext(c)
+	_, _, _, userOrgID, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
 	}
 
-	// Check permissions based on role
-	if role != models.RoleSystemAdmin && role != models.RoleOrgAdmin && role != models.RoleOrgUser {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	if !authorize(c, authz.CatalogView, authz.InOrganization(userOrgID)) {
 		return
 	}
 
@@ -182,21 +179,10 @@ func (h *CatalogHandlers) GetOrganizationCatalogSources(c *gin.Context) {
 		return
 	}
 
-	// Get user info from context
-	_, _, role, userOrgID, ok := auth.GetUserFromContext(c)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
+	if !authorize(c, authz.CatalogView, authz.InOrganization(orgID)) {
 		return
 	}
 
-	// Check permissions - only system admin can access any org, others can only access their own
-	if role != models.RoleSystemAdmin {
-		if userOrgID == "" || userOrgID != orgID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Can only access catalog sources for your own organization"})
-			return
-		}
-	}
-
 	sources, err := h.storage.ListOrganizationCatalogSources(orgID)
 	if err != nil {
 		klog.Errorf("Failed to list organization catalog sources for org %s: %v", orgID, err)
@@ -219,27 +205,10 @@ func (h *CatalogHandlers) AddCatalogSourceToOrganization(c *gin.Context) {
 		return
 	}
 
-	// Get user info from context
-	_, _, role, userOrgID, ok := auth.GetUserFromContext(c)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
-		return
-	}
-
-	// Check permissions - only system admin and org admin can manage catalog sources
-	if role != models.RoleSystemAdmin && role != models.RoleOrgAdmin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions to manage catalog sources"})
+	if !authorize(c, authz.CatalogManage, authz.InOrganization(orgID)) {
 		return
 	}
 
-	// For org admin, ensure they can only manage sources for their own organization
-	if role == models.RoleOrgAdmin {
-		if userOrgID == "" || userOrgID != orgID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Can only manage catalog sources for your own organization"})
-			return
-		}
-	}
-
 	var req models.CreateOrganizationCatalogSourceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
@@ -283,27 +252,10 @@ func (h *CatalogHandlers) UpdateOrganizationCatalogSource(c *gin.Context) {
 		return
 	}
 
-	// Get user info from context
-	_, _, role, userOrgID, ok := auth.GetUserFromContext(c)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
+	if !authorize(c, authz.CatalogManage, authz.InOrganization(orgID)) {
 		return
 	}
 
-	// Check permissions - only system admin and org admin can manage catalog sources
-	if role != models.RoleSystemAdmin && role != models.RoleOrgAdmin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions to manage catalog sources"})
-		return
-	}
-
-	// For org admin, ensure they can only manage sources for their own organization
-	if role == models.RoleOrgAdmin {
-		if userOrgID == "" || userOrgID != orgID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Can only manage catalog sources for your own organization"})
-			return
-		}
-	}
-
 	var req models.UpdateOrganizationCatalogSourceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
@@ -355,27 +307,10 @@ func (h *CatalogHandlers) RemoveOrganizationCatalogSource(c *gin.Context) {
 		return
 	}
 
-	// Get user info from context
-	_, _, role, userOrgID, ok := auth.GetUserFromContext(c)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
-		return
-	}
-
-	// Check permissions - only system admin and org admin can manage catalog sources
-	if role != models.RoleSystemAdmin && role != models.RoleOrgAdmin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions to manage catalog sources"})
+	if !authorize(c, authz.CatalogManage, authz.InOrganization(orgID)) {
 		return
 	}
 
-	// For org admin, ensure they can only manage sources for their own organization
-	if role == models.RoleOrgAdmin {
-		if userOrgID == "" || userOrgID != orgID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Can only manage catalog sources for your own organization"})
-			return
-		}
-	}
-
 	// Get existing catalog source to verify it belongs to the organization
 	source, err := h.storage.GetOrganizationCatalogSource(sourceID)
 	if err != nil {
@@ -412,21 +347,10 @@ func (h *CatalogHandlers) GetOrganizationCatalogTemplates(c *gin.Context) {
 		return
 	}
 
-	// Get user info from context
-	_, _, role, userOrgID, ok := auth.GetUserFromContext(c)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
+	if !authorize(c, authz.CatalogView, authz.InOrganization(orgID)) {
 		return
 	}
 
-	// Check permissions - only system admin can access any org, others can only access their own
-	if role != models.RoleSystemAdmin {
-		if userOrgID == "" || userOrgID != orgID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Can only access catalog templates for your own organization"})
-			return
-		}
-	}
-
 	// Get query parameters for filtering
 	sourceType := c.Query("source_type") // Filter by specific source type
 	category := c.Query("category")      // Operating System, Database, Application, etc.
@@ -479,3 +403,305 @@ func (h *CatalogHandlers) GetOrganizationCatalogTemplates(c *gin.Context) {
 		},
 	})
 }
+
+var (
+	catalogTypes       = []string{models.CatalogTypeVMTemplate, models.CatalogTypeApplicationStack, models.CatalogTypeMixed}
+	catalogSourceTypes = []string{models.CatalogSourceGit, models.CatalogSourceOCI, models.CatalogSourceS3, models.CatalogSourceHTTP, models.CatalogSourceLocal}
+)
+
+// checkCatalogAccess verifies that the user may read, or with manage set change, the catalogs of
+// an organization. It responds with 401 or 403 and returns false if the handler must stop.
+func checkCatalogAccess(c *gin.Context, orgID string, manage bool) bool {
+	verb := authz.CatalogView
+	if manage {
+		verb = authz.CatalogManage
+	}
+	return authorize(c, verb, authz.InOrganization(orgID))
+}
+
+// getOrganizationCatalog loads a catalog of the organization in the request path, responding with
+// 404 when it does not exist or belongs to another organization
+func (h *CatalogHandlers) getOrganizationCatalog(c *gin.Context, orgID, catalogID string) (*models.Catalog, bool) {
+	catalog, err := h.storage.GetCatalog(catalogID)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Catalog not found"})
+			return nil, false
+		}
+		klog.Errorf("Failed to get catalog %s: %v", catalogID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get catalog"})
+		return nil, false
+	}
+
+	if catalog.OrgID != orgID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Catalog not found"})
+		return nil, false
+	}
+	return catalog, true
+}
+
+// ListOrganizationCatalogs handles listing the catalogs of an organization
+func (h *CatalogHandlers) ListOrganizationCatalogs(c *gin.Context) {
+	orgID := c.Param("id")
+	if orgID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization ID required"})
+		return
+	}
+
+	if !checkCatalogAccess(c, orgID, false) {
+		return
+	}
+
+	catalogs, err := h.storage.ListCatalogs(orgID)
+	if err != nil {
+		klog.Errorf("Failed to list catalogs for org %s: %v", orgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list catalogs"})
+		return
+	}
+
+	klog.V(6).Infof("Retrieved %d catalogs for organization %s", len(catalogs), orgID)
+	c.JSON(http.StatusOK, gin.H{
+		"catalogs": catalogs,
+		"total":    len(catalogs),
+	})
+}
+
+// GetOrganizationCatalog handles getting a catalog of an organization
+func (h *CatalogHandlers) GetOrganizationCatalog(c *gin.Context) {
+	orgID := c.Param("id")
+	catalogID := c.Param("catalogId")
+	if orgID == "" || catalogID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization ID and catalog ID required"})
+		return
+	}
+
+	if !checkCatalogAccess(c, orgID, false) {
+		return
+	}
+
+	catalog, ok := h.getOrganizationCatalog(c, orgID, catalogID)
+	if !ok {
+		return
+	}
+
+	setETag(c, catalog.ResourceVersion)
+	c.JSON(http.StatusOK, catalog)
+}
+
+// CreateOrganizationCatalog handles adding a catalog to an organization
+func (h *CatalogHandlers) CreateOrganizationCatalog(c *gin.Context) {
+	orgID := c.Param("id")
+	if orgID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization ID required"})
+		return
+	}
+
+	if !checkCatalogAccess(c, orgID, true) {
+		return
+	}
+
+	var req models.CreateCatalogRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		klog.V(4).Infof("Invalid create catalog request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if req.OrgID != "" && req.OrgID != orgID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization ID does not match the request path"})
+		return
+	}
+	if !util.ContainsString(catalogTypes, req.Type) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid catalog type"})
+		return
+	}
+	if !util.ContainsString(catalogSourceTypes, req.SourceType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid catalog source type"})
+		return
+	}
+
+	org, err := h.storage.GetOrganization(orgID)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			return
+		}
+		klog.Errorf("Failed to get organization %s: %v", orgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get organization"})
+		return
+	}
+
+	generatedID, err := util.GenerateID(8)
+	if err != nil {
+		klog.Errorf("Failed to generate ID for catalog: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate ID"})
+		return
+	}
+
+	catalog := &models.Catalog{
+		ID:                    "catalog-" + generatedID,
+		Name:                  req.Name,
+		Description:           req.Description,
+		OrgID:                 orgID,
+		DisplayName:           &req.DisplayName,
+		CRName:                util.SanitizeKubernetesName(req.Name),
+		CRNamespace:           org.Namespace,
+		Type:                  req.Type,
+		SourceType:            req.SourceType,
+		SourceURL:             req.SourceURL,
+		SourceBranch:          req.SourceBranch,
+		SourcePath:            req.SourcePath,
+		InsecureSkipTLSVerify: req.InsecureSkipTLSVerify,
+		RefreshInterval:       req.RefreshInterval,
+		IncludePatterns:       models.JSONBArray(req.IncludePatterns),
+		ExcludePatterns:       models.JSONBArray(req.ExcludePatterns),
+		RequiredTags:          models.JSONBArray(req.RequiredTags),
+		AllowedVDCs:           models.JSONBArray(req.AllowedVDCs),
+		AllowedGroups:         models.JSONBArray(req.AllowedGroups),
+		ReadOnly:              req.ReadOnly,
+		IsEnabled:             req.IsEnabled,
+		Phase:                 models.CatalogPhasePending,
+	}
+	if req.SourceCredentials != "" {
+		catalog.SourceCredentials = &req.SourceCredentials
+	}
+
+	if err := h.storage.CreateCatalog(catalog); err != nil {
+		if err == storage.ErrAlreadyExists {
+			c.JSON(http.StatusConflict, gin.H{"error": "A catalog with this name already exists in the organization"})
+			return
+		}
+		klog.Errorf("Failed to create catalog for org %s: %v", orgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create catalog"})
+		return
+	}
+
+	klog.Infof("Created catalog %s (%s) for organization %s", catalog.ID, catalog.Name, orgID)
+	setETag(c, catalog.ResourceVersion)
+	c.JSON(http.StatusCreated, catalog)
+}
+
+// UpdateOrganizationCatalog handles updating a catalog of an organization
+func (h *CatalogHandlers) UpdateOrganizationCatalog(c *gin.Context) {
+	orgID := c.Param("id")
+	catalogID := c.Param("catalogId")
+	if orgID == "" || catalogID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization ID and catalog ID required"})
+		return
+	}
+
+	if !checkCatalogAccess(c, orgID, true) {
+		return
+	}
+
+	var req models.UpdateCatalogRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		klog.V(4).Infof("Invalid update catalog request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	catalog, ok := h.getOrganizationCatalog(c, orgID, catalogID)
+	if !ok {
+		return
+	}
+	if !checkIfMatch(c, catalog.ResourceVersion) {
+		return
+	}
+
+	// Update fields if provided
+	if req.DisplayName != nil {
+		catalog.DisplayName = req.DisplayName
+	}
+	if req.Description != nil {
+		catalog.Description = *req.Description
+	}
+	if req.SourceURL != nil {
+		catalog.SourceURL = *req.SourceURL
+	}
+	if req.SourceBranch != nil {
+		catalog.SourceBranch = *req.SourceBranch
+	}
+	if req.SourcePath != nil {
+		catalog.SourcePath = *req.SourcePath
+	}
+	if req.SourceCredentials != nil {
+		catalog.SourceCredentials = req.SourceCredentials
+	}
+	if req.InsecureSkipTLSVerify != nil {
+		catalog.InsecureSkipTLSVerify = *req.InsecureSkipTLSVerify
+	}
+	if req.RefreshInterval != nil {
+		catalog.RefreshInterval = *req.RefreshInterval
+	}
+	if req.IncludePatterns != nil {
+		catalog.IncludePatterns = models.JSONBArray(req.IncludePatterns)
+	}
+	if req.ExcludePatterns != nil {
+		catalog.ExcludePatterns = models.JSONBArray(req.ExcludePatterns)
+	}
+	if req.RequiredTags != nil {
+		catalog.RequiredTags = models.JSONBArray(req.RequiredTags)
+	}
+	if req.AllowedVDCs != nil {
+		catalog.AllowedVDCs = models.JSONBArray(req.AllowedVDCs)
+	}
+	if req.AllowedGroups != nil {
+		catalog.AllowedGroups = models.JSONBArray(req.AllowedGroups)
+	}
+	if req.ReadOnly != nil {
+		catalog.ReadOnly = *req.ReadOnly
+	}
+	if req.IsEnabled != nil {
+		catalog.IsEnabled = *req.IsEnabled
+	}
+
+	if err := h.storage.UpdateCatalog(catalog); err != nil {
+		if err == storage.ErrConflict {
+			respondConflict(c, "Catalog was modified concurrently, reload it and retry")
+			return
+		}
+		klog.Errorf("Failed to update catalog %s: %v", catalogID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update catalog"})
+		return
+	}
+
+	klog.Infof("Updated catalog %s for organization %s", catalogID, orgID)
+	setETag(c, catalog.ResourceVersion)
+	c.JSON(http.StatusOK, catalog)
+}
+
+// DeleteOrganizationCatalog handles removing a catalog from an organization. Its templates are
+// kept and no longer reference the catalog.
+func (h *CatalogHandlers) DeleteOrganizationCatalog(c *gin.Context) {
+	orgID := c.Param("id")
+	catalogID := c.Param("catalogId")
+	if orgID == "" || catalogID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization ID and catalog ID required"})
+		return
+	}
+
+	if !checkCatalogAccess(c, orgID, true) {
+		return
+	}
+
+	catalog, ok := h.getOrganizationCatalog(c, orgID, catalogID)
+	if !ok {
+		return
+	}
+	if !checkIfMatch(c, catalog.ResourceVersion) {
+		return
+	}
+
+	if err := h.storage.DeleteCatalog(catalogID); err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Catalog not found"})
+			return
+		}
+		klog.Errorf("Failed to delete catalog %s: %v", catalogID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete catalog"})
+		return
+	}
+
+	klog.Infof("Deleted catalog %s from organization %s", catalogID, orgID)
+	c.JSON(http.StatusNoContent, nil)
+}
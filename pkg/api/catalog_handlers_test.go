@@ -13,6 +13,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
 )
 
 // MockCatalogService implements catalog.Provider interface for testing
@@ -480,5 +481,222 @@ func TestNewCatalogHandlers(t *testing.T) {
 	assert.Equal(t, mockCatalogService, handlers.catalogService)
 }
 
+// setupCatalogStorage returns a storage with two organizations and a catalog of org-1
+func setupCatalogStorage(t *testing.T) storage.Storage {
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+	require.NoError(t, store.CreateOrganization(&models.Organization{ID: "org-1", Name: "Org 1", Namespace: "org-org-1"}))
+	require.NoError(t, store.CreateOrganization(&models.Organization{ID: "org-2", Name: "Org 2", Namespace: "org-org-2"}))
+	require.NoError(t, store.CreateCatalog(&models.Catalog{
+		ID:         "catalog-1",
+		Name:       "golden-images",
+		OrgID:      "org-1",
+		CRName:     "golden-images",
+		Type:       models.CatalogTypeVMTemplate,
+		SourceType: models.CatalogSourceGit,
+		SourceURL:  "https://git.example.com/golden-images.git",
+		IsEnabled:  true,
+	}))
+	return store
+}
+
+func TestCatalogHandlers_ListOrganizationCatalogs(t *testing.T) {
+	store := setupCatalogStorage(t)
+	handlers := NewCatalogHandlers(store, nil)
+
+	tests := []struct {
+		name           string
+		role           string
+		userOrgID      string
+		orgID          string
+		expectedStatus int
+		expectedTotal  int
+	}{
+		{"system admin", models.RoleSystemAdmin, "", "org-1", http.StatusOK, 1},
+		{"org user of the organization", models.RoleOrgUser, "org-1", "org-1", http.StatusOK, 1},
+		{"organization without catalogs", models.RoleSystemAdmin, "", "org-2", http.StatusOK, 0},
+		{"org admin of another organization", models.RoleOrgAdmin, "org-2", "org-1", http.StatusForbidden, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, w := setupGinContext(http.MethodGet, "/organizations/"+tt.orgID+"/catalogs", nil, "user-1", "user", tt.role, tt.userOrgID)
+			c.Params = gin.Params{{Key: "id", Value: tt.orgID}}
+			handlers.ListOrganizationCatalogs(c)
+
+			require.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusOK {
+				var response struct {
+					Catalogs []models.Catalog `json:"catalogs"`
+					Total    int              `json:"total"`
+				}
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, tt.expectedTotal, response.Total)
+				assert.NotNil(t, response.Catalogs)
+			}
+		})
+	}
+}
+
+func TestCatalogHandlers_GetOrganizationCatalog(t *testing.T) {
+	store := setupCatalogStorage(t)
+	handlers := NewCatalogHandlers(store, nil)
+
+	t.Run("existing catalog", func(t *testing.T) {
+		c, w := setupGinContext(http.MethodGet, "/organizations/org-1/catalogs/catalog-1", nil, "admin", "admin", models.RoleSystemAdmin, "")
+		c.Params = gin.Params{{Key: "id", Value: "org-1"}, {Key: "catalogId", Value: "catalog-1"}}
+		handlers.GetOrganizationCatalog(c)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+		var catalog models.Catalog
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &catalog))
+		assert.Equal(t, "golden-images", catalog.Name)
+	})
+
+	t.Run("catalog of another organization", func(t *testing.T) {
+		c, w := setupGinContext(http.MethodGet, "/organizations/org-2/catalogs/catalog-1", nil, "admin", "admin", models.RoleSystemAdmin, "")
+		c.Params = gin.Params{{Key: "id", Value: "org-2"}, {Key: "catalogId", Value: "catalog-1"}}
+		handlers.GetOrganizationCatalog(c)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestCatalogHandlers_CreateOrganizationCatalog(t *testing.T) {
+	request := models.CreateCatalogRequest{
+		Name:        "App Stacks",
+		DisplayName: "Application stacks",
+		Type:        models.CatalogTypeApplicationStack,
+		SourceType:  models.CatalogSourceOCI,
+		SourceURL:   "oci://registry.example.com/stacks",
+		IsEnabled:   true,
+	}
+
+	t.Run("successful creation", func(t *testing.T) {
+		store := setupCatalogStorage(t)
+		handlers := NewCatalogHandlers(store, nil)
+
+		c, w := setupGinContext(http.MethodPost, "/organizations/org-1/catalogs", request, "orgadmin", "orgadmin", models.RoleOrgAdmin, "org-1")
+		c.Params = gin.Params{{Key: "id", Value: "org-1"}}
+		handlers.CreateOrganizationCatalog(c)
+
+		require.Equal(t, http.StatusCreated, w.Code)
+		var created models.Catalog
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+		assert.Equal(t, "org-1", created.OrgID)
+		assert.Equal(t, "app-stacks", created.CRName)
+		assert.Equal(t, "org-org-1", created.CRNamespace)
+		assert.Equal(t, models.CatalogPhasePending, created.Phase)
+
+		stored, err := store.GetCatalog(created.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "oci://registry.example.com/stacks", stored.SourceURL)
+	})
+
+	t.Run("duplicate name", func(t *testing.T) {
+		store := setupCatalogStorage(t)
+		handlers := NewCatalogHandlers(store, nil)
+		duplicate := request
+		duplicate.Name = "golden-images"
+
+		c, w := setupGinContext(http.MethodPost, "/organizations/org-1/catalogs", duplicate, "admin", "admin", models.RoleSystemAdmin, "")
+		c.Params = gin.Params{{Key: "id", Value: "org-1"}}
+		handlers.CreateOrganizationCatalog(c)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("invalid source type", func(t *testing.T) {
+		store := setupCatalogStorage(t)
+		handlers := NewCatalogHandlers(store, nil)
+		invalid := request
+		invalid.SourceType = "ftp"
+
+		c, w := setupGinContext(http.MethodPost, "/organizations/org-1/catalogs", invalid, "admin", "admin", models.RoleSystemAdmin, "")
+		c.Params = gin.Params{{Key: "id", Value: "org-1"}}
+		handlers.CreateOrganizationCatalog(c)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("unknown organization", func(t *testing.T) {
+		store := setupCatalogStorage(t)
+		handlers := NewCatalogHandlers(store, nil)
+
+		c, w := setupGinContext(http.MethodPost, "/organizations/missing/catalogs", request, "admin", "admin", models.RoleSystemAdmin, "")
+		c.Params = gin.Params{{Key: "id", Value: "missing"}}
+		handlers.CreateOrganizationCatalog(c)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("org user", func(t *testing.T) {
+		store := setupCatalogStorage(t)
+		handlers := NewCatalogHandlers(store, nil)
+
+		c, w := setupGinContext(http.MethodPost, "/organizations/org-1/catalogs", request, "user", "user", models.RoleOrgUser, "org-1")
+		c.Params = gin.Params{{Key: "id", Value: "org-1"}}
+		handlers.CreateOrganizationCatalog(c)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestCatalogHandlers_UpdateOrganizationCatalog(t *testing.T) {
+	disable := false
+	request := models.UpdateCatalogRequest{IsEnabled: &disable, IncludePatterns: []string{"rhel-*"}}
+
+	t.Run("successful update", func(t *testing.T) {
+		store := setupCatalogStorage(t)
+		handlers := NewCatalogHandlers(store, nil)
+
+		c, w := setupGinContext(http.MethodPut, "/organizations/org-1/catalogs/catalog-1", request, "admin", "admin", models.RoleSystemAdmin, "")
+		c.Request.Header.Set("If-Match", `"1"`)
+		c.Params = gin.Params{{Key: "id", Value: "org-1"}, {Key: "catalogId", Value: "catalog-1"}}
+		handlers.UpdateOrganizationCatalog(c)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+		stored, err := store.GetCatalog("catalog-1")
+		require.NoError(t, err)
+		assert.False(t, stored.IsEnabled)
+		assert.Equal(t, models.JSONBArray{"rhel-*"}, stored.IncludePatterns)
+	})
+
+	t.Run("stale If-Match", func(t *testing.T) {
+		store := setupCatalogStorage(t)
+		handlers := NewCatalogHandlers(store, nil)
+
+		c, w := setupGinContext(http.MethodPut, "/organizations/org-1/catalogs/catalog-1", request, "admin", "admin", models.RoleSystemAdmin, "")
+		c.Request.Header.Set("If-Match", `"7"`)
+		c.Params = gin.Params{{Key: "id", Value: "org-1"}, {Key: "catalogId", Value: "catalog-1"}}
+		handlers.UpdateOrganizationCatalog(c)
+
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		stored, err := store.GetCatalog("catalog-1")
+		require.NoError(t, err)
+		assert.True(t, stored.IsEnabled)
+	})
+}
+
+func TestCatalogHandlers_DeleteOrganizationCatalog(t *testing.T) {
+	store := setupCatalogStorage(t)
+	handlers := NewCatalogHandlers(store, nil)
+	require.NoError(t, store.CreateTemplate(&models.Template{ID: "template-1", OrgID: "org-1", CatalogID: stringPtr("catalog-1")}))
+
+	c, w := setupGinContext(http.MethodDelete, "/organizations/org-1/catalogs/catalog-1", nil, "orgadmin", "orgadmin", models.RoleOrgAdmin, "org-1")
+	c.Params = gin.Params{{Key: "id", Value: "org-1"}, {Key: "catalogId", Value: "catalog-1"}}
+	handlers.DeleteOrganizationCatalog(c)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	_, err := store.GetCatalog("catalog-1")
+	assert.Equal(t, storage.ErrNotFound, err)
+
+	template, err := store.GetTemplate("template-1")
+	require.NoError(t, err)
+	assert.Nil(t, template.CatalogID, "templates outlive their catalog")
+}
+
 // Helper function to create error for not found cases
 var ErrNotFound = assert.AnError
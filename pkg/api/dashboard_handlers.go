@@ -13,6 +13,7 @@ import (
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/eliorerz/ovim-updated/pkg/authz"
 	"github.com/eliorerz/ovim-updated/pkg/models"
 	"github.com/eliorerz/ovim-updated/pkg/storage"
 )
@@ -51,6 +52,10 @@ type DashboardSummary struct {
 
 // GetSummary handles GET /dashboard/summary
 func (h *DashboardHandlers) GetSummary(c *gin.Context) {
+	if !authorizeOwnOrganization(c, authz.DashboardView) {
+		return
+	}
+
 	summary, err := h.buildDashboardSummary()
 	if err != nil {
 		klog.Errorf("Failed to build dashboard summary: %v", err)
@@ -161,6 +166,10 @@ type SystemHealthDetails struct {
 
 // GetSystemHealth handles GET /dashboard/system-health
 func (h *DashboardHandlers) GetSystemHealth(c *gin.Context) {
+	if !authorizeOwnOrganization(c, authz.DashboardView) {
+		return
+	}
+
 	health, err := h.buildSystemHealth()
 	if err != nil {
 		klog.Errorf("Failed to build system health: %v", err)
@@ -432,6 +441,10 @@ type TopConsumer struct {
 
 // GetSystemResources handles GET /dashboard/resources
 func (h *DashboardHandlers) GetSystemResources(c *gin.Context) {
+	if !authorizeOwnOrganization(c, authz.DashboardView) {
+		return
+	}
+
 	summary, err := h.buildSystemResourceSummary()
 	if err != nil {
 		klog.Errorf("Failed to build system resource summary: %v", err)
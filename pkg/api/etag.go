@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// formatETag renders a resource version as a strong entity tag
+func formatETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// setETag sets the ETag header of the response to the given resource version
+func setETag(c *gin.Context, version int64) {
+	c.Header("ETag", formatETag(version))
+}
+
+// ifMatchSatisfied reports whether an If-Match header value matches the resource version.
+// Weak entity tags never match, as If-Match requires the strong comparison.
+func ifMatchSatisfied(header string, version int64) bool {
+	current := formatETag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch evaluates the If-Match precondition of the request against the current resource
+// version, responding with 412 when it fails. It returns false if the handler must stop.
+func checkIfMatch(c *gin.Context, version int64) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" || ifMatchSatisfied(header, version) {
+		return true
+	}
+
+	setETag(c, version)
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Resource has been modified, reload it and retry"})
+	return false
+}
+
+// ifMatchTx is checkIfMatch for use in a transaction, it aborts the transaction with 412 when the
+// precondition fails
+func ifMatchTx(c *gin.Context, version int64) error {
+	header := c.GetHeader("If-Match")
+	if header == "" || ifMatchSatisfied(header, version) {
+		return nil
+	}
+
+	setETag(c, version)
+	return abortTx(http.StatusPreconditionFailed, gin.H{"error": "Resource has been modified, reload it and retry"})
+}
+
+// respondConflict writes the response for an update rejected with storage.ErrConflict because the
+// resource changed after it was read
+func respondConflict(c *gin.Context, message string) {
+	c.JSON(conflictStatus(c), gin.H{"error": message})
+}
+
+// conflictStatus is the status of a request whose update was rejected with storage.ErrConflict.
+// Conditional requests get 412, others 409.
+func conflictStatus(c *gin.Context) int {
+	if c.GetHeader("If-Match") != "" {
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusConflict
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+func TestIfMatchSatisfied(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{`"3"`, true},
+		{`"2"`, false},
+		{`*`, true},
+		{`"1", "3"`, true},
+		{`"1","2"`, false},
+		{`W/"3"`, false},
+		{`3`, false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ifMatchSatisfied(tt.header, 3), "If-Match: %s", tt.header)
+	}
+}
+
+func TestUserHandlers_Get_SetsETag(t *testing.T) {
+	mockStorage := new(MockStorage)
+	mockStorage.On("GetUserByID", "user1").Return(&models.User{ID: "user1", ResourceVersion: 4}, nil)
+
+	c, w := setupGinContext(http.MethodGet, "/users/user1", nil, "admin", "admin", models.RoleSystemAdmin, "")
+	c.Params = gin.Params{{Key: "id", Value: "user1"}}
+
+	NewUserHandlers(mockStorage).Get(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+	mockStorage.AssertExpectations(t)
+}
+
+func TestUserHandlers_Update_IfMatch(t *testing.T) {
+	stored := func() *models.User {
+		return &models.User{ID: "user1", Username: "user1", Role: models.RoleSystemAdmin, ResourceVersion: 2}
+	}
+
+	tests := []struct {
+		name           string
+		ifMatch        string
+		updateErr      error
+		expectUpdate   bool
+		expectedStatus int
+		expectedETag   string
+	}{
+		{
+			name:           "matching version",
+			ifMatch:        `"2"`,
+			expectUpdate:   true,
+			expectedStatus: http.StatusOK,
+			expectedETag:   `"3"`,
+		},
+		{
+			name:           "stale version",
+			ifMatch:        `"1"`,
+			expectedStatus: http.StatusPreconditionFailed,
+			expectedETag:   `"2"`,
+		},
+		{
+			name:           "concurrent update with If-Match",
+			ifMatch:        `"2"`,
+			updateErr:      storage.ErrConflict,
+			expectUpdate:   true,
+			expectedStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "concurrent update without If-Match",
+			updateErr:      storage.ErrConflict,
+			expectUpdate:   true,
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(MockStorage)
+			mockStorage.On("GetUserByID", "user1").Return(stored(), nil)
+			if tt.expectUpdate {
+				mockStorage.On("UpdateUser", mock.MatchedBy(func(user *models.User) bool {
+					return user.ResourceVersion == 2
+				})).Run(func(args mock.Arguments) {
+					if tt.updateErr == nil {
+						args.Get(0).(*models.User).ResourceVersion = 3
+					}
+				}).Return(tt.updateErr)
+			}
+
+			c, w := setupGinContext(http.MethodPut, "/users/user1", UpdateUserRequest{Email: "new@example.com"}, "admin", "admin", models.RoleSystemAdmin, "")
+			c.Params = gin.Params{{Key: "id", Value: "user1"}}
+			if tt.ifMatch != "" {
+				c.Request.Header.Set("If-Match", tt.ifMatch)
+			}
+
+			NewUserHandlers(mockStorage).Update(c)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedETag != "" {
+				assert.Equal(t, tt.expectedETag, w.Header().Get("ETag"))
+			}
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestVMHandlers_Delete_StaleIfMatch(t *testing.T) {
+	mockStorage := new(MockStorage)
+	mockStorage.On("GetVM", "vm1").Return(&models.VirtualMachine{ID: "vm1", OrgID: "org1", ResourceVersion: 5}, nil)
+
+	c, w := setupGinContext(http.MethodDelete, "/vms/vm1", nil, "admin", "admin", models.RoleSystemAdmin, "")
+	c.Params = gin.Params{{Key: "id", Value: "vm1"}}
+	c.Request.Header.Set("If-Match", `"4"`)
+
+	NewVMHandlers(mockStorage, nil, nil, nil).Delete(c)
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, `"5"`, w.Header().Get("ETag"))
+	mockStorage.AssertNotCalled(t, "DeleteVM", mock.Anything)
+}
+
+func TestOrganizationHandlers_Delete_StaleIfMatch(t *testing.T) {
+	mockStorage := new(MockStorage)
+	mockStorage.On("GetOrganization", "org1").Return(&models.Organization{ID: "org1", ResourceVersion: 2}, nil)
+	mockK8sClient := &MockK8sClient{}
+	mockK8sClient.On("Get", mock.Anything, mock.AnythingOfType("types.NamespacedName"), mock.AnythingOfType("*v1.Organization"), mock.Anything).Return(nil)
+
+	c, w := setupGinContext(http.MethodDelete, "/organizations/org1", nil, "admin", "admin", models.RoleSystemAdmin, "")
+	c.Params = gin.Params{{Key: "id", Value: "org1"}}
+	c.Request.Header.Set("If-Match", `"1"`)
+
+	NewOrganizationHandlers(mockStorage, mockK8sClient, nil).Delete(c)
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	mockStorage.AssertNotCalled(t, "ListVDCs", mock.Anything)
+	mockK8sClient.AssertNotCalled(t, "Update", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -2,9 +2,15 @@ package api
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ovimv1 "github.com/eliorerz/ovim-updated/pkg/api/v1"
 )
 
 // EventRecorder wraps the Kubernetes event recorder
@@ -58,3 +64,36 @@ func (er *EventRecorder) RecordVDCUpdated(ctx context.Context, vdcID string, org
 func (er *EventRecorder) RecordVDCDeleted(ctx context.Context, vdcID string, orgID string, username string) {
 	// For API events, we just log since we don't have a client.Object
 }
+
+// User event recording methods. Users are not Kubernetes objects, so their events are recorded on
+// the Organization of the user, and users without one have their events logged only.
+
+// RecordUserLockedOut records that failed logins locked an account out
+func (er *EventRecorder) RecordUserLockedOut(ctx context.Context, username string, orgID string, until time.Time) {
+	er.recordUserEvent(ctx, orgID, corev1.EventTypeWarning, "UserLockedOut",
+		fmt.Sprintf("User %s locked out after failed logins until %s", username, until.UTC().Format(time.RFC3339)))
+}
+
+// RecordUserUnlocked records that an administrator lifted the lockout of an account
+func (er *EventRecorder) RecordUserUnlocked(ctx context.Context, username string, orgID string, unlockedBy string) {
+	er.recordUserEvent(ctx, orgID, corev1.EventTypeNormal, "UserUnlocked",
+		fmt.Sprintf("User %s unlocked by %s", username, unlockedBy))
+}
+
+// RecordUserImpersonated records that an administrator started acting as a user
+func (er *EventRecorder) RecordUserImpersonated(ctx context.Context, username string, orgID string, impersonator string, reason string) {
+	er.recordUserEvent(ctx, orgID, corev1.EventTypeWarning, "UserImpersonated",
+		fmt.Sprintf("User %s impersonated by %s: %s", username, impersonator, reason))
+}
+
+func (er *EventRecorder) recordUserEvent(ctx context.Context, orgID string, eventType, reason, message string) {
+	if orgID == "" || er.k8sClient == nil {
+		return
+	}
+	org := &ovimv1.Organization{}
+	if err := er.k8sClient.Get(ctx, client.ObjectKey{Name: orgID}, org); err != nil {
+		klog.V(4).Infof("Failed to get organization %s to record %s event: %v", orgID, reason, err)
+		return
+	}
+	er.Record(org, eventType, reason, message)
+}
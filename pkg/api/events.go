@@ -12,6 +12,8 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/eliorerz/ovim-updated/pkg/authz"
 )
 
 // EventsHandlers handles Kubernetes events API operations
@@ -60,6 +62,10 @@ type RecentEventsResponse struct {
 
 // GetEvents handles GET /api/v1/events
 func (h *EventsHandlers) GetEvents(c *gin.Context) {
+	if !authorizeOwnOrganization(c, authz.EventList) {
+		return
+	}
+
 	if h.k8sClientset == nil {
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Kubernetes client not available"})
 		return
@@ -150,6 +156,10 @@ func (h *EventsHandlers) GetEvents(c *gin.Context) {
 
 // GetRecentEvents handles GET /api/v1/events/recent
 func (h *EventsHandlers) GetRecentEvents(c *gin.Context) {
+	if !authorizeOwnOrganization(c, authz.EventList) {
+		return
+	}
+
 	if h.k8sClientset == nil {
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Kubernetes client not available"})
 		return
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+	"github.com/eliorerz/ovim-updated/pkg/util"
+)
+
+const (
+	// impersonatedByAnnotation names the administrator who created, updated or deleted a
+	// resource while impersonating the user of the ovim.io/*-by annotations
+	impersonatedByAnnotation = "ovim.io/impersonated-by"
+
+	// maxImpersonationReasonLength is the longest reason an impersonation may have
+	maxImpersonationReasonLength = 512
+)
+
+// ImpersonateRequest represents the request body for impersonating a user
+type ImpersonateRequest struct {
+	// Reason tells the user why the administrator acted as the user, such as a support ticket
+	Reason string `json:"reason" binding:"required"`
+}
+
+// ImpersonateResponse returns the token acting as the impersonated user
+type ImpersonateResponse struct {
+	Token         string                `json:"token"`
+	ExpiresAt     time.Time             `json:"expires_at"`
+	Impersonation *models.Impersonation `json:"impersonation"`
+}
+
+// SetImpersonation enables impersonation with tokens of the token manager lasting duration
+func (h *UserHandlers) SetImpersonation(tokenManager *auth.TokenManager, duration time.Duration) {
+	h.tokenManager = tokenManager
+	h.impersonationDuration = duration
+}
+
+// SetEventRecorder sets the event recorder telling users they were impersonated
+func (h *UserHandlers) SetEventRecorder(recorder *EventRecorder) {
+	h.eventRecorder = recorder
+}
+
+// Impersonate handles issuing a short-lived token letting the caller act as another user, to see
+// the API as the user does. The token names the caller in its act claim, every request made with
+// it is logged as impersonated, and the user can list the impersonations.
+func (h *UserHandlers) Impersonate(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID required"})
+		return
+	}
+
+	if h.tokenManager == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Impersonation is not enabled"})
+		return
+	}
+
+	var req ImpersonateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+		return
+	}
+	if len(req.Reason) > maxImpersonationReasonLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reason is too long"})
+		return
+	}
+
+	callerID, callerUsername, _, _, ok := auth.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	if !interactiveLogin(c) {
+		return
+	}
+	if callerID == id {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot impersonate yourself"})
+		return
+	}
+
+	user, err := h.storage.GetUserByID(id)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		klog.Errorf("Failed to get user %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	if !authorize(c, authz.UserImpersonate, userResource(user)) {
+		return
+	}
+	if !h.checkImpersonationGrant(c, user) {
+		return
+	}
+
+	impersonationID, err := util.GenerateID(16)
+	if err != nil {
+		klog.Errorf("Failed to generate ID for impersonation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to impersonate user"})
+		return
+	}
+	claims := &auth.Claims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		OrgID:    util.StringValue(user.OrgID),
+	}
+	actor := &auth.Actor{Subject: callerID, Username: callerUsername, ImpersonationID: impersonationID}
+	token, err := h.tokenManager.GenerateImpersonationToken(claims, actor, h.impersonationDuration)
+	if err != nil {
+		klog.Errorf("Failed to generate impersonation token for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to impersonate user"})
+		return
+	}
+
+	impersonation := &models.Impersonation{
+		ID:                   impersonationID,
+		UserID:               user.ID,
+		ImpersonatorID:       callerID,
+		ImpersonatorUsername: callerUsername,
+		Reason:               req.Reason,
+		TokenID:              claims.ID,
+		ExpiresAt:            claims.ExpiresAt.Time,
+	}
+	if err := h.storage.CreateImpersonation(impersonation); err != nil {
+		klog.Errorf("Failed to store impersonation of user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to impersonate user"})
+		return
+	}
+
+	klog.Infof("User %s started impersonating %s (%s) until %s: %s", callerUsername, user.Username, impersonationID,
+		impersonation.ExpiresAt.UTC().Format(time.RFC3339), req.Reason)
+	if h.eventRecorder != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		h.eventRecorder.RecordUserImpersonated(ctx, user.Username, util.StringValue(user.OrgID), callerUsername, req.Reason)
+	}
+
+	c.JSON(http.StatusCreated, &ImpersonateResponse{
+		Token:         token,
+		ExpiresAt:     impersonation.ExpiresAt,
+		Impersonation: impersonation,
+	})
+}
+
+// ListImpersonations handles listing the impersonations of a user
+func (h *UserHandlers) ListImpersonations(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID required"})
+		return
+	}
+
+	user, err := h.storage.GetUserByID(id)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		klog.Errorf("Failed to get user %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	if !authorize(c, authz.UserGet, userResource(user)) {
+		return
+	}
+
+	h.respondImpersonations(c, user.ID)
+}
+
+// ListProfileImpersonations handles listing the impersonations of the current user, so that
+// users see who acted as them, when and why
+func (h *UserHandlers) ListProfileImpersonations(c *gin.Context) {
+	userID, _, _, _, ok := auth.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	h.respondImpersonations(c, userID)
+}
+
+func (h *UserHandlers) respondImpersonations(c *gin.Context, userID string) {
+	impersonations, err := h.storage.ListImpersonations(userID)
+	if err != nil {
+		klog.Errorf("Failed to list impersonations of user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list impersonations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"impersonations": impersonations,
+		"total":          len(impersonations),
+	})
+}
+
+// checkImpersonationGrant verifies that the caller holds every permission of the user, so that
+// impersonation cannot escalate privileges. It responds and returns false if the handler must
+// stop.
+func (h *UserHandlers) checkImpersonationGrant(c *gin.Context, user *models.User) bool {
+	authorizer := authz.FromContext(c)
+	caller, err := authorizer.Permissions(c)
+	if err != nil {
+		return respondAuthzError(c, authz.UserImpersonate, err)
+	}
+	granted, err := authorizer.PermissionsFor(authz.Subject{Role: user.Role, OrgID: util.StringValue(user.OrgID)})
+	if err != nil {
+		return respondAuthzError(c, authz.UserImpersonate, err)
+	}
+	if !caller.Covers(granted) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot impersonate a user with permissions you do not hold"})
+		return false
+	}
+	return true
+}
+
+// interactiveLogin stops API tokens, service accounts and impersonation tokens from starting an
+// impersonation, which needs an administrator who logged in
+func interactiveLogin(c *gin.Context) bool {
+	_, apiToken := auth.GetAPITokenIDFromContext(c)
+	_, serviceAccount := auth.GetServiceAccountFromContext(c)
+	_, impersonating := auth.GetImpersonatorFromContext(c)
+	if apiToken || serviceAccount || impersonating {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Impersonation requires an interactive login"})
+		return false
+	}
+	return true
+}
+
+// actingUsername returns the username of the request, naming the impersonator of impersonated
+// requests so that logs and events tell who acted
+func actingUsername(c *gin.Context) string {
+	_, username, _, _, _ := auth.GetUserFromContext(c)
+	if actor, ok := auth.GetImpersonatorFromContext(c); ok {
+		return fmt.Sprintf("%s (impersonated by %s)", username, actor.Username)
+	}
+	return username
+}
+
+// annotateImpersonator records the impersonator of the request in the annotations of a resource
+// it changes, and removes the one of a previous impersonated change otherwise
+func annotateImpersonator(c *gin.Context, annotations map[string]string) {
+	if actor, ok := auth.GetImpersonatorFromContext(c); ok {
+		annotations[impersonatedByAnnotation] = actor.Username
+		return
+	}
+	delete(annotations, impersonatedByAnnotation)
+}
@@ -0,0 +1,474 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+	"github.com/eliorerz/ovim-updated/pkg/util"
+)
+
+// Statuses of invitations
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationRevoked  = "revoked"
+	InvitationExpired  = "expired"
+)
+
+// InviteRequest represents the request body for inviting a person to an organization
+type InviteRequest struct {
+	Email string `json:"email" binding:"required"`
+	Role  string `json:"role" binding:"required"`
+}
+
+// InvitationResponse is an invitation with its status
+type InvitationResponse struct {
+	*models.Invitation
+	Status string `json:"status"`
+}
+
+// AcceptInvitationRequest accepts an invitation either by creating a local account with a
+// username and password, or by logging in with OIDC with the code of the authorization
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	OIDCCode string `json:"oidc_code"`
+}
+
+// invitationStatus returns the status of an invitation at a given time
+func invitationStatus(invitation *models.Invitation, now time.Time) string {
+	switch {
+	case invitation.AcceptedAt != nil:
+		return InvitationAccepted
+	case invitation.RevokedAt != nil:
+		return InvitationRevoked
+	case !invitation.ExpiresAt.After(now):
+		return InvitationExpired
+	default:
+		return InvitationPending
+	}
+}
+
+func newInvitationResponse(invitation *models.Invitation) *InvitationResponse {
+	return &InvitationResponse{Invitation: invitation, Status: invitationStatus(invitation, time.Now())}
+}
+
+// SetInvitationManager enables invitations sent by the invitation manager
+func (h *UserHandlers) SetInvitationManager(invitations *InvitationManager) {
+	h.invitations = invitations
+}
+
+// ListInvitations handles listing the invitations of an organization
+func (h *UserHandlers) ListInvitations(c *gin.Context) {
+	orgID := c.Param("id")
+	if orgID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization ID required"})
+		return
+	}
+
+	if !authorize(c, authz.InvitationList, authz.InOrganization(orgID)) {
+		return
+	}
+
+	invitations, err := h.storage.ListInvitations(orgID)
+	if err != nil {
+		klog.Errorf("Failed to list invitations of organization %s: %v", orgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list invitations"})
+		return
+	}
+
+	responses := make([]*InvitationResponse, 0, len(invitations))
+	for _, invitation := range invitations {
+		responses = append(responses, newInvitationResponse(invitation))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"invitations": responses,
+		"total":       len(responses),
+		"org_id":      orgID,
+	})
+}
+
+// Invite handles inviting a person by email to join an organization with a role. The invitee
+// accepts the invitation by creating a local account or by logging in with OIDC.
+func (h *UserHandlers) Invite(c *gin.Context) {
+	orgID := c.Param("id")
+	if orgID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization ID required"})
+		return
+	}
+
+	if h.invitations == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Invitations are not enabled"})
+		return
+	}
+
+	if !authorize(c, authz.InvitationManage, authz.InOrganization(orgID)) {
+		return
+	}
+
+	var req InviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An email and a role are required"})
+		return
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if !isValidEmail(req.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email format"})
+		return
+	}
+	if req.Role == models.RoleSystemAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invitees cannot be system administrators"})
+		return
+	}
+
+	org, ok := h.getInvitationOrganization(c, orgID)
+	if !ok {
+		return
+	}
+	if !h.checkRoleGrant(c, req.Role, &orgID) {
+		return
+	}
+
+	// People with an account are added as members instead, and invited once at a time
+	users, err := h.storage.ListUsers()
+	if err != nil {
+		klog.Errorf("Failed to list users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invite user"})
+		return
+	}
+	for _, user := range users {
+		if strings.EqualFold(user.Email, req.Email) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A user has this email already, add the user to the organization as a member"})
+			return
+		}
+	}
+	invitations, err := h.storage.ListInvitations(orgID)
+	if err != nil {
+		klog.Errorf("Failed to list invitations of organization %s: %v", orgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invite user"})
+		return
+	}
+	now := time.Now()
+	for _, invitation := range invitations {
+		if strings.EqualFold(invitation.Email, req.Email) && invitationStatus(invitation, now) == InvitationPending {
+			c.JSON(http.StatusConflict, gin.H{"error": "This email has a pending invitation, resend it instead"})
+			return
+		}
+	}
+
+	invitation := &models.Invitation{
+		OrgID:     orgID,
+		Email:     req.Email,
+		Role:      req.Role,
+		InvitedBy: actingUsername(c),
+	}
+	if err := h.invitations.Invite(org, invitation); err != nil {
+		if err == errInvitationDelivery {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Invitation was created but could not be delivered, resend it", "invitation": newInvitationResponse(invitation)})
+			return
+		}
+		klog.Errorf("Failed to invite %s to organization %s: %v", req.Email, orgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invite user"})
+		return
+	}
+
+	klog.Infof("User %s invited %s to organization %s as %s", invitation.InvitedBy, invitation.Email, orgID, invitation.Role)
+	c.JSON(http.StatusCreated, newInvitationResponse(invitation))
+}
+
+// ResendInvitation handles sending an invitation again with a new token, which also extends an
+// expired invitation. Tokens sent before can no longer be used.
+func (h *UserHandlers) ResendInvitation(c *gin.Context) {
+	orgID := c.Param("id")
+	invitationID := c.Param("invitationId")
+	if orgID == "" || invitationID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization ID and Invitation ID required"})
+		return
+	}
+
+	if h.invitations == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Invitations are not enabled"})
+		return
+	}
+
+	if !authorize(c, authz.InvitationManage, authz.InOrganization(orgID)) {
+		return
+	}
+
+	org, ok := h.getInvitationOrganization(c, orgID)
+	if !ok {
+		return
+	}
+	invitation, ok := h.getInvitation(c, orgID, invitationID)
+	if !ok {
+		return
+	}
+
+	if err := h.invitations.Resend(org, invitation); err != nil {
+		switch err {
+		case storage.ErrConflict:
+			c.JSON(http.StatusConflict, gin.H{"error": "Invitation was accepted or revoked"})
+		case storage.ErrNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		case errInvitationDelivery:
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Invitation could not be delivered, resend it"})
+		default:
+			klog.Errorf("Failed to resend invitation %s: %v", invitationID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resend invitation"})
+		}
+		return
+	}
+
+	klog.Infof("User %s resent invitation %s to %s", actingUsername(c), invitationID, invitation.Email)
+	c.JSON(http.StatusOK, newInvitationResponse(invitation))
+}
+
+// RevokeInvitation handles cancelling a pending invitation, whose tokens can no longer be used
+func (h *UserHandlers) RevokeInvitation(c *gin.Context) {
+	orgID := c.Param("id")
+	invitationID := c.Param("invitationId")
+	if orgID == "" || invitationID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization ID and Invitation ID required"})
+		return
+	}
+
+	if !authorize(c, authz.InvitationManage, authz.InOrganization(orgID)) {
+		return
+	}
+
+	if _, ok := h.getInvitation(c, orgID, invitationID); !ok {
+		return
+	}
+	if err := h.storage.RevokeInvitation(invitationID, time.Now()); err != nil {
+		switch err {
+		case storage.ErrConflict:
+			c.JSON(http.StatusConflict, gin.H{"error": "Invitation was accepted or revoked"})
+		case storage.ErrNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		default:
+			klog.Errorf("Failed to revoke invitation %s: %v", invitationID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invitation"})
+		}
+		return
+	}
+
+	klog.Infof("User %s revoked invitation %s", actingUsername(c), invitationID)
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked successfully"})
+}
+
+// getInvitationOrganization returns the organization of an invitation, or responds and returns
+// false
+func (h *UserHandlers) getInvitationOrganization(c *gin.Context, orgID string) (*models.Organization, bool) {
+	org, err := h.storage.GetOrganization(orgID)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			return nil, false
+		}
+		klog.Errorf("Failed to get organization %s: %v", orgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get organization"})
+		return nil, false
+	}
+	return org, true
+}
+
+// getInvitation returns an invitation of an organization, or responds and returns false
+func (h *UserHandlers) getInvitation(c *gin.Context, orgID, invitationID string) (*models.Invitation, bool) {
+	invitation, err := h.storage.GetInvitation(invitationID)
+	if err != nil && err != storage.ErrNotFound {
+		klog.Errorf("Failed to get invitation %s: %v", invitationID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get invitation"})
+		return nil, false
+	}
+	if err == storage.ErrNotFound || invitation.OrgID != orgID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		return nil, false
+	}
+	return invitation, true
+}
+
+// SetInvitationManager enables accepting the invitations of the invitation manager
+func (h *AuthHandlers) SetInvitationManager(invitations *InvitationManager) {
+	h.invitations = invitations
+}
+
+// AcceptInvitation handles accepting an invitation with its token. The invitee either creates a
+// local account in the organization with the role of the invitation, or logs in with OIDC as an
+// identity with the email of the invitation and becomes a member of the organization with that
+// role. Either way the invitee is logged in.
+func (h *AuthHandlers) AcceptInvitation(c *gin.Context) {
+	if h.invitations == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Invitations are not enabled"})
+		return
+	}
+
+	var req AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	var user *models.User
+	var invitation *models.Invitation
+	var ok bool
+	if req.OIDCCode != "" {
+		user, invitation, ok = h.acceptInvitationWithOIDC(c, &req)
+	} else {
+		user, invitation, ok = h.acceptInvitationWithPassword(c, &req)
+	}
+	if !ok {
+		return
+	}
+
+	response, err := h.startSession(user)
+	if err != nil {
+		klog.Errorf("Failed to generate token for user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	klog.Infof("User %s accepted invitation %s to organization %s as %s", user.Username, invitation.ID, invitation.OrgID, invitation.Role)
+	c.JSON(http.StatusCreated, response)
+}
+
+// acceptInvitationWithPassword creates the local account of an invitee, or responds and returns
+// false
+func (h *AuthHandlers) acceptInvitationWithPassword(c *gin.Context, req *AcceptInvitationRequest) (*models.User, *models.Invitation, bool) {
+	username := strings.TrimSpace(req.Username)
+	if len(username) < 3 || len(username) > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A username of 3 to 50 characters and a password, or an OIDC code, are required"})
+		return nil, nil, false
+	}
+	if err := h.passwords.Validate(req.Password); err != nil {
+		respondPasswordError(c, err)
+		return nil, nil, false
+	}
+	hashedPassword, err := auth.HashPassword(req.Password)
+	if err != nil {
+		respondPasswordError(c, err)
+		return nil, nil, false
+	}
+	userID, err := util.GenerateID(16)
+	if err != nil {
+		klog.Errorf("Failed to generate user ID: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		return nil, nil, false
+	}
+
+	var user *models.User
+	invitation, err := h.invitations.Redeem(req.Token, func(tx storage.Storage, invitation *models.Invitation) (string, error) {
+		now := time.Now()
+		orgID := invitation.OrgID
+		user = &models.User{
+			ID:                userID,
+			Username:          username,
+			Email:             invitation.Email,
+			PasswordHash:      hashedPassword,
+			Role:              invitation.Role,
+			OrgID:             &orgID,
+			CreatedAt:         now,
+			UpdatedAt:         now,
+			PasswordChangedAt: &now,
+		}
+		return user.ID, tx.CreateUser(user)
+	})
+	if err != nil {
+		switch err {
+		case errInvalidInvitation:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+		case storage.ErrAlreadyExists:
+			c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+		default:
+			klog.Errorf("Failed to accept invitation: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		}
+		return nil, nil, false
+	}
+	return user, invitation, true
+}
+
+// acceptInvitationWithOIDC logs an invitee in with OIDC and makes the user a member of the
+// organization of the invitation, or responds and returns false. The OIDC user keeps the role
+// and organization of the mapping rules, which are applied again on every login.
+func (h *AuthHandlers) acceptInvitationWithOIDC(c *gin.Context, req *AcceptInvitationRequest) (*models.User, *models.Invitation, bool) {
+	if h.oidcProvider == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "OIDC authentication is not configured"})
+		return nil, nil, false
+	}
+
+	// Check the invitation before the code, which can be exchanged only once
+	invitation, err := h.invitations.Lookup(req.Token)
+	if err != nil {
+		if err == errInvalidInvitation {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+			return nil, nil, false
+		}
+		klog.Errorf("Failed to look up invitation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		return nil, nil, false
+	}
+
+	userInfo, ok := h.exchangeOIDCCode(c, req.OIDCCode)
+	if !ok {
+		return nil, nil, false
+	}
+	if !userInfo.EmailVerified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "The email of the identity is not verified"})
+		return nil, nil, false
+	}
+	if !strings.EqualFold(userInfo.Email, invitation.Email) {
+		klog.Warningf("OIDC identity %s tried to accept invitation %s of another email", userInfo.Subject, invitation.ID)
+		c.JSON(http.StatusForbidden, gin.H{"error": "The invitation was sent to another email"})
+		return nil, nil, false
+	}
+
+	user, err := h.getOrCreateOIDCUser(userInfo, h.oidcProvider.MapUserInfo(userInfo))
+	if err != nil {
+		if err == errAccountExists {
+			klog.Warningf("OIDC identity %s collides with an account of another identity, refusing invitation %s", userInfo.Subject, invitation.ID)
+			c.JSON(http.StatusConflict, gin.H{"error": "Another account already uses this username or email"})
+			return nil, nil, false
+		}
+		klog.Errorf("Failed to create/update OIDC user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user account"})
+		return nil, nil, false
+	}
+	if accountDisabled(c, user) {
+		return nil, nil, false
+	}
+	if user.Role == models.RoleSystemAdmin || util.StringValue(user.OrgID) == invitation.OrgID {
+		c.JSON(http.StatusConflict, gin.H{"error": "You have access to the organization already"})
+		return nil, nil, false
+	}
+
+	invitation, err = h.invitations.Redeem(req.Token, func(tx storage.Storage, invitation *models.Invitation) (string, error) {
+		id, err := util.GenerateID(8)
+		if err != nil {
+			return "", err
+		}
+		membership := &models.OrgMembership{ID: "member-" + id, UserID: user.ID, OrgID: invitation.OrgID, Role: invitation.Role}
+		return user.ID, tx.CreateOrgMembership(membership)
+	})
+	if err != nil {
+		switch err {
+		case errInvalidInvitation:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+		case storage.ErrAlreadyExists:
+			c.JSON(http.StatusConflict, gin.H{"error": "You have access to the organization already"})
+		default:
+			klog.Errorf("Failed to accept invitation: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		}
+		return nil, nil, false
+	}
+	return user, invitation, true
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/notify"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+	"github.com/eliorerz/ovim-updated/pkg/util"
+)
+
+// recordingNotifier keeps the messages it is asked to deliver, or fails with err
+type recordingNotifier struct {
+	messages []*notify.Message
+	err      error
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, msg *notify.Message) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.messages = append(n.messages, msg)
+	return nil
+}
+
+// token returns the invitation token of the last message
+func (n *recordingNotifier) token(t *testing.T) string {
+	require.NotEmpty(t, n.messages)
+	body := n.messages[len(n.messages)-1].Body
+	start := strings.Index(body, "?token=")
+	require.NotEqual(t, -1, start, body)
+	token, err := url.QueryUnescape(strings.Fields(body[start+len("?token="):])[0])
+	require.NoError(t, err)
+	return token
+}
+
+// setupInvitations returns the role storage with user and auth handlers sending invitations
+// through notifier
+func setupInvitations(t *testing.T) (storage.Storage, *recordingNotifier, *UserHandlers, *AuthHandlers) {
+	store := setupRoleStorage(t)
+	notifier := &recordingNotifier{}
+	tokenManager := auth.NewTokenManager("test-secret", time.Hour)
+	invitations := NewInvitationManager(store, tokenManager, notifier, 0, "https://ovim.example.com/invitations/accept")
+
+	userHandlers := NewUserHandlers(store)
+	userHandlers.SetInvitationManager(invitations)
+	authHandlers := NewAuthHandlers(store, tokenManager, nil)
+	authHandlers.SetInvitationManager(invitations)
+	return store, notifier, userHandlers, authHandlers
+}
+
+func invite(store storage.Storage, handlers *UserHandlers, req InviteRequest) *InvitationResponse {
+	c, w := roleContext(store, http.MethodPost, "/organizations/org-1/invitations", req, models.RoleOrgAdmin, "org-1", gin.Params{{Key: "id", Value: "org-1"}})
+	handlers.Invite(c)
+	if w.Code != http.StatusCreated {
+		return nil
+	}
+	var response InvitationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		return nil
+	}
+	return &response
+}
+
+func acceptInvitation(handlers *AuthHandlers, req AcceptInvitationRequest) (int, string) {
+	c, w := setupGinContext(http.MethodPost, "/auth/invitations/accept", req, "", "", "", "")
+	handlers.AcceptInvitation(c)
+	return w.Code, w.Body.String()
+}
+
+func TestUserHandlers_Invite(t *testing.T) {
+	tests := []struct {
+		name           string
+		role           string
+		userOrgID      string
+		orgID          string
+		body           InviteRequest
+		expectedStatus int
+	}{
+		{"org admin invites", models.RoleOrgAdmin, "org-1", "org-1", InviteRequest{Email: "alice@example.com", Role: models.RoleOrgUser}, http.StatusCreated},
+		{"org admin invites with a custom role", models.RoleOrgAdmin, "org-1", "org-1", InviteRequest{Email: "alice@example.com", Role: "operator"}, http.StatusCreated},
+		{"system admin invites", models.RoleSystemAdmin, "", "org-1", InviteRequest{Email: "alice@example.com", Role: models.RoleOrgAdmin}, http.StatusCreated},
+		{"org admin of another organization", models.RoleOrgAdmin, "org-2", "org-1", InviteRequest{Email: "alice@example.com", Role: models.RoleOrgUser}, http.StatusForbidden},
+		{"org user", models.RoleOrgUser, "org-1", "org-1", InviteRequest{Email: "alice@example.com", Role: models.RoleOrgUser}, http.StatusForbidden},
+		{"system admin role", models.RoleSystemAdmin, "", "org-1", InviteRequest{Email: "alice@example.com", Role: models.RoleSystemAdmin}, http.StatusBadRequest},
+		{"custom role of another organization", models.RoleSystemAdmin, "", "org-2", InviteRequest{Email: "alice@example.com", Role: "operator"}, http.StatusBadRequest},
+		{"invalid email", models.RoleOrgAdmin, "org-1", "org-1", InviteRequest{Email: "alice", Role: models.RoleOrgUser}, http.StatusBadRequest},
+		{"missing role", models.RoleOrgAdmin, "org-1", "org-1", InviteRequest{Email: "alice@example.com"}, http.StatusBadRequest},
+		{"email of a user", models.RoleOrgAdmin, "org-1", "org-1", InviteRequest{Email: "OP@example.com", Role: models.RoleOrgUser}, http.StatusConflict},
+		{"missing organization", models.RoleSystemAdmin, "", "org-3", InviteRequest{Email: "alice@example.com", Role: models.RoleOrgUser}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, notifier, handlers, _ := setupInvitations(t)
+
+			c, w := roleContext(store, http.MethodPost, "/organizations/"+tt.orgID+"/invitations", tt.body, tt.role, tt.userOrgID, gin.Params{{Key: "id", Value: tt.orgID}})
+			handlers.Invite(c)
+
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus != http.StatusCreated {
+				assert.Empty(t, notifier.messages)
+				return
+			}
+			var response InvitationResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, InvitationPending, response.Status)
+			assert.Equal(t, tt.body.Role, response.Role)
+			assert.Equal(t, "caller", response.InvitedBy)
+			assert.NotContains(t, w.Body.String(), "token_id")
+
+			require.Len(t, notifier.messages, 1)
+			assert.Equal(t, "alice@example.com", notifier.messages[0].To)
+			assert.Contains(t, notifier.messages[0].Subject, "Org 1")
+			assert.Contains(t, notifier.messages[0].Body, "https://ovim.example.com/invitations/accept?token=")
+		})
+	}
+}
+
+func TestInvitations_Workflow(t *testing.T) {
+	store, notifier, handlers, authHandlers := setupInvitations(t)
+	invitation := invite(store, handlers, InviteRequest{Email: "alice@example.com", Role: "operator"})
+	require.NotNil(t, invitation)
+	firstToken := notifier.token(t)
+	params := gin.Params{{Key: "id", Value: "org-1"}, {Key: "invitationId", Value: invitation.ID}}
+
+	t.Run("duplicate", func(t *testing.T) {
+		c, w := roleContext(store, http.MethodPost, "/organizations/org-1/invitations", InviteRequest{Email: "Alice@example.com", Role: models.RoleOrgUser}, models.RoleOrgAdmin, "org-1", gin.Params{{Key: "id", Value: "org-1"}})
+		handlers.Invite(c)
+		assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	})
+
+	t.Run("resend", func(t *testing.T) {
+		c, w := roleContext(store, http.MethodPost, "/organizations/org-1/invitations/"+invitation.ID+"/resend", nil, models.RoleOrgAdmin, "org-2", params)
+		handlers.ResendInvitation(c)
+		assert.Equal(t, http.StatusForbidden, w.Code, "admins of other organizations cannot resend")
+
+		c, w = roleContext(store, http.MethodPost, "/organizations/org-1/invitations/"+invitation.ID+"/resend", nil, models.RoleOrgAdmin, "org-1", params)
+		handlers.ResendInvitation(c)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Len(t, notifier.messages, 2)
+		assert.NotEqual(t, firstToken, notifier.token(t))
+
+		// Only the token sent last can be used
+		status, body := acceptInvitation(authHandlers, AcceptInvitationRequest{Token: firstToken, Username: "alice", Password: "Secret-pass-123"})
+		assert.Equal(t, http.StatusBadRequest, status, body)
+	})
+
+	t.Run("accept with a password", func(t *testing.T) {
+		status, body := acceptInvitation(authHandlers, AcceptInvitationRequest{Token: notifier.token(t), Username: "alice", Password: "short"})
+		assert.Equal(t, http.StatusBadRequest, status, body)
+		require.NoError(t, store.CreateUser(&models.User{ID: "user-carol", Username: "carol", Email: "carol@example.com", Role: models.RoleOrgUser, OrgID: stringPtr("org-2")}))
+		status, body = acceptInvitation(authHandlers, AcceptInvitationRequest{Token: notifier.token(t), Username: "carol", Password: "Secret-pass-123"})
+		assert.Equal(t, http.StatusConflict, status, body)
+
+		status, body = acceptInvitation(authHandlers, AcceptInvitationRequest{Token: notifier.token(t), Username: "alice", Password: "Secret-pass-123"})
+		require.Equal(t, http.StatusCreated, status, body)
+		var response LoginResponse
+		require.NoError(t, json.Unmarshal([]byte(body), &response))
+		assert.NotEmpty(t, response.Token)
+		assert.Equal(t, "alice", response.User.Username)
+
+		user, err := store.GetUserByUsername("alice")
+		require.NoError(t, err)
+		assert.Equal(t, "alice@example.com", user.Email)
+		assert.Equal(t, "operator", user.Role)
+		assert.Equal(t, "org-1", util.StringValue(user.OrgID))
+		valid, err := auth.VerifyPassword("Secret-pass-123", user.PasswordHash)
+		require.NoError(t, err)
+		assert.True(t, valid)
+
+		stored, err := store.GetInvitation(invitation.ID)
+		require.NoError(t, err)
+		require.NotNil(t, stored.UserID)
+		assert.Equal(t, user.ID, *stored.UserID)
+
+		// Invitations are accepted once
+		status, body = acceptInvitation(authHandlers, AcceptInvitationRequest{Token: notifier.token(t), Username: "alice2", Password: "Secret-pass-123"})
+		assert.Equal(t, http.StatusBadRequest, status, body)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		c, w := roleContext(store, http.MethodDelete, "/organizations/org-1/invitations/"+invitation.ID, nil, models.RoleOrgAdmin, "org-1", params)
+		handlers.RevokeInvitation(c)
+		assert.Equal(t, http.StatusConflict, w.Code, "accepted invitations cannot be revoked")
+
+		bob := invite(store, handlers, InviteRequest{Email: "bob@example.com", Role: models.RoleOrgUser})
+		require.NotNil(t, bob)
+		bobParams := gin.Params{{Key: "id", Value: "org-1"}, {Key: "invitationId", Value: bob.ID}}
+		c, w = roleContext(store, http.MethodDelete, "/organizations/org-1/invitations/"+bob.ID, nil, models.RoleOrgUser, "org-1", bobParams)
+		handlers.RevokeInvitation(c)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		c, w = roleContext(store, http.MethodDelete, "/organizations/org-2/invitations/"+bob.ID, nil, models.RoleSystemAdmin, "",
+			gin.Params{{Key: "id", Value: "org-2"}, {Key: "invitationId", Value: bob.ID}})
+		handlers.RevokeInvitation(c)
+		assert.Equal(t, http.StatusNotFound, w.Code, "invitations are found within their organization")
+
+		c, w = roleContext(store, http.MethodDelete, "/organizations/org-1/invitations/"+bob.ID, nil, models.RoleOrgAdmin, "org-1", bobParams)
+		handlers.RevokeInvitation(c)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		status, body := acceptInvitation(authHandlers, AcceptInvitationRequest{Token: notifier.token(t), Username: "bob", Password: "Secret-pass-123"})
+		assert.Equal(t, http.StatusBadRequest, status, body)
+		c, w = roleContext(store, http.MethodPost, "/organizations/org-1/invitations/"+bob.ID+"/resend", nil, models.RoleOrgAdmin, "org-1", bobParams)
+		handlers.ResendInvitation(c)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		c, w := roleContext(store, http.MethodGet, "/organizations/org-1/invitations", nil, models.RoleOrgUser, "org-1", gin.Params{{Key: "id", Value: "org-1"}})
+		handlers.ListInvitations(c)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		c, w = roleContext(store, http.MethodGet, "/organizations/org-1/invitations", nil, models.RoleOrgAdmin, "org-1", gin.Params{{Key: "id", Value: "org-1"}})
+		handlers.ListInvitations(c)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response struct {
+			Invitations []*InvitationResponse `json:"invitations"`
+			Total       int                   `json:"total"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.Equal(t, 2, response.Total)
+		assert.Equal(t, InvitationAccepted, response.Invitations[0].Status)
+		assert.Equal(t, InvitationRevoked, response.Invitations[1].Status)
+	})
+}
+
+func TestInvitations_Delivery(t *testing.T) {
+	store, notifier, handlers, authHandlers := setupInvitations(t)
+	notifier.err = errors.New("connection refused")
+
+	c, w := roleContext(store, http.MethodPost, "/organizations/org-1/invitations", InviteRequest{Email: "alice@example.com", Role: models.RoleOrgUser}, models.RoleOrgAdmin, "org-1", gin.Params{{Key: "id", Value: "org-1"}})
+	handlers.Invite(c)
+	require.Equal(t, http.StatusBadGateway, w.Code, w.Body.String())
+
+	// The invitation is kept and can be sent again
+	invitations, err := store.ListInvitations("org-1")
+	require.NoError(t, err)
+	require.Len(t, invitations, 1)
+	notifier.err = nil
+	c, w = roleContext(store, http.MethodPost, "/organizations/org-1/invitations/"+invitations[0].ID+"/resend", nil, models.RoleOrgAdmin, "org-1",
+		gin.Params{{Key: "id", Value: "org-1"}, {Key: "invitationId", Value: invitations[0].ID}})
+	handlers.ResendInvitation(c)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	status, body := acceptInvitation(authHandlers, AcceptInvitationRequest{Token: notifier.token(t), Username: "alice", Password: "Secret-pass-123"})
+	assert.Equal(t, http.StatusCreated, status, body)
+}
+
+func TestInvitationManager_Expiry(t *testing.T) {
+	store := setupRoleStorage(t)
+	notifier := &recordingNotifier{}
+	manager := NewInvitationManager(store, auth.NewTokenManager("test-secret", time.Hour), notifier, time.Hour, "https://ovim.example.com/accept")
+	org, err := store.GetOrganization("org-1")
+	require.NoError(t, err)
+
+	invitation := &models.Invitation{OrgID: "org-1", Email: "alice@example.com", Role: models.RoleOrgUser, InvitedBy: "admin"}
+	require.NoError(t, manager.Invite(org, invitation))
+	token := notifier.token(t)
+	_, err = manager.Lookup(token)
+	require.NoError(t, err)
+
+	manager.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, err = manager.Lookup(token)
+	assert.Equal(t, errInvalidInvitation, err)
+	assert.Equal(t, InvitationExpired, invitationStatus(invitation, manager.now()))
+
+	// Resending an expired invitation extends it
+	require.NoError(t, manager.Resend(org, invitation))
+	manager.now = time.Now
+	_, err = manager.Lookup(notifier.token(t))
+	assert.NoError(t, err)
+}
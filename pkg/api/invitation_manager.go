@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/config"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/notify"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+	"github.com/eliorerz/ovim-updated/pkg/util"
+)
+
+// DefaultInvitationTokenDuration is how long an invitation can be accepted after it was sent
+const DefaultInvitationTokenDuration = 72 * time.Hour
+
+var (
+	// errInvalidInvitation is returned for invitation tokens that are invalid, expired, replaced
+	// by a newer one, or whose invitation was accepted or revoked
+	errInvalidInvitation = errors.New("invalid or expired invitation")
+
+	// errInvitationDelivery is returned when an invitation was stored but could not be sent
+	errInvitationDelivery = errors.New("failed to deliver invitation")
+)
+
+// InvitationManager invites people to join organizations by email. Each invitation is accepted
+// once with the latest signed token sent for it.
+type InvitationManager struct {
+	storage       storage.Storage
+	tokenManager  *auth.TokenManager
+	notifier      notify.Notifier
+	tokenDuration time.Duration
+	acceptURL     string
+	now           func() time.Time
+}
+
+// NewInvitationManager creates an invitation manager sending invitations with notifier. Their
+// tokens expire after tokenDuration, DefaultInvitationTokenDuration if zero. Invitations link to
+// acceptURL with the token in its token query parameter, or contain the bare token without one.
+func NewInvitationManager(storage storage.Storage, tokenManager *auth.TokenManager, notifier notify.Notifier, tokenDuration time.Duration, acceptURL string) *InvitationManager {
+	if tokenDuration <= 0 {
+		tokenDuration = DefaultInvitationTokenDuration
+	}
+	return &InvitationManager{
+		storage:       storage,
+		tokenManager:  tokenManager,
+		notifier:      notifier,
+		tokenDuration: tokenDuration,
+		acceptURL:     acceptURL,
+		now:           time.Now,
+	}
+}
+
+// Invite stores an invitation to join org and sends it. The invitation names its organization,
+// email, role and inviter; its ID, token and expiry are set here. It returns
+// errInvitationDelivery if the invitation is stored but was not sent, so it can be resent.
+func (m *InvitationManager) Invite(org *models.Organization, invitation *models.Invitation) error {
+	id, err := util.GenerateID(16)
+	if err != nil {
+		return err
+	}
+	invitation.ID = id
+	invitation.ExpiresAt = m.now().Add(m.tokenDuration)
+	token, tokenID, err := m.tokenManager.GenerateInvitationToken(invitation.ID, invitation.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	invitation.TokenID = tokenID
+
+	if err := m.storage.CreateInvitation(invitation); err != nil {
+		return fmt.Errorf("failed to store invitation: %w", err)
+	}
+	return m.send(org, invitation, token)
+}
+
+// Resend sends a pending or expired invitation again with a new token, so that earlier ones can
+// no longer be used. It returns storage.ErrConflict if the invitation was accepted or revoked.
+func (m *InvitationManager) Resend(org *models.Organization, invitation *models.Invitation) error {
+	expiresAt := m.now().Add(m.tokenDuration)
+	token, tokenID, err := m.tokenManager.GenerateInvitationToken(invitation.ID, expiresAt)
+	if err != nil {
+		return err
+	}
+	if err := m.storage.RenewInvitation(invitation.ID, tokenID, expiresAt); err != nil {
+		return err
+	}
+	invitation.TokenID = tokenID
+	invitation.ExpiresAt = expiresAt
+	return m.send(org, invitation, token)
+}
+
+// Lookup returns the invitation of a token that can still be accepted
+func (m *InvitationManager) Lookup(token string) (*models.Invitation, error) {
+	return m.lookup(m.storage, token)
+}
+
+// Redeem accepts the invitation of a token. accept gives the invitee access to the organization
+// within the transaction accepting the invitation, and returns the ID of the invitee's user.
+func (m *InvitationManager) Redeem(token string, accept func(tx storage.Storage, invitation *models.Invitation) (string, error)) (*models.Invitation, error) {
+	var invitation *models.Invitation
+	err := m.storage.WithTx(func(tx storage.Storage) error {
+		var err error
+		invitation, err = m.lookup(tx, token)
+		if err != nil {
+			return err
+		}
+		userID, err := accept(tx, invitation)
+		if err != nil {
+			return err
+		}
+		now := m.now()
+		if err := tx.AcceptInvitation(invitation.ID, userID, now); err != nil {
+			if err == storage.ErrConflict {
+				return errInvalidInvitation
+			}
+			return err
+		}
+		invitation.AcceptedAt = &now
+		invitation.UserID = &userID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+func (m *InvitationManager) lookup(s storage.Storage, token string) (*models.Invitation, error) {
+	claims, err := m.tokenManager.ValidateInvitationToken(token)
+	if err != nil {
+		return nil, errInvalidInvitation
+	}
+	invitation, err := s.GetInvitation(claims.Subject)
+	if err == storage.ErrNotFound {
+		return nil, errInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+	if invitation.TokenID != claims.ID || invitationStatus(invitation, m.now()) != InvitationPending {
+		return nil, errInvalidInvitation
+	}
+	return invitation, nil
+}
+
+// send delivers an invitation with its token
+func (m *InvitationManager) send(org *models.Organization, invitation *models.Invitation, token string) error {
+	accept := "Accept the invitation with this token:\n\n" + token
+	if m.acceptURL != "" {
+		accept = "Accept the invitation at:\n\n" + m.acceptURL + "?token=" + url.QueryEscape(token)
+	}
+	msg := &notify.Message{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("You are invited to join %s on OVIM", org.Name),
+		Body: fmt.Sprintf("%s invited you to join the organization %s on OVIM as %s.\n\n%s\n\nThe invitation expires on %s.\n",
+			invitation.InvitedBy, org.Name, invitation.Role, accept, invitation.ExpiresAt.UTC().Format(time.RFC1123)),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := m.notifier.Notify(ctx, msg); err != nil {
+		klog.Errorf("Failed to send invitation %s to %s: %v", invitation.ID, invitation.Email, err)
+		return errInvitationDelivery
+	}
+	return nil
+}
+
+// newNotifier creates the notifier delivering invitations
+func newNotifier(cfg config.NotifierConfig) (notify.Notifier, error) {
+	if cfg.Type != config.NotifierSMTP {
+		return notify.NewLogNotifier(), nil
+	}
+	return notify.NewSMTPNotifier(notify.SMTPConfig(cfg.SMTP))
+}
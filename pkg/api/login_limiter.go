@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/eliorerz/ovim-updated/pkg/config"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+	"github.com/eliorerz/ovim-updated/pkg/util"
+)
+
+// LoginLimiter slows down password guessing. It counts failed logins per account and per client
+// address in the storage, so that every replica enforces the same delays and lockouts.
+type LoginLimiter struct {
+	storage       storage.Storage
+	config        config.LockoutConfig
+	eventRecorder *EventRecorder
+	now           func() time.Time
+}
+
+// NewLoginLimiter creates a login limiter enforcing cfg
+func NewLoginLimiter(storage storage.Storage, cfg config.LockoutConfig) *LoginLimiter {
+	return &LoginLimiter{
+		storage: storage,
+		config:  cfg,
+		now:     time.Now,
+	}
+}
+
+// SetEventRecorder sets the event recorder for lockout events
+func (l *LoginLimiter) SetEventRecorder(recorder *EventRecorder) {
+	l.eventRecorder = recorder
+}
+
+// accountAttemptID and addressAttemptID name the login attempts of an account and of a client
+func accountAttemptID(username string) string {
+	return "user:" + username
+}
+
+func addressAttemptID(clientIP string) string {
+	return "ip:" + clientIP
+}
+
+// Check returns how long the client must wait before trying to log in to the account, zero if
+// it may try now
+func (l *LoginLimiter) Check(username, clientIP string) (time.Duration, error) {
+	now := l.now()
+	var wait time.Duration
+	for _, id := range []string{accountAttemptID(username), addressAttemptID(clientIP)} {
+		attempt, err := l.storage.GetLoginAttempt(id)
+		if err == storage.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if w := l.wait(attempt, now); w > wait {
+			wait = w
+		}
+	}
+	return wait, nil
+}
+
+// wait returns how long after now the next login of a tracked account or client may be tried
+func (l *LoginLimiter) wait(attempt *models.LoginAttempt, now time.Time) time.Duration {
+	if attempt.LockedUntil != nil && attempt.LockedUntil.After(now) {
+		return attempt.LockedUntil.Sub(now)
+	}
+	if l.config.Delay <= 0 || attempt.LastFailureAt.Before(now.Add(-l.config.Window)) {
+		return 0
+	}
+	return max(attempt.LastFailureAt.Add(l.delay(attempt.Failures)).Sub(now), 0)
+}
+
+// delay returns the wait after a number of failures, doubling from the configured delay
+func (l *LoginLimiter) delay(failures int) time.Duration {
+	delay := l.config.Delay
+	for i := 1; i < failures && (l.config.MaxDelay <= 0 || delay < l.config.MaxDelay); i++ {
+		delay *= 2
+	}
+	if l.config.MaxDelay > 0 {
+		delay = min(delay, l.config.MaxDelay)
+	}
+	return delay
+}
+
+// Fail records a failed login to the account from the client, and locks either out once it
+// reaches its maximum of failures. Unknown accounts are tracked like existing ones, so that
+// lockouts do not tell which usernames exist.
+func (l *LoginLimiter) Fail(ctx context.Context, username, clientIP string) error {
+	now := l.now()
+	since := now.Add(-l.config.Window)
+	account, err := l.storage.RecordLoginFailure(accountAttemptID(username), now, since)
+	if err != nil {
+		return err
+	}
+	address, err := l.storage.RecordLoginFailure(addressAttemptID(clientIP), now, since)
+	if err != nil {
+		return err
+	}
+
+	until := now.Add(l.config.Duration)
+	if reachedMaxAttempts(account, l.config.MaxAttempts) {
+		if err := l.storage.LockLogin(account.ID, until); err != nil {
+			return err
+		}
+		klog.Warningf("Locked out user %s until %s after %d failed logins", username, until.Format(time.RFC3339), account.Failures)
+		l.recordLockout(ctx, username, until)
+	}
+	if reachedMaxAttempts(address, l.config.MaxAttemptsPerIP) {
+		if err := l.storage.LockLogin(address.ID, until); err != nil {
+			return err
+		}
+		klog.Warningf("Locked out logins from %s until %s after %d failed logins", clientIP, until.Format(time.RFC3339), address.Failures)
+	}
+	return nil
+}
+
+// reachedMaxAttempts reports whether an attempt that is not locked yet has to be
+func reachedMaxAttempts(attempt *models.LoginAttempt, maxAttempts int) bool {
+	return maxAttempts > 0 && attempt.LockedUntil == nil && attempt.Failures >= maxAttempts
+}
+
+func (l *LoginLimiter) recordLockout(ctx context.Context, username string, until time.Time) {
+	if l.eventRecorder == nil {
+		return
+	}
+	user, err := l.storage.GetUserByUsername(username)
+	if err != nil {
+		if err != storage.ErrNotFound {
+			klog.Errorf("Failed to get locked out user %s: %v", username, err)
+		}
+		return
+	}
+	l.eventRecorder.RecordUserLockedOut(ctx, username, util.StringValue(user.OrgID), until)
+}
+
+// Succeed forgets the failed logins of an account after a successful one. Failures from the
+// client address still count, so that guessing across accounts is not reset by one success.
+func (l *LoginLimiter) Succeed(username string) error {
+	return l.storage.ResetLoginAttempts(accountAttemptID(username))
+}
+
+// Unlock lifts the lockout of an account and forgets its failed logins
+func (l *LoginLimiter) Unlock(ctx context.Context, user *models.User, unlockedBy string) error {
+	if err := l.storage.ResetLoginAttempts(accountAttemptID(user.Username)); err != nil {
+		return err
+	}
+	klog.Infof("User %s unlocked by %s", user.Username, unlockedBy)
+	if l.eventRecorder != nil {
+		l.eventRecorder.RecordUserUnlocked(ctx, user.Username, util.StringValue(user.OrgID), unlockedBy)
+	}
+	return nil
+}
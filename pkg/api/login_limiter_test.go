@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/config"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// testLockoutConfig locks an account out after three failures and an address after five
+var testLockoutConfig = config.LockoutConfig{
+	MaxAttempts:      3,
+	MaxAttemptsPerIP: 5,
+	Duration:         10 * time.Minute,
+	Window:           15 * time.Minute,
+	Delay:            time.Second,
+	MaxDelay:         4 * time.Second,
+}
+
+// setupLoginLimiter returns a limiter whose clock is advanced by the returned function
+func setupLoginLimiter(t *testing.T, cfg config.LockoutConfig) (*LoginLimiter, storage.Storage, func(time.Duration)) {
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+	limiter := NewLoginLimiter(store, cfg)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+	return limiter, store, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestLoginLimiter_Delays(t *testing.T) {
+	cfg := testLockoutConfig
+	cfg.MaxAttempts = 0
+	limiter, _, advance := setupLoginLimiter(t, cfg)
+	ctx := context.Background()
+
+	wait, err := limiter.Check("alice", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		require.NoError(t, limiter.Fail(ctx, "alice", "192.0.2.1"))
+		wait, err := limiter.Check("alice", "192.0.2.1")
+		require.NoError(t, err)
+		assert.Equal(t, expected, wait)
+	}
+
+	advance(3 * time.Second)
+	wait, err = limiter.Check("alice", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, wait)
+
+	wait, err = limiter.Check("bob", "192.0.2.2")
+	require.NoError(t, err)
+	assert.Zero(t, wait, "other accounts and addresses are not delayed")
+}
+
+func TestLoginLimiter_Lockout(t *testing.T) {
+	limiter, store, advance := setupLoginLimiter(t, testLockoutConfig)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, limiter.Fail(ctx, "alice", "192.0.2.1"))
+	}
+	wait, err := limiter.Check("alice", "192.0.2.2")
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, wait, "the account is locked from every address")
+
+	advance(10 * time.Minute)
+	wait, err = limiter.Check("alice", "192.0.2.2")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+
+	require.NoError(t, limiter.Fail(ctx, "alice", "192.0.2.2"))
+	attempt, err := store.GetLoginAttempt(accountAttemptID("alice"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, attempt.Failures, "failures start over after a lockout")
+	assert.Nil(t, attempt.LockedUntil)
+}
+
+func TestLoginLimiter_AddressLockout(t *testing.T) {
+	limiter, _, _ := setupLoginLimiter(t, testLockoutConfig)
+	ctx := context.Background()
+
+	for _, username := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		require.NoError(t, limiter.Fail(ctx, username, "192.0.2.1"))
+	}
+	wait, err := limiter.Check("frank", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, wait)
+
+	require.NoError(t, limiter.Succeed("frank"))
+	wait, err = limiter.Check("frank", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, wait, "a successful login does not unlock its address")
+
+	wait, err = limiter.Check("frank", "192.0.2.2")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+}
+
+func TestAuthHandlers_LoginLockout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+	passwordHash, err := auth.HashPassword("alicepassword")
+	require.NoError(t, err)
+	require.NoError(t, store.CreateUser(&models.User{
+		ID:           "alice",
+		Username:     "alice",
+		Email:        "alice@example.com",
+		PasswordHash: passwordHash,
+		Role:         models.RoleOrgUser,
+		OrgID:        stringPtr("org-1"),
+	}))
+
+	cfg := testLockoutConfig
+	cfg.Delay = 0
+	limiter := NewLoginLimiter(store, cfg)
+	handlers := NewAuthHandlers(store, auth.NewTokenManager("test-secret", time.Minute), nil)
+	handlers.SetLoginLimiter(limiter)
+	router := gin.New()
+	router.POST("/auth/login", handlers.Login)
+
+	w := serveJSON(router, http.MethodPost, "/auth/login", "", LoginRequest{Username: "alice", Password: "wrongpassword"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	loginAlice(t, router)
+
+	for i := 0; i < 3; i++ {
+		w := serveJSON(router, http.MethodPost, "/auth/login", "", LoginRequest{Username: "alice", Password: "wrongpassword"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "a successful login resets the failures")
+	}
+
+	w = serveJSON(router, http.MethodPost, "/auth/login", "", LoginRequest{Username: "alice", Password: "alicepassword"})
+	require.Equal(t, http.StatusTooManyRequests, w.Code, "a locked account rejects the right password")
+	assert.Equal(t, "600", w.Header().Get("Retry-After"))
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, float64(600), response["retry_after"])
+
+	user, err := store.GetUserByUsername("alice")
+	require.NoError(t, err)
+	require.NoError(t, limiter.Unlock(context.Background(), user, "admin"))
+	loginAlice(t, router)
+}
@@ -0,0 +1,250 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+	"github.com/eliorerz/ovim-updated/pkg/util"
+)
+
+// MembershipRequest represents the request body for adding a user to an organization
+type MembershipRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// MemberResponse is a membership of an organization with the user it belongs to
+type MemberResponse struct {
+	*models.OrgMembership
+	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
+}
+
+// SetMembership handles giving a user a role in an organization besides the organization of the
+// user, adding the user to the organization or changing the role of its membership. The user
+// then acts in the organization by sending its ID in the X-OVIM-Organization header.
+func (h *UserHandlers) SetMembership(c *gin.Context) {
+	userID := c.Param("userId")
+	orgID := c.Param("id")
+
+	if userID == "" || orgID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID and Organization ID required"})
+		return
+	}
+
+	if !authorize(c, authz.MembershipManage, authz.InOrganization(orgID)) {
+		return
+	}
+
+	var req MembershipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A role is required"})
+		return
+	}
+	if req.Role == models.RoleSystemAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Members cannot be system administrators"})
+		return
+	}
+
+	if _, err := h.storage.GetOrganization(orgID); err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			return
+		}
+		klog.Errorf("Failed to get organization %s: %v", orgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get organization"})
+		return
+	}
+
+	// Only system admins add users of any organization. Others only change the role of the
+	// members they see and invite the users of other organizations, who must accept. Users they
+	// cannot see are not found, whether they exist or not.
+	user, err := h.storage.GetUserByID(userID)
+	if err == nil {
+		var visible bool
+		visible, err = h.memberVisible(c, user, orgID)
+		if err == nil && !visible {
+			err = storage.ErrNotFound
+		}
+	}
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found, invite users of other organizations instead"})
+			return
+		}
+		klog.Errorf("Failed to get user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+	if user.Role == models.RoleSystemAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "System administrators have access to every organization"})
+		return
+	}
+	if util.StringValue(user.OrgID) == orgID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User belongs to this organization already"})
+		return
+	}
+
+	if !h.checkRoleGrant(c, req.Role, &orgID) {
+		return
+	}
+
+	// Create the membership or change its role atomically, so that concurrent requests for the
+	// same user do not both create it
+	var membership *models.OrgMembership
+	created := false
+	err = h.storage.WithTx(func(tx storage.Storage) error {
+		var err error
+		membership, err = tx.GetOrgMembership(userID, orgID)
+		if err == storage.ErrNotFound {
+			id, err := util.GenerateID(8)
+			if err != nil {
+				return err
+			}
+			membership = &models.OrgMembership{ID: "member-" + id, UserID: userID, OrgID: orgID, Role: req.Role}
+			created = true
+			return tx.CreateOrgMembership(membership)
+		}
+		if err != nil {
+			return err
+		}
+		membership.Role = req.Role
+		return tx.UpdateOrgMembership(membership)
+	})
+	if err != nil {
+		if err == storage.ErrAlreadyExists {
+			c.JSON(http.StatusConflict, gin.H{"error": "Membership was created concurrently, retry the request"})
+			return
+		}
+		klog.Errorf("Failed to add user %s to organization %s: %v", userID, orgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add user to organization"})
+		return
+	}
+
+	_, username, _, _, _ := auth.GetUserFromContext(c)
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		klog.Infof("User %s added user %s to organization %s as %s", username, user.Username, orgID, req.Role)
+	} else {
+		klog.Infof("User %s changed the role of user %s in organization %s to %s", username, user.Username, orgID, req.Role)
+	}
+	// The email of the user is only listed to the members of the organization
+	c.JSON(status, &MemberResponse{OrgMembership: membership, Username: user.Username})
+}
+
+// memberVisible reports whether the caller may give the user a membership of the organization:
+// the caller may see the user, or the user is a member of the organization already
+func (h *UserHandlers) memberVisible(c *gin.Context, user *models.User, orgID string) (bool, error) {
+	if err := authz.Authorize(c, authz.UserGet, userResource(user)); err == nil {
+		return true, nil
+	} else if !errors.Is(err, authz.ErrForbidden) {
+		return false, err
+	}
+	if _, err := h.storage.GetOrgMembership(user.ID, orgID); err != nil {
+		if err == storage.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteMembership handles removing a user from an organization it is a member of. The
+// organization of the user itself is left with RemoveFromOrganization.
+func (h *UserHandlers) DeleteMembership(c *gin.Context) {
+	userID := c.Param("userId")
+	orgID := c.Param("id")
+
+	if userID == "" || orgID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID and Organization ID required"})
+		return
+	}
+
+	if !authorize(c, authz.MembershipManage, authz.InOrganization(orgID)) {
+		return
+	}
+
+	if err := h.storage.DeleteOrgMembership(userID, orgID); err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Membership not found"})
+			return
+		}
+		klog.Errorf("Failed to remove user %s from organization %s: %v", userID, orgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove user from organization"})
+		return
+	}
+
+	_, username, _, _, _ := auth.GetUserFromContext(c)
+	klog.Infof("User %s removed the membership of user %s in organization %s", username, userID, orgID)
+	c.JSON(http.StatusOK, gin.H{"message": "Membership removed successfully"})
+}
+
+// ListProfileMemberships handles listing the organizations the current user is a member of
+// besides its own, to pick the one to act in
+func (h *UserHandlers) ListProfileMemberships(c *gin.Context) {
+	userID, _, _, _, ok := auth.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	memberships, err := h.storage.ListOrgMemberships(userID, "")
+	if err != nil {
+		klog.Errorf("Failed to list memberships of user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list memberships"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"memberships": memberships,
+		"total":       len(memberships),
+	})
+}
+
+// listMembers returns the memberships of an organization with their users
+func (h *UserHandlers) listMembers(orgID string) ([]*MemberResponse, error) {
+	memberships, err := h.storage.ListOrgMemberships("", orgID)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]*MemberResponse, 0, len(memberships))
+	for _, membership := range memberships {
+		user, err := h.storage.GetUserByID(membership.UserID)
+		if err == storage.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, &MemberResponse{OrgMembership: membership, Username: user.Username, Email: user.Email})
+	}
+	return members, nil
+}
+
+// membershipResolver resolves the memberships of users from storage
+type membershipResolver struct {
+	storage storage.Storage
+}
+
+// NewMembershipResolver returns a resolver for the organization memberships kept in storage
+func NewMembershipResolver(storage storage.Storage) auth.MembershipResolver {
+	return &membershipResolver{storage: storage}
+}
+
+func (r *membershipResolver) MembershipRole(userID, orgID string) (string, bool, error) {
+	membership, err := r.storage.GetOrgMembership(userID, orgID)
+	if err == storage.ErrNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return membership.Role, true, nil
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// setupMembershipStorage returns the role storage with bob, an org user of org-2
+func setupMembershipStorage(t *testing.T) storage.Storage {
+	store := setupRoleStorage(t)
+	require.NoError(t, store.CreateUser(&models.User{ID: "user-bob", Username: "bob", Email: "bob@example.com", Role: models.RoleOrgUser, OrgID: stringPtr("org-2")}))
+	return store
+}
+
+func TestUserHandlers_SetMembership(t *testing.T) {
+	tests := []struct {
+		name           string
+		role           string
+		userOrgID      string
+		orgID          string
+		userID         string
+		memberRole     string
+		body           MembershipRequest
+		expectedStatus int
+	}{
+		{"system admin adds a member", models.RoleSystemAdmin, "", "org-1", "user-bob", "", MembershipRequest{Role: models.RoleOrgAdmin}, http.StatusCreated},
+		{"system admin grants a custom role", models.RoleSystemAdmin, "", "org-1", "user-bob", "", MembershipRequest{Role: "operator"}, http.StatusCreated},
+		{"org admin changes the role of a member", models.RoleOrgAdmin, "org-1", "org-1", "user-bob", models.RoleOrgMember, MembershipRequest{Role: "operator"}, http.StatusOK},
+		{"org admin cannot add a user of another organization", models.RoleOrgAdmin, "org-1", "org-1", "user-bob", "", MembershipRequest{Role: models.RoleOrgUser}, http.StatusNotFound},
+		{"org admin of another organization", models.RoleOrgAdmin, "org-2", "org-1", "user-bob", "", MembershipRequest{Role: models.RoleOrgUser}, http.StatusForbidden},
+		{"org user", models.RoleOrgUser, "org-1", "org-1", "user-bob", models.RoleOrgMember, MembershipRequest{Role: models.RoleOrgUser}, http.StatusForbidden},
+		{"system admin role", models.RoleSystemAdmin, "", "org-1", "user-bob", "", MembershipRequest{Role: models.RoleSystemAdmin}, http.StatusBadRequest},
+		{"custom role of another organization", models.RoleSystemAdmin, "", "org-2", "user-op", "", MembershipRequest{Role: "operator"}, http.StatusBadRequest},
+		{"organization of the user", models.RoleSystemAdmin, "", "org-2", "user-bob", "", MembershipRequest{Role: models.RoleOrgAdmin}, http.StatusBadRequest},
+		{"missing role", models.RoleOrgAdmin, "org-1", "org-1", "user-bob", models.RoleOrgMember, MembershipRequest{}, http.StatusBadRequest},
+		{"missing user", models.RoleOrgAdmin, "org-1", "org-1", "user-missing", "", MembershipRequest{Role: models.RoleOrgUser}, http.StatusNotFound},
+		{"missing organization", models.RoleSystemAdmin, "", "org-3", "user-bob", "", MembershipRequest{Role: models.RoleOrgUser}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := setupMembershipStorage(t)
+			handlers := NewUserHandlers(store)
+			if tt.memberRole != "" {
+				require.NoError(t, store.CreateOrgMembership(&models.OrgMembership{ID: "member-1", UserID: tt.userID, OrgID: tt.orgID, Role: tt.memberRole}))
+			}
+
+			c, w := roleContext(store, http.MethodPut, "/organizations/"+tt.orgID+"/users/"+tt.userID+"/membership", tt.body, tt.role, tt.userOrgID,
+				gin.Params{{Key: "id", Value: tt.orgID}, {Key: "userId", Value: tt.userID}})
+			handlers.SetMembership(c)
+
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus == http.StatusCreated || tt.expectedStatus == http.StatusOK {
+				var response MemberResponse
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, "bob", response.Username)
+				assert.Empty(t, response.Email, "the email of a user of another organization is not returned")
+				assert.Equal(t, tt.body.Role, response.Role)
+
+				stored, err := store.GetOrgMembership(tt.userID, tt.orgID)
+				require.NoError(t, err)
+				assert.Equal(t, response.ID, stored.ID)
+				assert.Equal(t, tt.body.Role, stored.Role)
+			} else if tt.memberRole == "" {
+				_, err := store.GetOrgMembership(tt.userID, tt.orgID)
+				assert.Equal(t, storage.ErrNotFound, err, "no membership is created")
+			}
+		})
+	}
+}
+
+func TestUserHandlers_Memberships(t *testing.T) {
+	store := setupMembershipStorage(t)
+	handlers := NewUserHandlers(store)
+	params := gin.Params{{Key: "id", Value: "org-1"}, {Key: "userId", Value: "user-bob"}}
+
+	c, w := roleContext(store, http.MethodPut, "/organizations/org-1/users/user-bob/membership", MembershipRequest{Role: models.RoleOrgUser}, models.RoleSystemAdmin, "", params)
+	handlers.SetMembership(c)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	t.Run("change role", func(t *testing.T) {
+		c, w := roleContext(store, http.MethodPut, "/organizations/org-1/users/user-bob/membership", MembershipRequest{Role: "operator"}, models.RoleOrgAdmin, "org-1", params)
+		handlers.SetMembership(c)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		membership, err := store.GetOrgMembership("user-bob", "org-1")
+		require.NoError(t, err)
+		assert.Equal(t, "operator", membership.Role)
+
+		// The role cannot be deleted while a member holds it
+		require.NoError(t, store.UpdateUser(&models.User{ID: "user-op", Username: "op", Email: "op@example.com", Role: models.RoleOrgUser, OrgID: stringPtr("org-1")}))
+		roleHandlers := NewRoleHandlers(store)
+		c, w = roleContext(store, http.MethodDelete, "/organizations/org-1/roles/role-operator", nil, models.RoleOrgAdmin, "org-1",
+			gin.Params{{Key: "id", Value: "org-1"}, {Key: "roleId", Value: "role-operator"}})
+		roleHandlers.Delete(c)
+		assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	})
+
+	t.Run("list organization users", func(t *testing.T) {
+		c, w := roleContext(store, http.MethodGet, "/organizations/org-1/users", nil, models.RoleOrgAdmin, "org-1", gin.Params{{Key: "id", Value: "org-1"}})
+		handlers.ListByOrganization(c)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var response struct {
+			Users   []*models.User    `json:"users"`
+			Members []*MemberResponse `json:"members"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.Len(t, response.Users, 1)
+		assert.Equal(t, "op", response.Users[0].Username)
+		require.Len(t, response.Members, 1)
+		assert.Equal(t, "bob", response.Members[0].Username)
+		assert.Equal(t, "operator", response.Members[0].Role)
+	})
+
+	t.Run("list profile memberships", func(t *testing.T) {
+		c, w := setupGinContext(http.MethodGet, "/profile/memberships", nil, "user-bob", "bob", models.RoleOrgUser, "org-2")
+		handlers.ListProfileMemberships(c)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"org_id":"org-1"`)
+		assert.Contains(t, w.Body.String(), `"total":1`)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		c, w := roleContext(store, http.MethodDelete, "/organizations/org-1/users/user-bob/membership", nil, models.RoleOrgUser, "org-1", params)
+		handlers.DeleteMembership(c)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		c, w = roleContext(store, http.MethodDelete, "/organizations/org-1/users/user-bob/membership", nil, models.RoleOrgAdmin, "org-1", params)
+		handlers.DeleteMembership(c)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		_, err := store.GetOrgMembership("user-bob", "org-1")
+		assert.Equal(t, storage.ErrNotFound, err)
+
+		c, w = roleContext(store, http.MethodDelete, "/organizations/org-1/users/user-bob/membership", nil, models.RoleOrgAdmin, "org-1", params)
+		handlers.DeleteMembership(c)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("assign to organization", func(t *testing.T) {
+		// Moving a user into an organization it is a member of drops the membership
+		require.NoError(t, store.CreateOrgMembership(&models.OrgMembership{ID: "member-1", UserID: "user-bob", OrgID: "org-1", Role: models.RoleOrgUser}))
+		c, w := roleContext(store, http.MethodPost, "/organizations/org-1/users/user-bob", nil, models.RoleSystemAdmin, "", params)
+		handlers.AssignToOrganization(c)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		_, err := store.GetOrgMembership("user-bob", "org-1")
+		assert.Equal(t, storage.ErrNotFound, err)
+	})
+}
+
+func TestMembership_ActiveOrganization(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := setupMembershipStorage(t)
+	require.NoError(t, store.CreateOrgMembership(&models.OrgMembership{ID: "member-1", UserID: "user-bob", OrgID: "org-1", Role: models.RoleOrgAdmin}))
+
+	tokenManager := auth.NewTokenManager("test-secret", time.Minute)
+	middleware := auth.NewMiddleware(tokenManager)
+	middleware.SetMembershipResolver(NewMembershipResolver(store))
+	handlers := NewUserHandlers(store)
+	router := gin.New()
+	router.GET("/organizations/:id/users", middleware.RequireAuth(), middleware.SelectOrganization(), authz.NewAuthorizer(store).Middleware(), handlers.ListByOrganization)
+	token, err := tokenManager.GenerateToken("user-bob", "bob", models.RoleOrgUser, "org-2")
+	require.NoError(t, err)
+
+	listUsers := func(orgID, activeOrgID string) int {
+		req := httptest.NewRequest(http.MethodGet, "/organizations/"+orgID+"/users", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if activeOrgID != "" {
+			req.Header.Set(auth.OrganizationHeader, activeOrgID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Bob is an org user of org-2, and an org admin of org-1 when acting in it
+	assert.Equal(t, http.StatusForbidden, listUsers("org-2", ""))
+	assert.Equal(t, http.StatusForbidden, listUsers("org-1", ""))
+	assert.Equal(t, http.StatusOK, listUsers("org-1", "org-1"))
+	assert.Equal(t, http.StatusForbidden, listUsers("org-2", "org-1"), "acting in org-1 grants nothing in org-2")
+	assert.Equal(t, http.StatusForbidden, listUsers("org-1", "org-3"))
+
+	// Removing the membership takes effect on the next request
+	require.NoError(t, store.DeleteOrgMembership("user-bob", "org-1"))
+	assert.Equal(t, http.StatusForbidden, listUsers("org-1", "org-1"))
+}
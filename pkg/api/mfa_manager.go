@@ -0,0 +1,236 @@
+package api
+
+import (
+	"errors"
+	"time"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/config"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+	"github.com/eliorerz/ovim-updated/pkg/util"
+)
+
+// recoveryCodeCount is the number of recovery codes a user gets
+const recoveryCodeCount = 10
+
+var (
+	// errInvalidMFACode is returned for TOTP codes and recovery codes that do not match
+	errInvalidMFACode = errors.New("invalid MFA code")
+
+	// errMFAEnabled is returned when enrolling a user whose second factor is enabled already
+	errMFAEnabled = errors.New("MFA is enabled already")
+
+	// errMFANotEnrolled is returned when activating or using a second factor the user has not
+	errMFANotEnrolled = errors.New("MFA is not enrolled")
+)
+
+// MFAManager enrolls users in TOTP multi-factor authentication and verifies their codes. Only
+// users with a password have a second factor, identity providers handle the others.
+type MFAManager struct {
+	storage       storage.Storage
+	issuer        string
+	requiredRoles map[string]bool
+	now           func() time.Time
+}
+
+// NewMFAManager creates an MFA manager naming accounts after issuer in authenticator apps,
+// config.DefaultMFAIssuer if empty. Users holding one of requiredRoles must enroll.
+func NewMFAManager(storage storage.Storage, issuer string, requiredRoles []string) *MFAManager {
+	if issuer == "" {
+		issuer = config.DefaultMFAIssuer
+	}
+	roles := make(map[string]bool, len(requiredRoles))
+	for _, role := range requiredRoles {
+		roles[role] = true
+	}
+	return &MFAManager{
+		storage:       storage,
+		issuer:        issuer,
+		requiredRoles: roles,
+		now:           time.Now,
+	}
+}
+
+// Required reports whether the user has to enroll a second factor, because of the role or
+// because the organization requires it. The roles and organizations of the memberships of the
+// user count as well, since the user can act in them with the same login.
+func (m *MFAManager) Required(user *models.User) (bool, error) {
+	if user.PasswordHash == "" {
+		return false, nil
+	}
+	if m.requiredRoles[user.Role] {
+		return true, nil
+	}
+	required, err := m.orgRequiresMFA(util.StringValue(user.OrgID))
+	if required || err != nil {
+		return required, err
+	}
+
+	memberships, err := m.storage.ListOrgMemberships(user.ID, "")
+	if err != nil {
+		return false, err
+	}
+	for _, membership := range memberships {
+		if m.requiredRoles[membership.Role] {
+			return true, nil
+		}
+		required, err := m.orgRequiresMFA(membership.OrgID)
+		if required || err != nil {
+			return required, err
+		}
+	}
+	return false, nil
+}
+
+// orgRequiresMFA reports whether the organization requires its users to enroll a second factor
+func (m *MFAManager) orgRequiresMFA(orgID string) (bool, error) {
+	if orgID == "" {
+		return false, nil
+	}
+	org, err := m.storage.GetOrganization(orgID)
+	if err == storage.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return org.RequireMFA, nil
+}
+
+// EnrollmentRequired reports whether the user has to enroll a second factor before doing
+// anything else
+func (m *MFAManager) EnrollmentRequired(user *models.User) (bool, error) {
+	if user.MFAEnabled {
+		return false, nil
+	}
+	return m.Required(user)
+}
+
+// Enroll generates a new TOTP secret for the user and returns it with its otpauth URI. The
+// secret is only used once Activate confirmed the user added it to an authenticator app.
+func (m *MFAManager) Enroll(user *models.User) (secret, uri string, err error) {
+	if user.PasswordHash == "" {
+		return "", "", errNoPassword
+	}
+	if user.MFAEnabled {
+		return "", "", errMFAEnabled
+	}
+	secret, err = auth.NewTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	user.MFASecret = secret
+	user.MFALastCounter = 0
+	user.UpdatedAt = m.now()
+	if err := m.storage.UpdateUser(user); err != nil {
+		return "", "", err
+	}
+	return secret, auth.TOTPURI(m.issuer, user.Username, secret), nil
+}
+
+// Activate enables the pending second factor of the user once given one of its codes, and
+// returns the recovery codes of the user
+func (m *MFAManager) Activate(user *models.User, code string) ([]string, error) {
+	if user.MFAEnabled {
+		return nil, errMFAEnabled
+	}
+	if user.MFASecret == "" {
+		return nil, errMFANotEnrolled
+	}
+	if err := m.verifyTOTP(user, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	user.MFAEnabled = true
+	user.MFARecoveryCodes = hashes
+	user.UpdatedAt = m.now()
+	if err := m.updateUser(user); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify checks the second factor of the user, a TOTP code or an unused recovery code, which
+// cannot be used again
+func (m *MFAManager) Verify(user *models.User, code string) error {
+	if !user.MFAEnabled || user.MFASecret == "" {
+		return errMFANotEnrolled
+	}
+	err := m.verifyTOTP(user, code)
+	if err == nil {
+		return m.updateUser(user)
+	}
+	if err != errInvalidMFACode {
+		return err
+	}
+
+	hash := auth.HashRecoveryCode(code)
+	for i, stored := range user.MFARecoveryCodes {
+		if stored == hash {
+			user.MFARecoveryCodes = append(append(models.JSONBArray{}, user.MFARecoveryCodes[:i]...), user.MFARecoveryCodes[i+1:]...)
+			return m.updateUser(user)
+		}
+	}
+	return errInvalidMFACode
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user once given a TOTP code
+func (m *MFAManager) RegenerateRecoveryCodes(user *models.User, code string) ([]string, error) {
+	if !user.MFAEnabled {
+		return nil, errMFANotEnrolled
+	}
+	if err := m.verifyTOTP(user, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	user.MFARecoveryCodes = hashes
+	user.UpdatedAt = m.now()
+	if err := m.updateUser(user); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable removes the second factor of the user, enrolled or pending
+func (m *MFAManager) Disable(user *models.User) error {
+	user.MFAEnabled = false
+	user.MFASecret = ""
+	user.MFALastCounter = 0
+	user.MFARecoveryCodes = nil
+	user.UpdatedAt = m.now()
+	return m.storage.UpdateUser(user)
+}
+
+// verifyTOTP checks a TOTP code of the user and records its period in the user, so that it
+// cannot be used again once the user is updated
+func (m *MFAManager) verifyTOTP(user *models.User, code string) error {
+	counter, ok, err := auth.VerifyTOTP(user.MFASecret, code, m.now(), user.MFALastCounter)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errInvalidMFACode
+	}
+	user.MFALastCounter = counter
+	return nil
+}
+
+// updateUser stores a user whose code was consumed. A concurrent update of the user may have
+// consumed the same code, so the code is rejected then.
+func (m *MFAManager) updateUser(user *models.User) error {
+	err := m.storage.UpdateUser(user)
+	if err == storage.ErrConflict {
+		return errInvalidMFACode
+	}
+	return err
+}
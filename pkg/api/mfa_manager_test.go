@@ -0,0 +1,228 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// setupMFARouter returns a router serving the login and MFA endpoints for alice, an org_user of
+// org-1, and an MFA manager whose clock, shared with the login limiter, is advanced by the
+// returned function
+func setupMFARouter(t *testing.T, requiredRoles ...string) (*gin.Engine, storage.Storage, *MFAManager, func(time.Duration)) {
+	_, store := setupSessionRouter(t)
+	require.NoError(t, store.CreateOrganization(&models.Organization{ID: "org-1", Name: "Org 1", Namespace: "org-org-1", IsEnabled: true, CRName: "org-1"}))
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	mfa := NewMFAManager(store, "OVIM", requiredRoles)
+	mfa.now = clock
+	limiter := NewLoginLimiter(store, testLockoutConfig)
+	limiter.now = clock
+
+	tokenManager := auth.NewTokenManager("test-secret", time.Minute)
+	middleware := auth.NewMiddleware(tokenManager)
+	middleware.SetRevocationList(store)
+	handlers := NewAuthHandlers(store, tokenManager, nil)
+	handlers.SetMFAManager(mfa)
+	handlers.SetLoginLimiter(limiter)
+
+	router := gin.New()
+	router.POST("/auth/login", handlers.Login)
+	router.POST("/auth/refresh", handlers.Refresh)
+	router.POST("/auth/mfa/verify", handlers.VerifyMFA)
+	protected := router.Group("/", middleware.RequireAuth(), middleware.RequireMFAEnrollment("/profile/mfa", "/profile/mfa/enroll", "/profile/mfa/activate"), authz.NewAuthorizer(store).Middleware())
+	protected.GET("/profile/mfa", handlers.GetMFA)
+	protected.DELETE("/profile/mfa", handlers.DisableMFA)
+	protected.POST("/profile/mfa/enroll", handlers.EnrollMFA)
+	protected.POST("/profile/mfa/activate", handlers.ActivateMFA)
+	protected.POST("/profile/mfa/recovery-codes", handlers.RegenerateRecoveryCodes)
+	protected.GET("/me", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{})
+	})
+	return router, store, mfa, func(d time.Duration) { now = now.Add(d) }
+}
+
+// mfaLogin logs alice in with her password and returns the raw response
+func mfaLogin(t *testing.T, router *gin.Engine) map[string]interface{} {
+	t.Helper()
+	w := serveJSON(router, http.MethodPost, "/auth/login", "", LoginRequest{Username: "alice", Password: "alicepassword"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+// enrollAlice enrolls alice in MFA and returns her secret and recovery codes
+func enrollAlice(t *testing.T, router *gin.Engine, mfa *MFAManager, token string) (string, []string) {
+	t.Helper()
+	w := serveJSON(router, http.MethodPost, "/profile/mfa/enroll", token, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var enrolled MFAEnrollResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &enrolled))
+	assert.Contains(t, enrolled.OTPAuthURI, "otpauth://totp/OVIM:alice?")
+
+	w = serveJSON(router, http.MethodPost, "/profile/mfa/activate", token, MFACodeRequest{Code: "000000"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	code, err := auth.TOTPCode(enrolled.Secret, mfa.now())
+	require.NoError(t, err)
+	w = serveJSON(router, http.MethodPost, "/profile/mfa/activate", token, MFACodeRequest{Code: code})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var activated MFARecoveryCodesResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &activated))
+	require.Len(t, activated.RecoveryCodes, recoveryCodeCount)
+	return enrolled.Secret, activated.RecoveryCodes
+}
+
+func TestAuthHandlers_MFALogin(t *testing.T) {
+	router, store, mfa, advance := setupMFARouter(t)
+	session := loginAlice(t, router)
+	assert.False(t, session.MFAEnrollmentRequired)
+	secret, recoveryCodes := enrollAlice(t, router, mfa, session.Token)
+
+	w := serveJSON(router, http.MethodPost, "/profile/mfa/enroll", session.Token, nil)
+	assert.Equal(t, http.StatusConflict, w.Code, "an enabled second factor is disabled first")
+
+	verify := func(token, code string) *httptest.ResponseRecorder {
+		return serveJSON(router, http.MethodPost, "/auth/mfa/verify", "", VerifyMFARequest{MFAToken: token, Code: code})
+	}
+
+	challenge := mfaLogin(t, router)
+	assert.Equal(t, true, challenge["mfa_required"])
+	assert.Nil(t, challenge["token"], "the password alone gives no access token")
+	mfaToken := challenge["mfa_token"].(string)
+	assert.Equal(t, http.StatusUnauthorized, serveJSON(router, http.MethodGet, "/me", mfaToken, nil).Code)
+
+	code, err := auth.TOTPCode(secret, mfa.now())
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, verify(mfaToken, code).Code, "the code used for the activation cannot be replayed")
+	assert.Equal(t, http.StatusUnauthorized, verify("invalid", code).Code)
+
+	advance(auth.TOTPPeriod)
+	code, err = auth.TOTPCode(secret, mfa.now())
+	require.NoError(t, err)
+	w = verify(mfaToken, code)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, http.StatusOK, serveJSON(router, http.MethodGet, "/me", response.Token, nil).Code)
+
+	// Recovery codes work once, in any case
+	assert.Equal(t, http.StatusOK, verify(mfaToken, recoveryCodes[0]).Code)
+	assert.Equal(t, http.StatusUnauthorized, verify(mfaToken, recoveryCodes[0]).Code)
+	advance(testLockoutConfig.MaxDelay)
+	user, err := store.GetUserByID("alice")
+	require.NoError(t, err)
+	assert.Len(t, user.MFARecoveryCodes, recoveryCodeCount-1)
+
+	// Wrong codes count towards the lockout
+	for i := 0; i < testLockoutConfig.MaxAttempts-1; i++ {
+		assert.Equal(t, http.StatusUnauthorized, verify(mfaToken, "000000").Code)
+		advance(testLockoutConfig.MaxDelay)
+	}
+	w = verify(mfaToken, recoveryCodes[1])
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+}
+
+func TestAuthHandlers_MFAManagement(t *testing.T) {
+	router, store, mfa, advance := setupMFARouter(t)
+	session := loginAlice(t, router)
+
+	w := serveJSON(router, http.MethodPost, "/profile/mfa/enroll", session.Token, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var status MFAStatusResponse
+	w = serveJSON(router, http.MethodGet, "/profile/mfa", session.Token, nil)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, MFAStatusResponse{Pending: true}, status)
+
+	// A pending enrollment is cancelled without a code
+	require.Equal(t, http.StatusOK, serveJSON(router, http.MethodDelete, "/profile/mfa", session.Token, nil).Code)
+	user, err := store.GetUserByID("alice")
+	require.NoError(t, err)
+	assert.Empty(t, user.MFASecret)
+
+	secret, _ := enrollAlice(t, router, mfa, session.Token)
+	w = serveJSON(router, http.MethodGet, "/profile/mfa", session.Token, nil)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, MFAStatusResponse{Enabled: true, RecoveryCodesRemaining: recoveryCodeCount}, status)
+
+	advance(auth.TOTPPeriod)
+	code, err := auth.TOTPCode(secret, mfa.now())
+	require.NoError(t, err)
+	w = serveJSON(router, http.MethodPost, "/profile/mfa/recovery-codes", session.Token, MFACodeRequest{Code: code})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var regenerated MFARecoveryCodesResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &regenerated))
+
+	assert.Equal(t, http.StatusBadRequest, serveJSON(router, http.MethodDelete, "/profile/mfa", session.Token, nil).Code, "an enabled second factor needs a code")
+	assert.Equal(t, http.StatusBadRequest, serveJSON(router, http.MethodDelete, "/profile/mfa", session.Token, MFACodeRequest{Code: "000000"}).Code)
+	advance(testLockoutConfig.MaxDelay)
+	require.Equal(t, http.StatusOK, serveJSON(router, http.MethodDelete, "/profile/mfa", session.Token, MFACodeRequest{Code: regenerated.RecoveryCodes[0]}).Code)
+
+	user, err = store.GetUserByID("alice")
+	require.NoError(t, err)
+	assert.False(t, user.MFAEnabled)
+	assert.Empty(t, user.MFARecoveryCodes)
+	loginAlice(t, router)
+}
+
+func TestAuthHandlers_MFARequired(t *testing.T) {
+	t.Run("role", func(t *testing.T) {
+		router, _, mfa, advance := setupMFARouter(t, models.RoleOrgUser)
+		session := loginAlice(t, router)
+		assert.True(t, session.MFAEnrollmentRequired)
+
+		w := serveJSON(router, http.MethodGet, "/me", session.Token, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, "the token only allows enrolling")
+		var status MFAStatusResponse
+		w = serveJSON(router, http.MethodGet, "/profile/mfa", session.Token, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+		assert.True(t, status.Required)
+
+		secret, _ := enrollAlice(t, router, mfa, session.Token)
+		refreshStatus, refreshed := refreshTokens(t, router, session.RefreshToken)
+		require.Equal(t, http.StatusOK, refreshStatus)
+		assert.False(t, refreshed.MFAEnrollmentRequired)
+		assert.Equal(t, http.StatusOK, serveJSON(router, http.MethodGet, "/me", refreshed.Token, nil).Code)
+
+		advance(auth.TOTPPeriod)
+		code, err := auth.TOTPCode(secret, mfa.now())
+		require.NoError(t, err)
+		challenge := mfaLogin(t, router)
+		w = serveJSON(router, http.MethodPost, "/auth/mfa/verify", "", VerifyMFARequest{MFAToken: challenge["mfa_token"].(string), Code: code})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	})
+
+	t.Run("organization", func(t *testing.T) {
+		router, store, _, _ := setupMFARouter(t, models.RoleSystemAdmin)
+		assert.False(t, loginAlice(t, router).MFAEnrollmentRequired)
+
+		org, err := store.GetOrganization("org-1")
+		require.NoError(t, err)
+		org.RequireMFA = true
+		require.NoError(t, store.UpdateOrganization(org))
+		assert.True(t, loginAlice(t, router).MFAEnrollmentRequired)
+
+		// Users of identity providers have no password and enroll there
+		user, err := store.GetUserByID("alice")
+		require.NoError(t, err)
+		mfa := NewMFAManager(store, "", nil)
+		user.PasswordHash = ""
+		required, err := mfa.Required(user)
+		require.NoError(t, err)
+		assert.False(t, required)
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"k8s.io/klog/v2"
 
 	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
 	"github.com/eliorerz/ovim-updated/pkg/models"
 	"github.com/eliorerz/ovim-updated/pkg/openshift"
 	"github.com/eliorerz/ovim-updated/pkg/storage"
@@ -38,6 +39,10 @@ func NewOpenShiftHandlers(client openshift.Provider, storage storage.Storage) *O
 // @Failure 500 {object} ErrorResponse
 // @Router /api/v1/openshift/templates [get]
 func (h *OpenShiftHandlers) GetOpenShiftTemplates(c *gin.Context) {
+	if !authorizeOwnOrganization(c, authz.OpenShiftView) {
+		return
+	}
+
 	klog.Info("Getting OpenShift templates")
 
 	templates, err := h.client.GetTemplates(c.Request.Context())
@@ -66,6 +71,10 @@ func (h *OpenShiftHandlers) GetOpenShiftTemplates(c *gin.Context) {
 // @Failure 500 {object} ErrorResponse
 // @Router /api/v1/openshift/vms [post]
 func (h *OpenShiftHandlers) DeployVMFromTemplate(c *gin.Context) {
+	if !authorizeOwnOrganization(c, authz.OpenShiftManage) {
+		return
+	}
+
 	var req openshift.DeployVMRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		klog.Errorf("Invalid request body: %v", err)
@@ -293,6 +302,10 @@ func (h *OpenShiftHandlers) DeployVMFromTemplate(c *gin.Context) {
 // @Failure 500 {object} ErrorResponse
 // @Router /api/v1/openshift/vms [get]
 func (h *OpenShiftHandlers) GetOpenShiftVMs(c *gin.Context) {
+	if !authorizeOwnOrganization(c, authz.OpenShiftView) {
+		return
+	}
+
 	namespace := c.Query("namespace")
 	if namespace == "" {
 		namespace = "default"
@@ -323,6 +336,10 @@ func (h *OpenShiftHandlers) GetOpenShiftVMs(c *gin.Context) {
 // @Failure 503 {object} ErrorResponse
 // @Router /api/v1/openshift/status [get]
 func (h *OpenShiftHandlers) GetOpenShiftStatus(c *gin.Context) {
+	if !authorizeOwnOrganization(c, authz.OpenShiftView) {
+		return
+	}
+
 	klog.Info("Checking OpenShift connection status")
 
 	connected := h.client.IsConnected(c.Request.Context())
@@ -387,6 +404,10 @@ func (h *OpenShiftHandlers) resolveTemplateName(ctx context.Context, templateNam
 // @Failure 500 {object} ErrorResponse
 // @Router /api/v1/openshift/vms/{id}/power [put]
 func (h *OpenShiftHandlers) UpdateOpenShiftVMPower(c *gin.Context) {
+	if !authorizeOwnOrganization(c, authz.OpenShiftManage) {
+		return
+	}
+
 	vmID := c.Param("id")
 	if vmID == "" {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -491,6 +512,10 @@ func (h *OpenShiftHandlers) UpdateOpenShiftVMPower(c *gin.Context) {
 // @Failure 500 {object} ErrorResponse
 // @Router /api/v1/openshift/vms/{id} [delete]
 func (h *OpenShiftHandlers) DeleteOpenShiftVM(c *gin.Context) {
+	if !authorizeOwnOrganization(c, authz.OpenShiftManage) {
+		return
+	}
+
 	vmID := c.Param("id")
 	if vmID == "" {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -534,6 +559,10 @@ func (h *OpenShiftHandlers) DeleteOpenShiftVM(c *gin.Context) {
 // @Failure 500 {object} ErrorResponse
 // @Router /api/v1/openshift/vms/{id} [put]
 func (h *OpenShiftHandlers) UpdateOpenShiftVM(c *gin.Context) {
+	if !authorizeOwnOrganization(c, authz.OpenShiftManage) {
+		return
+	}
+
 	vmID := c.Param("id")
 	if vmID == "" {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -588,6 +617,10 @@ func (h *OpenShiftHandlers) UpdateOpenShiftVM(c *gin.Context) {
 // @Failure 500 {object} ErrorResponse
 // @Router /api/v1/openshift/vms/{id}/console [get]
 func (h *OpenShiftHandlers) GetOpenShiftVMConsole(c *gin.Context) {
+	if !authorizeOwnOrganization(c, authz.OpenShiftView) {
+		return
+	}
+
 	vmID := c.Param("id")
 	if vmID == "" {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
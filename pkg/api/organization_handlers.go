@@ -6,12 +6,14 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	ovimv1 "github.com/eliorerz/ovim-updated/pkg/api/v1"
 	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
 	"github.com/eliorerz/ovim-updated/pkg/models"
 	"github.com/eliorerz/ovim-updated/pkg/openshift"
 	"github.com/eliorerz/ovim-updated/pkg/storage"
@@ -42,17 +44,28 @@ func (h *OrganizationHandlers) SetEventRecorder(recorder *EventRecorder) {
 
 // List handles listing all organizations
 func (h *OrganizationHandlers) List(c *gin.Context) {
-	orgs, err := h.storage.ListOrganizations()
+	if !authorize(c, authz.OrgList, authz.Global()) {
+		return
+	}
+
+	opts, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	orgs, nextCursor, total, err := h.storage.QueryOrganizations(opts)
 	if err != nil {
 		klog.Errorf("Failed to list organizations: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list organizations"})
+		respondListError(c, err, "Failed to list organizations")
 		return
 	}
 
 	klog.V(6).Infof("Listed %d organizations", len(orgs))
 	c.JSON(http.StatusOK, gin.H{
 		"organizations": orgs,
-		"total":         len(orgs),
+		"total":         total,
+		"next_cursor":   nextCursor,
 	})
 }
 
@@ -64,6 +77,10 @@ func (h *OrganizationHandlers) Get(c *gin.Context) {
 		return
 	}
 
+	if !authorize(c, authz.OrgGet, authz.InOrganization(id)) {
+		return
+	}
+
 	org, err := h.storage.GetOrganization(id)
 	if err != nil {
 		if err == storage.ErrNotFound {
@@ -75,9 +92,41 @@ func (h *OrganizationHandlers) Get(c *gin.Context) {
 		return
 	}
 
+	setETag(c, org.ResourceVersion)
 	c.JSON(http.StatusOK, org)
 }
 
+// claimVersion evaluates the If-Match header of an update against the stored organization, whose
+// resource version is the ETag returned by Get, and bumps that version in the same conditional
+// write. Of concurrent updates made at one version only the first gets past it, before any of them
+// writes the Organization resource. It returns the new version, or 0 for an unconditional update
+// of an organization the controller has not stored yet, and false if the handler must stop.
+func (h *OrganizationHandlers) claimVersion(c *gin.Context, id string) (int64, bool) {
+	org, err := h.storage.GetOrganization(id)
+	if err == storage.ErrNotFound && c.GetHeader("If-Match") == "" {
+		return 0, true
+	}
+	if err == nil && !checkIfMatch(c, org.ResourceVersion) {
+		return 0, false
+	}
+	if err == nil {
+		err = h.storage.UpdateOrganization(org)
+	}
+	if err != nil {
+		switch err {
+		case storage.ErrNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		case storage.ErrConflict:
+			respondConflict(c, "Organization was modified concurrently, retry the request")
+		default:
+			klog.Errorf("Failed to update organization %s: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update organization"})
+		}
+		return 0, false
+	}
+	return org.ResourceVersion, true
+}
+
 // Create handles creating a new organization
 func (h *OrganizationHandlers) Create(c *gin.Context) {
 	var req models.CreateOrganizationRequest
@@ -88,15 +137,13 @@ func (h *OrganizationHandlers) Create(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, _, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
 	}
 
-	// Check permissions - only system admin can create organizations
-	if role != models.RoleSystemAdmin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only system administrators can create organizations"})
+	if !authorize(c, authz.OrgCreate, authz.Global()) {
 		return
 	}
 
@@ -119,6 +166,7 @@ func (h *OrganizationHandlers) Create(c *gin.Context) {
 			IsEnabled:   req.IsEnabled,
 		},
 	}
+	annotateImpersonator(c, orgCR.Annotations)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -152,7 +200,7 @@ func (h *OrganizationHandlers) Create(c *gin.Context) {
 
 	// Record API event
 	if h.eventRecorder != nil {
-		h.eventRecorder.RecordOrganizationCreated(ctx, orgID, username)
+		h.eventRecorder.RecordOrganizationCreated(ctx, orgID, actingUsername(c))
 	}
 
 	c.JSON(http.StatusCreated, response)
@@ -174,15 +222,13 @@ func (h *OrganizationHandlers) Update(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, _, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
 	}
 
-	// Check permissions - only system admin can update organizations
-	if role != models.RoleSystemAdmin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only system administrators can update organizations"})
+	if !authorize(c, authz.OrgUpdate, authz.InOrganization(id)) {
 		return
 	}
 
@@ -203,6 +249,11 @@ func (h *OrganizationHandlers) Update(c *gin.Context) {
 		return
 	}
 
+	version, ok := h.claimVersion(c, id)
+	if !ok {
+		return
+	}
+
 	// Update fields
 	if req.DisplayName != nil {
 		orgCR.Spec.DisplayName = *req.DisplayName
@@ -223,9 +274,14 @@ func (h *OrganizationHandlers) Update(c *gin.Context) {
 	}
 	orgCR.Annotations["ovim.io/updated-by"] = username
 	orgCR.Annotations["ovim.io/updated-at"] = time.Now().Format(time.RFC3339)
+	annotateImpersonator(c, orgCR.Annotations)
 
 	if h.k8sClient != nil {
 		if err := h.k8sClient.Update(ctx, orgCR); err != nil {
+			if apierrors.IsConflict(err) {
+				respondConflict(c, "Organization was modified concurrently, retry the request")
+				return
+			}
 			klog.Errorf("Failed to update Organization CRD %s: %v", id, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update organization CRD"})
 			return
@@ -238,7 +294,7 @@ func (h *OrganizationHandlers) Update(c *gin.Context) {
 
 	// Record API event
 	if h.eventRecorder != nil {
-		h.eventRecorder.RecordOrganizationUpdated(ctx, id, username)
+		h.eventRecorder.RecordOrganizationUpdated(ctx, id, actingUsername(c))
 	}
 
 	// Return updated organization data from CRD
@@ -253,94 +309,186 @@ func (h *OrganizationHandlers) Update(c *gin.Context) {
 		CRNamespace: "default",
 	}
 
+	if version != 0 {
+		response.ResourceVersion = version
+		setETag(c, version)
+	}
 	c.JSON(http.StatusOK, response)
 }
 
-// Delete handles deleting an organization
-func (h *OrganizationHandlers) Delete(c *gin.Context) {
+// OrganizationMFARequest represents a request to require, or no longer require, the users of an
+// organization to enroll a second factor
+type OrganizationMFARequest struct {
+	Required *bool `json:"required" binding:"required"`
+}
+
+// UpdateMFA handles setting whether the users of an organization must enroll a second factor.
+// The setting is kept in the database only, the Organization resource does not carry it.
+func (h *OrganizationHandlers) UpdateMFA(c *gin.Context) {
 	id := c.Param("id")
 	if id == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization ID required"})
 		return
 	}
 
-	// Get user info from context
-	userID, username, role, _, ok := auth.GetUserFromContext(c)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
+	var req OrganizationMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
 		return
 	}
 
-	// Check permissions - only system admin can delete organizations
-	if role != models.RoleSystemAdmin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only system administrators can delete organizations"})
+	if !authorize(c, authz.OrgUpdate, authz.InOrganization(id)) {
 		return
 	}
 
-	// Check for dependent VDCs
-	vdcs, err := h.storage.ListVDCs(id)
+	org, err := h.storage.GetOrganization(id)
 	if err != nil {
-		klog.Errorf("Failed to list VDCs for organization %s: %v", id, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check VDCs"})
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			return
+		}
+		klog.Errorf("Failed to get organization %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get organization"})
+		return
+	}
+
+	// The update is made at the version checked, so a concurrent change fails it
+	if !checkIfMatch(c, org.ResourceVersion) {
+		return
+	}
+
+	org.RequireMFA = *req.Required
+	org.UpdatedAt = time.Now()
+	if err := h.storage.UpdateOrganization(org); err != nil {
+		if err == storage.ErrConflict {
+			respondConflict(c, "Organization was modified concurrently, retry the request")
+			return
+		}
+		klog.Errorf("Failed to update MFA requirement of organization %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update organization"})
 		return
 	}
 
-	if len(vdcs) > 0 {
-		c.JSON(http.StatusConflict, gin.H{
-			"error":     "Cannot delete organization with existing VDCs",
-			"vdc_count": len(vdcs),
-		})
+	_, username, _, _, _ := auth.GetUserFromContext(c)
+	klog.Infof("MFA requirement of organization %s set to %t by %s", id, org.RequireMFA, username)
+	setETag(c, org.ResourceVersion)
+	c.JSON(http.StatusOK, org)
+}
+
+// Delete handles deleting an organization
+func (h *OrganizationHandlers) Delete(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization ID required"})
+		return
+	}
+
+	// Get user info from context
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
+		return
+	}
+
+	if !authorize(c, authz.OrgDelete, authz.InOrganization(id)) {
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Get existing Organization CRD
+	// Get existing Organization CRD, before the transaction that only writes the database
+	if h.k8sClient == nil {
+		klog.Warningf("k8sClient not available, skipping organization deletion for %s", id)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
 	orgCR := &ovimv1.Organization{}
-	if h.k8sClient != nil {
-		if err := h.k8sClient.Get(ctx, client.ObjectKey{Name: id}, orgCR); err != nil {
-			klog.Errorf("Failed to get Organization CRD %s: %v", id, err)
-			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+	if err := h.k8sClient.Get(ctx, client.ObjectKey{Name: id}, orgCR); err != nil {
+		klog.Errorf("Failed to get Organization CRD %s: %v", id, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+
+	// The organization is moved to the trash: its record is tombstoned and its CRD is marked as
+	// trashed, which stops the controller from syncing it. Members, catalog sources and the CRD
+	// itself are only removed when the trash is purged.
+	err := h.storage.WithTx(func(tx storage.Storage) error {
+		// The record is updated at the version checked, which fails on a concurrent change and
+		// holds the row until the transaction ends
+		org, err := tx.GetOrganization(id)
+		if err != nil {
+			if err == storage.ErrNotFound {
+				return abortTx(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			}
+			return err
+		}
+		if err := ifMatchTx(c, org.ResourceVersion); err != nil {
+			return err
+		}
+		if err := tx.UpdateOrganization(org); err != nil {
+			if err == storage.ErrConflict {
+				return abortTx(conflictStatus(c), gin.H{"error": "Organization was modified concurrently, retry the request"})
+			}
+			return err
+		}
+
+		// Check for dependent VDCs
+		vdcs, err := tx.ListVDCs(id)
+		if err != nil {
+			klog.Errorf("Failed to list VDCs for organization %s: %v", id, err)
+			return abortTx(http.StatusInternalServerError, gin.H{"error": "Failed to check VDCs"})
+		}
+
+		if len(vdcs) > 0 {
+			return abortTx(http.StatusConflict, gin.H{
+				"error":     "Cannot delete organization with existing VDCs",
+				"vdc_count": len(vdcs),
+			})
+		}
+
+		if err := tx.DeleteOrganization(id); err != nil {
+			if err == storage.ErrNotFound {
+				return abortTx(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		if respondTxAbort(c, err) {
 			return
 		}
-	} else {
-		klog.Warningf("k8sClient not available, skipping organization deletion for %s", id)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		klog.Errorf("Failed to delete organization %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete organization"})
 		return
 	}
 
-	// Add deletion annotation for audit
+	// Add deletion annotations for audit and mark the CRD as trashed. Failing to do so takes the
+	// record back out of the trash.
+	deletedAt := time.Now().Format(time.RFC3339)
 	if orgCR.Annotations == nil {
 		orgCR.Annotations = make(map[string]string)
 	}
 	orgCR.Annotations["ovim.io/deleted-by"] = username
-	orgCR.Annotations["ovim.io/deleted-at"] = time.Now().Format(time.RFC3339)
-
-	if h.k8sClient != nil {
-		if err := h.k8sClient.Update(ctx, orgCR); err != nil {
-			klog.Warningf("Failed to add deletion annotation to Organization CRD %s: %v", id, err)
-		}
-	} else {
-		klog.Warningf("k8sClient not available, skipping deletion annotation for %s", id)
-	}
+	orgCR.Annotations["ovim.io/deleted-at"] = deletedAt
+	annotateImpersonator(c, orgCR.Annotations)
+	orgCR.Annotations[ovimv1.TrashedAnnotation] = deletedAt
 
-	// Delete the Organization CRD
-	if h.k8sClient != nil {
-		if err := h.k8sClient.Delete(ctx, orgCR); err != nil {
-			klog.Errorf("Failed to delete Organization CRD %s: %v", id, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete organization CRD"})
-			return
+	if err := h.k8sClient.Update(ctx, orgCR); err != nil {
+		klog.Errorf("Failed to mark Organization CRD %s as trashed: %v", id, err)
+		if err := h.storage.RestoreOrganization(id); err != nil {
+			klog.Errorf("Failed to restore organization %s whose CRD is not trashed, restore it from the trash: %v", id, err)
 		}
-	} else {
-		klog.Warningf("k8sClient not available, skipping organization CRD deletion for %s", id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update organization CRD"})
+		return
 	}
 
-	klog.Infof("Deleted Organization CRD %s by user %s (%s) - controller will handle cleanup", id, username, userID)
+	klog.Infof("Moved organization %s to the trash by user %s (%s)", id, username, userID)
 
 	// Record API event
 	if h.eventRecorder != nil {
-		h.eventRecorder.RecordOrganizationDeleted(ctx, id, username)
+		h.eventRecorder.RecordOrganizationDeleted(ctx, id, actingUsername(c))
 	}
 
 	c.JSON(http.StatusNoContent, nil)
@@ -361,6 +509,10 @@ func (h *OrganizationHandlers) GetUserOrganization(c *gin.Context) {
 		return
 	}
 
+	if !authorize(c, authz.OrgGet, authz.InOrganization(orgID)) {
+		return
+	}
+
 	// Get the organization
 	org, err := h.storage.GetOrganization(orgID)
 	if err != nil {
@@ -386,18 +538,14 @@ func (h *OrganizationHandlers) GetResourceUsage(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
 	}
 
-	// Check permissions - only system admin can view any org, others can only view their own
-	if role != models.RoleSystemAdmin {
-		if userOrgID == "" || userOrgID != id {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Can only view resource usage for your own organization"})
-			return
-		}
+	if !authorize(c, authz.OrgUsage, authz.InOrganization(id)) {
+		return
 	}
 
 	// Get organization
@@ -502,18 +650,14 @@ func (h *OrganizationHandlers) ValidateResourceAllocation(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
 	}
 
-	// Check permissions - only system admin can validate any org, others can only validate their own
-	if role != models.RoleSystemAdmin {
-		if userOrgID == "" || userOrgID != id {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Can only validate resource allocation for your own organization"})
-			return
-		}
+	if !authorize(c, authz.OrgUsage, authz.InOrganization(id)) {
+		return
 	}
 
 	// Define request structure for resource validation
@@ -593,15 +737,13 @@ func (h *OrganizationHandlers) ForceReconcile(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, _, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
 	}
 
-	// Check permissions - only system admin can force reconcile organizations
-	if role != models.RoleSystemAdmin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only system administrators can force reconcile organizations"})
+	if !authorize(c, authz.OrgReconcile, authz.InOrganization(id)) {
 		return
 	}
 
@@ -640,7 +782,7 @@ func (h *OrganizationHandlers) ForceReconcile(c *gin.Context) {
 
 	// Record API event
 	if h.eventRecorder != nil {
-		h.eventRecorder.RecordOrganizationReconcileForced(ctx, id, username)
+		h.eventRecorder.RecordOrganizationReconcileForced(ctx, id, actingUsername(c))
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -660,15 +802,13 @@ func (h *OrganizationHandlers) GetStatus(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, _, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
 	}
 
-	// Check permissions - only system admin can get organization status
-	if role != models.RoleSystemAdmin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only system administrators can view organization status"})
+	if !authorize(c, authz.OrgGet, authz.InOrganization(id)) {
 		return
 	}
 
@@ -32,6 +32,7 @@ func TestNewOrganizationHandlers(t *testing.T) {
 func TestOrganizationHandlers_List(t *testing.T) {
 	tests := []struct {
 		name                string
+		query               string
 		mockStorageBehavior func(*MockStorage)
 		expectedStatus      int
 		expectedOrgs        int
@@ -39,10 +40,10 @@ func TestOrganizationHandlers_List(t *testing.T) {
 		{
 			name: "successful list",
 			mockStorageBehavior: func(ms *MockStorage) {
-				ms.On("ListOrganizations").Return([]*models.Organization{
+				ms.On("QueryOrganizations", storage.ListOptions{}).Return([]*models.Organization{
 					{ID: "org1", Name: "Organization 1"},
 					{ID: "org2", Name: "Organization 2"},
-				}, nil)
+				}, "", 2, nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedOrgs:   2,
@@ -50,11 +51,31 @@ func TestOrganizationHandlers_List(t *testing.T) {
 		{
 			name: "storage error",
 			mockStorageBehavior: func(ms *MockStorage) {
-				ms.On("ListOrganizations").Return([]*models.Organization{}, fmt.Errorf("database error"))
+				ms.On("QueryOrganizations", storage.ListOptions{}).Return(nil, "", 0, fmt.Errorf("database error"))
 			},
 			expectedStatus: http.StatusInternalServerError,
 			expectedOrgs:   0,
 		},
+		{
+			name:  "paginated list of enabled organizations",
+			query: "?limit=1&sort=name&status=enabled",
+			mockStorageBehavior: func(ms *MockStorage) {
+				ms.On("QueryOrganizations", storage.ListOptions{Limit: 1, Sort: "name", Status: "enabled"}).Return([]*models.Organization{
+					{ID: "org1", Name: "Organization 1", IsEnabled: true},
+				}, "next-page", 3, nil)
+			},
+			expectedStatus: http.StatusOK,
+			// The total counts the organizations matching the filters on every page
+			expectedOrgs: 3,
+		},
+		{
+			name:  "invalid status",
+			query: "?status=bogus",
+			mockStorageBehavior: func(ms *MockStorage) {
+				ms.On("QueryOrganizations", storage.ListOptions{Status: "bogus"}).Return(nil, "", 0, storage.ErrInvalidInput)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
 	}
 
 	for _, tt := range tests {
@@ -63,7 +84,7 @@ func TestOrganizationHandlers_List(t *testing.T) {
 			tt.mockStorageBehavior(mockStorage)
 
 			handlers := NewOrganizationHandlers(mockStorage, nil, nil)
-			c, w := setupGinContext("GET", "/organizations", nil, "user1", "admin", models.RoleSystemAdmin, "")
+			c, w := setupGinContext("GET", "/organizations"+tt.query, nil, "user1", "admin", models.RoleSystemAdmin, "")
 
 			handlers.List(c)
 
@@ -256,9 +277,11 @@ func TestOrganizationHandlers_Update_CRDOnly(t *testing.T) {
 		requestBody         models.UpdateOrganizationRequest
 		userID              string
 		role                string
+		ifMatch             string
 		mockStorageBehavior func(*MockStorage)
 		mockK8sBehavior     func(*MockK8sClient)
 		expectedStatus      int
+		expectedETag        string
 	}{
 		{
 			name:  "successful update",
@@ -268,10 +291,17 @@ func TestOrganizationHandlers_Update_CRDOnly(t *testing.T) {
 				Description: stringPtr("Updated description"),
 				IsEnabled:   boolPtr(false),
 			},
-			userID: "user-123",
-			role:   models.RoleSystemAdmin,
+			userID:  "user-123",
+			role:    models.RoleSystemAdmin,
+			ifMatch: `"2"`,
 			mockStorageBehavior: func(ms *MockStorage) {
-				// No storage calls needed - CRD controller handles sync
+				// The version is claimed in the database, the CRD controller syncs the fields
+				ms.On("GetOrganization", "test-org").Return(&models.Organization{ID: "test-org", ResourceVersion: 2}, nil)
+				ms.On("UpdateOrganization", mock.MatchedBy(func(org *models.Organization) bool {
+					return org.ResourceVersion == 2
+				})).Run(func(args mock.Arguments) {
+					args.Get(0).(*models.Organization).ResourceVersion = 3
+				}).Return(nil)
 			},
 			mockK8sBehavior: func(mk *MockK8sClient) {
 				// Mock getting existing organization
@@ -284,6 +314,62 @@ func TestOrganizationHandlers_Update_CRDOnly(t *testing.T) {
 				mk.On("Update", mock.Anything, mock.AnythingOfType("*v1.Organization"), mock.Anything).Return(nil)
 			},
 			expectedStatus: http.StatusOK,
+			expectedETag:   `"3"`,
+		},
+		{
+			name:  "organization not synced to the database yet",
+			orgID: "test-org",
+			requestBody: models.UpdateOrganizationRequest{
+				DisplayName: stringPtr("Updated Organization"),
+			},
+			userID: "user-123",
+			role:   models.RoleSystemAdmin,
+			mockStorageBehavior: func(ms *MockStorage) {
+				ms.On("GetOrganization", "test-org").Return(nil, storage.ErrNotFound)
+			},
+			mockK8sBehavior: func(mk *MockK8sClient) {
+				mk.On("Get", mock.Anything, mock.AnythingOfType("types.NamespacedName"), mock.AnythingOfType("*v1.Organization"), mock.Anything).Return(nil)
+				mk.On("Update", mock.Anything, mock.AnythingOfType("*v1.Organization"), mock.Anything).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:  "stale If-Match",
+			orgID: "test-org",
+			requestBody: models.UpdateOrganizationRequest{
+				DisplayName: stringPtr("Updated Organization"),
+			},
+			userID:  "user-123",
+			role:    models.RoleSystemAdmin,
+			ifMatch: `"1"`,
+			mockStorageBehavior: func(ms *MockStorage) {
+				ms.On("GetOrganization", "test-org").Return(&models.Organization{ID: "test-org", ResourceVersion: 2}, nil)
+			},
+			mockK8sBehavior: func(mk *MockK8sClient) {
+				// The CRD is not updated
+				mk.On("Get", mock.Anything, mock.AnythingOfType("types.NamespacedName"), mock.AnythingOfType("*v1.Organization"), mock.Anything).Return(nil)
+			},
+			expectedStatus: http.StatusPreconditionFailed,
+			expectedETag:   `"2"`,
+		},
+		{
+			name:  "concurrent update at the same version",
+			orgID: "test-org",
+			requestBody: models.UpdateOrganizationRequest{
+				DisplayName: stringPtr("Updated Organization"),
+			},
+			userID:  "user-123",
+			role:    models.RoleSystemAdmin,
+			ifMatch: `"2"`,
+			mockStorageBehavior: func(ms *MockStorage) {
+				ms.On("GetOrganization", "test-org").Return(&models.Organization{ID: "test-org", ResourceVersion: 2}, nil)
+				ms.On("UpdateOrganization", mock.AnythingOfType("*models.Organization")).Return(storage.ErrConflict)
+			},
+			mockK8sBehavior: func(mk *MockK8sClient) {
+				// The CRD is not updated
+				mk.On("Get", mock.Anything, mock.AnythingOfType("types.NamespacedName"), mock.AnythingOfType("*v1.Organization"), mock.Anything).Return(nil)
+			},
+			expectedStatus: http.StatusPreconditionFailed,
 		},
 		{
 			name:  "organization not found",
@@ -330,10 +416,14 @@ func TestOrganizationHandlers_Update_CRDOnly(t *testing.T) {
 			handlers := NewOrganizationHandlers(mockStorage, mockK8sClient, nil)
 			c, w := setupGinContext("PUT", fmt.Sprintf("/organizations/%s", tt.orgID), tt.requestBody, tt.userID, "admin", tt.role, "")
 			c.Params = []gin.Param{{Key: "id", Value: tt.orgID}}
+			if tt.ifMatch != "" {
+				c.Request.Header.Set("If-Match", tt.ifMatch)
+			}
 
 			handlers.Update(c)
 
 			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedETag, w.Header().Get("ETag"))
 			mockStorage.AssertExpectations(t)
 			mockK8sClient.AssertExpectations(t)
 		})
@@ -354,8 +444,12 @@ func TestOrganizationHandlers_Delete_CRDOnly(t *testing.T) {
 			orgID:    "test-org",
 			userRole: models.RoleSystemAdmin,
 			mockStorageBehavior: func(ms *MockStorage) {
+				ms.On("GetOrganization", "test-org").Return(&models.Organization{ID: "test-org", ResourceVersion: 2}, nil)
+				ms.On("UpdateOrganization", mock.AnythingOfType("*models.Organization")).Return(nil)
 				// Check for dependent VDCs
 				ms.On("ListVDCs", "test-org").Return([]*models.VirtualDataCenter{}, nil)
+				// Move the organization to the trash
+				ms.On("DeleteOrganization", "test-org").Return(nil)
 			},
 			mockK8sBehavior: func(mk *MockK8sClient) {
 				// Mock getting existing organization
@@ -363,10 +457,10 @@ func TestOrganizationHandlers_Delete_CRDOnly(t *testing.T) {
 					org := args.Get(2).(*ovimv1.Organization)
 					org.Name = "test-org"
 				})
-				// Mock update for deletion annotations
-				mk.On("Update", mock.Anything, mock.AnythingOfType("*v1.Organization"), mock.Anything).Return(nil)
-				// Mock delete
-				mk.On("Delete", mock.Anything, mock.AnythingOfType("*v1.Organization"), mock.Anything).Return(nil)
+				// Mock update marking the CRD as trashed, the CRD itself is kept
+				mk.On("Update", mock.Anything, mock.MatchedBy(func(org *ovimv1.Organization) bool {
+					return org.Annotations[ovimv1.TrashedAnnotation] != "" && org.Annotations["ovim.io/deleted-by"] == "admin"
+				}), mock.Anything).Return(nil)
 			},
 			expectedStatus: http.StatusNoContent,
 		},
@@ -375,12 +469,15 @@ func TestOrganizationHandlers_Delete_CRDOnly(t *testing.T) {
 			orgID:    "test-org",
 			userRole: models.RoleSystemAdmin,
 			mockStorageBehavior: func(ms *MockStorage) {
+				ms.On("GetOrganization", "test-org").Return(&models.Organization{ID: "test-org", ResourceVersion: 2}, nil)
+				ms.On("UpdateOrganization", mock.AnythingOfType("*models.Organization")).Return(nil)
 				ms.On("ListVDCs", "test-org").Return([]*models.VirtualDataCenter{
 					{ID: "vdc1", Name: "VDC 1"},
 				}, nil)
 			},
 			mockK8sBehavior: func(mk *MockK8sClient) {
-				// No K8s calls expected
+				// The CRD is read but not updated
+				mk.On("Get", mock.Anything, mock.AnythingOfType("types.NamespacedName"), mock.AnythingOfType("*v1.Organization"), mock.Anything).Return(nil)
 			},
 			expectedStatus: http.StatusConflict,
 		},
@@ -389,7 +486,7 @@ func TestOrganizationHandlers_Delete_CRDOnly(t *testing.T) {
 			orgID:    "nonexistent",
 			userRole: models.RoleSystemAdmin,
 			mockStorageBehavior: func(ms *MockStorage) {
-				ms.On("ListVDCs", "nonexistent").Return([]*models.VirtualDataCenter{}, nil)
+				// No calls expected
 			},
 			mockK8sBehavior: func(mk *MockK8sClient) {
 				mk.On("Get", mock.Anything, mock.AnythingOfType("types.NamespacedName"), mock.AnythingOfType("*v1.Organization"), mock.Anything).Return(kerrors.NewNotFound(schema.GroupResource{Group: "ovim.io", Resource: "organizations"}, "nonexistent"))
@@ -834,3 +931,54 @@ func TestOrganizationHandlers_ValidateResourceAllocation(t *testing.T) {
 		})
 	}
 }
+
+func TestOrganizationHandlers_Delete_RollsBackOnCRDFailure(t *testing.T) {
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+	require.NoError(t, store.CreateOrganization(&models.Organization{ID: "test-org", Name: "Test Org"}))
+	require.NoError(t, store.CreateUser(&models.User{ID: "member", Username: "member", Role: models.RoleOrgUser, OrgID: stringPtr("test-org")}))
+	require.NoError(t, store.CreateOrganizationCatalogSource(&models.OrganizationCatalogSource{ID: "source1", OrgID: "test-org"}))
+
+	mockK8sClient := &MockK8sClient{}
+	mockK8sClient.On("Get", mock.Anything, mock.AnythingOfType("types.NamespacedName"), mock.AnythingOfType("*v1.Organization"), mock.Anything).Return(nil)
+	mockK8sClient.On("Update", mock.Anything, mock.AnythingOfType("*v1.Organization"), mock.Anything).Return(fmt.Errorf("api server unavailable"))
+
+	handlers := NewOrganizationHandlers(store, mockK8sClient, nil)
+	c, w := setupGinContext("DELETE", "/organizations/test-org", nil, "user1", "admin", models.RoleSystemAdmin, "")
+	c.Params = []gin.Param{{Key: "id", Value: "test-org"}}
+
+	handlers.Delete(c)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// The organization was taken back out of the trash and neither the member nor the catalog
+	// source was touched
+	_, err = store.GetOrganization("test-org")
+	assert.NoError(t, err)
+	user, err := store.GetUserByID("member")
+	require.NoError(t, err)
+	require.NotNil(t, user.OrgID)
+	assert.Equal(t, "test-org", *user.OrgID)
+	_, err = store.GetOrganizationCatalogSource("source1")
+	assert.NoError(t, err)
+}
+
+func TestOrganizationHandlers_UpdateMFA(t *testing.T) {
+	store := setupRoleStorage(t)
+	handlers := NewOrganizationHandlers(store, nil, nil)
+	update := func(role, userOrgID, orgID string, body interface{}) int {
+		c, w := roleContext(store, http.MethodPut, "/organizations/"+orgID+"/mfa", body, role, userOrgID, gin.Params{{Key: "id", Value: orgID}})
+		handlers.UpdateMFA(c)
+		return w.Code
+	}
+	required := true
+
+	assert.Equal(t, http.StatusBadRequest, update(models.RoleSystemAdmin, "", "org-1", gin.H{}))
+	assert.Equal(t, http.StatusForbidden, update(models.RoleOrgAdmin, "org-1", "org-1", OrganizationMFARequest{Required: &required}))
+	assert.Equal(t, http.StatusNotFound, update(models.RoleSystemAdmin, "", "unknown", OrganizationMFARequest{Required: &required}))
+	require.Equal(t, http.StatusOK, update(models.RoleSystemAdmin, "", "org-1", OrganizationMFARequest{Required: &required}))
+
+	org, err := store.GetOrganization("org-1")
+	require.NoError(t, err)
+	assert.True(t, org.RequireMFA)
+}
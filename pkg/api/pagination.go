@@ -0,0 +1,41 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// parseListOptions reads the common list query parameters (limit, cursor, sort, status, name)
+func parseListOptions(c *gin.Context) (storage.ListOptions, error) {
+	opts := storage.ListOptions{
+		Cursor: c.Query("cursor"),
+		Sort:   c.Query("sort"),
+		Status: c.Query("status"),
+		Name:   c.Query("name"),
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("invalid limit %q", limit)
+		}
+		opts.Limit = n
+	}
+
+	return opts, nil
+}
+
+// respondListError writes the response for a failed Query* call, mapping invalid options to 400
+func respondListError(c *gin.Context, err error, message string) {
+	if errors.Is(err, storage.ErrInvalidInput) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+	"github.com/eliorerz/ovim-updated/pkg/util"
+)
+
+// DefaultPasswordResetTokenDuration is how long a password reset token can be used
+const DefaultPasswordResetTokenDuration = 24 * time.Hour
+
+var (
+	// errInvalidResetToken is returned for reset tokens that are unknown, expired or used
+	errInvalidResetToken = errors.New("invalid or expired password reset token")
+
+	// errNoPassword is returned for users who log in through an identity provider
+	errNoPassword = errors.New("user has no password")
+)
+
+// PasswordManager sets the passwords of users according to the password policy
+type PasswordManager struct {
+	storage            storage.Storage
+	policy             auth.PasswordPolicy
+	resetTokenDuration time.Duration
+	now                func() time.Time
+}
+
+// NewPasswordManager creates a password manager enforcing policy. Reset tokens it issues
+// expire after resetTokenDuration, DefaultPasswordResetTokenDuration if zero.
+func NewPasswordManager(storage storage.Storage, policy auth.PasswordPolicy, resetTokenDuration time.Duration) *PasswordManager {
+	if resetTokenDuration <= 0 {
+		resetTokenDuration = DefaultPasswordResetTokenDuration
+	}
+	return &PasswordManager{
+		storage:            storage,
+		policy:             policy,
+		resetTokenDuration: resetTokenDuration,
+		now:                time.Now,
+	}
+}
+
+// Validate checks a new password against the policy, returning a *auth.PasswordPolicyError
+func (m *PasswordManager) Validate(password string) error {
+	return m.policy.Validate(password)
+}
+
+// ChangeRequired reports whether the user has to change the password before doing anything
+// else, because an administrator asked for it or because the password is too old
+func (m *PasswordManager) ChangeRequired(user *models.User) bool {
+	if user.MustChangePassword {
+		return true
+	}
+	if user.PasswordHash == "" {
+		return false
+	}
+	changedAt := user.CreatedAt
+	if user.PasswordChangedAt != nil {
+		changedAt = *user.PasswordChangedAt
+	}
+	return m.policy.Expired(changedAt, m.now())
+}
+
+// Change replaces the password of the user, which is updated in place. It returns a
+// *auth.PasswordPolicyError or auth.ErrPasswordReused if the policy does not allow the password.
+// Changing the password ends every session of the user.
+func (m *PasswordManager) Change(user *models.User, password string) error {
+	return m.storage.WithTx(func(tx storage.Storage) error {
+		return m.change(tx, user, password)
+	})
+}
+
+func (m *PasswordManager) change(tx storage.Storage, user *models.User, password string) error {
+	if user.PasswordHash == "" {
+		return errNoPassword
+	}
+	if err := m.policy.Validate(password); err != nil {
+		return err
+	}
+	if err := m.policy.CheckReuse(password, user.PasswordHash, user.PasswordHistory); err != nil {
+		return err
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	now := m.now()
+	user.PasswordHistory = m.policy.PreviousHashes(user.PasswordHash, user.PasswordHistory)
+	user.PasswordHash = hash
+	user.PasswordChangedAt = &now
+	user.MustChangePassword = false
+	user.UpdatedAt = now
+	if err := tx.UpdateUser(user); err != nil {
+		return err
+	}
+	return tx.RevokeUserTokens(user.ID)
+}
+
+// IssueResetToken creates a one-time token letting the user set a new password without the
+// current one, and returns it with its record
+func (m *PasswordManager) IssueResetToken(user *models.User, createdBy string) (string, *models.PasswordResetToken, error) {
+	if user.PasswordHash == "" {
+		return "", nil, errNoPassword
+	}
+	token, hash, err := auth.NewPasswordResetToken()
+	if err != nil {
+		return "", nil, err
+	}
+	id, err := util.GenerateID(16)
+	if err != nil {
+		return "", nil, err
+	}
+
+	record := &models.PasswordResetToken{
+		ID:        id,
+		UserID:    user.ID,
+		TokenHash: hash,
+		CreatedBy: createdBy,
+		ExpiresAt: m.now().Add(m.resetTokenDuration),
+	}
+	if err := m.storage.CreatePasswordResetToken(record); err != nil {
+		return "", nil, fmt.Errorf("failed to store password reset token: %w", err)
+	}
+	return token, record, nil
+}
+
+// Reset sets a new password with a reset token and returns the user. Tokens issued before the
+// password last changed can no longer be used.
+func (m *PasswordManager) Reset(token, password string) (*models.User, error) {
+	var user *models.User
+	err := m.storage.WithTx(func(tx storage.Storage) error {
+		record, err := tx.GetPasswordResetTokenByHash(auth.HashPasswordResetToken(token))
+		if err == storage.ErrNotFound {
+			return errInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+		now := m.now()
+		if record.UsedAt != nil || !record.ExpiresAt.After(now) {
+			return errInvalidResetToken
+		}
+
+		user, err = tx.GetUserByID(record.UserID)
+		if err == storage.ErrNotFound {
+			return errInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+		if user.PasswordChangedAt != nil && user.PasswordChangedAt.After(record.CreatedAt) {
+			return errInvalidResetToken
+		}
+
+		if err := m.change(tx, user, password); err != nil {
+			return err
+		}
+		if err := tx.UsePasswordResetToken(record.ID, now); err != nil {
+			if err == storage.ErrConflict {
+				return errInvalidResetToken
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
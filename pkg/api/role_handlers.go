@@ -0,0 +1,321 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/eliorerz/ovim-updated/pkg/authz"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+	"github.com/eliorerz/ovim-updated/pkg/util"
+)
+
+// roleNamePattern is the form of custom role names, which end up in tokens and user records
+var roleNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// RoleHandlers handles the custom roles of organizations
+type RoleHandlers struct {
+	storage storage.Storage
+}
+
+// NewRoleHandlers creates a new role handlers instance
+func NewRoleHandlers(storage storage.Storage) *RoleHandlers {
+	return &RoleHandlers{
+		storage: storage,
+	}
+}
+
+// CreateRoleRequest represents the request body for creating a custom role
+type CreateRoleRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description"`
+	Verbs       []string `json:"verbs" binding:"required"`
+}
+
+// UpdateRoleRequest represents the request body for updating a custom role
+type UpdateRoleRequest struct {
+	Name        *string  `json:"name"`
+	Description *string  `json:"description"`
+	Verbs       []string `json:"verbs"`
+}
+
+// List handles listing the custom roles of an organization, along with the verbs roles may grant
+func (h *RoleHandlers) List(c *gin.Context) {
+	orgID := c.Param("id")
+	if !authorize(c, authz.RoleList, authz.InOrganization(orgID)) {
+		return
+	}
+
+	roles, err := h.storage.ListOrgRoles(orgID)
+	if err != nil {
+		klog.Errorf("Failed to list roles of organization %s: %v", orgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list roles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"roles": roles,
+		"total": len(roles),
+		"verbs": authz.Verbs(),
+	})
+}
+
+// Get handles getting a custom role of an organization
+func (h *RoleHandlers) Get(c *gin.Context) {
+	orgID := c.Param("id")
+	if !authorize(c, authz.RoleList, authz.InOrganization(orgID)) {
+		return
+	}
+
+	role, ok := h.getOrgRole(c, orgID, c.Param("roleId"))
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+// Create handles adding a custom role to an organization
+func (h *RoleHandlers) Create(c *gin.Context) {
+	orgID := c.Param("id")
+	if !authorize(c, authz.RoleManage, authz.InOrganization(orgID)) {
+		return
+	}
+
+	var req CreateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		klog.V(4).Infof("Invalid create role request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	name, ok := validateRoleName(c, req.Name)
+	if !ok {
+		return
+	}
+	verbs, ok := h.validateRoleVerbs(c, orgID, req.Verbs)
+	if !ok {
+		return
+	}
+
+	if _, err := h.storage.GetOrganization(orgID); err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			return
+		}
+		klog.Errorf("Failed to get organization %s: %v", orgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get organization"})
+		return
+	}
+
+	generatedID, err := util.GenerateID(8)
+	if err != nil {
+		klog.Errorf("Failed to generate ID for role: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate ID"})
+		return
+	}
+
+	role := &models.OrgRole{
+		ID:          "role-" + generatedID,
+		OrgID:       orgID,
+		Name:        name,
+		Description: strings.TrimSpace(req.Description),
+		Verbs:       verbs,
+	}
+	if err := h.storage.CreateOrgRole(role); err != nil {
+		if err == storage.ErrAlreadyExists {
+			c.JSON(http.StatusConflict, gin.H{"error": "A role with this name already exists in the organization"})
+			return
+		}
+		klog.Errorf("Failed to create role for org %s: %v", orgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role"})
+		return
+	}
+
+	klog.Infof("Created role %s (%s) for organization %s", role.Name, role.ID, orgID)
+	c.JSON(http.StatusCreated, role)
+}
+
+// Update handles changing a custom role of an organization. A role assigned to users cannot be
+// renamed, as users hold their role by name.
+func (h *RoleHandlers) Update(c *gin.Context) {
+	orgID := c.Param("id")
+	if !authorize(c, authz.RoleManage, authz.InOrganization(orgID)) {
+		return
+	}
+
+	var req UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		klog.V(4).Infof("Invalid update role request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	role, ok := h.getOrgRole(c, orgID, c.Param("roleId"))
+	if !ok {
+		return
+	}
+
+	if req.Name != nil {
+		name, ok := validateRoleName(c, *req.Name)
+		if !ok {
+			return
+		}
+		if name != role.Name {
+			if ok := h.checkUnassigned(c, role, "A role assigned to users cannot be renamed"); !ok {
+				return
+			}
+			role.Name = name
+		}
+	}
+	if req.Description != nil {
+		role.Description = strings.TrimSpace(*req.Description)
+	}
+	if req.Verbs != nil {
+		verbs, ok := h.validateRoleVerbs(c, orgID, req.Verbs)
+		if !ok {
+			return
+		}
+		role.Verbs = verbs
+	}
+
+	if err := h.storage.UpdateOrgRole(role); err != nil {
+		switch err {
+		case storage.ErrAlreadyExists:
+			c.JSON(http.StatusConflict, gin.H{"error": "A role with this name already exists in the organization"})
+		case storage.ErrNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		default:
+			klog.Errorf("Failed to update role %s: %v", role.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		}
+		return
+	}
+
+	klog.Infof("Updated role %s (%s) of organization %s", role.Name, role.ID, orgID)
+	c.JSON(http.StatusOK, role)
+}
+
+// Delete handles removing a custom role from an organization, which must not be assigned to users
+func (h *RoleHandlers) Delete(c *gin.Context) {
+	orgID := c.Param("id")
+	if !authorize(c, authz.RoleManage, authz.InOrganization(orgID)) {
+		return
+	}
+
+	role, ok := h.getOrgRole(c, orgID, c.Param("roleId"))
+	if !ok {
+		return
+	}
+	if !h.checkUnassigned(c, role, "A role assigned to users cannot be deleted") {
+		return
+	}
+
+	if err := h.storage.DeleteOrgRole(role.ID); err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			return
+		}
+		klog.Errorf("Failed to delete role %s: %v", role.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+		return
+	}
+
+	klog.Infof("Deleted role %s (%s) from organization %s", role.Name, role.ID, orgID)
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// getOrgRole loads a role of the organization in the request path, responding with 404 for roles
+// of other organizations
+func (h *RoleHandlers) getOrgRole(c *gin.Context, orgID, roleID string) (*models.OrgRole, bool) {
+	role, err := h.storage.GetOrgRole(roleID)
+	if err != nil && err != storage.ErrNotFound {
+		klog.Errorf("Failed to get role %s: %v", roleID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get role"})
+		return nil, false
+	}
+	if err == storage.ErrNotFound || role.OrgID != orgID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return nil, false
+	}
+	return role, true
+}
+
+// checkUnassigned responds with 409 if users or members of the organization hold the role
+func (h *RoleHandlers) checkUnassigned(c *gin.Context, role *models.OrgRole, message string) bool {
+	users, err := h.storage.ListUsersByOrg(role.OrgID)
+	if err != nil {
+		klog.Errorf("Failed to list users of organization %s: %v", role.OrgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list users"})
+		return false
+	}
+	for _, user := range users {
+		if user.Role == role.Name {
+			c.JSON(http.StatusConflict, gin.H{"error": message})
+			return false
+		}
+	}
+
+	memberships, err := h.storage.ListOrgMemberships("", role.OrgID)
+	if err != nil {
+		klog.Errorf("Failed to list memberships of organization %s: %v", role.OrgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list memberships"})
+		return false
+	}
+	for _, membership := range memberships {
+		if membership.Role == role.Name {
+			c.JSON(http.StatusConflict, gin.H{"error": message})
+			return false
+		}
+	}
+	return true
+}
+
+// validateRoleName checks the name of a custom role, which must not shadow a built-in role
+func validateRoleName(c *gin.Context, name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if !roleNamePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role name must be 1 to 63 lowercase letters, digits, '-' or '_'"})
+		return "", false
+	}
+	if authz.IsBuiltinRole(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role name is reserved for a built-in role"})
+		return "", false
+	}
+	return name, true
+}
+
+// validateRoleVerbs checks the verbs of a custom role. The caller must hold every verb, wildcards
+// included, within the organization, so that nobody grants more than they have.
+func (h *RoleHandlers) validateRoleVerbs(c *gin.Context, orgID string, requested []string) (models.JSONBArray, bool) {
+	if len(requested) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one verb is required"})
+		return nil, false
+	}
+
+	permissions, err := authz.FromContext(c).Permissions(c)
+	if !respondAuthzError(c, authz.RoleManage, err) {
+		return nil, false
+	}
+
+	verbs := make(models.JSONBArray, 0, len(requested))
+	for _, name := range requested {
+		verb := authz.Verb(strings.TrimSpace(name))
+		if !authz.ValidVerb(verb) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown verb: " + string(verb)})
+			return nil, false
+		}
+		for _, granted := range authz.Expand(verb) {
+			if !permissions.Allows(granted, authz.InOrganization(orgID)) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Cannot grant a verb you do not hold", "verb": granted})
+				return nil, false
+			}
+		}
+		if !util.ContainsString(verbs, string(verb)) {
+			verbs = append(verbs, string(verb))
+		}
+	}
+	return verbs, true
+}
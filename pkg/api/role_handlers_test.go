@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/authz"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// setupRoleStorage returns a storage with two organizations, an "operator" role of org-1 and a
+// user of org-1 holding it
+func setupRoleStorage(t *testing.T) storage.Storage {
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+	require.NoError(t, store.CreateOrganization(&models.Organization{ID: "org-1", Name: "Org 1", Namespace: "org-org-1"}))
+	require.NoError(t, store.CreateOrganization(&models.Organization{ID: "org-2", Name: "Org 2", Namespace: "org-org-2"}))
+	require.NoError(t, store.CreateOrgRole(&models.OrgRole{ID: "role-operator", OrgID: "org-1", Name: "operator", Verbs: models.JSONBArray{"vm:list", "vm:get", "vm:power"}}))
+	require.NoError(t, store.CreateUser(&models.User{ID: "user-op", Username: "op", Email: "op@example.com", Role: "operator", OrgID: stringPtr("org-1")}))
+	return store
+}
+
+// roleContext returns a request context authorized against the custom roles of store
+func roleContext(store storage.Storage, method, url string, body interface{}, role, orgID string, params gin.Params) (*gin.Context, *httptest.ResponseRecorder) {
+	c, w := setupGinContext(method, url, body, "caller", "caller", role, orgID)
+	c.Params = params
+	authz.NewAuthorizer(store).Middleware()(c)
+	return c, w
+}
+
+func TestRoleHandlers_Create(t *testing.T) {
+	tests := []struct {
+		name           string
+		role           string
+		userOrgID      string
+		orgID          string
+		body           CreateRoleRequest
+		expectedStatus int
+	}{
+		{"org admin creates a role", models.RoleOrgAdmin, "org-1", "org-1", CreateRoleRequest{Name: "auditor", Verbs: []string{"vdc:list", "vm:list"}}, http.StatusCreated},
+		{"system admin grants any verb", models.RoleSystemAdmin, "", "org-1", CreateRoleRequest{Name: "restorer", Verbs: []string{"*"}}, http.StatusCreated},
+		{"org admin cannot grant verbs it does not hold", models.RoleOrgAdmin, "org-1", "org-1", CreateRoleRequest{Name: "exporter", Verbs: []string{"backup:export"}}, http.StatusForbidden},
+		{"org admin cannot grant wildcards beyond its verbs", models.RoleOrgAdmin, "org-1", "org-1", CreateRoleRequest{Name: "vms", Verbs: []string{"vm:*"}}, http.StatusForbidden},
+		{"org admin of another organization", models.RoleOrgAdmin, "org-2", "org-1", CreateRoleRequest{Name: "auditor", Verbs: []string{"vdc:list"}}, http.StatusForbidden},
+		{"org user", models.RoleOrgUser, "org-1", "org-1", CreateRoleRequest{Name: "auditor", Verbs: []string{"vdc:list"}}, http.StatusForbidden},
+		{"built-in role name", models.RoleOrgAdmin, "org-1", "org-1", CreateRoleRequest{Name: models.RoleOrgMember, Verbs: []string{"vdc:list"}}, http.StatusBadRequest},
+		{"invalid role name", models.RoleOrgAdmin, "org-1", "org-1", CreateRoleRequest{Name: "Ops Team", Verbs: []string{"vdc:list"}}, http.StatusBadRequest},
+		{"unknown verb", models.RoleOrgAdmin, "org-1", "org-1", CreateRoleRequest{Name: "auditor", Verbs: []string{"vm:reboot"}}, http.StatusBadRequest},
+		{"duplicate name", models.RoleOrgAdmin, "org-1", "org-1", CreateRoleRequest{Name: "operator", Verbs: []string{"vm:list"}}, http.StatusConflict},
+		{"missing organization", models.RoleSystemAdmin, "", "org-3", CreateRoleRequest{Name: "auditor", Verbs: []string{"vdc:list"}}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := setupRoleStorage(t)
+			handlers := NewRoleHandlers(store)
+
+			c, w := roleContext(store, http.MethodPost, "/organizations/"+tt.orgID+"/roles", tt.body, tt.role, tt.userOrgID, gin.Params{{Key: "id", Value: tt.orgID}})
+			handlers.Create(c)
+
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			if tt.expectedStatus == http.StatusCreated {
+				var role models.OrgRole
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &role))
+				assert.Equal(t, tt.orgID, role.OrgID)
+
+				stored, err := store.GetOrgRoleByName(tt.orgID, tt.body.Name)
+				require.NoError(t, err)
+				assert.Equal(t, role.ID, stored.ID)
+				assert.Equal(t, role.Verbs, stored.Verbs)
+			}
+		})
+	}
+}
+
+func TestRoleHandlers_List(t *testing.T) {
+	store := setupRoleStorage(t)
+	handlers := NewRoleHandlers(store)
+
+	c, w := roleContext(store, http.MethodGet, "/organizations/org-1/roles", nil, models.RoleOrgAdmin, "org-1", gin.Params{{Key: "id", Value: "org-1"}})
+	handlers.List(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Roles []models.OrgRole `json:"roles"`
+		Total int              `json:"total"`
+		Verbs []string         `json:"verbs"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 1, response.Total)
+	assert.Equal(t, "operator", response.Roles[0].Name)
+	assert.Contains(t, response.Verbs, "vm:power")
+
+	c, w = roleContext(store, http.MethodGet, "/organizations/org-1/roles", nil, models.RoleOrgMember, "org-1", gin.Params{{Key: "id", Value: "org-1"}})
+	handlers.List(c)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestRoleHandlers_UpdateAndDelete(t *testing.T) {
+	store := setupRoleStorage(t)
+	handlers := NewRoleHandlers(store)
+	params := gin.Params{{Key: "id", Value: "org-1"}, {Key: "roleId", Value: "role-operator"}}
+
+	t.Run("role of another organization", func(t *testing.T) {
+		c, w := roleContext(store, http.MethodGet, "/organizations/org-2/roles/role-operator", nil, models.RoleSystemAdmin, "", gin.Params{{Key: "id", Value: "org-2"}, {Key: "roleId", Value: "role-operator"}})
+		handlers.Get(c)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("assigned role cannot be renamed", func(t *testing.T) {
+		c, w := roleContext(store, http.MethodPut, "/organizations/org-1/roles/role-operator", UpdateRoleRequest{Name: stringPtr("ops")}, models.RoleOrgAdmin, "org-1", params)
+		handlers.Update(c)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("verbs of an assigned role can change", func(t *testing.T) {
+		c, w := roleContext(store, http.MethodPut, "/organizations/org-1/roles/role-operator", UpdateRoleRequest{Verbs: []string{"vm:list", "vm:get"}}, models.RoleOrgAdmin, "org-1", params)
+		handlers.Update(c)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		role, err := store.GetOrgRole("role-operator")
+		require.NoError(t, err)
+		assert.Equal(t, models.JSONBArray{"vm:list", "vm:get"}, role.Verbs)
+	})
+
+	t.Run("assigned role cannot be deleted", func(t *testing.T) {
+		c, w := roleContext(store, http.MethodDelete, "/organizations/org-1/roles/role-operator", nil, models.RoleOrgAdmin, "org-1", params)
+		handlers.Delete(c)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("unassigned role is deleted", func(t *testing.T) {
+		require.NoError(t, store.DeleteUser("user-op"))
+
+		c, w := roleContext(store, http.MethodDelete, "/organizations/org-1/roles/role-operator", nil, models.RoleOrgAdmin, "org-1", params)
+		handlers.Delete(c)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		_, err := store.GetOrgRole("role-operator")
+		assert.Equal(t, storage.ErrNotFound, err)
+	})
+}
+
+func TestRoleHandlers_CustomRoleCaller(t *testing.T) {
+	store := setupRoleStorage(t)
+	require.NoError(t, store.CreateOrgRole(&models.OrgRole{ID: "role-manager", OrgID: "org-1", Name: "role-manager", Verbs: models.JSONBArray{"role:*", "vm:list"}}))
+	handlers := NewRoleHandlers(store)
+	params := gin.Params{{Key: "id", Value: "org-1"}}
+
+	c, w := roleContext(store, http.MethodPost, "/organizations/org-1/roles", CreateRoleRequest{Name: "viewer", Verbs: []string{"vm:list", "vm:list"}}, "role-manager", "org-1", params)
+	handlers.Create(c)
+	require.Equal(t, http.StatusCreated, w.Code, "a custom role grants its verbs within the organization")
+	role, err := store.GetOrgRoleByName("org-1", "viewer")
+	require.NoError(t, err)
+	assert.Equal(t, models.JSONBArray{"vm:list"}, role.Verbs, "duplicate verbs are dropped")
+
+	c, w = roleContext(store, http.MethodPost, "/organizations/org-1/roles", CreateRoleRequest{Name: "powerer", Verbs: []string{"vm:power"}}, "role-manager", "org-1", params)
+	handlers.Create(c)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,869 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/scim"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+	"github.com/eliorerz/ovim-updated/pkg/util"
+)
+
+const (
+	// SCIMPrefix is where the SCIM 2.0 provisioning service is served
+	SCIMPrefix = "/scim/v2"
+
+	// scimMaxResults is the most resources a SCIM query returns
+	scimMaxResults = 200
+)
+
+// scimGroupRoles are the built-in roles that have a SCIM group in every organization
+var scimGroupRoles = []string{models.RoleOrgAdmin, models.RoleOrgUser, models.RoleOrgMember}
+
+// SCIMHandlers serves the SCIM 2.0 service identity providers provision users and groups with.
+//
+// SCIM users are the OVIM users other than system administrators, which identity providers can
+// neither see nor change. Users are created without a password and log in through the identity
+// provider. Deactivating a user disables it and revokes its sessions and API tokens.
+//
+// SCIM groups are the roles of organizations: the group "<org ID>:<role>" exists for every
+// built-in role but system_admin and every custom role of an organization, and cannot be
+// created, renamed or deleted otherwise. Its members are the users with the role in the
+// organization, either as their own organization or as a membership. A user has one role in an
+// organization, so adding it to a group of an organization changes its role there.
+type SCIMHandlers struct {
+	storage   storage.Storage
+	tokenHash [sha256.Size]byte
+}
+
+// NewSCIMHandlers creates the SCIM handlers of identity providers authenticating with token
+func NewSCIMHandlers(storage storage.Storage, token string) *SCIMHandlers {
+	return &SCIMHandlers{
+		storage:   storage,
+		tokenHash: sha256.Sum256([]byte(token)),
+	}
+}
+
+// Authenticate rejects requests without the bearer token of the SCIM service. Hashes of the
+// tokens are compared so that the comparison takes the same time whatever token is presented.
+func (h *SCIMHandlers) Authenticate(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	presented := sha256.Sum256([]byte(token))
+	if !ok || token == "" || subtle.ConstantTimeCompare(presented[:], h.tokenHash[:]) != 1 {
+		klog.V(4).Infof("Rejected SCIM request from %s without a valid token", c.ClientIP())
+		c.Header("WWW-Authenticate", `Bearer realm="ovim-scim"`)
+		respondSCIM(c, http.StatusUnauthorized, scim.NewError(http.StatusUnauthorized, "", "Invalid or missing bearer token"))
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// GetServiceProviderConfig handles telling identity providers which features the service has
+func (h *SCIMHandlers) GetServiceProviderConfig(c *gin.Context) {
+	respondSCIM(c, http.StatusOK, &scim.ServiceProviderConfig{
+		Schemas: []string{scim.SchemaServiceProviderConfig},
+		Patch:   scim.Supported{Supported: true},
+		Filter:  scim.FilterSupport{Supported: true, MaxResults: scimMaxResults},
+		AuthenticationSchemes: []scim.AuthScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "Bearer Token",
+			Description: "The SCIM token of the OVIM server",
+			Primary:     true,
+		}},
+	})
+}
+
+// ListUsers handles querying users with the filter, startIndex and count query parameters
+func (h *SCIMHandlers) ListUsers(c *gin.Context) {
+	query, err := parseSCIMQuery(c)
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+
+	users, err := h.storage.ListUsers()
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	memberships, err := h.storage.ListOrgMemberships("", "")
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	membershipsByUser := make(map[string][]*models.OrgMembership)
+	for _, membership := range memberships {
+		membershipsByUser[membership.UserID] = append(membershipsByUser[membership.UserID], membership)
+	}
+
+	var resources []interface{}
+	for _, user := range users {
+		if user.Role == models.RoleSystemAdmin {
+			continue
+		}
+		resource := toSCIMUser(user, membershipsByUser[user.ID])
+		matches, err := query.matches(resource)
+		if err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+		if matches {
+			resources = append(resources, resource)
+		}
+	}
+	respondSCIM(c, http.StatusOK, query.page(resources))
+}
+
+// GetUser handles getting a user
+func (h *SCIMHandlers) GetUser(c *gin.Context) {
+	user, err := h.getUser(h.storage, c.Param("id"))
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	h.respondUser(c, http.StatusOK, user)
+}
+
+// CreateUser handles provisioning a user. The user has no password nor organization, and gets
+// access to organizations when it is added to their groups. It logs in with OIDC when its
+// externalId is its OIDC subject.
+func (h *SCIMHandlers) CreateUser(c *gin.Context) {
+	var resource scim.User
+	if err := decodeSCIM(c, &resource); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+
+	// Updates only validate the attributes they change, new users need them all
+	if strings.TrimSpace(resource.UserName) == "" || strings.TrimSpace(resource.PrimaryEmail()) == "" {
+		respondSCIMError(c, scim.BadRequest(scim.ErrorInvalidValue, "userName and an email are required"))
+		return
+	}
+
+	userID, err := util.GenerateID(16)
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	now := time.Now()
+	user := &models.User{
+		ID:         userID,
+		Role:       models.RoleOrgMember,
+		AuthSource: models.AuthSourceSCIM,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := setSCIMUserAttributes(user, &resource); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+
+	if err := h.storage.CreateUser(user); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+
+	klog.Infof("SCIM provisioned user %s (active: %t)", user.Username, !user.Disabled)
+	h.respondUser(c, http.StatusCreated, user)
+}
+
+// ReplaceUser handles replacing the attributes of a user
+func (h *SCIMHandlers) ReplaceUser(c *gin.Context) {
+	var resource scim.User
+	if err := decodeSCIM(c, &resource); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	h.updateUser(c, func(*models.User) (*scim.User, error) {
+		return &resource, nil
+	})
+}
+
+// PatchUser handles changing the attributes of a user with PATCH operations, such as setting
+// active to false to deprovision the user
+func (h *SCIMHandlers) PatchUser(c *gin.Context) {
+	var patch scim.PatchRequest
+	if err := decodeSCIM(c, &patch); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	h.updateUser(c, func(user *models.User) (*scim.User, error) {
+		attributes, err := scim.Attributes(toSCIMUser(user, nil))
+		if err != nil {
+			return nil, err
+		}
+		if err := scim.Patch(attributes, patch.Operations); err != nil {
+			return nil, err
+		}
+		var resource scim.User
+		if err := scim.Decode(attributes, &resource); err != nil {
+			return nil, err
+		}
+		return &resource, nil
+	})
+}
+
+// updateUser applies the attributes update returns for a user. Deactivating the user revokes its
+// sessions and API tokens in the same transaction.
+func (h *SCIMHandlers) updateUser(c *gin.Context, update func(user *models.User) (*scim.User, error)) {
+	var user *models.User
+	deprovisioned := false
+	err := h.storage.WithTx(func(tx storage.Storage) error {
+		var err error
+		user, err = h.getUser(tx, c.Param("id"))
+		if err != nil {
+			return err
+		}
+		resource, err := update(user)
+		if err != nil {
+			return err
+		}
+
+		wasDisabled := user.Disabled
+		if err := setSCIMUserAttributes(user, resource); err != nil {
+			return err
+		}
+		user.UpdatedAt = time.Now()
+		if err := tx.UpdateUser(user); err != nil {
+			return err
+		}
+		if user.Disabled && !wasDisabled {
+			deprovisioned = true
+			return revokeUserAccess(tx, user.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+
+	if deprovisioned {
+		klog.Infof("SCIM deprovisioned user %s, its sessions and API tokens are revoked", user.Username)
+	} else {
+		klog.Infof("SCIM updated user %s (active: %t)", user.Username, !user.Disabled)
+	}
+	h.respondUser(c, http.StatusOK, user)
+}
+
+// DeleteUser handles deleting a user, revoking its tokens first like deleting it through the API
+func (h *SCIMHandlers) DeleteUser(c *gin.Context) {
+	var user *models.User
+	err := h.storage.WithTx(func(tx storage.Storage) error {
+		var err error
+		user, err = h.getUser(tx, c.Param("id"))
+		if err != nil {
+			return err
+		}
+		if err := tx.RevokeUserTokens(user.ID); err != nil {
+			return err
+		}
+		return tx.DeleteUser(user.ID)
+	})
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+
+	klog.Infof("SCIM deleted user %s", user.Username)
+	c.Status(http.StatusNoContent)
+}
+
+// getUser returns a user SCIM may manage, or a 404 error
+func (h *SCIMHandlers) getUser(s storage.Storage, id string) (*models.User, error) {
+	user, err := s.GetUserByID(id)
+	if err == storage.ErrNotFound || (err == nil && user.Role == models.RoleSystemAdmin) {
+		return nil, scim.NewError(http.StatusNotFound, "", "User not found")
+	}
+	return user, err
+}
+
+// respondUser responds with a user and the groups it is a member of
+func (h *SCIMHandlers) respondUser(c *gin.Context, status int, user *models.User) {
+	memberships, err := h.storage.ListOrgMemberships(user.ID, "")
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	resource := toSCIMUser(user, memberships)
+	c.Header("Location", resource.Meta.Location)
+	c.Header("ETag", `W/`+formatETag(user.ResourceVersion))
+	respondSCIM(c, status, resource)
+}
+
+// ListGroups handles querying groups with the filter, startIndex and count query parameters
+func (h *SCIMHandlers) ListGroups(c *gin.Context) {
+	query, err := parseSCIMQuery(c)
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+
+	orgs, err := h.storage.ListOrganizations()
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	members, err := listGroupMembers(h.storage, "")
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+
+	var resources []interface{}
+	for _, org := range orgs {
+		roles, err := h.groupRoles(org.ID)
+		if err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+		for _, role := range roles {
+			resource := toSCIMGroup(org, role, members[scimGroupID(org.ID, role)])
+			matches, err := query.matches(resource)
+			if err != nil {
+				respondSCIMError(c, err)
+				return
+			}
+			if matches {
+				resources = append(resources, resource)
+			}
+		}
+	}
+	respondSCIM(c, http.StatusOK, query.page(resources))
+}
+
+// GetGroup handles getting a group with its members
+func (h *SCIMHandlers) GetGroup(c *gin.Context) {
+	org, role, err := h.getGroup(c.Param("id"))
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	h.respondGroup(c, http.StatusOK, org, role)
+}
+
+// CreateGroup handles an identity provider pushing a group. Groups cannot be created, so the
+// group must name an existing one with its displayName, whose members are then set.
+func (h *SCIMHandlers) CreateGroup(c *gin.Context) {
+	var resource scim.Group
+	if err := decodeSCIM(c, &resource); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	org, role, err := h.getGroup(resource.DisplayName)
+	if err != nil {
+		var scimErr *scim.Error
+		if errors.As(err, &scimErr) && scimErr.StatusCode() == http.StatusNotFound {
+			err = scim.BadRequest(scim.ErrorInvalidValue,
+				"Groups cannot be created, displayName must name the group <organization ID>:<role> of an existing role")
+		}
+		respondSCIMError(c, err)
+		return
+	}
+	if !h.setGroupMembers(c, org.ID, role, func([]scim.Member) (*scim.Group, error) {
+		return &resource, nil
+	}) {
+		return
+	}
+	h.respondGroup(c, http.StatusCreated, org, role)
+}
+
+// ReplaceGroup handles replacing the members of a group
+func (h *SCIMHandlers) ReplaceGroup(c *gin.Context) {
+	var resource scim.Group
+	if err := decodeSCIM(c, &resource); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	org, role, err := h.getGroup(c.Param("id"))
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	if !h.setGroupMembers(c, org.ID, role, func([]scim.Member) (*scim.Group, error) {
+		return &resource, nil
+	}) {
+		return
+	}
+	h.respondGroup(c, http.StatusOK, org, role)
+}
+
+// PatchGroup handles adding and removing members of a group with PATCH operations
+func (h *SCIMHandlers) PatchGroup(c *gin.Context) {
+	var patch scim.PatchRequest
+	if err := decodeSCIM(c, &patch); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	org, role, err := h.getGroup(c.Param("id"))
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	if !h.setGroupMembers(c, org.ID, role, func(members []scim.Member) (*scim.Group, error) {
+		attributes, err := scim.Attributes(toSCIMGroup(org, role, members))
+		if err != nil {
+			return nil, err
+		}
+		if err := scim.Patch(attributes, patch.Operations); err != nil {
+			return nil, err
+		}
+		var resource scim.Group
+		if err := scim.Decode(attributes, &resource); err != nil {
+			return nil, err
+		}
+		return &resource, nil
+	}) {
+		return
+	}
+	h.respondGroup(c, http.StatusOK, org, role)
+}
+
+// DeleteGroup handles an identity provider deleting a group, which removes its members from it.
+// The organization and the role stay.
+func (h *SCIMHandlers) DeleteGroup(c *gin.Context) {
+	org, role, err := h.getGroup(c.Param("id"))
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	if !h.setGroupMembers(c, org.ID, role, func([]scim.Member) (*scim.Group, error) {
+		return &scim.Group{DisplayName: scimGroupID(org.ID, role)}, nil
+	}) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// setGroupMembers makes the members of the group update returns from the current members the
+// members of the group of a role in an organization, or responds and returns false. Members are
+// added and removed in one transaction, so that a failure changes nobody's access.
+func (h *SCIMHandlers) setGroupMembers(c *gin.Context, orgID, role string, update func(members []scim.Member) (*scim.Group, error)) bool {
+	groupID := scimGroupID(orgID, role)
+	var added, removed []string
+	err := h.storage.WithTx(func(tx storage.Storage) error {
+		members, err := groupMembers(tx, orgID, role)
+		if err != nil {
+			return err
+		}
+		resource, err := update(members)
+		if err != nil {
+			return err
+		}
+		if resource.DisplayName != "" && !strings.EqualFold(resource.DisplayName, groupID) {
+			return scim.BadRequest(scim.ErrorMutability, "Groups cannot be renamed")
+		}
+
+		current := make(map[string]bool, len(members))
+		for _, member := range members {
+			current[member.Value] = true
+		}
+		wanted := make(map[string]bool, len(resource.Members))
+		for _, member := range resource.Members {
+			if wanted[member.Value] {
+				continue
+			}
+			wanted[member.Value] = true
+			if !current[member.Value] {
+				if err := addGroupMember(tx, member.Value, orgID, role); err != nil {
+					return err
+				}
+				added = append(added, member.Value)
+			}
+		}
+		for _, member := range members {
+			if !wanted[member.Value] {
+				if err := removeGroupMember(tx, member.Value, orgID, role); err != nil {
+					return err
+				}
+				removed = append(removed, member.Value)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		respondSCIMError(c, err)
+		return false
+	}
+
+	if len(added) > 0 || len(removed) > 0 {
+		klog.Infof("SCIM changed the members of group %s: added %v, removed %v", groupID, added, removed)
+	}
+	return true
+}
+
+// addGroupMember gives a user a role in an organization. Users without an organization get it
+// as their own, and so do users of the organization a new role. Users of other organizations
+// get a membership.
+func addGroupMember(tx storage.Storage, userID, orgID, role string) error {
+	user, err := tx.GetUserByID(userID)
+	if err == storage.ErrNotFound || (err == nil && user.Role == models.RoleSystemAdmin) {
+		return scim.BadRequest(scim.ErrorInvalidValue, "User %s not found", userID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if user.OrgID == nil || *user.OrgID == orgID {
+		previousRole, previousOrgID := user.Role, user.OrgID
+		user.Role = role
+		user.OrgID = &orgID
+		user.UpdatedAt = time.Now()
+		if err := updateUserAccess(tx, user, previousRole, previousOrgID); err != nil {
+			return err
+		}
+		// A membership of the user in its own organization would be redundant
+		if err := tx.DeleteOrgMembership(user.ID, orgID); err != nil && err != storage.ErrNotFound {
+			return err
+		}
+		return nil
+	}
+
+	membership, err := tx.GetOrgMembership(user.ID, orgID)
+	if err == storage.ErrNotFound {
+		id, err := util.GenerateID(8)
+		if err != nil {
+			return err
+		}
+		return tx.CreateOrgMembership(&models.OrgMembership{ID: "member-" + id, UserID: user.ID, OrgID: orgID, Role: role})
+	}
+	if err != nil {
+		return err
+	}
+	membership.Role = role
+	return tx.UpdateOrgMembership(membership)
+}
+
+// removeGroupMember takes a role in an organization from a user. Users of the organization leave
+// it, and members lose their membership.
+func removeGroupMember(tx storage.Storage, userID, orgID, role string) error {
+	user, err := tx.GetUserByID(userID)
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if util.StringValue(user.OrgID) == orgID {
+		if user.Role != role {
+			return nil
+		}
+		previousOrgID := user.OrgID
+		user.OrgID = nil
+		user.UpdatedAt = time.Now()
+		return updateUserAccess(tx, user, user.Role, previousOrgID)
+	}
+
+	membership, err := tx.GetOrgMembership(user.ID, orgID)
+	if err == storage.ErrNotFound || (err == nil && membership.Role != role) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return tx.DeleteOrgMembership(user.ID, orgID)
+}
+
+// getGroup returns the organization and role of a group ID, or a 404 error
+func (h *SCIMHandlers) getGroup(id string) (*models.Organization, string, error) {
+	notFound := scim.NewError(http.StatusNotFound, "", "Group not found")
+	separator := strings.LastIndex(id, ":")
+	if separator < 0 {
+		return nil, "", notFound
+	}
+	orgID, role := id[:separator], id[separator+1:]
+
+	org, err := h.storage.GetOrganization(orgID)
+	if err == storage.ErrNotFound {
+		return nil, "", notFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if slices.Contains(scimGroupRoles, role) {
+		return org, role, nil
+	}
+	if _, err := h.storage.GetOrgRoleByName(orgID, role); err != nil {
+		if err == storage.ErrNotFound {
+			return nil, "", notFound
+		}
+		return nil, "", err
+	}
+	return org, role, nil
+}
+
+// groupRoles returns the roles of an organization that have a group
+func (h *SCIMHandlers) groupRoles(orgID string) ([]string, error) {
+	customRoles, err := h.storage.ListOrgRoles(orgID)
+	if err != nil {
+		return nil, err
+	}
+	roles := slices.Clone(scimGroupRoles)
+	for _, role := range customRoles {
+		roles = append(roles, role.Name)
+	}
+	return roles, nil
+}
+
+// respondGroup responds with a group and its members
+func (h *SCIMHandlers) respondGroup(c *gin.Context, status int, org *models.Organization, role string) {
+	members, err := groupMembers(h.storage, org.ID, role)
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	resource := toSCIMGroup(org, role, members)
+	c.Header("Location", resource.Meta.Location)
+	respondSCIM(c, status, resource)
+}
+
+// listGroupMembers returns the members of every group of an organization, or of every
+// organization for an empty orgID, by group ID
+func listGroupMembers(s storage.Storage, orgID string) (map[string][]scim.Member, error) {
+	var users []*models.User
+	var err error
+	if orgID == "" {
+		users, err = s.ListUsers()
+	} else {
+		users, err = s.ListUsersByOrg(orgID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	memberships, err := s.ListOrgMemberships("", orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string][]scim.Member)
+	usernames := make(map[string]string, len(users))
+	for _, user := range users {
+		usernames[user.ID] = user.Username
+		if user.OrgID != nil && user.Role != models.RoleSystemAdmin {
+			groupID := scimGroupID(*user.OrgID, user.Role)
+			members[groupID] = append(members[groupID], scimMember(user.ID, user.Username))
+		}
+	}
+	for _, membership := range memberships {
+		username, ok := usernames[membership.UserID]
+		if !ok {
+			user, err := s.GetUserByID(membership.UserID)
+			if err == storage.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			username = user.Username
+		}
+		groupID := scimGroupID(membership.OrgID, membership.Role)
+		members[groupID] = append(members[groupID], scimMember(membership.UserID, username))
+	}
+	return members, nil
+}
+
+// groupMembers returns the members of the group of a role in an organization
+func groupMembers(s storage.Storage, orgID, role string) ([]scim.Member, error) {
+	members, err := listGroupMembers(s, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return members[scimGroupID(orgID, role)], nil
+}
+
+// scimGroupID returns the ID of the group of a role in an organization, which is also its name
+func scimGroupID(orgID, role string) string {
+	return orgID + ":" + role
+}
+
+func scimMember(userID, username string) scim.Member {
+	return scim.Member{Value: userID, Display: username, Ref: SCIMPrefix + "/Users/" + userID}
+}
+
+// toSCIMUser returns the SCIM resource of a user with the groups of its organization and of its
+// memberships
+func toSCIMUser(user *models.User, memberships []*models.OrgMembership) *scim.User {
+	var groups []scim.GroupRef
+	if user.OrgID != nil {
+		groupID := scimGroupID(*user.OrgID, user.Role)
+		groups = append(groups, scim.GroupRef{Value: groupID, Display: groupID})
+	}
+	for _, membership := range memberships {
+		groupID := scimGroupID(membership.OrgID, membership.Role)
+		groups = append(groups, scim.GroupRef{Value: groupID, Display: groupID})
+	}
+
+	active := scim.Boolean(!user.Disabled)
+	resource := &scim.User{
+		Schemas:  []string{scim.SchemaUser},
+		ID:       user.ID,
+		UserName: user.Username,
+		Emails:   []scim.Email{{Value: user.Email, Type: "work", Primary: true}},
+		Active:   &active,
+		Groups:   groups,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt.UTC(),
+			LastModified: user.UpdatedAt.UTC(),
+			Location:     SCIMPrefix + "/Users/" + user.ID,
+			Version:      `W/` + formatETag(user.ResourceVersion),
+		},
+	}
+	if user.AuthSource == models.AuthSourceSCIM {
+		resource.ExternalID = user.ExternalID
+	}
+	return resource
+}
+
+// toSCIMGroup returns the SCIM resource of the group of a role in an organization
+func toSCIMGroup(org *models.Organization, role string, members []scim.Member) *scim.Group {
+	groupID := scimGroupID(org.ID, role)
+	return &scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          groupID,
+		DisplayName: groupID,
+		Members:     members,
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      org.CreatedAt.UTC(),
+			LastModified: org.UpdatedAt.UTC(),
+			Location:     SCIMPrefix + "/Groups/" + groupID,
+		},
+	}
+}
+
+// setSCIMUserAttributes sets the username, email, externalId and state of a user from its SCIM
+// resource. A resource without active leaves the state unchanged. Only changed attributes are
+// validated, so that users created otherwise can always be deactivated.
+func setSCIMUserAttributes(user *models.User, resource *scim.User) error {
+	username := strings.TrimSpace(resource.UserName)
+	if username != user.Username && (len(username) < 3 || len(username) > 50) {
+		return scim.BadRequest(scim.ErrorInvalidValue, "userName must have 3 to 50 characters")
+	}
+	email := strings.TrimSpace(resource.PrimaryEmail())
+	if email != user.Email && !isValidEmail(email) {
+		return scim.BadRequest(scim.ErrorInvalidValue, "A valid email is required")
+	}
+	user.Username = username
+	user.Email = email
+	// The identities of other sources are theirs
+	if user.AuthSource == models.AuthSourceSCIM {
+		user.ExternalID = strings.TrimSpace(resource.ExternalID)
+	}
+	if resource.Active != nil {
+		user.Disabled = !bool(*resource.Active)
+	}
+	return nil
+}
+
+// revokeUserAccess revokes the sessions and API tokens of a user
+func revokeUserAccess(tx storage.Storage, userID string) error {
+	if err := tx.RevokeUserTokens(userID); err != nil {
+		return err
+	}
+	tokens, err := tx.ListAPITokens(userID)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err := tx.DeleteAPIToken(token.ID); err != nil && err != storage.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// scimQuery is the filter and page of a SCIM query
+type scimQuery struct {
+	filter     scim.Filter
+	startIndex int
+	count      int
+}
+
+// parseSCIMQuery parses the filter, startIndex and count query parameters
+func parseSCIMQuery(c *gin.Context) (*scimQuery, error) {
+	query := &scimQuery{startIndex: 1, count: scimMaxResults}
+	if value := c.Query("filter"); value != "" {
+		filter, err := scim.ParseFilter(value)
+		if err != nil {
+			return nil, err
+		}
+		query.filter = filter
+	}
+	for name, target := range map[string]*int{"startIndex": &query.startIndex, "count": &query.count} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, scim.BadRequest(scim.ErrorInvalidValue, "%s must be an integer", name)
+		}
+		*target = parsed
+	}
+	query.count = min(query.count, scimMaxResults)
+	query.startIndex = max(query.startIndex, 1)
+	return query, nil
+}
+
+// matches reports whether a resource matches the filter of the query
+func (q *scimQuery) matches(resource interface{}) (bool, error) {
+	if q.filter == nil {
+		return true, nil
+	}
+	attributes, err := scim.Attributes(resource)
+	if err != nil {
+		return false, err
+	}
+	return q.filter.Matches(attributes), nil
+}
+
+// page returns the page of the query out of the resources matching it
+func (q *scimQuery) page(resources []interface{}) *scim.ListResponse {
+	start, end := scim.Page(len(resources), q.startIndex, q.count)
+	return scim.NewListResponse(resources[start:end], len(resources), q.startIndex)
+}
+
+// decodeSCIM decodes the JSON body of a SCIM request
+func decodeSCIM(c *gin.Context, v interface{}) error {
+	if err := json.NewDecoder(c.Request.Body).Decode(v); err != nil {
+		return scim.BadRequest(scim.ErrorInvalidSyntax, "Invalid request body: %v", err)
+	}
+	return nil
+}
+
+// respondSCIM responds with a SCIM resource or message
+func respondSCIM(c *gin.Context, status int, body interface{}) {
+	c.Header("Content-Type", scim.ContentType)
+	c.JSON(status, body)
+}
+
+// respondSCIMError responds with the SCIM error of err. Storage conflicts are uniqueness errors,
+// and errors that are neither are logged and hidden from the client.
+func respondSCIMError(c *gin.Context, err error) {
+	var scimErr *scim.Error
+	switch {
+	case errors.As(err, &scimErr):
+	case err == storage.ErrAlreadyExists:
+		scimErr = scim.NewError(http.StatusConflict, scim.ErrorUniqueness, "A user with this userName or email already exists")
+	case err == storage.ErrConflict:
+		scimErr = scim.NewError(http.StatusConflict, "", "The resource was modified concurrently, retry the request")
+	default:
+		klog.Errorf("Failed to serve SCIM request %s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+		scimErr = scim.NewError(http.StatusInternalServerError, "", "Internal server error")
+	}
+	respondSCIM(c, scimErr.StatusCode(), scimErr)
+}
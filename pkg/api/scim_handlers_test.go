@@ -0,0 +1,253 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/scim"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+const testSCIMToken = "scim-test-token-0123456789abcdefghij"
+
+// setupSCIMRouter serves the SCIM service the way the server does next to the auth endpoints,
+// with the organizations of setupRoleStorage, the org user alice of org-1 and a system admin
+func setupSCIMRouter(t *testing.T) (*gin.Engine, storage.Storage) {
+	router, store := setupSessionRouter(t)
+	require.NoError(t, store.CreateOrganization(&models.Organization{ID: "org-1", Name: "Org 1", Namespace: "org-org-1"}))
+	require.NoError(t, store.CreateOrganization(&models.Organization{ID: "org-2", Name: "Org 2", Namespace: "org-org-2"}))
+	require.NoError(t, store.CreateOrgRole(&models.OrgRole{ID: "role-operator", OrgID: "org-1", Name: "operator", Verbs: models.JSONBArray{"vm:list"}}))
+	require.NoError(t, store.CreateUser(&models.User{ID: "root", Username: "root", Email: "root@example.com", Role: models.RoleSystemAdmin}))
+
+	handlers := NewSCIMHandlers(store, testSCIMToken)
+	group := router.Group(SCIMPrefix, handlers.Authenticate)
+	group.GET("/ServiceProviderConfig", handlers.GetServiceProviderConfig)
+	group.GET("/Users", handlers.ListUsers)
+	group.POST("/Users", handlers.CreateUser)
+	group.GET("/Users/:id", handlers.GetUser)
+	group.PUT("/Users/:id", handlers.ReplaceUser)
+	group.PATCH("/Users/:id", handlers.PatchUser)
+	group.DELETE("/Users/:id", handlers.DeleteUser)
+	group.GET("/Groups", handlers.ListGroups)
+	group.POST("/Groups", handlers.CreateGroup)
+	group.GET("/Groups/:id", handlers.GetGroup)
+	group.PUT("/Groups/:id", handlers.ReplaceGroup)
+	group.PATCH("/Groups/:id", handlers.PatchGroup)
+	group.DELETE("/Groups/:id", handlers.DeleteGroup)
+	return router, store
+}
+
+// serveSCIM sends a SCIM request with the SCIM token and a raw JSON body to the router
+func serveSCIM(router *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", scim.ContentType)
+	req.Header.Set("Authorization", "Bearer "+testSCIMToken)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// scimErrorType returns the scimType of a SCIM error response
+func scimErrorType(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var response scim.Error
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response.ScimType
+}
+
+func TestSCIMHandlers_Authenticate(t *testing.T) {
+	router, _ := setupSCIMRouter(t)
+
+	for _, token := range []string{"", "wrong-token", testSCIMToken + "x"} {
+		w := serveJSON(router, http.MethodGet, SCIMPrefix+"/Users", token, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, token)
+		assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+	}
+
+	w := serveSCIM(router, http.MethodGet, SCIMPrefix+"/ServiceProviderConfig", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, scim.ContentType, w.Header().Get("Content-Type"))
+}
+
+func TestSCIMHandlers_Users(t *testing.T) {
+	router, store := setupSCIMRouter(t)
+
+	w := serveSCIM(router, http.MethodPost, SCIMPrefix+"/Users",
+		`{"schemas":["`+scim.SchemaUser+`"],"externalId":"sub-bob","userName":"bob","emails":[{"value":"bob@example.com","primary":true}],"active":true}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created scim.User
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, SCIMPrefix+"/Users/"+created.ID, w.Header().Get("Location"))
+	assert.Equal(t, "sub-bob", created.ExternalID)
+
+	user, err := store.GetUserByID(created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "bob", user.Username)
+	assert.Equal(t, models.RoleOrgMember, user.Role)
+	assert.Nil(t, user.OrgID)
+	assert.Empty(t, user.PasswordHash)
+	assert.False(t, user.Disabled)
+	assert.Equal(t, models.AuthSourceSCIM, user.AuthSource)
+	assert.Equal(t, "sub-bob", user.ExternalID, "the user logs in with the OIDC subject of its externalId")
+
+	w = serveSCIM(router, http.MethodPost, SCIMPrefix+"/Users", `{"userName":"bob","emails":[{"value":"bob2@example.com"}]}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, scim.ErrorUniqueness, scimErrorType(t, w))
+
+	w = serveSCIM(router, http.MethodPost, SCIMPrefix+"/Users", `{"userName":"carol"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, scim.ErrorInvalidValue, scimErrorType(t, w))
+
+	// System admins are hidden from identity providers
+	w = serveSCIM(router, http.MethodGet, SCIMPrefix+"/Users?count=10", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list scim.ListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, 2, list.TotalResults)
+	assert.Equal(t, http.StatusNotFound, serveSCIM(router, http.MethodGet, SCIMPrefix+"/Users/root", "").Code)
+	assert.Equal(t, http.StatusNotFound, serveSCIM(router, http.MethodPatch, SCIMPrefix+"/Users/root",
+		`{"Operations":[{"op":"replace","path":"active","value":false}]}`).Code)
+
+	w = serveSCIM(router, http.MethodGet, SCIMPrefix+"/Users?filter="+url.QueryEscape(`userName eq "BOB"`), "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Equal(t, 1, list.TotalResults)
+	assert.Equal(t, created.ID, list.Resources[0].(map[string]interface{})["id"])
+
+	w = serveSCIM(router, http.MethodGet, SCIMPrefix+"/Users?filter="+url.QueryEscape(`userName eq`), "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, scim.ErrorInvalidFilter, scimErrorType(t, w))
+
+	w = serveSCIM(router, http.MethodPut, SCIMPrefix+"/Users/"+created.ID, `{"userName":"bobby","emails":[{"value":"bobby@example.com"}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	user, err = store.GetUserByID(created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "bobby", user.Username)
+	assert.Equal(t, "bobby@example.com", user.Email)
+
+	w = serveSCIM(router, http.MethodDelete, SCIMPrefix+"/Users/"+created.ID, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	_, err = store.GetUserByID(created.ID)
+	assert.Equal(t, storage.ErrNotFound, err)
+}
+
+func TestSCIMHandlers_DeprovisionUser(t *testing.T) {
+	router, store := setupSCIMRouter(t)
+	session := loginAlice(t, router)
+	expiresAt := time.Now().Add(time.Hour)
+	require.NoError(t, store.CreateAPIToken(&models.APIToken{ID: "token-1", UserID: "alice", Name: "ci", TokenHash: "hash", ExpiresAt: &expiresAt}))
+
+	w := serveSCIM(router, http.MethodPatch, SCIMPrefix+"/Users/alice",
+		`{"schemas":["`+scim.SchemaPatchOp+`"],"Operations":[{"op":"Replace","path":"active","value":"False"}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resource scim.User
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resource))
+	require.NotNil(t, resource.Active)
+	assert.False(t, bool(*resource.Active))
+
+	user, err := store.GetUserByID("alice")
+	require.NoError(t, err)
+	assert.True(t, user.Disabled)
+	tokens, err := store.ListAPITokens("alice")
+	require.NoError(t, err)
+	assert.Empty(t, tokens)
+
+	assert.Equal(t, http.StatusUnauthorized, serveJSON(router, http.MethodGet, "/me", session.Token, nil).Code)
+	assert.NotEqual(t, http.StatusOK, serveJSON(router, http.MethodPost, "/auth/refresh", "", RefreshRequest{RefreshToken: session.RefreshToken}).Code)
+	w = serveJSON(router, http.MethodPost, "/auth/login", "", LoginRequest{Username: "alice", Password: "alicepassword"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = serveSCIM(router, http.MethodPatch, SCIMPrefix+"/Users/alice", `{"Operations":[{"op":"replace","value":{"active":true}}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	loginAlice(t, router)
+}
+
+func TestSCIMHandlers_Groups(t *testing.T) {
+	router, store := setupSCIMRouter(t)
+	require.NoError(t, store.CreateUser(&models.User{ID: "dave", Username: "dave", Email: "dave@example.com", Role: models.RoleOrgMember}))
+	require.NoError(t, store.CreateUser(&models.User{ID: "erin", Username: "erin", Email: "erin@example.com", Role: models.RoleOrgUser, OrgID: stringPtr("org-2")}))
+
+	w := serveSCIM(router, http.MethodGet, SCIMPrefix+"/Groups?filter="+url.QueryEscape(`displayName sw "org-1:"`), "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list scim.ListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, 4, list.TotalResults, "three built-in roles and the operator role")
+
+	// Users without an organization join it, users of another organization get a membership
+	w = serveSCIM(router, http.MethodPatch, SCIMPrefix+"/Groups/org-1:operator",
+		`{"Operations":[{"op":"add","path":"members","value":[{"value":"dave"},{"value":"erin"}]}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var group scim.Group
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &group))
+	assert.Len(t, group.Members, 2)
+
+	dave, err := store.GetUserByID("dave")
+	require.NoError(t, err)
+	assert.Equal(t, "operator", dave.Role)
+	assert.Equal(t, "org-1", *dave.OrgID)
+	membership, err := store.GetOrgMembership("erin", "org-1")
+	require.NoError(t, err)
+	assert.Equal(t, "operator", membership.Role)
+
+	w = serveSCIM(router, http.MethodGet, SCIMPrefix+"/Users/erin", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var erin scim.User
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &erin))
+	assert.ElementsMatch(t, []string{"org-2:org_user", "org-1:operator"}, []string{erin.Groups[0].Value, erin.Groups[1].Value})
+
+	// Moving alice to the admin group changes her role in her organization
+	w = serveSCIM(router, http.MethodPost, SCIMPrefix+"/Groups", `{"displayName":"org-1:org_admin","members":[{"value":"alice"}]}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	alice, err := store.GetUserByID("alice")
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleOrgAdmin, alice.Role)
+
+	w = serveSCIM(router, http.MethodPatch, SCIMPrefix+"/Groups/org-1:operator",
+		`{"Operations":[{"op":"remove","path":"members[value eq \"erin\"]"},{"op":"remove","path":"members[value eq \"dave\"]"}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	_, err = store.GetOrgMembership("erin", "org-1")
+	assert.Equal(t, storage.ErrNotFound, err)
+	dave, err = store.GetUserByID("dave")
+	require.NoError(t, err)
+	assert.Nil(t, dave.OrgID)
+
+	w = serveSCIM(router, http.MethodDelete, SCIMPrefix+"/Groups/org-1:org_admin", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	alice, err = store.GetUserByID("alice")
+	require.NoError(t, err)
+	assert.Nil(t, alice.OrgID)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedType   string
+	}{
+		{"unknown group name", http.MethodPost, "/Groups", `{"displayName":"Engineering"}`, http.StatusBadRequest, scim.ErrorInvalidValue},
+		{"unknown role", http.MethodGet, "/Groups/org-1:auditor", "", http.StatusNotFound, ""},
+		{"system admin group", http.MethodGet, "/Groups/org-1:system_admin", "", http.StatusNotFound, ""},
+		{"unknown organization", http.MethodGet, "/Groups/org-3:org_user", "", http.StatusNotFound, ""},
+		{"rename", http.MethodPatch, "/Groups/org-1:org_user", `{"Operations":[{"op":"replace","path":"displayName","value":"admins"}]}`, http.StatusBadRequest, scim.ErrorMutability},
+		{"unknown member", http.MethodPut, "/Groups/org-1:org_user", `{"displayName":"org-1:org_user","members":[{"value":"nobody"}]}`, http.StatusBadRequest, scim.ErrorInvalidValue},
+		{"system admin member", http.MethodPut, "/Groups/org-1:org_user", `{"members":[{"value":"root"}]}`, http.StatusBadRequest, scim.ErrorInvalidValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveSCIM(router, tt.method, SCIMPrefix+tt.path, tt.body)
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+			assert.Equal(t, tt.expectedType, scimErrorType(t, w))
+		})
+	}
+}
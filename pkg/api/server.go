@@ -2,6 +2,7 @@ package api
 
 import (
 	"net/http"
+	"slices"
 
 	"github.com/gin-gonic/gin"
 	"k8s.io/client-go/kubernetes"
@@ -9,7 +10,9 @@ import (
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	"github.com/eliorerz/ovim-updated/pkg/audit"
 	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
 	"github.com/eliorerz/ovim-updated/pkg/catalog"
 	"github.com/eliorerz/ovim-updated/pkg/config"
 	"github.com/eliorerz/ovim-updated/pkg/kubevirt"
@@ -22,8 +25,25 @@ const (
 	// API version constants
 	APIVersion = "v1"
 	APIPrefix  = "/api/" + APIVersion
+
+	// JWKSPath serves the public keys of the tokens
+	JWKSPath = "/.well-known/jwks.json"
 )
 
+// impersonationBlockedOperations are the operations no impersonation may make whatever the
+// configuration, the credentials of the user remain the user's own
+var impersonationBlockedOperations = []string{
+	"PUT " + APIPrefix + "/profile/password",
+	"* " + APIPrefix + "/profile/mfa",
+	"* " + APIPrefix + "/profile/mfa/enroll",
+	"* " + APIPrefix + "/profile/mfa/activate",
+	"* " + APIPrefix + "/profile/mfa/recovery-codes",
+	"POST " + APIPrefix + "/profile/tokens",
+	"PUT " + APIPrefix + "/profile/tokens/:id",
+	"DELETE " + APIPrefix + "/profile/tokens/:id",
+	"POST " + APIPrefix + "/users/:id/impersonate",
+}
+
 // Server represents the HTTP server for the OVIM API
 type Server struct {
 	config          *config.Config
@@ -32,11 +52,17 @@ type Server struct {
 	authManager     *auth.Middleware
 	tokenManager    *auth.TokenManager
 	oidcProvider    *auth.OIDCProvider
+	ldapProvider    *auth.LDAPProvider
 	k8sClient       client.Client
 	k8sClientset    kubernetes.Interface
 	openshiftClient *openshift.Client
 	catalogService  *catalog.Service
 	eventRecorder   *EventRecorder
+	loginLimiter    *LoginLimiter
+	passwords       *PasswordManager
+	mfa             *MFAManager
+	invitations     *InvitationManager
+	auditRecorder   *AuditRecorder
 	router          *gin.Engine
 }
 
@@ -51,9 +77,14 @@ func NewServer(cfg *config.Config, storage storage.Storage, provisioner kubevirt
 
 	// Create token manager
 	tokenManager := auth.NewTokenManager(cfg.Auth.JWTSecret, cfg.Auth.TokenDuration)
+	tokenManager.SetRefreshDuration(cfg.Auth.RefreshTokenDuration)
 
-	// Create auth middleware
+	// Create auth middleware, rejecting tokens revoked by logout or user changes and accepting
+	// the API tokens of users
 	authManager := auth.NewMiddleware(tokenManager)
+	authManager.SetRevocationList(storage)
+	authManager.SetAPITokenAuthenticator(NewAPITokenAuthenticator(storage))
+	authManager.SetMembershipResolver(NewMembershipResolver(storage))
 
 	// Create OIDC provider if enabled
 	var oidcProvider *auth.OIDCProvider
@@ -66,6 +97,10 @@ func NewServer(cfg *config.Config, storage storage.Storage, provisioner kubevirt
 			ClientSecret: cfg.Auth.OIDC.ClientSecret,
 			RedirectURL:  cfg.Auth.OIDC.RedirectURL,
 			Scopes:       cfg.Auth.OIDC.Scopes,
+			DefaultRole:  cfg.Auth.OIDC.DefaultRole,
+		}
+		for _, rule := range cfg.Auth.OIDC.RoleMappings {
+			authOIDCConfig.RoleMappings = append(authOIDCConfig.RoleMappings, auth.OIDCMappingRule(rule))
 		}
 		oidcProvider, err = auth.NewOIDCProvider(authOIDCConfig)
 		if err != nil {
@@ -77,6 +112,52 @@ func NewServer(cfg *config.Config, storage storage.Storage, provisioner kubevirt
 		}
 	}
 
+	// Create LDAP provider if enabled
+	var ldapProvider *auth.LDAPProvider
+	if cfg.Auth.LDAP.Enabled {
+		var err error
+		authLDAPConfig := &auth.LDAPConfig{
+			Enabled:            cfg.Auth.LDAP.Enabled,
+			URL:                cfg.Auth.LDAP.URL,
+			StartTLS:           cfg.Auth.LDAP.StartTLS,
+			CAFile:             cfg.Auth.LDAP.CAFile,
+			InsecureSkipVerify: cfg.Auth.LDAP.InsecureSkipVerify,
+			BindDN:             cfg.Auth.LDAP.BindDN,
+			BindPassword:       cfg.Auth.LDAP.BindPassword,
+			UserBaseDN:         cfg.Auth.LDAP.UserBaseDN,
+			UserFilter:         cfg.Auth.LDAP.UserFilter,
+			UsernameAttribute:  cfg.Auth.LDAP.UsernameAttribute,
+			EmailAttribute:     cfg.Auth.LDAP.EmailAttribute,
+			GroupBaseDN:        cfg.Auth.LDAP.GroupBaseDN,
+			GroupFilter:        cfg.Auth.LDAP.GroupFilter,
+			GroupNameAttribute: cfg.Auth.LDAP.GroupNameAttribute,
+			DefaultRole:        cfg.Auth.LDAP.DefaultRole,
+			Timeout:            cfg.Auth.LDAP.Timeout,
+		}
+		for _, mapping := range cfg.Auth.LDAP.GroupMappings {
+			authLDAPConfig.GroupMappings = append(authLDAPConfig.GroupMappings, auth.LDAPGroupMapping(mapping))
+		}
+		ldapProvider, err = auth.NewLDAPProvider(authLDAPConfig)
+		if err != nil {
+			klog.Errorf("Failed to initialize LDAP provider: %v", err)
+			// Don't fail server startup, just disable LDAP
+			ldapProvider = nil
+		} else {
+			klog.Infof("LDAP provider initialized successfully for server: %s", cfg.Auth.LDAP.URL)
+		}
+	}
+
+	// Accept the tokens of bound Kubernetes ServiceAccounts, validated by the API server
+	if len(cfg.Auth.ServiceAccounts.Bindings) > 0 {
+		if k8sClientset == nil {
+			klog.Errorf("Service account authentication needs Kubernetes integration, disabling it")
+		} else if err := setupServiceAccountAuth(authManager, storage, k8sClientset, cfg.Auth.ServiceAccounts); err != nil {
+			klog.Errorf("Failed to initialize service account authentication: %v", err)
+		} else {
+			klog.Infof("Service account authentication enabled with %d bindings", len(cfg.Auth.ServiceAccounts.Bindings))
+		}
+	}
+
 	// Create OpenShift client if enabled
 	var openshiftClient *openshift.Client
 	if cfg.OpenShift.Enabled {
@@ -109,6 +190,50 @@ func NewServer(cfg *config.Config, storage storage.Storage, provisioner kubevirt
 		klog.Warningf("Event recorder or k8s client not available, API events will not be recorded")
 	}
 
+	// Create login limiter, throttling password guessing across every replica sharing the storage
+	loginLimiter := NewLoginLimiter(storage, cfg.Auth.Lockout)
+	if eventRecorder != nil {
+		loginLimiter.SetEventRecorder(eventRecorder)
+	}
+
+	// Create password manager enforcing the password policy
+	passwords := NewPasswordManager(storage, auth.PasswordPolicy{
+		MinLength:        cfg.Auth.Password.MinLength,
+		RequireUppercase: cfg.Auth.Password.RequireUppercase,
+		RequireLowercase: cfg.Auth.Password.RequireLowercase,
+		RequireDigit:     cfg.Auth.Password.RequireDigit,
+		RequireSymbol:    cfg.Auth.Password.RequireSymbol,
+		History:          cfg.Auth.Password.History,
+		MaxAge:           cfg.Auth.Password.MaxAge,
+	}, cfg.Auth.Password.ResetTokenDuration)
+
+	// Create MFA manager verifying the second factor of password logins
+	mfa := NewMFAManager(storage, cfg.Auth.MFA.Issuer, cfg.Auth.MFA.RequiredRoles)
+
+	// Create invitation manager sending invitations through the notifier
+	var invitations *InvitationManager
+	notifier, err := newNotifier(cfg.Notifier)
+	if err != nil {
+		klog.Errorf("Failed to initialize notifier: %v", err)
+		// Don't fail server startup, just disable invitations
+	} else {
+		invitations = NewInvitationManager(storage, tokenManager, notifier, cfg.Auth.Invitation.TokenDuration, cfg.Auth.Invitation.AcceptURL)
+	}
+
+	// Create audit recorder, also appending records to the audit log file if configured
+	var auditSink audit.Sink
+	if cfg.Audit.LogFile != "" {
+		fileSink, err := audit.NewFileSink(cfg.Audit.LogFile)
+		if err != nil {
+			klog.Errorf("Failed to open audit log file: %v", err)
+			// Don't fail server startup, records are still stored
+		} else {
+			auditSink = fileSink
+			klog.Infof("Audit records are appended to %s", cfg.Audit.LogFile)
+		}
+	}
+	auditRecorder := NewAuditRecorder(storage, auditSink)
+
 	server := &Server{
 		config:          cfg,
 		storage:         storage,
@@ -116,11 +241,17 @@ func NewServer(cfg *config.Config, storage storage.Storage, provisioner kubevirt
 		authManager:     authManager,
 		tokenManager:    tokenManager,
 		oidcProvider:    oidcProvider,
+		ldapProvider:    ldapProvider,
 		k8sClient:       k8sClient,
 		k8sClientset:    k8sClientset,
 		openshiftClient: openshiftClient,
 		catalogService:  catalogService,
 		eventRecorder:   eventRecorder,
+		loginLimiter:    loginLimiter,
+		passwords:       passwords,
+		mfa:             mfa,
+		invitations:     invitations,
+		auditRecorder:   auditRecorder,
 		router:          gin.New(),
 	}
 
@@ -130,11 +261,21 @@ func NewServer(cfg *config.Config, storage storage.Storage, provisioner kubevirt
 	return server
 }
 
+// TokenManager returns the manager signing and verifying the tokens of the server
+func (s *Server) TokenManager() *auth.TokenManager {
+	return s.tokenManager
+}
+
 // Handler returns the HTTP handler for the server
 func (s *Server) Handler() http.Handler {
 	return s.router
 }
 
+// Close releases the resources of the server once it stopped serving, such as the audit log file
+func (s *Server) Close() error {
+	return s.auditRecorder.Close()
+}
+
 // setupMiddleware configures global middleware
 func (s *Server) setupMiddleware() {
 	// Recovery middleware
@@ -148,8 +289,9 @@ func (s *Server) setupMiddleware() {
 	// CORS middleware
 	s.router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, If-Match, "+auth.OrganizationHeader)
+		c.Header("Access-Control-Expose-Headers", "ETag, "+auth.ImpersonatedByHeader)
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -158,6 +300,9 @@ func (s *Server) setupMiddleware() {
 
 		c.Next()
 	})
+
+	// Audit trail of mutating calls, recording the user the routes authenticate once they ran
+	s.router.Use(s.auditRecorder.Middleware())
 }
 
 // setupRoutes configures all API routes
@@ -166,15 +311,45 @@ func (s *Server) setupRoutes() {
 	s.router.GET("/health", s.healthHandler)
 	s.router.GET("/version", s.versionHandler)
 
+	// SCIM 2.0 provisioning by identity providers, which authenticate with the SCIM token
+	if s.config.Auth.SCIM.Token != "" {
+		scimHandlers := NewSCIMHandlers(s.storage, s.config.Auth.SCIM.Token)
+		scimRoutes := s.router.Group(SCIMPrefix, scimHandlers.Authenticate)
+		scimRoutes.GET("/ServiceProviderConfig", scimHandlers.GetServiceProviderConfig)
+		scimRoutes.GET("/Users", scimHandlers.ListUsers)
+		scimRoutes.POST("/Users", scimHandlers.CreateUser)
+		scimRoutes.GET("/Users/:id", scimHandlers.GetUser)
+		scimRoutes.PUT("/Users/:id", scimHandlers.ReplaceUser)
+		scimRoutes.PATCH("/Users/:id", scimHandlers.PatchUser)
+		scimRoutes.DELETE("/Users/:id", scimHandlers.DeleteUser)
+		scimRoutes.GET("/Groups", scimHandlers.ListGroups)
+		scimRoutes.POST("/Groups", scimHandlers.CreateGroup)
+		scimRoutes.GET("/Groups/:id", scimHandlers.GetGroup)
+		scimRoutes.PUT("/Groups/:id", scimHandlers.ReplaceGroup)
+		scimRoutes.PATCH("/Groups/:id", scimHandlers.PatchGroup)
+		scimRoutes.DELETE("/Groups/:id", scimHandlers.DeleteGroup)
+	}
+
 	// API routes
 	api := s.router.Group(APIPrefix)
 	{
+		authHandlers := NewAuthHandlers(s.storage, s.tokenManager, s.oidcProvider)
+		authHandlers.SetLoginLimiter(s.loginLimiter)
+		authHandlers.SetPasswordManager(s.passwords)
+		authHandlers.SetMFAManager(s.mfa)
+		authHandlers.SetLDAPProvider(s.ldapProvider)
+		authHandlers.SetInvitationManager(s.invitations)
+		s.router.GET(JWKSPath, authHandlers.GetJWKS)
+
 		// Authentication routes (no auth required)
 		authRoutes := api.Group("/auth")
 		{
-			authHandlers := NewAuthHandlers(s.storage, s.tokenManager, s.oidcProvider)
 			authRoutes.POST("/login", authHandlers.Login)
-			authRoutes.POST("/logout", authHandlers.Logout)
+			authRoutes.POST("/refresh", authHandlers.Refresh)
+			authRoutes.POST("/mfa/verify", authHandlers.VerifyMFA)
+			authRoutes.POST("/password-reset", authHandlers.ResetPassword)
+			authRoutes.POST("/invitations/accept", authHandlers.AcceptInvitation)
+			authRoutes.POST("/logout", s.authManager.RequireAuth(), authHandlers.Logout)
 			authRoutes.GET("/info", authHandlers.GetAuthInfo)
 
 			// OIDC endpoints
@@ -182,15 +357,46 @@ func (s *Server) setupRoutes() {
 				authRoutes.GET("/oidc/auth-url", authHandlers.GetOIDCAuthURL)
 				authRoutes.POST("/oidc/callback", authHandlers.HandleOIDCCallback)
 			}
+
+			// LDAP endpoints
+			if s.ldapProvider != nil {
+				authRoutes.POST("/ldap/login", authHandlers.LDAPLogin)
+			}
 		}
 
 		// Protected routes (authentication required)
 		protected := api.Group("/")
-		protected.Use(s.authManager.RequireAuth())
+		protected.Use(
+			s.authManager.RequireAuth(),
+			// Users who must change their password or enroll a second factor can do nothing else
+			// until they did
+			s.authManager.RequirePasswordChange(APIPrefix+"/profile/password"),
+			s.authManager.RequireMFAEnrollment(
+				APIPrefix+"/profile/password",
+				APIPrefix+"/profile/mfa",
+				APIPrefix+"/profile/mfa/enroll",
+				APIPrefix+"/profile/mfa/activate",
+			),
+			// Administrators impersonating a user cannot touch the user's credentials nor make
+			// the operations configured as too dangerous
+			s.authManager.RestrictImpersonation(s.config.Auth.Impersonation.ReadOnly,
+				slices.Concat(impersonationBlockedOperations, s.config.Auth.Impersonation.BlockedOperations)...),
+			// Members of several organizations pick the one they act in, every check below
+			// applies to it
+			s.authManager.SelectOrganization(),
+			authz.NewAuthorizer(s.storage).Middleware(),
+		)
 		{
-			// Organization management (system admin only)
+			trashHandlers := NewTrashHandlers(s.storage, s.k8sClient, s.config.Trash.Retention)
+
+			// Trash of deleted organizations, VDCs and VMs
+			protected.GET("/trash", trashHandlers.List)
+
+			// Audit trail of mutating calls
+			protected.GET("/audit", NewAuditHandlers(s.storage).List)
+
+			// Organization management
 			orgs := protected.Group("/organizations")
-			orgs.Use(s.authManager.RequireRole("system_admin"))
 			{
 				orgHandlers := NewOrganizationHandlers(s.storage, s.k8sClient, s.openshiftClient)
 				if s.eventRecorder != nil {
@@ -198,17 +404,36 @@ func (s *Server) setupRoutes() {
 				}
 				catalogHandlers := NewCatalogHandlers(s.storage, s.catalogService)
 				userHandlers := NewUserHandlers(s.storage)
+				roleHandlers := NewRoleHandlers(s.storage)
 				orgs.GET("/", orgHandlers.List)
 				orgs.POST("/", orgHandlers.Create)
 				orgs.GET("/:id", orgHandlers.Get)
 				orgs.PUT("/:id", orgHandlers.Update)
 				orgs.DELETE("/:id", orgHandlers.Delete)
+				orgs.PUT("/:id/mfa", orgHandlers.UpdateMFA)
+				orgs.POST("/:id/restore", trashHandlers.RestoreOrganization)
 				orgs.GET("/:id/status", orgHandlers.GetStatus)
 				orgs.POST("/:id/reconcile", orgHandlers.ForceReconcile)
 				orgs.GET("/:id/templates", catalogHandlers.ListTemplatesByOrg)
 				orgs.GET("/:id/users", userHandlers.ListByOrganization)
 				orgs.POST("/:id/users/:userId", userHandlers.AssignToOrganization)
 				orgs.DELETE("/:id/users/:userId", userHandlers.RemoveFromOrganization)
+				orgs.PUT("/:id/users/:userId/membership", userHandlers.SetMembership)
+				orgs.DELETE("/:id/users/:userId/membership", userHandlers.DeleteMembership)
+
+				// Invitations to join the organization
+				userHandlers.SetInvitationManager(s.invitations)
+				orgs.GET("/:id/invitations", userHandlers.ListInvitations)
+				orgs.POST("/:id/invitations", userHandlers.Invite)
+				orgs.POST("/:id/invitations/:invitationId/resend", userHandlers.ResendInvitation)
+				orgs.DELETE("/:id/invitations/:invitationId", userHandlers.RevokeInvitation)
+
+				// Custom roles of the organization
+				orgs.GET("/:id/roles", roleHandlers.List)
+				orgs.POST("/:id/roles", roleHandlers.Create)
+				orgs.GET("/:id/roles/:roleId", roleHandlers.Get)
+				orgs.PUT("/:id/roles/:roleId", roleHandlers.Update)
+				orgs.DELETE("/:id/roles/:roleId", roleHandlers.Delete)
 
 				// Resource management endpoints
 				orgs.GET("/:id/resources", orgHandlers.GetResourceUsage)
@@ -220,6 +445,13 @@ func (s *Server) setupRoutes() {
 				orgs.PUT("/:id/catalog-sources/:sourceId", catalogHandlers.UpdateOrganizationCatalogSource)
 				orgs.DELETE("/:id/catalog-sources/:sourceId", catalogHandlers.RemoveOrganizationCatalogSource)
 
+				// Organization catalog management endpoints
+				orgs.GET("/:id/catalogs", catalogHandlers.ListOrganizationCatalogs)
+				orgs.POST("/:id/catalogs", catalogHandlers.CreateOrganizationCatalog)
+				orgs.GET("/:id/catalogs/:catalogId", catalogHandlers.GetOrganizationCatalog)
+				orgs.PUT("/:id/catalogs/:catalogId", catalogHandlers.UpdateOrganizationCatalog)
+				orgs.DELETE("/:id/catalogs/:catalogId", catalogHandlers.DeleteOrganizationCatalog)
+
 				// Organization catalog templates endpoint (based on assigned catalog sources)
 				orgs.GET("/:id/catalog/templates", catalogHandlers.GetOrganizationCatalogTemplates)
 
@@ -228,27 +460,66 @@ func (s *Server) setupRoutes() {
 				orgs.GET("/:id/vdc-requirements", vdcHandlers.CheckVDCRequirements)
 			}
 
-			// User management (system admin only)
+			// User management
 			users := protected.Group("/users")
-			users.Use(s.authManager.RequireRole("system_admin"))
 			{
 				userHandlers := NewUserHandlers(s.storage)
+				userHandlers.SetLoginLimiter(s.loginLimiter)
+				userHandlers.SetPasswordManager(s.passwords)
+				if s.eventRecorder != nil {
+					userHandlers.SetEventRecorder(s.eventRecorder)
+				}
 				users.GET("/", userHandlers.List)
 				users.POST("/", userHandlers.Create)
 				users.GET("/:id", userHandlers.Get)
 				users.PUT("/:id", userHandlers.Update)
 				users.DELETE("/:id", userHandlers.Delete)
+				users.POST("/:id/unlock", userHandlers.Unlock)
+				users.POST("/:id/password-reset", userHandlers.CreatePasswordReset)
+				users.DELETE("/:id/mfa", userHandlers.ResetMFA)
+				users.GET("/:id/impersonations", userHandlers.ListImpersonations)
+				if s.config.Auth.Impersonation.Enabled {
+					userHandlers.SetImpersonation(s.tokenManager, s.config.Auth.Impersonation.TokenDuration)
+					users.POST("/:id/impersonate", userHandlers.Impersonate)
+				}
 			}
 
+			// Data export and import for backups and environment cloning, and the API tokens of
+			// every user
+			admin := protected.Group("/admin")
+			{
+				backupHandlers := NewBackupHandlers(s.storage)
+				admin.GET("/export", backupHandlers.Export)
+				admin.POST("/import", backupHandlers.Import)
+
+				tokenHandlers := NewTokenHandlers(s.storage)
+				admin.GET("/tokens", tokenHandlers.List)
+				admin.DELETE("/tokens/:id", tokenHandlers.Delete)
+			}
+
+			// Change stream (all authenticated users, filtered by their permissions)
+			watchHandlers := NewWatchHandlers(s.storage)
+			protected.GET("/watch", watchHandlers.Watch)
+
 			// User profile and organization access (all authenticated users)
 			userProfile := protected.Group("/profile")
 			{
 				orgHandlers := NewOrganizationHandlers(s.storage, s.k8sClient, s.openshiftClient)
 				vdcHandlers := NewVDCHandlers(s.storage, s.k8sClient, s.openshiftClient)
+				tokenHandlers := NewTokenHandlers(s.storage)
+				userHandlers := NewUserHandlers(s.storage)
+				userProfile.PUT("/password", authHandlers.ChangePassword)
+
+				// Second factor of password logins
+				userProfile.GET("/mfa", authHandlers.GetMFA)
+				userProfile.DELETE("/mfa", authHandlers.DisableMFA)
+				userProfile.POST("/mfa/enroll", authHandlers.EnrollMFA)
+				userProfile.POST("/mfa/activate", authHandlers.ActivateMFA)
+				userProfile.POST("/mfa/recovery-codes", authHandlers.RegenerateRecoveryCodes)
 				userProfile.GET("/organization", orgHandlers.GetUserOrganization)
 				userProfile.GET("/vdcs", vdcHandlers.ListUserVDCs)
-				// Allow org admins to view their organization's resource usage
-				userProfile.GET("/organization/resources", s.authManager.RequireRole("org_admin"), func(c *gin.Context) {
+				// The resource usage of the user's organization
+				userProfile.GET("/organization/resources", func(c *gin.Context) {
 					// Get user org ID from context and set it as the id param for the handler
 					_, _, _, userOrgID, ok := auth.GetUserFromContext(c)
 					if !ok || userOrgID == "" {
@@ -258,11 +529,23 @@ func (s *Server) setupRoutes() {
 					c.Params = append(c.Params, gin.Param{Key: "id", Value: userOrgID})
 					orgHandlers.GetResourceUsage(c)
 				})
+
+				// API tokens for scripts and pipelines
+				userProfile.GET("/tokens", tokenHandlers.ListProfileTokens)
+				userProfile.POST("/tokens", tokenHandlers.CreateProfileToken)
+				userProfile.GET("/tokens/:id", tokenHandlers.GetProfileToken)
+				userProfile.PUT("/tokens/:id", tokenHandlers.UpdateProfileToken)
+				userProfile.DELETE("/tokens/:id", tokenHandlers.DeleteProfileToken)
+
+				// Administrators who impersonated the user
+				userProfile.GET("/impersonations", userHandlers.ListProfileImpersonations)
+
+				// Organizations the user is a member of besides its own
+				userProfile.GET("/memberships", userHandlers.ListProfileMemberships)
 			}
 
-			// VDC management (system admin and org admin)
+			// VDC management
 			vdcs := protected.Group("/vdcs")
-			vdcs.Use(s.authManager.RequireRole("system_admin", "org_admin"))
 			{
 				vdcHandlers := NewVDCHandlers(s.storage, s.k8sClient, s.openshiftClient)
 				if s.eventRecorder != nil {
@@ -273,6 +556,7 @@ func (s *Server) setupRoutes() {
 				vdcs.GET("/:id", vdcHandlers.Get)
 				vdcs.PUT("/:id", vdcHandlers.Update)
 				vdcs.DELETE("/:id", vdcHandlers.Delete)
+				vdcs.POST("/:id/restore", trashHandlers.RestoreVDC)
 
 				// VDC resource usage endpoint
 				vdcs.GET("/:id/resources", vdcHandlers.GetResourceUsage)
@@ -291,7 +575,7 @@ func (s *Server) setupRoutes() {
 				catalog.GET("/sources", catalogHandlers.GetCatalogSources)
 			}
 
-			// VM management (all authenticated users, filtered by role)
+			// VM management (all authenticated users, filtered by their permissions)
 			vms := protected.Group("/vms")
 			{
 				vmHandlers := NewVMHandlers(s.storage, s.provisioner, s.k8sClient, s.catalogService)
@@ -302,6 +586,7 @@ func (s *Server) setupRoutes() {
 				vms.GET("/:id/console", vmHandlers.GetConsoleAccess)
 				vms.PUT("/:id/power", vmHandlers.UpdatePower)
 				vms.DELETE("/:id", vmHandlers.Delete)
+				vms.POST("/:id/restore", trashHandlers.RestoreVM)
 			}
 
 			// Dashboard (all authenticated users)
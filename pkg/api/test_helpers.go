@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/mock"
@@ -16,6 +17,7 @@ import (
 
 	"github.com/eliorerz/ovim-updated/pkg/auth"
 	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
 )
 
 // MockK8sClient is a mock implementation of the controller-runtime client.Client interface
@@ -103,6 +105,14 @@ func (m *MockStorage) ListUsersByOrg(orgID string) ([]*models.User, error) {
 	return args.Get(0).([]*models.User), args.Error(1)
 }
 
+func (m *MockStorage) QueryUsers(opts storage.ListOptions) ([]*models.User, string, int, error) {
+	args := m.Called(opts)
+	if args.Get(0) == nil {
+		return nil, "", 0, args.Error(3)
+	}
+	return args.Get(0).([]*models.User), args.String(1), args.Int(2), args.Error(3)
+}
+
 func (m *MockStorage) GetUserByUsername(username string) (*models.User, error) {
 	args := m.Called(username)
 	if args.Get(0) == nil {
@@ -119,6 +129,14 @@ func (m *MockStorage) GetUserByID(id string) (*models.User, error) {
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
+func (m *MockStorage) GetUserByExternalID(source, externalID string) (*models.User, error) {
+	args := m.Called(source, externalID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
 func (m *MockStorage) CreateUser(user *models.User) error {
 	args := m.Called(user)
 	return args.Error(0)
@@ -139,6 +157,14 @@ func (m *MockStorage) ListOrganizations() ([]*models.Organization, error) {
 	return args.Get(0).([]*models.Organization), args.Error(1)
 }
 
+func (m *MockStorage) QueryOrganizations(opts storage.ListOptions) ([]*models.Organization, string, int, error) {
+	args := m.Called(opts)
+	if args.Get(0) == nil {
+		return nil, "", 0, args.Error(3)
+	}
+	return args.Get(0).([]*models.Organization), args.String(1), args.Int(2), args.Error(3)
+}
+
 func (m *MockStorage) GetOrganization(id string) (*models.Organization, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
@@ -167,6 +193,14 @@ func (m *MockStorage) ListVDCs(orgID string) ([]*models.VirtualDataCenter, error
 	return args.Get(0).([]*models.VirtualDataCenter), args.Error(1)
 }
 
+func (m *MockStorage) QueryVDCs(opts storage.ListOptions) ([]*models.VirtualDataCenter, string, int, error) {
+	args := m.Called(opts)
+	if args.Get(0) == nil {
+		return nil, "", 0, args.Error(3)
+	}
+	return args.Get(0).([]*models.VirtualDataCenter), args.String(1), args.Int(2), args.Error(3)
+}
+
 func (m *MockStorage) GetVDC(id string) (*models.VirtualDataCenter, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
@@ -190,6 +224,34 @@ func (m *MockStorage) DeleteVDC(id string) error {
 	return args.Error(0)
 }
 
+func (m *MockStorage) ListCatalogs(orgID string) ([]*models.Catalog, error) {
+	args := m.Called(orgID)
+	return args.Get(0).([]*models.Catalog), args.Error(1)
+}
+
+func (m *MockStorage) GetCatalog(id string) (*models.Catalog, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Catalog), args.Error(1)
+}
+
+func (m *MockStorage) CreateCatalog(catalog *models.Catalog) error {
+	args := m.Called(catalog)
+	return args.Error(0)
+}
+
+func (m *MockStorage) UpdateCatalog(catalog *models.Catalog) error {
+	args := m.Called(catalog)
+	return args.Error(0)
+}
+
+func (m *MockStorage) DeleteCatalog(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
 func (m *MockStorage) ListTemplates() ([]*models.Template, error) {
 	args := m.Called()
 	return args.Get(0).([]*models.Template), args.Error(1)
@@ -200,6 +262,14 @@ func (m *MockStorage) ListTemplatesByOrg(orgID string) ([]*models.Template, erro
 	return args.Get(0).([]*models.Template), args.Error(1)
 }
 
+func (m *MockStorage) QueryTemplates(opts storage.ListOptions) ([]*models.Template, string, int, error) {
+	args := m.Called(opts)
+	if args.Get(0) == nil {
+		return nil, "", 0, args.Error(3)
+	}
+	return args.Get(0).([]*models.Template), args.String(1), args.Int(2), args.Error(3)
+}
+
 func (m *MockStorage) GetTemplate(id string) (*models.Template, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
@@ -228,6 +298,14 @@ func (m *MockStorage) ListVMs(orgID string) ([]*models.VirtualMachine, error) {
 	return args.Get(0).([]*models.VirtualMachine), args.Error(1)
 }
 
+func (m *MockStorage) QueryVMs(opts storage.ListOptions) ([]*models.VirtualMachine, string, int, error) {
+	args := m.Called(opts)
+	if args.Get(0) == nil {
+		return nil, "", 0, args.Error(3)
+	}
+	return args.Get(0).([]*models.VirtualMachine), args.String(1), args.Int(2), args.Error(3)
+}
+
 func (m *MockStorage) GetVM(id string) (*models.VirtualMachine, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
@@ -279,6 +357,339 @@ func (m *MockStorage) DeleteOrganizationCatalogSource(id string) error {
 	return args.Error(0)
 }
 
+func (m *MockStorage) ListDeletedOrganizations() ([]*models.Organization, error) {
+	args := m.Called()
+	return args.Get(0).([]*models.Organization), args.Error(1)
+}
+
+func (m *MockStorage) RestoreOrganization(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) PurgeOrganization(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) ListDeletedVDCs() ([]*models.VirtualDataCenter, error) {
+	args := m.Called()
+	return args.Get(0).([]*models.VirtualDataCenter), args.Error(1)
+}
+
+func (m *MockStorage) RestoreVDC(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) PurgeVDC(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) ListDeletedVMs() ([]*models.VirtualMachine, error) {
+	args := m.Called()
+	return args.Get(0).([]*models.VirtualMachine), args.Error(1)
+}
+
+func (m *MockStorage) RestoreVM(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) PurgeVM(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) CreateRefreshToken(token *models.RefreshToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetRefreshTokenByHash(hash string) (*models.RefreshToken, error) {
+	args := m.Called(hash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.RefreshToken), args.Error(1)
+}
+
+func (m *MockStorage) RevokeRefreshToken(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) RevokeSession(sessionID string) error {
+	args := m.Called(sessionID)
+	return args.Error(0)
+}
+
+func (m *MockStorage) RevokeUserTokens(userID string) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+func (m *MockStorage) RevokeToken(token *models.RevokedToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockStorage) IsTokenRevoked(id string) (bool, error) {
+	args := m.Called(id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStorage) PurgeExpiredTokens(before time.Time) (int, error) {
+	args := m.Called(before)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStorage) CreatePasswordResetToken(token *models.PasswordResetToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetPasswordResetTokenByHash(hash string) (*models.PasswordResetToken, error) {
+	args := m.Called(hash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.PasswordResetToken), args.Error(1)
+}
+
+func (m *MockStorage) UsePasswordResetToken(id string, usedAt time.Time) error {
+	args := m.Called(id, usedAt)
+	return args.Error(0)
+}
+
+func (m *MockStorage) CreateAPIToken(token *models.APIToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockStorage) ListAPITokens(userID string) ([]*models.APIToken, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]*models.APIToken), args.Error(1)
+}
+
+func (m *MockStorage) GetAPIToken(id string) (*models.APIToken, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.APIToken), args.Error(1)
+}
+
+func (m *MockStorage) GetAPITokenByHash(hash string) (*models.APIToken, error) {
+	args := m.Called(hash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.APIToken), args.Error(1)
+}
+
+func (m *MockStorage) UpdateAPIToken(token *models.APIToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockStorage) TouchAPIToken(id string, usedAt time.Time) error {
+	args := m.Called(id, usedAt)
+	return args.Error(0)
+}
+
+func (m *MockStorage) DeleteAPIToken(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) ListOrgRoles(orgID string) ([]*models.OrgRole, error) {
+	args := m.Called(orgID)
+	return args.Get(0).([]*models.OrgRole), args.Error(1)
+}
+
+func (m *MockStorage) GetOrgRole(id string) (*models.OrgRole, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.OrgRole), args.Error(1)
+}
+
+func (m *MockStorage) GetOrgRoleByName(orgID, name string) (*models.OrgRole, error) {
+	args := m.Called(orgID, name)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.OrgRole), args.Error(1)
+}
+
+func (m *MockStorage) CreateOrgRole(role *models.OrgRole) error {
+	args := m.Called(role)
+	return args.Error(0)
+}
+
+func (m *MockStorage) UpdateOrgRole(role *models.OrgRole) error {
+	args := m.Called(role)
+	return args.Error(0)
+}
+
+func (m *MockStorage) DeleteOrgRole(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) CreateOrgMembership(membership *models.OrgMembership) error {
+	args := m.Called(membership)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetOrgMembership(userID, orgID string) (*models.OrgMembership, error) {
+	args := m.Called(userID, orgID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.OrgMembership), args.Error(1)
+}
+
+func (m *MockStorage) ListOrgMemberships(userID, orgID string) ([]*models.OrgMembership, error) {
+	args := m.Called(userID, orgID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.OrgMembership), args.Error(1)
+}
+
+func (m *MockStorage) UpdateOrgMembership(membership *models.OrgMembership) error {
+	args := m.Called(membership)
+	return args.Error(0)
+}
+
+func (m *MockStorage) DeleteOrgMembership(userID, orgID string) error {
+	args := m.Called(userID, orgID)
+	return args.Error(0)
+}
+
+func (m *MockStorage) CreateImpersonation(impersonation *models.Impersonation) error {
+	args := m.Called(impersonation)
+	return args.Error(0)
+}
+
+func (m *MockStorage) ListImpersonations(userID string) ([]*models.Impersonation, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.Impersonation), args.Error(1)
+}
+
+func (m *MockStorage) GetImpersonation(id string) (*models.Impersonation, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Impersonation), args.Error(1)
+}
+
+func (m *MockStorage) EndImpersonation(id string, endedAt time.Time) error {
+	args := m.Called(id, endedAt)
+	return args.Error(0)
+}
+
+func (m *MockStorage) CreateInvitation(invitation *models.Invitation) error {
+	args := m.Called(invitation)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetInvitation(id string) (*models.Invitation, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Invitation), args.Error(1)
+}
+
+func (m *MockStorage) ListInvitations(orgID string) ([]*models.Invitation, error) {
+	args := m.Called(orgID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.Invitation), args.Error(1)
+}
+
+func (m *MockStorage) RenewInvitation(id, tokenID string, expiresAt time.Time) error {
+	args := m.Called(id, tokenID, expiresAt)
+	return args.Error(0)
+}
+
+func (m *MockStorage) AcceptInvitation(id, userID string, acceptedAt time.Time) error {
+	args := m.Called(id, userID, acceptedAt)
+	return args.Error(0)
+}
+
+func (m *MockStorage) RevokeInvitation(id string, revokedAt time.Time) error {
+	args := m.Called(id, revokedAt)
+	return args.Error(0)
+}
+
+func (m *MockStorage) CreateAuditRecord(record *models.AuditRecord) error {
+	args := m.Called(record)
+	return args.Error(0)
+}
+
+func (m *MockStorage) QueryAuditRecords(opts storage.ListOptions) ([]*models.AuditRecord, string, int, error) {
+	args := m.Called(opts)
+	if args.Get(0) == nil {
+		return nil, "", 0, args.Error(3)
+	}
+	return args.Get(0).([]*models.AuditRecord), args.String(1), args.Int(2), args.Error(3)
+}
+
+func (m *MockStorage) GetLoginAttempt(id string) (*models.LoginAttempt, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.LoginAttempt), args.Error(1)
+}
+
+func (m *MockStorage) RecordLoginFailure(id string, at, since time.Time) (*models.LoginAttempt, error) {
+	args := m.Called(id, at, since)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.LoginAttempt), args.Error(1)
+}
+
+func (m *MockStorage) LockLogin(id string, until time.Time) error {
+	args := m.Called(id, until)
+	return args.Error(0)
+}
+
+func (m *MockStorage) ResetLoginAttempts(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) PurgeLoginAttempts(before time.Time) (int, error) {
+	args := m.Called(before)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStorage) Watch(ctx context.Context) (<-chan storage.ChangeEvent, error) {
+	args := m.Called(ctx)
+	if events := args.Get(0); events != nil {
+		return events.(<-chan storage.ChangeEvent), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// WithTx runs fn against the mock itself, so tests set expectations on the calls made in the transaction
+func (m *MockStorage) WithTx(fn func(tx storage.Storage) error) error {
+	return fn(m)
+}
+
 func (m *MockStorage) Ping() error {
 	args := m.Called()
 	return args.Error(0)
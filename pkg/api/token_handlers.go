@@ -0,0 +1,395 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
+	"github.com/eliorerz/ovim-updated/pkg/config"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+	"github.com/eliorerz/ovim-updated/pkg/util"
+)
+
+const (
+	// maxAPITokenNameLength is the longest name an API token may have
+	maxAPITokenNameLength = 100
+
+	// apiTokenTouchInterval is how often the last use of an API token is written
+	apiTokenTouchInterval = time.Minute
+)
+
+// TokenHandlers handles the API tokens of users
+type TokenHandlers struct {
+	storage storage.Storage
+}
+
+// NewTokenHandlers creates a new token handlers instance
+func NewTokenHandlers(storage storage.Storage) *TokenHandlers {
+	return &TokenHandlers{
+		storage: storage,
+	}
+}
+
+// CreateAPITokenRequest represents the request body for creating an API token
+type CreateAPITokenRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// UpdateAPITokenRequest represents the request body for updating an API token. Omitted fields
+// are left unchanged.
+type UpdateAPITokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// CreateAPITokenResponse returns the secret of a new API token, which is not shown again
+type CreateAPITokenResponse struct {
+	Token    string           `json:"token"`
+	APIToken *models.APIToken `json:"api_token"`
+}
+
+// ListProfileTokens handles listing the API tokens of the current user
+func (h *TokenHandlers) ListProfileTokens(c *gin.Context) {
+	userID, _, _, _, ok := auth.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	h.list(c, userID)
+}
+
+// GetProfileToken handles getting an API token of the current user
+func (h *TokenHandlers) GetProfileToken(c *gin.Context) {
+	token, ok := h.getOwnToken(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, token)
+}
+
+// CreateProfileToken handles creating an API token for the current user
+func (h *TokenHandlers) CreateProfileToken(c *gin.Context) {
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	if !rejectAPITokenAuth(c) {
+		return
+	}
+
+	var req CreateAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		klog.V(4).Infof("Invalid create API token request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if !validAPITokenName(c, req.Name) {
+		return
+	}
+	scopes, ok := normalizeScopes(c, req.Scopes)
+	if !ok {
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+		return
+	}
+
+	secret, hash, prefix, err := auth.NewAPIToken()
+	if err != nil {
+		klog.Errorf("Failed to generate API token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	generatedID, err := util.GenerateID(8)
+	if err != nil {
+		klog.Errorf("Failed to generate ID for API token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate ID"})
+		return
+	}
+
+	token := &models.APIToken{
+		ID:        "token-" + generatedID,
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    prefix,
+		TokenHash: hash,
+		Scopes:    scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := h.storage.CreateAPIToken(token); err != nil {
+		if err == storage.ErrAlreadyExists {
+			c.JSON(http.StatusConflict, gin.H{"error": "A token with this name already exists"})
+			return
+		}
+		klog.Errorf("Failed to create API token for user %s: %v", username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	klog.Infof("User %s created API token %s (%s)", username, token.Name, token.ID)
+	c.JSON(http.StatusCreated, CreateAPITokenResponse{Token: secret, APIToken: token})
+}
+
+// UpdateProfileToken handles renaming an API token of the current user or changing its scopes
+func (h *TokenHandlers) UpdateProfileToken(c *gin.Context) {
+	if !rejectAPITokenAuth(c) {
+		return
+	}
+	token, ok := h.getOwnToken(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		klog.V(4).Infof("Invalid update API token request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if req.Name != "" {
+		if !validAPITokenName(c, req.Name) {
+			return
+		}
+		token.Name = req.Name
+	}
+	if req.Scopes != nil {
+		scopes, ok := normalizeScopes(c, req.Scopes)
+		if !ok {
+			return
+		}
+		token.Scopes = scopes
+	}
+
+	if err := h.storage.UpdateAPIToken(token); err != nil {
+		switch err {
+		case storage.ErrNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		case storage.ErrAlreadyExists:
+			c.JSON(http.StatusConflict, gin.H{"error": "A token with this name already exists"})
+		default:
+			klog.Errorf("Failed to update API token %s: %v", token.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update token"})
+		}
+		return
+	}
+
+	klog.Infof("Updated API token %s (%s)", token.Name, token.ID)
+	c.JSON(http.StatusOK, token)
+}
+
+// DeleteProfileToken handles revoking an API token of the current user
+func (h *TokenHandlers) DeleteProfileToken(c *gin.Context) {
+	token, ok := h.getOwnToken(c)
+	if !ok {
+		return
+	}
+	h.delete(c, token)
+}
+
+// List handles listing the API tokens of every user, or of the user given by user_id
+func (h *TokenHandlers) List(c *gin.Context) {
+	if !authorize(c, authz.TokenList, authz.Global()) {
+		return
+	}
+	h.list(c, c.Query("user_id"))
+}
+
+// Delete handles revoking any API token
+func (h *TokenHandlers) Delete(c *gin.Context) {
+	if !authorize(c, authz.TokenDelete, authz.Global()) {
+		return
+	}
+	token, err := h.storage.GetAPIToken(c.Param("id"))
+	if err != nil {
+		respondTokenLookupError(c, err)
+		return
+	}
+	h.delete(c, token)
+}
+
+func (h *TokenHandlers) list(c *gin.Context, userID string) {
+	tokens, err := h.storage.ListAPITokens(userID)
+	if err != nil {
+		klog.Errorf("Failed to list API tokens: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tokens": tokens,
+		"total":  len(tokens),
+	})
+}
+
+func (h *TokenHandlers) delete(c *gin.Context, token *models.APIToken) {
+	if err := h.storage.DeleteAPIToken(token.ID); err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+			return
+		}
+		klog.Errorf("Failed to delete API token %s: %v", token.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	_, username, _, _, _ := auth.GetUserFromContext(c)
+	klog.Infof("User %s revoked API token %s (%s) of user %s", username, token.Name, token.ID, token.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked successfully"})
+}
+
+// getOwnToken returns the token named by the request path if it belongs to the current user.
+// Tokens of other users are reported as not found.
+func (h *TokenHandlers) getOwnToken(c *gin.Context) (*models.APIToken, bool) {
+	userID, _, _, _, ok := auth.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, false
+	}
+
+	token, err := h.storage.GetAPIToken(c.Param("id"))
+	if err == nil && token.UserID != userID {
+		err = storage.ErrNotFound
+	}
+	if err != nil {
+		respondTokenLookupError(c, err)
+		return nil, false
+	}
+	return token, true
+}
+
+func respondTokenLookupError(c *gin.Context, err error) {
+	if err == storage.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+	klog.Errorf("Failed to get API token %s: %v", c.Param("id"), err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get token"})
+}
+
+// rejectAPITokenAuth stops API tokens from creating tokens or widening scopes, which would let
+// a token escape its own scopes
+func rejectAPITokenAuth(c *gin.Context) bool {
+	if _, ok := auth.GetAPITokenIDFromContext(c); ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API tokens cannot manage API tokens"})
+		return false
+	}
+	return true
+}
+
+func validAPITokenName(c *gin.Context, name string) bool {
+	if len(name) > maxAPITokenNameLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token name is too long"})
+		return false
+	}
+	return true
+}
+
+// normalizeScopes validates the scopes of a request, which must allow at least one request
+func normalizeScopes(c *gin.Context, scopes []string) (models.JSONBArray, bool) {
+	if len(scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one scope is required"})
+		return nil, false
+	}
+	normalized := make(models.JSONBArray, 0, len(scopes))
+	for _, scope := range scopes {
+		scope, err := auth.NormalizeScope(scope)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		if !util.ContainsString(normalized, scope) {
+			normalized = append(normalized, scope)
+		}
+	}
+	return normalized, true
+}
+
+// apiTokenAuthenticator authenticates API tokens against storage. A token acts with the current
+// role and organization of its user.
+type apiTokenAuthenticator struct {
+	storage storage.Storage
+}
+
+// NewAPITokenAuthenticator returns an authenticator for the API tokens kept in storage
+func NewAPITokenAuthenticator(storage storage.Storage) auth.APITokenAuthenticator {
+	return &apiTokenAuthenticator{storage: storage}
+}
+
+func (a *apiTokenAuthenticator) AuthenticateAPIToken(secret string) (*auth.APITokenIdentity, error) {
+	token, err := a.storage.GetAPITokenByHash(auth.HashAPIToken(secret))
+	if err == storage.ErrNotFound {
+		return nil, auth.ErrInvalidAPIToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if token.ExpiresAt != nil && !token.ExpiresAt.After(now) {
+		return nil, auth.ErrInvalidAPIToken
+	}
+
+	user, err := a.storage.GetUserByID(token.UserID)
+	if err == storage.ErrNotFound {
+		return nil, auth.ErrInvalidAPIToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Disabled users cannot authenticate with any of their tokens
+	if user.Disabled {
+		return nil, auth.ErrInvalidAPIToken
+	}
+
+	// The last use is only needed roughly, writing it on every request would be wasteful
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= apiTokenTouchInterval {
+		if err := a.storage.TouchAPIToken(token.ID, now); err != nil {
+			klog.Warningf("Failed to record use of API token %s: %v", token.ID, err)
+		}
+	}
+
+	identity := &auth.APITokenIdentity{
+		TokenID:  token.ID,
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		Scopes:   token.Scopes,
+	}
+	if user.OrgID != nil {
+		identity.OrgID = *user.OrgID
+	}
+	return identity, nil
+}
+
+// setupServiceAccountAuth makes the middleware accept the tokens of the ServiceAccounts bound by
+// cfg, acting as users kept in storage
+func setupServiceAccountAuth(middleware *auth.Middleware, store storage.Storage, clientset kubernetes.Interface, cfg config.ServiceAccountsConfig) error {
+	bindings := make([]auth.ServiceAccountBinding, 0, len(cfg.Bindings))
+	for _, binding := range cfg.Bindings {
+		bindings = append(bindings, auth.ServiceAccountBinding(binding))
+	}
+	authenticator, err := auth.NewServiceAccountAuthenticator(clientset, bindings, cfg.Audiences)
+	if err != nil {
+		return err
+	}
+	authenticator.SetCacheTTL(cfg.CacheTTL)
+	authenticator.SetUserLookup(func(username string) (*models.User, error) {
+		user, err := store.GetUserByUsername(username)
+		if err == storage.ErrNotFound || (err == nil && user.Disabled) {
+			return nil, nil
+		}
+		return user, err
+	})
+	middleware.SetServiceAccountAuthenticator(authenticator)
+	return nil
+}
@@ -0,0 +1,303 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/config"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// setupTokenRouter serves the token endpoints and two VM routes to alice, an org user, and to
+// admin, a system admin. Their passwords are their names followed by "password".
+func setupTokenRouter(t *testing.T) (*gin.Engine, storage.Storage) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+	for _, user := range []*models.User{
+		{ID: "alice", Username: "alice", Email: "alice@example.com", Role: models.RoleOrgUser, OrgID: stringPtr("org-1")},
+		{ID: "admin", Username: "admin", Email: "admin@example.com", Role: models.RoleSystemAdmin},
+	} {
+		user.PasswordHash, err = auth.HashPassword(user.Username + "password")
+		require.NoError(t, err)
+		require.NoError(t, store.CreateUser(user))
+	}
+
+	tokenManager := auth.NewTokenManager("test-secret", time.Minute)
+	middleware := auth.NewMiddleware(tokenManager)
+	middleware.SetRevocationList(store)
+	middleware.SetAPITokenAuthenticator(NewAPITokenAuthenticator(store))
+	authHandlers := NewAuthHandlers(store, tokenManager, nil)
+	tokenHandlers := NewTokenHandlers(store)
+
+	router := gin.New()
+	router.POST("/api/v1/auth/login", authHandlers.Login)
+	protected := router.Group("/api/v1", middleware.RequireAuth())
+	protected.GET("/profile/tokens", tokenHandlers.ListProfileTokens)
+	protected.POST("/profile/tokens", tokenHandlers.CreateProfileToken)
+	protected.GET("/profile/tokens/:id", tokenHandlers.GetProfileToken)
+	protected.PUT("/profile/tokens/:id", tokenHandlers.UpdateProfileToken)
+	protected.DELETE("/profile/tokens/:id", tokenHandlers.DeleteProfileToken)
+	protected.GET("/admin/tokens", middleware.RequireRole(models.RoleSystemAdmin), tokenHandlers.List)
+	protected.DELETE("/admin/tokens/:id", middleware.RequireRole(models.RoleSystemAdmin), tokenHandlers.Delete)
+	vmHandler := func(c *gin.Context) {
+		_, username, role, orgID, _ := auth.GetUserFromContext(c)
+		c.JSON(http.StatusOK, gin.H{"username": username, "role": role, "org_id": orgID})
+	}
+	protected.GET("/vms/:id", vmHandler)
+	protected.POST("/vms", vmHandler)
+	return router, store
+}
+
+// login returns an access token of the user
+func login(t *testing.T, router *gin.Engine, username, password string) string {
+	t.Helper()
+	w := serveJSON(router, http.MethodPost, "/api/v1/auth/login", "", LoginRequest{Username: username, Password: password})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response LoginResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response.Token
+}
+
+// createAPIToken creates an API token with the given session and returns the response
+func createAPIToken(t *testing.T, router *gin.Engine, session string, req CreateAPITokenRequest) CreateAPITokenResponse {
+	t.Helper()
+	w := serveJSON(router, http.MethodPost, "/api/v1/profile/tokens", session, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var response CreateAPITokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+func TestTokenHandlers_Lifecycle(t *testing.T) {
+	router, store := setupTokenRouter(t)
+	session := login(t, router, "alice", "alicepassword")
+
+	created := createAPIToken(t, router, session, CreateAPITokenRequest{Name: "ci", Scopes: []string{"get /api/v1/vms/", "GET /api/v1/vms"}})
+	assert.Regexp(t, `^ovim_[A-Za-z0-9_-]{43}$`, created.Token)
+	assert.Equal(t, created.Token[:len(created.APIToken.Prefix)], created.APIToken.Prefix)
+	assert.Equal(t, models.JSONBArray{"GET /api/v1/vms"}, created.APIToken.Scopes, "scopes are normalized")
+	assert.NotContains(t, serveJSON(router, http.MethodGet, "/api/v1/profile/tokens/"+created.APIToken.ID, session, nil).Body.String(), created.Token)
+
+	stored, err := store.GetAPIToken(created.APIToken.ID)
+	require.NoError(t, err)
+	assert.Equal(t, auth.HashAPIToken(created.Token), stored.TokenHash, "only the hash is stored")
+	assert.Nil(t, stored.LastUsedAt)
+
+	// The token acts for alice within its scopes
+	w := serveJSON(router, http.MethodGet, "/api/v1/vms/vm-1", created.Token, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"username":"alice","role":"org_user","org_id":"org-1"}`, w.Body.String())
+	assert.Equal(t, http.StatusForbidden, serveJSON(router, http.MethodPost, "/api/v1/vms", created.Token, nil).Code)
+	assert.Equal(t, http.StatusForbidden, serveJSON(router, http.MethodGet, "/api/v1/profile/tokens", created.Token, nil).Code)
+
+	stored, err = store.GetAPIToken(created.APIToken.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored.LastUsedAt)
+	assert.WithinDuration(t, time.Now(), *stored.LastUsedAt, 5*time.Second)
+
+	// Widening the scopes makes the token usable for creating VMs
+	w = serveJSON(router, http.MethodPut, "/api/v1/profile/tokens/"+created.APIToken.ID, session, UpdateAPITokenRequest{Scopes: []string{"* /api/v1/vms"}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusOK, serveJSON(router, http.MethodPost, "/api/v1/vms", created.Token, nil).Code)
+
+	w = serveJSON(router, http.MethodGet, "/api/v1/profile/tokens", session, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Tokens []*models.APIToken `json:"tokens"`
+		Total  int                `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Equal(t, 1, list.Total)
+	assert.Equal(t, "ci", list.Tokens[0].Name)
+	assert.Equal(t, models.JSONBArray{"* /api/v1/vms"}, list.Tokens[0].Scopes)
+
+	// Revoked tokens stop working at once
+	require.Equal(t, http.StatusOK, serveJSON(router, http.MethodDelete, "/api/v1/profile/tokens/"+created.APIToken.ID, session, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, serveJSON(router, http.MethodGet, "/api/v1/vms/vm-1", created.Token, nil).Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, http.MethodDelete, "/api/v1/profile/tokens/"+created.APIToken.ID, session, nil).Code)
+}
+
+func TestTokenHandlers_Authentication(t *testing.T) {
+	router, store := setupTokenRouter(t)
+	session := login(t, router, "alice", "alicepassword")
+	scopes := []string{"GET /api/v1/vms/*"}
+
+	t.Run("expired", func(t *testing.T) {
+		created := createAPIToken(t, router, session, CreateAPITokenRequest{Name: "expired", Scopes: scopes})
+		stored, err := store.GetAPIToken(created.APIToken.ID)
+		require.NoError(t, err)
+		expiredAt := time.Now().Add(-time.Minute)
+		stored.ExpiresAt = &expiredAt
+		require.NoError(t, store.UpdateAPIToken(stored))
+		assert.Equal(t, http.StatusUnauthorized, serveJSON(router, http.MethodGet, "/api/v1/vms/vm-1", created.Token, nil).Code)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, serveJSON(router, http.MethodGet, "/api/v1/vms/vm-1", auth.APITokenPrefix+"unknown", nil).Code)
+	})
+
+	t.Run("role change applies", func(t *testing.T) {
+		created := createAPIToken(t, router, session, CreateAPITokenRequest{Name: "role", Scopes: scopes})
+		user, err := store.GetUserByID("alice")
+		require.NoError(t, err)
+		user.Role = models.RoleOrgAdmin
+		require.NoError(t, store.UpdateUser(user))
+
+		w := serveJSON(router, http.MethodGet, "/api/v1/vms/vm-1", created.Token, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"role":"org_admin"`)
+	})
+
+	t.Run("tokens cannot manage tokens", func(t *testing.T) {
+		created := createAPIToken(t, router, session, CreateAPITokenRequest{Name: "admin", Scopes: []string{"* /api/v1/profile/tokens"}})
+		w := serveJSON(router, http.MethodPost, "/api/v1/profile/tokens", created.Token, CreateAPITokenRequest{Name: "wider", Scopes: []string{"* /"}})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		w = serveJSON(router, http.MethodPut, "/api/v1/profile/tokens/"+created.APIToken.ID, created.Token, UpdateAPITokenRequest{Scopes: []string{"* /"}})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, http.StatusOK, serveJSON(router, http.MethodGet, "/api/v1/profile/tokens", created.Token, nil).Code)
+	})
+
+	t.Run("deleted user", func(t *testing.T) {
+		created := createAPIToken(t, router, session, CreateAPITokenRequest{Name: "deleted", Scopes: scopes})
+		require.NoError(t, store.DeleteUser("alice"))
+		assert.Equal(t, http.StatusUnauthorized, serveJSON(router, http.MethodGet, "/api/v1/vms/vm-1", created.Token, nil).Code)
+	})
+}
+
+func TestTokenHandlers_CreateValidation(t *testing.T) {
+	router, _ := setupTokenRouter(t)
+	session := login(t, router, "alice", "alicepassword")
+	createAPIToken(t, router, session, CreateAPITokenRequest{Name: "ci", Scopes: []string{"GET /api/v1"}})
+
+	past := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name           string
+		body           interface{}
+		expectedStatus int
+	}{
+		{"missing name", CreateAPITokenRequest{Scopes: []string{"GET /api/v1"}}, http.StatusBadRequest},
+		{"missing scopes", CreateAPITokenRequest{Name: "no-scopes"}, http.StatusBadRequest},
+		{"empty scopes", CreateAPITokenRequest{Name: "no-scopes", Scopes: []string{}}, http.StatusBadRequest},
+		{"invalid method", CreateAPITokenRequest{Name: "bad", Scopes: []string{"FETCH /api/v1"}}, http.StatusBadRequest},
+		{"relative path", CreateAPITokenRequest{Name: "bad", Scopes: []string{"GET api/v1"}}, http.StatusBadRequest},
+		{"expired", CreateAPITokenRequest{Name: "old", Scopes: []string{"GET /api/v1"}, ExpiresAt: &past}, http.StatusBadRequest},
+		{"duplicate name", CreateAPITokenRequest{Name: "ci", Scopes: []string{"GET /api/v1"}}, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveJSON(router, http.MethodPost, "/api/v1/profile/tokens", session, tt.body)
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestTokenHandlers_Admin(t *testing.T) {
+	router, store := setupTokenRouter(t)
+	aliceSession := login(t, router, "alice", "alicepassword")
+	adminSession := login(t, router, "admin", "adminpassword")
+
+	aliceToken := createAPIToken(t, router, aliceSession, CreateAPITokenRequest{Name: "ci", Scopes: []string{"GET /api/v1/vms"}})
+	createAPIToken(t, router, adminSession, CreateAPITokenRequest{Name: "ci", Scopes: []string{"GET /api/v1/vms"}})
+
+	// Users only see their own tokens
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, http.MethodGet, "/api/v1/profile/tokens/"+aliceToken.APIToken.ID, adminSession, nil).Code)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, http.MethodDelete, "/api/v1/profile/tokens/"+aliceToken.APIToken.ID, adminSession, nil).Code)
+	assert.Equal(t, http.StatusForbidden, serveJSON(router, http.MethodGet, "/api/v1/admin/tokens", aliceSession, nil).Code)
+
+	var list struct {
+		Total int `json:"total"`
+	}
+	w := serveJSON(router, http.MethodGet, "/api/v1/admin/tokens", adminSession, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, 2, list.Total)
+	w = serveJSON(router, http.MethodGet, "/api/v1/admin/tokens?user_id=alice", adminSession, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, 1, list.Total)
+
+	require.Equal(t, http.StatusOK, serveJSON(router, http.MethodDelete, "/api/v1/admin/tokens/"+aliceToken.APIToken.ID, adminSession, nil).Code)
+	_, err := store.GetAPIToken(aliceToken.APIToken.ID)
+	assert.Equal(t, storage.ErrNotFound, err)
+	assert.Equal(t, http.StatusNotFound, serveJSON(router, http.MethodDelete, "/api/v1/admin/tokens/"+aliceToken.APIToken.ID, adminSession, nil).Code)
+}
+
+func TestSetupServiceAccountAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+	require.NoError(t, store.CreateUser(&models.User{ID: "alice", Username: "alice", Email: "alice@example.com", Role: models.RoleOrgUser, OrgID: stringPtr("org-1")}))
+
+	// The API server authenticates each token as the ServiceAccount named by its subject
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview).DeepCopy()
+		claims := &jwt.RegisteredClaims{}
+		_, _, err := jwt.NewParser().ParseUnverified(review.Spec.Token, claims)
+		require.NoError(t, err)
+		review.Status.Authenticated = true
+		review.Status.User.Username = claims.Subject
+		return true, review, nil
+	})
+	tokenFor := func(serviceAccount string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.RegisteredClaims{
+			Issuer:  "https://kubernetes.default.svc",
+			Subject: "system:serviceaccount:" + serviceAccount,
+		}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		require.NoError(t, err)
+		return token
+	}
+
+	middleware := auth.NewMiddleware(auth.NewTokenManager("test-secret", time.Minute))
+	require.NoError(t, setupServiceAccountAuth(middleware, store, client, config.ServiceAccountsConfig{
+		Bindings: []config.ServiceAccountBinding{
+			{Namespace: "ci", ServiceAccount: "deployer", User: "alice"},
+			{Namespace: "ci", ServiceAccount: "orphan", User: "bob"},
+			{Namespace: "ops", ServiceAccount: "*", Role: models.RoleSystemAdmin},
+		},
+	}))
+	router := gin.New()
+	router.GET("/api/v1/vms", middleware.RequireAuth(), func(c *gin.Context) {
+		userID, _, role, orgID, _ := auth.GetUserFromContext(c)
+		c.JSON(http.StatusOK, gin.H{"user_id": userID, "role": role, "org_id": orgID})
+	})
+
+	tests := []struct {
+		serviceAccount string
+		expectedStatus int
+		expected       map[string]string
+	}{
+		{"ci:deployer", http.StatusOK, map[string]string{"user_id": "alice", "role": models.RoleOrgUser, "org_id": "org-1"}},
+		{"ops:backup", http.StatusOK, map[string]string{"user_id": "system:serviceaccount:ops:backup", "role": models.RoleSystemAdmin, "org_id": ""}},
+		{"ci:orphan", http.StatusForbidden, nil},
+		{"ci:builder", http.StatusForbidden, nil},
+	}
+	for _, tt := range tests {
+		w := serveJSON(router, http.MethodGet, "/api/v1/vms", tokenFor(tt.serviceAccount), nil)
+		require.Equal(t, tt.expectedStatus, w.Code, tt.serviceAccount)
+		if tt.expected != nil {
+			var body map[string]string
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, tt.expected, body, tt.serviceAccount)
+		}
+	}
+
+	err = setupServiceAccountAuth(middleware, store, client, config.ServiceAccountsConfig{
+		Bindings: []config.ServiceAccountBinding{{Namespace: "ci", ServiceAccount: "deployer"}},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// txAbort is returned from a storage transaction to roll it back and answer the request
+// with the given response instead of a generic error
+type txAbort struct {
+	status int
+	body   gin.H
+}
+
+func (e *txAbort) Error() string {
+	return fmt.Sprintf("transaction aborted with status %d: %v", e.status, e.body["error"])
+}
+
+// abortTx returns an error that rolls back the running transaction with the given response
+func abortTx(status int, body gin.H) error {
+	return &txAbort{status: status, body: body}
+}
+
+// respondTxAbort writes the response of a transaction aborted with abortTx,
+// returning false if err is not such an abort
+func respondTxAbort(c *gin.Context, err error) bool {
+	var abort *txAbort
+	if !errors.As(err, &abort) {
+		return false
+	}
+	c.JSON(abort.status, abort.body)
+	return true
+}
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ovimv1 "github.com/eliorerz/ovim-updated/pkg/api/v1"
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// TrashHandlers handles listing and restoring deleted organizations, VDCs and VMs
+type TrashHandlers struct {
+	storage   storage.Storage
+	k8sClient client.Client
+	retention time.Duration
+}
+
+// NewTrashHandlers creates a new trash handlers instance. Retention is only reported to clients,
+// purging is done by the trash purger.
+func NewTrashHandlers(storage storage.Storage, k8sClient client.Client, retention time.Duration) *TrashHandlers {
+	return &TrashHandlers{
+		storage:   storage,
+		k8sClient: k8sClient,
+		retention: retention,
+	}
+}
+
+// List handles listing everything in the trash
+func (h *TrashHandlers) List(c *gin.Context) {
+	if _, ok := h.authorizeTrash(c, authz.TrashList); !ok {
+		return
+	}
+
+	orgs, err := h.storage.ListDeletedOrganizations()
+	if err != nil {
+		klog.Errorf("Failed to list deleted organizations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list trash"})
+		return
+	}
+	vdcs, err := h.storage.ListDeletedVDCs()
+	if err != nil {
+		klog.Errorf("Failed to list deleted VDCs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list trash"})
+		return
+	}
+	vms, err := h.storage.ListDeletedVMs()
+	if err != nil {
+		klog.Errorf("Failed to list deleted VMs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list trash"})
+		return
+	}
+
+	retention := "unlimited"
+	if h.retention > 0 {
+		retention = h.retention.String()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"organizations": orgs,
+		"vdcs":          vdcs,
+		"vms":           vms,
+		"retention":     retention,
+	})
+}
+
+// RestoreOrganization handles restoring a deleted organization
+func (h *TrashHandlers) RestoreOrganization(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization ID required"})
+		return
+	}
+
+	username, ok := h.authorizeTrash(c, authz.OrgRestore)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := h.storage.RestoreOrganization(id); err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found in trash"})
+			return
+		}
+		klog.Errorf("Failed to restore organization %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore organization"})
+		return
+	}
+
+	// Clearing the trashed annotation comes after, failing to do so puts the organization back
+	// in the trash
+	if h.k8sClient != nil {
+		orgCR := &ovimv1.Organization{}
+		if err := h.untrash(ctx, client.ObjectKey{Name: id}, orgCR); err != nil {
+			if err := h.storage.DeleteOrganization(id); err != nil {
+				klog.Errorf("Failed to move organization %s whose CRD is trashed back to the trash: %v", id, err)
+			}
+			respondUntrashFailure(c, err, "Organization CRD no longer exists", "Failed to restore organization")
+			return
+		}
+	}
+
+	org, err := h.storage.GetOrganization(id)
+	if err != nil {
+		klog.Errorf("Failed to get restored organization %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get organization"})
+		return
+	}
+
+	klog.Infof("Restored organization %s from the trash by user %s", id, username)
+	setETag(c, org.ResourceVersion)
+	c.JSON(http.StatusOK, org)
+}
+
+// RestoreVDC handles restoring a deleted VDC. Its organization must not be in the trash.
+func (h *TrashHandlers) RestoreVDC(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "VDC ID required"})
+		return
+	}
+
+	username, ok := h.authorizeTrash(c, authz.VDCRestore)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var vdc *models.VirtualDataCenter
+	err := h.storage.WithTx(func(tx storage.Storage) error {
+		if err := tx.RestoreVDC(id); err != nil {
+			if err == storage.ErrNotFound {
+				return abortTx(http.StatusNotFound, gin.H{"error": "VDC not found in trash"})
+			}
+			return err
+		}
+
+		var err error
+		vdc, err = tx.GetVDC(id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.GetOrganization(vdc.OrgID); err != nil {
+			if err == storage.ErrNotFound {
+				return abortTx(http.StatusConflict, gin.H{"error": "Organization of the VDC is deleted, restore it first"})
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		if respondTxAbort(c, err) {
+			return
+		}
+		klog.Errorf("Failed to restore VDC %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore VDC"})
+		return
+	}
+
+	// Clearing the trashed annotation comes after, failing to do so puts the VDC back in the trash
+	if h.k8sClient != nil {
+		vdcCR := &ovimv1.VirtualDataCenter{}
+		if err := h.untrash(ctx, client.ObjectKey{Name: vdc.CRName, Namespace: vdc.CRNamespace}, vdcCR); err != nil {
+			if err := h.storage.DeleteVDC(id); err != nil {
+				klog.Errorf("Failed to move VDC %s whose CRD is trashed back to the trash: %v", id, err)
+			}
+			respondUntrashFailure(c, err, "VDC CRD no longer exists", "Failed to restore VDC")
+			return
+		}
+	}
+
+	klog.Infof("Restored VDC %s from the trash by user %s", id, username)
+	setETag(c, vdc.ResourceVersion)
+	c.JSON(http.StatusOK, vdc)
+}
+
+// RestoreVM handles restoring a deleted VM. Its organization and VDC must not be in the trash.
+// The VM stays stopped until it is powered on again.
+func (h *TrashHandlers) RestoreVM(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "VM ID required"})
+		return
+	}
+
+	username, ok := h.authorizeTrash(c, authz.VMRestore)
+	if !ok {
+		return
+	}
+
+	var vm *models.VirtualMachine
+	err := h.storage.WithTx(func(tx storage.Storage) error {
+		if err := tx.RestoreVM(id); err != nil {
+			if err == storage.ErrNotFound {
+				return abortTx(http.StatusNotFound, gin.H{"error": "VM not found in trash"})
+			}
+			return err
+		}
+
+		var err error
+		vm, err = tx.GetVM(id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.GetOrganization(vm.OrgID); err != nil {
+			if err == storage.ErrNotFound {
+				return abortTx(http.StatusConflict, gin.H{"error": "Organization of the VM is deleted, restore it first"})
+			}
+			return err
+		}
+		if vm.VDCID != nil {
+			if _, err := tx.GetVDC(*vm.VDCID); err != nil {
+				if err == storage.ErrNotFound {
+					return abortTx(http.StatusConflict, gin.H{"error": "VDC of the VM is deleted, restore it first"})
+				}
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if respondTxAbort(c, err) {
+			return
+		}
+		klog.Errorf("Failed to restore VM %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore VM"})
+		return
+	}
+
+	klog.Infof("Restored VM %s from the trash by user %s", id, username)
+	setETag(c, vm.ResourceVersion)
+	c.JSON(http.StatusOK, vm)
+}
+
+// untrash gets a CRD and removes its trash and deletion annotations
+func (h *TrashHandlers) untrash(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if err := h.k8sClient.Get(ctx, key, obj); err != nil {
+		klog.Errorf("Failed to get trashed CRD %s: %v", key.Name, err)
+		return err
+	}
+
+	annotations := obj.GetAnnotations()
+	delete(annotations, ovimv1.TrashedAnnotation)
+	delete(annotations, "ovim.io/deleted-by")
+	delete(annotations, "ovim.io/deleted-at")
+	obj.SetAnnotations(annotations)
+
+	if err := h.k8sClient.Update(ctx, obj); err != nil {
+		klog.Errorf("Failed to clear trash annotation of %s: %v", obj.GetName(), err)
+		return err
+	}
+	return nil
+}
+
+// respondUntrashFailure writes the response of a restore whose CRD could not be untrashed
+func respondUntrashFailure(c *gin.Context, err error, notFound, failed string) {
+	switch {
+	case apierrors.IsNotFound(err):
+		c.JSON(http.StatusConflict, gin.H{"error": notFound})
+	case apierrors.IsConflict(err):
+		c.JSON(http.StatusConflict, gin.H{"error": "Resource was modified concurrently, retry"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": failed})
+	}
+}
+
+// authorizeTrash checks that the user may perform the verb on the trash, which holds the deleted
+// records of every organization, and returns the name of the user
+func (h *TrashHandlers) authorizeTrash(c *gin.Context, verb authz.Verb) (string, bool) {
+	if !authorize(c, verb, authz.Global()) {
+		return "", false
+	}
+	_, username, _, _, _ := auth.GetUserFromContext(c)
+	return username, true
+}
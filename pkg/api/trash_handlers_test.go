@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ovimv1 "github.com/eliorerz/ovim-updated/pkg/api/v1"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// setupTrashStorage returns a storage with a deleted organization holding a deleted VDC and VM
+func setupTrashStorage(t *testing.T) storage.Storage {
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+	require.NoError(t, store.CreateOrganization(&models.Organization{ID: "trash-org", Name: "Trash Org", CRName: "trash-org"}))
+	require.NoError(t, store.CreateVDC(&models.VirtualDataCenter{ID: "trash-vdc", OrgID: "trash-org", CRName: "trash-vdc", CRNamespace: "org-trash-org"}))
+	require.NoError(t, store.CreateVM(&models.VirtualMachine{ID: "trash-vm", OrgID: "trash-org", VDCID: stringPtr("trash-vdc")}))
+	require.NoError(t, store.DeleteVM("trash-vm"))
+	require.NoError(t, store.DeleteVDC("trash-vdc"))
+	require.NoError(t, store.DeleteOrganization("trash-org"))
+	return store
+}
+
+// trashedOrganizationCR returns a mock Get behaviour filling in a trashed Organization CRD
+func trashedOrganizationCR(args mock.Arguments) {
+	org := args.Get(2).(*ovimv1.Organization)
+	org.ObjectMeta = metav1.ObjectMeta{
+		Name: "trash-org",
+		Annotations: map[string]string{
+			ovimv1.TrashedAnnotation: time.Now().Format(time.RFC3339),
+			"ovim.io/deleted-by":     "admin",
+			"ovim.io/deleted-at":     time.Now().Format(time.RFC3339),
+		},
+	}
+}
+
+func TestTrashHandlers_List(t *testing.T) {
+	store := setupTrashStorage(t)
+	handlers := NewTrashHandlers(store, nil, 72*time.Hour)
+
+	t.Run("system admin", func(t *testing.T) {
+		c, w := setupGinContext(http.MethodGet, "/trash", nil, "admin", "admin", models.RoleSystemAdmin, "")
+		handlers.List(c)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Organizations []models.Organization      `json:"organizations"`
+			VDCs          []models.VirtualDataCenter `json:"vdcs"`
+			VMs           []models.VirtualMachine    `json:"vms"`
+			Retention     string                     `json:"retention"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.Len(t, response.Organizations, 1)
+		assert.NotNil(t, response.Organizations[0].DeletedAt)
+		assert.Len(t, response.VDCs, 1)
+		assert.Len(t, response.VMs, 1)
+		assert.Equal(t, "72h0m0s", response.Retention)
+	})
+
+	t.Run("org admin", func(t *testing.T) {
+		c, w := setupGinContext(http.MethodGet, "/trash", nil, "orgadmin", "orgadmin", models.RoleOrgAdmin, "trash-org")
+		handlers.List(c)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestTrashHandlers_RestoreOrganization(t *testing.T) {
+	t.Run("successful restore", func(t *testing.T) {
+		store := setupTrashStorage(t)
+		mockK8sClient := &MockK8sClient{}
+		mockK8sClient.On("Get", mock.Anything, mock.AnythingOfType("types.NamespacedName"), mock.AnythingOfType("*v1.Organization"), mock.Anything).Return(nil).Run(trashedOrganizationCR)
+		mockK8sClient.On("Update", mock.Anything, mock.MatchedBy(func(org *ovimv1.Organization) bool {
+			return len(org.Annotations) == 0
+		}), mock.Anything).Return(nil)
+
+		c, w := setupGinContext(http.MethodPost, "/organizations/trash-org/restore", nil, "admin", "admin", models.RoleSystemAdmin, "")
+		c.Params = gin.Params{{Key: "id", Value: "trash-org"}}
+
+		NewTrashHandlers(store, mockK8sClient, 0).RestoreOrganization(c)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+		org, err := store.GetOrganization("trash-org")
+		require.NoError(t, err)
+		assert.Nil(t, org.DeletedAt)
+		mockK8sClient.AssertExpectations(t)
+	})
+
+	t.Run("CRD update failure keeps it in the trash", func(t *testing.T) {
+		store := setupTrashStorage(t)
+		mockK8sClient := &MockK8sClient{}
+		mockK8sClient.On("Get", mock.Anything, mock.AnythingOfType("types.NamespacedName"), mock.AnythingOfType("*v1.Organization"), mock.Anything).Return(nil).Run(trashedOrganizationCR)
+		mockK8sClient.On("Update", mock.Anything, mock.AnythingOfType("*v1.Organization"), mock.Anything).Return(fmt.Errorf("api server unavailable"))
+
+		c, w := setupGinContext(http.MethodPost, "/organizations/trash-org/restore", nil, "admin", "admin", models.RoleSystemAdmin, "")
+		c.Params = gin.Params{{Key: "id", Value: "trash-org"}}
+
+		NewTrashHandlers(store, mockK8sClient, 0).RestoreOrganization(c)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		_, err := store.GetOrganization("trash-org")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("not in trash", func(t *testing.T) {
+		store := setupTrashStorage(t)
+		c, w := setupGinContext(http.MethodPost, "/organizations/other/restore", nil, "admin", "admin", models.RoleSystemAdmin, "")
+		c.Params = gin.Params{{Key: "id", Value: "other"}}
+
+		NewTrashHandlers(store, &MockK8sClient{}, 0).RestoreOrganization(c)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestTrashHandlers_RestoreVDC_RequiresLiveOrganization(t *testing.T) {
+	store := setupTrashStorage(t)
+
+	c, w := setupGinContext(http.MethodPost, "/vdcs/trash-vdc/restore", nil, "admin", "admin", models.RoleSystemAdmin, "")
+	c.Params = gin.Params{{Key: "id", Value: "trash-vdc"}}
+
+	NewTrashHandlers(store, &MockK8sClient{}, 0).RestoreVDC(c)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	_, err := store.GetVDC("trash-vdc")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestTrashHandlers_RestoreVM(t *testing.T) {
+	store := setupTrashStorage(t)
+	require.NoError(t, store.RestoreOrganization("trash-org"))
+	require.NoError(t, store.RestoreVDC("trash-vdc"))
+
+	c, w := setupGinContext(http.MethodPost, "/vms/trash-vm/restore", nil, "admin", "admin", models.RoleSystemAdmin, "")
+	c.Params = gin.Params{{Key: "id", Value: "trash-vm"}}
+
+	NewTrashHandlers(store, nil, 0).RestoreVM(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	vm, err := store.GetVM("trash-vm")
+	require.NoError(t, err)
+	assert.Nil(t, vm.DeletedAt)
+}
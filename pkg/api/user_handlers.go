@@ -10,6 +10,7 @@ import (
 	"k8s.io/klog/v2"
 
 	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
 	"github.com/eliorerz/ovim-updated/pkg/models"
 	"github.com/eliorerz/ovim-updated/pkg/storage"
 	"github.com/eliorerz/ovim-updated/pkg/util"
@@ -17,16 +18,37 @@ import (
 
 // UserHandlers handles user-related requests
 type UserHandlers struct {
-	storage storage.Storage
+	storage      storage.Storage
+	loginLimiter *LoginLimiter
+	passwords    *PasswordManager
+	mfa          *MFAManager
+	// tokenManager issues impersonation tokens, impersonation is disabled without it
+	tokenManager          *auth.TokenManager
+	impersonationDuration time.Duration
+	eventRecorder         *EventRecorder
+	// invitations sends invitations, which are disabled without it
+	invitations *InvitationManager
 }
 
 // NewUserHandlers creates a new user handlers instance
 func NewUserHandlers(storage storage.Storage) *UserHandlers {
 	return &UserHandlers{
-		storage: storage,
+		storage:   storage,
+		passwords: NewPasswordManager(storage, auth.DefaultPasswordPolicy(), 0),
+		mfa:       NewMFAManager(storage, "", nil),
 	}
 }
 
+// SetPasswordManager sets the password manager enforcing the password policy
+func (h *UserHandlers) SetPasswordManager(passwords *PasswordManager) {
+	h.passwords = passwords
+}
+
+// SetLoginLimiter sets the login limiter whose lockouts can be lifted
+func (h *UserHandlers) SetLoginLimiter(limiter *LoginLimiter) {
+	h.loginLimiter = limiter
+}
+
 // CreateUserRequest represents the request body for creating a user
 type CreateUserRequest struct {
 	Username string  `json:"username" binding:"required"`
@@ -34,6 +56,8 @@ type CreateUserRequest struct {
 	Password string  `json:"password" binding:"required"`
 	Role     string  `json:"role" binding:"required"`
 	OrgID    *string `json:"org_id"`
+	// MustChangePassword makes the user replace the password at the first login
+	MustChangePassword bool `json:"must_change_password"`
 }
 
 // UpdateUserRequest represents the request body for updating a user
@@ -42,21 +66,41 @@ type UpdateUserRequest struct {
 	Email    string  `json:"email"`
 	Role     string  `json:"role"`
 	OrgID    *string `json:"org_id"`
+	// MustChangePassword makes the user replace the password at the next login
+	MustChangePassword *bool `json:"must_change_password"`
 }
 
-// List handles listing all users (system admin only)
+// List handles listing users, organization administrators only see the users of their organization
 func (h *UserHandlers) List(c *gin.Context) {
-	users, err := h.storage.ListUsers()
+	opts, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.OrgID = c.Query("org_id")
+	opts.Role = c.Query("role")
+
+	scope, ok := authorizeList(c, authz.UserList)
+	if !ok {
+		return
+	}
+	if scope != authz.ScopeGlobal {
+		_, _, _, userOrgID, _ := auth.GetUserFromContext(c)
+		opts.OrgID = userOrgID
+	}
+
+	users, nextCursor, total, err := h.storage.QueryUsers(opts)
 	if err != nil {
 		klog.Errorf("Failed to list users: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list users"})
+		respondListError(c, err, "Failed to list users")
 		return
 	}
 
 	klog.V(6).Infof("Listed %d users", len(users))
 	c.JSON(http.StatusOK, gin.H{
-		"users": users,
-		"total": len(users),
+		"users":       users,
+		"total":       total,
+		"next_cursor": nextCursor,
 	})
 }
 
@@ -79,11 +123,16 @@ func (h *UserHandlers) Get(c *gin.Context) {
 		return
 	}
 
+	if !authorize(c, authz.UserGet, userResource(user)) {
+		return
+	}
+
 	klog.V(6).Infof("Retrieved user: %s", user.Username)
+	setETag(c, user.ResourceVersion)
 	c.JSON(http.StatusOK, user)
 }
 
-// Create handles creating a new user (system admin only)
+// Create handles creating a new user
 func (h *UserHandlers) Create(c *gin.Context) {
 	var req CreateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -109,18 +158,24 @@ func (h *UserHandlers) Create(c *gin.Context) {
 		return
 	}
 
-	// Validate role
-	if req.Role != models.RoleSystemAdmin && req.Role != models.RoleOrgAdmin && req.Role != models.RoleOrgUser {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role. Must be 'system_admin', 'org_admin', or 'org_user'"})
-		return
-	}
-
 	// Validate organization assignment for non-system admins
 	if req.Role != models.RoleSystemAdmin && req.OrgID == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization ID required for non-system admin users"})
 		return
 	}
 
+	if !authorize(c, authz.UserCreate, authz.InOrganization(util.StringValue(req.OrgID))) {
+		return
+	}
+	if !h.checkRoleGrant(c, req.Role, req.OrgID) {
+		return
+	}
+
+	if err := h.passwords.Validate(req.Password); err != nil {
+		respondPasswordError(c, err)
+		return
+	}
+
 	// Hash password
 	hashedPassword, err := auth.HashPassword(req.Password)
 	if err != nil {
@@ -148,15 +203,18 @@ func (h *UserHandlers) Create(c *gin.Context) {
 	}
 
 	// Create user
+	now := time.Now()
 	user := &models.User{
-		ID:           userID,
-		Username:     strings.TrimSpace(req.Username),
-		Email:        strings.TrimSpace(req.Email),
-		PasswordHash: hashedPassword,
-		Role:         req.Role,
-		OrgID:        req.OrgID,
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+		ID:                 userID,
+		Username:           strings.TrimSpace(req.Username),
+		Email:              strings.TrimSpace(req.Email),
+		PasswordHash:       hashedPassword,
+		Role:               req.Role,
+		OrgID:              req.OrgID,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+		MustChangePassword: req.MustChangePassword,
+		PasswordChangedAt:  &now,
 	}
 
 	if err := h.storage.CreateUser(user); err != nil {
@@ -199,6 +257,15 @@ func (h *UserHandlers) Update(c *gin.Context) {
 		return
 	}
 
+	if !authorize(c, authz.UserUpdate, userResource(user)) {
+		return
+	}
+	if !checkIfMatch(c, user.ResourceVersion) {
+		return
+	}
+
+	previousRole, previousOrgID := user.Role, user.OrgID
+
 	// Update fields if provided
 	if req.Username != "" {
 		user.Username = strings.TrimSpace(req.Username)
@@ -207,16 +274,14 @@ func (h *UserHandlers) Update(c *gin.Context) {
 		user.Email = strings.TrimSpace(req.Email)
 	}
 	if req.Role != "" {
-		// Validate role
-		if req.Role != models.RoleSystemAdmin && req.Role != models.RoleOrgAdmin && req.Role != models.RoleOrgUser {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
-			return
-		}
 		user.Role = req.Role
 	}
 	if req.OrgID != nil {
 		user.OrgID = req.OrgID
 	}
+	if req.MustChangePassword != nil {
+		user.MustChangePassword = *req.MustChangePassword
+	}
 
 	// Validate organization assignment for non-system admins
 	if user.Role != models.RoleSystemAdmin && user.OrgID == nil {
@@ -224,19 +289,36 @@ func (h *UserHandlers) Update(c *gin.Context) {
 		return
 	}
 
+	// Moving a user to another organization or changing its role grants permissions there
+	if util.StringValue(user.OrgID) != util.StringValue(previousOrgID) {
+		if !authorize(c, authz.UserUpdate, userResource(user)) {
+			return
+		}
+	}
+	if user.Role != previousRole || util.StringValue(user.OrgID) != util.StringValue(previousOrgID) {
+		if !h.checkRoleGrant(c, user.Role, user.OrgID) {
+			return
+		}
+	}
+
 	user.UpdatedAt = time.Now()
 
-	if err := h.storage.UpdateUser(user); err != nil {
+	if err := updateUserAccess(h.storage, user, previousRole, previousOrgID); err != nil {
+		if err == storage.ErrConflict {
+			respondConflict(c, "User was modified concurrently, reload it and retry")
+			return
+		}
 		klog.Errorf("Failed to update user %s: %v", id, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 		return
 	}
 
 	klog.Infof("Updated user: %s", user.Username)
+	setETag(c, user.ResourceVersion)
 	c.JSON(http.StatusOK, user)
 }
 
-// Delete handles deleting a user (system admin only)
+// Delete handles deleting a user
 func (h *UserHandlers) Delete(c *gin.Context) {
 	id := c.Param("id")
 	if id == "" {
@@ -256,7 +338,21 @@ func (h *UserHandlers) Delete(c *gin.Context) {
 		return
 	}
 
-	if err := h.storage.DeleteUser(id); err != nil {
+	if !authorize(c, authz.UserDelete, userResource(user)) {
+		return
+	}
+	if !checkIfMatch(c, user.ResourceVersion) {
+		return
+	}
+
+	// Tokens are revoked first, deleting the user deletes the refresh tokens naming its access tokens
+	err = h.storage.WithTx(func(tx storage.Storage) error {
+		if err := tx.RevokeUserTokens(id); err != nil {
+			return err
+		}
+		return tx.DeleteUser(id)
+	})
+	if err != nil {
 		klog.Errorf("Failed to delete user %s: %v", id, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
 		return
@@ -266,6 +362,116 @@ func (h *UserHandlers) Delete(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
 }
 
+// CreatePasswordReset handles issuing a one-time token letting a user set a new password. The
+// token is only returned once, the administrator passes it on to the user.
+func (h *UserHandlers) CreatePasswordReset(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID required"})
+		return
+	}
+
+	user, err := h.storage.GetUserByID(id)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		klog.Errorf("Failed to get user %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	if !authorize(c, authz.UserUpdate, userResource(user)) {
+		return
+	}
+
+	_, username, _, _, _ := auth.GetUserFromContext(c)
+	token, record, err := h.passwords.IssueResetToken(user, username)
+	if err != nil {
+		respondPasswordError(c, err)
+		return
+	}
+
+	klog.Infof("Password reset of user %s issued by %s", user.Username, username)
+	c.JSON(http.StatusCreated, gin.H{
+		"reset_token": token,
+		"expires_at":  record.ExpiresAt,
+	})
+}
+
+// Unlock handles lifting the lockout of a user after too many failed logins
+func (h *UserHandlers) Unlock(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID required"})
+		return
+	}
+
+	if h.loginLimiter == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Login lockout is not enabled"})
+		return
+	}
+
+	user, err := h.storage.GetUserByID(id)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		klog.Errorf("Failed to get user %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	if !authorize(c, authz.UserUpdate, userResource(user)) {
+		return
+	}
+
+	_, username, _, _, _ := auth.GetUserFromContext(c)
+	if err := h.loginLimiter.Unlock(c.Request.Context(), user, username); err != nil {
+		klog.Errorf("Failed to unlock user %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
+}
+
+// ResetMFA handles removing the second factor of a user who lost it, so that the user can log
+// in with the password alone and enroll again
+func (h *UserHandlers) ResetMFA(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID required"})
+		return
+	}
+
+	user, err := h.storage.GetUserByID(id)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		klog.Errorf("Failed to get user %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	if !authorize(c, authz.UserUpdate, userResource(user)) {
+		return
+	}
+
+	if err := h.mfa.Disable(user); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	_, username, _, _, _ := auth.GetUserFromContext(c)
+	klog.Infof("MFA of user %s reset by %s", user.Username, username)
+	c.JSON(http.StatusOK, gin.H{"message": "MFA reset successfully"})
+}
+
 // ListByOrganization handles listing users in a specific organization
 func (h *UserHandlers) ListByOrganization(c *gin.Context) {
 	orgID := c.Param("id")
@@ -274,6 +480,10 @@ func (h *UserHandlers) ListByOrganization(c *gin.Context) {
 		return
 	}
 
+	if !authorize(c, authz.UserList, authz.InOrganization(orgID)) {
+		return
+	}
+
 	// Verify organization exists
 	_, err := h.storage.GetOrganization(orgID)
 	if err != nil {
@@ -293,11 +503,20 @@ func (h *UserHandlers) ListByOrganization(c *gin.Context) {
 		return
 	}
 
-	klog.V(6).Infof("Listed %d users for organization %s", len(users), orgID)
+	// Users of other organizations who are members of this one are listed with their role here
+	members, err := h.listMembers(orgID)
+	if err != nil {
+		klog.Errorf("Failed to list members of organization %s: %v", orgID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list users"})
+		return
+	}
+
+	klog.V(6).Infof("Listed %d users and %d members for organization %s", len(users), len(members), orgID)
 	c.JSON(http.StatusOK, gin.H{
-		"users":  users,
-		"total":  len(users),
-		"org_id": orgID,
+		"users":   users,
+		"total":   len(users),
+		"members": members,
+		"org_id":  orgID,
 	})
 }
 
@@ -311,6 +530,10 @@ func (h *UserHandlers) AssignToOrganization(c *gin.Context) {
 		return
 	}
 
+	if !authorize(c, authz.UserUpdate, authz.InOrganization(orgID)) {
+		return
+	}
+
 	// Get user
 	user, err := h.storage.GetUserByID(userID)
 	if err != nil {
@@ -323,6 +546,10 @@ func (h *UserHandlers) AssignToOrganization(c *gin.Context) {
 		return
 	}
 
+	if !authorize(c, authz.UserUpdate, userResource(user)) {
+		return
+	}
+
 	// Verify organization exists
 	_, err = h.storage.GetOrganization(orgID)
 	if err != nil {
@@ -336,10 +563,28 @@ func (h *UserHandlers) AssignToOrganization(c *gin.Context) {
 	}
 
 	// Update user's organization
+	previousOrgID := user.OrgID
 	user.OrgID = &orgID
+	if util.StringValue(previousOrgID) != orgID && !h.checkRoleGrant(c, user.Role, user.OrgID) {
+		return
+	}
 	user.UpdatedAt = time.Now()
 
-	if err := h.storage.UpdateUser(user); err != nil {
+	// A membership of the user in its new organization would be redundant
+	err = h.storage.WithTx(func(tx storage.Storage) error {
+		if err := updateUserAccess(tx, user, user.Role, previousOrgID); err != nil {
+			return err
+		}
+		if err := tx.DeleteOrgMembership(user.ID, orgID); err != nil && err != storage.ErrNotFound {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		if err == storage.ErrConflict {
+			respondConflict(c, "User was modified concurrently, retry the request")
+			return
+		}
 		klog.Errorf("Failed to assign user %s to organization %s: %v", userID, orgID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign user to organization"})
 		return
@@ -359,35 +604,46 @@ func (h *UserHandlers) RemoveFromOrganization(c *gin.Context) {
 		return
 	}
 
-	// Get user
-	user, err := h.storage.GetUserByID(userID)
-	if err != nil {
-		if err == storage.ErrNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-			return
-		}
-		klog.Errorf("Failed to get user %s: %v", userID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+	if !authorize(c, authz.UserUpdate, authz.InOrganization(orgID)) {
 		return
 	}
 
-	// Check if user belongs to the organization
-	if user.OrgID == nil || *user.OrgID != orgID {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User does not belong to this organization"})
-		return
-	}
+	// Read, check and update the user atomically so a concurrent reassignment is not overwritten
+	var user *models.User
+	err := h.storage.WithTx(func(tx storage.Storage) error {
+		var err error
+		user, err = tx.GetUserByID(userID)
+		if err != nil {
+			if err == storage.ErrNotFound {
+				return abortTx(http.StatusNotFound, gin.H{"error": "User not found"})
+			}
+			return err
+		}
 
-	// System admins cannot be removed from organizations via this endpoint
-	if user.Role == models.RoleSystemAdmin {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot remove system administrator from organization"})
-		return
-	}
+		// Check if user belongs to the organization
+		if user.OrgID == nil || *user.OrgID != orgID {
+			return abortTx(http.StatusBadRequest, gin.H{"error": "User does not belong to this organization"})
+		}
 
-	// Remove user from organization
-	user.OrgID = nil
-	user.UpdatedAt = time.Now()
+		// System admins cannot be removed from organizations via this endpoint
+		if user.Role == models.RoleSystemAdmin {
+			return abortTx(http.StatusBadRequest, gin.H{"error": "Cannot remove system administrator from organization"})
+		}
 
-	if err := h.storage.UpdateUser(user); err != nil {
+		// Remove user from organization
+		previousOrgID := user.OrgID
+		user.OrgID = nil
+		user.UpdatedAt = time.Now()
+		return updateUserAccess(tx, user, user.Role, previousOrgID)
+	})
+	if err != nil {
+		if respondTxAbort(c, err) {
+			return
+		}
+		if err == storage.ErrConflict {
+			respondConflict(c, "User was modified concurrently, retry the request")
+			return
+		}
 		klog.Errorf("Failed to remove user %s from organization %s: %v", userID, orgID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove user from organization"})
 		return
@@ -397,7 +653,65 @@ func (h *UserHandlers) RemoveFromOrganization(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
+// userResource returns the resource a user is, within its organization if it has one
+func userResource(user *models.User) authz.Resource {
+	return authz.InOrganization(util.StringValue(user.OrgID))
+}
+
+// checkRoleGrant verifies that role is a built-in role or a custom role of the organization, and
+// that the caller holds every permission the role grants there. It responds with 400, 403 or 500
+// and returns false if the handler must stop.
+func (h *UserHandlers) checkRoleGrant(c *gin.Context, role string, orgID *string) bool {
+	subject := authz.Subject{Role: role, OrgID: util.StringValue(orgID)}
+	if !authz.IsBuiltinRole(role) {
+		if subject.OrgID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role. Must be a built-in role or a custom role of the organization"})
+			return false
+		}
+		if _, err := h.storage.GetOrgRoleByName(subject.OrgID, role); err != nil {
+			if err == storage.ErrNotFound {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role. Must be a built-in role or a custom role of the organization"})
+				return false
+			}
+			klog.Errorf("Failed to get role %s of organization %s: %v", role, subject.OrgID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get role"})
+			return false
+		}
+	}
+
+	authorizer := authz.FromContext(c)
+	caller, err := authorizer.Permissions(c)
+	if err != nil {
+		return respondAuthzError(c, authz.UserUpdate, err)
+	}
+	granted, err := authorizer.PermissionsFor(subject)
+	if err != nil {
+		return respondAuthzError(c, authz.UserUpdate, err)
+	}
+	if !caller.Covers(granted) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot grant a role with permissions you do not hold"})
+		return false
+	}
+	return true
+}
+
+// updateUserAccess updates a user and, when its role or organization changed, revokes its tokens
+// since they carry the previous ones. The user has to log in again.
+func updateUserAccess(s storage.Storage, user *models.User, previousRole string, previousOrgID *string) error {
+	return s.WithTx(func(tx storage.Storage) error {
+		if err := tx.UpdateUser(user); err != nil {
+			return err
+		}
+		if user.Role == previousRole && util.StringValue(user.OrgID) == util.StringValue(previousOrgID) {
+			return nil
+		}
+		klog.Infof("Revoking tokens of user %s after a change of role or organization", user.Username)
+		return tx.RevokeUserTokens(user.ID)
+	})
+}
+
 // isValidEmail validates email format using a regular expression
+
 func isValidEmail(email string) bool {
 	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
 	return emailRegex.MatchString(email)
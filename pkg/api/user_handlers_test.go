@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -13,6 +14,7 @@ import (
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
+	"github.com/eliorerz/ovim-updated/pkg/auth"
 	"github.com/eliorerz/ovim-updated/pkg/models"
 	"github.com/eliorerz/ovim-updated/pkg/storage"
 )
@@ -43,7 +45,7 @@ func TestUserHandlers_List(t *testing.T) {
 						Role:     "org_admin",
 					},
 				}
-				mockStorage.On("ListUsers").Return(users, nil)
+				mockStorage.On("QueryUsers", storage.ListOptions{}).Return(users, "", len(users), nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectUsers:    true,
@@ -51,7 +53,7 @@ func TestUserHandlers_List(t *testing.T) {
 		{
 			name: "storage error",
 			setupMocks: func(mockStorage *MockStorage) {
-				mockStorage.On("ListUsers").Return(nil, assert.AnError)
+				mockStorage.On("QueryUsers", storage.ListOptions{}).Return(nil, "", 0, assert.AnError)
 			},
 			expectedStatus: http.StatusInternalServerError,
 			expectUsers:    false,
@@ -75,12 +77,17 @@ func TestUserHandlers_List(t *testing.T) {
 			assert.Equal(t, tt.expectedStatus, w.Code)
 
 			if tt.expectUsers {
-				var users []*models.User
-				err := json.Unmarshal(w.Body.Bytes(), &users)
+				var response struct {
+					Users      []*models.User `json:"users"`
+					Total      int            `json:"total"`
+					NextCursor string         `json:"next_cursor"`
+				}
+				err := json.Unmarshal(w.Body.Bytes(), &response)
 				require.NoError(t, err)
-				assert.Len(t, users, 2)
+				assert.Len(t, response.Users, 2)
+				assert.Equal(t, 2, response.Total)
 				// Ensure password hashes are not returned
-				for _, user := range users {
+				for _, user := range response.Users {
 					assert.Empty(t, user.PasswordHash)
 				}
 			}
@@ -691,3 +698,268 @@ func TestUpdateUserRequest_Validation(t *testing.T) {
 	assert.Equal(t, "updated@example.com", updateReq.Email)
 	assert.Equal(t, "org_admin", updateReq.Role)
 }
+
+func TestUserHandlers_RevokeTokens(t *testing.T) {
+	updateAlice := func(t *testing.T, store storage.Storage, request UpdateUserRequest) {
+		c, w := setupGinContext(http.MethodPut, "/users/alice", request, "admin", "admin", models.RoleSystemAdmin, "")
+		c.Params = gin.Params{{Key: "id", Value: "alice"}}
+		NewUserHandlers(store).Update(c)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	t.Run("email change keeps tokens", func(t *testing.T) {
+		router, store := setupSessionRouter(t)
+		login := loginAlice(t, router)
+
+		updateAlice(t, store, UpdateUserRequest{Email: "alice@example.org"})
+		assert.Equal(t, http.StatusOK, serveJSON(router, http.MethodGet, "/me", login.Token, nil).Code)
+	})
+
+	t.Run("role change", func(t *testing.T) {
+		router, store := setupSessionRouter(t)
+		login := loginAlice(t, router)
+
+		updateAlice(t, store, UpdateUserRequest{Role: models.RoleOrgAdmin})
+		assert.Equal(t, http.StatusUnauthorized, serveJSON(router, http.MethodGet, "/me", login.Token, nil).Code)
+		code, _ := refreshTokens(t, router, login.RefreshToken)
+		assert.Equal(t, http.StatusUnauthorized, code)
+
+		// A new login gets the new role
+		relogin := loginAlice(t, router)
+		w := serveJSON(router, http.MethodGet, "/me", relogin.Token, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), models.RoleOrgAdmin)
+	})
+
+	t.Run("organization change", func(t *testing.T) {
+		router, store := setupSessionRouter(t)
+		login := loginAlice(t, router)
+
+		require.NoError(t, store.CreateOrganization(&models.Organization{ID: "org-2", Name: "Org 2", CRName: "org-2"}))
+		c, w := setupGinContext(http.MethodPost, "/organizations/org-2/users/alice", nil, "admin", "admin", models.RoleSystemAdmin, "")
+		c.Params = gin.Params{{Key: "id", Value: "org-2"}, {Key: "userId", Value: "alice"}}
+		NewUserHandlers(store).AssignToOrganization(c)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		assert.Equal(t, http.StatusUnauthorized, serveJSON(router, http.MethodGet, "/me", login.Token, nil).Code)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		router, store := setupSessionRouter(t)
+		login := loginAlice(t, router)
+
+		c, w := setupGinContext(http.MethodDelete, "/users/alice", nil, "admin", "admin", models.RoleSystemAdmin, "")
+		c.Params = gin.Params{{Key: "id", Value: "alice"}}
+		NewUserHandlers(store).Delete(c)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		assert.Equal(t, http.StatusUnauthorized, serveJSON(router, http.MethodGet, "/me", login.Token, nil).Code)
+	})
+}
+
+func TestUserHandlers_RoleGrants(t *testing.T) {
+	tests := []struct {
+		name           string
+		role           string
+		userOrgID      string
+		grant          string
+		orgID          string
+		expectedStatus int
+	}{
+		{"system admin grants a custom role", models.RoleSystemAdmin, "", "operator", "org-1", http.StatusCreated},
+		{"system admin grants org member", models.RoleSystemAdmin, "", models.RoleOrgMember, "org-1", http.StatusCreated},
+		{"org admin cannot create users", models.RoleOrgAdmin, "org-1", models.RoleOrgMember, "org-1", http.StatusForbidden},
+		{"custom role of another organization", models.RoleSystemAdmin, "", "operator", "org-2", http.StatusBadRequest},
+		{"unknown role", models.RoleSystemAdmin, "", "auditor", "org-1", http.StatusBadRequest},
+		{"org user cannot create users", models.RoleOrgUser, "org-1", models.RoleOrgMember, "org-1", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := setupRoleStorage(t)
+			handlers := NewUserHandlers(store)
+
+			body := CreateUserRequest{
+				Username: "new-user",
+				Email:    "new-user@example.com",
+				Password: "password123",
+				Role:     tt.grant,
+				OrgID:    stringPtr(tt.orgID),
+			}
+			c, w := roleContext(store, http.MethodPost, "/users", body, tt.role, tt.userOrgID, nil)
+			handlers.Create(c)
+
+			require.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+		})
+	}
+
+	t.Run("custom role holder cannot grant a wider role", func(t *testing.T) {
+		store := setupRoleStorage(t)
+		require.NoError(t, store.CreateOrgRole(&models.OrgRole{ID: "role-hr", OrgID: "org-1", Name: "hr", Verbs: models.JSONBArray{"user:*"}}))
+		handlers := NewUserHandlers(store)
+
+		grant := func(role string) int {
+			body := CreateUserRequest{Username: "user-" + role, Email: role + "@example.com", Password: "password123", Role: role, OrgID: stringPtr("org-1")}
+			c, w := roleContext(store, http.MethodPost, "/users", body, "hr", "org-1", nil)
+			handlers.Create(c)
+			return w.Code
+		}
+		assert.Equal(t, http.StatusForbidden, grant(models.RoleOrgMember), "hr does not hold the verbs of org members")
+		assert.Equal(t, http.StatusForbidden, grant("operator"))
+		assert.Equal(t, http.StatusCreated, grant("hr"))
+	})
+}
+
+func TestUserHandlers_Unlock(t *testing.T) {
+	store := setupRoleStorage(t)
+	limiter := NewLoginLimiter(store, testLockoutConfig)
+	handlers := NewUserHandlers(store)
+	handlers.SetLoginLimiter(limiter)
+	for i := 0; i < testLockoutConfig.MaxAttempts; i++ {
+		require.NoError(t, limiter.Fail(context.Background(), "op", "192.0.2.1"))
+	}
+
+	unlock := func(role, orgID, userID string) int {
+		c, w := roleContext(store, http.MethodPost, "/users/"+userID+"/unlock", nil, role, orgID, gin.Params{{Key: "id", Value: userID}})
+		handlers.Unlock(c)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusForbidden, unlock(models.RoleOrgAdmin, "org-1", "user-op"))
+	assert.Equal(t, http.StatusNotFound, unlock(models.RoleSystemAdmin, "", "unknown"))
+
+	require.Equal(t, http.StatusOK, unlock(models.RoleSystemAdmin, "", "user-op"))
+	wait, err := limiter.Check("op", "192.0.2.2")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+	_, err = store.GetLoginAttempt(accountAttemptID("op"))
+	assert.Equal(t, storage.ErrNotFound, err)
+
+	c, w := roleContext(store, http.MethodPost, "/users/user-op/unlock", nil, models.RoleSystemAdmin, "", gin.Params{{Key: "id", Value: "user-op"}})
+	NewUserHandlers(store).Unlock(c)
+	assert.Equal(t, http.StatusNotImplemented, w.Code, "unlocking needs a login limiter")
+}
+
+func TestUserHandlers_ResetMFA(t *testing.T) {
+	store := setupRoleStorage(t)
+	user, err := store.GetUserByID("user-op")
+	require.NoError(t, err)
+	user.PasswordHash = "hash"
+	user.MFAEnabled = true
+	user.MFASecret = "JBSWY3DPEHPK3PXP"
+	user.MFARecoveryCodes = models.JSONBArray{"code"}
+	require.NoError(t, store.UpdateUser(user))
+
+	handlers := NewUserHandlers(store)
+	reset := func(role, orgID, userID string) int {
+		c, w := roleContext(store, http.MethodDelete, "/users/"+userID+"/mfa", nil, role, orgID, gin.Params{{Key: "id", Value: userID}})
+		handlers.ResetMFA(c)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusForbidden, reset(models.RoleOrgAdmin, "org-1", "user-op"))
+	assert.Equal(t, http.StatusNotFound, reset(models.RoleSystemAdmin, "", "unknown"))
+	require.Equal(t, http.StatusOK, reset(models.RoleSystemAdmin, "", "user-op"))
+
+	user, err = store.GetUserByID("user-op")
+	require.NoError(t, err)
+	assert.False(t, user.MFAEnabled)
+	assert.Empty(t, user.MFASecret)
+	assert.Empty(t, user.MFARecoveryCodes)
+}
+
+func TestUserHandlers_Impersonate(t *testing.T) {
+	store := setupRoleStorage(t)
+	tokenManager := auth.NewTokenManager("test-secret", time.Hour)
+	handlers := NewUserHandlers(store)
+	handlers.SetImpersonation(tokenManager, 5*time.Minute)
+
+	impersonate := func(role, orgID, userID string, body interface{}) *httptest.ResponseRecorder {
+		c, w := roleContext(store, http.MethodPost, "/users/"+userID+"/impersonate", body, role, orgID, gin.Params{{Key: "id", Value: userID}})
+		handlers.Impersonate(c)
+		return w
+	}
+	reason := ImpersonateRequest{Reason: "ticket 42"}
+	assert.Equal(t, http.StatusForbidden, impersonate(models.RoleOrgAdmin, "org-1", "user-op", reason).Code)
+	assert.Equal(t, http.StatusNotFound, impersonate(models.RoleSystemAdmin, "", "unknown", reason).Code)
+	assert.Equal(t, http.StatusBadRequest, impersonate(models.RoleSystemAdmin, "", "user-op", nil).Code, "a reason is required")
+	assert.Equal(t, http.StatusBadRequest, impersonate(models.RoleSystemAdmin, "", "caller", reason).Code)
+
+	w := impersonate(models.RoleSystemAdmin, "", "user-op", reason)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var response ImpersonateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), response.ExpiresAt, 5*time.Second)
+
+	claims, err := tokenManager.ValidateToken(response.Token)
+	require.NoError(t, err)
+	assert.Equal(t, "user-op", claims.UserID)
+	assert.Equal(t, "operator", claims.Role)
+	assert.Equal(t, "org-1", claims.OrgID)
+	require.NotNil(t, claims.Actor)
+	assert.Equal(t, "caller", claims.Actor.Subject)
+	assert.Equal(t, response.Impersonation.ID, claims.Actor.ImpersonationID)
+
+	impersonations, err := store.ListImpersonations("user-op")
+	require.NoError(t, err)
+	require.Len(t, impersonations, 1)
+	assert.Equal(t, "ticket 42", impersonations[0].Reason)
+	assert.Equal(t, claims.ID, impersonations[0].TokenID)
+
+	t.Run("API token", func(t *testing.T) {
+		c, w := roleContext(store, http.MethodPost, "/users/user-op/impersonate", reason, models.RoleSystemAdmin, "", gin.Params{{Key: "id", Value: "user-op"}})
+		c.Set(auth.ContextKeyAPIToken, "token-1")
+		handlers.Impersonate(c)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		c, w := roleContext(store, http.MethodPost, "/users/user-op/impersonate", reason, models.RoleSystemAdmin, "", gin.Params{{Key: "id", Value: "user-op"}})
+		NewUserHandlers(store).Impersonate(c)
+		assert.Equal(t, http.StatusNotImplemented, w.Code)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		c, w := roleContext(store, http.MethodGet, "/users/user-op/impersonations", nil, models.RoleSystemAdmin, "", gin.Params{{Key: "id", Value: "user-op"}})
+		handlers.ListImpersonations(c)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "ticket 42")
+		assert.NotContains(t, w.Body.String(), claims.ID, "the token ID is not exposed")
+
+		c, w = roleContext(store, http.MethodGet, "/users/user-op/impersonations", nil, models.RoleOrgUser, "org-1", gin.Params{{Key: "id", Value: "user-op"}})
+		handlers.ListImpersonations(c)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestUserHandlers_ImpersonationSession(t *testing.T) {
+	router, store := setupSessionRouter(t)
+	handlers := NewUserHandlers(store)
+	handlers.SetImpersonation(auth.NewTokenManager("test-secret", time.Minute), 0)
+	router.GET("/profile/impersonations", auth.NewMiddleware(auth.NewTokenManager("test-secret", time.Minute)).RequireAuth(), handlers.ListProfileImpersonations)
+
+	c, w := roleContext(store, http.MethodPost, "/users/alice/impersonate", ImpersonateRequest{Reason: "support"}, models.RoleSystemAdmin, "", gin.Params{{Key: "id", Value: "alice"}})
+	handlers.Impersonate(c)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var response ImpersonateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	// Requests made with the token act as alice and name the impersonator
+	w = serveJSON(router, http.MethodGet, "/me", response.Token, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "alice")
+	assert.Equal(t, "caller", w.Header().Get(auth.ImpersonatedByHeader))
+
+	// Alice sees who impersonated her
+	login := loginAlice(t, router)
+	w = serveJSON(router, http.MethodGet, "/profile/impersonations", login.Token, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"impersonator_username":"caller"`)
+	assert.Contains(t, w.Body.String(), "support")
+
+	// Logging out ends the impersonation
+	require.Equal(t, http.StatusOK, serveJSON(router, http.MethodPost, "/auth/logout", response.Token, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, serveJSON(router, http.MethodGet, "/me", response.Token, nil).Code)
+	impersonation, err := store.GetImpersonation(response.Impersonation.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, impersonation.EndedAt)
+	assert.Equal(t, http.StatusOK, serveJSON(router, http.MethodGet, "/me", login.Token, nil).Code)
+}
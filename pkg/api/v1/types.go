@@ -4,6 +4,11 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// TrashedAnnotation marks an Organization or VirtualDataCenter whose database record is in the
+// trash. The value is the RFC 3339 deletion time. Trashed resources keep their namespaces until
+// they are purged and are not synchronized to the database.
+const TrashedAnnotation = "ovim.io/trashed-at"
+
 // OrganizationSpec defines the desired state of Organization
 type OrganizationSpec struct {
 	// DisplayName is the human-readable organization name
@@ -4,15 +4,18 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	ovimv1 "github.com/eliorerz/ovim-updated/pkg/api/v1"
 	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
 	"github.com/eliorerz/ovim-updated/pkg/models"
 	"github.com/eliorerz/ovim-updated/pkg/openshift"
 	"github.com/eliorerz/ovim-updated/pkg/storage"
@@ -44,40 +47,40 @@ func (h *VDCHandlers) SetEventRecorder(recorder *EventRecorder) {
 // List handles listing VDCs
 func (h *VDCHandlers) List(c *gin.Context) {
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, userOrgID, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
 	}
 
-	var orgFilter string
-	// Filter VDCs based on user role
-	if role == models.RoleSystemAdmin {
-		// System admin can see all VDCs
-		orgFilter = ""
-	} else if role == models.RoleOrgAdmin || role == models.RoleOrgUser {
-		// Org admin and users can only see VDCs from their organization
-		if userOrgID == "" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "User not associated with any organization"})
-			return
-		}
-		orgFilter = userOrgID
-	} else {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	opts, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.OrgID = c.Query("org_id")
+
+	// Restrict the listing to the VDCs the user may see
+	scope, ok := authorizeList(c, authz.VDCList)
+	if !ok {
 		return
 	}
+	if scope != authz.ScopeGlobal {
+		opts.OrgID = userOrgID
+	}
 
-	vdcs, err := h.storage.ListVDCs(orgFilter)
+	vdcs, nextCursor, total, err := h.storage.QueryVDCs(opts)
 	if err != nil {
 		klog.Errorf("Failed to list VDCs for user %s (%s): %v", username, userID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list VDCs"})
+		respondListError(c, err, "Failed to list VDCs")
 		return
 	}
 
 	klog.V(6).Infof("Listed %d VDCs for user %s (%s)", len(vdcs), username, userID)
 	c.JSON(http.StatusOK, gin.H{
-		"vdcs":  vdcs,
-		"total": len(vdcs),
+		"vdcs":        vdcs,
+		"total":       total,
+		"next_cursor": nextCursor,
 	})
 }
 
@@ -90,7 +93,7 @@ func (h *VDCHandlers) Get(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
@@ -107,17 +110,45 @@ func (h *VDCHandlers) Get(c *gin.Context) {
 		return
 	}
 
-	// Check access permissions
-	if role != models.RoleSystemAdmin {
-		if userOrgID == "" || userOrgID != vdc.OrgID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this VDC"})
-			return
-		}
+	if !authorize(c, authz.VDCGet, authz.InOrganization(vdc.OrgID)) {
+		return
 	}
 
+	setETag(c, vdc.ResourceVersion)
 	c.JSON(http.StatusOK, vdc)
 }
 
+// claimVersion evaluates the If-Match header of an update against the stored VDC, whose resource
+// version is the ETag returned by Get, and bumps that version in the same conditional write. Of
+// concurrent updates made at one version only the first gets past it, before any of them writes
+// the VirtualDataCenter resource. It returns the new version, or 0 for an unconditional update of
+// a VDC the controller has not stored yet, and false if the handler must stop.
+func (h *VDCHandlers) claimVersion(c *gin.Context, id string) (int64, bool) {
+	vdc, err := h.storage.GetVDC(id)
+	if err == storage.ErrNotFound && c.GetHeader("If-Match") == "" {
+		return 0, true
+	}
+	if err == nil && !checkIfMatch(c, vdc.ResourceVersion) {
+		return 0, false
+	}
+	if err == nil {
+		err = h.storage.UpdateVDC(vdc)
+	}
+	if err != nil {
+		switch err {
+		case storage.ErrNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "VDC not found"})
+		case storage.ErrConflict:
+			respondConflict(c, "VDC was modified concurrently, retry the request")
+		default:
+			klog.Errorf("Failed to update VDC %s: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update VDC"})
+		}
+		return 0, false
+	}
+	return vdc.ResourceVersion, true
+}
+
 // Create handles creating a new VDC
 func (h *VDCHandlers) Create(c *gin.Context) {
 	var req models.CreateVDCRequest
@@ -128,26 +159,16 @@ func (h *VDCHandlers) Create(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
 	}
 
-	// Check permissions - only system admin and org admin can create VDCs
-	if role != models.RoleSystemAdmin && role != models.RoleOrgAdmin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions to create VDC"})
+	if !authorize(c, authz.VDCCreate, authz.InOrganization(req.OrgID)) {
 		return
 	}
 
-	// For org admin, ensure they can only create VDCs in their own organization
-	if role == models.RoleOrgAdmin {
-		if userOrgID == "" || userOrgID != req.OrgID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Can only create VDCs in your own organization"})
-			return
-		}
-	}
-
 	// Verify that the organization exists
 	_, err := h.storage.GetOrganization(req.OrgID)
 	if err != nil {
@@ -185,6 +206,7 @@ func (h *VDCHandlers) Create(c *gin.Context) {
 			NetworkPolicy: req.NetworkPolicy,
 		},
 	}
+	annotateImpersonator(c, vdcCR.Annotations)
 
 	// Add LimitRange if provided
 	if req.MinCPU != nil || req.MaxCPU != nil || req.MinMemory != nil || req.MaxMemory != nil {
@@ -229,7 +251,7 @@ func (h *VDCHandlers) Create(c *gin.Context) {
 
 	// Record API event
 	if h.eventRecorder != nil {
-		h.eventRecorder.RecordVDCCreated(ctx, vdcID, req.OrgID, username)
+		h.eventRecorder.RecordVDCCreated(ctx, vdcID, req.OrgID, actingUsername(c))
 	}
 
 	c.JSON(http.StatusCreated, response)
@@ -244,7 +266,7 @@ func (h *VDCHandlers) Update(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
@@ -285,19 +307,13 @@ func (h *VDCHandlers) Update(c *gin.Context) {
 		return
 	}
 
-	// Check permissions - only system admin and org admin can update VDCs
-	if role != models.RoleSystemAdmin && role != models.RoleOrgAdmin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions to update VDC"})
+	if !authorize(c, authz.VDCUpdate, authz.InOrganization(namespaceOrgID(orgNamespace))) {
 		return
 	}
 
-	// For org admin, ensure they can only update VDCs in their own organization
-	if role == models.RoleOrgAdmin {
-		expectedOrgNamespace := fmt.Sprintf("org-%s", userOrgID)
-		if userOrgID == "" || orgNamespace != expectedOrgNamespace {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Can only update VDCs in your own organization"})
-			return
-		}
+	version, ok := h.claimVersion(c, id)
+	if !ok {
+		return
 	}
 
 	// Update fields
@@ -326,8 +342,13 @@ func (h *VDCHandlers) Update(c *gin.Context) {
 	}
 	vdcCR.Annotations["ovim.io/updated-by"] = username
 	vdcCR.Annotations["ovim.io/updated-at"] = time.Now().Format(time.RFC3339)
+	annotateImpersonator(c, vdcCR.Annotations)
 
 	if err := h.k8sClient.Update(ctx, vdcCR); err != nil {
+		if apierrors.IsConflict(err) {
+			respondConflict(c, "VDC was modified concurrently, retry the request")
+			return
+		}
 		klog.Errorf("Failed to update VirtualDataCenter CRD %s: %v", id, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update VDC CRD"})
 		return
@@ -337,7 +358,7 @@ func (h *VDCHandlers) Update(c *gin.Context) {
 
 	// Record API event
 	if h.eventRecorder != nil {
-		h.eventRecorder.RecordVDCUpdated(ctx, id, vdcCR.Spec.OrganizationRef, username)
+		h.eventRecorder.RecordVDCUpdated(ctx, id, vdcCR.Spec.OrganizationRef, actingUsername(c))
 	}
 
 	// Return updated VDC data from CRD
@@ -354,6 +375,10 @@ func (h *VDCHandlers) Update(c *gin.Context) {
 		Phase:             string(vdcCR.Status.Phase),
 	}
 
+	if version != 0 {
+		response.ResourceVersion = version
+		setETag(c, version)
+	}
 	c.JSON(http.StatusOK, response)
 }
 
@@ -366,7 +391,7 @@ func (h *VDCHandlers) Delete(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
@@ -400,21 +425,10 @@ func (h *VDCHandlers) Delete(c *gin.Context) {
 		return
 	}
 
-	// Check permissions - only system admin and org admin can delete VDCs
-	if role != models.RoleSystemAdmin && role != models.RoleOrgAdmin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions to delete VDC"})
+	if !authorize(c, authz.VDCDelete, authz.InOrganization(namespaceOrgID(orgNamespace))) {
 		return
 	}
 
-	// For org admin, ensure they can only delete VDCs in their own organization
-	if role == models.RoleOrgAdmin {
-		expectedOrgNamespace := fmt.Sprintf("org-%s", userOrgID)
-		if userOrgID == "" || orgNamespace != expectedOrgNamespace {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Can only delete VDCs in your own organization"})
-			return
-		}
-	}
-
 	// Check for dependent VMs
 	vms, err := h.storage.ListVMs("")
 	if err != nil {
@@ -439,29 +453,69 @@ func (h *VDCHandlers) Delete(c *gin.Context) {
 		return
 	}
 
-	// Add deletion annotation for audit
+	// Move the VDC to the trash, the CRD and its namespace stay until the trash is purged
+	err = h.storage.WithTx(func(tx storage.Storage) error {
+		// The record is updated at the version checked, which fails on a concurrent change and
+		// holds the row until the transaction ends
+		vdc, err := tx.GetVDC(id)
+		if err != nil {
+			if err == storage.ErrNotFound {
+				return abortTx(http.StatusNotFound, gin.H{"error": "VDC not found"})
+			}
+			return err
+		}
+		if err := ifMatchTx(c, vdc.ResourceVersion); err != nil {
+			return err
+		}
+		if err := tx.UpdateVDC(vdc); err != nil {
+			if err == storage.ErrConflict {
+				return abortTx(conflictStatus(c), gin.H{"error": "VDC was modified concurrently, retry the request"})
+			}
+			return err
+		}
+
+		if err := tx.DeleteVDC(id); err != nil {
+			if err == storage.ErrNotFound {
+				return abortTx(http.StatusNotFound, gin.H{"error": "VDC not found"})
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		if respondTxAbort(c, err) {
+			return
+		}
+		klog.Errorf("Failed to delete VDC %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete VDC"})
+		return
+	}
+
+	// Add deletion annotations for audit and mark the CRD as trashed. Failing to do so takes the
+	// record back out of the trash.
+	deletedAt := time.Now().Format(time.RFC3339)
 	if vdcCR.Annotations == nil {
 		vdcCR.Annotations = make(map[string]string)
 	}
 	vdcCR.Annotations["ovim.io/deleted-by"] = username
-	vdcCR.Annotations["ovim.io/deleted-at"] = time.Now().Format(time.RFC3339)
+	vdcCR.Annotations["ovim.io/deleted-at"] = deletedAt
+	annotateImpersonator(c, vdcCR.Annotations)
+	vdcCR.Annotations[ovimv1.TrashedAnnotation] = deletedAt
 
 	if err := h.k8sClient.Update(ctx, vdcCR); err != nil {
-		klog.Warningf("Failed to add deletion annotation to VDC CRD %s: %v", id, err)
-	}
-
-	// Delete the VDC CRD
-	if err := h.k8sClient.Delete(ctx, vdcCR); err != nil {
-		klog.Errorf("Failed to delete VirtualDataCenter CRD %s: %v", id, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete VDC CRD"})
+		klog.Errorf("Failed to mark VirtualDataCenter CRD %s as trashed: %v", id, err)
+		if err := h.storage.RestoreVDC(id); err != nil {
+			klog.Errorf("Failed to restore VDC %s whose CRD is not trashed, restore it from the trash: %v", id, err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update VDC CRD"})
 		return
 	}
 
-	klog.Infof("Deleted VirtualDataCenter CRD %s by user %s (%s) - controller will handle cleanup", id, username, userID)
+	klog.Infof("Moved VDC %s to the trash by user %s (%s)", id, username, userID)
 
 	// Record API event
 	if h.eventRecorder != nil {
-		h.eventRecorder.RecordVDCDeleted(ctx, id, vdcCR.Spec.OrganizationRef, username)
+		h.eventRecorder.RecordVDCDeleted(ctx, id, vdcCR.Spec.OrganizationRef, actingUsername(c))
 	}
 
 	c.JSON(http.StatusNoContent, nil)
@@ -470,24 +524,22 @@ func (h *VDCHandlers) Delete(c *gin.Context) {
 // ListUserVDCs handles listing VDCs for the current user's organization
 func (h *VDCHandlers) ListUserVDCs(c *gin.Context) {
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, userOrgID, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
 	}
 
-	// Only org users and org admins can use this endpoint
-	if role != models.RoleOrgAdmin && role != models.RoleOrgUser {
-		c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint is for organization users only"})
-		return
-	}
-
 	// Check if user has an organization
 	if userOrgID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User is not assigned to any organization"})
 		return
 	}
 
+	if !authorize(c, authz.VDCList, authz.InOrganization(userOrgID)) {
+		return
+	}
+
 	// Get VDCs for the user's organization
 	vdcs, err := h.storage.ListVDCs(userOrgID)
 	if err != nil {
@@ -512,7 +564,7 @@ func (h *VDCHandlers) GetResourceUsage(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
@@ -530,12 +582,8 @@ func (h *VDCHandlers) GetResourceUsage(c *gin.Context) {
 		return
 	}
 
-	// Check permissions - only system admin can view any VDC, others can only view VDCs from their org
-	if role != models.RoleSystemAdmin {
-		if userOrgID == "" || userOrgID != vdc.OrgID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Can only view resource usage for VDCs in your organization"})
-			return
-		}
+	if !authorize(c, authz.VDCGet, authz.InOrganization(vdc.OrgID)) {
+		return
 	}
 
 	// Get VMs for this VDC (we need all VMs in the organization to pass to the method)
@@ -564,18 +612,14 @@ func (h *VDCHandlers) CheckVDCRequirements(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
 	}
 
-	// Check permissions - system admin can check any org, others can only check their own org
-	if role != models.RoleSystemAdmin {
-		if userOrgID == "" || userOrgID != orgID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Can only check VDC requirements for your own organization"})
-			return
-		}
+	if !authorize(c, authz.VDCList, authz.InOrganization(orgID)) {
+		return
 	}
 
 	// Get VDCs for the organization
@@ -622,7 +666,7 @@ func (h *VDCHandlers) GetStatus(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
@@ -656,21 +700,10 @@ func (h *VDCHandlers) GetStatus(c *gin.Context) {
 		return
 	}
 
-	// Check permissions - only system admin and org admin can access VDC status
-	if role != models.RoleSystemAdmin && role != models.RoleOrgAdmin {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions to view VDC status"})
+	if !authorize(c, authz.VDCGet, authz.InOrganization(namespaceOrgID(orgNamespace))) {
 		return
 	}
 
-	// For org admin, ensure they can only access VDCs in their own organization
-	if role == models.RoleOrgAdmin {
-		expectedOrgNamespace := fmt.Sprintf("org-%s", userOrgID)
-		if userOrgID == "" || orgNamespace != expectedOrgNamespace {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Can only view VDC status in your own organization"})
-			return
-		}
-	}
-
 	klog.V(6).Infof("Retrieved VDC status for %s by user %s (%s)", id, username, userID)
 
 	// Return CRD status
@@ -692,7 +725,7 @@ func (h *VDCHandlers) GetLimitRange(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
@@ -710,12 +743,8 @@ func (h *VDCHandlers) GetLimitRange(c *gin.Context) {
 		return
 	}
 
-	// Check permissions - only system admin can view any VDC, others can only view VDCs from their org
-	if role != models.RoleSystemAdmin {
-		if userOrgID == "" || userOrgID != vdc.OrgID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Can only view LimitRange for VDCs in your organization"})
-			return
-		}
+	if !authorize(c, authz.VDCGet, authz.InOrganization(vdc.OrgID)) {
+		return
 	}
 
 	// Use OpenShift client to get LimitRange information from the VDC workload namespace
@@ -736,3 +765,13 @@ func (h *VDCHandlers) GetLimitRange(c *gin.Context) {
 
 	c.JSON(http.StatusOK, limitRangeInfo)
 }
+
+// namespaceOrgID returns the ID of the organization owning an organization namespace, which is
+// named org-<id>, or an empty ID for other namespaces
+func namespaceOrgID(namespace string) string {
+	orgID, found := strings.CutPrefix(namespace, "org-")
+	if !found {
+		return ""
+	}
+	return orgID
+}
@@ -31,7 +31,7 @@ func TestNewVDCHandlers(t *testing.T) {
 func TestVDCHandlers_List(t *testing.T) {
 	tests := []struct {
 		name                string
-		orgFilter           string
+		query               string
 		userRole            string
 		userOrgID           string
 		mockStorageBehavior func(*MockStorage)
@@ -40,42 +40,53 @@ func TestVDCHandlers_List(t *testing.T) {
 	}{
 		{
 			name:      "successful list all VDCs (system admin)",
-			orgFilter: "",
 			userRole:  models.RoleSystemAdmin,
 			userOrgID: "",
 			mockStorageBehavior: func(ms *MockStorage) {
-				ms.On("ListVDCs", "").Return([]*models.VirtualDataCenter{
+				ms.On("QueryVDCs", storage.ListOptions{}).Return([]*models.VirtualDataCenter{
 					{ID: "vdc1", Name: "VDC 1", OrgID: "org1"},
 					{ID: "vdc2", Name: "VDC 2", OrgID: "org2"},
-				}, nil)
+				}, "", 2, nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedVDCs:   2,
 		},
 		{
-			name:      "list VDCs for specific organization",
-			orgFilter: "org1",
+			name:      "org admin is limited to own organization",
+			query:     "?org_id=org2",
 			userRole:  models.RoleOrgAdmin,
 			userOrgID: "org1",
 			mockStorageBehavior: func(ms *MockStorage) {
-				ms.On("ListVDCs", "org1").Return([]*models.VirtualDataCenter{
+				ms.On("QueryVDCs", storage.ListOptions{OrgID: "org1"}).Return([]*models.VirtualDataCenter{
 					{ID: "vdc1", Name: "VDC 1", OrgID: "org1"},
-				}, nil)
+				}, "", 1, nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedVDCs:   1,
 		},
 		{
 			name:      "storage error",
-			orgFilter: "",
 			userRole:  models.RoleSystemAdmin,
 			userOrgID: "",
 			mockStorageBehavior: func(ms *MockStorage) {
-				ms.On("ListVDCs", "").Return([]*models.VirtualDataCenter{}, fmt.Errorf("database error"))
+				ms.On("QueryVDCs", storage.ListOptions{}).Return(nil, "", 0, fmt.Errorf("database error"))
 			},
 			expectedStatus: http.StatusInternalServerError,
 			expectedVDCs:   0,
 		},
+		{
+			name:      "filter by organization and status (system admin)",
+			query:     "?org_id=org2&status=Active",
+			userRole:  models.RoleSystemAdmin,
+			userOrgID: "",
+			mockStorageBehavior: func(ms *MockStorage) {
+				ms.On("QueryVDCs", storage.ListOptions{OrgID: "org2", Status: models.VDCPhaseActive}).Return([]*models.VirtualDataCenter{
+					{ID: "vdc2", Name: "VDC 2", OrgID: "org2", Phase: models.VDCPhaseActive},
+				}, "", 1, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedVDCs:   1,
+		},
 	}
 
 	for _, tt := range tests {
@@ -84,11 +95,7 @@ func TestVDCHandlers_List(t *testing.T) {
 			tt.mockStorageBehavior(mockStorage)
 
 			handlers := NewVDCHandlers(mockStorage, nil, nil)
-			url := "/vdcs"
-			if tt.orgFilter != "" {
-				url += "?org=" + tt.orgFilter
-			}
-			c, w := setupGinContext("GET", url, nil, "user1", "admin", tt.userRole, tt.userOrgID)
+			c, w := setupGinContext("GET", "/vdcs"+tt.query, nil, "user1", "admin", tt.userRole, tt.userOrgID)
 
 			handlers.List(c)
 
@@ -329,9 +336,11 @@ func TestVDCHandlers_Update_CRDOnly(t *testing.T) {
 		requestBody         models.UpdateVDCRequest
 		userRole            string
 		userOrgID           string
+		ifMatch             string
 		mockStorageBehavior func(*MockStorage)
 		mockK8sBehavior     func(*MockK8sClient)
 		expectedStatus      int
+		expectedETag        string
 	}{
 		{
 			name:  "successful update",
@@ -343,8 +352,15 @@ func TestVDCHandlers_Update_CRDOnly(t *testing.T) {
 			},
 			userRole:  models.RoleSystemAdmin,
 			userOrgID: "",
+			ifMatch:   `"4"`,
 			mockStorageBehavior: func(ms *MockStorage) {
-				// No storage calls needed - CRD controller handles sync
+				// The version is claimed in the database, the CRD controller syncs the fields
+				ms.On("GetVDC", "test-vdc").Return(&models.VirtualDataCenter{ID: "test-vdc", ResourceVersion: 4}, nil)
+				ms.On("UpdateVDC", mock.MatchedBy(func(vdc *models.VirtualDataCenter) bool {
+					return vdc.ResourceVersion == 4
+				})).Run(func(args mock.Arguments) {
+					args.Get(0).(*models.VirtualDataCenter).ResourceVersion = 5
+				}).Return(nil)
 			},
 			mockK8sBehavior: func(mk *MockK8sClient) {
 				// Mock listing VDCs to find the one with matching name
@@ -367,6 +383,36 @@ func TestVDCHandlers_Update_CRDOnly(t *testing.T) {
 				mk.On("Update", mock.Anything, mock.AnythingOfType("*v1.VirtualDataCenter"), mock.Anything).Return(nil)
 			},
 			expectedStatus: http.StatusOK,
+			expectedETag:   `"5"`,
+		},
+		{
+			name:  "stale If-Match",
+			vdcID: "test-vdc",
+			requestBody: models.UpdateVDCRequest{
+				DisplayName: stringPtr("Updated VDC"),
+			},
+			userRole:  models.RoleSystemAdmin,
+			userOrgID: "",
+			ifMatch:   `"3"`,
+			mockStorageBehavior: func(ms *MockStorage) {
+				ms.On("GetVDC", "test-vdc").Return(&models.VirtualDataCenter{ID: "test-vdc", ResourceVersion: 4}, nil)
+			},
+			mockK8sBehavior: func(mk *MockK8sClient) {
+				// The CRD is not updated
+				mk.On("List", mock.Anything, mock.AnythingOfType("*v1.VirtualDataCenterList"), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+					vdcList := args.Get(1).(*ovimv1.VirtualDataCenterList)
+					vdcList.Items = []ovimv1.VirtualDataCenter{
+						{
+							ObjectMeta: metav1.ObjectMeta{
+								Name:      "test-vdc",
+								Namespace: "org-test-org",
+							},
+						},
+					}
+				})
+			},
+			expectedStatus: http.StatusPreconditionFailed,
+			expectedETag:   `"4"`,
 		},
 		{
 			name:  "VDC not found",
@@ -431,10 +477,14 @@ func TestVDCHandlers_Update_CRDOnly(t *testing.T) {
 			handlers := NewVDCHandlers(mockStorage, mockK8sClient, nil)
 			c, w := setupGinContext("PUT", fmt.Sprintf("/vdcs/%s", tt.vdcID), tt.requestBody, "user1", "admin", tt.userRole, tt.userOrgID)
 			c.Params = []gin.Param{{Key: "id", Value: tt.vdcID}}
+			if tt.ifMatch != "" {
+				c.Request.Header.Set("If-Match", tt.ifMatch)
+			}
 
 			handlers.Update(c)
 
 			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedETag, w.Header().Get("ETag"))
 			mockStorage.AssertExpectations(t)
 			mockK8sClient.AssertExpectations(t)
 		})
@@ -459,6 +509,10 @@ func TestVDCHandlers_Delete_CRDOnly(t *testing.T) {
 			mockStorageBehavior: func(ms *MockStorage) {
 				// Check for dependent VMs
 				ms.On("ListVMs", "").Return([]*models.VirtualMachine{}, nil)
+				// Move the VDC to the trash at the version checked
+				ms.On("GetVDC", "test-vdc").Return(&models.VirtualDataCenter{ID: "test-vdc", ResourceVersion: 4}, nil)
+				ms.On("UpdateVDC", mock.AnythingOfType("*models.VirtualDataCenter")).Return(nil)
+				ms.On("DeleteVDC", "test-vdc").Return(nil)
 			},
 			mockK8sBehavior: func(mk *MockK8sClient) {
 				// Mock listing VDCs to find the one with matching name
@@ -476,10 +530,10 @@ func TestVDCHandlers_Delete_CRDOnly(t *testing.T) {
 						},
 					}
 				})
-				// Mock update for deletion annotations
-				mk.On("Update", mock.Anything, mock.AnythingOfType("*v1.VirtualDataCenter"), mock.Anything).Return(nil)
-				// Mock delete
-				mk.On("Delete", mock.Anything, mock.AnythingOfType("*v1.VirtualDataCenter"), mock.Anything).Return(nil)
+				// Mock update marking the CRD as trashed, the CRD itself is kept
+				mk.On("Update", mock.Anything, mock.MatchedBy(func(vdc *ovimv1.VirtualDataCenter) bool {
+					return vdc.Annotations[ovimv1.TrashedAnnotation] != ""
+				}), mock.Anything).Return(nil)
 			},
 			expectedStatus: http.StatusNoContent,
 		},
@@ -580,6 +634,32 @@ func TestVDCHandlers_Delete_CRDOnly(t *testing.T) {
 	}
 }
 
+func TestVDCHandlers_Delete_RestoresOnCRDFailure(t *testing.T) {
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+	require.NoError(t, store.CreateOrganization(&models.Organization{ID: "test-org", Name: "Test Org"}))
+	require.NoError(t, store.CreateVDC(&models.VirtualDataCenter{ID: "test-vdc", Name: "Test VDC", OrgID: "test-org"}))
+
+	mockK8sClient := &MockK8sClient{}
+	mockK8sClient.On("List", mock.Anything, mock.AnythingOfType("*v1.VirtualDataCenterList"), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		vdcList := args.Get(1).(*ovimv1.VirtualDataCenterList)
+		vdcList.Items = []ovimv1.VirtualDataCenter{
+			{ObjectMeta: metav1.ObjectMeta{Name: "test-vdc", Namespace: "org-test-org"}},
+		}
+	})
+	mockK8sClient.On("Update", mock.Anything, mock.AnythingOfType("*v1.VirtualDataCenter"), mock.Anything).Return(fmt.Errorf("api server unavailable"))
+
+	c, w := setupGinContext("DELETE", "/vdcs/test-vdc", nil, "user1", "admin", models.RoleSystemAdmin, "")
+	c.Params = []gin.Param{{Key: "id", Value: "test-vdc"}}
+
+	NewVDCHandlers(store, mockK8sClient, nil).Delete(c)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	// The VDC was taken back out of the trash
+	_, err = store.GetVDC("test-vdc")
+	assert.NoError(t, err)
+}
+
 func TestVDCHandlers_ListUserVDCs(t *testing.T) {
 	tests := []struct {
 		name                string
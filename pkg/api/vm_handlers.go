@@ -12,6 +12,7 @@ import (
 
 	ovimv1 "github.com/eliorerz/ovim-updated/pkg/api/v1"
 	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
 	"github.com/eliorerz/ovim-updated/pkg/catalog"
 	"github.com/eliorerz/ovim-updated/pkg/kubevirt"
 	"github.com/eliorerz/ovim-updated/pkg/models"
@@ -40,51 +41,45 @@ func NewVMHandlers(storage storage.Storage, provisioner kubevirt.VMProvisioner,
 // List handles listing VMs
 func (h *VMHandlers) List(c *gin.Context) {
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, userOrgID, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
 	}
 
-	var orgFilter string
-	// Filter VMs based on user role
-	if role == models.RoleSystemAdmin {
-		// System admin can see all VMs
-		orgFilter = ""
-	} else if role == models.RoleOrgAdmin || role == models.RoleOrgUser {
-		// Org admin and users can only see VMs from their organization
-		if userOrgID == "" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "User not associated with any organization"})
-			return
-		}
-		orgFilter = userOrgID
-	} else {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	opts, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	opts.OwnerID = c.Query("owner_id")
+	opts.VDCID = c.Query("vdc_id")
+	opts.OrgID = c.Query("org_id")
 
-	vms, err := h.storage.ListVMs(orgFilter)
-	if err != nil {
-		klog.Errorf("Failed to list VMs for user %s (%s): %v", username, userID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list VMs"})
+	// Restrict the listing to the VMs the user may see
+	scope, ok := authorizeList(c, authz.VMList)
+	if !ok {
 		return
 	}
+	if scope != authz.ScopeGlobal {
+		opts.OrgID = userOrgID
+	}
+	if scope == authz.ScopeOwn {
+		opts.OwnerID = userID
+	}
 
-	// For org users, filter VMs to only show their own
-	if role == models.RoleOrgUser {
-		userVMs := make([]*models.VirtualMachine, 0)
-		for _, vm := range vms {
-			if vm.OwnerID == userID {
-				userVMs = append(userVMs, vm)
-			}
-		}
-		vms = userVMs
+	vms, nextCursor, total, err := h.storage.QueryVMs(opts)
+	if err != nil {
+		klog.Errorf("Failed to list VMs for user %s (%s): %v", username, userID, err)
+		respondListError(c, err, "Failed to list VMs")
+		return
 	}
 
 	klog.V(6).Infof("Listed %d VMs for user %s (%s)", len(vms), username, userID)
 	c.JSON(http.StatusOK, gin.H{
-		"vms":   vms,
-		"total": len(vms),
+		"vms":         vms,
+		"total":       total,
+		"next_cursor": nextCursor,
 	})
 }
 
@@ -98,24 +93,23 @@ func (h *VMHandlers) Create(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, userOrgID, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
 	}
 
-	// Check if user can create VMs (all authenticated users can create VMs in their org)
-	if role != models.RoleSystemAdmin && role != models.RoleOrgAdmin && role != models.RoleOrgUser {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions to create VM"})
-		return
-	}
-
 	// Ensure user is associated with an organization
 	if userOrgID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User not associated with any organization"})
 		return
 	}
 
+	// The new VM belongs to the user, in the user's organization
+	if !authorize(c, authz.VMCreate, authz.OwnedBy(userOrgID, userID)) {
+		return
+	}
+
 	// Verify the template exists via catalog service
 	var template *models.Template
 	var err error
@@ -306,7 +300,7 @@ func (h *VMHandlers) Get(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
@@ -323,26 +317,11 @@ func (h *VMHandlers) Get(c *gin.Context) {
 		return
 	}
 
-	// Check access permissions
-	if role == models.RoleSystemAdmin {
-		// System admin can access any VM
-	} else if role == models.RoleOrgAdmin {
-		// Org admin can access VMs in their organization
-		if userOrgID == "" || userOrgID != vm.OrgID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this VM"})
-			return
-		}
-	} else if role == models.RoleOrgUser {
-		// Org user can only access their own VMs
-		if userOrgID == "" || userOrgID != vm.OrgID || userID != vm.OwnerID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this VM"})
-			return
-		}
-	} else {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	if !authorize(c, authz.VMGet, authz.OwnedBy(vm.OrgID, vm.OwnerID)) {
 		return
 	}
 
+	setETag(c, vm.ResourceVersion)
 	c.JSON(http.StatusOK, vm)
 }
 
@@ -355,7 +334,7 @@ func (h *VMHandlers) GetStatus(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
@@ -373,23 +352,7 @@ func (h *VMHandlers) GetStatus(c *gin.Context) {
 		return
 	}
 
-	// Check access permissions
-	if role == models.RoleSystemAdmin {
-		// System admin can access any VM
-	} else if role == models.RoleOrgAdmin {
-		// Org admin can access VMs in their organization
-		if userOrgID == "" || userOrgID != vm.OrgID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this VM"})
-			return
-		}
-	} else if role == models.RoleOrgUser {
-		// Org user can only access their own VMs
-		if userOrgID == "" || userOrgID != vm.OrgID || userID != vm.OwnerID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this VM"})
-			return
-		}
-	} else {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	if !authorize(c, authz.VMGet, authz.OwnedBy(vm.OrgID, vm.OwnerID)) {
 		return
 	}
 
@@ -465,7 +428,7 @@ func (h *VMHandlers) UpdatePower(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
@@ -483,23 +446,11 @@ func (h *VMHandlers) UpdatePower(c *gin.Context) {
 		return
 	}
 
-	// Check access permissions
-	if role == models.RoleSystemAdmin {
-		// System admin can control any VM
-	} else if role == models.RoleOrgAdmin {
-		// Org admin can control VMs in their organization
-		if userOrgID == "" || userOrgID != vm.OrgID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this VM"})
-			return
-		}
-	} else if role == models.RoleOrgUser {
-		// Org user can only control their own VMs
-		if userOrgID == "" || userOrgID != vm.OrgID || userID != vm.OwnerID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this VM"})
-			return
-		}
-	} else {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	if !authorize(c, authz.VMPower, authz.OwnedBy(vm.OrgID, vm.OwnerID)) {
+		return
+	}
+
+	if !checkIfMatch(c, vm.ResourceVersion) {
 		return
 	}
 
@@ -564,6 +515,10 @@ func (h *VMHandlers) UpdatePower(c *gin.Context) {
 	// Update VM status in database
 	vm.Status = newStatus
 	if err := h.storage.UpdateVM(vm); err != nil {
+		if err == storage.ErrConflict {
+			respondConflict(c, "VM was modified concurrently, reload it and retry")
+			return
+		}
 		klog.Errorf("Failed to update VM %s power state in database: %v", id, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update VM power state"})
 		return
@@ -571,6 +526,7 @@ func (h *VMHandlers) UpdatePower(c *gin.Context) {
 
 	klog.Infof("VM %s (%s) power action '%s' performed by user %s (%s)", vm.Name, vm.ID, req.Action, username, userID)
 
+	setETag(c, vm.ResourceVersion)
 	c.JSON(http.StatusOK, gin.H{
 		"message": "VM power state updated successfully",
 		"action":  req.Action,
@@ -587,7 +543,7 @@ func (h *VMHandlers) GetConsoleAccess(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
@@ -605,23 +561,7 @@ func (h *VMHandlers) GetConsoleAccess(c *gin.Context) {
 		return
 	}
 
-	// Check access permissions
-	if role == models.RoleSystemAdmin {
-		// System admin can access any VM
-	} else if role == models.RoleOrgAdmin {
-		// Org admin can access VMs in their organization
-		if userOrgID == "" || userOrgID != vm.OrgID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this VM"})
-			return
-		}
-	} else if role == models.RoleOrgUser {
-		// Org user can only access their own VMs
-		if userOrgID == "" || userOrgID != vm.OrgID || userID != vm.OwnerID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this VM"})
-			return
-		}
-	} else {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	if !authorize(c, authz.VMConsole, authz.OwnedBy(vm.OrgID, vm.OwnerID)) {
 		return
 	}
 
@@ -662,7 +602,7 @@ func (h *VMHandlers) Delete(c *gin.Context) {
 	}
 
 	// Get user info from context
-	userID, username, role, userOrgID, ok := auth.GetUserFromContext(c)
+	userID, username, _, _, ok := auth.GetUserFromContext(c)
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
 		return
@@ -680,23 +620,11 @@ func (h *VMHandlers) Delete(c *gin.Context) {
 		return
 	}
 
-	// Check access permissions
-	if role == models.RoleSystemAdmin {
-		// System admin can delete any VM
-	} else if role == models.RoleOrgAdmin {
-		// Org admin can delete VMs in their organization
-		if userOrgID == "" || userOrgID != vm.OrgID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this VM"})
-			return
-		}
-	} else if role == models.RoleOrgUser {
-		// Org user can only delete their own VMs
-		if userOrgID == "" || userOrgID != vm.OrgID || userID != vm.OwnerID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to this VM"})
-			return
-		}
-	} else {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	if !authorize(c, authz.VMDelete, authz.OwnedBy(vm.OrgID, vm.OwnerID)) {
+		return
+	}
+
+	if !checkIfMatch(c, vm.ResourceVersion) {
 		return
 	}
 
@@ -713,36 +641,44 @@ func (h *VMHandlers) Delete(c *gin.Context) {
 		return
 	}
 
-	// Set VM status to deleting before actual deletion
-	vm.Status = models.VMStatusDeleting
-	if err := h.storage.UpdateVM(vm); err != nil {
-		klog.Errorf("Failed to update VM %s status to deleting: %v", id, err)
-		// Continue with deletion anyway
-	}
-
-	// Delete VM from KubeVirt cluster first
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	if err := h.provisioner.DeleteVM(ctx, vm.ID, vdc.WorkloadNamespace); err != nil {
-		klog.Errorf("Failed to delete VM %s from KubeVirt: %v", vm.ID, err)
-		// Update status back to error instead of continuing
-		vm.Status = models.VMStatusError
-		if updateErr := h.storage.UpdateVM(vm); updateErr != nil {
-			klog.Errorf("Failed to update VM %s status to error: %v", vm.ID, updateErr)
+	// A deleted VM is kept in the cluster until the trash is purged, so it can be restored.
+	// Stop it so it no longer consumes resources.
+	if vm.Status == models.VMStatusRunning {
+		if err := h.provisioner.StopVM(ctx, vm.ID, vdc.WorkloadNamespace); err != nil {
+			klog.Errorf("Failed to stop VM %s before deletion: %v", vm.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to stop VM"})
+			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete VM from cluster"})
-		return
 	}
 
-	// Delete VM from database
-	if err := h.storage.DeleteVM(id); err != nil {
+	err = h.storage.WithTx(func(tx storage.Storage) error {
+		if vm.Status != models.VMStatusStopped {
+			vm.Status = models.VMStatusStopped
+			vm.UpdatedAt = time.Now()
+			if err := tx.UpdateVM(vm); err != nil {
+				return err
+			}
+		}
+		return tx.DeleteVM(id)
+	})
+	if err != nil {
+		if err == storage.ErrConflict {
+			respondConflict(c, "VM was modified concurrently, reload it and retry")
+			return
+		}
+		if err == storage.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "VM not found"})
+			return
+		}
 		klog.Errorf("Failed to delete VM %s from database: %v", id, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete VM from database"})
 		return
 	}
 
-	klog.Infof("VM %s (%s) deleted from cluster and database by user %s (%s)", vm.Name, vm.ID, username, userID)
+	klog.Infof("VM %s (%s) moved to the trash by user %s (%s)", vm.Name, vm.ID, username, userID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "VM deleted successfully",
@@ -91,19 +91,21 @@ func TestVMHandlers_List(t *testing.T) {
 		name                string
 		userRole            string
 		userOrgID           string
+		query               string
 		mockStorageBehavior func(*MockStorage)
 		expectedStatus      int
 		expectedVMs         int
+		expectedNextCursor  string
 	}{
 		{
 			name:      "successful list all VMs (system admin)",
 			userRole:  models.RoleSystemAdmin,
 			userOrgID: "",
 			mockStorageBehavior: func(ms *MockStorage) {
-				ms.On("ListVMs", "").Return([]*models.VirtualMachine{
+				ms.On("QueryVMs", storage.ListOptions{}).Return([]*models.VirtualMachine{
 					{ID: "vm1", Name: "VM 1", OwnerID: "user1"},
 					{ID: "vm2", Name: "VM 2", OwnerID: "user2"},
-				}, nil)
+				}, "", 2, nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedVMs:    2,
@@ -113,9 +115,9 @@ func TestVMHandlers_List(t *testing.T) {
 			userRole:  models.RoleOrgAdmin,
 			userOrgID: "org1",
 			mockStorageBehavior: func(ms *MockStorage) {
-				ms.On("ListVMs", "org1").Return([]*models.VirtualMachine{
+				ms.On("QueryVMs", storage.ListOptions{OrgID: "org1"}).Return([]*models.VirtualMachine{
 					{ID: "vm1", Name: "VM 1", OwnerID: "user1"},
-				}, nil)
+				}, "", 1, nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedVMs:    1,
@@ -125,10 +127,9 @@ func TestVMHandlers_List(t *testing.T) {
 			userRole:  models.RoleOrgUser,
 			userOrgID: "org1",
 			mockStorageBehavior: func(ms *MockStorage) {
-				ms.On("ListVMs", "org1").Return([]*models.VirtualMachine{
+				ms.On("QueryVMs", storage.ListOptions{OrgID: "org1", OwnerID: "user1"}).Return([]*models.VirtualMachine{
 					{ID: "vm1", Name: "VM 1", OwnerID: "user1"},
-					{ID: "vm2", Name: "VM 2", OwnerID: "other-user"},
-				}, nil)
+				}, "", 1, nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedVMs:    1, // Only user's own VM
@@ -148,11 +149,58 @@ func TestVMHandlers_List(t *testing.T) {
 			userRole:  models.RoleSystemAdmin,
 			userOrgID: "",
 			mockStorageBehavior: func(ms *MockStorage) {
-				ms.On("ListVMs", "").Return([]*models.VirtualMachine{}, fmt.Errorf("database error"))
+				ms.On("QueryVMs", storage.ListOptions{}).Return(nil, "", 0, fmt.Errorf("database error"))
 			},
 			expectedStatus: http.StatusInternalServerError,
 			expectedVMs:    0,
 		},
+		{
+			name:      "paginated and filtered list",
+			userRole:  models.RoleSystemAdmin,
+			userOrgID: "",
+			query:     "?limit=1&cursor=abc&sort=-name&status=running&vdc_id=vdc1",
+			mockStorageBehavior: func(ms *MockStorage) {
+				opts := storage.ListOptions{Limit: 1, Cursor: "abc", Sort: "-name", Status: "running", VDCID: "vdc1"}
+				ms.On("QueryVMs", opts).Return([]*models.VirtualMachine{
+					{ID: "vm2", Name: "VM 2", OwnerID: "user2"},
+				}, "next-page", 3, nil)
+			},
+			expectedStatus: http.StatusOK,
+			// The total counts the VMs matching the filters on every page
+			expectedVMs:        3,
+			expectedNextCursor: "next-page",
+		},
+		{
+			name:      "org admin cannot query another organization",
+			userRole:  models.RoleOrgAdmin,
+			userOrgID: "org1",
+			query:     "?org_id=org2",
+			mockStorageBehavior: func(ms *MockStorage) {
+				ms.On("QueryVMs", storage.ListOptions{OrgID: "org1"}).Return([]*models.VirtualMachine{}, "", 0, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedVMs:    0,
+		},
+		{
+			name:      "invalid limit",
+			userRole:  models.RoleSystemAdmin,
+			userOrgID: "",
+			query:     "?limit=abc",
+			mockStorageBehavior: func(ms *MockStorage) {
+				// No calls expected
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "invalid sort field",
+			userRole:  models.RoleSystemAdmin,
+			userOrgID: "",
+			query:     "?sort=bogus",
+			mockStorageBehavior: func(ms *MockStorage) {
+				ms.On("QueryVMs", storage.ListOptions{Sort: "bogus"}).Return(nil, "", 0, storage.ErrInvalidInput)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
 	}
 
 	for _, tt := range tests {
@@ -161,7 +209,7 @@ func TestVMHandlers_List(t *testing.T) {
 			tt.mockStorageBehavior(mockStorage)
 
 			handlers := NewVMHandlers(mockStorage, nil, nil, nil)
-			c, w := setupGinContext("GET", "/vms", nil, "user1", "user", tt.userRole, tt.userOrgID)
+			c, w := setupGinContext("GET", "/vms"+tt.query, nil, "user1", "user", tt.userRole, tt.userOrgID)
 
 			handlers.List(c)
 
@@ -171,6 +219,7 @@ func TestVMHandlers_List(t *testing.T) {
 				err := json.Unmarshal(w.Body.Bytes(), &response)
 				require.NoError(t, err)
 				assert.Equal(t, float64(tt.expectedVMs), response["total"])
+				assert.Equal(t, tt.expectedNextCursor, response["next_cursor"])
 			}
 			mockStorage.AssertExpectations(t)
 		})
@@ -663,16 +712,20 @@ func TestVMHandlers_Delete(t *testing.T) {
 					OrgID:   "test-org",
 					VDCID:   stringPtr("test-vdc"),
 					OwnerID: "user1",
+					Status:  models.VMStatusRunning,
 				}, nil)
 				ms.On("GetVDC", "test-vdc").Return(&models.VirtualDataCenter{
 					ID:                "test-vdc",
 					WorkloadNamespace: "vdc-test-org-test-vdc",
 				}, nil)
-				ms.On("UpdateVM", mock.AnythingOfType("*models.VirtualMachine")).Return(nil)
+				ms.On("UpdateVM", mock.MatchedBy(func(vm *models.VirtualMachine) bool {
+					return vm.Status == models.VMStatusStopped
+				})).Return(nil)
 				ms.On("DeleteVM", "vm1").Return(nil)
 			},
 			mockProvBehavior: func(mp *MockVMProvisioner) {
-				mp.On("DeleteVM", mock.Anything, "vm1", "vdc-test-org-test-vdc").Return(nil)
+				// The VM is stopped and kept in the cluster while it is in the trash
+				mp.On("StopVM", mock.Anything, "vm1", "vdc-test-org-test-vdc").Return(nil)
 			},
 			expectedStatus: http.StatusOK,
 		},
@@ -718,15 +771,15 @@ func TestVMHandlers_Delete(t *testing.T) {
 					OrgID:   "test-org",
 					VDCID:   stringPtr("test-vdc"),
 					OwnerID: "user1",
+					Status:  models.VMStatusRunning,
 				}, nil)
 				ms.On("GetVDC", "test-vdc").Return(&models.VirtualDataCenter{
 					ID:                "test-vdc",
 					WorkloadNamespace: "vdc-test-org-test-vdc",
 				}, nil)
-				ms.On("UpdateVM", mock.AnythingOfType("*models.VirtualMachine")).Return(nil)
 			},
 			mockProvBehavior: func(mp *MockVMProvisioner) {
-				mp.On("DeleteVM", mock.Anything, "vm1", "vdc-test-org-test-vdc").Return(fmt.Errorf("kubevirt error"))
+				mp.On("StopVM", mock.Anything, "vm1", "vdc-test-org-test-vdc").Return(fmt.Errorf("kubevirt error"))
 			},
 			expectedStatus: http.StatusInternalServerError,
 		},
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// defaultWatchHeartbeat is how often an idle stream gets a comment, so that proxies keep it open
+const defaultWatchHeartbeat = 30 * time.Second
+
+// watchKinds are the kinds a watch may be limited to
+var watchKinds = map[string]bool{
+	storage.KindUser:          true,
+	storage.KindOrganization:  true,
+	storage.KindVDC:           true,
+	storage.KindCatalog:       true,
+	storage.KindTemplate:      true,
+	storage.KindVM:            true,
+	storage.KindCatalogSource: true,
+}
+
+// WatchHandlers handles streaming storage changes to clients
+type WatchHandlers struct {
+	storage   storage.Storage
+	heartbeat time.Duration
+}
+
+// NewWatchHandlers creates a new watch handlers instance
+func NewWatchHandlers(storage storage.Storage) *WatchHandlers {
+	return &WatchHandlers{
+		storage:   storage,
+		heartbeat: defaultWatchHeartbeat,
+	}
+}
+
+// watcher is the caller of a watch, whose permissions decide which changes it sees
+type watcher struct {
+	userID      string
+	orgID       string
+	permissions *authz.Permissions
+	kinds       map[string]bool
+}
+
+// Watch handles streaming changes as Server-Sent Events. Every change the caller may see is sent
+// as an event named after the change type, with the change as JSON data. The kind query parameter
+// limits the stream to a comma separated list of kinds. When the server can no longer tell what
+// changed it sends a reset event and ends the stream, clients then list again and reconnect.
+func (h *WatchHandlers) Watch(c *gin.Context) {
+	userID, username, _, orgID, ok := auth.GetUserFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User context not found"})
+		return
+	}
+	if _, ok := authorizeList(c, authz.ChangeWatch); !ok {
+		return
+	}
+	permissions, err := authz.FromContext(c).Permissions(c)
+	if !respondAuthzError(c, authz.ChangeWatch, err) {
+		return
+	}
+
+	w := &watcher{userID: userID, orgID: orgID, permissions: permissions}
+	if kinds := c.Query("kind"); kinds != "" {
+		w.kinds = make(map[string]bool)
+		for _, kind := range strings.Split(kinds, ",") {
+			kind = strings.TrimSpace(kind)
+			if !watchKinds[kind] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown kind: " + kind})
+				return
+			}
+			w.kinds[kind] = true
+		}
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	events, err := h.storage.Watch(ctx)
+	if err != nil {
+		klog.Errorf("Failed to watch changes for user %s (%s): %v", username, userID, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Change feed unavailable"})
+		return
+	}
+
+	// The server write timeout is meant for ordinary requests, a stream lasts as long as the client wants
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		klog.V(4).Infof("Could not lift the write deadline of a watch: %v", err)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	klog.V(4).Infof("User %s (%s) started watching changes", username, userID)
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(out io.Writer) bool {
+		select {
+		case event, open := <-events:
+			if !open {
+				if ctx.Err() == nil {
+					c.SSEvent("reset", gin.H{})
+				}
+				return false
+			}
+			if w.sees(event) {
+				c.SSEvent(string(event.Type), event)
+			}
+			return true
+		case <-heartbeat.C:
+			_, err := io.WriteString(out, ": heartbeat\n\n")
+			return err == nil
+		case <-ctx.Done():
+			return false
+		}
+	})
+	klog.V(4).Infof("User %s (%s) stopped watching changes", username, userID)
+}
+
+// sees tells whether the watcher may see a change, following what the list and get endpoints
+// show it
+func (w *watcher) sees(event storage.ChangeEvent) bool {
+	if w.kinds != nil && !w.kinds[event.Kind] {
+		return false
+	}
+
+	switch {
+	case event.Kind == storage.KindUser && event.ID == w.userID:
+		// Everyone follows their own account, including a move to another organization
+		return true
+	case event.Kind == storage.KindTemplate && event.OrgID == "":
+		// Global templates are part of everyone's catalog
+		return w.permissions.Allows(authz.CatalogView, authz.InOrganization(w.orgID))
+	}
+
+	resource := authz.OwnedBy(event.OrgID, event.OwnerID)
+	switch event.Kind {
+	case storage.KindUser:
+		return w.permissions.Allows(authz.UserList, resource)
+	case storage.KindOrganization:
+		return w.permissions.Allows(authz.OrgGet, resource)
+	case storage.KindVDC:
+		return w.permissions.Allows(authz.VDCGet, resource)
+	case storage.KindVM:
+		return w.permissions.Allows(authz.VMGet, resource)
+	case storage.KindCatalogSource:
+		return w.permissions.Allows(authz.CatalogManage, resource)
+	default:
+		return w.permissions.Allows(authz.CatalogView, resource)
+	}
+}
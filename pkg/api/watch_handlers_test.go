@@ -0,0 +1,203 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/authz"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// sseEvent is an event read from a Server-Sent Events stream
+type sseEvent struct {
+	name   string
+	change storage.ChangeEvent
+}
+
+// startWatch opens a watch stream as the given user and returns its events as they arrive
+func startWatch(t *testing.T, store storage.Storage, userID, role, orgID, query string) <-chan sseEvent {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/watch", func(c *gin.Context) {
+		c.Set(auth.ContextKeyUserID, userID)
+		c.Set(auth.ContextKeyUsername, userID)
+		c.Set(auth.ContextKeyRole, role)
+		c.Set(auth.ContextKeyOrgID, orgID)
+	}, NewWatchHandlers(store).Watch)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/watch"+query, nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer resp.Body.Close()
+		defer close(events)
+		var event sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event:"):
+				event.name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			case strings.HasPrefix(line, "data:"):
+				_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &event.change)
+			case line == "" && event.name != "":
+				events <- event
+				event = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+// nextSSEvent returns the next event of a stream, failing the test if none arrives
+func nextSSEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "stream ended")
+		return event
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no event received")
+		return sseEvent{}
+	}
+}
+
+func TestWatchHandlers_Watch(t *testing.T) {
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+	events := startWatch(t, store, "user-1", models.RoleOrgUser, "watch-org", "")
+
+	// Changes of other organizations and other users' VMs are filtered out
+	require.NoError(t, store.CreateOrganization(&models.Organization{ID: "other-org", Name: "Other", CRName: "other-org"}))
+	require.NoError(t, store.CreateVM(&models.VirtualMachine{ID: "vm-other", Name: "other", OrgID: "watch-org", OwnerID: "user-2", Status: models.VMStatusPending}))
+	require.NoError(t, store.CreateVM(&models.VirtualMachine{ID: "vm-own", Name: "own", OrgID: "watch-org", OwnerID: "user-1", Status: models.VMStatusPending}))
+
+	event := nextSSEvent(t, events)
+	assert.Equal(t, "created", event.name)
+	assert.Equal(t, storage.ChangeEvent{Type: storage.ChangeCreated, Kind: storage.KindVM, ID: "vm-own", OrgID: "watch-org", OwnerID: "user-1", ResourceVersion: 1}, event.change)
+
+	require.NoError(t, store.DeleteVM("vm-own"))
+	event = nextSSEvent(t, events)
+	assert.Equal(t, "deleted", event.name)
+	assert.Equal(t, "vm-own", event.change.ID)
+
+	// A dropped watch is reported before the stream ends
+	require.NoError(t, store.Close())
+	assert.Equal(t, "reset", nextSSEvent(t, events).name)
+	_, open := <-events
+	assert.False(t, open)
+}
+
+func TestWatchHandlers_WatchKinds(t *testing.T) {
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+	events := startWatch(t, store, "admin", models.RoleSystemAdmin, "", "?kind=vdc")
+
+	require.NoError(t, store.CreateOrganization(&models.Organization{ID: "watch-org", Name: "Watch", CRName: "watch-org"}))
+	require.NoError(t, store.CreateVDC(&models.VirtualDataCenter{ID: "vdc-1", Name: "vdc", OrgID: "watch-org", CRName: "vdc-1", CRNamespace: "watch-org"}))
+
+	event := nextSSEvent(t, events)
+	assert.Equal(t, storage.KindVDC, event.change.Kind)
+	assert.Equal(t, "vdc-1", event.change.ID)
+}
+
+func TestWatchHandlers_WatchErrors(t *testing.T) {
+	t.Run("unknown kind", func(t *testing.T) {
+		store, err := storage.NewMemoryStorageForTest()
+		require.NoError(t, err)
+		c, w := setupGinContext(http.MethodGet, "/watch?kind=vm,disk", nil, "admin", "admin", models.RoleSystemAdmin, "")
+		NewWatchHandlers(store).Watch(c)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("no organization", func(t *testing.T) {
+		store, err := storage.NewMemoryStorageForTest()
+		require.NoError(t, err)
+		c, w := setupGinContext(http.MethodGet, "/watch", nil, "user-1", "user", models.RoleOrgUser, "")
+		NewWatchHandlers(store).Watch(c)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("feed unavailable", func(t *testing.T) {
+		store := &MockStorage{}
+		store.On("Watch", mock.Anything).Return(nil, errors.New("connection refused"))
+		c, w := setupGinContext(http.MethodGet, "/watch", nil, "admin", "admin", models.RoleSystemAdmin, "")
+		NewWatchHandlers(store).Watch(c)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		store.AssertExpectations(t)
+	})
+}
+
+func TestWatcher_Sees(t *testing.T) {
+	vm := func(orgID, ownerID string) storage.ChangeEvent {
+		return storage.ChangeEvent{Type: storage.ChangeCreated, Kind: storage.KindVM, ID: "vm", OrgID: orgID, OwnerID: ownerID}
+	}
+	event := func(kind, id, orgID string) storage.ChangeEvent {
+		return storage.ChangeEvent{Type: storage.ChangeUpdated, Kind: kind, ID: id, OrgID: orgID}
+	}
+
+	newWatcher := func(userID, role, orgID string) *watcher {
+		permissions, err := authz.NewAuthorizer(nil).PermissionsFor(authz.Subject{UserID: userID, Role: role, OrgID: orgID})
+		require.NoError(t, err)
+		return &watcher{userID: userID, orgID: orgID, permissions: permissions}
+	}
+	systemAdmin := newWatcher("admin", models.RoleSystemAdmin, "")
+	orgAdmin := newWatcher("org-admin", models.RoleOrgAdmin, "org-1")
+	orgUser := newWatcher("user-1", models.RoleOrgUser, "org-1")
+	orgMember := newWatcher("member-1", models.RoleOrgMember, "org-1")
+	vdcsOnly := newWatcher("org-admin", models.RoleOrgAdmin, "org-1")
+	vdcsOnly.kinds = map[string]bool{storage.KindVDC: true}
+
+	tests := []struct {
+		name    string
+		watcher *watcher
+		event   storage.ChangeEvent
+		sees    bool
+	}{
+		{"system admin sees other organizations", systemAdmin, vm("org-2", "user-2"), true},
+		{"org admin sees VMs of the organization", orgAdmin, vm("org-1", "user-1"), true},
+		{"org admin does not see other organizations", orgAdmin, vm("org-2", "user-2"), false},
+		{"org admin sees users of the organization", orgAdmin, event(storage.KindUser, "user-1", "org-1"), true},
+		{"org admin sees catalog sources", orgAdmin, event(storage.KindCatalogSource, "source-1", "org-1"), true},
+		{"org user sees own VMs", orgUser, vm("org-1", "user-1"), true},
+		{"org user does not see VMs of others", orgUser, vm("org-1", "user-2"), false},
+		{"org user sees VDCs of the organization", orgUser, event(storage.KindVDC, "vdc-1", "org-1"), true},
+		{"org user sees the organization", orgUser, event(storage.KindOrganization, "org-1", "org-1"), true},
+		{"org user does not see other users", orgUser, event(storage.KindUser, "user-2", "org-1"), false},
+		{"org user sees own account leave the organization", orgUser, event(storage.KindUser, "user-1", "org-2"), true},
+		{"org user does not see catalog sources", orgUser, event(storage.KindCatalogSource, "source-1", "org-1"), false},
+		{"org member does not see VMs", orgMember, vm("org-1", "member-1"), false},
+		{"everyone sees global templates", orgMember, event(storage.KindTemplate, "template-1", ""), true},
+		{"organization templates stay in the organization", orgMember, event(storage.KindTemplate, "template-1", "org-2"), false},
+		{"kinds limit the stream", vdcsOnly, vm("org-1", "user-1"), false},
+		{"kinds let listed kinds through", vdcsOnly, event(storage.KindVDC, "vdc-1", "org-1"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.sees, tt.watcher.sees(tt.event))
+		})
+	}
+}
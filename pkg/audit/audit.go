@@ -0,0 +1,207 @@
+// Package audit keeps the trail of the mutating calls made to the API, which compliance reviews
+// and security investigations rely on.
+//
+// The API stores a models.AuditRecord for every call and writes it to the configured sinks,
+// such as a JSON-lines file a SIEM agent ships. This package redacts the secrets of requests
+// before they are recorded, computes the changes a call made from the resource before and after
+// it, and provides the sinks.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+)
+
+// Redacted replaces the values of secret attributes
+const Redacted = "[REDACTED]"
+
+// secretWords are the words of attribute names whose values are secrets, such as password,
+// new_password, refresh_token or recovery_codes
+var secretWords = map[string]bool{
+	"password":    true,
+	"passwd":      true,
+	"passphrase":  true,
+	"secret":      true,
+	"token":       true,
+	"tokens":      true,
+	"code":        true,
+	"codes":       true,
+	"otpauth":     true,
+	"credential":  true,
+	"credentials": true,
+}
+
+// ignoredChanges are the attributes every update changes, which tell nothing about the call
+var ignoredChanges = map[string]bool{
+	"updated_at":       true,
+	"resource_version": true,
+}
+
+// Sink receives every audit record, after it is stored
+type Sink interface {
+	// Write records the record, or returns why it could not
+	Write(record *models.AuditRecord) error
+	// Close releases the resources of the sink
+	Close() error
+}
+
+// FileSink appends records to a file as JSON lines, one record per line
+type FileSink struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewFileSink opens the file records are appended to, creating it readable by its owner only.
+// Rotating the file by copying and truncating it loses no record.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Write appends the record as a line of JSON, written at once so that lines never interleave
+func (s *FileSink) Write(record *models.AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record %s: %w", record.ID, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit record %s: %w", record.ID, err)
+	}
+	return nil
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
+
+// IsSecret reports whether an attribute holds a secret: one of the words of its name, split on
+// underscores and case changes, is a secret word. Timestamps such as password_changed_at are not
+// secrets.
+func IsSecret(name string) bool {
+	if strings.HasSuffix(name, "_at") {
+		return false
+	}
+	for _, word := range splitWords(name) {
+		if secretWords[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// splitWords splits a snake_case or camelCase name into its lowercase words
+func splitWords(name string) []string {
+	var words []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, strings.ToLower(word.String()))
+			word.Reset()
+		}
+	}
+	for _, r := range name {
+		switch {
+		case r == '_' || r == '-' || r == '.':
+			flush()
+		case r >= 'A' && r <= 'Z':
+			flush()
+			word.WriteRune(r)
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+	return words
+}
+
+// Redact returns a copy of a JSON value whose secret attributes, at any depth, are Redacted
+func Redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for name, attribute := range v {
+			if isSecretValue(name, attribute) {
+				redacted[name] = Redacted
+				continue
+			}
+			redacted[name] = Redact(attribute)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, element := range v {
+			redacted[i] = Redact(element)
+		}
+		return redacted
+	default:
+		return value
+	}
+}
+
+// isSecretValue reports whether the value of an attribute is a secret. Flags such as
+// must_change_password are not.
+func isSecretValue(name string, value interface{}) bool {
+	if _, isFlag := value.(bool); isFlag || value == nil {
+		return false
+	}
+	return IsSecret(name)
+}
+
+// redactAttribute returns the value of an attribute with its secrets redacted
+func redactAttribute(name string, value interface{}) interface{} {
+	if isSecretValue(name, value) {
+		return Redacted
+	}
+	return Redact(value)
+}
+
+// Diff returns the attributes that differ between two states of a resource, given as JSON
+// objects, each with its "old" and "new" value. A nil state is a resource that does not exist:
+// the attributes of a created resource only have a new value, and those of a deleted one only
+// an old value. Secrets are redacted and the attributes every update changes are left out. It
+// returns nil when nothing changed.
+func Diff(before, after map[string]interface{}) map[string]interface{} {
+	changes := make(map[string]interface{})
+	record := func(name string, oldValue, newValue interface{}, hasOld, hasNew bool) {
+		if ignoredChanges[name] || (hasOld && hasNew && reflect.DeepEqual(oldValue, newValue)) {
+			return
+		}
+		change := make(map[string]interface{}, 2)
+		if hasOld {
+			change["old"] = redactAttribute(name, oldValue)
+		}
+		if hasNew {
+			change["new"] = redactAttribute(name, newValue)
+		}
+		changes[name] = change
+	}
+
+	for name, oldValue := range before {
+		newValue, hasNew := after[name]
+		record(name, oldValue, newValue, true, hasNew)
+	}
+	for name, newValue := range after {
+		if _, hasOld := before[name]; !hasOld {
+			record(name, nil, newValue, false, true)
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
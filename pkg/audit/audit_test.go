@@ -0,0 +1,123 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+)
+
+func TestIsSecret(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"password", true},
+		{"new_password", true},
+		{"currentPassword", true},
+		{"client_secret", true},
+		{"refresh_token", true},
+		{"recovery_codes", true},
+		{"code", true},
+		{"bind-password", true},
+		{"password_changed_at", false},
+		{"username", false},
+		{"tokenizer", false},
+		{"description", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, IsSecret(tt.name))
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	value := map[string]interface{}{
+		"username":             "alice",
+		"password":             "hunter22",
+		"must_change_password": true,
+		"mfa":                  map[string]interface{}{"code": "123456", "method": "totp"},
+		"sources":              []interface{}{map[string]interface{}{"url": "https://example.com", "token": "abc"}},
+		"secret":               nil,
+	}
+
+	redacted := Redact(value)
+
+	assert.Equal(t, map[string]interface{}{
+		"username":             "alice",
+		"password":             Redacted,
+		"must_change_password": true,
+		"mfa":                  map[string]interface{}{"code": Redacted, "method": "totp"},
+		"sources":              []interface{}{map[string]interface{}{"url": "https://example.com", "token": Redacted}},
+		"secret":               nil,
+	}, redacted)
+	assert.Equal(t, "hunter22", value["password"], "the value is not modified")
+}
+
+func TestDiff(t *testing.T) {
+	before := map[string]interface{}{
+		"name":             "web",
+		"cpu":              float64(2),
+		"labels":           map[string]interface{}{"tier": "front"},
+		"password":         "old",
+		"updated_at":       "2026-01-01T00:00:00Z",
+		"resource_version": float64(1),
+		"retired":          "soon",
+	}
+	after := map[string]interface{}{
+		"name":             "web",
+		"cpu":              float64(4),
+		"labels":           map[string]interface{}{"tier": "front"},
+		"password":         "new",
+		"updated_at":       "2026-01-02T00:00:00Z",
+		"resource_version": float64(2),
+		"owner":            "alice",
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"cpu":      map[string]interface{}{"old": float64(2), "new": float64(4)},
+		"password": map[string]interface{}{"old": Redacted, "new": Redacted},
+		"retired":  map[string]interface{}{"old": "soon"},
+		"owner":    map[string]interface{}{"new": "alice"},
+	}, Diff(before, after))
+
+	assert.Equal(t, map[string]interface{}{"name": map[string]interface{}{"new": "web"}}, Diff(nil, map[string]interface{}{"name": "web"}))
+	assert.Equal(t, map[string]interface{}{"name": map[string]interface{}{"old": "web"}}, Diff(map[string]interface{}{"name": "web"}, nil))
+	assert.Nil(t, Diff(before, before))
+	assert.Nil(t, Diff(nil, nil))
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, os.WriteFile(path, []byte("{\"id\":\"earlier\"}\n"), 0o600))
+
+	sink, err := NewFileSink(path)
+	require.NoError(t, err)
+	require.NoError(t, sink.Write(&models.AuditRecord{ID: "audit-1", ActorUsername: "alice", Outcome: models.AuditOutcomeSuccess}))
+	require.NoError(t, sink.Write(&models.AuditRecord{ID: "audit-2", ActorUsername: "bob", Outcome: models.AuditOutcomeDenied}))
+	require.NoError(t, sink.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var ids []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record models.AuditRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record), "every line is a record")
+		ids = append(ids, record.ID)
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []string{"earlier", "audit-1", "audit-2"}, ids, "records are appended")
+
+	_, err = NewFileSink(filepath.Join(t.TempDir(), "missing", "audit.log"))
+	assert.Error(t, err)
+}
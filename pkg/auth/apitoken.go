@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// APITokenPrefix starts every API token, which tells them apart from JWTs
+	APITokenPrefix = "ovim_"
+
+	// apiTokenBytes is the number of random bytes in an API token
+	apiTokenBytes = 32
+
+	// apiTokenDisplayLength is the length of the token prefix that is kept to identify a token
+	apiTokenDisplayLength = len(APITokenPrefix) + 8
+
+	// ScopeAnyMethod is the scope method allowing every method
+	ScopeAnyMethod = "*"
+)
+
+// ErrInvalidAPIToken is returned for API tokens that are unknown, expired or whose user is gone
+var ErrInvalidAPIToken = errors.New("invalid API token")
+
+// scopeMethods are the methods a scope may allow
+var scopeMethods = map[string]bool{
+	ScopeAnyMethod:     true,
+	http.MethodGet:     true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// APITokenIdentity is the user an API token acts for, with the scopes limiting the token
+type APITokenIdentity struct {
+	TokenID  string
+	UserID   string
+	Username string
+	Role     string
+	OrgID    string
+	Scopes   []string
+}
+
+// APITokenAuthenticator resolves API tokens to the user they act for
+type APITokenAuthenticator interface {
+	// AuthenticateAPIToken returns ErrInvalidAPIToken if the token cannot be used
+	AuthenticateAPIToken(token string) (*APITokenIdentity, error)
+}
+
+// NewAPIToken returns a random API token, the hash under which it is stored and the prefix
+// shown to identify it
+func NewAPIToken() (token, hash, prefix string, err error) {
+	b := make([]byte, apiTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate API token: %w", err)
+	}
+	token = APITokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashAPIToken(token), token[:apiTokenDisplayLength], nil
+}
+
+// HashAPIToken returns the hash under which an API token is stored
+func HashAPIToken(token string) string {
+	return hashToken(token)
+}
+
+// IsAPIToken reports whether a bearer token is an API token rather than a JWT
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// NormalizeScope validates a scope of the form "METHOD /path" and returns it in canonical form,
+// with an upper case method and no trailing slash
+func NormalizeScope(scope string) (string, error) {
+	method, path, found := strings.Cut(strings.TrimSpace(scope), " ")
+	if !found {
+		return "", fmt.Errorf("scope %q must be a method and a path", scope)
+	}
+	method = strings.ToUpper(method)
+	if !scopeMethods[method] {
+		return "", fmt.Errorf("scope %q has an unsupported method", scope)
+	}
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, " ?#") {
+		return "", fmt.Errorf("scope %q must have an absolute path", scope)
+	}
+	return method + " " + cleanScopePath(path), nil
+}
+
+// ScopesAllow reports whether one of the scopes allows a request. A scope allows its path and
+// every path below it.
+func ScopesAllow(scopes []string, method, path string) bool {
+	requestSegments := pathSegments(path)
+	for _, scope := range scopes {
+		scopeMethod, scopePath, found := strings.Cut(scope, " ")
+		if !found || (scopeMethod != ScopeAnyMethod && !strings.EqualFold(scopeMethod, method)) {
+			continue
+		}
+		if segmentsMatch(pathSegments(scopePath), requestSegments) {
+			return true
+		}
+	}
+	return false
+}
+
+// segmentsMatch reports whether the scope segments are a prefix of the request segments
+func segmentsMatch(scope, request []string) bool {
+	if len(scope) > len(request) {
+		return false
+	}
+	for i, segment := range scope {
+		if segment != "*" && segment != request[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func cleanScopePath(path string) string {
+	return "/" + strings.Join(pathSegments(path), "/")
+}
+
+func pathSegments(path string) []string {
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAPIToken(t *testing.T) {
+	token, hash, prefix, err := NewAPIToken()
+	require.NoError(t, err)
+	assert.True(t, IsAPIToken(token))
+	assert.Len(t, token, len(APITokenPrefix)+43)
+	assert.Equal(t, HashAPIToken(token), hash)
+	assert.Equal(t, token[:len(prefix)], prefix)
+	assert.Len(t, prefix, len(APITokenPrefix)+8)
+
+	other, _, _, err := NewAPIToken()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+
+	jwtToken, err := NewTokenManager("test-secret", 0).GenerateToken("user-123", "testuser", "org_user", "")
+	require.NoError(t, err)
+	assert.False(t, IsAPIToken(jwtToken))
+}
+
+func TestNormalizeScope(t *testing.T) {
+	tests := []struct {
+		scope    string
+		expected string
+		wantErr  bool
+	}{
+		{"GET /api/v1/vms", "GET /api/v1/vms", false},
+		{"get /api/v1/vms/", "GET /api/v1/vms", false},
+		{" delete  /api/v1//vms/* ", "DELETE /api/v1/vms/*", false},
+		{"* /", "* /", false},
+		{"GET", "", true},
+		{"FETCH /api/v1", "", true},
+		{"GET api/v1", "", true},
+		{"GET /api/v1?org_id=org-1", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			scope, err := NormalizeScope(tt.scope)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, scope)
+		})
+	}
+}
+
+func TestScopesAllow(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  []string
+		method  string
+		path    string
+		allowed bool
+	}{
+		{"exact path", []string{"GET /api/v1/vms"}, http.MethodGet, "/api/v1/vms", true},
+		{"trailing slash", []string{"GET /api/v1/vms"}, http.MethodGet, "/api/v1/vms/", true},
+		{"sub path", []string{"GET /api/v1/vms"}, http.MethodGet, "/api/v1/vms/vm-1/status", true},
+		{"other method", []string{"GET /api/v1/vms"}, http.MethodDelete, "/api/v1/vms/vm-1", false},
+		{"any method", []string{"* /api/v1/vms"}, http.MethodDelete, "/api/v1/vms/vm-1", true},
+		{"segment boundary", []string{"GET /api/v1/vm"}, http.MethodGet, "/api/v1/vms", false},
+		{"parent path", []string{"GET /api/v1/vms"}, http.MethodGet, "/api/v1", false},
+		{"wildcard segment", []string{"PUT /api/v1/vms/*/power"}, http.MethodPut, "/api/v1/vms/vm-1/power", true},
+		{"wildcard segment mismatch", []string{"PUT /api/v1/vms/*/power"}, http.MethodPut, "/api/v1/vms/vm-1", false},
+		{"root", []string{"GET /"}, http.MethodGet, "/api/v1/organizations", true},
+		{"second scope", []string{"GET /api/v1/vdcs", "POST /api/v1/vms"}, http.MethodPost, "/api/v1/vms", true},
+		{"no scopes", nil, http.MethodGet, "/api/v1/vms", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.allowed, ScopesAllow(tt.scopes, tt.method, tt.path))
+		})
+	}
+}
@@ -2,16 +2,27 @@ package auth
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/eliorerz/ovim-updated/pkg/util"
 )
 
 const (
 	// JWT configuration constants
-	DefaultTokenDuration = 24 * time.Hour
-	JWTIssuer            = "ovim-backend"
-	JWTSigningMethod     = "HS256"
+	DefaultTokenDuration        = 24 * time.Hour
+	DefaultRefreshTokenDuration = 7 * 24 * time.Hour
+	JWTIssuer                   = "ovim-backend"
+	JWTSigningMethod            = "HS256"
+
+	// MFAChallengeDuration is how long the second factor of a login can be given
+	MFAChallengeDuration = 5 * time.Minute
+	mfaChallengeAudience = "ovim-mfa"
+
+	// invitationAudience restricts invitation tokens to accepting invitations
+	invitationAudience = "ovim-invitation"
 )
 
 // Claims represents JWT claims for OVIM
@@ -20,13 +31,51 @@ type Claims struct {
 	Username string `json:"username"`
 	Role     string `json:"role"`
 	OrgID    string `json:"org_id,omitempty"`
+	// SessionID is the login session whose refresh tokens renew the token
+	SessionID string `json:"sid,omitempty"`
+	// PasswordChangeRequired limits the token to changing the password of the user
+	PasswordChangeRequired bool `json:"pwd_change,omitempty"`
+	// MFAEnrollmentRequired limits the token to enrolling a second factor
+	MFAEnrollmentRequired bool `json:"mfa_enroll,omitempty"`
+	// Actor is the administrator impersonating the user with the token
+	Actor *Actor `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// TokenManager handles JWT token operations
+// Actor is the party acting as the subject of a token, the act claim of RFC 8693
+type Actor struct {
+	Subject  string `json:"sub"`
+	Username string `json:"username"`
+	// ImpersonationID is the record of the impersonation the user can see
+	ImpersonationID string `json:"impersonation_id,omitempty"`
+}
+
+// MFAChallengeClaims are the claims of the token proving the password of a login whose second
+// factor is still missing. They carry no role, so the token is no access token.
+type MFAChallengeClaims struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	jwt.RegisteredClaims
+}
+
+// InvitationClaims are the claims of the token accepting an invitation, whose ID is the subject.
+// The token ID is stored with the invitation, so that only the latest token sent can be used.
+type InvitationClaims struct {
+	jwt.RegisteredClaims
+}
+
+// TokenManager handles JWT token operations. Tokens are signed with an HMAC secret until
+// signing keys are set, after which only tokens signed by one of the keys are accepted.
 type TokenManager struct {
-	secret   []byte
-	duration time.Duration
+	secret          []byte
+	duration        time.Duration
+	refreshDuration time.Duration
+	now             func() time.Time
+
+	mu sync.RWMutex
+	// keys are the signing keys, oldest first
+	keys         []*SigningKey
+	publishDelay time.Duration
 }
 
 // NewTokenManager creates a new token manager
@@ -35,34 +84,181 @@ func NewTokenManager(secret string, duration time.Duration) *TokenManager {
 		duration = DefaultTokenDuration
 	}
 	return &TokenManager{
-		secret:   []byte(secret),
-		duration: duration,
+		secret:          []byte(secret),
+		duration:        duration,
+		refreshDuration: DefaultRefreshTokenDuration,
+		now:             time.Now,
 	}
 }
 
+// SetSigningKeys replaces the keys tokens are signed and verified with. The newest key signs,
+// but a key with a creation time only does once publishDelay passed, so that every replica
+// and relying party knows it before its first token.
+func (tm *TokenManager) SetSigningKeys(keys []*SigningKey, publishDelay time.Duration) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("at least one signing key is required")
+	}
+	sorted := append([]*SigningKey{}, keys...)
+	sortSigningKeys(sorted)
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].ID == sorted[i-1].ID {
+			return fmt.Errorf("duplicate signing key ID %q", sorted[i].ID)
+		}
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.keys = sorted
+	tm.publishDelay = publishDelay
+	return nil
+}
+
+// JWKS returns the public keys tokens are verified with, empty while tokens are signed with
+// the HMAC secret
+func (tm *TokenManager) JWKS() JSONWebKeySet {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range tm.keys {
+		set.Keys = append(set.Keys, key.JWK())
+	}
+	return set
+}
+
+// signingKey returns the key signing new tokens, nil while the HMAC secret signs them
+func (tm *TokenManager) signingKey() *SigningKey {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	if len(tm.keys) == 0 {
+		return nil
+	}
+	now := tm.now()
+	for i := len(tm.keys) - 1; i >= 0; i-- {
+		key := tm.keys[i]
+		if key.Created.IsZero() || !key.Created.Add(tm.publishDelay).After(now) {
+			return key
+		}
+	}
+	// Every key is too new, as when the first key was just generated
+	return tm.keys[0]
+}
+
+// sign signs the claims with the current signing key, or the HMAC secret
+func (tm *TokenManager) sign(claims jwt.Claims) (string, error) {
+	key := tm.signingKey()
+	if key == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(tm.secret)
+	}
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
+
+// verificationKey returns the key verifying a token: the signing key named by its kid header,
+// or the HMAC secret while no signing keys are set
+func (tm *TokenManager) verificationKey(token *jwt.Token) (interface{}, error) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	if len(tm.keys) == 0 {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return tm.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	for _, key := range tm.keys {
+		if key.ID != kid {
+			continue
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.private.Public(), nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// SetRefreshDuration sets how long a login session can be renewed with refresh tokens
+func (tm *TokenManager) SetRefreshDuration(duration time.Duration) {
+	if duration > 0 {
+		tm.refreshDuration = duration
+	}
+}
+
+// RefreshDuration returns how long a login session can be renewed with refresh tokens
+func (tm *TokenManager) RefreshDuration() time.Duration {
+	return tm.refreshDuration
+}
+
 // GenerateToken creates a new JWT token for the user
 func (tm *TokenManager) GenerateToken(userID, username, role, orgID string) (string, error) {
-	if userID == "" || username == "" || role == "" {
+	token, _, err := tm.GenerateSessionToken(userID, username, role, orgID, "")
+	return token, err
+}
+
+// GenerateSessionToken creates a new JWT token for the user in a login session and returns it
+// with its claims. Every token gets a unique ID, the jti, under which it can be revoked.
+func (tm *TokenManager) GenerateSessionToken(userID, username, role, orgID, sessionID string) (string, *Claims, error) {
+	claims := &Claims{
+		UserID:    userID,
+		Username:  username,
+		Role:      role,
+		OrgID:     orgID,
+		SessionID: sessionID,
+	}
+	token, err := tm.GenerateClaimsToken(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
+}
+
+// GenerateClaimsToken creates a new JWT token carrying the given user claims. It sets the
+// registered claims: a unique ID, the issuer, the subject and the validity.
+func (tm *TokenManager) GenerateClaimsToken(claims *Claims) (string, error) {
+	return tm.generateClaimsToken(claims, tm.duration)
+}
+
+// GenerateImpersonationToken creates a token letting the actor act as the user of the claims
+// for the given duration, which the lifetime of access tokens caps. The token has no session,
+// so it cannot be refreshed.
+func (tm *TokenManager) GenerateImpersonationToken(claims *Claims, actor *Actor, duration time.Duration) (string, error) {
+	if actor == nil || actor.Subject == "" || actor.Username == "" {
+		return "", fmt.Errorf("actor is required")
+	}
+	if actor.Subject == claims.UserID {
+		return "", fmt.Errorf("users cannot impersonate themselves")
+	}
+	if duration <= 0 || duration > tm.duration {
+		duration = tm.duration
+	}
+	claims.Actor = actor
+	claims.SessionID = ""
+	return tm.generateClaimsToken(claims, duration)
+}
+
+func (tm *TokenManager) generateClaimsToken(claims *Claims, duration time.Duration) (string, error) {
+	if claims.UserID == "" || claims.Username == "" || claims.Role == "" {
 		return "", fmt.Errorf("userID, username, and role are required")
 	}
 
+	tokenID, err := util.GenerateID(32)
+	if err != nil {
+		return "", err
+	}
+
 	now := time.Now()
-	claims := &Claims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
-		OrgID:    orgID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(now.Add(tm.duration)),
-			IssuedAt:  jwt.NewNumericDate(now),
-			NotBefore: jwt.NewNumericDate(now),
-			Issuer:    JWTIssuer,
-			Subject:   userID,
-		},
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        tokenID,
+		ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Issuer:    JWTIssuer,
+		Subject:   claims.UserID,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(tm.secret)
+	return tm.sign(claims)
 }
 
 // ValidateToken validates a JWT token and returns the claims
@@ -72,12 +268,7 @@ func (tm *TokenManager) ValidateToken(tokenString string) (*Claims, error) {
 	}
 
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return tm.secret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, tm.verificationKey)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
@@ -95,6 +286,100 @@ func (tm *TokenManager) ValidateToken(tokenString string) (*Claims, error) {
 	return claims, nil
 }
 
+// GenerateMFAChallenge creates the token a user exchanges with the second factor for the tokens
+// of the login, and returns it with its expiry
+func (tm *TokenManager) GenerateMFAChallenge(userID, username string) (string, time.Time, error) {
+	if userID == "" || username == "" {
+		return "", time.Time{}, fmt.Errorf("userID and username are required")
+	}
+
+	tokenID, err := util.GenerateID(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(MFAChallengeDuration)
+	claims := &MFAChallengeClaims{
+		UserID:   userID,
+		Username: username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    JWTIssuer,
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{mfaChallengeAudience},
+		},
+	}
+
+	token, err := tm.sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// ValidateMFAChallenge validates a token created by GenerateMFAChallenge and returns its claims
+func (tm *TokenManager) ValidateMFAChallenge(tokenString string) (*MFAChallengeClaims, error) {
+	claims := &MFAChallengeClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, tm.verificationKey, jwt.WithAudience(mfaChallengeAudience))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MFA challenge: %w", err)
+	}
+	if claims.UserID == "" || claims.Username == "" || claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("MFA challenge contains invalid claims")
+	}
+	return claims, nil
+}
+
+// GenerateInvitationToken creates the token accepting an invitation until expiresAt, and returns
+// it with its ID
+func (tm *TokenManager) GenerateInvitationToken(invitationID string, expiresAt time.Time) (token, tokenID string, err error) {
+	if invitationID == "" {
+		return "", "", fmt.Errorf("invitationID is required")
+	}
+
+	tokenID, err = util.GenerateID(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	claims := &InvitationClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    JWTIssuer,
+			Subject:   invitationID,
+			Audience:  jwt.ClaimStrings{invitationAudience},
+		},
+	}
+
+	token, err = tm.sign(claims)
+	if err != nil {
+		return "", "", err
+	}
+	return token, tokenID, nil
+}
+
+// ValidateInvitationToken validates a token created by GenerateInvitationToken and returns its
+// claims
+func (tm *TokenManager) ValidateInvitationToken(tokenString string) (*InvitationClaims, error) {
+	claims := &InvitationClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, tm.verificationKey, jwt.WithAudience(invitationAudience))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse invitation token: %w", err)
+	}
+	if claims.Subject == "" || claims.ID == "" || claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("invitation token contains invalid claims")
+	}
+	return claims, nil
+}
+
 // Legacy functions for backward compatibility
 func GenerateToken(userID, username, role, orgID, secret string) (string, error) {
 	tm := NewTokenManager(secret, DefaultTokenDuration)
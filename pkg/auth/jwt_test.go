@@ -71,6 +71,52 @@ func TestTokenManager_GenerateToken(t *testing.T) {
 	})
 }
 
+func TestTokenManager_GenerateSessionToken(t *testing.T) {
+	tm := NewTokenManager("test-secret", 15*time.Minute)
+
+	token, claims, err := tm.GenerateSessionToken("user-123", "testuser", "org_user", "org-456", "session-1")
+	require.NoError(t, err)
+	assert.Len(t, claims.ID, 32)
+	assert.Equal(t, "session-1", claims.SessionID)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), claims.ExpiresAt.Time, 5*time.Second)
+
+	validated, err := tm.ValidateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, claims.ID, validated.ID)
+	assert.Equal(t, "session-1", validated.SessionID)
+
+	// Every token can be revoked on its own
+	_, other, err := tm.GenerateSessionToken("user-123", "testuser", "org_user", "org-456", "session-1")
+	require.NoError(t, err)
+	assert.NotEqual(t, claims.ID, other.ID)
+
+	_, _, err = tm.GenerateSessionToken("", "testuser", "org_user", "org-456", "session-1")
+	assert.Error(t, err)
+}
+
+func TestTokenManager_RefreshDuration(t *testing.T) {
+	tm := NewTokenManager("test-secret", time.Minute)
+	assert.Equal(t, DefaultRefreshTokenDuration, tm.RefreshDuration())
+
+	tm.SetRefreshDuration(time.Hour)
+	assert.Equal(t, time.Hour, tm.RefreshDuration())
+	tm.SetRefreshDuration(0)
+	assert.Equal(t, time.Hour, tm.RefreshDuration())
+}
+
+func TestNewRefreshToken(t *testing.T) {
+	token, hash, err := NewRefreshToken()
+	require.NoError(t, err)
+	assert.Len(t, token, 43)
+	assert.Equal(t, HashRefreshToken(token), hash)
+	assert.NotContains(t, hash, token)
+
+	other, otherHash, err := NewRefreshToken()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+	assert.NotEqual(t, hash, otherHash)
+}
+
 func TestTokenManager_ValidateToken(t *testing.T) {
 	tm := NewTokenManager("test-secret", time.Hour)
 
@@ -150,6 +196,66 @@ func TestTokenManager_ValidateToken(t *testing.T) {
 	})
 }
 
+func TestTokenManager_MFAChallenge(t *testing.T) {
+	tm := NewTokenManager("test-secret", time.Hour)
+
+	token, expiresAt, err := tm.GenerateMFAChallenge("user-123", "testuser")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(MFAChallengeDuration), expiresAt, time.Second)
+
+	claims, err := tm.ValidateMFAChallenge(token)
+	require.NoError(t, err)
+	assert.Equal(t, "user-123", claims.UserID)
+	assert.Equal(t, "testuser", claims.Username)
+
+	_, err = tm.ValidateToken(token)
+	assert.Error(t, err, "a challenge is no access token")
+
+	accessToken, err := tm.GenerateToken("user-123", "testuser", "org_user", "org-456")
+	require.NoError(t, err)
+	_, err = tm.ValidateMFAChallenge(accessToken)
+	assert.Error(t, err, "an access token is no challenge")
+
+	_, err = NewTokenManager("other-secret", time.Hour).ValidateMFAChallenge(token)
+	assert.Error(t, err)
+
+	_, _, err = tm.GenerateMFAChallenge("", "testuser")
+	assert.Error(t, err)
+}
+
+func TestTokenManager_InvitationToken(t *testing.T) {
+	tm := NewTokenManager("test-secret", time.Hour)
+
+	expiresAt := time.Now().Add(72 * time.Hour)
+	token, tokenID, err := tm.GenerateInvitationToken("invite-123", expiresAt)
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokenID)
+
+	claims, err := tm.ValidateInvitationToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "invite-123", claims.Subject)
+	assert.Equal(t, tokenID, claims.ID)
+	assert.WithinDuration(t, expiresAt, claims.ExpiresAt.Time, time.Second)
+
+	_, err = tm.ValidateToken(token)
+	assert.Error(t, err, "an invitation token is no access token")
+	challenge, _, err := tm.GenerateMFAChallenge("user-123", "testuser")
+	require.NoError(t, err)
+	_, err = tm.ValidateInvitationToken(challenge)
+	assert.Error(t, err, "an MFA challenge is no invitation token")
+
+	_, err = NewTokenManager("other-secret", time.Hour).ValidateInvitationToken(token)
+	assert.Error(t, err)
+
+	expired, _, err := tm.GenerateInvitationToken("invite-123", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	_, err = tm.ValidateInvitationToken(expired)
+	assert.Error(t, err)
+
+	_, _, err = tm.GenerateInvitationToken("", expiresAt)
+	assert.Error(t, err)
+}
+
 func TestLegacyFunctions(t *testing.T) {
 	secret := "legacy-secret"
 
@@ -217,6 +323,34 @@ func TestClaims(t *testing.T) {
 	assert.Equal(t, "test-user", claims.Subject)
 }
 
+func TestTokenManager_GenerateImpersonationToken(t *testing.T) {
+	tm := NewTokenManager("test-secret", time.Hour)
+	actor := &Actor{Subject: "admin-1", Username: "admin", ImpersonationID: "imp-1"}
+
+	claims := &Claims{UserID: "user-123", Username: "testuser", Role: "org_user", OrgID: "org-456", SessionID: "session-1"}
+	token, err := tm.GenerateImpersonationToken(claims, actor, 15*time.Minute)
+	require.NoError(t, err)
+
+	validated, err := tm.ValidateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "user-123", validated.UserID)
+	assert.Equal(t, actor, validated.Actor)
+	assert.Empty(t, validated.SessionID, "impersonation tokens cannot be refreshed")
+	assert.Equal(t, claims.ID, validated.ID)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), validated.ExpiresAt.Time, 5*time.Second)
+
+	// The lifetime of access tokens caps the duration
+	claims = &Claims{UserID: "user-123", Username: "testuser", Role: "org_user"}
+	_, err = tm.GenerateImpersonationToken(claims, actor, 2*time.Hour)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), claims.ExpiresAt.Time, 5*time.Second)
+
+	_, err = tm.GenerateImpersonationToken(&Claims{UserID: "user-123", Username: "testuser", Role: "org_user"}, nil, time.Minute)
+	assert.Error(t, err)
+	_, err = tm.GenerateImpersonationToken(&Claims{UserID: "admin-1", Username: "admin", Role: "system_admin"}, actor, time.Minute)
+	assert.Error(t, err)
+}
+
 func TestTokenManagerConcurrency(t *testing.T) {
 	tm := NewTokenManager("concurrent-secret", time.Hour)
 
@@ -266,3 +400,52 @@ func TestTokenManagerConcurrency(t *testing.T) {
 		assert.NotEmpty(t, claims.UserID)
 	}
 }
+
+func TestTokenManager_SigningKeys(t *testing.T) {
+	tm := NewTokenManager("test-secret", time.Hour)
+	hmacToken, err := tm.GenerateToken("user-1", "alice", "org_user", "")
+	require.NoError(t, err)
+	assert.Equal(t, JSONWebKeySet{Keys: []JSONWebKey{}}, tm.JWKS())
+
+	assert.Error(t, tm.SetSigningKeys(nil, 0))
+	rsaKey, err := GenerateSigningKey(SigningAlgorithmRS256, time.Now())
+	require.NoError(t, err)
+	ecKey, err := GenerateSigningKey(SigningAlgorithmES256, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Error(t, tm.SetSigningKeys([]*SigningKey{ecKey, ecKey}, 0), "key IDs are unique")
+	require.NoError(t, tm.SetSigningKeys([]*SigningKey{rsaKey, ecKey}, 10*time.Minute))
+	assert.Len(t, tm.JWKS().Keys, 2)
+
+	// The RSA key is too new to sign
+	token, err := tm.GenerateToken("user-1", "alice", "org_user", "")
+	require.NoError(t, err)
+	assertSignedBy(t, token, ecKey.ID)
+	claims, err := tm.ValidateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", claims.Username)
+
+	challenge, _, err := tm.GenerateMFAChallenge("user-1", "alice")
+	require.NoError(t, err)
+	_, err = tm.ValidateMFAChallenge(challenge)
+	assert.NoError(t, err)
+
+	_, err = tm.ValidateToken(hmacToken)
+	assert.Error(t, err, "the secret no longer signs tokens")
+
+	// Tokens must name a known key of their algorithm
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserID: "user-1", Username: "alice", Role: "system_admin"})
+	forged.Header["kid"] = ecKey.ID
+	signed, err := forged.SignedString([]byte("test-secret"))
+	require.NoError(t, err)
+	_, err = tm.ValidateToken(signed)
+	assert.Error(t, err)
+
+	other, err := GenerateSigningKey(SigningAlgorithmES256, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	otherTM := NewTokenManager("test-secret", time.Hour)
+	require.NoError(t, otherTM.SetSigningKeys([]*SigningKey{other}, 0))
+	token, err = otherTM.GenerateToken("user-1", "alice", "org_user", "")
+	require.NoError(t, err)
+	_, err = tm.ValidateToken(token)
+	assert.Error(t, err)
+}
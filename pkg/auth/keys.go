@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/eliorerz/ovim-updated/pkg/util"
+)
+
+const (
+	// Asymmetric signing algorithms of access tokens
+	SigningAlgorithmRS256 = "RS256"
+	SigningAlgorithmES256 = "ES256"
+
+	// minRSAKeyBits is the smallest RSA key accepted, and the size of generated ones
+	minRSAKeyBits = 2048
+
+	// keyCreatedHeader is the PEM header recording when a generated key was created
+	keyCreatedHeader = "Created"
+)
+
+// SigningKey is a private key signing access tokens, identified by the kid header of the
+// tokens it signs
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	// Created is when the key was generated, zero for keys provided without it
+	Created time.Time
+	private crypto.Signer
+}
+
+// JSONWebKey is the public part of a signing key, as defined by RFC 7517
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	// RSA modulus and exponent
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Elliptic curve and point
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is the document listing the keys tokens are verified with
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// GenerateSigningKey creates a new key for algorithm, RS256 or ES256. Its ID starts with its
+// creation time, so that newer keys sort after older ones.
+func GenerateSigningKey(algorithm string, now time.Time) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case SigningAlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, minRSAKeyBits)
+	case SigningAlgorithmES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	suffix, err := util.GenerateID(8)
+	if err != nil {
+		return nil, err
+	}
+	now = now.UTC().Truncate(time.Second)
+	return &SigningKey{
+		ID:        now.Format("20060102T150405Z") + "-" + suffix,
+		Algorithm: algorithm,
+		Created:   now,
+		private:   private,
+	}, nil
+}
+
+// ParseSigningKey reads a PEM encoded private key: an RSA key of at least 2048 bits, signing
+// with RS256, or a P-256 key, signing with ES256. PKCS #8, PKCS #1 and SEC 1 encodings are
+// accepted.
+func ParseSigningKey(id string, data []byte) (*SigningKey, error) {
+	if id == "" {
+		return nil, fmt.Errorf("signing key ID cannot be empty")
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", id)
+	}
+
+	var private interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("signing key %s has unsupported PEM type %q", id, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %w", id, err)
+	}
+
+	key := &SigningKey{ID: id}
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA signing key %s must have at least %d bits", id, minRSAKeyBits)
+		}
+		key.Algorithm = SigningAlgorithmRS256
+		key.private = private
+	case *ecdsa.PrivateKey:
+		if private.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ECDSA signing key %s must use the P-256 curve", id)
+		}
+		key.Algorithm = SigningAlgorithmES256
+		key.private = private
+	default:
+		return nil, fmt.Errorf("signing key %s must be an RSA or ECDSA key", id)
+	}
+
+	if created, ok := block.Headers[keyCreatedHeader]; ok {
+		key.Created, err = time.Parse(time.RFC3339, created)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s has an invalid %s header: %w", id, keyCreatedHeader, err)
+		}
+	}
+	return key, nil
+}
+
+// EncodePEM returns the private key in PKCS #8 PEM encoding, recording its creation time
+func (k *SigningKey) EncodePEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.private)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signing key %s: %w", k.ID, err)
+	}
+	block := &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	if !k.Created.IsZero() {
+		block.Headers = map[string]string{keyCreatedHeader: k.Created.UTC().Format(time.RFC3339)}
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// JWK returns the public key, for relying parties to verify tokens with
+func (k *SigningKey) JWK() JSONWebKey {
+	jwk := JSONWebKey{Use: "sig", KeyID: k.ID, Algorithm: k.Algorithm}
+	switch public := k.private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = encodeJWKInt(public.N)
+		jwk.E = encodeJWKInt(big.NewInt(int64(public.E)))
+	case *ecdsa.PublicKey:
+		// The uncompressed point is 0x04 followed by the two coordinates
+		point, err := public.ECDH()
+		if err == nil {
+			bytes := point.Bytes()[1:]
+			jwk.KeyType = "EC"
+			jwk.Curve = "P-256"
+			jwk.X = base64.RawURLEncoding.EncodeToString(bytes[:len(bytes)/2])
+			jwk.Y = base64.RawURLEncoding.EncodeToString(bytes[len(bytes)/2:])
+		}
+	}
+	return jwk
+}
+
+// method returns the JWT signing method of the key
+func (k *SigningKey) method() jwt.SigningMethod {
+	if k.Algorithm == SigningAlgorithmRS256 {
+		return jwt.SigningMethodRS256
+	}
+	return jwt.SigningMethodES256
+}
+
+// encodeJWKInt encodes an integer as the big-endian base64url string of RFC 7518
+func encodeJWKInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+// sortSigningKeys orders keys oldest first: keys without a creation time, then by creation
+// time, with the ID breaking ties
+func sortSigningKeys(keys []*SigningKey) {
+	sort.SliceStable(keys, func(i, j int) bool {
+		if !keys[i].Created.Equal(keys[j].Created) {
+			return keys[i].Created.Before(keys[j].Created)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+}
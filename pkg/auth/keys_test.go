@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSigningKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	require.NoError(t, err)
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		block     *pem.Block
+		algorithm string
+	}{
+		{"PKCS #1 RSA", &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, SigningAlgorithmRS256},
+		{"PKCS #8 ECDSA", &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}, SigningAlgorithmES256},
+		{"SEC 1 ECDSA", &pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}, SigningAlgorithmES256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseSigningKey("key-1", pem.EncodeToMemory(tt.block))
+			require.NoError(t, err)
+			assert.Equal(t, "key-1", key.ID)
+			assert.Equal(t, tt.algorithm, key.Algorithm)
+			assert.True(t, key.Created.IsZero())
+		})
+	}
+
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	p384, err := x509.MarshalECPrivateKey(p384Key)
+	require.NoError(t, err)
+	invalid := map[string][]byte{
+		"not PEM":         []byte("secret"),
+		"certificate":     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{1}}),
+		"small RSA key":   pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(smallKey)}),
+		"P-384 key":       pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: p384}),
+		"invalid created": pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1, Headers: map[string]string{"Created": "yesterday"}}),
+	}
+	for name, data := range invalid {
+		_, err := ParseSigningKey("key-1", data)
+		assert.Error(t, err, name)
+	}
+	_, err = ParseSigningKey("", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}))
+	assert.Error(t, err)
+}
+
+func TestGenerateSigningKey(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 500, time.UTC)
+	for _, algorithm := range []string{SigningAlgorithmRS256, SigningAlgorithmES256} {
+		t.Run(algorithm, func(t *testing.T) {
+			key, err := GenerateSigningKey(algorithm, now)
+			require.NoError(t, err)
+			assert.Regexp(t, `^20240101T120000Z-[0-9a-f]{8}$`, key.ID)
+
+			data, err := key.EncodePEM()
+			require.NoError(t, err)
+			parsed, err := ParseSigningKey(key.ID, data)
+			require.NoError(t, err)
+			assert.Equal(t, algorithm, parsed.Algorithm)
+			assert.True(t, now.Truncate(time.Second).Equal(parsed.Created))
+			assert.Equal(t, key.JWK(), parsed.JWK())
+		})
+	}
+
+	_, err := GenerateSigningKey("HS256", now)
+	assert.Error(t, err)
+}
+
+func TestSigningKey_JWK(t *testing.T) {
+	rsaKey, err := GenerateSigningKey(SigningAlgorithmRS256, time.Now())
+	require.NoError(t, err)
+	jwk := rsaKey.JWK()
+	assert.Equal(t, "RSA", jwk.KeyType)
+	assert.Equal(t, "sig", jwk.Use)
+	assert.Equal(t, "RS256", jwk.Algorithm)
+	assert.Equal(t, "AQAB", jwk.E)
+	assert.Len(t, jwk.N, 342, "a 2048 bit modulus")
+
+	ecKey, err := GenerateSigningKey(SigningAlgorithmES256, time.Now())
+	require.NoError(t, err)
+	jwk = ecKey.JWK()
+	assert.Equal(t, "EC", jwk.KeyType)
+	assert.Equal(t, "P-256", jwk.Curve)
+	assert.Len(t, jwk.X, 43)
+	assert.Len(t, jwk.Y, 43)
+	assert.Empty(t, jwk.N)
+}
+
+// assertSignedBy checks the kid header of a token
+func assertSignedBy(t *testing.T, token, kid string) {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, kid, parsed.Header["kid"])
+}
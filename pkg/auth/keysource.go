@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// signingKeySuffix ends the names of the files and Secret entries holding signing keys, which
+// are named after the ID of their key
+const signingKeySuffix = ".pem"
+
+// KeySource provides the signing keys of access tokens
+type KeySource interface {
+	Load(ctx context.Context) ([]*SigningKey, error)
+}
+
+// KeyRotator is a key source that can replace its keys
+type KeyRotator interface {
+	KeySource
+	// Rotate adds a key generated for algorithm once the newest key is interval old, or when
+	// there is none, and removes the keys superseded for longer than retain
+	Rotate(ctx context.Context, algorithm string, now time.Time, interval, retain time.Duration) error
+}
+
+// DirKeySource reads signing keys from the <kid>.pem files of a directory, such as a mounted
+// Secret
+type DirKeySource struct {
+	Dir string
+}
+
+// Load reads every key of the directory
+func (s *DirKeySource) Load(ctx context.Context) ([]*SigningKey, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key directory: %w", err)
+	}
+	var keys []*SigningKey
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, signingKeySuffix) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.Dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signing key: %w", err)
+		}
+		key, err := ParseSigningKey(strings.TrimSuffix(name, signingKeySuffix), data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// SecretKeySource reads signing keys from the <kid>.pem entries of a Kubernetes Secret, and
+// rotates them
+type SecretKeySource struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+}
+
+// Load reads every key of the Secret. A missing Secret has no keys.
+func (s *SecretKeySource) Load(ctx context.Context) ([]*SigningKey, error) {
+	secret, err := s.Client.CoreV1().Secrets(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signing key secret %s/%s: %w", s.Namespace, s.Name, err)
+	}
+	return secretSigningKeys(secret)
+}
+
+// Rotate updates the keys of the Secret, creating it if needed. Replicas racing to rotate
+// conflict on the update, and the losers keep the keys of the winner.
+func (s *SecretKeySource) Rotate(ctx context.Context, algorithm string, now time.Time, interval, retain time.Duration) error {
+	secrets := s.Client.CoreV1().Secrets(s.Namespace)
+	secret, err := secrets.Get(ctx, s.Name, metav1.GetOptions{})
+	exists := err == nil
+	if apierrors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: s.Namespace, Name: s.Name},
+			Type:       corev1.SecretTypeOpaque,
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to get signing key secret %s/%s: %w", s.Namespace, s.Name, err)
+	}
+
+	keys, err := secretSigningKeys(secret)
+	if err != nil {
+		return err
+	}
+	sortSigningKeys(keys)
+
+	changed := false
+	if len(keys) == 0 || !keys[len(keys)-1].Created.Add(interval).After(now) {
+		key, err := GenerateSigningKey(algorithm, now)
+		if err != nil {
+			return err
+		}
+		data, err := key.EncodePEM()
+		if err != nil {
+			return err
+		}
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[key.ID+signingKeySuffix] = data
+		keys = append(keys, key)
+		changed = true
+		klog.Infof("Generated signing key %s", key.ID)
+	}
+
+	// A key signs until its successor was created, its tokens are verified for retain longer
+	for i := 0; i < len(keys)-1; i++ {
+		successor := keys[i+1]
+		if !successor.Created.IsZero() && !successor.Created.Add(retain).After(now) {
+			delete(secret.Data, keys[i].ID+signingKeySuffix)
+			changed = true
+			klog.Infof("Removed retired signing key %s", keys[i].ID)
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	if exists {
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	} else {
+		_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+	}
+	if apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) {
+		klog.V(2).Infof("Signing key secret %s/%s was rotated by another replica", s.Namespace, s.Name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store signing key secret %s/%s: %w", s.Namespace, s.Name, err)
+	}
+	return nil
+}
+
+// secretSigningKeys parses the keys of a Secret
+func secretSigningKeys(secret *corev1.Secret) ([]*SigningKey, error) {
+	var keys []*SigningKey
+	for name, data := range secret.Data {
+		if !strings.HasSuffix(name, signingKeySuffix) {
+			continue
+		}
+		key, err := ParseSigningKey(strings.TrimSuffix(name, signingKeySuffix), data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// KeyManager keeps the signing keys of a token manager in sync with a key source, rotating
+// them when the source is a KeyRotator and rotation is enabled
+type KeyManager struct {
+	tokens         *TokenManager
+	source         KeySource
+	reloadInterval time.Duration
+	algorithm      string
+	rotation       time.Duration
+	now            func() time.Time
+}
+
+// NewKeyManager creates a key manager loading the keys of source every reloadInterval. Keys
+// generated by a rotation sign tokens once reloadInterval passed, when every replica loaded them.
+func NewKeyManager(tokens *TokenManager, source KeySource, reloadInterval time.Duration) *KeyManager {
+	return &KeyManager{
+		tokens:         tokens,
+		source:         source,
+		reloadInterval: reloadInterval,
+		now:            time.Now,
+	}
+}
+
+// SetRotation generates a new algorithm key every interval. The source must be a KeyRotator.
+func (m *KeyManager) SetRotation(algorithm string, interval time.Duration) error {
+	if _, ok := m.source.(KeyRotator); !ok {
+		return fmt.Errorf("signing keys of this source cannot be rotated")
+	}
+	if interval <= m.reloadInterval {
+		return fmt.Errorf("signing key rotation interval must be longer than the reload interval")
+	}
+	m.algorithm = algorithm
+	m.rotation = interval
+	return nil
+}
+
+// Reload rotates the keys if due and loads them into the token manager
+func (m *KeyManager) Reload(ctx context.Context) error {
+	if rotator, ok := m.source.(KeyRotator); ok && m.rotation > 0 {
+		// Superseded keys verify the tokens they signed until these expired, and the challenges
+		// of logins in progress
+		retain := m.tokens.duration
+		if retain < MFAChallengeDuration {
+			retain = MFAChallengeDuration
+		}
+		if err := rotator.Rotate(ctx, m.algorithm, m.now(), m.rotation, retain+m.reloadInterval); err != nil {
+			return err
+		}
+	}
+
+	keys, err := m.source.Load(ctx)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no signing keys found")
+	}
+	return m.tokens.SetSigningKeys(keys, m.reloadInterval)
+}
+
+// Run reloads the keys every reload interval until the context is cancelled. Failures are
+// logged and the previous keys kept.
+func (m *KeyManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := m.Reload(ctx); err != nil {
+			klog.Errorf("Failed to reload signing keys: %v", err)
+		}
+	}
+}
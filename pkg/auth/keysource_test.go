@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDirKeySource(t *testing.T) {
+	dir := t.TempDir()
+	key, err := GenerateSigningKey(SigningAlgorithmES256, time.Now())
+	require.NoError(t, err)
+	data, err := key.EncodePEM()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.pem"), data, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not a key"), 0600))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "..data"), 0700))
+
+	keys, err := (&DirKeySource{Dir: dir}).Load(context.Background())
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, "main", keys[0].ID)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("broken"), 0600))
+	_, err = (&DirKeySource{Dir: dir}).Load(context.Background())
+	assert.Error(t, err)
+	_, err = (&DirKeySource{Dir: filepath.Join(dir, "missing")}).Load(context.Background())
+	assert.Error(t, err)
+}
+
+func TestKeyManager_Rotation(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	source := &SecretKeySource{Client: client, Namespace: "ovim-system", Name: "ovim-jwt-keys"}
+	tokens := NewTokenManager("unused", 15*time.Minute)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tokens.now = func() time.Time { return now }
+
+	manager := NewKeyManager(tokens, source, time.Minute)
+	manager.now = tokens.now
+	require.NoError(t, manager.SetRotation(SigningAlgorithmES256, 24*time.Hour))
+	assert.Error(t, NewKeyManager(tokens, &DirKeySource{}, time.Minute).SetRotation(SigningAlgorithmES256, time.Hour), "directories are read-only")
+	assert.Error(t, manager.SetRotation(SigningAlgorithmES256, time.Second))
+
+	// The first reload creates the Secret with a key, which signs right away
+	require.NoError(t, manager.Reload(ctx))
+	first := tokens.JWKS().Keys
+	require.Len(t, first, 1)
+	token, err := tokens.GenerateToken("user-1", "alice", "org_user", "")
+	require.NoError(t, err)
+	assertSignedBy(t, token, first[0].KeyID)
+
+	now = now.Add(time.Hour)
+	require.NoError(t, manager.Reload(ctx))
+	assert.Equal(t, first, tokens.JWKS().Keys, "keys are rotated once a day")
+
+	// A new key is published before it signs
+	now = now.Add(24 * time.Hour)
+	require.NoError(t, manager.Reload(ctx))
+	keys := tokens.JWKS().Keys
+	require.Len(t, keys, 2)
+	assert.Equal(t, first[0], keys[0])
+	token, err = tokens.GenerateToken("user-1", "alice", "org_user", "")
+	require.NoError(t, err)
+	assertSignedBy(t, token, first[0].KeyID)
+
+	now = now.Add(time.Minute)
+	newToken, err := tokens.GenerateToken("user-1", "alice", "org_user", "")
+	require.NoError(t, err)
+	assertSignedBy(t, newToken, keys[1].KeyID)
+	_, err = tokens.ValidateToken(token)
+	assert.NoError(t, err, "tokens of the previous key stay valid")
+
+	// The previous key is removed once its tokens expired
+	now = now.Add(15 * time.Minute)
+	require.NoError(t, manager.Reload(ctx))
+	assert.Equal(t, keys[1:], tokens.JWKS().Keys)
+	_, err = tokens.ValidateToken(newToken)
+	assert.NoError(t, err)
+
+	secret, err := client.CoreV1().Secrets("ovim-system").Get(ctx, "ovim-jwt-keys", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Len(t, secret.Data, 1)
+	assert.Contains(t, secret.Data, keys[1].KeyID+".pem")
+}
+
+func TestKeyManager_Reload(t *testing.T) {
+	tokens := NewTokenManager("unused", time.Hour)
+	source := &SecretKeySource{Client: fake.NewSimpleClientset(), Namespace: "ovim-system", Name: "ovim-jwt-keys"}
+	assert.Error(t, NewKeyManager(tokens, source, time.Minute).Reload(context.Background()), "a missing Secret without rotation has no keys")
+	assert.Empty(t, tokens.JWKS().Keys)
+}
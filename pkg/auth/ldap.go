@@ -0,0 +1,306 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Defaults of the LDAP settings, suiting OpenLDAP style directories
+const (
+	DefaultLDAPUserFilter         = "(uid=%s)"
+	DefaultLDAPUsernameAttribute  = "uid"
+	DefaultLDAPEmailAttribute     = "mail"
+	DefaultLDAPGroupFilter        = "(member=%s)"
+	DefaultLDAPGroupNameAttribute = "cn"
+	DefaultLDAPTimeout            = 10 * time.Second
+
+	// ldapGroupsClaim holds the names and DNs of the groups of LDAP users for the mapping rules
+	ldapGroupsClaim = "groups"
+
+	// ldapMemberOfAttribute lists the groups of users in Active Directory and OpenLDAP with the
+	// memberof overlay
+	ldapMemberOfAttribute = "memberOf"
+)
+
+// ErrInvalidLDAPCredentials is returned for unknown users and wrong passwords
+var ErrInvalidLDAPCredentials = errors.New("invalid LDAP credentials")
+
+// LDAPConfig holds the settings of an LDAP or Active Directory server. Users are searched below
+// UserBaseDN with UserFilter, whose %s is replaced by the escaped username, and authenticated
+// by binding as the entry found. Their groups are searched below GroupBaseDN with GroupFilter,
+// whose %s is replaced by the escaped DN of the user, or read from the memberOf attribute of
+// the user without a GroupBaseDN.
+type LDAPConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// URL of the server, ldap:// or ldaps://
+	URL      string `yaml:"url"`
+	StartTLS bool   `yaml:"startTLS"`
+	// CAFile holds the certificates the server certificate is verified with, the system ones if empty
+	CAFile             string `yaml:"caFile"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+	// BindDN and BindPassword authenticate the searches, which are anonymous without a BindDN
+	BindDN             string `yaml:"bindDN"`
+	BindPassword       string `yaml:"bindPassword"`
+	UserBaseDN         string `yaml:"userBaseDN"`
+	UserFilter         string `yaml:"userFilter"`
+	UsernameAttribute  string `yaml:"usernameAttribute"`
+	EmailAttribute     string `yaml:"emailAttribute"`
+	GroupBaseDN        string `yaml:"groupBaseDN"`
+	GroupFilter        string `yaml:"groupFilter"`
+	GroupNameAttribute string `yaml:"groupNameAttribute"`
+	// GroupMappings give users their role and organization from their groups
+	GroupMappings []LDAPGroupMapping `yaml:"groupMappings"`
+	// DefaultRole is the role of users no mapping gives a role
+	DefaultRole string        `yaml:"defaultRole"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+// LDAPGroupMapping maps the members of matching groups to a role, an organization or both.
+// Group is matched against the names and DNs of the groups of users like the value of an OIDC
+// mapping rule.
+type LDAPGroupMapping struct {
+	Group string `yaml:"group" json:"group"`
+	Match string `yaml:"match" json:"match"`
+	Role  string `yaml:"role" json:"role"`
+	OrgID string `yaml:"orgId" json:"org_id"`
+}
+
+// LDAPUser is a user authenticated by the LDAP server
+type LDAPUser struct {
+	DN       string
+	Username string
+	Email    string
+	// Groups holds the names of the groups of the user, GroupDNs their DNs
+	Groups   []string
+	GroupDNs []string
+}
+
+// LDAPProvider authenticates users against an LDAP or Active Directory server
+type LDAPProvider struct {
+	config    LDAPConfig
+	tlsConfig *tls.Config
+	mapper    *OIDCMapper
+}
+
+// NewLDAPProvider validates the configuration and creates an LDAP provider. The server is
+// only contacted by logins.
+func NewLDAPProvider(config *LDAPConfig) (*LDAPProvider, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	cfg := *config
+	serverURL, err := url.Parse(cfg.URL)
+	if err != nil || (serverURL.Scheme != "ldap" && serverURL.Scheme != "ldaps") || serverURL.Host == "" {
+		return nil, fmt.Errorf("LDAP URL must be an ldap:// or ldaps:// URL")
+	}
+	if cfg.StartTLS && serverURL.Scheme == "ldaps" {
+		return nil, fmt.Errorf("LDAP StartTLS cannot be used with ldaps://")
+	}
+	if cfg.UserBaseDN == "" {
+		return nil, fmt.Errorf("LDAP user base DN is required")
+	}
+	if cfg.BindDN != "" && cfg.BindPassword == "" {
+		return nil, fmt.Errorf("LDAP bind password is required with a bind DN")
+	}
+	setDefault(&cfg.UserFilter, DefaultLDAPUserFilter)
+	setDefault(&cfg.UsernameAttribute, DefaultLDAPUsernameAttribute)
+	setDefault(&cfg.EmailAttribute, DefaultLDAPEmailAttribute)
+	setDefault(&cfg.GroupFilter, DefaultLDAPGroupFilter)
+	setDefault(&cfg.GroupNameAttribute, DefaultLDAPGroupNameAttribute)
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultLDAPTimeout
+	}
+	for name, filter := range map[string]string{"user": cfg.UserFilter, "group": cfg.GroupFilter} {
+		if !strings.Contains(filter, "%s") {
+			return nil, fmt.Errorf("LDAP %s filter must contain %%s", name)
+		}
+		if _, err := ldap.CompileFilter(strings.ReplaceAll(filter, "%s", "x")); err != nil {
+			return nil, fmt.Errorf("invalid LDAP %s filter: %w", name, err)
+		}
+	}
+
+	rules := make([]OIDCMappingRule, 0, len(cfg.GroupMappings))
+	for _, mapping := range cfg.GroupMappings {
+		rules = append(rules, OIDCMappingRule{Claim: ldapGroupsClaim, Match: mapping.Match, Value: mapping.Group, Role: mapping.Role, OrgID: mapping.OrgID})
+	}
+	mapper, err := newMapper("LDAP", rules, cfg.DefaultRole)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         serverURL.Hostname(),
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read LDAP CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("LDAP CA file contains no certificates")
+		}
+	}
+
+	return &LDAPProvider{config: cfg, tlsConfig: tlsConfig, mapper: mapper}, nil
+}
+
+// Authenticate checks the password of a user with the LDAP server and returns the user with
+// the groups. It returns ErrInvalidLDAPCredentials for unknown users and wrong passwords.
+func (p *LDAPProvider) Authenticate(username, password string) (*LDAPUser, error) {
+	// An empty password would be an unauthenticated bind, which servers accept for any DN
+	if username == "" || password == "" {
+		return nil, ErrInvalidLDAPCredentials
+	}
+
+	conn, err := p.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := p.bindService(conn); err != nil {
+		return nil, err
+	}
+	entry, err := p.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidLDAPCredentials
+		}
+		return nil, fmt.Errorf("failed to bind as LDAP user %s: %w", entry.DN, err)
+	}
+
+	user := &LDAPUser{
+		DN:       entry.DN,
+		Username: entry.GetAttributeValue(p.config.UsernameAttribute),
+		Email:    entry.GetAttributeValue(p.config.EmailAttribute),
+	}
+	if user.Username == "" {
+		user.Username = username
+	}
+
+	// Groups are read with the rights of the service account, users may not see them
+	if err := p.bindService(conn); err != nil {
+		return nil, err
+	}
+	if err := p.findGroups(conn, entry, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// MapUser returns the role and organization the group mappings give a user
+func (p *LDAPProvider) MapUser(user *LDAPUser) *OIDCMapping {
+	groups := make([]string, 0, len(user.Groups)+len(user.GroupDNs))
+	groups = append(append(groups, user.Groups...), user.GroupDNs...)
+	return p.mapper.Map(map[string]interface{}{ldapGroupsClaim: groups})
+}
+
+// connect opens a connection to the server, secured as configured
+func (p *LDAPProvider) connect() (*ldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: p.config.Timeout}
+	conn, err := ldap.DialURL(p.config.URL, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(p.tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %w", err)
+	}
+	conn.SetTimeout(p.config.Timeout)
+	if p.config.StartTLS {
+		if err := conn.StartTLS(p.tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start TLS with LDAP server: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// bindService authenticates the connection as the service account, if there is one
+func (p *LDAPProvider) bindService(conn *ldap.Conn) error {
+	if p.config.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(p.config.BindDN, p.config.BindPassword); err != nil {
+		return fmt.Errorf("failed to bind as LDAP service account %s: %w", p.config.BindDN, err)
+	}
+	return nil
+}
+
+// findUser returns the single entry of the user
+func (p *LDAPProvider) findUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	filter := strings.ReplaceAll(p.config.UserFilter, "%s", ldap.EscapeFilter(username))
+	request := ldap.NewSearchRequest(
+		p.config.UserBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(p.config.Timeout.Seconds()), false,
+		filter, []string{p.config.UsernameAttribute, p.config.EmailAttribute, ldapMemberOfAttribute}, nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("failed to search LDAP user %s: %w", username, err)
+	}
+	if result == nil || len(result.Entries) == 0 {
+		return nil, ErrInvalidLDAPCredentials
+	}
+	if len(result.Entries) > 1 {
+		return nil, fmt.Errorf("LDAP user filter matches several entries for user %s", username)
+	}
+	return result.Entries[0], nil
+}
+
+// findGroups adds the groups of the user entry to the user
+func (p *LDAPProvider) findGroups(conn *ldap.Conn, entry *ldap.Entry, user *LDAPUser) error {
+	if p.config.GroupBaseDN == "" {
+		for _, dn := range entry.GetAttributeValues(ldapMemberOfAttribute) {
+			user.GroupDNs = append(user.GroupDNs, dn)
+			if name := firstRDNValue(dn); name != "" {
+				user.Groups = append(user.Groups, name)
+			}
+		}
+		return nil
+	}
+
+	filter := strings.ReplaceAll(p.config.GroupFilter, "%s", ldap.EscapeFilter(entry.DN))
+	request := ldap.NewSearchRequest(
+		p.config.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(p.config.Timeout.Seconds()), false,
+		filter, []string{p.config.GroupNameAttribute}, nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil {
+		return fmt.Errorf("failed to search LDAP groups of %s: %w", entry.DN, err)
+	}
+	for _, group := range result.Entries {
+		user.GroupDNs = append(user.GroupDNs, group.DN)
+		if name := group.GetAttributeValue(p.config.GroupNameAttribute); name != "" {
+			user.Groups = append(user.Groups, name)
+		}
+	}
+	return nil
+}
+
+// firstRDNValue returns the value of the first attribute of a DN, the name of a group
+func firstRDNValue(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return ""
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
+
+func setDefault(value *string, defaultValue string) {
+	if *value == "" {
+		*value = defaultValue
+	}
+}
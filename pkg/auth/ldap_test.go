@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth/ldaptest"
+)
+
+const (
+	testLDAPBindDN   = "cn=ovim,ou=services,dc=example,dc=com"
+	testLDAPPassword = "service-secret"
+)
+
+// testLDAPDirectory holds alice, in the admins and acme groups, bob, in no group, and carol,
+// whose groups are listed by memberOf like in Active Directory
+func testLDAPDirectory() []ldaptest.Entry {
+	return []ldaptest.Entry{
+		{DN: testLDAPBindDN, Password: testLDAPPassword},
+		{DN: "uid=alice,ou=people,dc=example,dc=com", Password: "alice-secret", Attributes: map[string][]string{
+			"uid": {"alice"}, "mail": {"alice@example.com"}, "objectClass": {"inetOrgPerson"},
+		}},
+		{DN: "uid=bob,ou=people,dc=example,dc=com", Password: "bob-secret", Attributes: map[string][]string{
+			"uid": {"bob"}, "mail": {"bob@example.com"}, "objectClass": {"inetOrgPerson"},
+		}},
+		{DN: "uid=carol,ou=people,dc=example,dc=com", Password: "carol-secret", Attributes: map[string][]string{
+			"uid": {"carol"}, "objectClass": {"inetOrgPerson"},
+			"memberOf": {"cn=team-acme,ou=groups,dc=example,dc=com"},
+		}},
+		{DN: "cn=ovim-admins,ou=groups,dc=example,dc=com", Attributes: map[string][]string{
+			"cn": {"ovim-admins"}, "member": {"uid=alice,ou=people,dc=example,dc=com"},
+		}},
+		{DN: "cn=team-acme,ou=groups,dc=example,dc=com", Attributes: map[string][]string{
+			"cn": {"team-acme"}, "member": {"uid=alice,ou=people,dc=example,dc=com", "uid=carol,ou=people,dc=example,dc=com"},
+		}},
+	}
+}
+
+// testLDAPConfig returns the settings of a provider searching the directory of server
+func testLDAPConfig(server *ldaptest.Server) *LDAPConfig {
+	return &LDAPConfig{
+		Enabled:      true,
+		URL:          server.URL,
+		BindDN:       testLDAPBindDN,
+		BindPassword: testLDAPPassword,
+		UserBaseDN:   "ou=people,dc=example,dc=com",
+		GroupBaseDN:  "ou=groups,dc=example,dc=com",
+		GroupMappings: []LDAPGroupMapping{
+			{Group: "ovim-admins", Role: "system_admin"},
+			{Group: "team-(.+)", Match: MatchRegex, OrgID: "$1"},
+		},
+	}
+}
+
+func TestNewLDAPProvider(t *testing.T) {
+	server := ldaptest.NewServer()
+	defer server.Close()
+
+	provider, err := NewLDAPProvider(&LDAPConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, provider, "disabled")
+
+	provider, err = NewLDAPProvider(testLDAPConfig(server))
+	require.NoError(t, err)
+	assert.Equal(t, DefaultLDAPUserFilter, provider.config.UserFilter)
+	assert.Equal(t, DefaultLDAPTimeout, provider.config.Timeout)
+
+	tests := []struct {
+		name   string
+		modify func(*LDAPConfig)
+		errMsg string
+	}{
+		{"InvalidURL", func(c *LDAPConfig) { c.URL = "http://ldap.example.com" }, "ldap:// or ldaps://"},
+		{"StartTLSWithLDAPS", func(c *LDAPConfig) { c.URL = "ldaps://ldap.example.com"; c.StartTLS = true }, "StartTLS cannot be used"},
+		{"MissingUserBaseDN", func(c *LDAPConfig) { c.UserBaseDN = "" }, "user base DN is required"},
+		{"MissingBindPassword", func(c *LDAPConfig) { c.BindPassword = "" }, "bind password is required"},
+		{"FilterWithoutPlaceholder", func(c *LDAPConfig) { c.UserFilter = "(uid=alice)" }, "user filter must contain %s"},
+		{"InvalidFilter", func(c *LDAPConfig) { c.GroupFilter = "(member=%s" }, "invalid LDAP group filter"},
+		{"InvalidMappingRole", func(c *LDAPConfig) { c.GroupMappings = []LDAPGroupMapping{{Group: "admins", Role: "root"}} }, "LDAP mapping rule 1 has invalid role"},
+		{"InvalidDefaultRole", func(c *LDAPConfig) { c.DefaultRole = "root" }, "invalid default LDAP role"},
+		{"MissingCAFile", func(c *LDAPConfig) { c.CAFile = "/nonexistent/ca.pem" }, "failed to read LDAP CA file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testLDAPConfig(server)
+			tt.modify(config)
+			_, err := NewLDAPProvider(config)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
+
+func TestLDAPProvider_Authenticate(t *testing.T) {
+	server := ldaptest.NewServer(testLDAPDirectory()...)
+	defer server.Close()
+	provider, err := NewLDAPProvider(testLDAPConfig(server))
+	require.NoError(t, err)
+
+	user, err := provider.Authenticate("alice", "alice-secret")
+	require.NoError(t, err)
+	assert.Equal(t, "uid=alice,ou=people,dc=example,dc=com", user.DN)
+	assert.Equal(t, "alice", user.Username)
+	assert.Equal(t, "alice@example.com", user.Email)
+	assert.ElementsMatch(t, []string{"ovim-admins", "team-acme"}, user.Groups)
+	assert.Len(t, user.GroupDNs, 2)
+	assert.Equal(t, &OIDCMapping{Role: "system_admin", OrgID: "acme", OrgManaged: true}, provider.MapUser(user))
+
+	user, err = provider.Authenticate("bob", "bob-secret")
+	require.NoError(t, err)
+	assert.Empty(t, user.Groups)
+	assert.Equal(t, &OIDCMapping{Role: "org_user", OrgManaged: true}, provider.MapUser(user))
+
+	for name, credentials := range map[string][2]string{
+		"WrongPassword":  {"alice", "bob-secret"},
+		"EmptyPassword":  {"alice", ""},
+		"UnknownUser":    {"mallory", "secret"},
+		"FilterInjected": {"*", "alice-secret"},
+	} {
+		_, err := provider.Authenticate(credentials[0], credentials[1])
+		assert.ErrorIs(t, err, ErrInvalidLDAPCredentials, name)
+	}
+}
+
+func TestLDAPProvider_MemberOf(t *testing.T) {
+	server := ldaptest.NewServer(testLDAPDirectory()...)
+	defer server.Close()
+	config := testLDAPConfig(server)
+	config.GroupBaseDN = ""
+	provider, err := NewLDAPProvider(config)
+	require.NoError(t, err)
+
+	user, err := provider.Authenticate("carol", "carol-secret")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team-acme"}, user.Groups)
+	assert.Equal(t, []string{"cn=team-acme,ou=groups,dc=example,dc=com"}, user.GroupDNs)
+	assert.Equal(t, "acme", provider.MapUser(user).OrgID)
+
+	// Mappings may name groups by DN too
+	config.GroupMappings = []LDAPGroupMapping{{Group: "cn=team-acme,ou=groups,dc=example,dc=com", Role: "org_admin"}}
+	provider, err = NewLDAPProvider(config)
+	require.NoError(t, err)
+	assert.Equal(t, "org_admin", provider.MapUser(user).Role)
+}
+
+func TestLDAPProvider_Failures(t *testing.T) {
+	server := ldaptest.NewServer(testLDAPDirectory()...)
+	config := testLDAPConfig(server)
+
+	config.BindPassword = "wrong"
+	provider, err := NewLDAPProvider(config)
+	require.NoError(t, err)
+	_, err = provider.Authenticate("alice", "alice-secret")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidLDAPCredentials, "misconfiguration is not a failed login")
+
+	// A filter matching several users must not log in as any of them
+	config = testLDAPConfig(server)
+	config.UserFilter = "(|(uid=%s)(objectClass=inetOrgPerson))"
+	provider, err = NewLDAPProvider(config)
+	require.NoError(t, err)
+	_, err = provider.Authenticate("alice", "alice-secret")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "matches several entries")
+
+	server.Close()
+	provider, err = NewLDAPProvider(testLDAPConfig(server))
+	require.NoError(t, err)
+	_, err = provider.Authenticate("alice", "alice-secret")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to connect")
+}
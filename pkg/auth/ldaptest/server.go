@@ -0,0 +1,283 @@
+// Package ldaptest provides an in-memory LDAP server for tests, like net/http/httptest does
+// for HTTP. It supports simple binds and searches with equality, presence, and, or and not
+// filters, which is what LDAP authentication needs.
+package ldaptest
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Entry is an entry of the directory. Binding as the entry needs its password, entries without
+// one cannot bind.
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Server is an LDAP server listening on a local port
+type Server struct {
+	// URL of the server, ldap://127.0.0.1:<port>
+	URL string
+
+	listener net.Listener
+	mu       sync.Mutex
+	entries  []Entry
+	conns    map[net.Conn]bool
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a server holding the entries. It panics if it cannot listen, and has to be
+// closed.
+func NewServer(entries ...Entry) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("ldaptest: failed to listen: " + err.Error())
+	}
+	s := &Server{
+		URL:      "ldap://" + listener.Addr().String(),
+		listener: listener,
+		entries:  entries,
+		conns:    make(map[net.Conn]bool),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// SetEntries replaces the entries of the directory
+func (s *Server) SetEntries(entries ...Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = entries
+}
+
+// Close stops the server and closes its connections
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+// handle answers the requests of a connection until it is unbound or closed
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if len(packet.Children) < 2 {
+			return
+		}
+		messageID, _ := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			code := s.bind(request)
+			writeResult(conn, messageID, ldap.ApplicationBindResponse, code)
+		case ldap.ApplicationSearchRequest:
+			s.search(conn, messageID, request)
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			writeResult(conn, messageID, ldap.ApplicationExtendedResponse, ldap.LDAPResultUnwillingToPerform)
+		}
+	}
+}
+
+// bind returns the result of a simple bind. Binding with an empty DN and password is anonymous.
+func (s *Server) bind(request *ber.Packet) uint16 {
+	if len(request.Children) < 3 || request.Children[2].Tag != 0 {
+		return ldap.LDAPResultAuthMethodNotSupported
+	}
+	dn := packetString(request.Children[1])
+	password := request.Children[2].Data.String()
+	if dn == "" && password == "" {
+		return ldap.LDAPResultSuccess
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.entries {
+		if strings.EqualFold(entry.DN, dn) && entry.Password != "" && entry.Password == password {
+			return ldap.LDAPResultSuccess
+		}
+	}
+	return ldap.LDAPResultInvalidCredentials
+}
+
+// search sends the entries below the base object matching the filter, up to the size limit
+func (s *Server) search(w io.Writer, messageID int64, request *ber.Packet) {
+	if len(request.Children) < 8 {
+		writeResult(w, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError)
+		return
+	}
+	base := strings.ToLower(packetString(request.Children[0]))
+	sizeLimit, _ := request.Children[3].Value.(int64)
+	filter := request.Children[6]
+	var attributes []string
+	for _, attribute := range request.Children[7].Children {
+		attributes = append(attributes, packetString(attribute))
+	}
+
+	s.mu.Lock()
+	entries := append([]Entry(nil), s.entries...)
+	s.mu.Unlock()
+
+	sent := 0
+	for _, entry := range entries {
+		dn := strings.ToLower(entry.DN)
+		if dn != base && !strings.HasSuffix(dn, ","+base) {
+			continue
+		}
+		if !matches(entry, filter) {
+			continue
+		}
+		if sizeLimit > 0 && int64(sent) == sizeLimit {
+			writeResult(w, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSizeLimitExceeded)
+			return
+		}
+		writeEntry(w, messageID, entry, attributes)
+		sent++
+	}
+	writeResult(w, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)
+}
+
+// matches evaluates a filter on an entry, attribute names and values are case-insensitive
+func matches(entry Entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matches(entry, child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matches(entry, child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return len(filter.Children) == 1 && !matches(entry, filter.Children[0])
+	case ldap.FilterEqualityMatch:
+		if len(filter.Children) != 2 {
+			return false
+		}
+		value := packetString(filter.Children[1])
+		for _, v := range attributeValues(entry, packetString(filter.Children[0])) {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		attribute := filter.Data.String()
+		return strings.EqualFold(attribute, "objectClass") || len(attributeValues(entry, attribute)) > 0
+	default:
+		return false
+	}
+}
+
+// attributeValues returns the values of an attribute of an entry
+func attributeValues(entry Entry, name string) []string {
+	for attribute, values := range entry.Attributes {
+		if strings.EqualFold(attribute, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+func writeEntry(w io.Writer, messageID int64, entry Entry, attributes []string) {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "Object Name"))
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range entry.Attributes {
+		if !requested(name, attributes) {
+			continue
+		}
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		list.AppendChild(attribute)
+	}
+	response.AppendChild(list)
+	writeMessage(w, messageID, response)
+}
+
+// requested reports whether a search asked for the attribute, all of them if it asked for none
+func requested(name string, attributes []string) bool {
+	if len(attributes) == 0 {
+		return true
+	}
+	for _, attribute := range attributes {
+		if attribute == "*" || strings.EqualFold(attribute, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeResult(w io.Writer, messageID int64, tag ber.Tag, code uint16) {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, ldap.LDAPResultCodeMap[code], "Diagnostic Message"))
+	writeMessage(w, messageID, response)
+}
+
+func writeMessage(w io.Writer, messageID int64, operation *ber.Packet) {
+	message := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Message")
+	message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	message.AppendChild(operation)
+	_, _ = w.Write(message.Bytes())
+}
+
+// packetString returns the value of an octet string
+func packetString(packet *ber.Packet) string {
+	if value, ok := packet.Value.(string); ok {
+		return value
+	}
+	return packet.Data.String()
+}
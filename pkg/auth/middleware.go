@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
@@ -14,15 +15,41 @@ const (
 	ContextKeyUsername = "username"
 	ContextKeyRole     = "role"
 	ContextKeyOrgID    = "org_id"
+	ContextKeyClaims   = "claims"
+	ContextKeyAPIToken = "api_token_id"
+	// ContextKeyServiceAccount holds the namespace/name of the Kubernetes ServiceAccount that
+	// authenticated the request
+	ContextKeyServiceAccount = "service_account"
+	// ContextKeyImpersonator holds the Actor impersonating the user of the request
+	ContextKeyImpersonator = "impersonator"
 
 	// HTTP header constants
 	AuthorizationHeader = "Authorization"
 	BearerPrefix        = "Bearer "
+	// ImpersonatedByHeader names the impersonator in the responses to impersonated requests
+	ImpersonatedByHeader = "X-OVIM-Impersonated-By"
+	// OrganizationHeader selects the organization a member of several organizations acts in
+	OrganizationHeader = "X-OVIM-Organization"
 )
 
+// RevocationList tells whether a token was revoked before it expired
+type RevocationList interface {
+	IsTokenRevoked(id string) (bool, error)
+}
+
+// MembershipResolver returns the role of a user in an organization the user is a member of
+// besides its own
+type MembershipResolver interface {
+	MembershipRole(userID, orgID string) (role string, found bool, err error)
+}
+
 // Middleware provides authentication and authorization middleware for Gin
 type Middleware struct {
-	tokenManager *TokenManager
+	tokenManager    *TokenManager
+	revocations     RevocationList
+	apiTokens       APITokenAuthenticator
+	serviceAccounts *ServiceAccountAuthenticator
+	memberships     MembershipResolver
 }
 
 // NewMiddleware creates a new auth middleware
@@ -32,6 +59,28 @@ func NewMiddleware(tokenManager *TokenManager) *Middleware {
 	}
 }
 
+// SetRevocationList makes RequireAuth reject revoked tokens, and tokens without an ID that
+// could not be revoked
+func (m *Middleware) SetRevocationList(revocations RevocationList) {
+	m.revocations = revocations
+}
+
+// SetAPITokenAuthenticator makes RequireAuth accept API tokens alongside JWTs
+func (m *Middleware) SetAPITokenAuthenticator(authenticator APITokenAuthenticator) {
+	m.apiTokens = authenticator
+}
+
+// SetServiceAccountAuthenticator makes RequireAuth accept the tokens of bound Kubernetes
+// ServiceAccounts alongside JWTs
+func (m *Middleware) SetServiceAccountAuthenticator(authenticator *ServiceAccountAuthenticator) {
+	m.serviceAccounts = authenticator
+}
+
+// SetMembershipResolver makes SelectOrganization resolve the organizations users are members of
+func (m *Middleware) SetMembershipResolver(resolver MembershipResolver) {
+	m.memberships = resolver
+}
+
 // RequireAuth is a middleware that requires valid authentication
 func (m *Middleware) RequireAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -51,6 +100,15 @@ func (m *Middleware) RequireAuth() gin.HandlerFunc {
 			return
 		}
 
+		if m.apiTokens != nil && IsAPIToken(tokenString) {
+			m.authenticateAPIToken(c, tokenString)
+			return
+		}
+		if m.serviceAccounts != nil && IsServiceAccountToken(tokenString) {
+			m.authenticateServiceAccount(c, tokenString)
+			return
+		}
+
 		claims, err := m.tokenManager.ValidateToken(tokenString)
 		if err != nil {
 			klog.V(4).Infof("Token validation failed: %v", err)
@@ -59,17 +117,228 @@ func (m *Middleware) RequireAuth() gin.HandlerFunc {
 			return
 		}
 
+		if m.revocations != nil {
+			if claims.ID == "" {
+				klog.V(4).Infof("Rejecting token of user %s without an ID", claims.Username)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				c.Abort()
+				return
+			}
+			revoked, err := m.revocations.IsTokenRevoked(claims.ID)
+			if err != nil {
+				klog.Errorf("Failed to check revocation of token %s: %v", claims.ID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate token"})
+				c.Abort()
+				return
+			}
+			if revoked {
+				klog.V(4).Infof("Rejecting revoked token %s of user %s", claims.ID, claims.Username)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+				c.Abort()
+				return
+			}
+		}
+
 		// Set user context
 		c.Set(ContextKeyUserID, claims.UserID)
 		c.Set(ContextKeyUsername, claims.Username)
 		c.Set(ContextKeyRole, claims.Role)
 		c.Set(ContextKeyOrgID, claims.OrgID)
+		c.Set(ContextKeyClaims, claims)
+
+		if claims.Actor != nil {
+			// Every request of an impersonation is logged, naming who made it
+			c.Set(ContextKeyImpersonator, claims.Actor)
+			c.Header(ImpersonatedByHeader, claims.Actor.Username)
+			klog.Infof("User %s impersonating %s (role: %s, org: %s): %s %s", claims.Actor.Username, claims.Username,
+				claims.Role, claims.OrgID, c.Request.Method, c.Request.URL.Path)
+			c.Next()
+			return
+		}
 
 		klog.V(6).Infof("Authenticated user: %s (role: %s, org: %s)", claims.Username, claims.Role, claims.OrgID)
 		c.Next()
 	}
 }
 
+// authenticateAPIToken authenticates a request made with an API token, which may only make
+// the requests its scopes allow
+func (m *Middleware) authenticateAPIToken(c *gin.Context, token string) {
+	identity, err := m.apiTokens.AuthenticateAPIToken(token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidAPIToken) {
+			klog.V(4).Infof("API token authentication failed: %v", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		} else {
+			klog.Errorf("Failed to authenticate API token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate token"})
+		}
+		c.Abort()
+		return
+	}
+
+	if !ScopesAllow(identity.Scopes, c.Request.Method, c.Request.URL.Path) {
+		klog.V(4).Infof("API token %s of user %s does not allow %s %s", identity.TokenID, identity.Username, c.Request.Method, c.Request.URL.Path)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Token scope does not allow this request"})
+		c.Abort()
+		return
+	}
+
+	c.Set(ContextKeyUserID, identity.UserID)
+	c.Set(ContextKeyUsername, identity.Username)
+	c.Set(ContextKeyRole, identity.Role)
+	c.Set(ContextKeyOrgID, identity.OrgID)
+	c.Set(ContextKeyAPIToken, identity.TokenID)
+
+	klog.V(6).Infof("Authenticated user %s with API token %s", identity.Username, identity.TokenID)
+	c.Next()
+}
+
+// authenticateServiceAccount authenticates a request made with the token of a Kubernetes
+// ServiceAccount, acting as the OVIM user or role it is bound to
+func (m *Middleware) authenticateServiceAccount(c *gin.Context, token string) {
+	identity, err := m.serviceAccounts.Authenticate(c.Request.Context(), token)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidServiceAccountToken):
+			klog.V(4).Infof("Service account authentication failed: %v", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		case errors.Is(err, ErrServiceAccountNotBound):
+			klog.V(4).Infof("Service account authentication failed: %v", err)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Service account is not allowed to access OVIM"})
+		default:
+			klog.Errorf("Failed to authenticate service account token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate token"})
+		}
+		c.Abort()
+		return
+	}
+
+	c.Set(ContextKeyUserID, identity.UserID)
+	c.Set(ContextKeyUsername, identity.Username)
+	c.Set(ContextKeyRole, identity.Role)
+	c.Set(ContextKeyOrgID, identity.OrgID)
+	c.Set(ContextKeyServiceAccount, identity.ServiceAccount)
+
+	klog.V(6).Infof("Authenticated service account %s as %s (role: %s, org: %s)", identity.ServiceAccount, identity.Username, identity.Role, identity.OrgID)
+	c.Next()
+}
+
+// RequirePasswordChange is a middleware rejecting the requests of tokens issued to users who
+// must change their password, except for the given routes. It runs after RequireAuth.
+func (m *Middleware) RequirePasswordChange(allowedRoutes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := GetClaimsFromContext(c)
+		if !ok || !claims.PasswordChangeRequired || routeAllowed(c, allowedRoutes) {
+			c.Next()
+			return
+		}
+
+		klog.V(4).Infof("Rejecting %s %s of user %s who must change the password", c.Request.Method, c.Request.URL.Path, claims.Username)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Password change required", "password_change_required": true})
+		c.Abort()
+	}
+}
+
+// RequireMFAEnrollment is a middleware rejecting the requests of tokens issued to users who
+// must enroll a second factor, except for the given routes. It runs after RequireAuth.
+func (m *Middleware) RequireMFAEnrollment(allowedRoutes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := GetClaimsFromContext(c)
+		if !ok || !claims.MFAEnrollmentRequired || routeAllowed(c, allowedRoutes) {
+			c.Next()
+			return
+		}
+
+		klog.V(4).Infof("Rejecting %s %s of user %s who must enroll MFA", c.Request.Method, c.Request.URL.Path, claims.Username)
+		c.JSON(http.StatusForbidden, gin.H{"error": "MFA enrollment required", "mfa_enrollment_required": true})
+		c.Abort()
+	}
+}
+
+// RestrictImpersonation is a middleware rejecting the impersonated requests of the given
+// operations, written as "METHOD route" such as "DELETE /api/v1/vms/:id" with "*" matching any
+// method. With readOnly set every request but GET is rejected. It runs after RequireAuth.
+func (m *Middleware) RestrictImpersonation(readOnly bool, operations ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor, ok := GetImpersonatorFromContext(c)
+		if !ok {
+			c.Next()
+			return
+		}
+		blocked := readOnly && c.Request.Method != http.MethodGet || operationListed(c, operations)
+		if !blocked {
+			c.Next()
+			return
+		}
+
+		klog.Warningf("Rejecting %s %s of user %s impersonating %s", c.Request.Method, c.Request.URL.Path, actor.Username, c.GetString(ContextKeyUsername))
+		c.JSON(http.StatusForbidden, gin.H{"error": "Operation not allowed while impersonating", "impersonating": true})
+		c.Abort()
+	}
+}
+
+// SelectOrganization is a middleware switching the organization and role of the request to the
+// ones of the membership named by the OrganizationHeader, so that every tenancy check applies to
+// the active organization. Requests without the header, or naming the organization of the user,
+// act in the organization of the user; system admins are not tenants and ignore the header.
+// It runs after RequireAuth.
+func (m *Middleware) SelectOrganization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID := c.GetHeader(OrganizationHeader)
+		if orgID == "" || orgID == c.GetString(ContextKeyOrgID) || c.GetString(ContextKeyRole) == "system_admin" {
+			c.Next()
+			return
+		}
+
+		userID := c.GetString(ContextKeyUserID)
+		if m.memberships == nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of the organization"})
+			c.Abort()
+			return
+		}
+		role, found, err := m.memberships.MembershipRole(userID, orgID)
+		if err != nil {
+			klog.Errorf("Failed to resolve membership of user %s in organization %s: %v", userID, orgID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve organization membership"})
+			c.Abort()
+			return
+		}
+		if !found {
+			klog.V(4).Infof("Rejecting %s %s of user %s who is not a member of organization %s", c.Request.Method, c.Request.URL.Path, c.GetString(ContextKeyUsername), orgID)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of the organization"})
+			c.Abort()
+			return
+		}
+
+		klog.V(6).Infof("User %s acts in organization %s as %s", c.GetString(ContextKeyUsername), orgID, role)
+		c.Set(ContextKeyRole, role)
+		c.Set(ContextKeyOrgID, orgID)
+		c.Next()
+	}
+}
+
+// operationListed reports whether the method and route of the request are one of operations
+func operationListed(c *gin.Context, operations []string) bool {
+	for _, operation := range operations {
+		method, route, ok := strings.Cut(operation, " ")
+		if ok && (method == "*" || method == c.Request.Method) && route == c.FullPath() {
+			return true
+		}
+	}
+	return false
+}
+
+// routeAllowed reports whether the route of the request is one of routes
+func routeAllowed(c *gin.Context, routes []string) bool {
+	for _, route := range routes {
+		if c.FullPath() == route {
+			return true
+		}
+	}
+	return false
+}
+
 // RequireRole is a middleware that requires specific roles
 func (m *Middleware) RequireRole(allowedRoles ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -146,6 +415,39 @@ func GetUserFromContext(c *gin.Context) (userID, username, role, orgID string, o
 	return userID, username, role, orgID, true
 }
 
+// GetClaimsFromContext returns the claims of the token that authenticated the request
+func GetClaimsFromContext(c *gin.Context) (*Claims, bool) {
+	value, exists := c.Get(ContextKeyClaims)
+	if !exists {
+		return nil, false
+	}
+	claims, ok := value.(*Claims)
+	return claims, ok
+}
+
+// GetAPITokenIDFromContext returns the ID of the API token that authenticated the request
+func GetAPITokenIDFromContext(c *gin.Context) (string, bool) {
+	tokenID := c.GetString(ContextKeyAPIToken)
+	return tokenID, tokenID != ""
+}
+
+// GetServiceAccountFromContext returns the namespace/name of the Kubernetes ServiceAccount that
+// authenticated the request
+func GetServiceAccountFromContext(c *gin.Context) (string, bool) {
+	serviceAccount := c.GetString(ContextKeyServiceAccount)
+	return serviceAccount, serviceAccount != ""
+}
+
+// GetImpersonatorFromContext returns the administrator impersonating the user of the request
+func GetImpersonatorFromContext(c *gin.Context) (*Actor, bool) {
+	value, exists := c.Get(ContextKeyImpersonator)
+	if !exists {
+		return nil, false
+	}
+	actor, ok := value.(*Actor)
+	return actor, ok
+}
+
 // Legacy functions for backward compatibility
 func AuthMiddleware(secret string) gin.HandlerFunc {
 	tm := NewTokenManager(secret, DefaultTokenDuration)
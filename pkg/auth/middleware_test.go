@@ -1,12 +1,15 @@
 package auth
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -112,6 +115,340 @@ func TestMiddleware_RequireAuth(t *testing.T) {
 	}
 }
 
+// fakeRevocationList revokes the token IDs it holds
+type fakeRevocationList struct {
+	revoked map[string]bool
+	err     error
+}
+
+func (f *fakeRevocationList) IsTokenRevoked(id string) (bool, error) {
+	return f.revoked[id], f.err
+}
+
+func TestMiddleware_RequireAuthRevocation(t *testing.T) {
+	tm := NewTokenManager("test-secret", time.Hour)
+	token, claims, err := tm.GenerateSessionToken("user-123", "testuser", "org_user", "org-456", "session-1")
+	require.NoError(t, err)
+	revokedToken, revokedClaims, err := tm.GenerateSessionToken("user-123", "testuser", "org_user", "org-456", "session-1")
+	require.NoError(t, err)
+
+	// Tokens of earlier versions have no ID and cannot be revoked
+	legacyClaims := *claims
+	legacyClaims.ID = ""
+	legacyToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &legacyClaims).SignedString([]byte("test-secret"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		token          string
+		revocations    *fakeRevocationList
+		expectedStatus int
+	}{
+		{"ValidToken", token, &fakeRevocationList{revoked: map[string]bool{revokedClaims.ID: true}}, http.StatusOK},
+		{"RevokedToken", revokedToken, &fakeRevocationList{revoked: map[string]bool{revokedClaims.ID: true}}, http.StatusUnauthorized},
+		{"TokenWithoutID", legacyToken, &fakeRevocationList{}, http.StatusUnauthorized},
+		{"RevocationListUnavailable", token, &fakeRevocationList{err: errors.New("database is down")}, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			middleware := NewMiddleware(tm)
+			middleware.SetRevocationList(tt.revocations)
+
+			router := setupTestGin()
+			router.GET("/test", middleware.RequireAuth(), func(c *gin.Context) {
+				got, ok := GetClaimsFromContext(c)
+				require.True(t, ok)
+				assert.Equal(t, claims.ID, got.ID)
+				assert.Equal(t, "session-1", got.SessionID)
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set(AuthorizationHeader, BearerPrefix+tt.token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+// fakeAPITokens authenticates the API tokens it holds
+type fakeAPITokens struct {
+	identities map[string]*APITokenIdentity
+	err        error
+}
+
+func (f *fakeAPITokens) AuthenticateAPIToken(token string) (*APITokenIdentity, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	identity, exists := f.identities[token]
+	if !exists {
+		return nil, ErrInvalidAPIToken
+	}
+	return identity, nil
+}
+
+func TestMiddleware_RequireAuthAPIToken(t *testing.T) {
+	tm := NewTokenManager("test-secret", time.Hour)
+	jwtToken, err := tm.GenerateToken("user-123", "testuser", "org_user", "org-456")
+	require.NoError(t, err)
+	tokens := &fakeAPITokens{identities: map[string]*APITokenIdentity{
+		"ovim_reader": {TokenID: "token-1", UserID: "user-123", Username: "testuser", Role: "org_user", OrgID: "org-456", Scopes: []string{"GET /test"}},
+	}}
+
+	tests := []struct {
+		name           string
+		method         string
+		token          string
+		tokens         *fakeAPITokens
+		expectedStatus int
+		expectedToken  string
+	}{
+		{"ScopedRequest", http.MethodGet, "ovim_reader", tokens, http.StatusOK, "token-1"},
+		{"OutOfScope", http.MethodPost, "ovim_reader", tokens, http.StatusForbidden, ""},
+		{"UnknownToken", http.MethodGet, "ovim_unknown", tokens, http.StatusUnauthorized, ""},
+		{"LookupFailure", http.MethodGet, "ovim_reader", &fakeAPITokens{err: errors.New("database is down")}, http.StatusInternalServerError, ""},
+		{"JWTStillAccepted", http.MethodPost, jwtToken, tokens, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			middleware := NewMiddleware(tm)
+			middleware.SetAPITokenAuthenticator(tt.tokens)
+
+			router := setupTestGin()
+			handler := func(c *gin.Context) {
+				userID, username, role, orgID, ok := GetUserFromContext(c)
+				require.True(t, ok)
+				assert.Equal(t, []string{"user-123", "testuser", "org_user", "org-456"}, []string{userID, username, role, orgID})
+				tokenID, _ := GetAPITokenIDFromContext(c)
+				assert.Equal(t, tt.expectedToken, tokenID)
+				c.Status(http.StatusOK)
+			}
+			router.GET("/test", middleware.RequireAuth(), handler)
+			router.POST("/test", middleware.RequireAuth(), handler)
+
+			req := httptest.NewRequest(tt.method, "/test", nil)
+			req.Header.Set(AuthorizationHeader, BearerPrefix+tt.token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestMiddleware_RequirePasswordChange(t *testing.T) {
+	tm := NewTokenManager("test-secret", time.Hour)
+	middleware := NewMiddleware(tm)
+	token, err := tm.GenerateToken("user-123", "testuser", "org_user", "org-456")
+	require.NoError(t, err)
+	restrictedToken, err := tm.GenerateClaimsToken(&Claims{UserID: "user-123", Username: "testuser", Role: "org_user", OrgID: "org-456", PasswordChangeRequired: true})
+	require.NoError(t, err)
+
+	router := setupTestGin()
+	protected := router.Group("/", middleware.RequireAuth(), middleware.RequirePasswordChange("/profile/password"))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	protected.GET("/vms", ok)
+	protected.PUT("/profile/password", ok)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		token          string
+		expectedStatus int
+	}{
+		{"RegularToken", http.MethodGet, "/vms", token, http.StatusOK},
+		{"ChangeRequired", http.MethodGet, "/vms", restrictedToken, http.StatusForbidden},
+		{"AllowedRoute", http.MethodPut, "/profile/password", restrictedToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set(AuthorizationHeader, BearerPrefix+tt.token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusForbidden {
+				assert.Contains(t, w.Body.String(), "password_change_required")
+			}
+		})
+	}
+}
+
+func TestMiddleware_RequireMFAEnrollment(t *testing.T) {
+	tm := NewTokenManager("test-secret", time.Hour)
+	middleware := NewMiddleware(tm)
+	token, err := tm.GenerateToken("user-123", "testuser", "org_user", "org-456")
+	require.NoError(t, err)
+	restrictedToken, err := tm.GenerateClaimsToken(&Claims{UserID: "user-123", Username: "testuser", Role: "org_user", OrgID: "org-456", MFAEnrollmentRequired: true})
+	require.NoError(t, err)
+
+	router := setupTestGin()
+	protected := router.Group("/", middleware.RequireAuth(), middleware.RequireMFAEnrollment("/profile/mfa/enroll"))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	protected.GET("/vms", ok)
+	protected.POST("/profile/mfa/enroll", ok)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		token          string
+		expectedStatus int
+	}{
+		{"RegularToken", http.MethodGet, "/vms", token, http.StatusOK},
+		{"EnrollmentRequired", http.MethodGet, "/vms", restrictedToken, http.StatusForbidden},
+		{"AllowedRoute", http.MethodPost, "/profile/mfa/enroll", restrictedToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set(AuthorizationHeader, BearerPrefix+tt.token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusForbidden {
+				assert.Contains(t, w.Body.String(), "mfa_enrollment_required")
+			}
+		})
+	}
+}
+
+func TestMiddleware_RestrictImpersonation(t *testing.T) {
+	tm := NewTokenManager("test-secret", time.Hour)
+	middleware := NewMiddleware(tm)
+	token, err := tm.GenerateToken("user-123", "testuser", "org_user", "org-456")
+	require.NoError(t, err)
+	impersonationToken, err := tm.GenerateImpersonationToken(&Claims{UserID: "user-123", Username: "testuser", Role: "org_user", OrgID: "org-456"},
+		&Actor{Subject: "admin-1", Username: "admin"}, time.Minute)
+	require.NoError(t, err)
+
+	ok := func(c *gin.Context) {
+		actor, _ := GetImpersonatorFromContext(c)
+		c.JSON(http.StatusOK, gin.H{"impersonator": actor})
+	}
+	router := setupTestGin()
+	restricted := router.Group("/", middleware.RequireAuth(), middleware.RestrictImpersonation(false, "DELETE /vms/:id", "* /profile/password"))
+	restricted.GET("/vms/:id", ok)
+	restricted.DELETE("/vms/:id", ok)
+	restricted.PUT("/profile/password", ok)
+	readOnly := router.Group("/read-only", middleware.RequireAuth(), middleware.RestrictImpersonation(true))
+	readOnly.GET("/vms/:id", ok)
+	readOnly.POST("/vms/:id", ok)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		token          string
+		expectedStatus int
+	}{
+		{"RegularToken", http.MethodDelete, "/vms/vm-1", token, http.StatusOK},
+		{"AllowedOperation", http.MethodGet, "/vms/vm-1", impersonationToken, http.StatusOK},
+		{"BlockedOperation", http.MethodDelete, "/vms/vm-1", impersonationToken, http.StatusForbidden},
+		{"BlockedAnyMethod", http.MethodPut, "/profile/password", impersonationToken, http.StatusForbidden},
+		{"ReadOnlyGet", http.MethodGet, "/read-only/vms/vm-1", impersonationToken, http.StatusOK},
+		{"ReadOnlyPost", http.MethodPost, "/read-only/vms/vm-1", impersonationToken, http.StatusForbidden},
+		{"ReadOnlyRegularToken", http.MethodPost, "/read-only/vms/vm-1", token, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set(AuthorizationHeader, BearerPrefix+tt.token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.token == impersonationToken {
+				assert.Equal(t, "admin", w.Header().Get(ImpersonatedByHeader))
+				if tt.expectedStatus == http.StatusOK {
+					assert.Contains(t, w.Body.String(), "admin-1")
+				}
+			} else {
+				assert.Empty(t, w.Header().Get(ImpersonatedByHeader))
+			}
+		})
+	}
+}
+
+// membershipsFunc resolves memberships with a function
+type membershipsFunc func(userID, orgID string) (string, bool, error)
+
+func (f membershipsFunc) MembershipRole(userID, orgID string) (string, bool, error) {
+	return f(userID, orgID)
+}
+
+func TestMiddleware_SelectOrganization(t *testing.T) {
+	tm := NewTokenManager("test-secret", time.Hour)
+	middleware := NewMiddleware(tm)
+	middleware.SetMembershipResolver(membershipsFunc(func(userID, orgID string) (string, bool, error) {
+		switch {
+		case orgID == "org-broken":
+			return "", false, errors.New("database unavailable")
+		case userID == "user-123" && orgID == "org-789":
+			return "org_admin", true, nil
+		}
+		return "", false, nil
+	}))
+	token, err := tm.GenerateToken("user-123", "testuser", "org_user", "org-456")
+	require.NoError(t, err)
+	adminToken, err := tm.GenerateToken("admin-1", "admin", "system_admin", "")
+	require.NoError(t, err)
+
+	router := setupTestGin()
+	router.GET("/whoami", middleware.RequireAuth(), middleware.SelectOrganization(), func(c *gin.Context) {
+		_, _, role, orgID, _ := GetUserFromContext(c)
+		c.JSON(http.StatusOK, gin.H{"role": role, "org_id": orgID})
+	})
+
+	tests := []struct {
+		name           string
+		token          string
+		organization   string
+		expectedStatus int
+		expectedRole   string
+		expectedOrgID  string
+	}{
+		{"NoHeader", token, "", http.StatusOK, "org_user", "org-456"},
+		{"OwnOrganization", token, "org-456", http.StatusOK, "org_user", "org-456"},
+		{"Member", token, "org-789", http.StatusOK, "org_admin", "org-789"},
+		{"NotMember", token, "org-999", http.StatusForbidden, "", ""},
+		{"ResolverError", token, "org-broken", http.StatusInternalServerError, "", ""},
+		{"SystemAdmin", adminToken, "org-789", http.StatusOK, "system_admin", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+			req.Header.Set(AuthorizationHeader, BearerPrefix+tt.token)
+			if tt.organization != "" {
+				req.Header.Set(OrganizationHeader, tt.organization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var body map[string]string
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, tt.expectedRole, body["role"])
+			assert.Equal(t, tt.expectedOrgID, body["org_id"])
+		})
+	}
+}
+
 func TestMiddleware_RequireRole(t *testing.T) {
 	tm := NewTokenManager("test-secret", time.Hour)
 	middleware := NewMiddleware(tm)
@@ -6,10 +6,11 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net/url"
-	"strings"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"golang.org/x/oauth2"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
 )
 
 // OIDCConfig holds OpenID Connect configuration
@@ -20,6 +21,10 @@ type OIDCConfig struct {
 	ClientSecret string   `yaml:"clientSecret"`
 	RedirectURL  string   `yaml:"redirectUrl"`
 	Scopes       []string `yaml:"scopes"`
+	// RoleMappings give users their role and organization from the claims of their ID token
+	RoleMappings []OIDCMappingRule `yaml:"roleMappings"`
+	// DefaultRole is the role of users no mapping rule gives a role
+	DefaultRole string `yaml:"defaultRole"`
 }
 
 // OIDCProvider handles OpenID Connect authentication
@@ -28,6 +33,7 @@ type OIDCProvider struct {
 	verifier *oidc.IDTokenVerifier
 	oauth2   oauth2.Config
 	provider *oidc.Provider
+	mapper   *OIDCMapper
 }
 
 // NewOIDCProvider creates a new OIDC provider
@@ -52,6 +58,11 @@ func NewOIDCProvider(config *OIDCConfig) (*OIDCProvider, error) {
 		return nil, fmt.Errorf("OIDC redirect URL is required")
 	}
 
+	mapper, err := NewOIDCMapper(config.RoleMappings, config.DefaultRole)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx := context.Background()
 	provider, err := oidc.NewProvider(ctx, config.IssuerURL)
 	if err != nil {
@@ -83,6 +94,7 @@ func NewOIDCProvider(config *OIDCConfig) (*OIDCProvider, error) {
 		verifier: verifier,
 		oauth2:   oauth2Config,
 		provider: provider,
+		mapper:   mapper,
 	}, nil
 }
 
@@ -126,7 +138,10 @@ type UserInfo struct {
 	Email             string   `json:"email"`
 	EmailVerified     bool     `json:"email_verified"`
 	Groups            []string `json:"groups"`
-	Roles             []string `json:"realm_access,omitempty"`
+	// Roles are the realm roles of Keycloak, found in the realm_access claim
+	Roles []string `json:"-"`
+	// Claims holds every claim of the ID token for the mapping rules
+	Claims map[string]interface{} `json:"-"`
 }
 
 // GetUserInfo extracts user information from ID token
@@ -135,6 +150,10 @@ func (p *OIDCProvider) GetUserInfo(ctx context.Context, idToken *oidc.IDToken) (
 	if err := idToken.Claims(&userInfo); err != nil {
 		return nil, fmt.Errorf("failed to extract user info: %w", err)
 	}
+	if err := idToken.Claims(&userInfo.Claims); err != nil {
+		return nil, fmt.Errorf("failed to extract claims: %w", err)
+	}
+	userInfo.Roles = claimValues(userInfo.Claims, "realm_access.roles")
 
 	// For now, we'll rely on the ID token claims
 	// To get additional userinfo, we'd need the access token which would require
@@ -143,28 +162,14 @@ func (p *OIDCProvider) GetUserInfo(ctx context.Context, idToken *oidc.IDToken) (
 	return &userInfo, nil
 }
 
-// MapOIDCRolesToOVIM maps OIDC roles/groups to OVIM roles
-func (p *OIDCProvider) MapOIDCRolesToOVIM(userInfo *UserInfo) string {
-	// Check for system admin role
-	for _, role := range userInfo.Roles {
-		if strings.Contains(strings.ToLower(role), "admin") {
-			return "system_admin"
-		}
+// MapUserInfo returns the role and organization the mapping rules give a user. Without rules
+// every user gets the default role and no organization.
+func (p *OIDCProvider) MapUserInfo(userInfo *UserInfo) *OIDCMapping {
+	mapper := p.mapper
+	if mapper == nil {
+		mapper = &OIDCMapper{defaultRole: models.RoleOrgUser}
 	}
-
-	// Check groups for admin roles
-	for _, group := range userInfo.Groups {
-		groupLower := strings.ToLower(group)
-		if strings.Contains(groupLower, "admin") {
-			if strings.Contains(groupLower, "system") {
-				return "system_admin"
-			}
-			return "org_admin"
-		}
-	}
-
-	// Default to regular user
-	return "user"
+	return mapper.Map(userInfo.Claims)
 }
 
 // ValidateOIDCConfig validates OIDC configuration
@@ -197,5 +202,9 @@ func ValidateOIDCConfig(config *OIDCConfig) error {
 		return fmt.Errorf("invalid OIDC redirect URL: %w", err)
 	}
 
+	if _, err := NewOIDCMapper(config.RoleMappings, config.DefaultRole); err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+)
+
+// Match types of OIDC mapping rules
+const (
+	MatchExact = "exact"
+	MatchRegex = "regex"
+)
+
+// OIDCMappingRule maps the users whose claim has a matching value to a role, an organization
+// or both. Claim is a dot separated path into the ID token claims, such as "groups" or
+// "realm_access.roles". A regex must match the whole value, and OrgID may refer to its
+// submatches as $1 or ${name}.
+type OIDCMappingRule struct {
+	Claim string `yaml:"claim" json:"claim"`
+	Match string `yaml:"match" json:"match"`
+	Value string `yaml:"value" json:"value"`
+	Role  string `yaml:"role" json:"role"`
+	OrgID string `yaml:"orgId" json:"org_id"`
+}
+
+// OIDCMapping is the role and organization the mapping rules give a user
+type OIDCMapping struct {
+	Role string
+	// OrgID is empty if no rule gave the user an organization
+	OrgID string
+	// OrgManaged is set if some rule assigns organizations. The organization of users is then
+	// replaced on every login, otherwise it is left to administrators.
+	OrgManaged bool
+}
+
+// OIDCMapper applies mapping rules to the claims of ID tokens
+type OIDCMapper struct {
+	rules       []compiledRule
+	defaultRole string
+	orgManaged  bool
+}
+
+type compiledRule struct {
+	OIDCMappingRule
+	pattern *regexp.Regexp
+}
+
+// validRoles are the roles mapping rules may assign
+var validRoles = map[string]bool{
+	models.RoleSystemAdmin: true,
+	models.RoleOrgAdmin:    true,
+	models.RoleOrgUser:     true,
+	models.RoleOrgMember:   true,
+}
+
+// NewOIDCMapper validates and compiles mapping rules. Users matched by no rule that assigns a
+// role get the default role, org_user if it is empty.
+func NewOIDCMapper(rules []OIDCMappingRule, defaultRole string) (*OIDCMapper, error) {
+	return newMapper("OIDC", rules, defaultRole)
+}
+
+// newMapper compiles the mapping rules of a kind of identity provider, named in errors
+func newMapper(kind string, rules []OIDCMappingRule, defaultRole string) (*OIDCMapper, error) {
+	if defaultRole == "" {
+		defaultRole = models.RoleOrgUser
+	}
+	if !validRoles[defaultRole] {
+		return nil, fmt.Errorf("invalid default %s role %q", kind, defaultRole)
+	}
+
+	mapper := &OIDCMapper{defaultRole: defaultRole}
+	for i, rule := range rules {
+		if rule.Claim == "" {
+			return nil, fmt.Errorf("%s mapping rule %d has no claim", kind, i+1)
+		}
+		if rule.Role == "" && rule.OrgID == "" {
+			return nil, fmt.Errorf("%s mapping rule %d assigns neither a role nor an organization", kind, i+1)
+		}
+		if rule.Role != "" && !validRoles[rule.Role] {
+			return nil, fmt.Errorf("%s mapping rule %d has invalid role %q", kind, i+1, rule.Role)
+		}
+
+		compiled := compiledRule{OIDCMappingRule: rule}
+		switch rule.Match {
+		case "", MatchExact:
+			compiled.Match = MatchExact
+		case MatchRegex:
+			pattern, err := regexp.Compile("^(?:" + rule.Value + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("%s mapping rule %d has invalid regex: %w", kind, i+1, err)
+			}
+			compiled.pattern = pattern
+		default:
+			return nil, fmt.Errorf("%s mapping rule %d has unknown match type %q", kind, i+1, rule.Match)
+		}
+
+		mapper.rules = append(mapper.rules, compiled)
+		mapper.orgManaged = mapper.orgManaged || rule.OrgID != ""
+	}
+	return mapper, nil
+}
+
+// Map returns the role and organization of a user with the given claims. Rules are applied in
+// order: the first matching rule with a role gives the role, and the first matching rule with
+// an organization gives the organization.
+func (m *OIDCMapper) Map(claims map[string]interface{}) *OIDCMapping {
+	mapping := &OIDCMapping{OrgManaged: m.orgManaged}
+	for _, rule := range m.rules {
+		if mapping.Role != "" && (mapping.OrgID != "" || rule.OrgID == "") {
+			continue
+		}
+		for _, value := range claimValues(claims, rule.Claim) {
+			orgID, matched := rule.apply(value)
+			if !matched {
+				continue
+			}
+			if mapping.Role == "" {
+				mapping.Role = rule.Role
+			}
+			if mapping.OrgID == "" {
+				mapping.OrgID = orgID
+			}
+			break
+		}
+	}
+	if mapping.Role == "" {
+		mapping.Role = m.defaultRole
+	}
+	return mapping
+}
+
+// apply matches a claim value and returns the organization the rule assigns for it
+func (r *compiledRule) apply(value string) (string, bool) {
+	if r.pattern == nil {
+		return r.OrgID, value == r.Value
+	}
+	submatches := r.pattern.FindStringSubmatchIndex(value)
+	if submatches == nil {
+		return "", false
+	}
+	return string(r.pattern.ExpandString(nil, r.OrgID, value, submatches)), true
+}
+
+// claimValues returns the string values of the claim at a dot separated path. Claim names may
+// contain dots themselves, as namespaced claims like "https://example.com/groups" do.
+func claimValues(claims map[string]interface{}, path string) []string {
+	if value, exists := claims[path]; exists {
+		return stringValues(value)
+	}
+	for i := strings.IndexByte(path, '.'); i >= 0; i = nextDot(path, i) {
+		if nested, ok := claims[path[:i]].(map[string]interface{}); ok {
+			if values := claimValues(nested, path[i+1:]); len(values) > 0 {
+				return values
+			}
+		}
+	}
+	return nil
+}
+
+func nextDot(path string, i int) int {
+	next := strings.IndexByte(path[i+1:], '.')
+	if next < 0 {
+		return -1
+	}
+	return i + 1 + next
+}
+
+// stringValues turns a claim into strings, claims may be a single value or a list
+func stringValues(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	case nil:
+		return nil
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
 )
 
 func TestNewOIDCProvider(t *testing.T) {
@@ -129,80 +131,48 @@ func TestOIDCProvider_GetAuthURL(t *testing.T) {
 	assert.IsType(t, "", authURL)
 }
 
-func TestMapOIDCRolesToOVIM(t *testing.T) {
-	provider := &OIDCProvider{}
+func TestOIDCProvider_MapUserInfo(t *testing.T) {
+	t.Run("NoRules", func(t *testing.T) {
+		provider := &OIDCProvider{}
+
+		// Names that merely contain "admin" grant nothing
+		for _, userInfo := range []*UserInfo{
+			{Claims: map[string]interface{}{"realm_access": map[string]interface{}{"roles": []interface{}{"application-admin"}}}},
+			{Claims: map[string]interface{}{"groups": []interface{}{"system-admins", "org-admins"}}},
+			{},
+		} {
+			mapping := provider.MapUserInfo(userInfo)
+			assert.Equal(t, &OIDCMapping{Role: models.RoleOrgUser}, mapping)
+		}
+	})
 
-	tests := []struct {
-		name         string
-		userInfo     *UserInfo
-		expectedRole string
-	}{
-		{
-			name: "SystemAdminRole",
-			userInfo: &UserInfo{
-				Roles: []string{"system-admin", "user"},
-			},
-			expectedRole: "system_admin",
-		},
-		{
-			name: "AdminRoleInRoles",
-			userInfo: &UserInfo{
-				Roles: []string{"application-admin", "user"},
-			},
-			expectedRole: "system_admin",
-		},
-		{
-			name: "SystemAdminGroup",
-			userInfo: &UserInfo{
-				Groups: []string{"system-admins", "users"},
-			},
-			expectedRole: "system_admin",
-		},
-		{
-			name: "OrgAdminGroup",
-			userInfo: &UserInfo{
-				Groups: []string{"org-admins", "users"},
-			},
-			expectedRole: "org_admin",
-		},
-		{
-			name: "RegularUser",
-			userInfo: &UserInfo{
-				Roles:  []string{"user", "member"},
-				Groups: []string{"users", "members"},
-			},
-			expectedRole: "user",
-		},
-		{
-			name: "EmptyRolesAndGroups",
-			userInfo: &UserInfo{
-				Roles:  []string{},
-				Groups: []string{},
-			},
-			expectedRole: "user",
-		},
-		{
-			name: "MixedCaseAdmin",
-			userInfo: &UserInfo{
-				Roles: []string{"ADMIN", "USER"},
-			},
-			expectedRole: "system_admin",
-		},
-		{
-			name: "AdminInGroups",
-			userInfo: &UserInfo{
-				Groups: []string{"platform-admin", "users"},
+	t.Run("Rules", func(t *testing.T) {
+		config := &OIDCConfig{
+			Enabled:      true,
+			IssuerURL:    "https://auth.example.com",
+			ClientID:     "test-client-id",
+			ClientSecret: "test-client-secret",
+			RedirectURL:  "https://app.example.com/callback",
+			RoleMappings: []OIDCMappingRule{
+				{Claim: "realm_access.roles", Value: "ovim-admin", Role: models.RoleSystemAdmin},
+				{Claim: "groups", Match: MatchRegex, Value: "ovim-(.+)-admins", Role: models.RoleOrgAdmin, OrgID: "$1"},
 			},
-			expectedRole: "org_admin",
-		},
-	}
+			DefaultRole: models.RoleOrgMember,
+		}
+		require.NoError(t, ValidateOIDCConfig(config))
+		mapper, err := NewOIDCMapper(config.RoleMappings, config.DefaultRole)
+		require.NoError(t, err)
+		provider := &OIDCProvider{config: config, mapper: mapper}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			role := provider.MapOIDCRolesToOVIM(tt.userInfo)
-			assert.Equal(t, tt.expectedRole, role)
-		})
-	}
+		admin := &UserInfo{Claims: map[string]interface{}{"realm_access": map[string]interface{}{"roles": []interface{}{"ovim-admin"}}}}
+		assert.Equal(t, &OIDCMapping{Role: models.RoleSystemAdmin, OrgManaged: true}, provider.MapUserInfo(admin))
+
+		orgAdmin := &UserInfo{Claims: map[string]interface{}{"groups": []interface{}{"users", "ovim-acme-admins"}}}
+		assert.Equal(t, &OIDCMapping{Role: models.RoleOrgAdmin, OrgID: "acme", OrgManaged: true}, provider.MapUserInfo(orgAdmin))
+
+		member := &UserInfo{Claims: map[string]interface{}{"groups": []interface{}{"ovim-acme-admins-old"}}}
+		assert.Equal(t, &OIDCMapping{Role: models.RoleOrgMember, OrgManaged: true}, provider.MapUserInfo(member))
+	})
 }
 
 func TestValidateOIDCConfig(t *testing.T) {
@@ -440,61 +410,125 @@ func TestOIDCConfigValidation(t *testing.T) {
 }
 
 func TestOIDCRoleMapping(t *testing.T) {
-	provider := &OIDCProvider{}
+	rules := []OIDCMappingRule{
+		{Claim: "groups", Value: "platform-team", Role: models.RoleSystemAdmin},
+		{Claim: "groups", Match: MatchRegex, Value: "team-(?P<org>[a-z]+)-leads", Role: models.RoleOrgAdmin, OrgID: "${org}"},
+		{Claim: "groups", Match: MatchRegex, Value: "team-([a-z]+)", Role: models.RoleOrgUser, OrgID: "$1"},
+		{Claim: "https://ovim.example.com/org", OrgID: "contractors", Value: "external"},
+	}
+	mapper, err := NewOIDCMapper(rules, "")
+	require.NoError(t, err)
 
-	// Test various role/group combinations
 	testCases := []struct {
 		description string
-		roles       []string
-		groups      []string
-		expected    string
+		claims      map[string]interface{}
+		role        string
+		orgID       string
 	}{
 		{
-			description: "Multiple admin roles",
-			roles:       []string{"super-admin", "platform-admin", "user"},
-			groups:      []string{"users"},
-			expected:    "system_admin",
+			description: "Exact match",
+			claims:      map[string]interface{}{"groups": []interface{}{"users", "platform-team"}},
+			role:        models.RoleSystemAdmin,
+		},
+		{
+			description: "Exact match is case sensitive",
+			claims:      map[string]interface{}{"groups": []interface{}{"Platform-Team"}},
+			role:        models.RoleOrgUser,
+		},
+		{
+			description: "Named submatch gives the organization",
+			claims:      map[string]interface{}{"groups": []interface{}{"team-acme-leads"}},
+			role:        models.RoleOrgAdmin,
+			orgID:       "acme",
 		},
 		{
-			description: "Admin in groups only",
-			roles:       []string{"user", "member"},
-			groups:      []string{"platform-admin", "users"},
-			expected:    "org_admin",
+			description: "Earlier rules win",
+			claims:      map[string]interface{}{"groups": []interface{}{"team-acme", "team-globex-leads"}},
+			role:        models.RoleOrgAdmin,
+			orgID:       "globex",
 		},
 		{
-			description: "System admin group",
-			roles:       []string{},
-			groups:      []string{"system-administrators", "users"},
-			expected:    "system_admin",
+			description: "Regex matches whole values only",
+			claims:      map[string]interface{}{"groups": []interface{}{"old-team-acme"}},
+			role:        models.RoleOrgUser,
 		},
 		{
-			description: "Case insensitive matching",
-			roles:       []string{"USER", "MEMBER"},
-			groups:      []string{"ADMIN-GROUP", "USERS"},
-			expected:    "org_admin",
+			description: "Role and organization from different rules",
+			claims: map[string]interface{}{
+				"groups":                       []interface{}{"platform-team"},
+				"https://ovim.example.com/org": "external",
+			},
+			role:  models.RoleSystemAdmin,
+			orgID: "contractors",
 		},
 		{
-			description: "No admin privileges",
-			roles:       []string{"user", "member", "viewer"},
-			groups:      []string{"users", "viewers", "members"},
-			expected:    "user",
+			description: "Single string claim",
+			claims:      map[string]interface{}{"groups": "team-acme"},
+			role:        models.RoleOrgUser,
+			orgID:       "acme",
 		},
 		{
-			description: "Empty roles and groups",
-			roles:       []string{},
-			groups:      []string{},
-			expected:    "user",
+			description: "No claims",
+			claims:      nil,
+			role:        models.RoleOrgUser,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.description, func(t *testing.T) {
-			userInfo := &UserInfo{
-				Roles:  tc.roles,
-				Groups: tc.groups,
+			mapping := mapper.Map(tc.claims)
+			assert.Equal(t, tc.role, mapping.Role)
+			assert.Equal(t, tc.orgID, mapping.OrgID)
+			assert.True(t, mapping.OrgManaged)
+		})
+	}
+}
+
+func TestNewOIDCMapper(t *testing.T) {
+	tests := []struct {
+		name        string
+		rules       []OIDCMappingRule
+		defaultRole string
+		wantErr     string
+	}{
+		{"Valid", []OIDCMappingRule{{Claim: "groups", Value: "admins", Role: models.RoleOrgAdmin}}, models.RoleOrgMember, ""},
+		{"InvalidDefaultRole", nil, "user", "invalid default OIDC role"},
+		{"MissingClaim", []OIDCMappingRule{{Value: "admins", Role: models.RoleOrgAdmin}}, "", "has no claim"},
+		{"InvalidRole", []OIDCMappingRule{{Claim: "groups", Value: "admins", Role: "admin"}}, "", "invalid role"},
+		{"NothingAssigned", []OIDCMappingRule{{Claim: "groups", Value: "admins"}}, "", "neither a role nor an organization"},
+		{"UnknownMatch", []OIDCMappingRule{{Claim: "groups", Match: "prefix", Value: "admins", Role: models.RoleOrgAdmin}}, "", "unknown match type"},
+		{"InvalidRegex", []OIDCMappingRule{{Claim: "groups", Match: MatchRegex, Value: "(admins", Role: models.RoleOrgAdmin}}, "", "invalid regex"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper, err := NewOIDCMapper(tt.rules, tt.defaultRole)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				assert.NotNil(t, mapper)
+				return
 			}
-			result := provider.MapOIDCRolesToOVIM(userInfo)
-			assert.Equal(t, tc.expected, result)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
 		})
 	}
 }
+
+func TestClaimValues(t *testing.T) {
+	claims := map[string]interface{}{
+		"groups":       []interface{}{"a", "b", 3},
+		"realm_access": map[string]interface{}{"roles": []interface{}{"admin"}},
+		"resource_access": map[string]interface{}{
+			"ovim.example.com": map[string]interface{}{"roles": []interface{}{"viewer"}},
+		},
+		"https://example.com/tenant": "acme",
+		"verified":                   true,
+	}
+
+	assert.Equal(t, []string{"a", "b"}, claimValues(claims, "groups"))
+	assert.Equal(t, []string{"admin"}, claimValues(claims, "realm_access.roles"))
+	assert.Equal(t, []string{"viewer"}, claimValues(claims, "resource_access.ovim.example.com.roles"))
+	assert.Equal(t, []string{"acme"}, claimValues(claims, "https://example.com/tenant"))
+	assert.Equal(t, []string{"true"}, claimValues(claims, "verified"))
+	assert.Empty(t, claimValues(claims, "realm_access.groups"))
+	assert.Empty(t, claimValues(claims, "missing"))
+}
@@ -7,6 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
+	"unicode"
 
 	"golang.org/x/crypto/argon2"
 )
@@ -28,6 +30,13 @@ var (
 	ErrIncompatibleVersion = errors.New("incompatible argon2 version")
 	ErrPasswordTooShort    = errors.New("password must be at least 8 characters")
 	ErrPasswordTooLong     = errors.New("password must be less than 128 characters")
+	ErrPasswordReused      = errors.New("password was used recently")
+)
+
+const (
+	// MinPasswordLength and MaxPasswordLength bound the length of every password
+	MinPasswordLength = 8
+	MaxPasswordLength = 128
 )
 
 // PasswordConfig holds Argon2 configuration parameters
@@ -129,10 +138,10 @@ func (ph *PasswordHasher) VerifyPassword(password, encodedHash string) (bool, er
 
 // validatePassword performs basic password validation
 func validatePassword(password string) error {
-	if len(password) < 8 {
+	if len(password) < MinPasswordLength {
 		return ErrPasswordTooShort
 	}
-	if len(password) > 128 {
+	if len(password) > MaxPasswordLength {
 		return ErrPasswordTooLong
 	}
 	return nil
@@ -150,3 +159,139 @@ func HashPassword(password string) (string, error) {
 func VerifyPassword(password, encodedHash string) (bool, error) {
 	return defaultHasher.VerifyPassword(password, encodedHash)
 }
+
+// PasswordPolicy is the policy passwords chosen by users must satisfy
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters, never less than MinPasswordLength
+	MinLength        int
+	RequireUppercase bool
+	RequireLowercase bool
+	RequireDigit     bool
+	RequireSymbol    bool
+	// History is how many recent passwords, the current one included, cannot be chosen again
+	History int
+	// MaxAge is how long a password can be used before it has to be changed, zero for ever
+	MaxAge time.Duration
+}
+
+// DefaultPasswordPolicy returns the policy only requiring the length every password needs
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{MinLength: MinPasswordLength}
+}
+
+// PasswordPolicyError lists the rules of the policy a password breaks
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password " + strings.Join(e.Violations, ", ")
+}
+
+// Validate returns a *PasswordPolicyError if the password breaks the rules of the policy
+func (p PasswordPolicy) Validate(password string) error {
+	var violations []string
+	minLength := p.MinLength
+	if minLength < MinPasswordLength {
+		minLength = MinPasswordLength
+	}
+	if len(password) < minLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters", minLength))
+	}
+	if len(password) > MaxPasswordLength {
+		violations = append(violations, fmt.Sprintf("must be less than %d characters", MaxPasswordLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireUppercase && !upper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.RequireLowercase && !lower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// CheckReuse returns ErrPasswordReused if the password matches the current hash or one of the
+// previous hashes the policy keeps
+func (p PasswordPolicy) CheckReuse(password, currentHash string, previousHashes []string) error {
+	if p.History <= 0 {
+		return nil
+	}
+	hashes := append([]string{currentHash}, previousHashes...)
+	if len(hashes) > p.History {
+		hashes = hashes[:p.History]
+	}
+	for _, hash := range hashes {
+		if hash == "" {
+			continue
+		}
+		reused, err := VerifyPassword(password, hash)
+		if err != nil {
+			return err
+		}
+		if reused {
+			return ErrPasswordReused
+		}
+	}
+	return nil
+}
+
+// PreviousHashes returns the previous hashes to keep once the current password is replaced,
+// most recent first
+func (p PasswordPolicy) PreviousHashes(currentHash string, previousHashes []string) []string {
+	keep := p.History - 1
+	if keep <= 0 || currentHash == "" {
+		return nil
+	}
+	hashes := append([]string{currentHash}, previousHashes...)
+	if len(hashes) > keep {
+		hashes = hashes[:keep]
+	}
+	return hashes
+}
+
+// Expired reports whether a password set at changedAt has to be changed at now
+func (p PasswordPolicy) Expired(changedAt, now time.Time) bool {
+	return p.MaxAge > 0 && !changedAt.IsZero() && now.Sub(changedAt) >= p.MaxAge
+}
+
+// passwordResetTokenBytes is the number of random bytes in a password reset token
+const passwordResetTokenBytes = 32
+
+// NewPasswordResetToken returns a random password reset token and the hash under which it is stored
+func NewPasswordResetToken() (token, hash string, err error) {
+	b := make([]byte, passwordResetTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate password reset token: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashPasswordResetToken(token), nil
+}
+
+// HashPasswordResetToken returns the hash under which a password reset token is stored
+func HashPasswordResetToken(token string) string {
+	return hashToken(token)
+}
@@ -1,8 +1,10 @@
 package auth
 
 import (
+	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -311,6 +313,73 @@ func TestValidatePassword(t *testing.T) {
 	})
 }
 
+func TestPasswordPolicy_Validate(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 12, RequireUppercase: true, RequireLowercase: true, RequireDigit: true, RequireSymbol: true}
+
+	assert.NoError(t, policy.Validate("Correct-horse-1"))
+
+	err := policy.Validate("short")
+	var policyErr *PasswordPolicyError
+	require.ErrorAs(t, err, &policyErr)
+	assert.Equal(t, []string{
+		"must be at least 12 characters",
+		"must contain an uppercase letter",
+		"must contain a digit",
+		"must contain a symbol",
+	}, policyErr.Violations)
+	assert.Equal(t, "password must be at least 12 characters, must contain an uppercase letter, must contain a digit, must contain a symbol", err.Error())
+
+	t.Run("MinimumLength", func(t *testing.T) {
+		policy := PasswordPolicy{MinLength: 4}
+		assert.Error(t, policy.Validate("1234567"), "no policy allows passwords shorter than the minimum")
+		assert.NoError(t, policy.Validate("12345678"))
+		assert.Error(t, policy.Validate(strings.Repeat("a", MaxPasswordLength+1)))
+	})
+}
+
+func TestPasswordPolicy_History(t *testing.T) {
+	policy := PasswordPolicy{History: 3}
+	hashes := make([]string, 4)
+	for i := range hashes {
+		hash, err := HashPassword(fmt.Sprintf("password-%d", i))
+		require.NoError(t, err)
+		hashes[i] = hash
+	}
+
+	// hashes[0] is the current password, the others are older ones, most recent first
+	previous := policy.PreviousHashes(hashes[1], hashes[2:])
+	assert.Equal(t, hashes[1:3], previous, "the current password and the previous ones cover the history")
+
+	assert.Equal(t, ErrPasswordReused, policy.CheckReuse("password-0", hashes[0], previous))
+	assert.Equal(t, ErrPasswordReused, policy.CheckReuse("password-2", hashes[0], previous))
+	assert.NoError(t, policy.CheckReuse("password-3", hashes[0], hashes[1:]), "older passwords are beyond the history")
+	assert.NoError(t, policy.CheckReuse("password-4", hashes[0], previous))
+
+	assert.NoError(t, PasswordPolicy{}.CheckReuse("password-0", hashes[0], nil), "reuse is allowed without history")
+	assert.Nil(t, PasswordPolicy{History: 1}.PreviousHashes(hashes[0], hashes[1:]))
+}
+
+func TestPasswordPolicy_Expired(t *testing.T) {
+	changedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	policy := PasswordPolicy{MaxAge: 90 * 24 * time.Hour}
+
+	assert.False(t, policy.Expired(changedAt, changedAt.Add(89*24*time.Hour)))
+	assert.True(t, policy.Expired(changedAt, changedAt.Add(90*24*time.Hour)))
+	assert.False(t, policy.Expired(time.Time{}, changedAt), "passwords of unknown age do not expire")
+	assert.False(t, PasswordPolicy{}.Expired(changedAt, changedAt.Add(10*365*24*time.Hour)))
+}
+
+func TestPasswordResetToken(t *testing.T) {
+	token, hash, err := NewPasswordResetToken()
+	require.NoError(t, err)
+	other, _, err := NewPasswordResetToken()
+	require.NoError(t, err)
+
+	assert.NotEqual(t, token, other)
+	assert.NotEqual(t, token, hash)
+	assert.Equal(t, hash, HashPasswordResetToken(token))
+}
+
 func TestPasswordConstants(t *testing.T) {
 	assert.Equal(t, uint32(1), DefaultArgon2Time)
 	assert.Equal(t, uint32(64*1024), DefaultArgon2Memory)
@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+)
+
+const (
+	// ServiceAccountWildcard binds every ServiceAccount of a namespace
+	ServiceAccountWildcard = "*"
+
+	// DefaultServiceAccountCacheTTL is how long the review of a ServiceAccount token is reused
+	DefaultServiceAccountCacheTTL = time.Minute
+
+	// serviceAccountUsernamePrefix starts the Kubernetes username of ServiceAccounts
+	serviceAccountUsernamePrefix = "system:serviceaccount:"
+
+	// maxCachedReviews bounds the reviews kept, expired ones are dropped beyond it
+	maxCachedReviews = 1024
+)
+
+var (
+	// ErrInvalidServiceAccountToken is returned for tokens the Kubernetes API server does not
+	// authenticate as a ServiceAccount
+	ErrInvalidServiceAccountToken = errors.New("invalid service account token")
+
+	// ErrServiceAccountNotBound is returned for ServiceAccounts no binding maps to OVIM
+	ErrServiceAccountNotBound = errors.New("service account is not bound to an OVIM user or role")
+)
+
+// ServiceAccountBinding maps a ServiceAccount, or every ServiceAccount of a namespace, to OVIM.
+// The ServiceAccount acts either as the existing OVIM user named by User, with the role and
+// organization of the user, or as itself with Role in the organization OrgID.
+type ServiceAccountBinding struct {
+	Namespace      string `yaml:"namespace" json:"namespace"`
+	ServiceAccount string `yaml:"serviceAccount" json:"service_account"`
+	User           string `yaml:"user" json:"user"`
+	Role           string `yaml:"role" json:"role"`
+	OrgID          string `yaml:"orgId" json:"org_id"`
+}
+
+// ServiceAccountIdentity is who a ServiceAccount token acts as
+type ServiceAccountIdentity struct {
+	// ServiceAccount is the namespace/name of the ServiceAccount
+	ServiceAccount string
+	UserID         string
+	Username       string
+	Role           string
+	OrgID          string
+}
+
+// UserLookup returns the OVIM user with the username, nil if there is none
+type UserLookup func(username string) (*models.User, error)
+
+// ServiceAccountAuthenticator authenticates Kubernetes ServiceAccount tokens with the
+// TokenReview API and maps their ServiceAccounts to OVIM through bindings
+type ServiceAccountAuthenticator struct {
+	client     kubernetes.Interface
+	bindings   []ServiceAccountBinding
+	audiences  []string
+	lookupUser UserLookup
+	cacheTTL   time.Duration
+	now        func() time.Time
+
+	mu sync.Mutex
+	// reviews maps token hashes to the ServiceAccounts they authenticated as, empty if none
+	reviews map[string]cachedReview
+}
+
+type cachedReview struct {
+	serviceAccount string
+	expiresAt      time.Time
+}
+
+// NewServiceAccountAuthenticator validates the bindings and creates an authenticator reviewing
+// tokens with client. Tokens must be issued for one of audiences, or for the API server if
+// there are none.
+func NewServiceAccountAuthenticator(client kubernetes.Interface, bindings []ServiceAccountBinding, audiences []string) (*ServiceAccountAuthenticator, error) {
+	for i, binding := range bindings {
+		if binding.Namespace == "" || binding.ServiceAccount == "" {
+			return nil, fmt.Errorf("service account binding %d needs a namespace and a service account", i+1)
+		}
+		if (binding.User == "") == (binding.Role == "") {
+			return nil, fmt.Errorf("service account binding %d must give either a user or a role", i+1)
+		}
+		if binding.User != "" && binding.OrgID != "" {
+			return nil, fmt.Errorf("service account binding %d of a user cannot give an organization", i+1)
+		}
+		// Custom roles are defined per organization
+		if binding.Role != "" && !validRoles[binding.Role] && binding.OrgID == "" {
+			return nil, fmt.Errorf("service account binding %d has invalid role %q", i+1, binding.Role)
+		}
+	}
+	return &ServiceAccountAuthenticator{
+		client:    client,
+		bindings:  bindings,
+		audiences: audiences,
+		cacheTTL:  DefaultServiceAccountCacheTTL,
+		now:       time.Now,
+		reviews:   make(map[string]cachedReview),
+	}, nil
+}
+
+// SetUserLookup sets how the users of bindings are found. Bindings to users match nothing
+// without it.
+func (a *ServiceAccountAuthenticator) SetUserLookup(lookup UserLookup) {
+	a.lookupUser = lookup
+}
+
+// SetCacheTTL sets how long the review of a token is reused, zero reviews every request
+func (a *ServiceAccountAuthenticator) SetCacheTTL(ttl time.Duration) {
+	a.cacheTTL = ttl
+}
+
+// IsServiceAccountToken reports whether a bearer token looks like a JWT issued by someone else
+// than OVIM, such as the Kubernetes API server. The token is not verified.
+func IsServiceAccountToken(token string) bool {
+	claims := &jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return false
+	}
+	return claims.Issuer != "" && claims.Issuer != JWTIssuer
+}
+
+// Authenticate reviews a token and returns who its ServiceAccount acts as. It returns
+// ErrInvalidServiceAccountToken for tokens that are not valid ServiceAccount tokens and
+// ErrServiceAccountNotBound for ServiceAccounts without a binding.
+func (a *ServiceAccountAuthenticator) Authenticate(ctx context.Context, token string) (*ServiceAccountIdentity, error) {
+	serviceAccount, err := a.review(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if serviceAccount == "" {
+		return nil, ErrInvalidServiceAccountToken
+	}
+	namespace, name, _ := strings.Cut(serviceAccount, "/")
+
+	for _, binding := range a.bindings {
+		if binding.Namespace != namespace || (binding.ServiceAccount != name && binding.ServiceAccount != ServiceAccountWildcard) {
+			continue
+		}
+		if binding.Role != "" {
+			username := serviceAccountUsernamePrefix + namespace + ":" + name
+			return &ServiceAccountIdentity{
+				ServiceAccount: serviceAccount,
+				UserID:         username,
+				Username:       username,
+				Role:           binding.Role,
+				OrgID:          binding.OrgID,
+			}, nil
+		}
+		if a.lookupUser == nil {
+			continue
+		}
+		user, err := a.lookupUser(binding.User)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user %s bound to service account %s: %w", binding.User, serviceAccount, err)
+		}
+		if user == nil {
+			continue
+		}
+		identity := &ServiceAccountIdentity{
+			ServiceAccount: serviceAccount,
+			UserID:         user.ID,
+			Username:       user.Username,
+			Role:           user.Role,
+		}
+		if user.OrgID != nil {
+			identity.OrgID = *user.OrgID
+		}
+		return identity, nil
+	}
+	return nil, ErrServiceAccountNotBound
+}
+
+// review returns the namespace/name of the ServiceAccount the token authenticates as, empty if
+// it does not, reusing recent reviews of the token
+func (a *ServiceAccountAuthenticator) review(ctx context.Context, token string) (string, error) {
+	hash := hashToken(token)
+	now := a.now()
+	a.mu.Lock()
+	cached, ok := a.reviews[hash]
+	a.mu.Unlock()
+	if ok && cached.expiresAt.After(now) {
+		return cached.serviceAccount, nil
+	}
+
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: a.audiences},
+	}
+	result, err := a.client.AuthenticationV1().TokenReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to review service account token: %w", err)
+	}
+	serviceAccount := ""
+	if result.Status.Authenticated && a.audienceAccepted(result.Status.Audiences) {
+		if rest, ok := strings.CutPrefix(result.Status.User.Username, serviceAccountUsernamePrefix); ok {
+			if namespace, name, ok := strings.Cut(rest, ":"); ok && namespace != "" && name != "" {
+				serviceAccount = namespace + "/" + name
+			}
+		}
+	}
+
+	if a.cacheTTL > 0 {
+		a.mu.Lock()
+		if len(a.reviews) >= maxCachedReviews {
+			for key, entry := range a.reviews {
+				if !entry.expiresAt.After(now) {
+					delete(a.reviews, key)
+				}
+			}
+		}
+		if len(a.reviews) < maxCachedReviews {
+			a.reviews[hash] = cachedReview{serviceAccount: serviceAccount, expiresAt: now.Add(a.cacheTTL)}
+		}
+		a.mu.Unlock()
+	}
+	return serviceAccount, nil
+}
+
+// audienceAccepted reports whether the token was issued for one of the audiences. The API
+// server only returns audiences when some were requested.
+func (a *ServiceAccountAuthenticator) audienceAccepted(audiences []string) bool {
+	if len(a.audiences) == 0 {
+		return true
+	}
+	for _, audience := range audiences {
+		for _, accepted := range a.audiences {
+			if audience == accepted {
+				return true
+			}
+		}
+	}
+	return false
+}
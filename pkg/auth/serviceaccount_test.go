@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+)
+
+// fakeTokenReviews returns a clientset whose API server authenticates the tokens of accounts as
+// the given usernames for the given audiences, and counts the reviews
+func fakeTokenReviews(accounts map[string]string, audiences []string, reviews *int) *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		*reviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "unreachable" {
+			return true, nil, errors.New("connection refused")
+		}
+		result := review.DeepCopy()
+		if username, ok := accounts[review.Spec.Token]; ok {
+			result.Status.Authenticated = true
+			result.Status.User.Username = username
+			result.Status.Audiences = audiences
+		}
+		return true, result, nil
+	})
+	return client
+}
+
+// serviceAccountToken returns an unsigned JWT issued by the Kubernetes API server
+func serviceAccountToken(t *testing.T, subject string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.RegisteredClaims{
+		Issuer:  "https://kubernetes.default.svc.cluster.local",
+		Subject: subject,
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	return token
+}
+
+func TestNewServiceAccountAuthenticator(t *testing.T) {
+	valid := []ServiceAccountBinding{
+		{Namespace: "ci", ServiceAccount: "deployer", User: "deploy-bot"},
+		{Namespace: "acme", ServiceAccount: "*", Role: "org_user", OrgID: "org-1"},
+		{Namespace: "acme", ServiceAccount: "ops", Role: "operator", OrgID: "org-1"},
+		{Namespace: "platform", ServiceAccount: "admin", Role: "system_admin"},
+	}
+	_, err := NewServiceAccountAuthenticator(fake.NewSimpleClientset(), valid, nil)
+	require.NoError(t, err)
+
+	invalid := map[string]ServiceAccountBinding{
+		"missing namespace":  {ServiceAccount: "deployer", User: "deploy-bot"},
+		"missing account":    {Namespace: "ci", User: "deploy-bot"},
+		"neither":            {Namespace: "ci", ServiceAccount: "deployer"},
+		"user and role":      {Namespace: "ci", ServiceAccount: "deployer", User: "deploy-bot", Role: "org_user"},
+		"user with org":      {Namespace: "ci", ServiceAccount: "deployer", User: "deploy-bot", OrgID: "org-1"},
+		"custom role no org": {Namespace: "ci", ServiceAccount: "deployer", Role: "operator"},
+	}
+	for name, binding := range invalid {
+		_, err := NewServiceAccountAuthenticator(fake.NewSimpleClientset(), []ServiceAccountBinding{binding}, nil)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestIsServiceAccountToken(t *testing.T) {
+	assert.True(t, IsServiceAccountToken(serviceAccountToken(t, "system:serviceaccount:ci:deployer")))
+
+	ovimToken, err := NewTokenManager("secret", time.Hour).GenerateToken("user-1", "alice", "org_user", "org-1")
+	require.NoError(t, err)
+	assert.False(t, IsServiceAccountToken(ovimToken))
+	assert.False(t, IsServiceAccountToken("ovim_0123456789"))
+	assert.False(t, IsServiceAccountToken("not-a-jwt"))
+}
+
+func TestServiceAccountAuthenticator_Authenticate(t *testing.T) {
+	orgID := "org-1"
+	users := map[string]*models.User{
+		"deploy-bot": {ID: "user-9", Username: "deploy-bot", Role: "org_admin", OrgID: &orgID},
+	}
+	var reviews int
+	client := fakeTokenReviews(map[string]string{
+		"deployer": "system:serviceaccount:ci:deployer",
+		"builder":  "system:serviceaccount:acme:builder",
+		"stray":    "system:serviceaccount:other:stray",
+		"ghost":    "system:serviceaccount:ci:ghost",
+		"node":     "system:node:worker-1",
+	}, []string{"ovim"}, &reviews)
+	authenticator, err := NewServiceAccountAuthenticator(client, []ServiceAccountBinding{
+		{Namespace: "ci", ServiceAccount: "deployer", User: "deploy-bot"},
+		{Namespace: "ci", ServiceAccount: "ghost", User: "deleted-user"},
+		{Namespace: "acme", ServiceAccount: "*", Role: "org_user", OrgID: "org-2"},
+	}, []string{"ovim"})
+	require.NoError(t, err)
+	authenticator.SetUserLookup(func(username string) (*models.User, error) {
+		if username == "broken" {
+			return nil, errors.New("database is down")
+		}
+		return users[username], nil
+	})
+	ctx := context.Background()
+
+	identity, err := authenticator.Authenticate(ctx, "deployer")
+	require.NoError(t, err)
+	assert.Equal(t, &ServiceAccountIdentity{ServiceAccount: "ci/deployer", UserID: "user-9", Username: "deploy-bot", Role: "org_admin", OrgID: "org-1"}, identity)
+
+	identity, err = authenticator.Authenticate(ctx, "builder")
+	require.NoError(t, err)
+	assert.Equal(t, &ServiceAccountIdentity{
+		ServiceAccount: "acme/builder",
+		UserID:         "system:serviceaccount:acme:builder",
+		Username:       "system:serviceaccount:acme:builder",
+		Role:           "org_user",
+		OrgID:          "org-2",
+	}, identity)
+
+	_, err = authenticator.Authenticate(ctx, "stray")
+	assert.ErrorIs(t, err, ErrServiceAccountNotBound)
+	_, err = authenticator.Authenticate(ctx, "ghost")
+	assert.ErrorIs(t, err, ErrServiceAccountNotBound, "bound to a user that does not exist")
+	_, err = authenticator.Authenticate(ctx, "forged")
+	assert.ErrorIs(t, err, ErrInvalidServiceAccountToken)
+	_, err = authenticator.Authenticate(ctx, "node")
+	assert.ErrorIs(t, err, ErrInvalidServiceAccountToken, "not a service account")
+	_, err = authenticator.Authenticate(ctx, "unreachable")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidServiceAccountToken)
+}
+
+func TestServiceAccountAuthenticator_Audiences(t *testing.T) {
+	var reviews int
+	client := fakeTokenReviews(map[string]string{"deployer": "system:serviceaccount:ci:deployer"}, []string{"https://kubernetes.default.svc"}, &reviews)
+	bindings := []ServiceAccountBinding{{Namespace: "ci", ServiceAccount: "deployer", Role: "system_admin"}}
+
+	authenticator, err := NewServiceAccountAuthenticator(client, bindings, []string{"ovim"})
+	require.NoError(t, err)
+	_, err = authenticator.Authenticate(context.Background(), "deployer")
+	assert.ErrorIs(t, err, ErrInvalidServiceAccountToken, "issued for another audience")
+
+	authenticator, err = NewServiceAccountAuthenticator(client, bindings, nil)
+	require.NoError(t, err)
+	_, err = authenticator.Authenticate(context.Background(), "deployer")
+	assert.NoError(t, err)
+}
+
+func TestServiceAccountAuthenticator_Cache(t *testing.T) {
+	var reviews int
+	client := fakeTokenReviews(map[string]string{"deployer": "system:serviceaccount:ci:deployer"}, nil, &reviews)
+	authenticator, err := NewServiceAccountAuthenticator(client, []ServiceAccountBinding{
+		{Namespace: "ci", ServiceAccount: "deployer", Role: "org_user", OrgID: "org-1"},
+	}, nil)
+	require.NoError(t, err)
+	now := time.Now()
+	authenticator.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := authenticator.Authenticate(ctx, "deployer")
+		require.NoError(t, err)
+		_, err = authenticator.Authenticate(ctx, "forged")
+		require.ErrorIs(t, err, ErrInvalidServiceAccountToken)
+	}
+	assert.Equal(t, 2, reviews, "valid and invalid tokens are reviewed once")
+
+	now = now.Add(DefaultServiceAccountCacheTTL)
+	_, err = authenticator.Authenticate(ctx, "deployer")
+	require.NoError(t, err)
+	assert.Equal(t, 3, reviews, "expired reviews are repeated")
+
+	authenticator.SetCacheTTL(0)
+	_, err = authenticator.Authenticate(ctx, "forged")
+	require.ErrorIs(t, err, ErrInvalidServiceAccountToken)
+	_, err = authenticator.Authenticate(ctx, "forged")
+	require.ErrorIs(t, err, ErrInvalidServiceAccountToken)
+	assert.Equal(t, 5, reviews)
+}
+
+func TestMiddleware_RequireAuthServiceAccount(t *testing.T) {
+	tm := NewTokenManager("test-secret", time.Hour)
+	ovimToken, err := tm.GenerateToken("user-123", "testuser", "org_user", "org-456")
+	require.NoError(t, err)
+	deployer := serviceAccountToken(t, "system:serviceaccount:ci:deployer")
+	stray := serviceAccountToken(t, "system:serviceaccount:ci:stray")
+	forged := serviceAccountToken(t, "system:serviceaccount:ci:forged")
+
+	var reviews int
+	client := fakeTokenReviews(map[string]string{
+		deployer: "system:serviceaccount:ci:deployer",
+		stray:    "system:serviceaccount:ci:stray",
+	}, nil, &reviews)
+	authenticator, err := NewServiceAccountAuthenticator(client, []ServiceAccountBinding{
+		{Namespace: "ci", ServiceAccount: "deployer", Role: "org_admin", OrgID: "org-456"},
+	}, nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		token          string
+		expectedStatus int
+		expectedRole   string
+		expectedSA     string
+	}{
+		{"BoundServiceAccount", deployer, http.StatusOK, "org_admin", "ci/deployer"},
+		{"UnboundServiceAccount", stray, http.StatusForbidden, "", ""},
+		{"RejectedToken", forged, http.StatusUnauthorized, "", ""},
+		{"JWTStillAccepted", ovimToken, http.StatusOK, "org_user", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			middleware := NewMiddleware(tm)
+			middleware.SetServiceAccountAuthenticator(authenticator)
+
+			router := setupTestGin()
+			router.GET("/test", middleware.RequireAuth(), func(c *gin.Context) {
+				_, _, role, orgID, ok := GetUserFromContext(c)
+				require.True(t, ok)
+				assert.Equal(t, tt.expectedRole, role)
+				assert.Equal(t, "org-456", orgID)
+				serviceAccount, _ := GetServiceAccountFromContext(c)
+				assert.Equal(t, tt.expectedSA, serviceAccount)
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set(AuthorizationHeader, BearerPrefix+tt.token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+
+	// Without the authenticator the tokens of ServiceAccounts are invalid JWTs
+	router := setupTestGin()
+	router.GET("/test", NewMiddleware(tm).RequireAuth(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set(AuthorizationHeader, BearerPrefix+deployer)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPPeriod is how long a TOTP code is valid, TOTPDigits how many digits it has
+	TOTPPeriod = 30 * time.Second
+	TOTPDigits = 6
+
+	// totpSecretBytes is the number of random bytes in a TOTP secret, the size of a SHA-1 key
+	totpSecretBytes = 20
+
+	// totpSkew is how many periods before and after the current one are accepted, for clocks
+	// that drift and codes typed at the end of their period
+	totpSkew = 1
+
+	// recoveryCodeBytes is the number of random bytes in a recovery code
+	recoveryCodeBytes = 5
+)
+
+// totpEncoding encodes TOTP secrets and recovery codes like authenticator apps expect
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random TOTP secret, base32 encoded
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPURI returns the otpauth URI authenticator apps read from a QR code to add the secret of
+// an account
+func TOTPURI(issuer, account, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(TOTPDigits))
+	params.Set("period", fmt.Sprint(int(TOTPPeriod.Seconds())))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// TOTPCode returns the code of a secret at the given time, as defined by RFC 6238
+func TOTPCode(secret string, at time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	return totpCode(key, totpCounter(at)), nil
+}
+
+// VerifyTOTP checks a code of a secret at the given time and returns the counter of its period.
+// Codes of periods up to lastCounter were used already and are rejected, so that a code
+// cannot be replayed.
+func VerifyTOTP(secret, code string, at time.Time, lastCounter int64) (int64, bool, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return 0, false, nil
+	}
+
+	current := totpCounter(at)
+	for counter := current - totpSkew; counter <= current+totpSkew; counter++ {
+		if counter <= lastCounter {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, counter)), []byte(code)) == 1 {
+			return counter, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// totpCounter returns the number of periods since the Unix epoch
+func totpCounter(at time.Time) int64 {
+	return at.Unix() / int64(TOTPPeriod.Seconds())
+}
+
+// totpCode computes the HOTP code of RFC 4226 for a counter
+func totpCode(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod)
+}
+
+// NewRecoveryCodes returns n random recovery codes and the hashes under which they are stored.
+// Each code lets the user log in once without the authenticator app.
+func NewRecoveryCodes(n int) (codes, hashes []string, err error) {
+	for i := 0; i < n; i++ {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		code = code[:len(code)/2] + "-" + code[len(code)/2:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns the hash under which a recovery code is stored. Codes are compared
+// regardless of case and dashes.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return hashToken(strings.ReplaceAll(code, "-", ""))
+}
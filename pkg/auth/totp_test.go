@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfc6238Secret is the SHA-1 key of the test vectors of RFC 6238
+var rfc6238Secret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode(t *testing.T) {
+	// The last six digits of the eight digit codes of RFC 6238, appendix B
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, expected := range vectors {
+		code, err := TOTPCode(rfc6238Secret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, expected, code, "code at %d", unix)
+	}
+
+	_, err := TOTPCode("not base32!", time.Now())
+	assert.Error(t, err)
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	code, err := TOTPCode(secret, now)
+	require.NoError(t, err)
+
+	counter, ok, err := VerifyTOTP(secret, code, now, 0)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/30, counter)
+
+	_, ok, err = VerifyTOTP(secret, " "+code+" ", now.Add(TOTPPeriod), 0)
+	require.NoError(t, err)
+	assert.True(t, ok, "codes of the previous period are accepted")
+
+	_, ok, err = VerifyTOTP(secret, code, now.Add(2*TOTPPeriod), 0)
+	require.NoError(t, err)
+	assert.False(t, ok, "older codes are not")
+
+	_, ok, err = VerifyTOTP(secret, code, now, counter)
+	require.NoError(t, err)
+	assert.False(t, ok, "used codes cannot be replayed")
+
+	_, ok, err = VerifyTOTP(secret, "12345", now, 0)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("OVIM", "alice@example.com", "SECRET"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/OVIM:alice@example.com", uri.Path)
+	assert.Equal(t, "SECRET", uri.Query().Get("secret"))
+	assert.Equal(t, "OVIM", uri.Query().Get("issuer"))
+	assert.Equal(t, "6", uri.Query().Get("digits"))
+	assert.Equal(t, "30", uri.Query().Get("period"))
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes(10)
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+	require.Len(t, hashes, 10)
+
+	seen := map[string]bool{}
+	for i, code := range codes {
+		assert.Regexp(t, `^[a-z2-7]{4}-[a-z2-7]{4}$`, code)
+		assert.False(t, seen[code], "codes are unique")
+		seen[code] = true
+		assert.Equal(t, hashes[i], HashRecoveryCode(code))
+	}
+
+	// Codes are typed with or without the dash and in any case
+	assert.Equal(t, hashes[0], HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))))
+}
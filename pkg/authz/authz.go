@@ -0,0 +1,285 @@
+// Package authz decides what users may do.
+//
+// A permission is a verb on a kind of resource, such as "vm:power". Roles bind verbs within a
+// scope: everywhere, within the organization of the user, or only for the resources the user
+// owns within it. The four built-in roles have fixed bindings, and organizations may define
+// custom roles whose verbs apply within the organization. Handlers check every request with a
+// single Authorize call naming the verb and the resource it acts on.
+package authz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// contextKey is the gin context key of the authorizer of a request
+const contextKey = "authorizer"
+
+var (
+	// ErrUnauthenticated is returned when the context carries no authenticated user
+	ErrUnauthenticated = errors.New("user not authenticated")
+	// ErrForbidden is returned when the user may not perform the verb on the resource
+	ErrForbidden = errors.New("insufficient permissions")
+)
+
+// Scope is how far a binding of a verb reaches. Wider scopes include the narrower ones.
+type Scope int
+
+const (
+	// ScopeNone grants nothing
+	ScopeNone Scope = iota
+	// ScopeOwn covers the resources of the user's organization that the user owns
+	ScopeOwn
+	// ScopeOrganization covers every resource of the user's organization
+	ScopeOrganization
+	// ScopeGlobal covers every resource, including those outside any organization
+	ScopeGlobal
+)
+
+// String returns the name of the scope
+func (s Scope) String() string {
+	switch s {
+	case ScopeOwn:
+		return "own"
+	case ScopeOrganization:
+		return "organization"
+	case ScopeGlobal:
+		return "global"
+	default:
+		return "none"
+	}
+}
+
+// Resource is what a verb acts on. The zero Resource is outside any organization, so only global
+// bindings cover it.
+type Resource struct {
+	OrgID   string
+	OwnerID string
+}
+
+// Global returns a resource outside any organization, such as the list of all organizations
+func Global() Resource {
+	return Resource{}
+}
+
+// InOrganization returns a resource of an organization
+func InOrganization(orgID string) Resource {
+	return Resource{OrgID: orgID}
+}
+
+// OwnedBy returns a resource of an organization owned by a user
+func OwnedBy(orgID, ownerID string) Resource {
+	return Resource{OrgID: orgID, OwnerID: ownerID}
+}
+
+// Subject is the user a decision is made for
+type Subject struct {
+	UserID string
+	Role   string
+	OrgID  string
+}
+
+// SubjectFromContext returns the authenticated user of a request context
+func SubjectFromContext(ctx context.Context) (Subject, bool) {
+	userID, _ := ctx.Value(auth.ContextKeyUserID).(string)
+	role, _ := ctx.Value(auth.ContextKeyRole).(string)
+	orgID, _ := ctx.Value(auth.ContextKeyOrgID).(string)
+	if userID == "" || role == "" {
+		return Subject{}, false
+	}
+	return Subject{UserID: userID, Role: role, OrgID: orgID}, true
+}
+
+// Binding grants verbs within a scope
+type Binding struct {
+	Scope Scope
+	Verbs []Verb
+}
+
+// Permissions are the verbs a subject may perform and how far each reaches
+type Permissions struct {
+	subject Subject
+	scopes  map[Verb]Scope
+}
+
+// newPermissions merges bindings, keeping the widest scope of every verb
+func newPermissions(subject Subject, bindings []Binding) *Permissions {
+	p := &Permissions{subject: subject, scopes: make(map[Verb]Scope)}
+	for _, binding := range bindings {
+		for _, verb := range binding.Verbs {
+			if binding.Scope > p.scopes[verb] {
+				p.scopes[verb] = binding.Scope
+			}
+		}
+	}
+	return p
+}
+
+// Subject returns the user the permissions belong to
+func (p *Permissions) Subject() Subject {
+	return p.subject
+}
+
+// Scope returns how far the verb reaches, ScopeNone if it is not granted
+func (p *Permissions) Scope(verb Verb) Scope {
+	scope := p.scopes[verb]
+	for _, wildcard := range []Verb{verb.Kind() + ":*", All} {
+		if p.scopes[wildcard] > scope {
+			scope = p.scopes[wildcard]
+		}
+	}
+	return scope
+}
+
+// Allows reports whether the verb may be performed on the resource
+func (p *Permissions) Allows(verb Verb, resource Resource) bool {
+	switch p.Scope(verb) {
+	case ScopeGlobal:
+		return true
+	case ScopeOrganization:
+		return p.inOrganization(resource)
+	case ScopeOwn:
+		return p.inOrganization(resource) && resource.OwnerID == p.subject.UserID
+	default:
+		return false
+	}
+}
+
+// Covers reports whether p allows everything other allows, within the organization of the
+// subject of other. Users may only grant roles their permissions cover.
+func (p *Permissions) Covers(other *Permissions) bool {
+	for _, verb := range verbs {
+		var resource Resource
+		switch other.Scope(verb) {
+		case ScopeNone:
+			continue
+		case ScopeGlobal:
+			resource = Global()
+		case ScopeOrganization:
+			resource = InOrganization(other.subject.OrgID)
+		case ScopeOwn:
+			resource = OwnedBy(other.subject.OrgID, p.subject.UserID)
+		}
+		if !p.Allows(verb, resource) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Permissions) inOrganization(resource Resource) bool {
+	return p.subject.OrgID != "" && resource.OrgID == p.subject.OrgID
+}
+
+// RoleStore looks up the custom roles of organizations
+type RoleStore interface {
+	GetOrgRoleByName(orgID, name string) (*models.OrgRole, error)
+}
+
+// Authorizer resolves the permissions of users from their role
+type Authorizer struct {
+	roles RoleStore
+}
+
+// NewAuthorizer returns an authorizer looking up custom roles in roles. Without a role store
+// only the built-in roles grant permissions.
+func NewAuthorizer(roles RoleStore) *Authorizer {
+	return &Authorizer{roles: roles}
+}
+
+// defaultAuthorizer serves requests that passed no Middleware, it knows the built-in roles only
+var defaultAuthorizer = NewAuthorizer(nil)
+
+// Middleware makes the authorizer decide for the requests it handles
+func (a *Authorizer) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(contextKey, a)
+		c.Next()
+	}
+}
+
+// PermissionsFor returns the permissions of a subject. A role that is neither built in nor a
+// custom role of the subject's organization grants nothing.
+func (a *Authorizer) PermissionsFor(subject Subject) (*Permissions, error) {
+	if bindings, ok := builtinRoles[subject.Role]; ok {
+		return newPermissions(subject, bindings), nil
+	}
+	if a.roles == nil || subject.OrgID == "" {
+		return newPermissions(subject, nil), nil
+	}
+
+	role, err := a.roles.GetOrgRoleByName(subject.OrgID, subject.Role)
+	if err == storage.ErrNotFound {
+		return newPermissions(subject, nil), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get role %s of organization %s: %w", subject.Role, subject.OrgID, err)
+	}
+	verbs := make([]Verb, 0, len(role.Verbs))
+	for _, verb := range role.Verbs {
+		verbs = append(verbs, Verb(verb))
+	}
+	return newPermissions(subject, []Binding{{Scope: ScopeOrganization, Verbs: verbs}}), nil
+}
+
+// Permissions returns the permissions of the authenticated user of a request context
+func (a *Authorizer) Permissions(ctx context.Context) (*Permissions, error) {
+	subject, ok := SubjectFromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	return a.PermissionsFor(subject)
+}
+
+// Authorize returns nil if the authenticated user of the context may perform the verb on the
+// resource, ErrForbidden if not and ErrUnauthenticated without a user
+func (a *Authorizer) Authorize(ctx context.Context, verb Verb, resource Resource) error {
+	permissions, err := a.Permissions(ctx)
+	if err != nil {
+		return err
+	}
+	if !permissions.Allows(verb, resource) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// AuthorizeList returns how far the authenticated user of the context may list with the verb,
+// which tells list handlers how to filter. It returns ErrForbidden if the verb is not granted.
+func (a *Authorizer) AuthorizeList(ctx context.Context, verb Verb) (Scope, error) {
+	permissions, err := a.Permissions(ctx)
+	if err != nil {
+		return ScopeNone, err
+	}
+	scope := permissions.Scope(verb)
+	if scope == ScopeNone {
+		return ScopeNone, ErrForbidden
+	}
+	return scope, nil
+}
+
+// FromContext returns the authorizer set by Middleware, or one that knows the built-in roles only
+func FromContext(ctx context.Context) *Authorizer {
+	if a, ok := ctx.Value(contextKey).(*Authorizer); ok {
+		return a
+	}
+	return defaultAuthorizer
+}
+
+// Authorize checks a request with the authorizer of its context, see Authorizer.Authorize
+func Authorize(ctx context.Context, verb Verb, resource Resource) error {
+	return FromContext(ctx).Authorize(ctx, verb, resource)
+}
+
+// AuthorizeList checks a list request with the authorizer of its context, see
+// Authorizer.AuthorizeList
+func AuthorizeList(ctx context.Context, verb Verb) (Scope, error) {
+	return FromContext(ctx).AuthorizeList(ctx, verb)
+}
@@ -0,0 +1,174 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/auth"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// fakeRoles holds custom roles by organization and name
+type fakeRoles struct {
+	roles map[string]*models.OrgRole
+	err   error
+}
+
+func (f *fakeRoles) GetOrgRoleByName(orgID, name string) (*models.OrgRole, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	role, ok := f.roles[orgID+"/"+name]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return role, nil
+}
+
+func subjectContext(userID, role, orgID string) context.Context {
+	ctx := context.WithValue(context.Background(), auth.ContextKeyUserID, userID)
+	ctx = context.WithValue(ctx, auth.ContextKeyRole, role)
+	return context.WithValue(ctx, auth.ContextKeyOrgID, orgID)
+}
+
+func TestBuiltinRoles(t *testing.T) {
+	authorizer := NewAuthorizer(nil)
+	permissionsOf := func(role, orgID string) *Permissions {
+		permissions, err := authorizer.PermissionsFor(Subject{UserID: "user-1", Role: role, OrgID: orgID})
+		require.NoError(t, err)
+		return permissions
+	}
+
+	tests := []struct {
+		name     string
+		role     string
+		orgID    string
+		verb     Verb
+		resource Resource
+		allowed  bool
+	}{
+		{"system admin lists organizations", models.RoleSystemAdmin, "", OrgList, Global(), true},
+		{"system admin deletes any VM", models.RoleSystemAdmin, "", VMDelete, OwnedBy("org-2", "user-2"), true},
+		{"org admin updates a VDC of its organization", models.RoleOrgAdmin, "org-1", VDCUpdate, InOrganization("org-1"), true},
+		{"org admin cannot update a VDC of another organization", models.RoleOrgAdmin, "org-1", VDCUpdate, InOrganization("org-2"), false},
+		{"org admin powers any VM of its organization", models.RoleOrgAdmin, "org-1", VMPower, OwnedBy("org-1", "user-2"), true},
+		{"org admin cannot list organizations", models.RoleOrgAdmin, "org-1", OrgList, Global(), false},
+		{"org admin cannot export backups", models.RoleOrgAdmin, "org-1", BackupExport, Global(), false},
+		{"org user powers its own VM", models.RoleOrgUser, "org-1", VMPower, OwnedBy("org-1", "user-1"), true},
+		{"org user cannot power the VM of another user", models.RoleOrgUser, "org-1", VMPower, OwnedBy("org-1", "user-2"), false},
+		{"org user cannot create a VDC", models.RoleOrgUser, "org-1", VDCCreate, InOrganization("org-1"), false},
+		{"org member reads VDCs", models.RoleOrgMember, "org-1", VDCGet, InOrganization("org-1"), true},
+		{"org member cannot read VMs", models.RoleOrgMember, "org-1", VMGet, OwnedBy("org-1", "user-1"), false},
+		{"org member cannot deploy", models.RoleOrgMember, "org-1", OpenShiftManage, InOrganization("org-1"), false},
+		{"organization scope needs an organization", models.RoleOrgAdmin, "", VDCGet, InOrganization(""), false},
+		{"unknown role grants nothing", "auditor", "org-1", VDCGet, InOrganization("org-1"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.allowed, permissionsOf(tt.role, tt.orgID).Allows(tt.verb, tt.resource))
+		})
+	}
+}
+
+func TestPermissions_Scope(t *testing.T) {
+	subject := Subject{UserID: "user-1", Role: "custom", OrgID: "org-1"}
+	permissions := newPermissions(subject, []Binding{
+		{Scope: ScopeOwn, Verbs: []Verb{VMList, "vdc:*"}},
+		{Scope: ScopeOrganization, Verbs: []Verb{VMList, OrgGet}},
+	})
+
+	assert.Equal(t, ScopeOrganization, permissions.Scope(VMList), "the widest binding wins")
+	assert.Equal(t, ScopeOwn, permissions.Scope(VDCDelete), "kind wildcards match every action")
+	assert.Equal(t, ScopeNone, permissions.Scope(VMDelete))
+	assert.Equal(t, subject, permissions.Subject())
+
+	admin := newPermissions(subject, []Binding{{Scope: ScopeGlobal, Verbs: []Verb{All}}})
+	assert.Equal(t, ScopeGlobal, admin.Scope(BackupImport))
+	assert.Equal(t, "global", ScopeGlobal.String())
+	assert.Equal(t, "none", ScopeNone.String())
+}
+
+func TestPermissions_Covers(t *testing.T) {
+	authorizer := NewAuthorizer(nil)
+	permissionsOf := func(role, orgID string) *Permissions {
+		permissions, err := authorizer.PermissionsFor(Subject{UserID: "user-1", Role: role, OrgID: orgID})
+		require.NoError(t, err)
+		return permissions
+	}
+
+	admin := permissionsOf(models.RoleSystemAdmin, "")
+	orgAdmin := permissionsOf(models.RoleOrgAdmin, "org-1")
+	assert.True(t, admin.Covers(permissionsOf(models.RoleOrgAdmin, "org-1")))
+	assert.True(t, orgAdmin.Covers(permissionsOf(models.RoleOrgUser, "org-1")))
+	assert.True(t, orgAdmin.Covers(permissionsOf(models.RoleOrgMember, "org-1")))
+	assert.False(t, orgAdmin.Covers(permissionsOf(models.RoleOrgUser, "org-2")), "another organization")
+	assert.False(t, orgAdmin.Covers(permissionsOf(models.RoleSystemAdmin, "")))
+	assert.False(t, permissionsOf(models.RoleOrgUser, "org-1").Covers(permissionsOf(models.RoleOrgAdmin, "org-1")))
+}
+
+func TestAuthorizer_CustomRoles(t *testing.T) {
+	roles := &fakeRoles{roles: map[string]*models.OrgRole{
+		"org-1/operator": {ID: "role-1", OrgID: "org-1", Name: "operator", Verbs: models.JSONBArray{"vm:power", "vdc:*"}},
+	}}
+	authorizer := NewAuthorizer(roles)
+
+	ctx := subjectContext("user-1", "operator", "org-1")
+	assert.NoError(t, authorizer.Authorize(ctx, VMPower, OwnedBy("org-1", "user-2")))
+	assert.NoError(t, authorizer.Authorize(ctx, VDCDelete, InOrganization("org-1")))
+	assert.ErrorIs(t, authorizer.Authorize(ctx, VMDelete, OwnedBy("org-1", "user-2")), ErrForbidden)
+	assert.ErrorIs(t, authorizer.Authorize(ctx, VMPower, OwnedBy("org-2", "user-2")), ErrForbidden)
+
+	scope, err := authorizer.AuthorizeList(ctx, VDCList)
+	require.NoError(t, err)
+	assert.Equal(t, ScopeOrganization, scope)
+	_, err = authorizer.AuthorizeList(ctx, VMList)
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	// The role is looked up in the organization of the user only
+	other := subjectContext("user-2", "operator", "org-2")
+	assert.ErrorIs(t, authorizer.Authorize(other, VMPower, OwnedBy("org-2", "user-2")), ErrForbidden)
+
+	// Without a role store custom roles grant nothing
+	assert.ErrorIs(t, NewAuthorizer(nil).Authorize(ctx, VMPower, OwnedBy("org-1", "user-2")), ErrForbidden)
+
+	roles.err = errors.New("connection refused")
+	err = authorizer.Authorize(ctx, VMPower, OwnedBy("org-1", "user-2"))
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrForbidden)
+}
+
+func TestAuthorizer_Unauthenticated(t *testing.T) {
+	_, ok := SubjectFromContext(context.Background())
+	assert.False(t, ok)
+
+	err := Authorize(context.Background(), VMList, Global())
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+	_, err = AuthorizeList(context.Background(), VMList)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	subject, ok := SubjectFromContext(subjectContext("user-1", models.RoleOrgUser, "org-1"))
+	require.True(t, ok)
+	assert.Equal(t, Subject{UserID: "user-1", Role: models.RoleOrgUser, OrgID: "org-1"}, subject)
+}
+
+func TestVerbs(t *testing.T) {
+	assert.True(t, ValidVerb(VMPower))
+	assert.True(t, ValidVerb("vm:*"))
+	assert.True(t, ValidVerb(All))
+	assert.False(t, ValidVerb("vm:reboot"))
+	assert.False(t, ValidVerb("cluster:*"))
+
+	assert.Equal(t, []Verb{VMPower}, Expand(VMPower))
+	assert.Len(t, Expand("vm:*"), 7)
+	assert.Len(t, Expand(All), len(Verbs()))
+	assert.Empty(t, Expand("vm:reboot"))
+
+	assert.Equal(t, Verb("vm"), VMPower.Kind())
+	assert.True(t, IsBuiltinRole(models.RoleOrgMember))
+	assert.False(t, IsBuiltinRole("operator"))
+}
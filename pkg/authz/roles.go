@@ -0,0 +1,203 @@
+package authz
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+)
+
+// Verb is an action on a kind of resource, written kind:action. The action "*" stands for every
+// action on the kind, and the verb "*" for every verb.
+type Verb string
+
+// All grants every verb
+const All Verb = "*"
+
+// Kind returns the kind of resource the verb acts on
+func (v Verb) Kind() Verb {
+	kind, _, _ := strings.Cut(string(v), ":")
+	return Verb(kind)
+}
+
+// Verbs of virtual machines
+const (
+	VMList    Verb = "vm:list"
+	VMGet     Verb = "vm:get"
+	VMCreate  Verb = "vm:create"
+	VMPower   Verb = "vm:power"
+	VMConsole Verb = "vm:console"
+	VMDelete  Verb = "vm:delete"
+	VMRestore Verb = "vm:restore"
+)
+
+// Verbs of virtual data centers
+const (
+	VDCList    Verb = "vdc:list"
+	VDCGet     Verb = "vdc:get"
+	VDCCreate  Verb = "vdc:create"
+	VDCUpdate  Verb = "vdc:update"
+	VDCDelete  Verb = "vdc:delete"
+	VDCRestore Verb = "vdc:restore"
+)
+
+// Verbs of organizations
+const (
+	OrgList      Verb = "org:list"
+	OrgGet       Verb = "org:get"
+	OrgCreate    Verb = "org:create"
+	OrgUpdate    Verb = "org:update"
+	OrgDelete    Verb = "org:delete"
+	OrgRestore   Verb = "org:restore"
+	OrgReconcile Verb = "org:reconcile"
+	OrgUsage     Verb = "org:usage"
+)
+
+// Verbs of users
+const (
+	UserList   Verb = "user:list"
+	UserGet    Verb = "user:get"
+	UserCreate Verb = "user:create"
+	UserUpdate Verb = "user:update"
+	UserDelete Verb = "user:delete"
+	// UserImpersonate acts as a user with a short-lived token, to see the API as the user does
+	UserImpersonate Verb = "user:impersonate"
+)
+
+// Verbs of templates, catalogs and catalog sources
+const (
+	CatalogView   Verb = "catalog:view"
+	CatalogManage Verb = "catalog:manage"
+)
+
+// Verbs of the custom roles of organizations
+const (
+	RoleList   Verb = "role:list"
+	RoleManage Verb = "role:manage"
+)
+
+// Verbs of the memberships of users in organizations besides their own
+const (
+	MembershipManage Verb = "membership:manage"
+)
+
+// Verbs of the invitations to join organizations
+const (
+	InvitationList   Verb = "invitation:list"
+	InvitationManage Verb = "invitation:manage"
+)
+
+// Verbs of the audit trail of mutating API calls
+const (
+	AuditList Verb = "audit:list"
+)
+
+// Verbs of administration
+const (
+	TrashList    Verb = "trash:list"
+	BackupExport Verb = "backup:export"
+	BackupImport Verb = "backup:import"
+	TokenList    Verb = "token:list"
+	TokenDelete  Verb = "token:delete"
+)
+
+// Verbs of dashboards, events, alerts, the OpenShift integration and the change stream
+const (
+	DashboardView   Verb = "dashboard:view"
+	EventList       Verb = "event:list"
+	AlertList       Verb = "alert:list"
+	OpenShiftView   Verb = "openshift:view"
+	OpenShiftManage Verb = "openshift:manage"
+	ChangeWatch     Verb = "change:watch"
+)
+
+// verbs are the known verbs
+var verbs = []Verb{
+	VMList, VMGet, VMCreate, VMPower, VMConsole, VMDelete, VMRestore,
+	VDCList, VDCGet, VDCCreate, VDCUpdate, VDCDelete, VDCRestore,
+	OrgList, OrgGet, OrgCreate, OrgUpdate, OrgDelete, OrgRestore, OrgReconcile, OrgUsage,
+	UserList, UserGet, UserCreate, UserUpdate, UserDelete, UserImpersonate,
+	CatalogView, CatalogManage,
+	RoleList, RoleManage,
+	MembershipManage,
+	InvitationList, InvitationManage,
+	AuditList,
+	TrashList, BackupExport, BackupImport, TokenList, TokenDelete,
+	DashboardView, EventList, AlertList, OpenShiftView, OpenShiftManage, ChangeWatch,
+}
+
+// Verbs returns the known verbs, sorted
+func Verbs() []Verb {
+	sorted := append([]Verb(nil), verbs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// ValidVerb reports whether v is a known verb, the wildcard of a known kind or All
+func ValidVerb(v Verb) bool {
+	if v == All {
+		return true
+	}
+	for _, known := range verbs {
+		if v == known || v == known.Kind()+":*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Expand returns the known verbs matched by v, which may be a wildcard
+func Expand(v Verb) []Verb {
+	var matched []Verb
+	for _, known := range verbs {
+		if v == All || v == known || v == known.Kind()+":*" {
+			matched = append(matched, known)
+		}
+	}
+	return matched
+}
+
+// builtinRoles are the bindings of the built-in roles. Organization administrators manage their
+// organization, users manage their own VMs and members have read-only access.
+var builtinRoles = map[string][]Binding{
+	models.RoleSystemAdmin: {
+		{Scope: ScopeGlobal, Verbs: []Verb{All}},
+	},
+	models.RoleOrgAdmin: {
+		{Scope: ScopeOrganization, Verbs: []Verb{
+			VMList, VMGet, VMCreate, VMPower, VMConsole, VMDelete,
+			VDCList, VDCGet, VDCCreate, VDCUpdate, VDCDelete,
+			OrgGet, OrgUsage,
+			UserList,
+			CatalogView, CatalogManage,
+			RoleList, RoleManage,
+			MembershipManage,
+			InvitationList, InvitationManage,
+			AuditList,
+			DashboardView, EventList, AlertList, OpenShiftView, OpenShiftManage, ChangeWatch,
+		}},
+	},
+	models.RoleOrgUser: {
+		{Scope: ScopeOrganization, Verbs: []Verb{
+			VDCList, VDCGet,
+			OrgGet,
+			CatalogView,
+			DashboardView, EventList, AlertList, OpenShiftView, OpenShiftManage, ChangeWatch,
+		}},
+		{Scope: ScopeOwn, Verbs: []Verb{VMList, VMGet, VMCreate, VMPower, VMConsole, VMDelete}},
+	},
+	models.RoleOrgMember: {
+		{Scope: ScopeOrganization, Verbs: []Verb{
+			VDCList, VDCGet,
+			OrgGet,
+			CatalogView,
+			DashboardView, EventList, AlertList, OpenShiftView, ChangeWatch,
+		}},
+	},
+}
+
+// IsBuiltinRole reports whether role is one of the built-in roles, which custom roles cannot shadow
+func IsBuiltinRole(role string) bool {
+	_, ok := builtinRoles[role]
+	return ok
+}
@@ -0,0 +1,176 @@
+// Package backup exports the records of a storage into a portable snapshot and imports
+// snapshots into another storage, for backups and for cloning one environment into another.
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+	"github.com/eliorerz/ovim-updated/pkg/version"
+)
+
+// FormatVersion is the version of the snapshot format written by Export. It changes whenever
+// a snapshot written by an older binary could no longer be imported as is.
+const FormatVersion = 1
+
+// ErrUnsupportedFormat is returned when importing a snapshot of another format version
+var ErrUnsupportedFormat = errors.New("unsupported snapshot format")
+
+// Snapshot holds every record of a storage. Records of each kind are ordered oldest first, which
+// is also the order they are imported in. Deleted organizations, VDCs and VMs are included with
+// their DeletedAt set.
+type Snapshot struct {
+	FormatVersion  int                                 `json:"format_version"`
+	ExportedAt     time.Time                           `json:"exported_at"`
+	ServerVersion  string                              `json:"server_version,omitempty"`
+	Users          []*User                             `json:"users"`
+	Organizations  []*models.Organization              `json:"organizations"`
+	Memberships    []*models.OrgMembership             `json:"memberships"`
+	Roles          []*models.OrgRole                   `json:"roles"`
+	VDCs           []*models.VirtualDataCenter         `json:"vdcs"`
+	Catalogs       []*models.Catalog                   `json:"catalogs"`
+	Templates      []*models.Template                  `json:"templates"`
+	VMs            []*models.VirtualMachine            `json:"vms"`
+	CatalogSources []*models.OrganizationCatalogSource `json:"catalog_sources"`
+}
+
+// User is a user as stored in a snapshot. Unlike models.User it serializes the password hash,
+// the password history and the second factor, so that users can still log in after an import
+// and cannot reuse a previous password.
+type User struct {
+	models.User
+	PasswordHash     string            `json:"password_hash"`
+	PasswordHistory  models.JSONBArray `json:"password_history,omitempty"`
+	MFASecret        string            `json:"mfa_secret,omitempty"`
+	MFARecoveryCodes models.JSONBArray `json:"mfa_recovery_codes,omitempty"`
+	MFALastCounter   int64             `json:"mfa_last_counter,omitempty"`
+}
+
+// Export reads every record of s into a snapshot. It does not lock the storage, records written
+// while it runs may or may not be included.
+func Export(s storage.Storage) (*Snapshot, error) {
+	snapshot := &Snapshot{
+		FormatVersion: FormatVersion,
+		ExportedAt:    time.Now().UTC(),
+		ServerVersion: version.Get().String(),
+	}
+
+	users, err := s.ListUsers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	snapshot.Users = make([]*User, 0, len(users))
+	for _, user := range users {
+		snapshot.Users = append(snapshot.Users, &User{
+			User:             *user,
+			PasswordHash:     user.PasswordHash,
+			PasswordHistory:  user.PasswordHistory,
+			MFASecret:        user.MFASecret,
+			MFARecoveryCodes: user.MFARecoveryCodes,
+			MFALastCounter:   user.MFALastCounter,
+		})
+	}
+
+	orgs, err := s.ListOrganizations()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list organizations: %w", err)
+	}
+	deletedOrgs, err := s.ListDeletedOrganizations()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deleted organizations: %w", err)
+	}
+	snapshot.Organizations = merge(orgs, deletedOrgs, func(o *models.Organization) (time.Time, string) { return o.CreatedAt, o.ID })
+	for _, org := range snapshot.Organizations {
+		org.VirtualDataCenters = nil
+		org.Catalogs = nil
+	}
+
+	snapshot.Memberships, err = s.ListOrgMemberships("", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list organization memberships: %w", err)
+	}
+
+	vdcs, err := s.ListVDCs("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VDCs: %w", err)
+	}
+	deletedVDCs, err := s.ListDeletedVDCs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deleted VDCs: %w", err)
+	}
+	snapshot.VDCs = merge(vdcs, deletedVDCs, func(v *models.VirtualDataCenter) (time.Time, string) { return v.CreatedAt, v.ID })
+	for _, vdc := range snapshot.VDCs {
+		vdc.Organization = nil
+		vdc.VirtualMachines = nil
+	}
+
+	snapshot.Catalogs, err = s.ListCatalogs("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list catalogs: %w", err)
+	}
+	for _, catalog := range snapshot.Catalogs {
+		catalog.Organization = nil
+		catalog.Templates = nil
+	}
+
+	snapshot.Templates, err = s.ListTemplates()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+
+	vms, err := s.ListVMs("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VMs: %w", err)
+	}
+	deletedVMs, err := s.ListDeletedVMs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deleted VMs: %w", err)
+	}
+	snapshot.VMs = merge(vms, deletedVMs, func(v *models.VirtualMachine) (time.Time, string) { return v.CreatedAt, v.ID })
+
+	// Catalog sources and custom roles can only be listed per organization
+	snapshot.CatalogSources = make([]*models.OrganizationCatalogSource, 0)
+	snapshot.Roles = make([]*models.OrgRole, 0)
+	for _, org := range snapshot.Organizations {
+		sources, err := s.ListOrganizationCatalogSources(org.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list catalog sources of organization %s: %w", org.ID, err)
+		}
+		snapshot.CatalogSources = append(snapshot.CatalogSources, sources...)
+
+		roles, err := s.ListOrgRoles(org.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list roles of organization %s: %w", org.ID, err)
+		}
+		snapshot.Roles = append(snapshot.Roles, roles...)
+	}
+	sortByCreation(snapshot.CatalogSources, func(cs *models.OrganizationCatalogSource) (time.Time, string) { return cs.CreatedAt, cs.ID })
+	sortByCreation(snapshot.Roles, func(r *models.OrgRole) (time.Time, string) { return r.CreatedAt, r.ID })
+
+	return snapshot, nil
+}
+
+// merge returns the live and deleted records of one kind as a single list, oldest first
+func merge[T any](live, deleted []T, key func(T) (time.Time, string)) []T {
+	records := make([]T, 0, len(live)+len(deleted))
+	records = append(records, live...)
+	records = append(records, deleted...)
+	sortByCreation(records, key)
+	return records
+}
+
+// sortByCreation orders records like the storage does, by creation time with the ID breaking ties
+func sortByCreation[T any](records []T, key func(T) (time.Time, string)) {
+	sort.SliceStable(records, func(i, j int) bool {
+		ti, idi := key(records[i])
+		tj, idj := key(records[j])
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return idi < idj
+	})
+}
@@ -0,0 +1,294 @@
+package backup
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// setupSourceStorage returns a storage holding one record of every kind, with a disabled
+// organization, catalog and catalog source, and a deleted organization, VDC and VM
+func setupSourceStorage(t *testing.T) storage.Storage {
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+
+	require.NoError(t, store.CreateOrganization(&models.Organization{ID: "org-1", Name: "Org 1", Namespace: "org-org-1", IsEnabled: true, CRName: "org-1"}))
+	require.NoError(t, store.CreateOrganization(&models.Organization{ID: "org-2", Name: "Org 2", Namespace: "org-org-2", CRName: "org-2"}))
+	require.NoError(t, store.CreateVDC(&models.VirtualDataCenter{ID: "vdc-1", OrgID: "org-1", CRName: "vdc-1", WorkloadNamespace: "vdc-org-1-vdc-1", CPUQuota: 4, PodsQuota: 10, VMsQuota: 5}))
+	require.NoError(t, store.CreateVDC(&models.VirtualDataCenter{ID: "vdc-2", OrgID: "org-2", CRName: "vdc-2", WorkloadNamespace: "vdc-org-2-vdc-2", PodsQuota: 10, VMsQuota: 5}))
+	require.NoError(t, store.CreateCatalog(&models.Catalog{ID: "catalog-1", Name: "Catalog 1", OrgID: "org-1", Type: models.CatalogTypeVMTemplate, SourceBranch: "main", SourcePath: "/", RefreshInterval: "1h", Phase: models.CatalogPhasePending}))
+	require.NoError(t, store.CreateTemplate(&models.Template{ID: "template-1", Name: "Template 1", OrgID: "org-1", CatalogID: stringPtr("catalog-1"), ContentType: "vm-template", Source: "organization", SourceVendor: "Organization", Category: "Operating System"}))
+	require.NoError(t, store.CreateVM(&models.VirtualMachine{ID: "vm-1", Name: "VM 1", OrgID: "org-1", VDCID: stringPtr("vdc-1"), TemplateID: "template-1"}))
+	require.NoError(t, store.CreateVM(&models.VirtualMachine{ID: "vm-2", Name: "VM 2", OrgID: "org-1", VDCID: stringPtr("vdc-1"), TemplateID: "template-1"}))
+	require.NoError(t, store.CreateUser(&models.User{ID: "user-1", Username: "alice", Email: "alice@example.com", PasswordHash: "hash-1", PasswordHistory: models.JSONBArray{"hash-0"}, Role: models.RoleOrgAdmin, OrgID: stringPtr("org-1"), MFAEnabled: true, MFASecret: "secret-1", MFARecoveryCodes: models.JSONBArray{"code-1"}, MFALastCounter: 42}))
+	require.NoError(t, store.CreateOrganizationCatalogSource(&models.OrganizationCatalogSource{ID: "source-1", OrgID: "org-1", SourceType: "redhat-operators"}))
+	require.NoError(t, store.CreateOrgRole(&models.OrgRole{ID: "role-1", OrgID: "org-1", Name: "operator", Verbs: models.JSONBArray{"vm:power"}}))
+	require.NoError(t, store.CreateOrgMembership(&models.OrgMembership{ID: "member-1", UserID: "user-1", OrgID: "org-2", Role: models.RoleOrgUser}))
+
+	require.NoError(t, store.DeleteVM("vm-2"))
+	require.NoError(t, store.DeleteVDC("vdc-2"))
+	require.NoError(t, store.DeleteOrganization("org-2"))
+	return store
+}
+
+// roundTrip exports the snapshot through JSON, as the API and the CLI do
+func roundTrip(t *testing.T, snapshot *Snapshot) *Snapshot {
+	data, err := json.Marshal(snapshot)
+	require.NoError(t, err)
+	var decoded Snapshot
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	return &decoded
+}
+
+func TestExport(t *testing.T) {
+	snapshot, err := Export(setupSourceStorage(t))
+	require.NoError(t, err)
+
+	assert.Equal(t, FormatVersion, snapshot.FormatVersion)
+	assert.False(t, snapshot.ExportedAt.IsZero())
+	require.Len(t, snapshot.Organizations, 2)
+	assert.Nil(t, snapshot.Organizations[0].DeletedAt)
+	assert.NotNil(t, snapshot.Organizations[1].DeletedAt, "deleted organizations are exported")
+	assert.Len(t, snapshot.VDCs, 2)
+	assert.Len(t, snapshot.Catalogs, 1)
+	assert.Len(t, snapshot.Templates, 1)
+	assert.Len(t, snapshot.VMs, 2)
+	assert.Len(t, snapshot.CatalogSources, 1)
+	assert.Len(t, snapshot.Roles, 1)
+	assert.Len(t, snapshot.Memberships, 1)
+
+	decoded := roundTrip(t, snapshot)
+	require.Len(t, decoded.Users, 1)
+	assert.Equal(t, "alice", decoded.Users[0].Username)
+	assert.Equal(t, "hash-1", decoded.Users[0].PasswordHash, "password hashes survive serialization")
+	assert.Equal(t, models.JSONBArray{"hash-0"}, decoded.Users[0].PasswordHistory, "password histories survive serialization")
+	assert.Equal(t, "secret-1", decoded.Users[0].MFASecret, "second factors survive serialization")
+	assert.Equal(t, models.JSONBArray{"code-1"}, decoded.Users[0].MFARecoveryCodes)
+	assert.Equal(t, int64(42), decoded.Users[0].MFALastCounter, "used codes cannot be replayed after an import")
+}
+
+func TestImport(t *testing.T) {
+	snapshot, err := Export(setupSourceStorage(t))
+	require.NoError(t, err)
+
+	targets := map[string]func() (storage.Storage, error){
+		"memory": func() (storage.Storage, error) { return storage.NewMemoryStorageForTest() },
+		"sqlite": func() (storage.Storage, error) {
+			return storage.NewSQLiteStorageForTest(filepath.Join(t.TempDir(), "ovim.db"))
+		},
+	}
+	for name, newStorage := range targets {
+		t.Run(name, func(t *testing.T) {
+			target, err := newStorage()
+			require.NoError(t, err)
+			defer target.Close()
+
+			result, err := Import(target, roundTrip(t, snapshot), ConflictFail)
+			require.NoError(t, err)
+			assert.Equal(t, Counts{Created: 2}, result.Organizations)
+			assert.Equal(t, Counts{Created: 2}, result.VMs)
+			assert.Equal(t, Counts{Created: 1}, result.Users)
+			assert.Equal(t, Counts{Created: 1}, result.Memberships)
+
+			user, err := target.GetUserByUsername("alice")
+			require.NoError(t, err)
+			assert.Equal(t, "hash-1", user.PasswordHash)
+			assert.Equal(t, models.JSONBArray{"hash-0"}, user.PasswordHistory)
+			assert.Equal(t, "secret-1", user.MFASecret)
+			assert.Equal(t, int64(42), user.MFALastCounter)
+			assert.Equal(t, "org-1", *user.OrgID)
+
+			org, err := target.GetOrganization("org-1")
+			require.NoError(t, err)
+			assert.True(t, org.IsEnabled)
+			_, err = target.GetOrganization("org-2")
+			assert.Equal(t, storage.ErrNotFound, err, "deleted organizations stay deleted")
+			deletedOrgs, err := target.ListDeletedOrganizations()
+			require.NoError(t, err)
+			require.Len(t, deletedOrgs, 1)
+			assert.False(t, deletedOrgs[0].IsEnabled)
+
+			deletedVDCs, err := target.ListDeletedVDCs()
+			require.NoError(t, err)
+			require.Len(t, deletedVDCs, 1)
+			assert.Equal(t, "vdc-2", deletedVDCs[0].ID)
+			deletedVMs, err := target.ListDeletedVMs()
+			require.NoError(t, err)
+			require.Len(t, deletedVMs, 1)
+			assert.Equal(t, "vm-2", deletedVMs[0].ID)
+
+			catalog, err := target.GetCatalog("catalog-1")
+			require.NoError(t, err)
+			assert.False(t, catalog.IsEnabled)
+			template, err := target.GetTemplate("template-1")
+			require.NoError(t, err)
+			require.NotNil(t, template.CatalogID)
+			assert.Equal(t, "catalog-1", *template.CatalogID)
+
+			source, err := target.GetOrganizationCatalogSource("source-1")
+			require.NoError(t, err)
+			assert.False(t, source.Enabled)
+
+			role, err := target.GetOrgRoleByName("org-1", "operator")
+			require.NoError(t, err)
+			assert.Equal(t, models.JSONBArray{"vm:power"}, role.Verbs)
+
+			membership, err := target.GetOrgMembership("user-1", "org-2")
+			require.NoError(t, err)
+			assert.Equal(t, models.RoleOrgUser, membership.Role)
+
+			// The target exports the same records again
+			again, err := Export(target)
+			require.NoError(t, err)
+			assert.Len(t, again.Organizations, len(snapshot.Organizations))
+			assert.Len(t, again.VDCs, len(snapshot.VDCs))
+			assert.Len(t, again.VMs, len(snapshot.VMs))
+			assert.Len(t, again.CatalogSources, len(snapshot.CatalogSources))
+			assert.Len(t, again.Roles, len(snapshot.Roles))
+			assert.Len(t, again.Memberships, len(snapshot.Memberships))
+		})
+	}
+}
+
+func TestImport_Conflicts(t *testing.T) {
+	snapshot, err := Export(setupSourceStorage(t))
+	require.NoError(t, err)
+
+	// setupTarget returns a storage where org-1 and alice already exist with other details
+	setupTarget := func(t *testing.T) storage.Storage {
+		target, err := storage.NewSQLiteStorageForTest(filepath.Join(t.TempDir(), "ovim.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { target.Close() })
+		require.NoError(t, target.CreateOrganization(&models.Organization{ID: "org-1", Name: "Existing", Namespace: "org-existing", CRName: "existing"}))
+		require.NoError(t, target.CreateUser(&models.User{ID: "user-1", Username: "alice", Email: "alice@example.com", PasswordHash: "existing", Role: models.RoleOrgUser}))
+		return target
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		target := setupTarget(t)
+		_, err := Import(target, snapshot, ConflictFail)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, storage.ErrAlreadyExists)
+		assert.Contains(t, err.Error(), "organization org-1")
+
+		// Nothing was imported
+		_, err = target.GetVDC("vdc-1")
+		assert.Equal(t, storage.ErrNotFound, err)
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		target := setupTarget(t)
+		result, err := Import(target, snapshot, ConflictSkip)
+		require.NoError(t, err)
+		assert.Equal(t, Counts{Created: 1, Skipped: 1}, result.Organizations)
+		assert.Equal(t, Counts{Skipped: 1}, result.Users)
+
+		org, err := target.GetOrganization("org-1")
+		require.NoError(t, err)
+		assert.Equal(t, "Existing", org.Name)
+		user, err := target.GetUserByUsername("alice")
+		require.NoError(t, err)
+		assert.Equal(t, "existing", user.PasswordHash)
+		_, err = target.GetVDC("vdc-1")
+		assert.NoError(t, err, "records without conflict are imported")
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		target := setupTarget(t)
+		result, err := Import(target, snapshot, ConflictOverwrite)
+		require.NoError(t, err)
+		assert.Equal(t, Counts{Created: 1, Overwritten: 1}, result.Organizations)
+		assert.Equal(t, Counts{Overwritten: 1}, result.Users)
+
+		org, err := target.GetOrganization("org-1")
+		require.NoError(t, err)
+		assert.Equal(t, "Org 1", org.Name)
+		user, err := target.GetUserByUsername("alice")
+		require.NoError(t, err)
+		assert.Equal(t, "hash-1", user.PasswordHash)
+		assert.Equal(t, models.RoleOrgAdmin, user.Role)
+	})
+}
+
+func TestImport_UserConflicts(t *testing.T) {
+	snapshot, err := Export(setupSourceStorage(t))
+	require.NoError(t, err)
+
+	for _, policy := range []ConflictPolicy{ConflictSkip, ConflictOverwrite} {
+		t.Run(string(policy), func(t *testing.T) {
+			// alice exists under another ID, so user-1 can neither be created nor overwritten
+			target, err := storage.NewSQLiteStorageForTest(filepath.Join(t.TempDir(), "ovim.db"))
+			require.NoError(t, err)
+			t.Cleanup(func() { target.Close() })
+			require.NoError(t, target.CreateUser(&models.User{ID: "user-9", Username: "alice", Email: "alice@other.example.com", PasswordHash: "existing", Role: models.RoleOrgUser}))
+
+			result, err := Import(target, snapshot, policy)
+			require.NoError(t, err)
+			assert.Equal(t, Counts{Skipped: 1}, result.Users)
+			assert.Equal(t, Counts{Skipped: 1}, result.Memberships)
+			assert.Equal(t, []Conflict{
+				{Kind: "user", ID: "user-1", Reason: "username alice belongs to user user-9"},
+				{Kind: "membership", ID: "member-1", Reason: "user user-1 was not imported"},
+			}, result.Conflicts)
+
+			user, err := target.GetUserByUsername("alice")
+			require.NoError(t, err)
+			assert.Equal(t, "user-9", user.ID)
+			assert.Equal(t, "existing", user.PasswordHash)
+			_, err = target.GetUserByID("user-1")
+			assert.Equal(t, storage.ErrNotFound, err)
+			_, err = target.GetVDC("vdc-1")
+			assert.NoError(t, err, "records without conflict are imported")
+		})
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		target, err := storage.NewMemoryStorageForTest()
+		require.NoError(t, err)
+		require.NoError(t, target.CreateUser(&models.User{ID: "user-9", Username: "other", Email: "alice@example.com", Role: models.RoleOrgUser}))
+
+		_, err = Import(target, snapshot, ConflictFail)
+		assert.ErrorIs(t, err, storage.ErrAlreadyExists)
+		assert.Contains(t, err.Error(), "user user-1")
+	})
+}
+
+func TestImport_InvalidSnapshot(t *testing.T) {
+	target, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+
+	_, err = Import(target, &Snapshot{FormatVersion: FormatVersion + 1}, ConflictFail)
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+
+	_, err = Import(target, &Snapshot{FormatVersion: FormatVersion}, "replace")
+	assert.Error(t, err)
+
+	_, err = Import(target, nil, ConflictFail)
+	assert.Equal(t, storage.ErrInvalidInput, err)
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	for name, expected := range map[string]ConflictPolicy{
+		"":          ConflictFail,
+		"fail":      ConflictFail,
+		"skip":      ConflictSkip,
+		"overwrite": ConflictOverwrite,
+	} {
+		policy, err := ParseConflictPolicy(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, policy)
+	}
+
+	_, err := ParseConflictPolicy("merge")
+	assert.Error(t, err)
+}
@@ -0,0 +1,329 @@
+package backup
+
+import (
+	"fmt"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// ConflictPolicy decides what Import does with a record whose ID is already taken in the target
+// storage. A user whose username, email or external identity belongs to a user with another ID
+// fails the import with ConflictFail, and is skipped and reported in Result.Conflicts otherwise.
+type ConflictPolicy string
+
+const (
+	// ConflictFail aborts the import and leaves the storage unchanged
+	ConflictFail ConflictPolicy = "fail"
+	// ConflictSkip keeps the existing record and ignores the one of the snapshot
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite replaces the existing record with the one of the snapshot
+	ConflictOverwrite ConflictPolicy = "overwrite"
+)
+
+// ParseConflictPolicy parses a conflict policy name, an empty name means ConflictFail
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(name); policy {
+	case "":
+		return ConflictFail, nil
+	case ConflictFail, ConflictSkip, ConflictOverwrite:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy %q, expected fail, skip or overwrite", name)
+	}
+}
+
+// Counts tells how many records of one kind an import created, overwrote and skipped
+type Counts struct {
+	Created     int `json:"created"`
+	Overwritten int `json:"overwritten"`
+	Skipped     int `json:"skipped"`
+}
+
+// Conflict is a snapshot record left out of an import because one of its unique fields, other
+// than the ID, belongs to another record of the target storage. No conflict policy can apply to
+// it: overwriting would replace a different record.
+type Conflict struct {
+	Kind   string `json:"kind"`
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// Result summarizes an import per kind of record
+type Result struct {
+	Users          Counts `json:"users"`
+	Organizations  Counts `json:"organizations"`
+	Memberships    Counts `json:"memberships"`
+	Roles          Counts `json:"roles"`
+	VDCs           Counts `json:"vdcs"`
+	Catalogs       Counts `json:"catalogs"`
+	Templates      Counts `json:"templates"`
+	VMs            Counts `json:"vms"`
+	CatalogSources Counts `json:"catalog_sources"`
+	// Conflicts lists the records skipped because of a conflict on a field other than the ID.
+	// They are counted as skipped as well.
+	Conflicts []Conflict `json:"conflicts,omitempty"`
+}
+
+// Import writes every record of the snapshot into s in a single transaction, so a failed import
+// leaves s unchanged. Parents are imported before the records referencing them.
+//
+// The target storage assigns creation times and resource versions, records keep their relative
+// order because they are created oldest first. Records deleted in the snapshot are imported and
+// then deleted again, which starts their trash retention over.
+func Import(s storage.Storage, snapshot *Snapshot, policy ConflictPolicy) (*Result, error) {
+	if snapshot == nil {
+		return nil, storage.ErrInvalidInput
+	}
+	if snapshot.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("%w: version %d, this server reads version %d", ErrUnsupportedFormat, snapshot.FormatVersion, FormatVersion)
+	}
+	if _, err := ParseConflictPolicy(string(policy)); err != nil {
+		return nil, err
+	}
+
+	var result *Result
+	err := s.WithTx(func(tx storage.Storage) error {
+		result = &Result{}
+		return importSnapshot(tx, snapshot, policy, result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func importSnapshot(tx storage.Storage, snapshot *Snapshot, policy ConflictPolicy, result *Result) error {
+	orgs, err := importRecords(tx, "organization", snapshot.Organizations, policy, &result.Organizations,
+		func(o *models.Organization) string { return o.ID },
+		func(o *models.Organization) *models.Organization {
+			org := *o
+			org.DeletedAt, org.ResourceVersion = nil, 0
+			org.VirtualDataCenters, org.Catalogs = nil, nil
+			return &org
+		},
+		storage.Storage.CreateOrganization, storage.Storage.UpdateOrganization)
+	if err != nil {
+		return err
+	}
+
+	_, err = importRecords(tx, "role", snapshot.Roles, policy, &result.Roles,
+		func(r *models.OrgRole) string { return r.ID },
+		func(r *models.OrgRole) *models.OrgRole {
+			role := *r
+			return &role
+		},
+		storage.Storage.CreateOrgRole, storage.Storage.UpdateOrgRole)
+	if err != nil {
+		return err
+	}
+
+	vdcs, err := importRecords(tx, "VDC", snapshot.VDCs, policy, &result.VDCs,
+		func(v *models.VirtualDataCenter) string { return v.ID },
+		func(v *models.VirtualDataCenter) *models.VirtualDataCenter {
+			vdc := *v
+			vdc.DeletedAt, vdc.ResourceVersion = nil, 0
+			vdc.Organization, vdc.VirtualMachines = nil, nil
+			return &vdc
+		},
+		storage.Storage.CreateVDC, storage.Storage.UpdateVDC)
+	if err != nil {
+		return err
+	}
+
+	_, err = importRecords(tx, "catalog", snapshot.Catalogs, policy, &result.Catalogs,
+		func(c *models.Catalog) string { return c.ID },
+		func(c *models.Catalog) *models.Catalog {
+			catalog := *c
+			catalog.ResourceVersion = 0
+			catalog.Organization, catalog.Templates = nil, nil
+			return &catalog
+		},
+		storage.Storage.CreateCatalog, storage.Storage.UpdateCatalog)
+	if err != nil {
+		return err
+	}
+
+	_, err = importRecords(tx, "template", snapshot.Templates, policy, &result.Templates,
+		func(t *models.Template) string { return t.ID },
+		func(t *models.Template) *models.Template {
+			template := *t
+			template.ResourceVersion = 0
+			return &template
+		},
+		storage.Storage.CreateTemplate, storage.Storage.UpdateTemplate)
+	if err != nil {
+		return err
+	}
+
+	vms, err := importRecords(tx, "VM", snapshot.VMs, policy, &result.VMs,
+		func(v *models.VirtualMachine) string { return v.ID },
+		func(v *models.VirtualMachine) *models.VirtualMachine {
+			vm := *v
+			vm.DeletedAt, vm.ResourceVersion = nil, 0
+			return &vm
+		},
+		storage.Storage.CreateVM, storage.Storage.UpdateVM)
+	if err != nil {
+		return err
+	}
+
+	users, memberships := snapshot.Users, snapshot.Memberships
+	if policy != ConflictFail {
+		if users, memberships, err = withoutTakenUsers(tx, users, memberships, result); err != nil {
+			return err
+		}
+	}
+
+	_, err = importRecords(tx, "user", users, policy, &result.Users,
+		func(u *User) string { return u.ID },
+		func(u *User) *models.User {
+			user := u.User
+			user.PasswordHash, user.ResourceVersion = u.PasswordHash, 0
+			user.PasswordHistory = u.PasswordHistory
+			user.MFASecret, user.MFARecoveryCodes, user.MFALastCounter = u.MFASecret, u.MFARecoveryCodes, u.MFALastCounter
+			return &user
+		},
+		storage.Storage.CreateUser, storage.Storage.UpdateUser)
+	if err != nil {
+		return err
+	}
+
+	_, err = importRecords(tx, "membership", memberships, policy, &result.Memberships,
+		func(m *models.OrgMembership) string { return m.ID },
+		func(m *models.OrgMembership) *models.OrgMembership {
+			membership := *m
+			return &membership
+		},
+		storage.Storage.CreateOrgMembership, storage.Storage.UpdateOrgMembership)
+	if err != nil {
+		return err
+	}
+
+	_, err = importRecords(tx, "catalog source", snapshot.CatalogSources, policy, &result.CatalogSources,
+		func(cs *models.OrganizationCatalogSource) string { return cs.ID },
+		func(cs *models.OrganizationCatalogSource) *models.OrganizationCatalogSource {
+			source := *cs
+			return &source
+		},
+		storage.Storage.CreateOrganizationCatalogSource, storage.Storage.UpdateOrganizationCatalogSource)
+	if err != nil {
+		return err
+	}
+
+	// Children go to the trash before their parents, as they would have been deleted
+	for _, vm := range vms {
+		if vm.DeletedAt != nil {
+			if err := tx.DeleteVM(vm.ID); err != nil {
+				return fmt.Errorf("failed to delete imported VM %s: %w", vm.ID, err)
+			}
+		}
+	}
+	for _, vdc := range vdcs {
+		if vdc.DeletedAt != nil {
+			if err := tx.DeleteVDC(vdc.ID); err != nil {
+				return fmt.Errorf("failed to delete imported VDC %s: %w", vdc.ID, err)
+			}
+		}
+	}
+	for _, org := range orgs {
+		if org.DeletedAt != nil {
+			if err := tx.DeleteOrganization(org.ID); err != nil {
+				return fmt.Errorf("failed to delete imported organization %s: %w", org.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// importRecords writes the records of one kind and returns the snapshot records that were
+// written, skipped ones excluded. Create and update are given a fresh copy of each record made by
+// writable, since the storage sets fields of the records it writes even when it fails.
+//
+// Unless conflicts fail the import, every create runs in a nested transaction: a failed statement
+// aborts a PostgreSQL transaction, the nested one rolls back to a savepoint instead.
+func importRecords[T, W any](tx storage.Storage, kind string, records []*T, policy ConflictPolicy, counts *Counts,
+	id func(*T) string, writable func(*T) *W, create, update func(storage.Storage, *W) error) ([]*T, error) {
+	written := make([]*T, 0, len(records))
+	for _, record := range records {
+		var err error
+		if policy == ConflictFail {
+			err = create(tx, writable(record))
+		} else {
+			err = tx.WithTx(func(inner storage.Storage) error {
+				return create(inner, writable(record))
+			})
+		}
+
+		switch {
+		case err == nil:
+			counts.Created++
+		case err == storage.ErrAlreadyExists && policy == ConflictSkip:
+			counts.Skipped++
+			continue
+		case err == storage.ErrAlreadyExists && policy == ConflictOverwrite:
+			if err := update(tx, writable(record)); err != nil {
+				return nil, fmt.Errorf("failed to overwrite %s %s: %w", kind, id(record), err)
+			}
+			counts.Overwritten++
+		default:
+			return nil, fmt.Errorf("failed to import %s %s: %w", kind, id(record), err)
+		}
+		written = append(written, record)
+	}
+	return written, nil
+}
+
+// withoutTakenUsers leaves out the snapshot users whose username, email or external identity
+// belongs to a stored user with another ID, along with their memberships, and reports them in
+// result. Creating them fails whatever the policy, and the memberships would reference a user
+// that was never imported.
+func withoutTakenUsers(tx storage.Storage, users []*User, memberships []*models.OrgMembership,
+	result *Result) ([]*User, []*models.OrgMembership, error) {
+	stored, err := tx.ListUsers()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	byUsername := make(map[string]string, len(stored))
+	byEmail := make(map[string]string, len(stored))
+	byIdentity := make(map[string]string, len(stored))
+	for _, user := range stored {
+		byUsername[user.Username] = user.ID
+		byEmail[user.Email] = user.ID
+		if user.ExternalID != "" {
+			byIdentity[user.AuthSource+"/"+user.ExternalID] = user.ID
+		}
+	}
+
+	kept := make([]*User, 0, len(users))
+	left := make(map[string]bool)
+	for _, user := range users {
+		var reason string
+		if owner, ok := byUsername[user.Username]; ok && owner != user.ID {
+			reason = fmt.Sprintf("username %s belongs to user %s", user.Username, owner)
+		} else if owner, ok := byEmail[user.Email]; ok && owner != user.ID {
+			reason = fmt.Sprintf("email %s belongs to user %s", user.Email, owner)
+		} else if owner, ok := byIdentity[user.AuthSource+"/"+user.ExternalID]; ok && user.ExternalID != "" && owner != user.ID {
+			reason = fmt.Sprintf("%s subject %s belongs to user %s", user.AuthSource, user.ExternalID, owner)
+		}
+		if reason == "" {
+			kept = append(kept, user)
+			continue
+		}
+		left[user.ID] = true
+		result.Users.Skipped++
+		result.Conflicts = append(result.Conflicts, Conflict{Kind: "user", ID: user.ID, Reason: reason})
+	}
+
+	keptMemberships := make([]*models.OrgMembership, 0, len(memberships))
+	for _, membership := range memberships {
+		if !left[membership.UserID] {
+			keptMemberships = append(keptMemberships, membership)
+			continue
+		}
+		result.Memberships.Skipped++
+		result.Conflicts = append(result.Conflicts, Conflict{Kind: "membership", ID: membership.ID,
+			Reason: fmt.Sprintf("user %s was not imported", membership.UserID)})
+	}
+	return kept, keptMemberships, nil
+}
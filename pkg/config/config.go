@@ -1,9 +1,11 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,6 +20,46 @@ const (
 	DefaultReadTimeout     = 10 * time.Second
 	DefaultWriteTimeout    = 10 * time.Second
 	DefaultIdleTimeout     = 60 * time.Second
+	DefaultTrashRetention  = 30 * 24 * time.Hour
+	DefaultPurgeInterval   = time.Hour
+
+	// Access tokens are short-lived, sessions are renewed with refresh tokens
+	DefaultTokenDuration        = 15 * time.Minute
+	DefaultRefreshTokenDuration = 7 * 24 * time.Hour
+
+	// Failed password logins are delayed progressively and lock the account out after a while
+	DefaultLoginMaxAttempts      = 5
+	DefaultLoginMaxAttemptsPerIP = 50
+	DefaultLoginLockoutDuration  = 15 * time.Minute
+	DefaultLoginAttemptWindow    = 15 * time.Minute
+	DefaultLoginDelay            = time.Second
+	DefaultLoginMaxDelay         = 30 * time.Second
+
+	// Passwords need no character classes by default and never expire
+	DefaultPasswordMinLength          = 8
+	DefaultPasswordHistory            = 5
+	DefaultPasswordResetTokenDuration = 24 * time.Hour
+
+	// DefaultMFAIssuer names OVIM in authenticator apps
+	DefaultMFAIssuer = "OVIM"
+
+	// Generated signing keys use ES256, and every replica reloads the keys each minute
+	DefaultJWTKeyAlgorithm      = "ES256"
+	DefaultJWTKeyReloadInterval = time.Minute
+
+	// DefaultServiceAccountCacheTTL is how long the review of a ServiceAccount token is reused
+	DefaultServiceAccountCacheTTL = time.Minute
+
+	// DefaultImpersonationTokenDuration is how long administrators can impersonate a user at once
+	DefaultImpersonationTokenDuration = 15 * time.Minute
+
+	// DefaultInvitationTokenDuration is how long an invitation can be accepted
+	DefaultInvitationTokenDuration = 72 * time.Hour
+
+	// Notifications are logged unless an SMTP server is configured, which is reached on the
+	// submission port
+	DefaultNotifierType = NotifierLog
+	DefaultSMTPPort     = 587
 
 	// Environment variable names
 	EnvPort                = "OVIM_PORT"
@@ -32,21 +74,106 @@ const (
 	EnvKubevirtEnabled     = "OVIM_KUBEVIRT_ENABLED"
 	EnvKubevirtNamespace   = "OVIM_KUBEVIRT_NAMESPACE"
 	EnvJWTSecret           = "OVIM_JWT_SECRET"
+	EnvTokenDuration       = "OVIM_TOKEN_DURATION"
+	EnvRefreshDuration     = "OVIM_REFRESH_TOKEN_DURATION"
 	EnvEnvironment         = "OVIM_ENVIRONMENT"
 	EnvLogLevel            = "OVIM_LOG_LEVEL"
 
+	// Login lockout Environment variables
+	EnvLoginMaxAttempts      = "OVIM_LOGIN_MAX_ATTEMPTS"
+	EnvLoginMaxAttemptsPerIP = "OVIM_LOGIN_MAX_ATTEMPTS_PER_IP"
+	EnvLoginLockoutDuration  = "OVIM_LOGIN_LOCKOUT_DURATION"
+	EnvLoginAttemptWindow    = "OVIM_LOGIN_ATTEMPT_WINDOW"
+	EnvLoginDelay            = "OVIM_LOGIN_DELAY"
+	EnvLoginMaxDelay         = "OVIM_LOGIN_MAX_DELAY"
+
+	// Password policy Environment variables
+	EnvPasswordMinLength          = "OVIM_PASSWORD_MIN_LENGTH"
+	EnvPasswordRequireUppercase   = "OVIM_PASSWORD_REQUIRE_UPPERCASE"
+	EnvPasswordRequireLowercase   = "OVIM_PASSWORD_REQUIRE_LOWERCASE"
+	EnvPasswordRequireDigit       = "OVIM_PASSWORD_REQUIRE_DIGIT"
+	EnvPasswordRequireSymbol      = "OVIM_PASSWORD_REQUIRE_SYMBOL"
+	EnvPasswordHistory            = "OVIM_PASSWORD_HISTORY"
+	EnvPasswordMaxAge             = "OVIM_PASSWORD_MAX_AGE"
+	EnvPasswordResetTokenDuration = "OVIM_PASSWORD_RESET_TOKEN_DURATION"
+
+	// JWT signing key Environment variables
+	EnvJWTKeysDir           = "OVIM_JWT_KEYS_DIR"
+	EnvJWTKeysSecret        = "OVIM_JWT_KEYS_SECRET"
+	EnvJWTKeyAlgorithm      = "OVIM_JWT_KEY_ALGORITHM"
+	EnvJWTKeyRotation       = "OVIM_JWT_KEY_ROTATION"
+	EnvJWTKeyReloadInterval = "OVIM_JWT_KEY_RELOAD_INTERVAL"
+
+	// Kubernetes ServiceAccount Environment variables
+	EnvServiceAccountBindings  = "OVIM_SERVICE_ACCOUNT_BINDINGS"
+	EnvServiceAccountAudiences = "OVIM_SERVICE_ACCOUNT_AUDIENCES"
+	EnvServiceAccountCacheTTL  = "OVIM_SERVICE_ACCOUNT_CACHE_TTL"
+
+	// Impersonation Environment variables
+	EnvImpersonationEnabled           = "OVIM_IMPERSONATION_ENABLED"
+	EnvImpersonationTokenDuration     = "OVIM_IMPERSONATION_TOKEN_DURATION"
+	EnvImpersonationReadOnly          = "OVIM_IMPERSONATION_READ_ONLY"
+	EnvImpersonationBlockedOperations = "OVIM_IMPERSONATION_BLOCKED_OPERATIONS"
+
+	// Invitation Environment variables
+	EnvInvitationTokenDuration = "OVIM_INVITATION_TOKEN_DURATION"
+	EnvInvitationAcceptURL     = "OVIM_INVITATION_ACCEPT_URL"
+
+	// SCIM Environment variables
+	EnvSCIMToken = "OVIM_SCIM_TOKEN"
+
+	// Notifier Environment variables
+	EnvNotifier     = "OVIM_NOTIFIER"
+	EnvSMTPHost     = "OVIM_SMTP_HOST"
+	EnvSMTPPort     = "OVIM_SMTP_PORT"
+	EnvSMTPUsername = "OVIM_SMTP_USERNAME"
+	EnvSMTPPassword = "OVIM_SMTP_PASSWORD"
+	EnvSMTPFrom     = "OVIM_SMTP_FROM"
+
+	// MFA Environment variables
+	EnvMFAIssuer        = "OVIM_MFA_ISSUER"
+	EnvMFARequiredRoles = "OVIM_MFA_REQUIRED_ROLES"
+
 	// OIDC Environment variables
 	EnvOIDCEnabled      = "OVIM_OIDC_ENABLED"
 	EnvOIDCIssuerURL    = "OVIM_OIDC_ISSUER_URL"
 	EnvOIDCClientID     = "OVIM_OIDC_CLIENT_ID"
 	EnvOIDCClientSecret = "OVIM_OIDC_CLIENT_SECRET"
 	EnvOIDCRedirectURL  = "OVIM_OIDC_REDIRECT_URL"
+	EnvOIDCRoleMappings = "OVIM_OIDC_ROLE_MAPPINGS"
+	EnvOIDCDefaultRole  = "OVIM_OIDC_DEFAULT_ROLE"
+
+	// LDAP Environment variables
+	EnvLDAPEnabled            = "OVIM_LDAP_ENABLED"
+	EnvLDAPURL                = "OVIM_LDAP_URL"
+	EnvLDAPStartTLS           = "OVIM_LDAP_START_TLS"
+	EnvLDAPCAFile             = "OVIM_LDAP_CA_FILE"
+	EnvLDAPInsecureSkipVerify = "OVIM_LDAP_INSECURE_SKIP_VERIFY"
+	EnvLDAPBindDN             = "OVIM_LDAP_BIND_DN"
+	EnvLDAPBindPassword       = "OVIM_LDAP_BIND_PASSWORD"
+	EnvLDAPUserBaseDN         = "OVIM_LDAP_USER_BASE_DN"
+	EnvLDAPUserFilter         = "OVIM_LDAP_USER_FILTER"
+	EnvLDAPUsernameAttribute  = "OVIM_LDAP_USERNAME_ATTRIBUTE"
+	EnvLDAPEmailAttribute     = "OVIM_LDAP_EMAIL_ATTRIBUTE"
+	EnvLDAPGroupBaseDN        = "OVIM_LDAP_GROUP_BASE_DN"
+	EnvLDAPGroupFilter        = "OVIM_LDAP_GROUP_FILTER"
+	EnvLDAPGroupNameAttribute = "OVIM_LDAP_GROUP_NAME_ATTRIBUTE"
+	EnvLDAPGroupMappings      = "OVIM_LDAP_GROUP_MAPPINGS"
+	EnvLDAPDefaultRole        = "OVIM_LDAP_DEFAULT_ROLE"
+	EnvLDAPTimeout            = "OVIM_LDAP_TIMEOUT"
 
 	// OpenShift Environment variables
 	EnvOpenShiftEnabled           = "OVIM_OPENSHIFT_ENABLED"
 	EnvOpenShiftConfig            = "OVIM_OPENSHIFT_KUBECONFIG"
 	EnvOpenShiftInCluster         = "OVIM_OPENSHIFT_IN_CLUSTER"
 	EnvOpenShiftTemplateNamespace = "OVIM_OPENSHIFT_TEMPLATE_NAMESPACE"
+
+	// Trash Environment variables
+	EnvTrashRetention     = "OVIM_TRASH_RETENTION"
+	EnvTrashPurgeInterval = "OVIM_TRASH_PURGE_INTERVAL"
+
+	// Audit Environment variables
+	EnvAuditLogFile = "OVIM_AUDIT_LOG_FILE"
 )
 
 // Config holds all configuration for the OVIM backend
@@ -57,6 +184,9 @@ type Config struct {
 	OpenShift  OpenShiftConfig  `yaml:"openshift"`
 	Auth       AuthConfig       `yaml:"auth"`
 	Logging    LoggingConfig    `yaml:"logging"`
+	Trash      TrashConfig      `yaml:"trash"`
+	Notifier   NotifierConfig   `yaml:"notifier"`
+	Audit      AuditConfig      `yaml:"audit"`
 }
 
 // ServerConfig holds HTTP server configuration
@@ -110,9 +240,177 @@ type OpenShiftConfig struct {
 
 // AuthConfig holds authentication configuration
 type AuthConfig struct {
-	JWTSecret     string        `yaml:"jwtSecret"`
+	// JWTSecret signs tokens with HS256 unless signing keys are configured
+	JWTSecret string        `yaml:"jwtSecret"`
+	JWTKeys   JWTKeysConfig `yaml:"jwtKeys"`
+	// TokenDuration is the lifetime of access tokens
 	TokenDuration time.Duration `yaml:"tokenDuration"`
-	OIDC          OIDCConfig    `yaml:"oidc"`
+	// RefreshTokenDuration is how long after login a session can be renewed
+	RefreshTokenDuration time.Duration         `yaml:"refreshTokenDuration"`
+	OIDC                 OIDCConfig            `yaml:"oidc"`
+	LDAP                 LDAPConfig            `yaml:"ldap"`
+	Lockout              LockoutConfig         `yaml:"lockout"`
+	Password             PasswordConfig        `yaml:"password"`
+	MFA                  MFAConfig             `yaml:"mfa"`
+	ServiceAccounts      ServiceAccountsConfig `yaml:"serviceAccounts"`
+	Impersonation        ImpersonationConfig   `yaml:"impersonation"`
+	Invitation           InvitationConfig      `yaml:"invitation"`
+	SCIM                 SCIMConfig            `yaml:"scim"`
+}
+
+// MinSCIMTokenLength is the length a SCIM bearer token needs at least
+const MinSCIMTokenLength = 32
+
+// SCIMConfig holds the SCIM 2.0 service identity providers provision users and groups with
+type SCIMConfig struct {
+	// Token is the bearer token identity providers authenticate with. SCIM is disabled
+	// without one.
+	Token string `yaml:"token"`
+}
+
+// InvitationConfig holds how organization administrators invite users
+type InvitationConfig struct {
+	// TokenDuration is how long an invitation can be accepted before it has to be resent, the
+	// default if zero
+	TokenDuration time.Duration `yaml:"tokenDuration"`
+	// AcceptURL is the page of the UI accepting invitations, sent with the token appended as
+	// the token query parameter. Without it the token itself is sent.
+	AcceptURL string `yaml:"acceptURL"`
+}
+
+// Notifier types
+const (
+	// NotifierLog writes notifications to the server log, for development and air-gapped setups
+	NotifierLog = "log"
+	// NotifierSMTP emails notifications through an SMTP server
+	NotifierSMTP = "smtp"
+)
+
+// NotifierConfig holds how notifications such as invitations reach users
+type NotifierConfig struct {
+	// Type is NotifierLog, the default if empty, or NotifierSMTP
+	Type string     `yaml:"type"`
+	SMTP SMTPConfig `yaml:"smtp"`
+}
+
+// SMTPConfig holds the SMTP server emailing notifications. Connections are upgraded with
+// STARTTLS when the server offers it, and credentials are only sent over TLS or to localhost.
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// From is the sender address of the emails
+	From string `yaml:"from"`
+}
+
+// DefaultImpersonationBlockedOperations are the operations administrators cannot perform while
+// impersonating a user unless configured otherwise: deleting anything and moving data in or out
+var DefaultImpersonationBlockedOperations = []string{
+	"DELETE /api/v1/organizations/:id",
+	"DELETE /api/v1/vdcs/:id",
+	"DELETE /api/v1/vms/:id",
+	"DELETE /api/v1/users/:id",
+	"DELETE /api/v1/openshift/vms/:id",
+	"GET /api/v1/admin/export",
+	"POST /api/v1/admin/import",
+}
+
+// ImpersonationConfig holds how system administrators may impersonate users. Credentials of the
+// user can never be changed while impersonating, on top of the blocked operations.
+type ImpersonationConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// TokenDuration is the lifetime of impersonation tokens, capped by the access token duration
+	TokenDuration time.Duration `yaml:"tokenDuration"`
+	// ReadOnly rejects every impersonated request but GET
+	ReadOnly bool `yaml:"readOnly"`
+	// BlockedOperations are rejected while impersonating, as "METHOD route" with "*" for any method
+	BlockedOperations []string `yaml:"blockedOperations"`
+}
+
+// ServiceAccountsConfig holds the Kubernetes ServiceAccounts whose tokens authenticate to OVIM.
+// Tokens are validated with the TokenReview API, which needs Kubernetes integration.
+type ServiceAccountsConfig struct {
+	// Bindings map ServiceAccounts to OVIM, the first matching binding applies
+	Bindings []ServiceAccountBinding `yaml:"bindings"`
+	// Audiences the tokens must be issued for, the API server if empty
+	Audiences []string `yaml:"audiences"`
+	// CacheTTL is how long the review of a token is reused, zero reviews every request
+	CacheTTL time.Duration `yaml:"cacheTTL"`
+}
+
+// ServiceAccountBinding maps the ServiceAccount of a namespace, or all of them with "*", either
+// to the existing OVIM user User or to Role in the organization OrgID
+type ServiceAccountBinding struct {
+	Namespace      string `yaml:"namespace" json:"namespace"`
+	ServiceAccount string `yaml:"serviceAccount" json:"service_account"`
+	User           string `yaml:"user" json:"user"`
+	Role           string `yaml:"role" json:"role"`
+	OrgID          string `yaml:"orgId" json:"org_id"`
+}
+
+// JWTKeysConfig holds the asymmetric keys signing tokens, read from the <kid>.pem files of
+// Dir or the <kid>.pem entries of the Secret named namespace/name. The newest key signs and
+// every key verifies.
+type JWTKeysConfig struct {
+	Dir    string `yaml:"dir"`
+	Secret string `yaml:"secret"`
+	// Algorithm of the keys generated by rotation, RS256 or ES256
+	Algorithm string `yaml:"algorithm"`
+	// Rotation is how often a new key is generated into the Secret, zero never rotates keys
+	Rotation       time.Duration `yaml:"rotation"`
+	ReloadInterval time.Duration `yaml:"reloadInterval"`
+}
+
+// Enabled reports whether tokens are signed with keys rather than the JWT secret
+func (k JWTKeysConfig) Enabled() bool {
+	return k.Dir != "" || k.Secret != ""
+}
+
+// SecretName returns the namespace and name of the Secret holding the keys
+func (k JWTKeysConfig) SecretName() (namespace, name string, ok bool) {
+	namespace, name, ok = strings.Cut(k.Secret, "/")
+	return namespace, name, ok && namespace != "" && name != "" && !strings.Contains(name, "/")
+}
+
+// MFAConfig holds the settings of TOTP multi-factor authentication for password logins
+type MFAConfig struct {
+	// Issuer names the accounts in authenticator apps
+	Issuer string `yaml:"issuer"`
+	// RequiredRoles are the roles whose users must enroll a second factor, on top of the users
+	// of organizations requiring it
+	RequiredRoles []string `yaml:"requiredRoles"`
+}
+
+// PasswordConfig holds the policy passwords chosen by users must satisfy, and the lifetime of the
+// one-time tokens administrators issue to reset them
+type PasswordConfig struct {
+	MinLength        int  `yaml:"minLength"`
+	RequireUppercase bool `yaml:"requireUppercase"`
+	RequireLowercase bool `yaml:"requireLowercase"`
+	RequireDigit     bool `yaml:"requireDigit"`
+	RequireSymbol    bool `yaml:"requireSymbol"`
+	// History is how many recent passwords, the current one included, cannot be chosen again
+	History int `yaml:"history"`
+	// MaxAge is how long a password can be used before it has to be changed, zero for ever
+	MaxAge             time.Duration `yaml:"maxAge"`
+	ResetTokenDuration time.Duration `yaml:"resetTokenDuration"`
+}
+
+// LockoutConfig holds the protection of password logins against guessing. Failed logins are
+// counted per account and per client address; after each one the next attempt has to wait Delay,
+// doubled by every further failure up to MaxDelay, and reaching a maximum locks logins out for
+// Duration.
+type LockoutConfig struct {
+	// MaxAttempts is how many failed logins lock an account out, zero never locks accounts
+	MaxAttempts int `yaml:"maxAttempts"`
+	// MaxAttemptsPerIP is how many failed logins lock a client address out, zero never locks addresses
+	MaxAttemptsPerIP int           `yaml:"maxAttemptsPerIp"`
+	Duration         time.Duration `yaml:"duration"`
+	// Window is how long a failed login counts
+	Window   time.Duration `yaml:"window"`
+	Delay    time.Duration `yaml:"delay"`
+	MaxDelay time.Duration `yaml:"maxDelay"`
 }
 
 // OIDCConfig holds OpenID Connect configuration
@@ -123,6 +421,57 @@ type OIDCConfig struct {
 	ClientSecret string   `yaml:"clientSecret"`
 	RedirectURL  string   `yaml:"redirectUrl"`
 	Scopes       []string `yaml:"scopes"`
+	// RoleMappings are applied in order on every OIDC login
+	RoleMappings []OIDCMappingRule `yaml:"roleMappings"`
+	// DefaultRole is given to users no mapping rule gives a role, org_user if empty
+	DefaultRole string `yaml:"defaultRole"`
+}
+
+// OIDCMappingRule gives the users whose claim matches a value a role, an organization or both.
+// Match is "exact" (the default) or "regex".
+type OIDCMappingRule struct {
+	Claim string `yaml:"claim" json:"claim"`
+	Match string `yaml:"match" json:"match"`
+	Value string `yaml:"value" json:"value"`
+	Role  string `yaml:"role" json:"role"`
+	OrgID string `yaml:"orgId" json:"org_id"`
+}
+
+// LDAPConfig holds the LDAP or Active Directory server users can log in with. Empty settings
+// get defaults suiting OpenLDAP.
+type LDAPConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	URL                string `yaml:"url"`
+	StartTLS           bool   `yaml:"startTLS"`
+	CAFile             string `yaml:"caFile"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+	// BindDN and BindPassword authenticate the searches, which are anonymous without a BindDN
+	BindDN       string `yaml:"bindDN"`
+	BindPassword string `yaml:"bindPassword"`
+	UserBaseDN   string `yaml:"userBaseDN"`
+	// UserFilter finds the entry of a user, %s is replaced by the username
+	UserFilter        string `yaml:"userFilter"`
+	UsernameAttribute string `yaml:"usernameAttribute"`
+	EmailAttribute    string `yaml:"emailAttribute"`
+	// GroupBaseDN is where groups are searched, the memberOf attribute of users is read if empty
+	GroupBaseDN string `yaml:"groupBaseDN"`
+	// GroupFilter finds the groups of a user, %s is replaced by the DN of the user
+	GroupFilter        string `yaml:"groupFilter"`
+	GroupNameAttribute string `yaml:"groupNameAttribute"`
+	// GroupMappings are applied in order on every LDAP login
+	GroupMappings []LDAPGroupMapping `yaml:"groupMappings"`
+	// DefaultRole is given to users no mapping gives a role, org_user if empty
+	DefaultRole string        `yaml:"defaultRole"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+// LDAPGroupMapping gives the members of groups whose name or DN matches Group a role, an
+// organization or both. Match is "exact" (the default) or "regex".
+type LDAPGroupMapping struct {
+	Group string `yaml:"group" json:"group"`
+	Match string `yaml:"match" json:"match"`
+	Role  string `yaml:"role" json:"role"`
+	OrgID string `yaml:"orgId" json:"org_id"`
 }
 
 // LoggingConfig holds logging configuration
@@ -131,6 +480,19 @@ type LoggingConfig struct {
 	Format string `yaml:"format"`
 }
 
+// TrashConfig holds the retention of deleted organizations, VDCs and VMs
+type TrashConfig struct {
+	// Retention is how long deleted resources stay restorable, zero keeps them forever
+	Retention     time.Duration `yaml:"retention"`
+	PurgeInterval time.Duration `yaml:"purgeInterval"`
+}
+
+// AuditConfig holds where the audit records of mutating API calls go besides the database
+type AuditConfig struct {
+	// LogFile is a file every record is appended to as a line of JSON, for SIEM agents to ship
+	LogFile string `yaml:"logFile"`
+}
+
 // Load loads configuration from environment variables and config file
 func Load(configPath string) (*Config, error) {
 	cfg := &Config{
@@ -170,8 +532,55 @@ func Load(configPath string) (*Config, error) {
 			TemplateNamespace: getEnvString(EnvOpenShiftTemplateNamespace, "openshift"),
 		},
 		Auth: AuthConfig{
-			JWTSecret:     getEnvString(EnvJWTSecret, DefaultJWTSecret),
-			TokenDuration: 24 * time.Hour,
+			JWTSecret: getEnvString(EnvJWTSecret, DefaultJWTSecret),
+			JWTKeys: JWTKeysConfig{
+				Dir:            getEnvString(EnvJWTKeysDir, ""),
+				Secret:         getEnvString(EnvJWTKeysSecret, ""),
+				Algorithm:      getEnvString(EnvJWTKeyAlgorithm, DefaultJWTKeyAlgorithm),
+				Rotation:       getEnvDuration(EnvJWTKeyRotation, 0),
+				ReloadInterval: getEnvDuration(EnvJWTKeyReloadInterval, DefaultJWTKeyReloadInterval),
+			},
+			TokenDuration:        getEnvDuration(EnvTokenDuration, DefaultTokenDuration),
+			RefreshTokenDuration: getEnvDuration(EnvRefreshDuration, DefaultRefreshTokenDuration),
+			Lockout: LockoutConfig{
+				MaxAttempts:      getEnvInt(EnvLoginMaxAttempts, DefaultLoginMaxAttempts),
+				MaxAttemptsPerIP: getEnvInt(EnvLoginMaxAttemptsPerIP, DefaultLoginMaxAttemptsPerIP),
+				Duration:         getEnvDuration(EnvLoginLockoutDuration, DefaultLoginLockoutDuration),
+				Window:           getEnvDuration(EnvLoginAttemptWindow, DefaultLoginAttemptWindow),
+				Delay:            getEnvDuration(EnvLoginDelay, DefaultLoginDelay),
+				MaxDelay:         getEnvDuration(EnvLoginMaxDelay, DefaultLoginMaxDelay),
+			},
+			Password: PasswordConfig{
+				MinLength:          getEnvInt(EnvPasswordMinLength, DefaultPasswordMinLength),
+				RequireUppercase:   getEnvBool(EnvPasswordRequireUppercase, false),
+				RequireLowercase:   getEnvBool(EnvPasswordRequireLowercase, false),
+				RequireDigit:       getEnvBool(EnvPasswordRequireDigit, false),
+				RequireSymbol:      getEnvBool(EnvPasswordRequireSymbol, false),
+				History:            getEnvInt(EnvPasswordHistory, DefaultPasswordHistory),
+				MaxAge:             getEnvDuration(EnvPasswordMaxAge, 0),
+				ResetTokenDuration: getEnvDuration(EnvPasswordResetTokenDuration, DefaultPasswordResetTokenDuration),
+			},
+			MFA: MFAConfig{
+				Issuer:        getEnvString(EnvMFAIssuer, DefaultMFAIssuer),
+				RequiredRoles: getEnvList(EnvMFARequiredRoles),
+			},
+			ServiceAccounts: ServiceAccountsConfig{
+				Audiences: getEnvList(EnvServiceAccountAudiences),
+				CacheTTL:  getEnvDuration(EnvServiceAccountCacheTTL, DefaultServiceAccountCacheTTL),
+			},
+			Impersonation: ImpersonationConfig{
+				Enabled:           getEnvBool(EnvImpersonationEnabled, true),
+				TokenDuration:     getEnvDuration(EnvImpersonationTokenDuration, DefaultImpersonationTokenDuration),
+				ReadOnly:          getEnvBool(EnvImpersonationReadOnly, false),
+				BlockedOperations: DefaultImpersonationBlockedOperations,
+			},
+			Invitation: InvitationConfig{
+				TokenDuration: getEnvDuration(EnvInvitationTokenDuration, DefaultInvitationTokenDuration),
+				AcceptURL:     getEnvString(EnvInvitationAcceptURL, ""),
+			},
+			SCIM: SCIMConfig{
+				Token: getEnvString(EnvSCIMToken, ""),
+			},
 			OIDC: OIDCConfig{
 				Enabled:      getEnvBool(EnvOIDCEnabled, false),
 				IssuerURL:    getEnvString(EnvOIDCIssuerURL, ""),
@@ -179,12 +588,73 @@ func Load(configPath string) (*Config, error) {
 				ClientSecret: getEnvString(EnvOIDCClientSecret, ""),
 				RedirectURL:  getEnvString(EnvOIDCRedirectURL, ""),
 				Scopes:       []string{"openid", "profile", "email"},
+				DefaultRole:  getEnvString(EnvOIDCDefaultRole, ""),
+			},
+			LDAP: LDAPConfig{
+				Enabled:            getEnvBool(EnvLDAPEnabled, false),
+				URL:                getEnvString(EnvLDAPURL, ""),
+				StartTLS:           getEnvBool(EnvLDAPStartTLS, false),
+				CAFile:             getEnvString(EnvLDAPCAFile, ""),
+				InsecureSkipVerify: getEnvBool(EnvLDAPInsecureSkipVerify, false),
+				BindDN:             getEnvString(EnvLDAPBindDN, ""),
+				BindPassword:       getEnvString(EnvLDAPBindPassword, ""),
+				UserBaseDN:         getEnvString(EnvLDAPUserBaseDN, ""),
+				UserFilter:         getEnvString(EnvLDAPUserFilter, ""),
+				UsernameAttribute:  getEnvString(EnvLDAPUsernameAttribute, ""),
+				EmailAttribute:     getEnvString(EnvLDAPEmailAttribute, ""),
+				GroupBaseDN:        getEnvString(EnvLDAPGroupBaseDN, ""),
+				GroupFilter:        getEnvString(EnvLDAPGroupFilter, ""),
+				GroupNameAttribute: getEnvString(EnvLDAPGroupNameAttribute, ""),
+				DefaultRole:        getEnvString(EnvLDAPDefaultRole, ""),
+				Timeout:            getEnvDuration(EnvLDAPTimeout, 0),
 			},
 		},
 		Logging: LoggingConfig{
 			Level:  getEnvString(EnvLogLevel, "info"),
 			Format: "json",
 		},
+		Trash: TrashConfig{
+			Retention:     getEnvDuration(EnvTrashRetention, DefaultTrashRetention),
+			PurgeInterval: getEnvDuration(EnvTrashPurgeInterval, DefaultPurgeInterval),
+		},
+		Notifier: NotifierConfig{
+			Type: getEnvString(EnvNotifier, DefaultNotifierType),
+			SMTP: SMTPConfig{
+				Host:     getEnvString(EnvSMTPHost, ""),
+				Port:     getEnvInt(EnvSMTPPort, DefaultSMTPPort),
+				Username: getEnvString(EnvSMTPUsername, ""),
+				Password: getEnvString(EnvSMTPPassword, ""),
+				From:     getEnvString(EnvSMTPFrom, ""),
+			},
+		},
+		Audit: AuditConfig{
+			LogFile: getEnvString(EnvAuditLogFile, ""),
+		},
+	}
+
+	// Mapping rules are a JSON list, a typo must not silently drop them
+	if value := os.Getenv(EnvOIDCRoleMappings); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.Auth.OIDC.RoleMappings); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", EnvOIDCRoleMappings, err)
+		}
+	}
+
+	if value := os.Getenv(EnvLDAPGroupMappings); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.Auth.LDAP.GroupMappings); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", EnvLDAPGroupMappings, err)
+		}
+	}
+	// Operations are separated by commas, the value "none" blocks none but the credentials
+	if value := os.Getenv(EnvImpersonationBlockedOperations); value != "" {
+		cfg.Auth.Impersonation.BlockedOperations = nil
+		if value != "none" {
+			cfg.Auth.Impersonation.BlockedOperations = getEnvList(EnvImpersonationBlockedOperations)
+		}
+	}
+	if value := os.Getenv(EnvServiceAccountBindings); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.Auth.ServiceAccounts.Bindings); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", EnvServiceAccountBindings, err)
+		}
 	}
 
 	// Load from config file if provided
@@ -206,11 +676,141 @@ func (c *Config) validate() error {
 	if c.Server.Port == "" {
 		return fmt.Errorf("server port cannot be empty")
 	}
-	if c.Auth.JWTSecret == "" {
-		return fmt.Errorf("JWT secret cannot be empty")
+	if c.Auth.JWTKeys.Enabled() {
+		if err := c.Auth.JWTKeys.validate(); err != nil {
+			return err
+		}
+	} else {
+		if c.Auth.JWTSecret == "" {
+			return fmt.Errorf("JWT secret cannot be empty")
+		}
+		if c.Auth.JWTSecret == DefaultJWTSecret && c.Server.Environment == "production" {
+			return fmt.Errorf("default JWT secret cannot be used in production")
+		}
+	}
+	if c.Auth.TokenDuration < 0 || c.Auth.RefreshTokenDuration < 0 {
+		return fmt.Errorf("token durations cannot be negative")
+	}
+	if c.Auth.RefreshTokenDuration > 0 && c.Auth.RefreshTokenDuration < c.Auth.TokenDuration {
+		return fmt.Errorf("refresh token duration cannot be shorter than the access token duration")
+	}
+	if err := c.Auth.Lockout.validate(); err != nil {
+		return err
+	}
+	if err := c.Auth.Password.validate(); err != nil {
+		return err
+	}
+	if err := c.Auth.Impersonation.validate(); err != nil {
+		return err
+	}
+	if c.Auth.Invitation.TokenDuration < 0 {
+		return fmt.Errorf("invitation token duration cannot be negative")
+	}
+	if c.Auth.SCIM.Token != "" && len(c.Auth.SCIM.Token) < MinSCIMTokenLength {
+		return fmt.Errorf("SCIM token must be at least %d characters", MinSCIMTokenLength)
+	}
+	if err := c.Notifier.validate(); err != nil {
+		return err
+	}
+	if c.Auth.ServiceAccounts.CacheTTL < 0 {
+		return fmt.Errorf("service account cache TTL cannot be negative")
+	}
+	if c.Trash.Retention < 0 {
+		return fmt.Errorf("trash retention cannot be negative")
+	}
+	if c.Trash.Retention > 0 && c.Trash.PurgeInterval <= 0 {
+		return fmt.Errorf("trash purge interval must be positive")
+	}
+	return nil
+}
+
+// validate ensures the signing keys have a single source, and that only the keys of a Secret,
+// which the server can write, are rotated
+func (k JWTKeysConfig) validate() error {
+	if k.Dir != "" && k.Secret != "" {
+		return fmt.Errorf("JWT signing keys cannot be read from both a directory and a secret")
+	}
+	if _, _, ok := k.SecretName(); k.Secret != "" && !ok {
+		return fmt.Errorf("JWT signing key secret must be given as namespace/name")
+	}
+	if k.Algorithm != "RS256" && k.Algorithm != "ES256" {
+		return fmt.Errorf("JWT signing key algorithm must be RS256 or ES256")
+	}
+	if k.ReloadInterval <= 0 {
+		return fmt.Errorf("JWT signing key reload interval must be positive")
 	}
-	if c.Auth.JWTSecret == DefaultJWTSecret && c.Server.Environment == "production" {
-		return fmt.Errorf("default JWT secret cannot be used in production")
+	if k.Rotation < 0 {
+		return fmt.Errorf("JWT signing key rotation cannot be negative")
+	}
+	if k.Rotation > 0 && k.Secret == "" {
+		return fmt.Errorf("only JWT signing keys of a secret can be rotated")
+	}
+	if k.Rotation > 0 && k.Rotation <= k.ReloadInterval {
+		return fmt.Errorf("JWT signing key rotation must be longer than the reload interval")
+	}
+	return nil
+}
+
+// validate ensures the lockout settings are usable
+func (l LockoutConfig) validate() error {
+	if l.MaxAttempts < 0 || l.MaxAttemptsPerIP < 0 {
+		return fmt.Errorf("login attempt limits cannot be negative")
+	}
+	if l.Delay < 0 || l.MaxDelay < 0 {
+		return fmt.Errorf("login delays cannot be negative")
+	}
+	if (l.MaxAttempts > 0 || l.MaxAttemptsPerIP > 0 || l.Delay > 0) && l.Window <= 0 {
+		return fmt.Errorf("login attempt window must be positive")
+	}
+	if (l.MaxAttempts > 0 || l.MaxAttemptsPerIP > 0) && l.Duration <= 0 {
+		return fmt.Errorf("login lockout duration must be positive")
+	}
+	return nil
+}
+
+// validate ensures impersonation tokens expire and that blocked operations name a method and a route
+func (i ImpersonationConfig) validate() error {
+	if i.Enabled && i.TokenDuration <= 0 {
+		return fmt.Errorf("impersonation token duration must be positive")
+	}
+	for _, operation := range i.BlockedOperations {
+		method, route, ok := strings.Cut(operation, " ")
+		if !ok || method == "" || !strings.HasPrefix(route, "/") {
+			return fmt.Errorf("blocked impersonation operation %q must be given as METHOD /route", operation)
+		}
+	}
+	return nil
+}
+
+// validate ensures notifications can be delivered
+func (n NotifierConfig) validate() error {
+	switch n.Type {
+	case "", NotifierLog:
+		return nil
+	case NotifierSMTP:
+		if n.SMTP.Host == "" || n.SMTP.From == "" {
+			return fmt.Errorf("SMTP notifier needs a host and a sender address")
+		}
+		if n.SMTP.Port <= 0 || n.SMTP.Port > 65535 {
+			return fmt.Errorf("SMTP port must be between 1 and 65535")
+		}
+		return nil
+	default:
+		return fmt.Errorf("notifier must be %s or %s", NotifierLog, NotifierSMTP)
+	}
+}
+
+// validate ensures the password policy can be satisfied. A zero minimum length keeps the
+// minimum of 8 characters that every password needs.
+func (p PasswordConfig) validate() error {
+	if p.MinLength != 0 && (p.MinLength < DefaultPasswordMinLength || p.MinLength > 128) {
+		return fmt.Errorf("password minimum length must be between %d and 128", DefaultPasswordMinLength)
+	}
+	if p.History < 0 {
+		return fmt.Errorf("password history cannot be negative")
+	}
+	if p.MaxAge < 0 || p.ResetTokenDuration < 0 {
+		return fmt.Errorf("password durations cannot be negative")
 	}
 	return nil
 }
@@ -249,3 +849,24 @@ func getEnvBool(key string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+// getEnvList gets a comma separated list environment variable, empty entries are dropped
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvDuration gets a duration environment variable with a default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if durationValue, err := time.ParseDuration(value); err == nil {
+			return durationValue
+		}
+	}
+	return defaultValue
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -52,17 +53,47 @@ func TestLoad(t *testing.T) {
 
 		// Test Auth defaults
 		assert.Equal(t, DefaultJWTSecret, cfg.Auth.JWTSecret)
-		assert.Equal(t, 24*time.Hour, cfg.Auth.TokenDuration)
+		assert.Equal(t, DefaultTokenDuration, cfg.Auth.TokenDuration)
+		assert.Equal(t, DefaultRefreshTokenDuration, cfg.Auth.RefreshTokenDuration)
 		assert.False(t, cfg.Auth.OIDC.Enabled)
 		assert.Empty(t, cfg.Auth.OIDC.IssuerURL)
 		assert.Empty(t, cfg.Auth.OIDC.ClientID)
 		assert.Empty(t, cfg.Auth.OIDC.ClientSecret)
 		assert.Empty(t, cfg.Auth.OIDC.RedirectURL)
 		assert.Equal(t, []string{"openid", "profile", "email"}, cfg.Auth.OIDC.Scopes)
+		assert.Equal(t, LockoutConfig{
+			MaxAttempts:      DefaultLoginMaxAttempts,
+			MaxAttemptsPerIP: DefaultLoginMaxAttemptsPerIP,
+			Duration:         DefaultLoginLockoutDuration,
+			Window:           DefaultLoginAttemptWindow,
+			Delay:            DefaultLoginDelay,
+			MaxDelay:         DefaultLoginMaxDelay,
+		}, cfg.Auth.Lockout)
+		assert.Equal(t, PasswordConfig{
+			MinLength:          DefaultPasswordMinLength,
+			History:            DefaultPasswordHistory,
+			ResetTokenDuration: DefaultPasswordResetTokenDuration,
+		}, cfg.Auth.Password)
+		assert.Equal(t, MFAConfig{Issuer: DefaultMFAIssuer}, cfg.Auth.MFA)
+		assert.Equal(t, JWTKeysConfig{Algorithm: DefaultJWTKeyAlgorithm, ReloadInterval: DefaultJWTKeyReloadInterval}, cfg.Auth.JWTKeys)
+		assert.False(t, cfg.Auth.JWTKeys.Enabled())
+		assert.Equal(t, ServiceAccountsConfig{CacheTTL: DefaultServiceAccountCacheTTL}, cfg.Auth.ServiceAccounts)
+		assert.Equal(t, LDAPConfig{}, cfg.Auth.LDAP)
+		assert.Equal(t, ImpersonationConfig{
+			Enabled:           true,
+			TokenDuration:     DefaultImpersonationTokenDuration,
+			BlockedOperations: DefaultImpersonationBlockedOperations,
+		}, cfg.Auth.Impersonation)
+		assert.Equal(t, InvitationConfig{TokenDuration: DefaultInvitationTokenDuration}, cfg.Auth.Invitation)
+		assert.Equal(t, NotifierConfig{Type: NotifierLog, SMTP: SMTPConfig{Port: DefaultSMTPPort}}, cfg.Notifier)
 
 		// Test Logging defaults
 		assert.Equal(t, "info", cfg.Logging.Level)
 		assert.Equal(t, "json", cfg.Logging.Format)
+
+		// Test Trash defaults
+		assert.Equal(t, DefaultTrashRetention, cfg.Trash.Retention)
+		assert.Equal(t, DefaultPurgeInterval, cfg.Trash.PurgeInterval)
 	})
 
 	t.Run("EnvironmentVariables", func(t *testing.T) {
@@ -84,6 +115,8 @@ func TestLoad(t *testing.T) {
 		os.Setenv(EnvJWTSecret, "custom-jwt-secret")
 		os.Setenv(EnvEnvironment, "production")
 		os.Setenv(EnvLogLevel, "debug")
+		os.Setenv(EnvTrashRetention, "168h")
+		os.Setenv(EnvTrashPurgeInterval, "15m")
 		os.Setenv(EnvOIDCEnabled, "true")
 		os.Setenv(EnvOIDCIssuerURL, "https://auth.example.com")
 		os.Setenv(EnvOIDCClientID, "test-client")
@@ -136,6 +169,10 @@ func TestLoad(t *testing.T) {
 
 		// Test Logging config
 		assert.Equal(t, "debug", cfg.Logging.Level)
+
+		// Test Trash config
+		assert.Equal(t, 7*24*time.Hour, cfg.Trash.Retention)
+		assert.Equal(t, 15*time.Minute, cfg.Trash.PurgeInterval)
 	})
 
 	t.Run("ConfigFileLoading", func(t *testing.T) {
@@ -224,6 +261,343 @@ func TestConfigValidation(t *testing.T) {
 		err := cfg.validate()
 		assert.NoError(t, err)
 	})
+
+	t.Run("TrashRetentionWithoutPurgeInterval", func(t *testing.T) {
+		cfg := &Config{
+			Server: ServerConfig{
+				Port: "8080",
+			},
+			Auth: AuthConfig{
+				JWTSecret: "valid-secret",
+			},
+			Trash: TrashConfig{
+				Retention: time.Hour,
+			},
+		}
+
+		err := cfg.validate()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "trash purge interval must be positive")
+	})
+}
+
+func TestConfigValidation_TokenDurations(t *testing.T) {
+	newConfig := func(token, refresh time.Duration) *Config {
+		return &Config{
+			Server: ServerConfig{Port: "8080"},
+			Auth:   AuthConfig{JWTSecret: "valid-secret", TokenDuration: token, RefreshTokenDuration: refresh},
+		}
+	}
+
+	assert.NoError(t, newConfig(15*time.Minute, 24*time.Hour).validate())
+	assert.NoError(t, newConfig(0, 0).validate(), "zero selects the defaults")
+
+	err := newConfig(-time.Minute, 24*time.Hour).validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "token durations cannot be negative")
+
+	err = newConfig(time.Hour, time.Minute).validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "refresh token duration cannot be shorter than the access token duration")
+}
+
+func TestConfigValidation_Lockout(t *testing.T) {
+	newConfig := func(lockout LockoutConfig) *Config {
+		return &Config{
+			Server: ServerConfig{Port: "8080"},
+			Auth:   AuthConfig{JWTSecret: "valid-secret", Lockout: lockout},
+		}
+	}
+
+	assert.NoError(t, newConfig(LockoutConfig{}).validate(), "zero disables the protection")
+	assert.NoError(t, newConfig(LockoutConfig{MaxAttempts: 5, Duration: time.Minute, Window: time.Minute}).validate())
+
+	tests := []struct {
+		lockout LockoutConfig
+		message string
+	}{
+		{LockoutConfig{MaxAttempts: -1}, "login attempt limits cannot be negative"},
+		{LockoutConfig{Delay: -time.Second}, "login delays cannot be negative"},
+		{LockoutConfig{Delay: time.Second}, "login attempt window must be positive"},
+		{LockoutConfig{MaxAttemptsPerIP: 10, Window: time.Minute}, "login lockout duration must be positive"},
+	}
+	for _, tt := range tests {
+		err := newConfig(tt.lockout).validate()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), tt.message)
+	}
+}
+
+func TestConfigValidation_Password(t *testing.T) {
+	newConfig := func(password PasswordConfig) *Config {
+		return &Config{
+			Server: ServerConfig{Port: "8080"},
+			Auth:   AuthConfig{JWTSecret: "valid-secret", Password: password},
+		}
+	}
+
+	assert.NoError(t, newConfig(PasswordConfig{}).validate())
+	assert.NoError(t, newConfig(PasswordConfig{MinLength: 12, RequireDigit: true, History: 3, MaxAge: 90 * 24 * time.Hour}).validate())
+
+	tests := []struct {
+		password PasswordConfig
+		message  string
+	}{
+		{PasswordConfig{MinLength: 6}, "password minimum length must be between 8 and 128"},
+		{PasswordConfig{MinLength: 200}, "password minimum length must be between 8 and 128"},
+		{PasswordConfig{History: -1}, "password history cannot be negative"},
+		{PasswordConfig{MaxAge: -time.Hour}, "password durations cannot be negative"},
+	}
+	for _, tt := range tests {
+		err := newConfig(tt.password).validate()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), tt.message)
+	}
+}
+
+func TestConfigValidation_JWTKeys(t *testing.T) {
+	newConfig := func(keys JWTKeysConfig) *Config {
+		return &Config{
+			Server: ServerConfig{Port: "8080", Environment: "production"},
+			Auth:   AuthConfig{JWTSecret: DefaultJWTSecret, JWTKeys: keys},
+		}
+	}
+	valid := JWTKeysConfig{Secret: "ovim-system/ovim-jwt-keys", Algorithm: "ES256", ReloadInterval: time.Minute}
+
+	assert.NoError(t, newConfig(valid).validate(), "the JWT secret is not used with signing keys")
+	rotated := valid
+	rotated.Rotation = 24 * time.Hour
+	assert.NoError(t, newConfig(rotated).validate())
+	namespace, name, ok := valid.SecretName()
+	assert.True(t, ok)
+	assert.Equal(t, "ovim-system", namespace)
+	assert.Equal(t, "ovim-jwt-keys", name)
+
+	tests := []struct {
+		keys    JWTKeysConfig
+		message string
+	}{
+		{JWTKeysConfig{Dir: "/keys", Secret: "ns/name", Algorithm: "ES256", ReloadInterval: time.Minute}, "cannot be read from both a directory and a secret"},
+		{JWTKeysConfig{Secret: "name", Algorithm: "ES256", ReloadInterval: time.Minute}, "must be given as namespace/name"},
+		{JWTKeysConfig{Dir: "/keys", Algorithm: "HS256", ReloadInterval: time.Minute}, "algorithm must be RS256 or ES256"},
+		{JWTKeysConfig{Dir: "/keys", Algorithm: "RS256"}, "reload interval must be positive"},
+		{JWTKeysConfig{Dir: "/keys", Algorithm: "RS256", ReloadInterval: time.Minute, Rotation: time.Hour}, "only JWT signing keys of a secret can be rotated"},
+		{JWTKeysConfig{Secret: "ns/name", Algorithm: "RS256", ReloadInterval: time.Minute, Rotation: time.Second}, "rotation must be longer than the reload interval"},
+	}
+	for _, tt := range tests {
+		err := newConfig(tt.keys).validate()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), tt.message)
+	}
+}
+
+func TestLoad_OIDCRoleMappings(t *testing.T) {
+	clearEnvVars()
+	defer clearEnvVars()
+
+	os.Setenv(EnvOIDCRoleMappings, `[{"claim":"groups","match":"regex","value":"team-(.+)","role":"org_user","org_id":"$1"}]`)
+	os.Setenv(EnvOIDCDefaultRole, "org_member")
+
+	cfg, err := Load("")
+	require.NoError(t, err)
+	assert.Equal(t, []OIDCMappingRule{
+		{Claim: "groups", Match: "regex", Value: "team-(.+)", Role: "org_user", OrgID: "$1"},
+	}, cfg.Auth.OIDC.RoleMappings)
+	assert.Equal(t, "org_member", cfg.Auth.OIDC.DefaultRole)
+
+	os.Setenv(EnvOIDCRoleMappings, `{"claim":"groups"}`)
+	_, err = Load("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), EnvOIDCRoleMappings)
+}
+
+func TestLoad_LDAP(t *testing.T) {
+	clearEnvVars()
+	defer clearEnvVars()
+
+	os.Setenv(EnvLDAPEnabled, "true")
+	os.Setenv(EnvLDAPURL, "ldaps://ad.example.com")
+	os.Setenv(EnvLDAPBindDN, "cn=ovim,ou=services,dc=example,dc=com")
+	os.Setenv(EnvLDAPBindPassword, "secret")
+	os.Setenv(EnvLDAPUserBaseDN, "ou=people,dc=example,dc=com")
+	os.Setenv(EnvLDAPUserFilter, "(sAMAccountName=%s)")
+	os.Setenv(EnvLDAPUsernameAttribute, "sAMAccountName")
+	os.Setenv(EnvLDAPGroupMappings, `[{"group":"ovim-admins","role":"system_admin"},{"group":"team-(.+)","match":"regex","org_id":"$1"}]`)
+	os.Setenv(EnvLDAPDefaultRole, "org_member")
+	os.Setenv(EnvLDAPTimeout, "5s")
+
+	cfg, err := Load("")
+	require.NoError(t, err)
+	assert.Equal(t, LDAPConfig{
+		Enabled:           true,
+		URL:               "ldaps://ad.example.com",
+		BindDN:            "cn=ovim,ou=services,dc=example,dc=com",
+		BindPassword:      "secret",
+		UserBaseDN:        "ou=people,dc=example,dc=com",
+		UserFilter:        "(sAMAccountName=%s)",
+		UsernameAttribute: "sAMAccountName",
+		GroupMappings: []LDAPGroupMapping{
+			{Group: "ovim-admins", Role: "system_admin"},
+			{Group: "team-(.+)", Match: "regex", OrgID: "$1"},
+		},
+		DefaultRole: "org_member",
+		Timeout:     5 * time.Second,
+	}, cfg.Auth.LDAP)
+
+	os.Setenv(EnvLDAPGroupMappings, `{"group":"ovim-admins"}`)
+	_, err = Load("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), EnvLDAPGroupMappings)
+}
+
+func TestLoad_ServiceAccounts(t *testing.T) {
+	clearEnvVars()
+	defer clearEnvVars()
+
+	os.Setenv(EnvServiceAccountBindings, `[{"namespace":"ci","service_account":"deployer","user":"deploy-bot"},{"namespace":"acme","service_account":"*","role":"org_user","org_id":"acme"}]`)
+	os.Setenv(EnvServiceAccountAudiences, "ovim")
+	os.Setenv(EnvServiceAccountCacheTTL, "30s")
+
+	cfg, err := Load("")
+	require.NoError(t, err)
+	assert.Equal(t, ServiceAccountsConfig{
+		Bindings: []ServiceAccountBinding{
+			{Namespace: "ci", ServiceAccount: "deployer", User: "deploy-bot"},
+			{Namespace: "acme", ServiceAccount: "*", Role: "org_user", OrgID: "acme"},
+		},
+		Audiences: []string{"ovim"},
+		CacheTTL:  30 * time.Second,
+	}, cfg.Auth.ServiceAccounts)
+
+	os.Setenv(EnvServiceAccountCacheTTL, "-1s")
+	_, err = Load("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cache TTL cannot be negative")
+
+	os.Setenv(EnvServiceAccountBindings, `{"namespace":"ci"}`)
+	_, err = Load("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), EnvServiceAccountBindings)
+}
+
+func TestLoad_MFA(t *testing.T) {
+	clearEnvVars()
+	defer clearEnvVars()
+
+	os.Setenv(EnvMFAIssuer, "OVIM Production")
+	os.Setenv(EnvMFARequiredRoles, "system_admin, org_admin,,")
+
+	cfg, err := Load("")
+	require.NoError(t, err)
+	assert.Equal(t, MFAConfig{Issuer: "OVIM Production", RequiredRoles: []string{"system_admin", "org_admin"}}, cfg.Auth.MFA)
+}
+
+func TestLoad_Impersonation(t *testing.T) {
+	clearEnvVars()
+	defer clearEnvVars()
+
+	os.Setenv(EnvImpersonationTokenDuration, "5m")
+	os.Setenv(EnvImpersonationReadOnly, "true")
+	os.Setenv(EnvImpersonationBlockedOperations, "DELETE /api/v1/vms/:id, * /api/v1/vdcs/:id")
+
+	cfg, err := Load("")
+	require.NoError(t, err)
+	assert.Equal(t, ImpersonationConfig{
+		Enabled:           true,
+		TokenDuration:     5 * time.Minute,
+		ReadOnly:          true,
+		BlockedOperations: []string{"DELETE /api/v1/vms/:id", "* /api/v1/vdcs/:id"},
+	}, cfg.Auth.Impersonation)
+
+	os.Setenv(EnvImpersonationBlockedOperations, "none")
+	cfg, err = Load("")
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Auth.Impersonation.BlockedOperations)
+
+	os.Setenv(EnvImpersonationBlockedOperations, "/api/v1/vms")
+	_, err = Load("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "METHOD /route")
+
+	os.Setenv(EnvImpersonationBlockedOperations, "none")
+	os.Setenv(EnvImpersonationTokenDuration, "0s")
+	_, err = Load("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "impersonation token duration must be positive")
+}
+
+func TestLoad_Invitation(t *testing.T) {
+	clearEnvVars()
+	defer clearEnvVars()
+
+	os.Setenv(EnvInvitationTokenDuration, "24h")
+	os.Setenv(EnvInvitationAcceptURL, "https://ovim.example.com/invitations/accept")
+	os.Setenv(EnvNotifier, NotifierSMTP)
+	os.Setenv(EnvSMTPHost, "smtp.example.com")
+	os.Setenv(EnvSMTPUsername, "ovim")
+	os.Setenv(EnvSMTPPassword, "secret")
+	os.Setenv(EnvSMTPFrom, "ovim@example.com")
+
+	cfg, err := Load("")
+	require.NoError(t, err)
+	assert.Equal(t, InvitationConfig{
+		TokenDuration: 24 * time.Hour,
+		AcceptURL:     "https://ovim.example.com/invitations/accept",
+	}, cfg.Auth.Invitation)
+	assert.Equal(t, NotifierConfig{
+		Type: NotifierSMTP,
+		SMTP: SMTPConfig{Host: "smtp.example.com", Port: DefaultSMTPPort, Username: "ovim", Password: "secret", From: "ovim@example.com"},
+	}, cfg.Notifier)
+
+	os.Setenv(EnvSMTPFrom, "")
+	_, err = Load("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SMTP notifier needs a host and a sender address")
+
+	os.Setenv(EnvNotifier, "pigeon")
+	_, err = Load("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "notifier must be log or smtp")
+
+	os.Setenv(EnvNotifier, NotifierLog)
+	os.Setenv(EnvInvitationTokenDuration, "-1h")
+	_, err = Load("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invitation token duration cannot be negative")
+}
+
+func TestLoad_SCIM(t *testing.T) {
+	clearEnvVars()
+	defer clearEnvVars()
+
+	cfg, err := Load("")
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Auth.SCIM.Token)
+
+	token := strings.Repeat("s", MinSCIMTokenLength)
+	os.Setenv(EnvSCIMToken, token)
+	cfg, err = Load("")
+	require.NoError(t, err)
+	assert.Equal(t, token, cfg.Auth.SCIM.Token)
+
+	os.Setenv(EnvSCIMToken, "short")
+	_, err = Load("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SCIM token must be at least 32 characters")
+}
+
+func TestLoad_Audit(t *testing.T) {
+	clearEnvVars()
+	defer clearEnvVars()
+
+	cfg, err := Load("")
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Audit.LogFile)
+
+	os.Setenv(EnvAuditLogFile, "/var/log/ovim/audit.jsonl")
+	cfg, err = Load("")
+	require.NoError(t, err)
+	assert.Equal(t, "/var/log/ovim/audit.jsonl", cfg.Audit.LogFile)
 }
 
 func TestGetEnvString(t *testing.T) {
@@ -332,6 +706,52 @@ func TestGetEnvInt(t *testing.T) {
 	}
 }
 
+func TestGetEnvDuration(t *testing.T) {
+	tests := []struct {
+		name         string
+		key          string
+		defaultValue time.Duration
+		envValue     string
+		expected     time.Duration
+	}{
+		{
+			name:         "ValidDuration",
+			key:          "TEST_DURATION_VAR",
+			defaultValue: time.Hour,
+			envValue:     "90m",
+			expected:     90 * time.Minute,
+		},
+		{
+			name:         "InvalidDuration",
+			key:          "TEST_DURATION_VAR_INVALID",
+			defaultValue: time.Hour,
+			envValue:     "30",
+			expected:     time.Hour,
+		},
+		{
+			name:         "EmptyValue",
+			key:          "TEST_DURATION_VAR_EMPTY",
+			defaultValue: time.Hour,
+			envValue:     "",
+			expected:     time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.envValue != "" {
+				os.Setenv(tt.key, tt.envValue)
+				defer os.Unsetenv(tt.key)
+			} else {
+				os.Unsetenv(tt.key)
+			}
+
+			result := getEnvDuration(tt.key, tt.defaultValue)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
 func TestGetEnvBool(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -573,8 +993,19 @@ func clearEnvVars() {
 		EnvPort, EnvTLSEnabled, EnvTLSPort, EnvTLSCertFile, EnvTLSKeyFile, EnvTLSAutoGenerateCert,
 		EnvDatabaseURL, EnvKubernetesConfig, EnvKubernetesInCluster, EnvKubevirtEnabled, EnvKubevirtNamespace,
 		EnvJWTSecret, EnvEnvironment, EnvLogLevel, EnvOIDCEnabled, EnvOIDCIssuerURL, EnvOIDCClientID,
-		EnvOIDCClientSecret, EnvOIDCRedirectURL, EnvOpenShiftEnabled, EnvOpenShiftConfig,
-		EnvOpenShiftInCluster, EnvOpenShiftTemplateNamespace,
+		EnvOIDCClientSecret, EnvOIDCRedirectURL, EnvOIDCRoleMappings, EnvOIDCDefaultRole, EnvOpenShiftEnabled, EnvOpenShiftConfig,
+		EnvOpenShiftInCluster, EnvOpenShiftTemplateNamespace, EnvTrashRetention, EnvTrashPurgeInterval,
+		EnvLoginMaxAttempts, EnvLoginMaxAttemptsPerIP, EnvLoginLockoutDuration, EnvLoginAttemptWindow,
+		EnvLoginDelay, EnvLoginMaxDelay, EnvPasswordMinLength, EnvPasswordRequireUppercase, EnvPasswordRequireLowercase,
+		EnvPasswordRequireDigit, EnvPasswordRequireSymbol, EnvPasswordHistory, EnvPasswordMaxAge, EnvPasswordResetTokenDuration,
+		EnvMFAIssuer, EnvMFARequiredRoles, EnvJWTKeysDir, EnvJWTKeysSecret, EnvJWTKeyAlgorithm, EnvJWTKeyRotation,
+		EnvJWTKeyReloadInterval, EnvServiceAccountBindings, EnvServiceAccountAudiences, EnvServiceAccountCacheTTL,
+		EnvLDAPEnabled, EnvLDAPURL, EnvLDAPStartTLS, EnvLDAPCAFile, EnvLDAPInsecureSkipVerify, EnvLDAPBindDN, EnvLDAPBindPassword,
+		EnvLDAPUserBaseDN, EnvLDAPUserFilter, EnvLDAPUsernameAttribute, EnvLDAPEmailAttribute, EnvLDAPGroupBaseDN,
+		EnvLDAPGroupFilter, EnvLDAPGroupNameAttribute, EnvLDAPGroupMappings, EnvLDAPDefaultRole, EnvLDAPTimeout,
+		EnvImpersonationEnabled, EnvImpersonationTokenDuration, EnvImpersonationReadOnly, EnvImpersonationBlockedOperations,
+		EnvInvitationTokenDuration, EnvInvitationAcceptURL, EnvNotifier, EnvSMTPHost, EnvSMTPPort, EnvSMTPUsername,
+		EnvSMTPPassword, EnvSMTPFrom, EnvSCIMToken, EnvAuditLogFile,
 	}
 	for _, env := range envVars {
 		os.Unsetenv(env)
@@ -3,8 +3,10 @@ package models
 import (
 	"database/sql/driver"
 	"encoding/json"
-	"fmt"
 	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
 )
 
 // CRD-related constants
@@ -57,9 +59,9 @@ func (ja *JSONBArray) Scan(value interface{}) error {
 		return nil
 	}
 
-	bytes, ok := value.([]byte)
-	if !ok {
-		return fmt.Errorf("cannot scan %T into JSONBArray", value)
+	bytes, err := jsonColumnBytes(value, "JSONBArray")
+	if err != nil {
+		return err
 	}
 
 	if len(bytes) == 0 {
@@ -84,6 +86,16 @@ func (ja JSONBArray) Value() (driver.Value, error) {
 	return json.Marshal([]string(ja))
 }
 
+// GormDataType implements the GORM data type interface
+func (JSONBArray) GormDataType() string {
+	return "json"
+}
+
+// GormDBDataType returns the column type for the dialect of db
+func (JSONBArray) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	return jsonDataType(db)
+}
+
 // JSONBMap represents a JSONB object field
 type JSONBMap map[string]interface{}
 
@@ -94,9 +106,9 @@ func (jm *JSONBMap) Scan(value interface{}) error {
 		return nil
 	}
 
-	bytes, ok := value.([]byte)
-	if !ok {
-		return fmt.Errorf("cannot scan %T into JSONBMap", value)
+	bytes, err := jsonColumnBytes(value, "JSONBMap")
+	if err != nil {
+		return err
 	}
 
 	if len(bytes) == 0 {
@@ -121,6 +133,16 @@ func (jm JSONBMap) Value() (driver.Value, error) {
 	return json.Marshal(map[string]interface{}(jm))
 }
 
+// GormDataType implements the GORM data type interface
+func (JSONBMap) GormDataType() string {
+	return "json"
+}
+
+// GormDBDataType returns the column type for the dialect of db
+func (JSONBMap) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	return jsonDataType(db)
+}
+
 // Condition represents a Kubernetes-style condition
 type Condition struct {
 	Type               string    `json:"type"`
@@ -140,9 +162,9 @@ func (ca *ConditionsArray) Scan(value interface{}) error {
 		return nil
 	}
 
-	bytes, ok := value.([]byte)
-	if !ok {
-		return fmt.Errorf("cannot scan %T into ConditionsArray", value)
+	bytes, err := jsonColumnBytes(value, "ConditionsArray")
+	if err != nil {
+		return err
 	}
 
 	if len(bytes) == 0 {
@@ -167,6 +189,16 @@ func (ca ConditionsArray) Value() (driver.Value, error) {
 	return json.Marshal([]Condition(ca))
 }
 
+// GormDataType implements the GORM data type interface
+func (ConditionsArray) GormDataType() string {
+	return "json"
+}
+
+// GormDBDataType returns the column type for the dialect of db
+func (ConditionsArray) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	return jsonDataType(db)
+}
+
 // SetCondition updates or adds a condition to the conditions array
 func (ca *ConditionsArray) SetCondition(conditionType, status, reason, message string) {
 	newCondition := Condition{
@@ -223,7 +255,7 @@ type Organization struct {
 	Name        string `json:"name" gorm:"uniqueIndex"`
 	Description string `json:"description"`
 	Namespace   string `json:"namespace" gorm:"uniqueIndex"`
-	IsEnabled   bool   `json:"is_enabled" gorm:"default:true"`
+	IsEnabled   bool   `json:"is_enabled"` // Left without a gorm default so disabled organizations stay disabled
 
 	// CRD integration fields
 	DisplayName        *string    `json:"display_name,omitempty"`
@@ -233,8 +265,13 @@ type Organization struct {
 	LastRBACSync       *time.Time `json:"last_rbac_sync,omitempty"`
 	ObservedGeneration int64      `json:"observed_generation" gorm:"default:0"`
 
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	// RequireMFA makes the users of the organization with a password enroll a second factor
+	RequireMFA bool `json:"require_mfa" gorm:"not null;default:false"`
+
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	ResourceVersion int64      `json:"resource_version" gorm:"not null;default:1"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty" gorm:"index"`
 
 	// Relationships
 	VirtualDataCenters []VirtualDataCenter `json:"virtual_data_centers,omitempty" gorm:"foreignKey:OrgID"`
@@ -270,12 +307,12 @@ type VirtualDataCenter struct {
 
 	// Network and status
 	NetworkPolicy       string     `json:"network_policy" gorm:"default:default"`
-	CustomNetworkConfig JSONBMap   `json:"custom_network_config,omitempty" gorm:"type:jsonb"`
-	CatalogRestrictions JSONBArray `json:"catalog_restrictions,omitempty" gorm:"type:jsonb"`
+	CustomNetworkConfig JSONBMap   `json:"custom_network_config,omitempty"`
+	CatalogRestrictions JSONBArray `json:"catalog_restrictions,omitempty"`
 
 	// Status tracking
 	Phase              string          `json:"phase" gorm:"default:Pending"`
-	Conditions         ConditionsArray `json:"conditions,omitempty" gorm:"type:jsonb"`
+	Conditions         ConditionsArray `json:"conditions,omitempty"`
 	ObservedGeneration int64           `json:"observed_generation" gorm:"default:0"`
 
 	// Resource usage tracking (updated by metrics controller)
@@ -295,8 +332,10 @@ type VirtualDataCenter struct {
 	// Sync tracking
 	LastMetricsUpdate *time.Time `json:"last_metrics_update,omitempty"`
 
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	ResourceVersion int64      `json:"resource_version" gorm:"not null;default:1"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty" gorm:"index"`
 
 	// Relationships
 	Organization    *Organization    `json:"organization,omitempty" gorm:"foreignKey:OrgID"`
@@ -352,36 +391,37 @@ type Catalog struct {
 	RefreshInterval       string  `json:"refresh_interval" gorm:"default:1h"`
 
 	// Content filtering
-	IncludePatterns JSONBArray `json:"include_patterns,omitempty" gorm:"type:jsonb"`
-	ExcludePatterns JSONBArray `json:"exclude_patterns,omitempty" gorm:"type:jsonb"`
-	RequiredTags    JSONBArray `json:"required_tags,omitempty" gorm:"type:jsonb"`
+	IncludePatterns JSONBArray `json:"include_patterns,omitempty"`
+	ExcludePatterns JSONBArray `json:"exclude_patterns,omitempty"`
+	RequiredTags    JSONBArray `json:"required_tags,omitempty"`
 
-	// Permissions
-	AllowedVDCs   JSONBArray `json:"allowed_vdcs,omitempty" gorm:"type:jsonb"`
-	AllowedGroups JSONBArray `json:"allowed_groups,omitempty" gorm:"type:jsonb"`
-	ReadOnly      bool       `json:"read_only" gorm:"default:true"`
+	// Permissions. ReadOnly and IsEnabled have no gorm default, which would replace false on create.
+	AllowedVDCs   JSONBArray `json:"allowed_vdcs,omitempty" gorm:"column:allowed_vdcs"`
+	AllowedGroups JSONBArray `json:"allowed_groups,omitempty"`
+	ReadOnly      bool       `json:"read_only"`
 
 	// Status
-	IsEnabled  bool            `json:"is_enabled" gorm:"default:true"`
+	IsEnabled  bool            `json:"is_enabled"`
 	Phase      string          `json:"phase" gorm:"default:Pending"`
-	Conditions ConditionsArray `json:"conditions,omitempty" gorm:"type:jsonb"`
+	Conditions ConditionsArray `json:"conditions,omitempty"`
 
 	// Content summary
 	TotalItems        int        `json:"total_items" gorm:"default:0"`
 	VMTemplates       int        `json:"vm_templates" gorm:"default:0"`
 	ApplicationStacks int        `json:"application_stacks" gorm:"default:0"`
-	Categories        JSONBArray `json:"categories,omitempty" gorm:"type:jsonb"`
+	Categories        JSONBArray `json:"categories,omitempty"`
 
 	// Sync status
 	LastSync          *time.Time `json:"last_sync,omitempty"`
 	LastSyncAttempt   *time.Time `json:"last_sync_attempt,omitempty"`
-	SyncErrors        JSONBMap   `json:"sync_errors,omitempty" gorm:"type:jsonb"`
+	SyncErrors        JSONBMap   `json:"sync_errors,omitempty"`
 	NextSyncScheduled *time.Time `json:"next_sync_scheduled,omitempty"`
 
 	ObservedGeneration int64 `json:"observed_generation" gorm:"default:0"`
 
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	ResourceVersion int64     `json:"resource_version" gorm:"not null;default:1"`
 
 	// Relationships
 	Organization *Organization `json:"organization,omitempty" gorm:"foreignKey:OrgID"`
@@ -478,7 +518,7 @@ type CreateCatalogRequest struct {
 	Name        string `json:"name" binding:"required"`
 	DisplayName string `json:"display_name" binding:"required"`
 	Description string `json:"description"`
-	OrgID       string `json:"org_id" binding:"required"`
+	OrgID       string `json:"org_id,omitempty"` // Optional, the organization is taken from the request path
 
 	// Catalog configuration
 	Type                  string `json:"type" binding:"required"`
@@ -12,7 +12,7 @@ import (
 func TestJSONBArray(t *testing.T) {
 	tests := []struct {
 		name     string
-		input    []byte
+		input    interface{}
 		expected JSONBArray
 		wantErr  bool
 	}{
@@ -46,6 +46,18 @@ func TestJSONBArray(t *testing.T) {
 			expected: nil,
 			wantErr:  true,
 		},
+		{
+			name:     "text column",
+			input:    `["item1"]`,
+			expected: JSONBArray{"item1"},
+			wantErr:  false,
+		},
+		{
+			name:     "unsupported type",
+			input:    42,
+			expected: nil,
+			wantErr:  true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -8,6 +8,9 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
 )
 
 // User roles
@@ -38,9 +41,9 @@ func (sm *StringMap) Scan(value interface{}) error {
 		return nil
 	}
 
-	bytes, ok := value.([]byte)
-	if !ok {
-		return fmt.Errorf("cannot scan %T into StringMap", value)
+	bytes, err := jsonColumnBytes(value, "StringMap")
+	if err != nil {
+		return err
 	}
 
 	if len(bytes) == 0 {
@@ -65,16 +68,228 @@ func (sm StringMap) Value() (driver.Value, error) {
 	return json.Marshal(map[string]string(sm))
 }
 
+// GormDataType implements the GORM data type interface
+func (StringMap) GormDataType() string {
+	return "json"
+}
+
+// GormDBDataType stores the map as jsonb on PostgreSQL and as JSON text elsewhere
+func (StringMap) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	return jsonDataType(db)
+}
+
+// jsonColumnBytes returns the raw JSON of a scanned column. PostgreSQL returns jsonb
+// columns as []byte, while SQLite returns TEXT columns as string.
+func jsonColumnBytes(value interface{}, typeName string) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("cannot scan %T into %s", value, typeName)
+	}
+}
+
+// jsonDataType returns the column type used for JSON values on the dialect of db
+func jsonDataType(db *gorm.DB) string {
+	if db.Dialector.Name() == "postgres" {
+		return "jsonb"
+	}
+	return "text"
+}
+
 // User represents a user in the system
 type User struct {
-	ID           string    `json:"id" gorm:"primaryKey"`
-	Username     string    `json:"username" gorm:"uniqueIndex"`
-	Email        string    `json:"email" gorm:"uniqueIndex"`
-	PasswordHash string    `json:"-"`
-	Role         string    `json:"role"`
-	OrgID        *string   `json:"org_id,omitempty"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID              string    `json:"id" gorm:"primaryKey"`
+	Username        string    `json:"username" gorm:"uniqueIndex"`
+	Email           string    `json:"email" gorm:"uniqueIndex"`
+	PasswordHash    string    `json:"-"`
+	Role            string    `json:"role"`
+	OrgID           *string   `json:"org_id,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	ResourceVersion int64     `json:"resource_version" gorm:"not null;default:1"`
+	// MustChangePassword limits the user to changing the password until it is changed
+	MustChangePassword bool       `json:"must_change_password" gorm:"not null;default:false"`
+	PasswordChangedAt  *time.Time `json:"password_changed_at,omitempty"`
+	// PasswordHistory holds the hashes of the previous passwords, most recent first
+	PasswordHistory JSONBArray `json:"-"`
+	// MFAEnabled is set once the user proved having the TOTP secret. MFASecret is kept while an
+	// enrollment is pending, MFALastCounter is the period of the last code used.
+	MFAEnabled     bool   `json:"mfa_enabled" gorm:"not null;default:false"`
+	MFASecret      string `json:"-"`
+	MFALastCounter int64  `json:"-" gorm:"not null;default:0"`
+	// MFARecoveryCodes holds the hashes of the unused recovery codes
+	MFARecoveryCodes JSONBArray `json:"-"`
+	// Disabled stops the user from authenticating, such as once its identity provider
+	// deprovisioned it
+	Disabled bool `json:"disabled" gorm:"not null;default:false"`
+	// AuthSource is where the identity of the user comes from, and ExternalID its subject there,
+	// such as the OIDC sub or the LDAP DN. Logins through a source only match its users.
+	AuthSource string `json:"auth_source" gorm:"not null;default:local"`
+	ExternalID string `json:"external_id,omitempty"`
+}
+
+// Sources of the identity of users
+const (
+	AuthSourceLocal = "local"
+	AuthSourceOIDC  = "oidc"
+	AuthSourceLDAP  = "ldap"
+	AuthSourceSCIM  = "scim"
+)
+
+// PasswordResetToken is a one-time token an administrator issued to let a user set a new
+// password. Only the hash of the token is stored.
+type PasswordResetToken struct {
+	ID        string     `json:"id" gorm:"primaryKey"`
+	UserID    string     `json:"user_id" gorm:"index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	CreatedBy string     `json:"created_by"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+// RefreshToken is a refresh token of a login session. Only the hash of the token is stored.
+// Every refresh revokes the token and issues a new one in the same session, so a token that
+// is used twice has been stolen.
+type RefreshToken struct {
+	ID        string `json:"id" gorm:"primaryKey"`
+	UserID    string `json:"user_id" gorm:"index"`
+	SessionID string `json:"session_id" gorm:"index"`
+	TokenHash string `json:"-" gorm:"uniqueIndex"`
+	// AccessTokenID is the jti of the access token issued along with the refresh token
+	AccessTokenID        string     `json:"access_token_id"`
+	AccessTokenExpiresAt time.Time  `json:"access_token_expires_at"`
+	ExpiresAt            time.Time  `json:"expires_at"`
+	CreatedAt            time.Time  `json:"created_at"`
+	RevokedAt            *time.Time `json:"revoked_at,omitempty"`
+}
+
+// RevokedToken is an access token that was revoked before it expired. ID is its jti.
+type RevokedToken struct {
+	ID        string    `json:"id" gorm:"primaryKey"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// APIToken is a personal access token that scripts and pipelines use instead of logging in.
+// Only the hash of the secret is stored, Prefix keeps its first characters to tell tokens apart.
+// Each scope allows the requests of a method, or "*" for any, under a path such as
+// "GET /api/v1/vms", where a "*" segment matches any single segment.
+type APIToken struct {
+	ID         string     `json:"id" gorm:"primaryKey"`
+	UserID     string     `json:"user_id" gorm:"index"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	TokenHash  string     `json:"-" gorm:"uniqueIndex"`
+	Scopes     JSONBArray `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// OrgRole is a custom role of an organization. Users of the organization whose Role is its Name
+// may perform its verbs, such as "vm:power" or "vm:*", on the resources of the organization.
+type OrgRole struct {
+	ID          string     `json:"id" gorm:"primaryKey"`
+	OrgID       string     `json:"org_id" gorm:"index"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Verbs       JSONBArray `json:"verbs"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// OrgMembership gives a user a role in an organization besides the organization of the user, so
+// that people working for several tenants need a single account. Role is a built-in role other
+// than system_admin or a custom role of the organization.
+type OrgMembership struct {
+	ID        string    `json:"id" gorm:"primaryKey"`
+	UserID    string    `json:"user_id" gorm:"index"`
+	OrgID     string    `json:"org_id" gorm:"index"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Impersonation records a system administrator acting as a user with a short-lived token, so
+// that the user can see who saw the API as them and why. TokenID is the jti of the token.
+type Impersonation struct {
+	ID                   string     `json:"id" gorm:"primaryKey"`
+	UserID               string     `json:"user_id" gorm:"index"`
+	ImpersonatorID       string     `json:"impersonator_id"`
+	ImpersonatorUsername string     `json:"impersonator_username"`
+	Reason               string     `json:"reason"`
+	TokenID              string     `json:"-"`
+	ExpiresAt            time.Time  `json:"expires_at"`
+	CreatedAt            time.Time  `json:"created_at"`
+	EndedAt              *time.Time `json:"ended_at,omitempty"`
+}
+
+// Invitation invites a person by email to join an organization with a role. The invitee accepts
+// it once with the signed token sent by email, whose ID is TokenID, by creating a local account or
+// by logging in with OIDC. UserID is the user who accepted it.
+type Invitation struct {
+	ID         string     `json:"id" gorm:"primaryKey"`
+	OrgID      string     `json:"org_id" gorm:"index"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	InvitedBy  string     `json:"invited_by"`
+	TokenID    string     `json:"-"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	UserID     *string    `json:"user_id,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// LoginAttempt counts the recent failed password logins of an account or of a client address.
+// ID names what is tracked, such as "user:alice" or "ip:192.0.2.10".
+type LoginAttempt struct {
+	ID            string     `json:"id" gorm:"primaryKey"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
+
+// Outcomes of audited API calls
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeDenied  = "denied"
+	AuditOutcomeFailure = "failure"
+)
+
+// AuditRecord records a mutating API call: who made it, for whom if an administrator
+// impersonated a user, on which resource, what it changed and how it ended. Route is the route
+// template, such as "/api/v1/vms/:id", and Path the requested path. Request is the JSON body of
+// the request with secrets redacted, and Changes the attributes of the resource the call changed,
+// each with its old and new value. Records are never updated and outlive the users and
+// organizations they name.
+type AuditRecord struct {
+	ID                   string    `json:"id" gorm:"primaryKey"`
+	ActorID              string    `json:"actor_id" gorm:"index"`
+	ActorUsername        string    `json:"actor_username"`
+	ActorRole            string    `json:"actor_role"`
+	ImpersonatorID       string    `json:"impersonator_id,omitempty"`
+	ImpersonatorUsername string    `json:"impersonator_username,omitempty"`
+	OrgID                string    `json:"org_id" gorm:"index"`
+	Method               string    `json:"method"`
+	Route                string    `json:"route"`
+	Path                 string    `json:"path"`
+	ResourceType         string    `json:"resource_type"`
+	ResourceID           string    `json:"resource_id" gorm:"index"`
+	Request              JSONBMap  `json:"request,omitempty"`
+	Changes              JSONBMap  `json:"changes,omitempty"`
+	StatusCode           int       `json:"status_code"`
+	Outcome              string    `json:"outcome"`
+	Error                string    `json:"error,omitempty"`
+	ClientIP             string    `json:"client_ip"`
+	CreatedAt            time.Time `json:"created_at" gorm:"index"`
 }
 
 // Legacy types moved to migration_compat.go to avoid duplicates
@@ -237,32 +452,35 @@ type Template struct {
 	ContentType string  `json:"content_type" gorm:"default:'vm-template'"` // vm-template, application-stack
 
 	// Legacy fields (for backward compatibility)
-	Source       string    `json:"source" gorm:"default:'global'"`         // global, organization, external
-	SourceVendor string    `json:"source_vendor" gorm:"default:'Red Hat'"` // Red Hat, Organization, Community, etc.
-	Category     string    `json:"category" gorm:"default:'Operating System'"`
-	Namespace    string    `json:"namespace"` // OpenShift namespace where template resides
-	Featured     bool      `json:"featured"`  // Whether this template is featured/recommended
-	Metadata     StringMap `json:"metadata" gorm:"type:jsonb"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	Source          string    `json:"source" gorm:"default:'global'"`         // global, organization, external
+	SourceVendor    string    `json:"source_vendor" gorm:"default:'Red Hat'"` // Red Hat, Organization, Community, etc.
+	Category        string    `json:"category" gorm:"default:'Operating System'"`
+	Namespace       string    `json:"namespace"` // OpenShift namespace where template resides
+	Featured        bool      `json:"featured"`  // Whether this template is featured/recommended
+	Metadata        StringMap `json:"metadata"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	ResourceVersion int64     `json:"resource_version" gorm:"not null;default:1"`
 }
 
 // VirtualMachine represents a deployed virtual machine
 type VirtualMachine struct {
-	ID         string    `json:"id" gorm:"primaryKey"`
-	Name       string    `json:"name"`
-	OrgID      string    `json:"org_id" gorm:"index"`
-	VDCID      *string   `json:"vdc_id,omitempty" gorm:"index"` // Updated for optional VDC association
-	TemplateID string    `json:"template_id" gorm:"index"`
-	OwnerID    string    `json:"owner_id" gorm:"index"`
-	Status     string    `json:"status" gorm:"index"`
-	CPU        int       `json:"cpu"`
-	Memory     string    `json:"memory"`
-	DiskSize   string    `json:"disk_size"`
-	IPAddress  string    `json:"ip_address"`
-	Metadata   StringMap `json:"metadata" gorm:"type:jsonb"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID              string     `json:"id" gorm:"primaryKey"`
+	Name            string     `json:"name"`
+	OrgID           string     `json:"org_id" gorm:"index"`
+	VDCID           *string    `json:"vdc_id,omitempty" gorm:"index"` // Updated for optional VDC association
+	TemplateID      string     `json:"template_id" gorm:"index"`
+	OwnerID         string     `json:"owner_id" gorm:"index"`
+	Status          string     `json:"status" gorm:"index"`
+	CPU             int        `json:"cpu"`
+	Memory          string     `json:"memory"`
+	DiskSize        string     `json:"disk_size"`
+	IPAddress       string     `json:"ip_address"`
+	Metadata        StringMap  `json:"metadata"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	ResourceVersion int64      `json:"resource_version" gorm:"not null;default:1"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty" gorm:"index"`
 }
 
 // LimitRangeRequest represents LimitRange parameters for VM resource constraints
@@ -393,7 +611,7 @@ type OrganizationCatalogSource struct {
 	SourceType      string    `json:"source_type"`      // Type of catalog source (e.g., "operatorhubio", "redhat-operators")
 	SourceName      string    `json:"source_name"`      // Display name for this source in the organization
 	SourceNamespace string    `json:"source_namespace"` // OpenShift namespace where the catalog source exists
-	Enabled         bool      `json:"enabled" gorm:"default:true"`
+	Enabled         bool      `json:"enabled"`          // No gorm default, it would replace false on create
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
 }
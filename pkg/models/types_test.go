@@ -46,8 +46,14 @@ func TestStringMap_Scan(t *testing.T) {
 			hasError: true,
 		},
 		{
-			name:     "Invalid type (string)",
-			input:    "not a byte slice",
+			name:     "Valid JSON string",
+			input:    `{"key1":"value1"}`,
+			expected: StringMap{"key1": "value1"},
+			hasError: false,
+		},
+		{
+			name:     "Invalid JSON string",
+			input:    "not json",
 			expected: nil,
 			hasError: true,
 		},
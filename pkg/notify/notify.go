@@ -0,0 +1,55 @@
+// Package notify delivers messages to users, such as the invitations to join an organization.
+//
+// A Notifier sends a Message to an email address. The SMTP notifier sends it as an email, and the
+// log notifier only logs it, for development and for deployments without a mail server, where an
+// administrator forwards the message by hand.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// Message is a plain text message to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages
+type Notifier interface {
+	// Notify delivers the message, or returns why it could not
+	Notify(ctx context.Context, msg *Message) error
+}
+
+// validate rejects messages without a recipient and headers that would smuggle other headers in
+func (m *Message) validate() error {
+	if m == nil || m.To == "" {
+		return fmt.Errorf("message has no recipient")
+	}
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return fmt.Errorf("message headers cannot contain line breaks")
+	}
+	return nil
+}
+
+// LogNotifier logs messages instead of delivering them
+type LogNotifier struct{}
+
+// NewLogNotifier returns a notifier logging every message with its body
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+// Notify logs the message
+func (n *LogNotifier) Notify(_ context.Context, msg *Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	klog.Infof("Notification to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smtpServer is a minimal SMTP server accepting a single message without TLS or authentication
+type smtpServer struct {
+	listener net.Listener
+	commands []string
+	data     chan string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	server := &smtpServer{listener: listener, data: make(chan string, 1)}
+	go server.serve()
+	return server
+}
+
+func (s *smtpServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimRight(line, "\r\n")
+		s.commands = append(s.commands, command)
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			reply("250-localhost")
+			reply("250 8BITMIME")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.data <- data.String()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	server := newSMTPServer(t)
+	notifier, err := NewSMTPNotifier(SMTPConfig{Host: "127.0.0.1", Port: server.port(), From: "OVIM <ovim@example.com>"})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	body := "Join Acme on OVIM: https://ovim.example.com/invitations/accept?token=abc\nThe link expires in 3 days — ignore this email otherwise."
+	require.NoError(t, notifier.Notify(ctx, &Message{To: "alice@example.com", Subject: "You are invited to Acmé", Body: body}))
+
+	var data string
+	select {
+	case data = <-server.data:
+	case <-ctx.Done():
+		t.Fatal("the server received no message")
+	}
+	assert.Contains(t, server.commands, "MAIL FROM:<ovim@example.com> BODY=8BITMIME")
+	assert.Contains(t, server.commands, "RCPT TO:<alice@example.com>")
+
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, `"OVIM" <ovim@example.com>`, msg.Header.Get("From"))
+	assert.Equal(t, "<alice@example.com>", msg.Header.Get("To"))
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "You are invited to Acmé", subject)
+	assert.Equal(t, "quoted-printable", msg.Header.Get("Content-Transfer-Encoding"))
+	decoded, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	require.NoError(t, err)
+	assert.Equal(t, strings.ReplaceAll(body, "\n", "\r\n"), strings.TrimRight(string(decoded), "\r\n"))
+}
+
+func TestSMTPNotifier_Invalid(t *testing.T) {
+	_, err := NewSMTPNotifier(SMTPConfig{Port: 587, From: "ovim@example.com"})
+	assert.Error(t, err)
+	_, err = NewSMTPNotifier(SMTPConfig{Host: "smtp.example.com", From: "ovim@example.com"})
+	assert.Error(t, err)
+	_, err = NewSMTPNotifier(SMTPConfig{Host: "smtp.example.com", Port: 587, From: "not an address"})
+	assert.Error(t, err)
+
+	notifier, err := NewSMTPNotifier(SMTPConfig{Host: "127.0.0.1", Port: 1, Username: "ovim", From: "ovim@example.com"})
+	require.NoError(t, err)
+	assert.Error(t, notifier.Notify(context.Background(), &Message{To: "alice@example.com\r\nBcc: eve@example.com", Subject: "Hi"}))
+	assert.Error(t, notifier.Notify(context.Background(), &Message{To: "not an address", Subject: "Hi"}))
+}
+
+func TestLogNotifier(t *testing.T) {
+	notifier := NewLogNotifier()
+	assert.NoError(t, notifier.Notify(context.Background(), &Message{To: "alice@example.com", Subject: "Hi", Body: "Hello"}))
+	assert.Error(t, notifier.Notify(context.Background(), &Message{Subject: "Hi"}))
+	assert.Error(t, notifier.Notify(context.Background(), &Message{To: "alice@example.com", Subject: "Hi\nBcc: eve@example.com"}))
+}
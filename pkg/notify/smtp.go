@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// implicitTLSPort is the SMTP submission port whose connections start with TLS rather than
+// upgrading with STARTTLS
+const implicitTLSPort = 465
+
+// SMTPConfig configures the SMTP server sending emails
+type SMTPConfig struct {
+	Host string
+	Port int
+	// Username and Password authenticate with PLAIN, which the server must offer; no
+	// authentication happens without a username
+	Username string
+	Password string
+	// From is the sender address, such as "OVIM <ovim@example.com>"
+	From string
+}
+
+// SMTPNotifier sends messages as emails through an SMTP server
+type SMTPNotifier struct {
+	config SMTPConfig
+	from   *mail.Address
+}
+
+// NewSMTPNotifier returns a notifier sending emails through the configured server
+func NewSMTPNotifier(config SMTPConfig) (*SMTPNotifier, error) {
+	if config.Host == "" {
+		return nil, fmt.Errorf("SMTP host is required")
+	}
+	if config.Port <= 0 || config.Port > 65535 {
+		return nil, fmt.Errorf("invalid SMTP port %d", config.Port)
+	}
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", config.From, err)
+	}
+	return &SMTPNotifier{config: config, from: from}, nil
+}
+
+// Notify sends the message, upgrading the connection with STARTTLS whenever the server offers it
+func (n *SMTPNotifier) Notify(ctx context.Context, msg *Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address %q: %w", msg.To, err)
+	}
+
+	address := net.JoinHostPort(n.config.Host, strconv.Itoa(n.config.Port))
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server %s: %w", address, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	tlsConfig := &tls.Config{ServerName: n.config.Host, MinVersion: tls.VersionTLS12}
+	if n.config.Port == implicitTLSPort {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, n.config.Host)
+	if err != nil {
+		return fmt.Errorf("failed to start SMTP session with %s: %w", address, err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && n.config.Port != implicitTLSPort {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("failed to start TLS with %s: %w", address, err)
+		}
+	}
+	if n.config.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("SMTP server %s does not support authentication", address)
+		}
+		auth := smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("failed to authenticate with %s: %w", address, err)
+		}
+	}
+
+	if err := client.Mail(n.from.Address); err != nil {
+		return fmt.Errorf("SMTP server rejected the sender: %w", err)
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("SMTP server rejected the recipient: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP server rejected the message: %w", err)
+	}
+	if _, err := w.Write(n.compose(to, msg)); err != nil {
+		return fmt.Errorf("failed to send the message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP server rejected the message: %w", err)
+	}
+	return client.Quit()
+}
+
+// compose returns the email of a message, with a quoted-printable UTF-8 body
+func (n *SMTPNotifier) compose(to *mail.Address, msg *Message) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", n.from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(msg.Body))
+	qp.Close()
+	return buf.Bytes()
+}
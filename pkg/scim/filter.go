@@ -0,0 +1,501 @@
+package scim
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFilterDepth is how deep groupings of a filter may nest
+const maxFilterDepth = 32
+
+// attributeNamePattern matches the names of attributes and sub-attributes
+var attributeNamePattern = regexp.MustCompile(`^(\$ref|[A-Za-z][A-Za-z0-9_-]*)$`)
+
+// Filter is a parsed filter of a query or of a PATCH path
+type Filter interface {
+	// Matches reports whether a resource, or a value of a multi-valued attribute, given as a
+	// generic JSON object matches the filter
+	Matches(resource map[string]interface{}) bool
+}
+
+// ParseFilter parses a filter such as `userName eq "alice" and active eq true`. It supports the
+// comparison operators eq, ne, co, sw, ew, gt, ge, lt and le, the presence operator pr, the
+// logical operators and, or and not, groupings, and value paths such as
+// `emails[type eq "work"]`. Strings compare without regard to case, like the case-insensitive
+// attributes of users and groups. It returns an invalidFilter error for malformed filters.
+func ParseFilter(filter string) (Filter, error) {
+	tokens, err := tokenize(filter)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	parsed, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, BadRequest(ErrorInvalidFilter, "unexpected %q in filter", p.peek().text)
+	}
+	return parsed, nil
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOpen
+	tokenClose
+	tokenOpenBracket
+	tokenCloseBracket
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// tokenize splits a filter into words, decoded strings, parentheses and brackets
+func tokenize(filter string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(filter); {
+		switch c := filter[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenOpen, text: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenClose, text: ")"})
+			i++
+		case c == '[':
+			tokens = append(tokens, token{kind: tokenOpenBracket, text: "["})
+			i++
+		case c == ']':
+			tokens = append(tokens, token{kind: tokenCloseBracket, text: "]"})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(filter) && filter[end] != '"' {
+				if filter[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(filter) {
+				return nil, BadRequest(ErrorInvalidFilter, "unterminated string in filter")
+			}
+			var value string
+			if err := json.Unmarshal([]byte(filter[i:end+1]), &value); err != nil {
+				return nil, BadRequest(ErrorInvalidFilter, "invalid string %s in filter", filter[i:end+1])
+			}
+			tokens = append(tokens, token{kind: tokenString, text: value})
+			i = end + 1
+		default:
+			end := i
+			for end < len(filter) && !strings.ContainsRune(" \t\n\r()[]\"", rune(filter[end])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: filter[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// keyword reports whether the next token is the keyword, and consumes it if it is
+func (p *filterParser) keyword(keyword string) bool {
+	if p.done() || p.peek().kind != tokenWord || !strings.EqualFold(p.peek().text, keyword) {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+func (p *filterParser) expect(kind tokenKind, text string) error {
+	if p.done() || p.peek().kind != kind {
+		return BadRequest(ErrorInvalidFilter, "expected %q in filter", text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *filterParser) enter() error {
+	p.depth++
+	if p.depth > maxFilterDepth {
+		return BadRequest(ErrorInvalidFilter, "filter nests too deeply")
+	}
+	return nil
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalFilter{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalFilter{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (Filter, error) {
+	if p.keyword("not") {
+		filter, err := p.parseGroup()
+		if err != nil {
+			return nil, err
+		}
+		return &notFilter{filter: filter}, nil
+	}
+	if !p.done() && p.peek().kind == tokenOpen {
+		return p.parseGroup()
+	}
+	return p.parseAttribute()
+}
+
+// parseGroup parses a filter between parentheses
+func (p *filterParser) parseGroup() (Filter, error) {
+	if err := p.expect(tokenOpen, "("); err != nil {
+		return nil, err
+	}
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.depth--
+	if err := p.expect(tokenClose, ")"); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// parseAttribute parses the comparison, presence test or value path of an attribute
+func (p *filterParser) parseAttribute() (Filter, error) {
+	if p.done() || p.peek().kind != tokenWord {
+		return nil, BadRequest(ErrorInvalidFilter, "expected an attribute in filter")
+	}
+	path, ok := parseAttributePath(p.peek().text)
+	if !ok {
+		return nil, BadRequest(ErrorInvalidFilter, "invalid attribute %q in filter", p.peek().text)
+	}
+	p.pos++
+
+	if !p.done() && p.peek().kind == tokenOpenBracket {
+		if path.sub != "" {
+			return nil, BadRequest(ErrorInvalidFilter, "value path %s cannot have a sub-attribute", path.attribute)
+		}
+		p.pos++
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		filter, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.depth--
+		if err := p.expect(tokenCloseBracket, "]"); err != nil {
+			return nil, err
+		}
+		return &valuePathFilter{attribute: path.attribute, filter: filter}, nil
+	}
+
+	if p.done() || p.peek().kind != tokenWord {
+		return nil, BadRequest(ErrorInvalidFilter, "expected an operator after %s in filter", path.attribute)
+	}
+	operator := strings.ToLower(p.peek().text)
+	p.pos++
+	if operator == "pr" {
+		return &presentFilter{path: path}, nil
+	}
+	switch operator {
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, BadRequest(ErrorInvalidFilter, "unknown operator %q in filter", operator)
+	}
+
+	if p.done() {
+		return nil, BadRequest(ErrorInvalidFilter, "expected a value after %s in filter", operator)
+	}
+	value, err := parseValue(p.peek())
+	if err != nil {
+		return nil, err
+	}
+	p.pos++
+	switch value.(type) {
+	case string:
+	case float64:
+		if operator == "co" || operator == "sw" || operator == "ew" {
+			return nil, BadRequest(ErrorInvalidFilter, "operator %s needs a string value", operator)
+		}
+	default:
+		if operator != "eq" && operator != "ne" {
+			return nil, BadRequest(ErrorInvalidFilter, "operator %s needs a string or number value", operator)
+		}
+	}
+	return &compareFilter{path: path, operator: operator, value: value}, nil
+}
+
+// parseValue returns the string, boolean, number or null of a comparison
+func parseValue(t token) (interface{}, error) {
+	if t.kind == tokenString {
+		return t.text, nil
+	}
+	if t.kind == tokenWord {
+		switch strings.ToLower(t.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		if number, err := strconv.ParseFloat(t.text, 64); err == nil {
+			return number, nil
+		}
+	}
+	return nil, BadRequest(ErrorInvalidFilter, "invalid value %q in filter", t.text)
+}
+
+// attributePath names an attribute, and a sub-attribute of it for complex attributes
+type attributePath struct {
+	attribute string
+	sub       string
+}
+
+// parseAttributePath parses an attribute path such as `name.givenName`, dropping the schema
+// URN attributes may be prefixed with. It returns false for invalid paths.
+func parseAttributePath(path string) (attributePath, bool) {
+	if strings.HasPrefix(strings.ToLower(path), "urn:") {
+		path = path[strings.LastIndex(path, ":")+1:]
+	}
+	attribute, sub, hasSub := strings.Cut(path, ".")
+	if !attributeNamePattern.MatchString(attribute) || (hasSub && !attributeNamePattern.MatchString(sub)) {
+		return attributePath{}, false
+	}
+	return attributePath{attribute: attribute, sub: sub}, true
+}
+
+// values returns the values of the path in a resource. Multi-valued attributes have one value
+// per element, and the elements of multi-valued complex attributes compare by their value
+// sub-attribute unless the path names another.
+func (a attributePath) values(resource map[string]interface{}) []interface{} {
+	value, ok := lookup(resource, a.attribute)
+	if !ok || value == nil {
+		return nil
+	}
+	elements, multiValued := value.([]interface{})
+	if !multiValued {
+		elements = []interface{}{value}
+	}
+
+	var values []interface{}
+	for _, element := range elements {
+		object, complex := element.(map[string]interface{})
+		switch {
+		case complex && a.sub != "":
+			element, ok = lookup(object, a.sub)
+		case complex && multiValued:
+			element, ok = lookup(object, "value")
+		case complex || a.sub != "":
+			ok = false
+		default:
+			ok = true
+		}
+		if ok && element != nil {
+			values = append(values, element)
+		}
+	}
+	return values
+}
+
+// lookup returns an attribute of an object, whose names are case-insensitive
+func lookup(object map[string]interface{}, name string) (interface{}, bool) {
+	if value, ok := object[name]; ok {
+		return value, true
+	}
+	for key, value := range object {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+type logicalFilter struct {
+	and         bool
+	left, right Filter
+}
+
+func (f *logicalFilter) Matches(resource map[string]interface{}) bool {
+	if f.and {
+		return f.left.Matches(resource) && f.right.Matches(resource)
+	}
+	return f.left.Matches(resource) || f.right.Matches(resource)
+}
+
+type notFilter struct {
+	filter Filter
+}
+
+func (f *notFilter) Matches(resource map[string]interface{}) bool {
+	return !f.filter.Matches(resource)
+}
+
+type presentFilter struct {
+	path attributePath
+}
+
+func (f *presentFilter) Matches(resource map[string]interface{}) bool {
+	for _, value := range f.path.values(resource) {
+		if s, ok := value.(string); !ok || s != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// valuePathFilter matches resources with a value of a multi-valued complex attribute matching
+// its filter
+type valuePathFilter struct {
+	attribute string
+	filter    Filter
+}
+
+func (f *valuePathFilter) Matches(resource map[string]interface{}) bool {
+	value, _ := lookup(resource, f.attribute)
+	elements, ok := value.([]interface{})
+	if !ok {
+		elements = []interface{}{value}
+	}
+	for _, element := range elements {
+		if object, ok := element.(map[string]interface{}); ok && f.filter.Matches(object) {
+			return true
+		}
+	}
+	return false
+}
+
+type compareFilter struct {
+	path     attributePath
+	operator string
+	value    interface{}
+}
+
+// Matches reports whether a value of the attribute compares with the value of the filter. An
+// attribute with no value equals null, and ne matches when no value is equal.
+func (f *compareFilter) Matches(resource map[string]interface{}) bool {
+	values := f.path.values(resource)
+	if f.value == nil {
+		return (f.operator == "eq") == (len(values) == 0)
+	}
+	if f.operator == "ne" {
+		return !(&compareFilter{path: f.path, operator: "eq", value: f.value}).Matches(resource)
+	}
+	for _, value := range values {
+		if compare(value, f.operator, f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// compare compares a value of a resource with the value of a filter
+func compare(actual interface{}, operator string, expected interface{}) bool {
+	switch expected := expected.(type) {
+	case bool:
+		switch actual := actual.(type) {
+		case bool:
+			return actual == expected
+		case string:
+			parsed, err := strconv.ParseBool(strings.ToLower(actual))
+			return err == nil && parsed == expected
+		}
+		return false
+	case float64:
+		actual, ok := actual.(float64)
+		if !ok {
+			return false
+		}
+		return ordered(operator, actual < expected, actual == expected)
+	case string:
+		actual, ok := actual.(string)
+		if !ok {
+			return false
+		}
+		a, e := strings.ToLower(actual), strings.ToLower(expected)
+		switch operator {
+		case "eq":
+			return a == e
+		case "co":
+			return strings.Contains(a, e)
+		case "sw":
+			return strings.HasPrefix(a, e)
+		case "ew":
+			return strings.HasSuffix(a, e)
+		}
+		// Timestamps are ordered in time, whatever their precision and zone
+		actualTime, actualErr := time.Parse(time.RFC3339Nano, actual)
+		expectedTime, expectedErr := time.Parse(time.RFC3339Nano, expected)
+		if actualErr == nil && expectedErr == nil {
+			return ordered(operator, actualTime.Before(expectedTime), actualTime.Equal(expectedTime))
+		}
+		return ordered(operator, a < e, a == e)
+	}
+	return false
+}
+
+// ordered applies an ordering operator given whether a value is less than or equal to another
+func ordered(operator string, less, equal bool) bool {
+	switch operator {
+	case "eq":
+		return equal
+	case "gt":
+		return !less && !equal
+	case "ge":
+		return !less
+	case "lt":
+		return less
+	case "le":
+		return less || equal
+	}
+	return false
+}
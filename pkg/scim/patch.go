@@ -0,0 +1,274 @@
+package scim
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// Path is the target of a PATCH operation, such as `emails[type eq "work"].value`: an attribute,
+// the values of a multi-valued attribute matching a filter, and a sub-attribute of them
+type Path struct {
+	Attribute    string
+	Filter       Filter
+	SubAttribute string
+}
+
+// ParsePath parses the path of a PATCH operation, dropping the schema URN it may be prefixed
+// with. It returns an invalidPath error for malformed paths.
+func ParsePath(path string) (*Path, error) {
+	attributePart, rest, hasFilter := strings.Cut(path, "[")
+	if !hasFilter {
+		parsed, ok := parseAttributePath(path)
+		if !ok {
+			return nil, BadRequest(ErrorInvalidPath, "invalid path %q", path)
+		}
+		return &Path{Attribute: parsed.attribute, SubAttribute: parsed.sub}, nil
+	}
+
+	parsed, ok := parseAttributePath(attributePart)
+	end := strings.LastIndex(rest, "]")
+	if !ok || parsed.sub != "" || end < 0 {
+		return nil, BadRequest(ErrorInvalidPath, "invalid path %q", path)
+	}
+	filter, err := ParseFilter(rest[:end])
+	if err != nil {
+		return nil, BadRequest(ErrorInvalidPath, "invalid filter in path %q: %s", path, err.(*Error).Detail)
+	}
+	result := &Path{Attribute: parsed.attribute, Filter: filter}
+	if sub := rest[end+1:]; sub != "" {
+		if !strings.HasPrefix(sub, ".") || !attributeNamePattern.MatchString(sub[1:]) {
+			return nil, BadRequest(ErrorInvalidPath, "invalid path %q", path)
+		}
+		result.SubAttribute = sub[1:]
+	}
+	return result, nil
+}
+
+// Patch applies the operations of a PATCH request in order to a resource given as a generic JSON
+// object. Operations are add, replace and remove in any case. Operations without a path apply
+// each attribute of their value. Adding to a multi-valued attribute appends the values it does
+// not hold yet, and removing from one with a value removes those values. Values are decoded
+// from JSON without checking their type, decoding the patched resource does. It returns a *Error
+// for operations that cannot be applied.
+func Patch(resource map[string]interface{}, operations []PatchOperation) error {
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return BadRequest(ErrorInvalidSyntax, "unknown operation %q", operation.Op)
+		}
+
+		var value interface{}
+		if len(operation.Value) > 0 {
+			if err := json.Unmarshal(operation.Value, &value); err != nil {
+				return BadRequest(ErrorInvalidSyntax, "invalid value of %s operation", op)
+			}
+		}
+		if op != "remove" && value == nil {
+			return BadRequest(ErrorInvalidValue, "%s operation needs a value", op)
+		}
+
+		if operation.Path == "" {
+			if op == "remove" {
+				return BadRequest(ErrorNoTarget, "remove operation needs a path")
+			}
+			attributes, ok := value.(map[string]interface{})
+			if !ok {
+				return BadRequest(ErrorInvalidValue, "%s operation without a path needs an object value", op)
+			}
+			for name, attributeValue := range attributes {
+				path, ok := parseAttributePath(name)
+				if !ok {
+					// Attributes of schema extensions are objects keyed by the schema URN
+					continue
+				}
+				if err := apply(resource, &Path{Attribute: path.attribute, SubAttribute: path.sub}, op, attributeValue); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		path, err := ParsePath(operation.Path)
+		if err != nil {
+			return err
+		}
+		if err := apply(resource, path, op, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply applies an operation to the target of a path
+func apply(resource map[string]interface{}, path *Path, op string, value interface{}) error {
+	key := attributeKey(resource, path.Attribute)
+	if path.Filter != nil {
+		return applyFiltered(resource, key, path, op, value)
+	}
+	current, exists := resource[key]
+
+	if path.SubAttribute != "" {
+		object, ok := current.(map[string]interface{})
+		if exists && current != nil && !ok {
+			return BadRequest(ErrorInvalidPath, "attribute %s has no sub-attributes", path.Attribute)
+		}
+		if op == "remove" {
+			if ok {
+				delete(object, attributeKey(object, path.SubAttribute))
+			}
+			return nil
+		}
+		if !ok {
+			object = map[string]interface{}{}
+			resource[key] = object
+		}
+		object[attributeKey(object, path.SubAttribute)] = value
+		return nil
+	}
+
+	switch op {
+	case "add":
+		switch current := current.(type) {
+		case []interface{}:
+			resource[key] = appendValues(current, value)
+		case map[string]interface{}:
+			merge(current, value)
+		default:
+			resource[key] = value
+		}
+	case "replace":
+		if current, ok := current.(map[string]interface{}); ok {
+			if values, ok := value.(map[string]interface{}); ok {
+				merge(current, values)
+				return nil
+			}
+		}
+		resource[key] = value
+	case "remove":
+		values, multiValued := current.([]interface{})
+		if value == nil || !multiValued {
+			delete(resource, key)
+			return nil
+		}
+		removed := valueList(value)
+		kept := values[:0]
+		for _, element := range values {
+			if !containsValue(removed, element) {
+				kept = append(kept, element)
+			}
+		}
+		resource[key] = kept
+	}
+	return nil
+}
+
+// applyFiltered applies an operation to the values of a multi-valued attribute matching the
+// filter of a path
+func applyFiltered(resource map[string]interface{}, key string, path *Path, op string, value interface{}) error {
+	values, _ := resource[key].([]interface{})
+	matched := false
+	kept := make([]interface{}, 0, len(values))
+	for _, element := range values {
+		object, ok := element.(map[string]interface{})
+		if !ok || !path.Filter.Matches(object) {
+			kept = append(kept, element)
+			continue
+		}
+		matched = true
+
+		switch {
+		case op == "remove" && path.SubAttribute == "":
+			continue
+		case op == "remove":
+			delete(object, attributeKey(object, path.SubAttribute))
+		case path.SubAttribute != "":
+			object[attributeKey(object, path.SubAttribute)] = value
+		case op == "add":
+			merge(object, value)
+		default:
+			replacement, ok := value.(map[string]interface{})
+			if !ok {
+				return BadRequest(ErrorInvalidValue, "values of %s are objects", path.Attribute)
+			}
+			element = replacement
+		}
+		kept = append(kept, element)
+	}
+
+	// Removing what is not there leaves nothing to do, but changing it is an error
+	if !matched && op != "remove" {
+		return BadRequest(ErrorNoTarget, "no value of %s matches the path", path.Attribute)
+	}
+	if matched {
+		resource[key] = kept
+	}
+	return nil
+}
+
+// attributeKey returns the key of an object holding an attribute, whose names are
+// case-insensitive, or the name itself for new attributes
+func attributeKey(object map[string]interface{}, name string) string {
+	if _, ok := object[name]; ok {
+		return name
+	}
+	for key := range object {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return name
+}
+
+// merge sets the attributes of value, if it is an object, in object
+func merge(object map[string]interface{}, value interface{}) {
+	attributes, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for name, attributeValue := range attributes {
+		object[attributeKey(object, name)] = attributeValue
+	}
+}
+
+// appendValues appends the values that are not in values yet
+func appendValues(values []interface{}, value interface{}) []interface{} {
+	for _, added := range valueList(value) {
+		if !containsValue(values, added) {
+			values = append(values, added)
+		}
+	}
+	return values
+}
+
+// valueList returns a value as a list of values
+func valueList(value interface{}) []interface{} {
+	if values, ok := value.([]interface{}); ok {
+		return values
+	}
+	return []interface{}{value}
+}
+
+// containsValue reports whether values hold value. Values of multi-valued complex attributes
+// are the same if they have the same value sub-attribute.
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, element := range values {
+		if sameValue(element, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameValue(a, b interface{}) bool {
+	objectA, okA := a.(map[string]interface{})
+	objectB, okB := b.(map[string]interface{})
+	if okA && okB {
+		valueA, hasA := lookup(objectA, "value")
+		valueB, hasB := lookup(objectB, "value")
+		if hasA && hasB {
+			return reflect.DeepEqual(valueA, valueB)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,285 @@
+// Package scim implements the protocol of SCIM 2.0 (RFC 7643 and RFC 7644), which identity
+// providers such as Okta and Keycloak use to provision users and groups.
+//
+// It holds the resources and messages of the protocol, parses filters and applies PATCH
+// operations. Resources are filtered and patched as generic JSON objects, so that both work with
+// any attribute a resource has. Mapping resources to OVIM users and organizations is left to the
+// API.
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContentType is the media type of SCIM requests and responses
+const ContentType = "application/scim+json"
+
+// Schemas of the resources and messages
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// Error types telling clients what was wrong with a request
+const (
+	ErrorInvalidFilter = "invalidFilter"
+	ErrorInvalidSyntax = "invalidSyntax"
+	ErrorInvalidPath   = "invalidPath"
+	ErrorInvalidValue  = "invalidValue"
+	ErrorNoTarget      = "noTarget"
+	ErrorMutability    = "mutability"
+	ErrorUniqueness    = "uniqueness"
+)
+
+// Meta holds the metadata of a resource
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location,omitempty"`
+	Version      string    `json:"version,omitempty"`
+}
+
+// Boolean is a boolean that also decodes from the strings "true" and "false" in any case, which
+// some identity providers send in PATCH operations
+type Boolean bool
+
+// UnmarshalJSON decodes a JSON boolean or a string holding one
+func (b *Boolean) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case bool:
+		*b = Boolean(v)
+		return nil
+	case string:
+		parsed, err := strconv.ParseBool(strings.ToLower(v))
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		*b = Boolean(parsed)
+		return nil
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+}
+
+// Email is an email address of a user
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// GroupRef is a group a user is a member of
+type GroupRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+// User is a SCIM user resource
+type User struct {
+	Schemas []string `json:"schemas"`
+	ID      string   `json:"id,omitempty"`
+	// ExternalID is the identifier of the user at the identity provider, such as its OIDC subject
+	ExternalID string     `json:"externalId,omitempty"`
+	UserName   string     `json:"userName"`
+	Emails     []Email    `json:"emails,omitempty"`
+	Active     *Boolean   `json:"active,omitempty"`
+	Groups     []GroupRef `json:"groups,omitempty"`
+	Meta       *Meta      `json:"meta,omitempty"`
+}
+
+// PrimaryEmail returns the primary email of the user, or its first one if none is primary
+func (u *User) PrimaryEmail() string {
+	for _, email := range u.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+// Member is a member of a group
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// Group is a SCIM group resource
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// ListResponse is a page of the resources matching a query
+type ListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// NewListResponse returns the page of resources starting at the 1-based startIndex with count
+// resources at most, out of the total resources matching the query
+func NewListResponse(resources []interface{}, total, startIndex int) *ListResponse {
+	if resources == nil {
+		resources = []interface{}{}
+	}
+	return &ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+// Page returns the bounds of the page of n resources starting at the 1-based startIndex with
+// count resources at most, as the startIndex and count query parameters give them. Indexes
+// below 1 are 1, and negative counts are 0.
+func Page(n, startIndex, count int) (int, int) {
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	start := min(startIndex-1, n)
+	end := min(start+max(count, 0), n)
+	return start, end
+}
+
+// Error is a SCIM error response. It is an error so that parsing and patching can return it
+// with the type clients expect.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// NewError returns an error response with an HTTP status, an error type if any and a detail
+func NewError(status int, scimType, detail string) *Error {
+	return &Error{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+// BadRequest returns a 400 error response of an error type
+func BadRequest(scimType, format string, args ...interface{}) *Error {
+	return NewError(http.StatusBadRequest, scimType, fmt.Sprintf(format, args...))
+}
+
+func (e *Error) Error() string {
+	if e.ScimType != "" {
+		return e.ScimType + ": " + e.Detail
+	}
+	return e.Detail
+}
+
+// StatusCode returns the HTTP status of the error
+func (e *Error) StatusCode() int {
+	status, err := strconv.Atoi(e.Status)
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
+// PatchRequest is a PATCH request, applying its operations in order
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation adds, replaces or removes the attributes of a path, or the attributes of its
+// value without a path
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ServiceProviderConfig tells clients which features of the protocol the service supports
+type ServiceProviderConfig struct {
+	Schemas               []string      `json:"schemas"`
+	Patch                 Supported     `json:"patch"`
+	Bulk                  BulkSupport   `json:"bulk"`
+	Filter                FilterSupport `json:"filter"`
+	ChangePassword        Supported     `json:"changePassword"`
+	Sort                  Supported     `json:"sort"`
+	ETag                  Supported     `json:"etag"`
+	AuthenticationSchemes []AuthScheme  `json:"authenticationSchemes"`
+}
+
+// Supported tells whether a feature is supported
+type Supported struct {
+	Supported bool `json:"supported"`
+}
+
+// BulkSupport tells whether bulk operations are supported and their limits
+type BulkSupport struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+// FilterSupport tells whether filters are supported and how many resources a query returns
+type FilterSupport struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+// AuthScheme is a way clients authenticate
+type AuthScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary,omitempty"`
+}
+
+// Attributes returns a resource as a generic JSON object, as filters and patches work on it
+func Attributes(resource interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	var attributes map[string]interface{}
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return nil, err
+	}
+	return attributes, nil
+}
+
+// Decode decodes a generic JSON object into a resource, returning an invalidValue error if an
+// attribute has a value of the wrong type
+func Decode(attributes map[string]interface{}, resource interface{}) error {
+	data, err := json.Marshal(attributes)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, resource); err != nil {
+		return BadRequest(ErrorInvalidValue, "invalid attribute value: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// alice is a user resource as a generic JSON object
+func alice(t *testing.T) map[string]interface{} {
+	active := Boolean(true)
+	attributes, err := Attributes(&User{
+		Schemas:  []string{SchemaUser},
+		ID:       "user-1",
+		UserName: "Alice",
+		Emails:   []Email{{Value: "alice@example.com", Type: "work", Primary: true}, {Value: "alice@home.example.com", Type: "home"}},
+		Active:   &active,
+		Groups:   []GroupRef{{Value: "org-1:org_admin"}},
+	})
+	require.NoError(t, err)
+	attributes["meta"] = map[string]interface{}{"lastModified": "2026-01-02T10:00:00Z"}
+	attributes["logins"] = float64(3)
+	return attributes
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		filter  string
+		matches bool
+	}{
+		{`userName eq "alice"`, true},
+		{`USERNAME Eq "ALICE"`, true},
+		{`userName eq "bob"`, false},
+		{`userName ne "bob"`, true},
+		{`userName co "lic"`, true},
+		{`userName sw "al"`, true},
+		{`userName ew "ce"`, true},
+		{`userName pr`, true},
+		{`displayName pr`, false},
+		{`displayName eq null`, true},
+		{`userName ne null`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "alice"`, true},
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`logins gt 2`, true},
+		{`logins le 2`, false},
+		{`emails eq "alice@home.example.com"`, true},
+		{`emails.value ew "@example.com"`, true},
+		{`emails[type eq "work" and value co "alice@"]`, true},
+		{`emails[type eq "home" and value eq "alice@example.com"]`, false},
+		{`groups.value eq "org-1:org_admin"`, true},
+		{`meta.lastModified gt "2026-01-01T00:00:00Z"`, true},
+		{`meta.lastModified ge "2026-01-02T11:00:00+01:00"`, true},
+		{`meta.lastModified lt "2026-01-02T10:00:00.5Z"`, true},
+		{`userName eq "bob" or active eq true`, true},
+		{`userName eq "bob" or userName eq "carol" and active eq true`, false},
+		{`(userName eq "bob" or userName eq "alice") and active eq true`, true},
+		{`not (userName eq "alice")`, false},
+		{`userName eq "a\"b"`, false},
+	}
+
+	resource := alice(t)
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			filter, err := ParseFilter(tt.filter)
+			require.NoError(t, err)
+			assert.Equal(t, tt.matches, filter.Matches(resource))
+		})
+	}
+}
+
+func TestParseFilter_Invalid(t *testing.T) {
+	for _, filter := range []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName is "alice"`,
+		`userName eq alice`,
+		`userName eq "alice`,
+		`userName eq "alice" and`,
+		`(userName eq "alice"`,
+		`emails[type eq "work"`,
+		`active co true`,
+		`logins sw 2`,
+		`1name eq "x"`,
+		`userName eq "alice" userName`,
+		`not userName eq "alice"`,
+		`name.givenName[value eq "x"]`,
+		`((((((((((((((((((((((((((((((((((userName pr))))))))))))))))))))))))))))))))))`,
+	} {
+		t.Run(filter, func(t *testing.T) {
+			_, err := ParseFilter(filter)
+			var scimErr *Error
+			require.ErrorAs(t, err, &scimErr)
+			assert.Equal(t, ErrorInvalidFilter, scimErr.ScimType)
+			assert.Equal(t, http.StatusBadRequest, scimErr.StatusCode())
+		})
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	path, err := ParsePath(`urn:ietf:params:scim:schemas:core:2.0:User:name.givenName`)
+	require.NoError(t, err)
+	assert.Equal(t, "name", path.Attribute)
+	assert.Equal(t, "givenName", path.SubAttribute)
+	assert.Nil(t, path.Filter)
+
+	path, err = ParsePath(`emails[type eq "work"].value`)
+	require.NoError(t, err)
+	assert.Equal(t, "emails", path.Attribute)
+	assert.Equal(t, "value", path.SubAttribute)
+	require.NotNil(t, path.Filter)
+	assert.True(t, path.Filter.Matches(map[string]interface{}{"type": "work"}))
+
+	for _, invalid := range []string{`emails[type eq "work"`, `emails[type eq]`, `emails[type eq "work"]value`, `name.given.name`, `emails.value[type eq "work"]`} {
+		_, err := ParsePath(invalid)
+		var scimErr *Error
+		require.ErrorAs(t, err, &scimErr, invalid)
+		assert.Equal(t, ErrorInvalidPath, scimErr.ScimType, invalid)
+	}
+}
+
+// operations decodes the operations of a PATCH request
+func operations(t *testing.T, body string) []PatchOperation {
+	var patch PatchRequest
+	require.NoError(t, json.Unmarshal([]byte(body), &patch))
+	return patch.Operations
+}
+
+func TestPatch(t *testing.T) {
+	t.Run("replace without a path", func(t *testing.T) {
+		resource := alice(t)
+		require.NoError(t, Patch(resource, operations(t, `{"Operations":[{"op":"replace","value":{"active":false,"userName":"alice2"}}]}`)))
+		assert.Equal(t, false, resource["active"])
+		assert.Equal(t, "alice2", resource["userName"])
+	})
+
+	t.Run("replace a path with a string boolean", func(t *testing.T) {
+		resource := alice(t)
+		require.NoError(t, Patch(resource, operations(t, `{"Operations":[{"op":"Replace","path":"active","value":"False"}]}`)))
+		var user User
+		require.NoError(t, Decode(resource, &user))
+		require.NotNil(t, user.Active)
+		assert.False(t, bool(*user.Active))
+	})
+
+	t.Run("replace a filtered sub-attribute", func(t *testing.T) {
+		resource := alice(t)
+		require.NoError(t, Patch(resource, operations(t, `{"Operations":[{"op":"replace","path":"emails[type eq \"work\"].value","value":"alice@corp.example.com"}]}`)))
+		var user User
+		require.NoError(t, Decode(resource, &user))
+		assert.Equal(t, "alice@corp.example.com", user.PrimaryEmail())
+		assert.Len(t, user.Emails, 2)
+
+		err := Patch(resource, operations(t, `{"Operations":[{"op":"replace","path":"emails[type eq \"other\"].value","value":"x@example.com"}]}`))
+		var scimErr *Error
+		require.ErrorAs(t, err, &scimErr)
+		assert.Equal(t, ErrorNoTarget, scimErr.ScimType)
+	})
+
+	t.Run("add and remove members", func(t *testing.T) {
+		resource := map[string]interface{}{"displayName": "org-1:org_user", "members": []interface{}{map[string]interface{}{"value": "user-1"}}}
+		require.NoError(t, Patch(resource, operations(t, `{"Operations":[
+			{"op":"add","path":"members","value":[{"value":"user-1"},{"value":"user-2"},{"value":"user-3"}]},
+			{"op":"remove","path":"members[value eq \"user-2\"]"},
+			{"op":"remove","path":"members[value eq \"user-9\"]"},
+			{"op":"Remove","path":"members","value":[{"value":"user-3"}]}
+		]}`)))
+		var group Group
+		require.NoError(t, Decode(resource, &group))
+		assert.Equal(t, []Member{{Value: "user-1"}}, group.Members)
+
+		require.NoError(t, Patch(resource, operations(t, `{"Operations":[{"op":"remove","path":"members"}]}`)))
+		assert.NotContains(t, resource, "members")
+	})
+
+	t.Run("add to a new complex attribute", func(t *testing.T) {
+		resource := alice(t)
+		require.NoError(t, Patch(resource, operations(t, `{"Operations":[{"op":"add","path":"name.givenName","value":"Alice"},{"op":"add","value":{"name":{"familyName":"Smith"}}}]}`)))
+		assert.Equal(t, map[string]interface{}{"givenName": "Alice", "familyName": "Smith"}, resource["name"])
+	})
+
+	t.Run("invalid operations", func(t *testing.T) {
+		for body, scimType := range map[string]string{
+			`{"Operations":[{"op":"move","path":"active","value":true}]}`:     ErrorInvalidSyntax,
+			`{"Operations":[{"op":"remove"}]}`:                                ErrorNoTarget,
+			`{"Operations":[{"op":"replace","path":"active"}]}`:               ErrorInvalidValue,
+			`{"Operations":[{"op":"replace","value":"alice"}]}`:               ErrorInvalidValue,
+			`{"Operations":[{"op":"replace","path":"active[","value":1}]}`:    ErrorInvalidPath,
+			`{"Operations":[{"op":"replace","path":"userName.x","value":1}]}`: ErrorInvalidPath,
+		} {
+			err := Patch(alice(t), operations(t, body))
+			var scimErr *Error
+			require.ErrorAs(t, err, &scimErr, body)
+			assert.Equal(t, scimType, scimErr.ScimType, body)
+		}
+
+		var user User
+		resource := alice(t)
+		require.NoError(t, Patch(resource, operations(t, `{"Operations":[{"op":"replace","path":"active","value":"maybe"}]}`)))
+		err := Decode(resource, &user)
+		var scimErr *Error
+		require.ErrorAs(t, err, &scimErr)
+		assert.Equal(t, ErrorInvalidValue, scimErr.ScimType)
+	})
+}
+
+func TestPage(t *testing.T) {
+	tests := []struct {
+		n, startIndex, count int
+		start, end           int
+	}{
+		{10, 1, 200, 0, 10},
+		{10, 3, 4, 2, 6},
+		{10, 0, 4, 0, 4},
+		{10, 9, 5, 8, 10},
+		{10, 20, 5, 10, 10},
+		{10, 1, -1, 0, 0},
+	}
+	for _, tt := range tests {
+		start, end := Page(tt.n, tt.startIndex, tt.count)
+		assert.Equal(t, tt.start, start)
+		assert.Equal(t, tt.end, end)
+	}
+
+	response := NewListResponse(nil, 0, 1)
+	data, err := json.Marshal(response)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"schemas":["`+SchemaListResponse+`"],"totalResults":0,"startIndex":1,"itemsPerPage":0,"Resources":[]}`, string(data))
+}
@@ -1,22 +1,67 @@
 package storage
 
 import (
+	"context"
+	"time"
+
 	"github.com/eliorerz/ovim-updated/pkg/models"
 )
 
-// Storage defines the interface for data storage operations
+// Storage defines the interface for data storage operations.
+// The Query* operations return one page of results matching the given ListOptions
+// along with the cursor of the next page, which is empty on the last page, and the number of
+// results matching the filters across all pages.
+//
+// Users, organizations, VDCs, catalogs, templates and VMs carry a ResourceVersion that starts at 1
+// and is incremented by every update. Update* operations given a non-zero ResourceVersion
+// fail with ErrConflict if the stored version differs, and set the new version on success.
+//
+// Deleting an organization, VDC or VM only sets its DeletedAt tombstone. Deleted records are
+// hidden from every other operation until they are restored or purged. Purging an organization
+// also purges its VDCs and catalogs.
+//
+// Catalogs belong to an existing organization and their names are unique within it. A template
+// may reference a catalog through its CatalogID, which must name an existing catalog, otherwise
+// Create* and Update* return ErrInvalidInput. Deleting a catalog keeps its templates and clears
+// their CatalogID.
+//
+// List* operations return records oldest first, with the ID breaking ties, and return an empty
+// slice rather than nil when nothing matches. Get*, Update*, Delete*, Restore* and Purge* return
+// ErrNotFound for unknown IDs. Create* and Update* return ErrInvalidInput for a nil record or an
+// empty ID, and ErrAlreadyExists when the ID, or the username or email of a user, is taken.
+// Returned records are copies, changing them has no effect until they are passed to an update.
+//
+// Refresh tokens belong to a login session of a user. Revoking a session, or every token of a
+// user, revokes its refresh tokens and adds the access tokens issued with them that have not
+// expired to the revoked tokens. Revoking every token of a user also ends the impersonations
+// of the user and those by the user, and revokes their tokens. Deleting a user deletes its refresh tokens but keeps the
+// revoked tokens, which are only removed by PurgeExpiredTokens once they expired.
+//
+// Watch reports every committed change, including changes made by other servers sharing the
+// database. Deleting a record is reported as deleted and restoring it as created. Purging reports
+// deleted only for records that were not in the trash. Changes made in a transaction are reported
+// once it commits. A watch channel is closed when its context is done, when the watcher falls too
+// far behind or when events may have been lost; the watcher then lists again and starts a new watch.
+//
+// The storagetest package checks this contract and every implementation must pass it.
 type Storage interface {
 	// User operations
 	ListUsers() ([]*models.User, error)
 	ListUsersByOrg(orgID string) ([]*models.User, error)
+	QueryUsers(opts ListOptions) ([]*models.User, string, int, error)
 	GetUserByUsername(username string) (*models.User, error)
 	GetUserByID(id string) (*models.User, error)
+	// GetUserByExternalID returns the user of a subject of an identity source, such as the DN of
+	// an LDAP entry
+	GetUserByExternalID(source, externalID string) (*models.User, error)
+	// CreateUser makes users without an identity source local users
 	CreateUser(user *models.User) error
 	UpdateUser(user *models.User) error
 	DeleteUser(id string) error
 
 	// Organization operations
 	ListOrganizations() ([]*models.Organization, error)
+	QueryOrganizations(opts ListOptions) ([]*models.Organization, string, int, error)
 	GetOrganization(id string) (*models.Organization, error)
 	CreateOrganization(org *models.Organization) error
 	UpdateOrganization(org *models.Organization) error
@@ -24,14 +69,23 @@ type Storage interface {
 
 	// VDC operations
 	ListVDCs(orgID string) ([]*models.VirtualDataCenter, error)
+	QueryVDCs(opts ListOptions) ([]*models.VirtualDataCenter, string, int, error)
 	GetVDC(id string) (*models.VirtualDataCenter, error)
 	CreateVDC(vdc *models.VirtualDataCenter) error
 	UpdateVDC(vdc *models.VirtualDataCenter) error
 	DeleteVDC(id string) error
 
+	// Catalog operations. ListCatalogs returns the catalogs of every organization for an empty orgID.
+	ListCatalogs(orgID string) ([]*models.Catalog, error)
+	GetCatalog(id string) (*models.Catalog, error)
+	CreateCatalog(catalog *models.Catalog) error
+	UpdateCatalog(catalog *models.Catalog) error
+	DeleteCatalog(id string) error
+
 	// Template operations
 	ListTemplates() ([]*models.Template, error)
 	ListTemplatesByOrg(orgID string) ([]*models.Template, error)
+	QueryTemplates(opts ListOptions) ([]*models.Template, string, int, error)
 	GetTemplate(id string) (*models.Template, error)
 	CreateTemplate(template *models.Template) error
 	UpdateTemplate(template *models.Template) error
@@ -39,6 +93,7 @@ type Storage interface {
 
 	// VM operations
 	ListVMs(orgID string) ([]*models.VirtualMachine, error)
+	QueryVMs(opts ListOptions) ([]*models.VirtualMachine, string, int, error)
 	GetVM(id string) (*models.VirtualMachine, error)
 	CreateVM(vm *models.VirtualMachine) error
 	UpdateVM(vm *models.VirtualMachine) error
@@ -51,6 +106,115 @@ type Storage interface {
 	UpdateOrganizationCatalogSource(source *models.OrganizationCatalogSource) error
 	DeleteOrganizationCatalogSource(id string) error
 
+	// Trash operations. ListDeleted* return deleted records, oldest deletion first. Restore*
+	// returns ErrNotFound unless the record is deleted, Purge* removes a record permanently.
+	ListDeletedOrganizations() ([]*models.Organization, error)
+	RestoreOrganization(id string) error
+	PurgeOrganization(id string) error
+	ListDeletedVDCs() ([]*models.VirtualDataCenter, error)
+	RestoreVDC(id string) error
+	PurgeVDC(id string) error
+	ListDeletedVMs() ([]*models.VirtualMachine, error)
+	RestoreVM(id string) error
+	PurgeVM(id string) error
+
+	// Token operations. GetRefreshTokenByHash returns revoked and expired tokens too.
+	// RevokeRefreshToken returns ErrConflict if the token is revoked already. Revoking a session
+	// or the tokens of a user that has none is not an error, nor is revoking a token twice.
+	// PurgeExpiredTokens removes refresh, revoked and password reset tokens that expired before
+	// the given time.
+	CreateRefreshToken(token *models.RefreshToken) error
+	GetRefreshTokenByHash(hash string) (*models.RefreshToken, error)
+	RevokeRefreshToken(id string) error
+	RevokeSession(sessionID string) error
+	RevokeUserTokens(userID string) error
+	RevokeToken(token *models.RevokedToken) error
+	IsTokenRevoked(id string) (bool, error)
+	PurgeExpiredTokens(before time.Time) (int, error)
+
+	// Password reset token operations. Tokens belong to an existing user and are deleted with it.
+	// GetPasswordResetTokenByHash returns used and expired tokens too. UsePasswordResetToken
+	// returns ErrConflict if the token was used already.
+	CreatePasswordResetToken(token *models.PasswordResetToken) error
+	GetPasswordResetTokenByHash(hash string) (*models.PasswordResetToken, error)
+	UsePasswordResetToken(id string, usedAt time.Time) error
+
+	// API token operations. ListAPITokens returns the tokens of every user for an empty userID.
+	// Token names are unique per user and tokens are deleted with their user. UpdateAPIToken
+	// changes the name, scopes and expiry of a token, TouchAPIToken only its last use.
+	CreateAPIToken(token *models.APIToken) error
+	ListAPITokens(userID string) ([]*models.APIToken, error)
+	GetAPIToken(id string) (*models.APIToken, error)
+	GetAPITokenByHash(hash string) (*models.APIToken, error)
+	UpdateAPIToken(token *models.APIToken) error
+	TouchAPIToken(id string, usedAt time.Time) error
+	DeleteAPIToken(id string) error
+
+	// Custom role operations. Roles belong to an existing organization, their names are unique
+	// within it and they are purged with it. UpdateOrgRole changes the name, description and verbs.
+	ListOrgRoles(orgID string) ([]*models.OrgRole, error)
+	GetOrgRole(id string) (*models.OrgRole, error)
+	GetOrgRoleByName(orgID, name string) (*models.OrgRole, error)
+	CreateOrgRole(role *models.OrgRole) error
+	UpdateOrgRole(role *models.OrgRole) error
+	DeleteOrgRole(id string) error
+
+	// Organization membership operations. Memberships belong to an existing user and organization
+	// and go away with either; a user is a member of an organization at most once, otherwise
+	// ErrAlreadyExists is returned. ListOrgMemberships filters by user and organization, an empty
+	// ID matching any. UpdateOrgMembership changes the role only.
+	CreateOrgMembership(membership *models.OrgMembership) error
+	GetOrgMembership(userID, orgID string) (*models.OrgMembership, error)
+	ListOrgMemberships(userID, orgID string) ([]*models.OrgMembership, error)
+	UpdateOrgMembership(membership *models.OrgMembership) error
+	DeleteOrgMembership(userID, orgID string) error
+
+	// Impersonation operations. Impersonations belong to an existing user, the one impersonated,
+	// and are deleted with it. ListImpersonations returns those of every user for an empty
+	// userID. EndImpersonation returns ErrConflict if the impersonation ended already.
+	CreateImpersonation(impersonation *models.Impersonation) error
+	ListImpersonations(userID string) ([]*models.Impersonation, error)
+	GetImpersonation(id string) (*models.Impersonation, error)
+	EndImpersonation(id string, endedAt time.Time) error
+
+	// Invitation operations. Invitations belong to an existing organization and are deleted with
+	// it. ListInvitations returns those of every organization for an empty orgID.
+	// RenewInvitation replaces the token of a pending invitation, AcceptInvitation records the
+	// user who accepted it and RevokeInvitation cancels it; the three return ErrConflict if the
+	// invitation was accepted or revoked already.
+	CreateInvitation(invitation *models.Invitation) error
+	GetInvitation(id string) (*models.Invitation, error)
+	ListInvitations(orgID string) ([]*models.Invitation, error)
+	RenewInvitation(id, tokenID string, expiresAt time.Time) error
+	AcceptInvitation(id, userID string, acceptedAt time.Time) error
+	RevokeInvitation(id string, revokedAt time.Time) error
+
+	// Audit record operations. Records are append-only and reference no other record.
+	// CreateAuditRecord keeps the CreatedAt of the record, or sets it when zero.
+	// QueryAuditRecords filters by organization, actor, resource type, resource ID and creation
+	// time, and sorts by id or created_at.
+	CreateAuditRecord(record *models.AuditRecord) error
+	QueryAuditRecords(opts ListOptions) ([]*models.AuditRecord, string, int, error)
+
+	// Login attempt operations. RecordLoginFailure atomically adds a failure at the given time and
+	// returns the updated attempt; an attempt whose last failure is before since, or whose lock
+	// ended by at, starts over at one failure without a lock. LockLogin returns ErrNotFound for an
+	// unknown ID, ResetLoginAttempts does not. PurgeLoginAttempts removes the attempts whose last
+	// failure and lock both ended before the given time.
+	GetLoginAttempt(id string) (*models.LoginAttempt, error)
+	RecordLoginFailure(id string, at, since time.Time) (*models.LoginAttempt, error)
+	LockLogin(id string, until time.Time) error
+	ResetLoginAttempts(id string) error
+	PurgeLoginAttempts(before time.Time) (int, error)
+
+	// Watch returns a channel receiving an event for every change committed after it returns
+	Watch(ctx context.Context) (<-chan ChangeEvent, error)
+
+	// WithTx runs fn atomically: either all writes made through tx are applied or none is.
+	// The error returned by fn is returned unchanged after rolling back. Transactions hold locks
+	// until fn returns, so fn must not wait on anything but the storage, such as Kubernetes calls.
+	WithTx(fn func(tx Storage) error) error
+
 	// Health check
 	Ping() error
 	Close() error
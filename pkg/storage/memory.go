@@ -1,8 +1,11 @@
 package storage
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"sort"
 	"sync"
 	"time"
 
@@ -16,6 +19,13 @@ var (
 	ErrNotFound      = errors.New("resource not found")
 	ErrAlreadyExists = errors.New("resource already exists")
 	ErrInvalidInput  = errors.New("invalid input")
+	ErrConflict      = errors.New("resource version conflict")
+)
+
+// The admin seeded into empty storage, whose password has to be changed at the first login
+const (
+	seedAdminID       = "user-admin"
+	seedAdminPassword = "adminpassword"
 )
 
 // MemoryStorage implements the Storage interface using in-memory storage
@@ -23,10 +33,26 @@ type MemoryStorage struct {
 	users          map[string]*models.User
 	organizations  map[string]*models.Organization
 	vdcs           map[string]*models.VirtualDataCenter
+	catalogs       map[string]*models.Catalog
 	templates      map[string]*models.Template
 	vms            map[string]*models.VirtualMachine
 	catalogSources map[string]*models.OrganizationCatalogSource
+	refreshTokens  map[string]*models.RefreshToken
+	revokedTokens  map[string]*models.RevokedToken
+	apiTokens      map[string]*models.APIToken
+	orgRoles       map[string]*models.OrgRole
+	loginAttempts  map[string]*models.LoginAttempt
+	resetTokens    map[string]*models.PasswordResetToken
+	memberships    map[string]*models.OrgMembership
+	impersonations map[string]*models.Impersonation
+	invitations    map[string]*models.Invitation
+	auditRecords   map[string]*models.AuditRecord
 	mutex          sync.RWMutex
+
+	// changes is shared with transactions, which queue their events in pending until they commit
+	changes *changeHub
+	inTx    bool
+	pending []ChangeEvent
 }
 
 // NewMemoryStorage creates a new in-memory storage instance
@@ -35,9 +61,21 @@ func NewMemoryStorage() (Storage, error) {
 		users:          make(map[string]*models.User),
 		organizations:  make(map[string]*models.Organization),
 		vdcs:           make(map[string]*models.VirtualDataCenter),
+		catalogs:       make(map[string]*models.Catalog),
 		templates:      make(map[string]*models.Template),
 		vms:            make(map[string]*models.VirtualMachine),
 		catalogSources: make(map[string]*models.OrganizationCatalogSource),
+		refreshTokens:  make(map[string]*models.RefreshToken),
+		revokedTokens:  make(map[string]*models.RevokedToken),
+		apiTokens:      make(map[string]*models.APIToken),
+		orgRoles:       make(map[string]*models.OrgRole),
+		loginAttempts:  make(map[string]*models.LoginAttempt),
+		resetTokens:    make(map[string]*models.PasswordResetToken),
+		memberships:    make(map[string]*models.OrgMembership),
+		impersonations: make(map[string]*models.Impersonation),
+		invitations:    make(map[string]*models.Invitation),
+		auditRecords:   make(map[string]*models.AuditRecord),
+		changes:        newChangeHub(),
 	}
 
 	if err := storage.seedData(); err != nil {
@@ -50,7 +88,7 @@ func NewMemoryStorage() (Storage, error) {
 
 // seedData populates the storage with initial test data
 func (s *MemoryStorage) seedData() error {
-	adminHash, err := auth.HashPassword("adminpassword")
+	adminHash, err := auth.HashPassword(seedAdminPassword)
 	if err != nil {
 		return fmt.Errorf("failed to hash admin password: %w", err)
 	}
@@ -60,13 +98,17 @@ func (s *MemoryStorage) seedData() error {
 	// Seed users
 	users := []*models.User{
 		{
-			ID:           "user-admin",
-			Username:     "admin",
-			Email:        "admin@ovim.local",
-			PasswordHash: adminHash,
-			Role:         models.RoleSystemAdmin,
-			CreatedAt:    now,
-			UpdatedAt:    now,
+			ID:              seedAdminID,
+			Username:        "admin",
+			Email:           "admin@ovim.local",
+			PasswordHash:    adminHash,
+			Role:            models.RoleSystemAdmin,
+			AuthSource:      models.AuthSourceLocal,
+			CreatedAt:       now,
+			UpdatedAt:       now,
+			ResourceVersion: 1,
+			// The well-known seed password has to be replaced at the first login
+			MustChangePassword: true,
 		},
 	}
 
@@ -92,7 +134,7 @@ func (s *MemoryStorage) GetUserByUsername(username string) (*models.User, error)
 
 	for _, user := range s.users {
 		if user.Username == username {
-			return user, nil
+			return clone(user), nil
 		}
 	}
 	return nil, ErrNotFound
@@ -106,24 +148,41 @@ func (s *MemoryStorage) GetUserByID(id string) (*models.User, error) {
 	if !exists {
 		return nil, ErrNotFound
 	}
-	return user, nil
+	return clone(user), nil
+}
+
+func (s *MemoryStorage) GetUserByExternalID(source, externalID string) (*models.User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, user := range s.users {
+		if externalID != "" && user.AuthSource == source && user.ExternalID == externalID {
+			return clone(user), nil
+		}
+	}
+	return nil, ErrNotFound
 }
 
 func (s *MemoryStorage) CreateUser(user *models.User) error {
 	if user == nil || user.ID == "" {
 		return ErrInvalidInput
 	}
+	if user.AuthSource == "" {
+		user.AuthSource = models.AuthSourceLocal
+	}
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if _, exists := s.users[user.ID]; exists {
+	if _, exists := s.users[user.ID]; exists || s.userTaken(user) {
 		return ErrAlreadyExists
 	}
 
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = user.CreatedAt
-	s.users[user.ID] = user
+	user.ResourceVersion = 1
+	s.users[user.ID] = clone(user)
+	s.notify(userEvent(ChangeCreated, user))
 	return nil
 }
 
@@ -135,24 +194,83 @@ func (s *MemoryStorage) UpdateUser(user *models.User) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if _, exists := s.users[user.ID]; !exists {
+	stored, exists := s.users[user.ID]
+	if !exists {
 		return ErrNotFound
 	}
+	version, err := nextVersion(user.ResourceVersion, stored.ResourceVersion)
+	if err != nil {
+		return err
+	}
+	if s.userTaken(user) {
+		return ErrAlreadyExists
+	}
 
 	user.UpdatedAt = time.Now()
-	s.users[user.ID] = user
+	user.ResourceVersion = version
+	s.users[user.ID] = clone(user)
+	s.notify(userEvent(ChangeUpdated, user))
 	return nil
 }
 
+// userTaken reports whether another user already has the username, email or identity of user
+func (s *MemoryStorage) userTaken(user *models.User) bool {
+	for _, other := range s.users {
+		if other.ID == user.ID {
+			continue
+		}
+		if other.Username == user.Username || other.Email == user.Email ||
+			(user.ExternalID != "" && other.AuthSource == user.AuthSource && other.ExternalID == user.ExternalID) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *MemoryStorage) DeleteUser(id string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if _, exists := s.users[id]; !exists {
+	stored, exists := s.users[id]
+	if !exists {
 		return ErrNotFound
 	}
 
 	delete(s.users, id)
+	for tokenID, token := range s.refreshTokens {
+		if token.UserID == id {
+			delete(s.refreshTokens, tokenID)
+		}
+	}
+	for tokenID, token := range s.apiTokens {
+		if token.UserID == id {
+			delete(s.apiTokens, tokenID)
+		}
+	}
+	for tokenID, token := range s.resetTokens {
+		if token.UserID == id {
+			delete(s.resetTokens, tokenID)
+		}
+	}
+	for membershipID, membership := range s.memberships {
+		if membership.UserID == id {
+			delete(s.memberships, membershipID)
+		}
+	}
+	for impersonationID, impersonation := range s.impersonations {
+		if impersonation.UserID == id {
+			delete(s.impersonations, impersonationID)
+		}
+	}
+	// Accepted invitations outlive the user who accepted them, as with ON DELETE SET NULL
+	for invitationID, invitation := range s.invitations {
+		if invitation.UserID != nil && *invitation.UserID == id {
+			unlinked := clone(invitation)
+			unlinked.UserID = nil
+			s.invitations[invitationID] = unlinked
+		}
+	}
+	s.notify(userEvent(ChangeDeleted, stored))
 	return nil
 }
 
@@ -162,8 +280,9 @@ func (s *MemoryStorage) ListUsers() ([]*models.User, error) {
 
 	users := make([]*models.User, 0, len(s.users))
 	for _, user := range s.users {
-		users = append(users, user)
+		users = append(users, clone(user))
 	}
+	sortByCreation(users, func(u *models.User) (time.Time, string) { return u.CreatedAt, u.ID })
 	return users, nil
 }
 
@@ -174,12 +293,56 @@ func (s *MemoryStorage) ListUsersByOrg(orgID string) ([]*models.User, error) {
 	users := make([]*models.User, 0)
 	for _, user := range s.users {
 		if user.OrgID != nil && *user.OrgID == orgID {
-			users = append(users, user)
+			users = append(users, clone(user))
 		}
 	}
+	sortByCreation(users, func(u *models.User) (time.Time, string) { return u.CreatedAt, u.ID })
 	return users, nil
 }
 
+func (s *MemoryStorage) QueryUsers(opts ListOptions) ([]*models.User, string, int, error) {
+	page, err := opts.resolve(userSortColumns)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	users := make([]*models.User, 0)
+	for _, user := range s.users {
+		if opts.OrgID != "" && (user.OrgID == nil || *user.OrgID != opts.OrgID) {
+			continue
+		}
+		if opts.Role != "" && user.Role != opts.Role {
+			continue
+		}
+		if !matchesName(user.Username, opts.Name) {
+			continue
+		}
+		users = append(users, clone(user))
+	}
+
+	key := func(u *models.User) string {
+		switch page.field {
+		case "name":
+			return u.Username
+		case "updated_at":
+			return timeSortKey(u.UpdatedAt)
+		case "id":
+			return u.ID
+		default:
+			return timeSortKey(u.CreatedAt)
+		}
+	}
+	sort.SliceStable(users, func(i, j int) bool {
+		return page.less(key(users[i]), users[i].ID, key(users[j]), users[j].ID)
+	})
+
+	start, end, next := page.window(len(users))
+	return users[start:end], next, len(users), nil
+}
+
 // Organization operations
 func (s *MemoryStorage) ListOrganizations() ([]*models.Organization, error) {
 	s.mutex.RLock()
@@ -187,20 +350,74 @@ func (s *MemoryStorage) ListOrganizations() ([]*models.Organization, error) {
 
 	orgs := make([]*models.Organization, 0, len(s.organizations))
 	for _, org := range s.organizations {
-		orgs = append(orgs, org)
+		if org.DeletedAt != nil {
+			continue
+		}
+		orgs = append(orgs, clone(org))
 	}
+	sortByCreation(orgs, func(o *models.Organization) (time.Time, string) { return o.CreatedAt, o.ID })
 	return orgs, nil
 }
 
+func (s *MemoryStorage) QueryOrganizations(opts ListOptions) ([]*models.Organization, string, int, error) {
+	page, err := opts.resolve(organizationSortColumns)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	enabled, filterEnabled, err := orgEnabledFilter(opts.Status)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	orgs := make([]*models.Organization, 0)
+	for _, org := range s.organizations {
+		if org.DeletedAt != nil {
+			continue
+		}
+		if opts.OrgID != "" && org.ID != opts.OrgID {
+			continue
+		}
+		if filterEnabled && org.IsEnabled != enabled {
+			continue
+		}
+		if !matchesName(org.Name, opts.Name) {
+			continue
+		}
+		orgs = append(orgs, clone(org))
+	}
+
+	key := func(o *models.Organization) string {
+		switch page.field {
+		case "name":
+			return o.Name
+		case "updated_at":
+			return timeSortKey(o.UpdatedAt)
+		case "id":
+			return o.ID
+		default:
+			return timeSortKey(o.CreatedAt)
+		}
+	}
+	sort.SliceStable(orgs, func(i, j int) bool {
+		return page.less(key(orgs[i]), orgs[i].ID, key(orgs[j]), orgs[j].ID)
+	})
+
+	start, end, next := page.window(len(orgs))
+	return orgs[start:end], next, len(orgs), nil
+}
+
 func (s *MemoryStorage) GetOrganization(id string) (*models.Organization, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
 	org, exists := s.organizations[id]
-	if !exists {
+	if !exists || org.DeletedAt != nil {
 		return nil, ErrNotFound
 	}
-	return org, nil
+	return clone(org), nil
 }
 
 func (s *MemoryStorage) CreateOrganization(org *models.Organization) error {
@@ -217,7 +434,9 @@ func (s *MemoryStorage) CreateOrganization(org *models.Organization) error {
 
 	org.CreatedAt = time.Now()
 	org.UpdatedAt = org.CreatedAt
-	s.organizations[org.ID] = org
+	org.ResourceVersion = 1
+	s.organizations[org.ID] = clone(org)
+	s.notify(organizationEvent(ChangeCreated, org))
 	return nil
 }
 
@@ -229,12 +448,19 @@ func (s *MemoryStorage) UpdateOrganization(org *models.Organization) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if _, exists := s.organizations[org.ID]; !exists {
+	stored, exists := s.organizations[org.ID]
+	if !exists || stored.DeletedAt != nil {
 		return ErrNotFound
 	}
+	version, err := nextVersion(org.ResourceVersion, stored.ResourceVersion)
+	if err != nil {
+		return err
+	}
 
 	org.UpdatedAt = time.Now()
-	s.organizations[org.ID] = org
+	org.ResourceVersion = version
+	s.organizations[org.ID] = clone(org)
+	s.notify(organizationEvent(ChangeUpdated, org))
 	return nil
 }
 
@@ -242,11 +468,18 @@ func (s *MemoryStorage) DeleteOrganization(id string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if _, exists := s.organizations[id]; !exists {
+	stored, exists := s.organizations[id]
+	if !exists || stored.DeletedAt != nil {
 		return ErrNotFound
 	}
 
-	delete(s.organizations, id)
+	now := time.Now()
+	deleted := clone(stored)
+	deleted.DeletedAt = &now
+	deleted.UpdatedAt = now
+	deleted.ResourceVersion++
+	s.organizations[id] = deleted
+	s.notify(organizationEvent(ChangeDeleted, deleted))
 	return nil
 }
 
@@ -257,22 +490,74 @@ func (s *MemoryStorage) ListVDCs(orgID string) ([]*models.VirtualDataCenter, err
 
 	vdcs := make([]*models.VirtualDataCenter, 0)
 	for _, vdc := range s.vdcs {
+		if vdc.DeletedAt != nil {
+			continue
+		}
 		if orgID == "" || vdc.OrgID == orgID {
-			vdcs = append(vdcs, vdc)
+			vdcs = append(vdcs, clone(vdc))
 		}
 	}
+	sortByCreation(vdcs, func(v *models.VirtualDataCenter) (time.Time, string) { return v.CreatedAt, v.ID })
 	return vdcs, nil
 }
 
+func (s *MemoryStorage) QueryVDCs(opts ListOptions) ([]*models.VirtualDataCenter, string, int, error) {
+	page, err := opts.resolve(vdcSortColumns)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	vdcs := make([]*models.VirtualDataCenter, 0)
+	for _, vdc := range s.vdcs {
+		if vdc.DeletedAt != nil {
+			continue
+		}
+		if opts.OrgID != "" && vdc.OrgID != opts.OrgID {
+			continue
+		}
+		if opts.Status != "" && vdc.Phase != opts.Status {
+			continue
+		}
+		if !matchesName(vdc.Name, opts.Name) {
+			continue
+		}
+		vdcs = append(vdcs, clone(vdc))
+	}
+
+	key := func(v *models.VirtualDataCenter) string {
+		switch page.field {
+		case "name":
+			return v.Name
+		case "status":
+			return v.Phase
+		case "updated_at":
+			return timeSortKey(v.UpdatedAt)
+		case "id":
+			return v.ID
+		default:
+			return timeSortKey(v.CreatedAt)
+		}
+	}
+	sort.SliceStable(vdcs, func(i, j int) bool {
+		return page.less(key(vdcs[i]), vdcs[i].ID, key(vdcs[j]), vdcs[j].ID)
+	})
+
+	start, end, next := page.window(len(vdcs))
+	return vdcs[start:end], next, len(vdcs), nil
+}
+
 func (s *MemoryStorage) GetVDC(id string) (*models.VirtualDataCenter, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
 	vdc, exists := s.vdcs[id]
-	if !exists {
+	if !exists || vdc.DeletedAt != nil {
 		return nil, ErrNotFound
 	}
-	return vdc, nil
+	return clone(vdc), nil
 }
 
 func (s *MemoryStorage) CreateVDC(vdc *models.VirtualDataCenter) error {
@@ -289,7 +574,9 @@ func (s *MemoryStorage) CreateVDC(vdc *models.VirtualDataCenter) error {
 
 	vdc.CreatedAt = time.Now()
 	vdc.UpdatedAt = vdc.CreatedAt
-	s.vdcs[vdc.ID] = vdc
+	vdc.ResourceVersion = 1
+	s.vdcs[vdc.ID] = clone(vdc)
+	s.notify(vdcEvent(ChangeCreated, vdc))
 	return nil
 }
 
@@ -301,12 +588,19 @@ func (s *MemoryStorage) UpdateVDC(vdc *models.VirtualDataCenter) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if _, exists := s.vdcs[vdc.ID]; !exists {
+	stored, exists := s.vdcs[vdc.ID]
+	if !exists || stored.DeletedAt != nil {
 		return ErrNotFound
 	}
+	version, err := nextVersion(vdc.ResourceVersion, stored.ResourceVersion)
+	if err != nil {
+		return err
+	}
 
 	vdc.UpdatedAt = time.Now()
-	s.vdcs[vdc.ID] = vdc
+	vdc.ResourceVersion = version
+	s.vdcs[vdc.ID] = clone(vdc)
+	s.notify(vdcEvent(ChangeUpdated, vdc))
 	return nil
 }
 
@@ -314,14 +608,158 @@ func (s *MemoryStorage) DeleteVDC(id string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if _, exists := s.vdcs[id]; !exists {
+	stored, exists := s.vdcs[id]
+	if !exists || stored.DeletedAt != nil {
 		return ErrNotFound
 	}
 
-	delete(s.vdcs, id)
+	now := time.Now()
+	deleted := clone(stored)
+	deleted.DeletedAt = &now
+	deleted.UpdatedAt = now
+	deleted.ResourceVersion++
+	s.vdcs[id] = deleted
+	s.notify(vdcEvent(ChangeDeleted, deleted))
+	return nil
+}
+
+// Catalog operations
+func (s *MemoryStorage) ListCatalogs(orgID string) ([]*models.Catalog, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	catalogs := make([]*models.Catalog, 0)
+	for _, catalog := range s.catalogs {
+		if orgID == "" || catalog.OrgID == orgID {
+			catalogs = append(catalogs, clone(catalog))
+		}
+	}
+	sortByCreation(catalogs, func(c *models.Catalog) (time.Time, string) { return c.CreatedAt, c.ID })
+	return catalogs, nil
+}
+
+func (s *MemoryStorage) GetCatalog(id string) (*models.Catalog, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	catalog, exists := s.catalogs[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return clone(catalog), nil
+}
+
+func (s *MemoryStorage) CreateCatalog(catalog *models.Catalog) error {
+	if catalog == nil || catalog.ID == "" {
+		return ErrInvalidInput
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.catalogs[catalog.ID]; exists {
+		return ErrAlreadyExists
+	}
+	if _, exists := s.organizations[catalog.OrgID]; !exists {
+		return ErrInvalidInput
+	}
+	if s.catalogNameTaken(catalog) {
+		return ErrAlreadyExists
+	}
+
+	catalog.CreatedAt = time.Now()
+	catalog.UpdatedAt = catalog.CreatedAt
+	catalog.ResourceVersion = 1
+	s.catalogs[catalog.ID] = storedCatalog(catalog)
+	s.notify(catalogEvent(ChangeCreated, catalog))
+	return nil
+}
+
+func (s *MemoryStorage) UpdateCatalog(catalog *models.Catalog) error {
+	if catalog == nil || catalog.ID == "" {
+		return ErrInvalidInput
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.catalogs[catalog.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if _, exists := s.organizations[catalog.OrgID]; !exists {
+		return ErrInvalidInput
+	}
+	version, err := nextVersion(catalog.ResourceVersion, stored.ResourceVersion)
+	if err != nil {
+		return err
+	}
+	if s.catalogNameTaken(catalog) {
+		return ErrAlreadyExists
+	}
+
+	catalog.UpdatedAt = time.Now()
+	catalog.ResourceVersion = version
+	s.catalogs[catalog.ID] = storedCatalog(catalog)
+	s.notify(catalogEvent(ChangeUpdated, catalog))
+	return nil
+}
+
+func (s *MemoryStorage) DeleteCatalog(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.catalogs[id]
+	if !exists {
+		return ErrNotFound
+	}
+
+	delete(s.catalogs, id)
+	s.notify(catalogEvent(ChangeDeleted, stored))
+	s.unlinkTemplates(id)
 	return nil
 }
 
+// catalogNameTaken reports whether another catalog of the same organization has the name of catalog
+func (s *MemoryStorage) catalogNameTaken(catalog *models.Catalog) bool {
+	for _, other := range s.catalogs {
+		if other.ID != catalog.ID && other.OrgID == catalog.OrgID && other.Name == catalog.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// unlinkTemplates clears the catalog of the templates of a deleted catalog, as the foreign key
+// of the SQL backends does
+func (s *MemoryStorage) unlinkTemplates(catalogID string) {
+	for id, tmpl := range s.templates {
+		if tmpl.CatalogID != nil && *tmpl.CatalogID == catalogID {
+			unlinked := clone(tmpl)
+			unlinked.CatalogID = nil
+			s.templates[id] = unlinked
+			s.notify(templateEvent(ChangeUpdated, unlinked))
+		}
+	}
+}
+
+// catalogExists reports whether a template may reference the catalog with the given ID
+func (s *MemoryStorage) catalogExists(catalogID *string) bool {
+	if catalogID == nil {
+		return true
+	}
+	_, exists := s.catalogs[*catalogID]
+	return exists
+}
+
+// storedCatalog copies a catalog without its relationships, which are not persisted
+func storedCatalog(catalog *models.Catalog) *models.Catalog {
+	stored := clone(catalog)
+	stored.Organization = nil
+	stored.Templates = nil
+	return stored
+}
+
 // Template operations
 func (s *MemoryStorage) ListTemplates() ([]*models.Template, error) {
 	s.mutex.RLock()
@@ -329,8 +767,9 @@ func (s *MemoryStorage) ListTemplates() ([]*models.Template, error) {
 
 	templates := make([]*models.Template, 0, len(s.templates))
 	for _, tmpl := range s.templates {
-		templates = append(templates, tmpl)
+		templates = append(templates, clone(tmpl))
 	}
+	sortByCreation(templates, func(t *models.Template) (time.Time, string) { return t.CreatedAt, t.ID })
 	return templates, nil
 }
 
@@ -341,12 +780,53 @@ func (s *MemoryStorage) ListTemplatesByOrg(orgID string) ([]*models.Template, er
 	templates := make([]*models.Template, 0)
 	for _, tmpl := range s.templates {
 		if tmpl.OrgID == orgID {
-			templates = append(templates, tmpl)
+			templates = append(templates, clone(tmpl))
 		}
 	}
+	sortByCreation(templates, func(t *models.Template) (time.Time, string) { return t.CreatedAt, t.ID })
 	return templates, nil
 }
 
+func (s *MemoryStorage) QueryTemplates(opts ListOptions) ([]*models.Template, string, int, error) {
+	page, err := opts.resolve(templateSortColumns)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	templates := make([]*models.Template, 0)
+	for _, tmpl := range s.templates {
+		if opts.OrgID != "" && tmpl.OrgID != opts.OrgID {
+			continue
+		}
+		if !matchesName(tmpl.Name, opts.Name) {
+			continue
+		}
+		templates = append(templates, clone(tmpl))
+	}
+
+	key := func(t *models.Template) string {
+		switch page.field {
+		case "name":
+			return t.Name
+		case "updated_at":
+			return timeSortKey(t.UpdatedAt)
+		case "id":
+			return t.ID
+		default:
+			return timeSortKey(t.CreatedAt)
+		}
+	}
+	sort.SliceStable(templates, func(i, j int) bool {
+		return page.less(key(templates[i]), templates[i].ID, key(templates[j]), templates[j].ID)
+	})
+
+	start, end, next := page.window(len(templates))
+	return templates[start:end], next, len(templates), nil
+}
+
 func (s *MemoryStorage) GetTemplate(id string) (*models.Template, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
@@ -355,7 +835,7 @@ func (s *MemoryStorage) GetTemplate(id string) (*models.Template, error) {
 	if !exists {
 		return nil, ErrNotFound
 	}
-	return tmpl, nil
+	return clone(tmpl), nil
 }
 
 func (s *MemoryStorage) CreateTemplate(template *models.Template) error {
@@ -369,10 +849,15 @@ func (s *MemoryStorage) CreateTemplate(template *models.Template) error {
 	if _, exists := s.templates[template.ID]; exists {
 		return ErrAlreadyExists
 	}
+	if !s.catalogExists(template.CatalogID) {
+		return ErrInvalidInput
+	}
 
 	template.CreatedAt = time.Now()
 	template.UpdatedAt = template.CreatedAt
-	s.templates[template.ID] = template
+	template.ResourceVersion = 1
+	s.templates[template.ID] = clone(template)
+	s.notify(templateEvent(ChangeCreated, template))
 	return nil
 }
 
@@ -384,12 +869,22 @@ func (s *MemoryStorage) UpdateTemplate(template *models.Template) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if _, exists := s.templates[template.ID]; !exists {
+	stored, exists := s.templates[template.ID]
+	if !exists {
 		return ErrNotFound
 	}
+	if !s.catalogExists(template.CatalogID) {
+		return ErrInvalidInput
+	}
+	version, err := nextVersion(template.ResourceVersion, stored.ResourceVersion)
+	if err != nil {
+		return err
+	}
 
 	template.UpdatedAt = time.Now()
-	s.templates[template.ID] = template
+	template.ResourceVersion = version
+	s.templates[template.ID] = clone(template)
+	s.notify(templateEvent(ChangeUpdated, template))
 	return nil
 }
 
@@ -397,11 +892,13 @@ func (s *MemoryStorage) DeleteTemplate(id string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if _, exists := s.templates[id]; !exists {
+	stored, exists := s.templates[id]
+	if !exists {
 		return ErrNotFound
 	}
 
 	delete(s.templates, id)
+	s.notify(templateEvent(ChangeDeleted, stored))
 	return nil
 }
 
@@ -412,22 +909,80 @@ func (s *MemoryStorage) ListVMs(orgID string) ([]*models.VirtualMachine, error)
 
 	vms := make([]*models.VirtualMachine, 0)
 	for _, vm := range s.vms {
+		if vm.DeletedAt != nil {
+			continue
+		}
 		if orgID == "" || vm.OrgID == orgID {
-			vms = append(vms, vm)
+			vms = append(vms, clone(vm))
 		}
 	}
+	sortByCreation(vms, func(v *models.VirtualMachine) (time.Time, string) { return v.CreatedAt, v.ID })
 	return vms, nil
 }
 
+func (s *MemoryStorage) QueryVMs(opts ListOptions) ([]*models.VirtualMachine, string, int, error) {
+	page, err := opts.resolve(vmSortColumns)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	vms := make([]*models.VirtualMachine, 0)
+	for _, vm := range s.vms {
+		if vm.DeletedAt != nil {
+			continue
+		}
+		if opts.OrgID != "" && vm.OrgID != opts.OrgID {
+			continue
+		}
+		if opts.OwnerID != "" && vm.OwnerID != opts.OwnerID {
+			continue
+		}
+		if opts.VDCID != "" && (vm.VDCID == nil || *vm.VDCID != opts.VDCID) {
+			continue
+		}
+		if opts.Status != "" && vm.Status != opts.Status {
+			continue
+		}
+		if !matchesName(vm.Name, opts.Name) {
+			continue
+		}
+		vms = append(vms, clone(vm))
+	}
+
+	key := func(v *models.VirtualMachine) string {
+		switch page.field {
+		case "name":
+			return v.Name
+		case "status":
+			return v.Status
+		case "updated_at":
+			return timeSortKey(v.UpdatedAt)
+		case "id":
+			return v.ID
+		default:
+			return timeSortKey(v.CreatedAt)
+		}
+	}
+	sort.SliceStable(vms, func(i, j int) bool {
+		return page.less(key(vms[i]), vms[i].ID, key(vms[j]), vms[j].ID)
+	})
+
+	start, end, next := page.window(len(vms))
+	return vms[start:end], next, len(vms), nil
+}
+
 func (s *MemoryStorage) GetVM(id string) (*models.VirtualMachine, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
 	vm, exists := s.vms[id]
-	if !exists {
+	if !exists || vm.DeletedAt != nil {
 		return nil, ErrNotFound
 	}
-	return vm, nil
+	return clone(vm), nil
 }
 
 func (s *MemoryStorage) CreateVM(vm *models.VirtualMachine) error {
@@ -444,7 +999,9 @@ func (s *MemoryStorage) CreateVM(vm *models.VirtualMachine) error {
 
 	vm.CreatedAt = time.Now()
 	vm.UpdatedAt = vm.CreatedAt
-	s.vms[vm.ID] = vm
+	vm.ResourceVersion = 1
+	s.vms[vm.ID] = clone(vm)
+	s.notify(vmEvent(ChangeCreated, vm))
 	return nil
 }
 
@@ -456,12 +1013,19 @@ func (s *MemoryStorage) UpdateVM(vm *models.VirtualMachine) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if _, exists := s.vms[vm.ID]; !exists {
+	stored, exists := s.vms[vm.ID]
+	if !exists || stored.DeletedAt != nil {
 		return ErrNotFound
 	}
+	version, err := nextVersion(vm.ResourceVersion, stored.ResourceVersion)
+	if err != nil {
+		return err
+	}
 
 	vm.UpdatedAt = time.Now()
-	s.vms[vm.ID] = vm
+	vm.ResourceVersion = version
+	s.vms[vm.ID] = clone(vm)
+	s.notify(vmEvent(ChangeUpdated, vm))
 	return nil
 }
 
@@ -469,45 +1033,1187 @@ func (s *MemoryStorage) DeleteVM(id string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if _, exists := s.vms[id]; !exists {
+	stored, exists := s.vms[id]
+	if !exists || stored.DeletedAt != nil {
 		return ErrNotFound
 	}
 
-	delete(s.vms, id)
+	now := time.Now()
+	deleted := clone(stored)
+	deleted.DeletedAt = &now
+	deleted.UpdatedAt = now
+	deleted.ResourceVersion++
+	s.vms[id] = deleted
+	s.notify(vmEvent(ChangeDeleted, deleted))
 	return nil
 }
 
-// Health operations
-func (s *MemoryStorage) Ping() error {
-	return nil
+// Trash operations
+func (s *MemoryStorage) ListDeletedOrganizations() ([]*models.Organization, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	orgs := make([]*models.Organization, 0)
+	for _, org := range s.organizations {
+		if org.DeletedAt != nil {
+			orgs = append(orgs, clone(org))
+		}
+	}
+	sort.SliceStable(orgs, func(i, j int) bool {
+		return orgs[i].DeletedAt.Before(*orgs[j].DeletedAt)
+	})
+	return orgs, nil
 }
 
-func (s *MemoryStorage) Close() error {
+func (s *MemoryStorage) RestoreOrganization(id string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	// Clear all data
-	s.users = nil
-	s.organizations = nil
-	s.vdcs = nil
-	s.templates = nil
-	s.vms = nil
+	stored, exists := s.organizations[id]
+	if !exists || stored.DeletedAt == nil {
+		return ErrNotFound
+	}
 
-	klog.Info("Memory storage closed")
+	restored := clone(stored)
+	restored.DeletedAt = nil
+	restored.UpdatedAt = time.Now()
+	restored.ResourceVersion++
+	s.organizations[id] = restored
+	s.notify(organizationEvent(ChangeCreated, restored))
 	return nil
 }
 
-// Organization Catalog Source operations
-func (s *MemoryStorage) ListOrganizationCatalogSources(orgID string) ([]*models.OrganizationCatalogSource, error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+func (s *MemoryStorage) PurgeOrganization(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.organizations[id]
+	if !exists {
+		return ErrNotFound
+	}
+
+	delete(s.organizations, id)
+	if stored.DeletedAt == nil {
+		s.notify(organizationEvent(ChangeDeleted, stored))
+	}
+	// VDCs, catalogs, roles, memberships and invitations belong to their organization, as the foreign keys of
+	// the SQL backends enforce
+	for vdcID, vdc := range s.vdcs {
+		if vdc.OrgID == id {
+			delete(s.vdcs, vdcID)
+			if vdc.DeletedAt == nil {
+				s.notify(vdcEvent(ChangeDeleted, vdc))
+			}
+		}
+	}
+	for catalogID, catalog := range s.catalogs {
+		if catalog.OrgID == id {
+			delete(s.catalogs, catalogID)
+			s.notify(catalogEvent(ChangeDeleted, catalog))
+			s.unlinkTemplates(catalogID)
+		}
+	}
+	for roleID, role := range s.orgRoles {
+		if role.OrgID == id {
+			delete(s.orgRoles, roleID)
+		}
+	}
+	for membershipID, membership := range s.memberships {
+		if membership.OrgID == id {
+			delete(s.memberships, membershipID)
+		}
+	}
+	for invitationID, invitation := range s.invitations {
+		if invitation.OrgID == id {
+			delete(s.invitations, invitationID)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) ListDeletedVDCs() ([]*models.VirtualDataCenter, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	vdcs := make([]*models.VirtualDataCenter, 0)
+	for _, vdc := range s.vdcs {
+		if vdc.DeletedAt != nil {
+			vdcs = append(vdcs, clone(vdc))
+		}
+	}
+	sort.SliceStable(vdcs, func(i, j int) bool {
+		return vdcs[i].DeletedAt.Before(*vdcs[j].DeletedAt)
+	})
+	return vdcs, nil
+}
+
+func (s *MemoryStorage) RestoreVDC(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.vdcs[id]
+	if !exists || stored.DeletedAt == nil {
+		return ErrNotFound
+	}
+
+	restored := clone(stored)
+	restored.DeletedAt = nil
+	restored.UpdatedAt = time.Now()
+	restored.ResourceVersion++
+	s.vdcs[id] = restored
+	s.notify(vdcEvent(ChangeCreated, restored))
+	return nil
+}
+
+func (s *MemoryStorage) PurgeVDC(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.vdcs[id]
+	if !exists {
+		return ErrNotFound
+	}
+
+	delete(s.vdcs, id)
+	if stored.DeletedAt == nil {
+		s.notify(vdcEvent(ChangeDeleted, stored))
+	}
+	return nil
+}
+
+func (s *MemoryStorage) ListDeletedVMs() ([]*models.VirtualMachine, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	vms := make([]*models.VirtualMachine, 0)
+	for _, vm := range s.vms {
+		if vm.DeletedAt != nil {
+			vms = append(vms, clone(vm))
+		}
+	}
+	sort.SliceStable(vms, func(i, j int) bool {
+		return vms[i].DeletedAt.Before(*vms[j].DeletedAt)
+	})
+	return vms, nil
+}
+
+func (s *MemoryStorage) RestoreVM(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.vms[id]
+	if !exists || stored.DeletedAt == nil {
+		return ErrNotFound
+	}
+
+	restored := clone(stored)
+	restored.DeletedAt = nil
+	restored.UpdatedAt = time.Now()
+	restored.ResourceVersion++
+	s.vms[id] = restored
+	s.notify(vmEvent(ChangeCreated, restored))
+	return nil
+}
+
+func (s *MemoryStorage) PurgeVM(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.vms[id]
+	if !exists {
+		return ErrNotFound
+	}
+
+	delete(s.vms, id)
+	if stored.DeletedAt == nil {
+		s.notify(vmEvent(ChangeDeleted, stored))
+	}
+	return nil
+}
+
+// Token operations
+
+func (s *MemoryStorage) CreateRefreshToken(token *models.RefreshToken) error {
+	if token == nil || token.ID == "" {
+		return ErrInvalidInput
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.users[token.UserID]; !exists {
+		return ErrInvalidInput
+	}
+	if _, exists := s.refreshTokens[token.ID]; exists {
+		return ErrAlreadyExists
+	}
+	for _, existing := range s.refreshTokens {
+		if existing.TokenHash == token.TokenHash {
+			return ErrAlreadyExists
+		}
+	}
+
+	token.CreatedAt = time.Now()
+	s.refreshTokens[token.ID] = clone(token)
+	return nil
+}
+
+func (s *MemoryStorage) GetRefreshTokenByHash(hash string) (*models.RefreshToken, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, token := range s.refreshTokens {
+		if token.TokenHash == hash {
+			return clone(token), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStorage) RevokeRefreshToken(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.refreshTokens[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if stored.RevokedAt != nil {
+		return ErrConflict
+	}
+	s.revokeRefreshToken(stored, time.Now())
+	return nil
+}
+
+func (s *MemoryStorage) RevokeSession(sessionID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.revokeRefreshTokens(func(token *models.RefreshToken) bool { return token.SessionID == sessionID })
+	return nil
+}
+
+func (s *MemoryStorage) RevokeUserTokens(userID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.revokeRefreshTokens(func(token *models.RefreshToken) bool { return token.UserID == userID })
+	s.endImpersonations(userID)
+	return nil
+}
+
+// revokeRefreshTokens revokes the matching refresh tokens and the access tokens issued with
+// them. Callers hold the mutex.
+func (s *MemoryStorage) revokeRefreshTokens(match func(*models.RefreshToken) bool) {
+	now := time.Now()
+	for _, token := range s.refreshTokens {
+		if !match(token) {
+			continue
+		}
+		if token.RevokedAt == nil {
+			s.revokeRefreshToken(token, now)
+		}
+		if _, exists := s.revokedTokens[token.AccessTokenID]; !exists && token.AccessTokenExpiresAt.After(now) {
+			s.revokedTokens[token.AccessTokenID] = &models.RevokedToken{
+				ID:        token.AccessTokenID,
+				UserID:    token.UserID,
+				ExpiresAt: token.AccessTokenExpiresAt,
+				RevokedAt: now,
+			}
+		}
+	}
+}
+
+// endImpersonations ends the ongoing impersonations of or by a user and revokes their tokens.
+// Callers hold the mutex.
+func (s *MemoryStorage) endImpersonations(userID string) {
+	now := time.Now()
+	for id, impersonation := range s.impersonations {
+		if impersonation.UserID != userID && impersonation.ImpersonatorID != userID || impersonation.EndedAt != nil {
+			continue
+		}
+		ended := clone(impersonation)
+		ended.EndedAt = &now
+		s.impersonations[id] = ended
+		if _, exists := s.revokedTokens[ended.TokenID]; !exists && ended.ExpiresAt.After(now) {
+			s.revokedTokens[ended.TokenID] = &models.RevokedToken{
+				ID:        ended.TokenID,
+				UserID:    ended.UserID,
+				ExpiresAt: ended.ExpiresAt,
+				RevokedAt: now,
+			}
+		}
+	}
+}
+
+// revokeRefreshToken replaces a stored token with a revoked copy. Callers hold the mutex.
+func (s *MemoryStorage) revokeRefreshToken(stored *models.RefreshToken, now time.Time) {
+	revoked := clone(stored)
+	revoked.RevokedAt = &now
+	s.refreshTokens[stored.ID] = revoked
+}
+
+func (s *MemoryStorage) RevokeToken(token *models.RevokedToken) error {
+	if token == nil || token.ID == "" {
+		return ErrInvalidInput
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.revokedTokens[token.ID]; !exists {
+		token.RevokedAt = time.Now()
+		s.revokedTokens[token.ID] = clone(token)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) IsTokenRevoked(id string) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, revoked := s.revokedTokens[id]
+	return revoked, nil
+}
+
+func (s *MemoryStorage) PurgeExpiredTokens(before time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	purged := 0
+	for id, token := range s.refreshTokens {
+		if token.ExpiresAt.Before(before) {
+			delete(s.refreshTokens, id)
+			purged++
+		}
+	}
+	for id, token := range s.revokedTokens {
+		if token.ExpiresAt.Before(before) {
+			delete(s.revokedTokens, id)
+			purged++
+		}
+	}
+	for id, token := range s.resetTokens {
+		if token.ExpiresAt.Before(before) {
+			delete(s.resetTokens, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// Password reset token operations
+
+func (s *MemoryStorage) CreatePasswordResetToken(token *models.PasswordResetToken) error {
+	if token == nil || token.ID == "" {
+		return ErrInvalidInput
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.users[token.UserID]; !exists {
+		return ErrInvalidInput
+	}
+	if _, exists := s.resetTokens[token.ID]; exists {
+		return ErrAlreadyExists
+	}
+	for _, existing := range s.resetTokens {
+		if existing.TokenHash == token.TokenHash {
+			return ErrAlreadyExists
+		}
+	}
+
+	token.CreatedAt = time.Now()
+	s.resetTokens[token.ID] = clone(token)
+	return nil
+}
+
+func (s *MemoryStorage) GetPasswordResetTokenByHash(hash string) (*models.PasswordResetToken, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, token := range s.resetTokens {
+		if token.TokenHash == hash {
+			return clone(token), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStorage) UsePasswordResetToken(id string, usedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.resetTokens[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if stored.UsedAt != nil {
+		return ErrConflict
+	}
+	used := clone(stored)
+	used.UsedAt = &usedAt
+	s.resetTokens[id] = used
+	return nil
+}
+
+// Organization membership operations
+
+func (s *MemoryStorage) CreateOrgMembership(membership *models.OrgMembership) error {
+	if membership == nil || membership.ID == "" {
+		return ErrInvalidInput
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.users[membership.UserID]; !exists {
+		return ErrInvalidInput
+	}
+	if _, exists := s.organizations[membership.OrgID]; !exists {
+		return ErrInvalidInput
+	}
+	if _, exists := s.memberships[membership.ID]; exists {
+		return ErrAlreadyExists
+	}
+	if s.findMembership(membership.UserID, membership.OrgID) != nil {
+		return ErrAlreadyExists
+	}
+
+	membership.CreatedAt = time.Now()
+	membership.UpdatedAt = membership.CreatedAt
+	s.memberships[membership.ID] = clone(membership)
+	return nil
+}
+
+func (s *MemoryStorage) GetOrgMembership(userID, orgID string) (*models.OrgMembership, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	membership := s.findMembership(userID, orgID)
+	if membership == nil {
+		return nil, ErrNotFound
+	}
+	return clone(membership), nil
+}
+
+func (s *MemoryStorage) ListOrgMemberships(userID, orgID string) ([]*models.OrgMembership, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	memberships := make([]*models.OrgMembership, 0)
+	for _, membership := range s.memberships {
+		if (userID == "" || membership.UserID == userID) && (orgID == "" || membership.OrgID == orgID) {
+			memberships = append(memberships, clone(membership))
+		}
+	}
+	sortByCreation(memberships, func(m *models.OrgMembership) (time.Time, string) { return m.CreatedAt, m.ID })
+	return memberships, nil
+}
+
+func (s *MemoryStorage) UpdateOrgMembership(membership *models.OrgMembership) error {
+	if membership == nil || membership.ID == "" {
+		return ErrInvalidInput
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.memberships[membership.ID]
+	if !exists {
+		return ErrNotFound
+	}
+
+	updated := clone(stored)
+	updated.Role = membership.Role
+	updated.UpdatedAt = time.Now()
+	s.memberships[membership.ID] = updated
+	membership.UpdatedAt = updated.UpdatedAt
+	return nil
+}
+
+func (s *MemoryStorage) DeleteOrgMembership(userID, orgID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	membership := s.findMembership(userID, orgID)
+	if membership == nil {
+		return ErrNotFound
+	}
+	delete(s.memberships, membership.ID)
+	return nil
+}
+
+// findMembership returns the stored membership of a user in an organization, nil if there is
+// none. Callers hold the mutex.
+func (s *MemoryStorage) findMembership(userID, orgID string) *models.OrgMembership {
+	for _, membership := range s.memberships {
+		if membership.UserID == userID && membership.OrgID == orgID {
+			return membership
+		}
+	}
+	return nil
+}
+
+// Impersonation operations
+
+func (s *MemoryStorage) CreateImpersonation(impersonation *models.Impersonation) error {
+	if impersonation == nil || impersonation.ID == "" {
+		return ErrInvalidInput
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.users[impersonation.UserID]; !exists {
+		return ErrInvalidInput
+	}
+	if _, exists := s.impersonations[impersonation.ID]; exists {
+		return ErrAlreadyExists
+	}
+
+	impersonation.CreatedAt = time.Now()
+	s.impersonations[impersonation.ID] = clone(impersonation)
+	return nil
+}
+
+func (s *MemoryStorage) ListImpersonations(userID string) ([]*models.Impersonation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	impersonations := make([]*models.Impersonation, 0)
+	for _, impersonation := range s.impersonations {
+		if userID == "" || impersonation.UserID == userID {
+			impersonations = append(impersonations, clone(impersonation))
+		}
+	}
+	sortByCreation(impersonations, func(i *models.Impersonation) (time.Time, string) { return i.CreatedAt, i.ID })
+	return impersonations, nil
+}
+
+func (s *MemoryStorage) GetImpersonation(id string) (*models.Impersonation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	impersonation, exists := s.impersonations[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return clone(impersonation), nil
+}
+
+func (s *MemoryStorage) EndImpersonation(id string, endedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.impersonations[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if stored.EndedAt != nil {
+		return ErrConflict
+	}
+	ended := clone(stored)
+	ended.EndedAt = &endedAt
+	s.impersonations[id] = ended
+	return nil
+}
+
+// Invitation operations
+
+func (s *MemoryStorage) CreateInvitation(invitation *models.Invitation) error {
+	if invitation == nil || invitation.ID == "" {
+		return ErrInvalidInput
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.organizations[invitation.OrgID]; !exists {
+		return ErrInvalidInput
+	}
+	if _, exists := s.invitations[invitation.ID]; exists {
+		return ErrAlreadyExists
+	}
+
+	invitation.CreatedAt = time.Now()
+	invitation.UpdatedAt = invitation.CreatedAt
+	s.invitations[invitation.ID] = clone(invitation)
+	return nil
+}
+
+func (s *MemoryStorage) GetInvitation(id string) (*models.Invitation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	invitation, exists := s.invitations[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return clone(invitation), nil
+}
+
+func (s *MemoryStorage) ListInvitations(orgID string) ([]*models.Invitation, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	invitations := make([]*models.Invitation, 0)
+	for _, invitation := range s.invitations {
+		if orgID == "" || invitation.OrgID == orgID {
+			invitations = append(invitations, clone(invitation))
+		}
+	}
+	sortByCreation(invitations, func(i *models.Invitation) (time.Time, string) { return i.CreatedAt, i.ID })
+	return invitations, nil
+}
+
+func (s *MemoryStorage) RenewInvitation(id, tokenID string, expiresAt time.Time) error {
+	return s.updatePendingInvitation(id, func(invitation *models.Invitation) {
+		invitation.TokenID = tokenID
+		invitation.ExpiresAt = expiresAt
+	})
+}
+
+func (s *MemoryStorage) AcceptInvitation(id, userID string, acceptedAt time.Time) error {
+	return s.updatePendingInvitation(id, func(invitation *models.Invitation) {
+		invitation.AcceptedAt = &acceptedAt
+		invitation.UserID = &userID
+	})
+}
+
+func (s *MemoryStorage) RevokeInvitation(id string, revokedAt time.Time) error {
+	return s.updatePendingInvitation(id, func(invitation *models.Invitation) {
+		invitation.RevokedAt = &revokedAt
+	})
+}
+
+// updatePendingInvitation applies update to a copy of an invitation that was neither accepted nor
+// revoked, and stores the copy
+func (s *MemoryStorage) updatePendingInvitation(id string, update func(*models.Invitation)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.invitations[id]
+	if !exists {
+		return ErrNotFound
+	}
+	if stored.AcceptedAt != nil || stored.RevokedAt != nil {
+		return ErrConflict
+	}
+	updated := clone(stored)
+	update(updated)
+	updated.UpdatedAt = time.Now()
+	s.invitations[id] = updated
+	return nil
+}
+
+// Audit record operations
+
+func (s *MemoryStorage) CreateAuditRecord(record *models.AuditRecord) error {
+	if record == nil || record.ID == "" {
+		return ErrInvalidInput
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.auditRecords[record.ID]; exists {
+		return ErrAlreadyExists
+	}
+
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+	stored, err := cloneAuditRecord(record)
+	if err != nil {
+		return err
+	}
+	s.auditRecords[record.ID] = stored
+	return nil
+}
+
+func (s *MemoryStorage) QueryAuditRecords(opts ListOptions) ([]*models.AuditRecord, string, int, error) {
+	page, err := opts.resolve(auditSortColumns)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	records := make([]*models.AuditRecord, 0)
+	for _, record := range s.auditRecords {
+		if opts.OrgID != "" && record.OrgID != opts.OrgID {
+			continue
+		}
+		if opts.ActorID != "" && record.ActorID != opts.ActorID {
+			continue
+		}
+		if opts.ResourceType != "" && record.ResourceType != opts.ResourceType {
+			continue
+		}
+		if opts.ResourceID != "" && record.ResourceID != opts.ResourceID {
+			continue
+		}
+		if !opts.Since.IsZero() && record.CreatedAt.Before(opts.Since) {
+			continue
+		}
+		if !opts.Until.IsZero() && !record.CreatedAt.Before(opts.Until) {
+			continue
+		}
+		copied, err := cloneAuditRecord(record)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		records = append(records, copied)
+	}
+
+	key := func(r *models.AuditRecord) string {
+		if page.field == "id" {
+			return r.ID
+		}
+		return timeSortKey(r.CreatedAt)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return page.less(key(records[i]), records[i].ID, key(records[j]), records[j].ID)
+	})
+
+	start, end, next := page.window(len(records))
+	return records[start:end], next, len(records), nil
+}
+
+// cloneAuditRecord copies a record with its request and changes, which are decoded from JSON as
+// the SQL backends return them
+func cloneAuditRecord(record *models.AuditRecord) (*models.AuditRecord, error) {
+	c := clone(record)
+	for _, field := range []*models.JSONBMap{&c.Request, &c.Changes} {
+		if *field == nil {
+			continue
+		}
+		value, err := field.Value()
+		if err != nil {
+			return nil, err
+		}
+		if err := field.Scan(value); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// API token operations
+
+func (s *MemoryStorage) CreateAPIToken(token *models.APIToken) error {
+	if token == nil || token.ID == "" {
+		return ErrInvalidInput
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.users[token.UserID]; !exists {
+		return ErrInvalidInput
+	}
+	if _, exists := s.apiTokens[token.ID]; exists {
+		return ErrAlreadyExists
+	}
+	for _, existing := range s.apiTokens {
+		if existing.TokenHash == token.TokenHash || (existing.UserID == token.UserID && existing.Name == token.Name) {
+			return ErrAlreadyExists
+		}
+	}
+
+	token.CreatedAt = time.Now()
+	token.UpdatedAt = token.CreatedAt
+	s.apiTokens[token.ID] = clone(token)
+	return nil
+}
+
+func (s *MemoryStorage) ListAPITokens(userID string) ([]*models.APIToken, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	tokens := make([]*models.APIToken, 0)
+	for _, token := range s.apiTokens {
+		if userID == "" || token.UserID == userID {
+			tokens = append(tokens, clone(token))
+		}
+	}
+	sortByCreation(tokens, func(t *models.APIToken) (time.Time, string) { return t.CreatedAt, t.ID })
+	return tokens, nil
+}
+
+func (s *MemoryStorage) GetAPIToken(id string) (*models.APIToken, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	token, exists := s.apiTokens[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return clone(token), nil
+}
+
+func (s *MemoryStorage) GetAPITokenByHash(hash string) (*models.APIToken, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, token := range s.apiTokens {
+		if token.TokenHash == hash {
+			return clone(token), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStorage) UpdateAPIToken(token *models.APIToken) error {
+	if token == nil || token.ID == "" {
+		return ErrInvalidInput
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.apiTokens[token.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	for _, existing := range s.apiTokens {
+		if existing.ID != token.ID && existing.UserID == stored.UserID && existing.Name == token.Name {
+			return ErrAlreadyExists
+		}
+	}
+
+	updated := clone(stored)
+	updated.Name = token.Name
+	updated.Scopes = token.Scopes
+	updated.ExpiresAt = token.ExpiresAt
+	updated.UpdatedAt = time.Now()
+	s.apiTokens[token.ID] = updated
+	token.UpdatedAt = updated.UpdatedAt
+	return nil
+}
+
+func (s *MemoryStorage) TouchAPIToken(id string, usedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.apiTokens[id]
+	if !exists {
+		return ErrNotFound
+	}
+	touched := clone(stored)
+	touched.LastUsedAt = &usedAt
+	s.apiTokens[id] = touched
+	return nil
+}
+
+func (s *MemoryStorage) DeleteAPIToken(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.apiTokens[id]; !exists {
+		return ErrNotFound
+	}
+	delete(s.apiTokens, id)
+	return nil
+}
+
+// Custom role operations
+
+func (s *MemoryStorage) ListOrgRoles(orgID string) ([]*models.OrgRole, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	roles := make([]*models.OrgRole, 0)
+	for _, role := range s.orgRoles {
+		if role.OrgID == orgID {
+			roles = append(roles, clone(role))
+		}
+	}
+	sortByCreation(roles, func(r *models.OrgRole) (time.Time, string) { return r.CreatedAt, r.ID })
+	return roles, nil
+}
+
+func (s *MemoryStorage) GetOrgRole(id string) (*models.OrgRole, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	role, exists := s.orgRoles[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return clone(role), nil
+}
+
+func (s *MemoryStorage) GetOrgRoleByName(orgID, name string) (*models.OrgRole, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, role := range s.orgRoles {
+		if role.OrgID == orgID && role.Name == name {
+			return clone(role), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStorage) CreateOrgRole(role *models.OrgRole) error {
+	if role == nil || role.ID == "" {
+		return ErrInvalidInput
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.organizations[role.OrgID]; !exists {
+		return ErrInvalidInput
+	}
+	if _, exists := s.orgRoles[role.ID]; exists {
+		return ErrAlreadyExists
+	}
+	if s.orgRoleNameTaken(role.ID, role.OrgID, role.Name) {
+		return ErrAlreadyExists
+	}
+
+	role.CreatedAt = time.Now()
+	role.UpdatedAt = role.CreatedAt
+	s.orgRoles[role.ID] = clone(role)
+	return nil
+}
+
+func (s *MemoryStorage) UpdateOrgRole(role *models.OrgRole) error {
+	if role == nil || role.ID == "" {
+		return ErrInvalidInput
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.orgRoles[role.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	if s.orgRoleNameTaken(role.ID, stored.OrgID, role.Name) {
+		return ErrAlreadyExists
+	}
+
+	updated := clone(stored)
+	updated.Name = role.Name
+	updated.Description = role.Description
+	updated.Verbs = role.Verbs
+	updated.UpdatedAt = time.Now()
+	s.orgRoles[role.ID] = updated
+	role.UpdatedAt = updated.UpdatedAt
+	return nil
+}
+
+func (s *MemoryStorage) DeleteOrgRole(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.orgRoles[id]; !exists {
+		return ErrNotFound
+	}
+	delete(s.orgRoles, id)
+	return nil
+}
+
+// orgRoleNameTaken reports whether another role of the organization has the given name
+func (s *MemoryStorage) orgRoleNameTaken(id, orgID, name string) bool {
+	for _, other := range s.orgRoles {
+		if other.ID != id && other.OrgID == orgID && other.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Login attempt operations
+
+func (s *MemoryStorage) GetLoginAttempt(id string) (*models.LoginAttempt, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	attempt, exists := s.loginAttempts[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return clone(attempt), nil
+}
+
+func (s *MemoryStorage) RecordLoginFailure(id string, at, since time.Time) (*models.LoginAttempt, error) {
+	if id == "" {
+		return nil, ErrInvalidInput
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	attempt := &models.LoginAttempt{ID: id}
+	if stored, exists := s.loginAttempts[id]; exists {
+		expired := stored.LockedUntil != nil && !stored.LockedUntil.After(at)
+		if !stored.LastFailureAt.Before(since) && !expired {
+			attempt = clone(stored)
+		}
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = at
+	s.loginAttempts[id] = attempt
+	return clone(attempt), nil
+}
+
+func (s *MemoryStorage) LockLogin(id string, until time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, exists := s.loginAttempts[id]
+	if !exists {
+		return ErrNotFound
+	}
+	locked := clone(stored)
+	locked.LockedUntil = &until
+	s.loginAttempts[id] = locked
+	return nil
+}
+
+func (s *MemoryStorage) ResetLoginAttempts(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.loginAttempts, id)
+	return nil
+}
+
+func (s *MemoryStorage) PurgeLoginAttempts(before time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	purged := 0
+	for id, attempt := range s.loginAttempts {
+		if attempt.LastFailureAt.Before(before) && (attempt.LockedUntil == nil || attempt.LockedUntil.Before(before)) {
+			delete(s.loginAttempts, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// WithTx runs fn against a copy-on-write snapshot of the storage and publishes the snapshot
+// only if fn succeeds. Transactions hold the storage lock, so fn must not use s directly.
+func (s *MemoryStorage) WithTx(fn func(tx Storage) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Stored objects are replaced rather than modified on update, copying the maps is enough
+	tx := &MemoryStorage{
+		users:          maps.Clone(s.users),
+		organizations:  maps.Clone(s.organizations),
+		vdcs:           maps.Clone(s.vdcs),
+		catalogs:       maps.Clone(s.catalogs),
+		templates:      maps.Clone(s.templates),
+		vms:            maps.Clone(s.vms),
+		catalogSources: maps.Clone(s.catalogSources),
+		refreshTokens:  maps.Clone(s.refreshTokens),
+		revokedTokens:  maps.Clone(s.revokedTokens),
+		apiTokens:      maps.Clone(s.apiTokens),
+		orgRoles:       maps.Clone(s.orgRoles),
+		loginAttempts:  maps.Clone(s.loginAttempts),
+		resetTokens:    maps.Clone(s.resetTokens),
+		memberships:    maps.Clone(s.memberships),
+		impersonations: maps.Clone(s.impersonations),
+		invitations:    maps.Clone(s.invitations),
+		auditRecords:   maps.Clone(s.auditRecords),
+		changes:        s.changes,
+		inTx:           true,
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	s.users = tx.users
+	s.organizations = tx.organizations
+	s.vdcs = tx.vdcs
+	s.catalogs = tx.catalogs
+	s.templates = tx.templates
+	s.vms = tx.vms
+	s.catalogSources = tx.catalogSources
+	s.refreshTokens = tx.refreshTokens
+	s.revokedTokens = tx.revokedTokens
+	s.apiTokens = tx.apiTokens
+	s.orgRoles = tx.orgRoles
+	s.loginAttempts = tx.loginAttempts
+	s.resetTokens = tx.resetTokens
+	s.memberships = tx.memberships
+	s.impersonations = tx.impersonations
+	s.invitations = tx.invitations
+	s.auditRecords = tx.auditRecords
+	for _, event := range tx.pending {
+		s.notify(event)
+	}
+	return nil
+}
+
+// Watch subscribes to the changes of this storage, there are no other writers to hear from
+func (s *MemoryStorage) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	return s.changes.subscribe(ctx)
+}
+
+// notify reports a change to watchers, or queues it until the transaction commits. Callers hold
+// the mutex, so events are published in the order of the writes.
+func (s *MemoryStorage) notify(event ChangeEvent) {
+	if s.inTx {
+		s.pending = append(s.pending, event)
+		return
+	}
+	s.changes.publish(event)
+}
+
+// Health operations
+func (s *MemoryStorage) Ping() error {
+	return nil
+}
+
+func (s *MemoryStorage) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Clear all data
+	s.users = nil
+	s.organizations = nil
+	s.vdcs = nil
+	s.catalogs = nil
+	s.templates = nil
+	s.vms = nil
+	s.refreshTokens = nil
+	s.revokedTokens = nil
+	s.apiTokens = nil
+	s.orgRoles = nil
+	s.loginAttempts = nil
+	s.resetTokens = nil
+	s.memberships = nil
+	s.impersonations = nil
+	s.invitations = nil
+	s.auditRecords = nil
+	s.changes.close()
+
+	klog.Info("Memory storage closed")
+	return nil
+}
+
+// Organization Catalog Source operations
+func (s *MemoryStorage) ListOrganizationCatalogSources(orgID string) ([]*models.OrganizationCatalogSource, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 
 	sources := make([]*models.OrganizationCatalogSource, 0)
 	for _, source := range s.catalogSources {
 		if source.OrgID == orgID {
-			sources = append(sources, source)
+			sources = append(sources, clone(source))
 		}
 	}
+	sortByCreation(sources, func(cs *models.OrganizationCatalogSource) (time.Time, string) { return cs.CreatedAt, cs.ID })
 	return sources, nil
 }
 
@@ -519,7 +2225,7 @@ func (s *MemoryStorage) GetOrganizationCatalogSource(id string) (*models.Organiz
 	if !exists {
 		return nil, ErrNotFound
 	}
-	return source, nil
+	return clone(source), nil
 }
 
 func (s *MemoryStorage) CreateOrganizationCatalogSource(source *models.OrganizationCatalogSource) error {
@@ -534,7 +2240,10 @@ func (s *MemoryStorage) CreateOrganizationCatalogSource(source *models.Organizat
 		return ErrAlreadyExists
 	}
 
-	s.catalogSources[source.ID] = source
+	source.CreatedAt = time.Now()
+	source.UpdatedAt = source.CreatedAt
+	s.catalogSources[source.ID] = clone(source)
+	s.notify(catalogSourceEvent(ChangeCreated, source))
 	return nil
 }
 
@@ -546,11 +2255,16 @@ func (s *MemoryStorage) UpdateOrganizationCatalogSource(source *models.Organizat
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if _, exists := s.catalogSources[source.ID]; !exists {
+	stored, exists := s.catalogSources[source.ID]
+	if !exists {
 		return ErrNotFound
 	}
 
-	s.catalogSources[source.ID] = source
+	source.UpdatedAt = time.Now()
+	updated := clone(source)
+	updated.CreatedAt = stored.CreatedAt
+	s.catalogSources[source.ID] = updated
+	s.notify(catalogSourceEvent(ChangeUpdated, updated))
 	return nil
 }
 
@@ -558,14 +2272,28 @@ func (s *MemoryStorage) DeleteOrganizationCatalogSource(id string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	if _, exists := s.catalogSources[id]; !exists {
+	stored, exists := s.catalogSources[id]
+	if !exists {
 		return ErrNotFound
 	}
 
 	delete(s.catalogSources, id)
+	s.notify(catalogSourceEvent(ChangeDeleted, stored))
 	return nil
 }
 
+// sortByCreation orders records oldest first, using the ID as a tie-breaker like the SQL backends
+func sortByCreation[T any](items []*T, key func(*T) (time.Time, string)) {
+	sort.SliceStable(items, func(i, j int) bool {
+		createdI, idI := key(items[i])
+		createdJ, idJ := key(items[j])
+		if !createdI.Equal(createdJ) {
+			return createdI.Before(createdJ)
+		}
+		return idI < idJ
+	})
+}
+
 // NewMemoryStorageForTest creates a new in-memory storage instance for testing
 // This version doesn't seed any initial data, providing a clean slate for tests
 func NewMemoryStorageForTest() (Storage, error) {
@@ -573,9 +2301,21 @@ func NewMemoryStorageForTest() (Storage, error) {
 		users:          make(map[string]*models.User),
 		organizations:  make(map[string]*models.Organization),
 		vdcs:           make(map[string]*models.VirtualDataCenter),
+		catalogs:       make(map[string]*models.Catalog),
 		templates:      make(map[string]*models.Template),
 		vms:            make(map[string]*models.VirtualMachine),
 		catalogSources: make(map[string]*models.OrganizationCatalogSource),
+		refreshTokens:  make(map[string]*models.RefreshToken),
+		revokedTokens:  make(map[string]*models.RevokedToken),
+		apiTokens:      make(map[string]*models.APIToken),
+		orgRoles:       make(map[string]*models.OrgRole),
+		loginAttempts:  make(map[string]*models.LoginAttempt),
+		resetTokens:    make(map[string]*models.PasswordResetToken),
+		memberships:    make(map[string]*models.OrgMembership),
+		impersonations: make(map[string]*models.Impersonation),
+		invitations:    make(map[string]*models.Invitation),
+		auditRecords:   make(map[string]*models.AuditRecord),
+		changes:        newChangeHub(),
 	}
 
 	klog.Info("Initialized in-memory storage for testing with clean state")
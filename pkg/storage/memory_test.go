@@ -694,3 +694,302 @@ func TestMemoryStorage_ErrorCases(t *testing.T) {
 		assert.Contains(t, err.Error(), "not found")
 	})
 }
+
+func TestMemoryStorage_QueryVMs(t *testing.T) {
+	storage, err := NewMemoryStorageForTest()
+	require.NoError(t, err)
+	defer storage.Close()
+
+	for i := 1; i <= 5; i++ {
+		vm := &models.VirtualMachine{
+			ID:      fmt.Sprintf("vm-%d", i),
+			Name:    fmt.Sprintf("VM %d", i),
+			OrgID:   "org-a",
+			VDCID:   stringPtr("vdc-a"),
+			OwnerID: "owner-a",
+			Status:  models.VMStatusRunning,
+		}
+		if i%2 == 0 {
+			vm.OrgID = "org-b"
+			vm.OwnerID = "owner-b"
+			vm.Status = models.VMStatusStopped
+		}
+		require.NoError(t, storage.CreateVM(vm))
+	}
+
+	t.Run("PaginateByName", func(t *testing.T) {
+		var names []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, 5, "pagination did not terminate")
+			vms, next, _, err := storage.QueryVMs(ListOptions{Limit: 2, Cursor: cursor, Sort: "name"})
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(vms), 2)
+			for _, vm := range vms {
+				names = append(names, vm.Name)
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		assert.Equal(t, []string{"VM 1", "VM 2", "VM 3", "VM 4", "VM 5"}, names)
+	})
+
+	t.Run("SortDescending", func(t *testing.T) {
+		vms, next, _, err := storage.QueryVMs(ListOptions{Sort: "-name"})
+		require.NoError(t, err)
+		assert.Empty(t, next)
+		require.Len(t, vms, 5)
+		assert.Equal(t, "VM 5", vms[0].Name)
+		assert.Equal(t, "VM 1", vms[4].Name)
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		vms, _, _, err := storage.QueryVMs(ListOptions{OrgID: "org-a"})
+		require.NoError(t, err)
+		assert.Len(t, vms, 3)
+
+		vms, _, _, err = storage.QueryVMs(ListOptions{OwnerID: "owner-b"})
+		require.NoError(t, err)
+		assert.Len(t, vms, 2)
+
+		vms, _, _, err = storage.QueryVMs(ListOptions{Status: models.VMStatusStopped, VDCID: "vdc-a"})
+		require.NoError(t, err)
+		assert.Len(t, vms, 2)
+
+		vms, _, _, err = storage.QueryVMs(ListOptions{Name: "vm 3"})
+		require.NoError(t, err)
+		require.Len(t, vms, 1)
+		assert.Equal(t, "vm-3", vms[0].ID)
+	})
+
+	t.Run("CursorPastEnd", func(t *testing.T) {
+		vms, next, _, err := storage.QueryVMs(ListOptions{Cursor: encodeCursor(10)})
+		require.NoError(t, err)
+		assert.Empty(t, vms)
+		assert.Empty(t, next)
+	})
+
+	t.Run("InvalidOptions", func(t *testing.T) {
+		_, _, _, err := storage.QueryVMs(ListOptions{Sort: "owner"})
+		assert.ErrorIs(t, err, ErrInvalidInput)
+
+		_, _, _, err = storage.QueryVMs(ListOptions{Cursor: "not-a-cursor"})
+		assert.ErrorIs(t, err, ErrInvalidInput)
+
+		_, _, _, err = storage.QueryVMs(ListOptions{Limit: -1})
+		assert.ErrorIs(t, err, ErrInvalidInput)
+	})
+}
+
+func TestMemoryStorage_QueryUsersAndOrganizations(t *testing.T) {
+	storage, err := NewMemoryStorageForTest()
+	require.NoError(t, err)
+	defer storage.Close()
+
+	require.NoError(t, storage.CreateOrganization(&models.Organization{ID: "org-a", Name: "Alpha", IsEnabled: true}))
+	require.NoError(t, storage.CreateOrganization(&models.Organization{ID: "org-b", Name: "Beta", IsEnabled: false}))
+	require.NoError(t, storage.CreateUser(&models.User{ID: "u1", Username: "alice", Email: "alice@example.com", Role: models.RoleOrgAdmin, OrgID: stringPtr("org-a")}))
+	require.NoError(t, storage.CreateUser(&models.User{ID: "u2", Username: "bob", Email: "bob@example.com", Role: models.RoleOrgUser, OrgID: stringPtr("org-a")}))
+	require.NoError(t, storage.CreateUser(&models.User{ID: "u3", Username: "carol", Email: "carol@example.com", Role: models.RoleSystemAdmin}))
+
+	t.Run("UsersByOrgAndRole", func(t *testing.T) {
+		users, _, _, err := storage.QueryUsers(ListOptions{OrgID: "org-a", Sort: "name"})
+		require.NoError(t, err)
+		require.Len(t, users, 2)
+		assert.Equal(t, "alice", users[0].Username)
+
+		users, _, _, err = storage.QueryUsers(ListOptions{Role: models.RoleSystemAdmin})
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		assert.Equal(t, "carol", users[0].Username)
+	})
+
+	t.Run("OrganizationsByStatus", func(t *testing.T) {
+		orgs, _, _, err := storage.QueryOrganizations(ListOptions{Status: OrgStatusEnabled})
+		require.NoError(t, err)
+		require.Len(t, orgs, 1)
+		assert.Equal(t, "org-a", orgs[0].ID)
+
+		orgs, _, _, err = storage.QueryOrganizations(ListOptions{Status: OrgStatusDisabled})
+		require.NoError(t, err)
+		require.Len(t, orgs, 1)
+		assert.Equal(t, "org-b", orgs[0].ID)
+
+		_, _, _, err = storage.QueryOrganizations(ListOptions{Status: "archived"})
+		assert.ErrorIs(t, err, ErrInvalidInput)
+	})
+}
+
+func TestMemoryStorage_ResourceVersions(t *testing.T) {
+	storage, err := NewMemoryStorage()
+	require.NoError(t, err)
+
+	vm := &models.VirtualMachine{ID: "vm-1", Name: "vm", OrgID: "org-1", Status: models.VMStatusPending}
+	require.NoError(t, storage.CreateVM(vm))
+	assert.Equal(t, int64(1), vm.ResourceVersion)
+
+	// Two clients read the same version
+	first, err := storage.GetVM("vm-1")
+	require.NoError(t, err)
+	second, err := storage.GetVM("vm-1")
+	require.NoError(t, err)
+
+	// Modifying a returned object does not change the stored one
+	first.Status = models.VMStatusRunning
+	stored, err := storage.GetVM("vm-1")
+	require.NoError(t, err)
+	assert.Equal(t, models.VMStatusPending, stored.Status)
+
+	require.NoError(t, storage.UpdateVM(first))
+	assert.Equal(t, int64(2), first.ResourceVersion)
+
+	// The second client lost the race
+	second.Status = models.VMStatusStopped
+	assert.ErrorIs(t, storage.UpdateVM(second), ErrConflict)
+	assert.Equal(t, int64(1), second.ResourceVersion)
+
+	stored, err = storage.GetVM("vm-1")
+	require.NoError(t, err)
+	assert.Equal(t, models.VMStatusRunning, stored.Status)
+	assert.Equal(t, int64(2), stored.ResourceVersion)
+
+	// A zero version skips the check
+	unconditional := &models.VirtualMachine{ID: "vm-1", Name: "vm", OrgID: "org-1", Status: models.VMStatusStopped}
+	require.NoError(t, storage.UpdateVM(unconditional))
+	assert.Equal(t, int64(3), unconditional.ResourceVersion)
+
+	user, err := storage.GetUserByUsername("admin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), user.ResourceVersion)
+	user.ResourceVersion = 7
+	assert.ErrorIs(t, storage.UpdateUser(user), ErrConflict)
+}
+
+func TestMemoryStorage_WithTx(t *testing.T) {
+	storage, err := NewMemoryStorageForTest()
+	require.NoError(t, err)
+	require.NoError(t, storage.CreateUser(&models.User{ID: "user-1", Username: "one", OrgID: stringPtr("org-1")}))
+
+	t.Run("Commit", func(t *testing.T) {
+		err := storage.WithTx(func(tx Storage) error {
+			user, err := tx.GetUserByID("user-1")
+			if err != nil {
+				return err
+			}
+			user.OrgID = nil
+			if err := tx.UpdateUser(user); err != nil {
+				return err
+			}
+			return tx.CreateVM(&models.VirtualMachine{ID: "vm-1", OrgID: "org-1"})
+		})
+		require.NoError(t, err)
+
+		user, err := storage.GetUserByID("user-1")
+		require.NoError(t, err)
+		assert.Nil(t, user.OrgID)
+		_, err = storage.GetVM("vm-1")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		failure := fmt.Errorf("step failed")
+		err := storage.WithTx(func(tx Storage) error {
+			if err := tx.DeleteVM("vm-1"); err != nil {
+				return err
+			}
+			if err := tx.CreateUser(&models.User{ID: "user-2", Username: "two", Email: "two@example.com"}); err != nil {
+				return err
+			}
+
+			// Writes are visible inside the transaction
+			if _, err := tx.GetVM("vm-1"); err != ErrNotFound {
+				return fmt.Errorf("expected deleted VM inside transaction, got %v", err)
+			}
+			return failure
+		})
+		assert.ErrorIs(t, err, failure)
+
+		_, err = storage.GetVM("vm-1")
+		assert.NoError(t, err)
+		_, err = storage.GetUserByID("user-2")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestMemoryStorage_Trash(t *testing.T) {
+	storage, err := NewMemoryStorageForTest()
+	require.NoError(t, err)
+	require.NoError(t, storage.CreateOrganization(&models.Organization{ID: "org-1", Name: "Org 1"}))
+	require.NoError(t, storage.CreateVDC(&models.VirtualDataCenter{ID: "vdc-1", OrgID: "org-1"}))
+	require.NoError(t, storage.CreateVM(&models.VirtualMachine{ID: "vm-1", OrgID: "org-1", VDCID: stringPtr("vdc-1")}))
+
+	t.Run("DeleteHidesRecords", func(t *testing.T) {
+		require.NoError(t, storage.DeleteVM("vm-1"))
+		require.NoError(t, storage.DeleteVDC("vdc-1"))
+		require.NoError(t, storage.DeleteOrganization("org-1"))
+
+		_, err := storage.GetOrganization("org-1")
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = storage.GetVDC("vdc-1")
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = storage.GetVM("vm-1")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		vms, err := storage.ListVMs("org-1")
+		require.NoError(t, err)
+		assert.Empty(t, vms)
+		vdcs, _, _, err := storage.QueryVDCs(ListOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, vdcs)
+
+		assert.ErrorIs(t, storage.DeleteVM("vm-1"), ErrNotFound)
+		assert.ErrorIs(t, storage.UpdateVM(&models.VirtualMachine{ID: "vm-1"}), ErrNotFound)
+		assert.ErrorIs(t, storage.CreateVM(&models.VirtualMachine{ID: "vm-1"}), ErrAlreadyExists)
+	})
+
+	t.Run("ListDeleted", func(t *testing.T) {
+		orgs, err := storage.ListDeletedOrganizations()
+		require.NoError(t, err)
+		require.Len(t, orgs, 1)
+		require.NotNil(t, orgs[0].DeletedAt)
+		assert.Equal(t, int64(2), orgs[0].ResourceVersion)
+
+		vdcs, err := storage.ListDeletedVDCs()
+		require.NoError(t, err)
+		assert.Len(t, vdcs, 1)
+		vms, err := storage.ListDeletedVMs()
+		require.NoError(t, err)
+		assert.Len(t, vms, 1)
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		require.NoError(t, storage.RestoreOrganization("org-1"))
+		assert.ErrorIs(t, storage.RestoreOrganization("org-1"), ErrNotFound)
+		assert.ErrorIs(t, storage.RestoreVDC("missing"), ErrNotFound)
+
+		org, err := storage.GetOrganization("org-1")
+		require.NoError(t, err)
+		assert.Nil(t, org.DeletedAt)
+		assert.Equal(t, int64(3), org.ResourceVersion)
+
+		orgs, err := storage.ListDeletedOrganizations()
+		require.NoError(t, err)
+		assert.Empty(t, orgs)
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		require.NoError(t, storage.PurgeVM("vm-1"))
+		assert.ErrorIs(t, storage.PurgeVM("vm-1"), ErrNotFound)
+		assert.ErrorIs(t, storage.RestoreVM("vm-1"), ErrNotFound)
+
+		// Live records can be purged as well
+		require.NoError(t, storage.PurgeOrganization("org-1"))
+		_, err := storage.GetOrganization("org-1")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		// The id is free again once purged
+		assert.NoError(t, storage.CreateVM(&models.VirtualMachine{ID: "vm-1"}))
+	})
+}
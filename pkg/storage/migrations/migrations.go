@@ -0,0 +1,385 @@
+// Package migrations implements versioned schema migrations for the SQL storage backends.
+//
+// Migrations are SQL files embedded in the binary and named NNN_description.up.sql and
+// NNN_description.down.sql. PostgreSQL scripts live in sql/, their SQLite counterparts in
+// sql/sqlite/ under the same versions. Applied versions are tracked in the schema_migrations
+// table, using the same layout as the hand-written migration scripts of earlier releases.
+package migrations
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DialectPostgres and DialectSQLite are the GORM dialect names with embedded migrations
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+
+	// tableName is the table that records applied migrations
+	tableName = "schema_migrations"
+
+	// advisoryLockID serializes migrations across replicas starting at the same time
+	advisoryLockID = 0x6f76696d // "ovim"
+)
+
+var (
+	// ErrSchemaTooNew is returned when the database contains migrations unknown to this binary
+	ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+	// ErrIrreversible is returned when rolling back a migration that has no down script
+	ErrIrreversible = errors.New("migration cannot be rolled back")
+)
+
+//go:embed sql/*.sql sql/sqlite/*.sql
+var sqlFiles embed.FS
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a single schema change with its rollback
+type Migration struct {
+	Version     int
+	Description string
+	Up          string
+	Down        string
+}
+
+// Status describes whether a migration has been applied to the database
+type Status struct {
+	Version     int
+	Description string
+	Applied     bool
+	AppliedAt   *time.Time
+	// Unknown is set for versions recorded in the database that this binary does not ship
+	Unknown bool
+}
+
+// appliedMigration is a row of the schema_migrations table
+type appliedMigration struct {
+	Version     string    `gorm:"column:version"`
+	AppliedAt   time.Time `gorm:"column:applied_at"`
+	Description string    `gorm:"column:description"`
+}
+
+// Migrator applies and rolls back migrations against a database
+type Migrator struct {
+	db         *gorm.DB
+	dialect    string
+	migrations []Migration
+}
+
+// New creates a migrator for the embedded migrations of the dialect of db
+func New(db *gorm.DB) (*Migrator, error) {
+	dialect := db.Dialector.Name()
+	migrations, err := LoadDialect(dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// Load parses the embedded PostgreSQL migration files, ordered by version
+func Load() ([]Migration, error) {
+	return LoadDialect(DialectPostgres)
+}
+
+// LoadDialect parses the embedded migration files of a dialect, ordered by version
+func LoadDialect(dialect string) ([]Migration, error) {
+	switch dialect {
+	case DialectPostgres:
+		return load(sqlFiles, "sql")
+	case DialectSQLite:
+		return load(sqlFiles, "sql/sqlite")
+	default:
+		return nil, fmt.Errorf("no migrations for database dialect %q", dialect)
+	}
+}
+
+func load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		description := strings.ReplaceAll(match[2], "_", " ")
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		migration, exists := byVersion[version]
+		if !exists {
+			migration = &Migration{Version: version, Description: description}
+			byVersion[version] = migration
+		} else if migration.Description != description {
+			return nil, fmt.Errorf("migration %03d has conflicting names %q and %q", version, migration.Description, description)
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if strings.TrimSpace(migration.Up) == "" {
+			return nil, fmt.Errorf("migration %03d has no up script", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Latest returns the highest migration version known to this binary
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return -1
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status reports every known migration and any unknown versions recorded in the database
+func (m *Migrator) Status() ([]Status, error) {
+	if err := m.ensureTable(m.db); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	known := make(map[int]bool, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+		status := Status{Version: migration.Version, Description: migration.Description}
+		if record, ok := applied[migration.Version]; ok {
+			appliedAt := record.AppliedAt
+			status.Applied = true
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	for version, record := range applied {
+		if known[version] {
+			continue
+		}
+		appliedAt := record.AppliedAt
+		statuses = append(statuses, Status{
+			Version:     version,
+			Description: record.Description,
+			Applied:     true,
+			AppliedAt:   &appliedAt,
+			Unknown:     true,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// Check returns ErrSchemaTooNew if the database has migrations this binary does not know about
+func (m *Migrator) Check() error {
+	if err := m.ensureTable(m.db); err != nil {
+		return err
+	}
+	applied, err := m.applied(m.db)
+	if err != nil {
+		return err
+	}
+	return m.checkApplied(applied)
+}
+
+// Up applies all pending migrations in order and returns the ones that were applied
+func (m *Migrator) Up() ([]Migration, error) {
+	if err := m.Check(); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range m.migrations {
+		migration := migration
+		ran := false
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			applied, err := m.lock(tx)
+			if err != nil {
+				return err
+			}
+			if _, ok := applied[migration.Version]; ok {
+				return nil
+			}
+
+			klog.Infof("Applying migration %03d (%s)", migration.Version, migration.Description)
+			if err := tx.Exec(migration.Up).Error; err != nil {
+				return fmt.Errorf("migration %03d failed: %w", migration.Version, err)
+			}
+			record := appliedMigration{
+				Version:     formatVersion(migration.Version),
+				AppliedAt:   time.Now(),
+				Description: migration.Description,
+			}
+			if err := tx.Table(tableName).Create(&record).Error; err != nil {
+				return fmt.Errorf("failed to record migration %03d: %w", migration.Version, err)
+			}
+			ran = true
+			return nil
+		})
+		if err != nil {
+			return done, err
+		}
+		if ran {
+			done = append(done, migration)
+		}
+	}
+	return done, nil
+}
+
+// Down rolls back the given number of most recently applied migrations and returns them
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	if steps < 1 {
+		return nil, fmt.Errorf("steps must be at least 1, got %d", steps)
+	}
+	if err := m.Check(); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		ran := false
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			applied, err := m.lock(tx)
+			if err != nil {
+				return err
+			}
+			if _, ok := applied[migration.Version]; !ok {
+				return nil
+			}
+			if strings.TrimSpace(migration.Down) == "" {
+				return fmt.Errorf("migration %03d: %w", migration.Version, ErrIrreversible)
+			}
+
+			klog.Infof("Rolling back migration %03d (%s)", migration.Version, migration.Description)
+			if err := tx.Exec(migration.Down).Error; err != nil {
+				return fmt.Errorf("rollback of migration %03d failed: %w", migration.Version, err)
+			}
+			if err := tx.Exec("DELETE FROM "+tableName+" WHERE version = ?", formatVersion(migration.Version)).Error; err != nil {
+				return fmt.Errorf("failed to remove migration record %03d: %w", migration.Version, err)
+			}
+			ran = true
+			return nil
+		})
+		if err != nil {
+			return done, err
+		}
+		if ran {
+			done = append(done, migration)
+		}
+	}
+	return done, nil
+}
+
+// Close closes the underlying database connection
+func (m *Migrator) Close() error {
+	sqlDB, err := m.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// ensureTable creates the tracking table if it does not exist yet
+func (m *Migrator) ensureTable(db *gorm.DB) error {
+	err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + tableName + ` (
+    version VARCHAR(50) PRIMARY KEY,
+    applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    description TEXT
+)`).Error
+	if err != nil {
+		return fmt.Errorf("failed to create %s table: %w", tableName, err)
+	}
+	return nil
+}
+
+// lock takes the migration lock for the transaction and returns the applied versions,
+// re-checking the schema version since another replica may have migrated in the meantime.
+// SQLite has a single writer, so its write transaction already is the lock.
+func (m *Migrator) lock(tx *gorm.DB) (map[int]appliedMigration, error) {
+	if m.dialect == DialectPostgres {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", advisoryLockID).Error; err != nil {
+			return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+	}
+	if err := m.ensureTable(tx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkApplied(applied); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// applied returns the recorded migrations keyed by version
+func (m *Migrator) applied(db *gorm.DB) (map[int]appliedMigration, error) {
+	var records []appliedMigration
+	if err := db.Table(tableName).Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", tableName, err)
+	}
+
+	applied := make(map[int]appliedMigration, len(records))
+	for _, record := range records {
+		version, err := strconv.Atoi(strings.TrimSpace(record.Version))
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %q in %s", record.Version, tableName)
+		}
+		applied[version] = record
+	}
+	return applied, nil
+}
+
+func (m *Migrator) checkApplied(applied map[int]appliedMigration) error {
+	latest := m.Latest()
+	for version := range applied {
+		if version > latest {
+			return fmt.Errorf("%w: database is at version %03d, latest known version is %03d", ErrSchemaTooNew, version, latest)
+		}
+	}
+	return nil
+}
+
+// formatVersion renders a version the way it is stored in schema_migrations
+func formatVersion(version int) string {
+	return fmt.Sprintf("%03d", version)
+}
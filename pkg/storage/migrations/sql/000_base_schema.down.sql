@@ -0,0 +1,13 @@
+-- ============================================================================
+-- OVIM Database Rollback: 000 - Base Schema
+-- ============================================================================
+--
+-- WARNING: This drops every OVIM table and all of its data.
+--
+-- ============================================================================
+
+DROP TABLE IF EXISTS organization_catalog_sources;
+DROP TABLE IF EXISTS virtual_machines;
+DROP TABLE IF EXISTS templates;
+DROP TABLE IF EXISTS organizations;
+DROP TABLE IF EXISTS users;
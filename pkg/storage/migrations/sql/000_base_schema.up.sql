@@ -0,0 +1,98 @@
+-- ============================================================================
+-- OVIM Database Migration: 000 - Base Schema
+-- ============================================================================
+--
+-- Creates the tables that predate the CRD architecture. Databases that were
+-- initialized by earlier releases already contain these tables, so every
+-- statement is idempotent and the migration only records the baseline there.
+--
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS users (
+    id TEXT PRIMARY KEY,
+    username TEXT,
+    email TEXT,
+    password_hash TEXT,
+    role TEXT,
+    org_id TEXT,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users(username);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users(email);
+
+CREATE TABLE IF NOT EXISTS organizations (
+    id TEXT PRIMARY KEY,
+    name TEXT,
+    description TEXT,
+    namespace TEXT,
+    is_enabled BOOLEAN DEFAULT TRUE,
+    cpu_quota INTEGER DEFAULT 0,
+    memory_quota INTEGER DEFAULT 0,
+    storage_quota INTEGER DEFAULT 0,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_organizations_name ON organizations(name);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_organizations_namespace ON organizations(namespace);
+
+CREATE TABLE IF NOT EXISTS templates (
+    id TEXT PRIMARY KEY,
+    name TEXT,
+    template_name TEXT,
+    description TEXT,
+    os_type TEXT,
+    os_version TEXT,
+    cpu BIGINT,
+    memory TEXT,
+    disk_size TEXT,
+    image_url TEXT,
+    icon_class TEXT,
+    org_id TEXT,
+    source TEXT DEFAULT 'global',
+    source_vendor TEXT DEFAULT 'Red Hat',
+    category TEXT DEFAULT 'Operating System',
+    namespace TEXT,
+    featured BOOLEAN,
+    metadata JSONB,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_templates_org_id ON templates(org_id);
+
+CREATE TABLE IF NOT EXISTS virtual_machines (
+    id TEXT PRIMARY KEY,
+    name TEXT,
+    org_id TEXT,
+    template_id TEXT,
+    owner_id TEXT,
+    status TEXT,
+    cpu BIGINT,
+    memory TEXT,
+    disk_size TEXT,
+    ip_address TEXT,
+    metadata JSONB,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_virtual_machines_org_id ON virtual_machines(org_id);
+CREATE INDEX IF NOT EXISTS idx_virtual_machines_template_id ON virtual_machines(template_id);
+CREATE INDEX IF NOT EXISTS idx_virtual_machines_owner_id ON virtual_machines(owner_id);
+CREATE INDEX IF NOT EXISTS idx_virtual_machines_status ON virtual_machines(status);
+
+CREATE TABLE IF NOT EXISTS organization_catalog_sources (
+    id TEXT PRIMARY KEY,
+    org_id TEXT,
+    source_type TEXT,
+    source_name TEXT,
+    source_namespace TEXT,
+    enabled BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_organization_catalog_sources_org_id ON organization_catalog_sources(org_id);
@@ -10,14 +10,17 @@
 --
 -- ============================================================================
 
--- Begin transaction
-BEGIN;
+-- ============================================================================
+-- 1. DROP TRIGGERS
+-- ============================================================================
 
-\echo 'WARNING: This will destroy all VDC and Catalog data!'
-\echo 'Press Ctrl+C to cancel, or continue to proceed with rollback...'
+DROP TRIGGER IF EXISTS update_organizations_updated_at ON organizations;
+DROP TRIGGER IF EXISTS update_virtual_data_centers_updated_at ON virtual_data_centers;
+DROP TRIGGER IF EXISTS update_catalogs_updated_at ON catalogs;
+DROP FUNCTION IF EXISTS update_updated_at_column();
 
 -- ============================================================================
--- 1. DROP NEW TABLES (WARNING: DATA LOSS)
+-- 2. DROP NEW TABLES (WARNING: DATA LOSS)
 -- ============================================================================
 
 -- Drop catalogs table and all data
@@ -27,7 +30,7 @@ DROP TABLE IF EXISTS catalogs CASCADE;
 DROP TABLE IF EXISTS virtual_data_centers CASCADE;
 
 -- ============================================================================
--- 2. RESTORE ORGANIZATIONS TABLE (Remove CRD fields, add quotas back)
+-- 3. RESTORE ORGANIZATIONS TABLE (Remove CRD fields, add quotas back)
 -- ============================================================================
 
 -- Remove CRD integration fields
@@ -44,40 +47,16 @@ ALTER TABLE organizations ADD COLUMN IF NOT EXISTS memory_quota INTEGER DEFAULT
 ALTER TABLE organizations ADD COLUMN IF NOT EXISTS storage_quota INTEGER DEFAULT 0;
 
 -- ============================================================================
--- 3. CLEAN UP VIRTUAL_MACHINES TABLE
+-- 4. CLEAN UP VIRTUAL_MACHINES TABLE
 -- ============================================================================
 
 -- Remove VDC association from virtual machines
 ALTER TABLE virtual_machines DROP COLUMN IF EXISTS vdc_id;
 
 -- ============================================================================
--- 4. CLEAN UP TEMPLATES TABLE
+-- 5. CLEAN UP TEMPLATES TABLE
 -- ============================================================================
 
 -- Remove catalog integration from templates
 ALTER TABLE templates DROP COLUMN IF EXISTS catalog_id;
 ALTER TABLE templates DROP COLUMN IF EXISTS content_type;
-
--- ============================================================================
--- 5. DROP TRIGGERS
--- ============================================================================
-
-DROP TRIGGER IF EXISTS update_organizations_updated_at ON organizations;
-DROP TRIGGER IF EXISTS update_virtual_data_centers_updated_at ON virtual_data_centers;
-DROP TRIGGER IF EXISTS update_catalogs_updated_at ON catalogs;
-
--- ============================================================================
--- 6. REMOVE MIGRATION RECORD
--- ============================================================================
-
-DELETE FROM schema_migrations WHERE version = '001';
-
--- ============================================================================
--- 7. COMMIT ROLLBACK
--- ============================================================================
-
-COMMIT;
-
-\echo 'Rollback 001 completed - CRD architecture has been removed.'
-\echo 'All VDC and Catalog data has been permanently deleted.'
-\echo 'Organizations have been restored to legacy quota model.'
\ No newline at end of file
@@ -10,11 +10,11 @@
 -- 3. Add CRD integration fields for Kubernetes synchronization
 -- 4. Support for Catalog CRDs and content management
 --
+-- The migration engine runs this file in a single transaction and records
+-- the applied version itself.
+--
 -- ============================================================================
 
--- Begin transaction
-BEGIN;
-
 -- ============================================================================
 -- 1. UPDATE ORGANIZATIONS TABLE (Remove quotas, add CRD fields)
 -- ============================================================================
@@ -242,47 +242,3 @@ WHERE namespace IS NULL OR namespace = '';
 UPDATE organizations 
 SET cr_name = name 
 WHERE cr_name IS NULL;
-
--- ============================================================================
--- 8. COMMIT MIGRATION
--- ============================================================================
-
--- Record migration in schema_migrations table (create if not exists)
-CREATE TABLE IF NOT EXISTS schema_migrations (
-    version VARCHAR(50) PRIMARY KEY,
-    applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    description TEXT
-);
-
--- Record this migration
-INSERT INTO schema_migrations (version, description) 
-VALUES ('001', 'Organization and VDC CRD architecture migration')
-ON CONFLICT (version) DO UPDATE SET 
-    applied_at = CURRENT_TIMESTAMP,
-    description = EXCLUDED.description;
-
--- Commit transaction
-COMMIT;
-
--- ============================================================================
--- MIGRATION COMPLETE
--- ============================================================================
-
--- Display migration summary
-DO $$ 
-BEGIN
-    RAISE NOTICE 'Migration 001 completed successfully!';
-    RAISE NOTICE 'Summary of changes:';
-    RAISE NOTICE '- Updated organizations table (removed quotas, added CRD fields)';
-    RAISE NOTICE '- Created virtual_data_centers table with resource quotas';
-    RAISE NOTICE '- Created catalogs table for content management';
-    RAISE NOTICE '- Updated virtual_machines table with VDC association';
-    RAISE NOTICE '- Updated templates table with catalog integration';
-    RAISE NOTICE '- Added updated_at triggers for automatic timestamps';
-    RAISE NOTICE '- Created indexes for optimal query performance';
-    RAISE NOTICE '';
-    RAISE NOTICE 'Next steps:';
-    RAISE NOTICE '1. Deploy CRD definitions: kubectl apply -f config/crd/';
-    RAISE NOTICE '2. Deploy controllers to sync CRD status with database';
-    RAISE NOTICE '3. Validate migration with: psql -f scripts/migrations/validate_migration_001.sql';
-END $$;
\ No newline at end of file
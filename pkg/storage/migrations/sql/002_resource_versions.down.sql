@@ -0,0 +1,9 @@
+-- ============================================================================
+-- OVIM Database Rollback: 002 - Resource Versions
+-- ============================================================================
+
+ALTER TABLE virtual_machines DROP COLUMN IF EXISTS resource_version;
+ALTER TABLE templates DROP COLUMN IF EXISTS resource_version;
+ALTER TABLE virtual_data_centers DROP COLUMN IF EXISTS resource_version;
+ALTER TABLE organizations DROP COLUMN IF EXISTS resource_version;
+ALTER TABLE users DROP COLUMN IF EXISTS resource_version;
@@ -0,0 +1,15 @@
+-- ============================================================================
+-- OVIM Database Migration: 002 - Resource Versions
+-- ============================================================================
+--
+-- Adds the resource_version column used for optimistic concurrency control.
+-- Every update increments it, and the API exposes it as the resource ETag.
+-- Existing rows start at version 1.
+--
+-- ============================================================================
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS resource_version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS resource_version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE virtual_data_centers ADD COLUMN IF NOT EXISTS resource_version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE templates ADD COLUMN IF NOT EXISTS resource_version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE virtual_machines ADD COLUMN IF NOT EXISTS resource_version BIGINT NOT NULL DEFAULT 1;
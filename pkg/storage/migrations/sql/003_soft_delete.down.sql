@@ -0,0 +1,16 @@
+-- ============================================================================
+-- OVIM Database Rollback: 003 - Soft Delete
+-- ============================================================================
+--
+-- Rows still in the trash become live again once the column is dropped,
+-- purge or restore them before rolling back.
+--
+-- ============================================================================
+
+DROP INDEX IF EXISTS idx_virtual_machines_deleted_at;
+DROP INDEX IF EXISTS idx_virtual_data_centers_deleted_at;
+DROP INDEX IF EXISTS idx_organizations_deleted_at;
+
+ALTER TABLE virtual_machines DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE virtual_data_centers DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE organizations DROP COLUMN IF EXISTS deleted_at;
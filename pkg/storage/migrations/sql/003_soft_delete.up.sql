@@ -0,0 +1,17 @@
+-- ============================================================================
+-- OVIM Database Migration: 003 - Soft Delete
+-- ============================================================================
+--
+-- Adds the deleted_at tombstone to organizations, VDCs and VMs. Deleting one
+-- of them only sets deleted_at; the row stays in the trash until it is
+-- restored or purged once the retention period has passed.
+--
+-- ============================================================================
+
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE virtual_data_centers ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE virtual_machines ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_organizations_deleted_at ON organizations(deleted_at);
+CREATE INDEX IF NOT EXISTS idx_virtual_data_centers_deleted_at ON virtual_data_centers(deleted_at);
+CREATE INDEX IF NOT EXISTS idx_virtual_machines_deleted_at ON virtual_machines(deleted_at);
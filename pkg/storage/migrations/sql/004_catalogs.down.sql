@@ -0,0 +1,6 @@
+-- ============================================================================
+-- OVIM Database Rollback: 004 - Catalogs
+-- ============================================================================
+
+ALTER TABLE templates DROP CONSTRAINT IF EXISTS fk_templates_catalog;
+ALTER TABLE catalogs DROP COLUMN IF EXISTS resource_version;
@@ -0,0 +1,19 @@
+-- ============================================================================
+-- OVIM Database Migration: 004 - Catalogs
+-- ============================================================================
+--
+-- Makes catalogs versioned like the other resources and links templates to
+-- the catalog they came from. Deleting a catalog keeps its templates but
+-- clears their catalog_id. References to catalogs that do not exist are
+-- cleared before the constraint is added.
+--
+-- ============================================================================
+
+ALTER TABLE catalogs ADD COLUMN IF NOT EXISTS resource_version BIGINT NOT NULL DEFAULT 1;
+
+UPDATE templates SET catalog_id = NULL
+WHERE catalog_id IS NOT NULL AND catalog_id NOT IN (SELECT id FROM catalogs);
+
+ALTER TABLE templates DROP CONSTRAINT IF EXISTS fk_templates_catalog;
+ALTER TABLE templates ADD CONSTRAINT fk_templates_catalog
+    FOREIGN KEY (catalog_id) REFERENCES catalogs(id) ON DELETE SET NULL;
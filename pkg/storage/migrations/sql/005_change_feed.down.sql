@@ -0,0 +1,12 @@
+-- ============================================================================
+-- OVIM Database Rollback: 005 - Change Feed
+-- ============================================================================
+
+DROP TRIGGER IF EXISTS notify_users_change ON users;
+DROP TRIGGER IF EXISTS notify_organizations_change ON organizations;
+DROP TRIGGER IF EXISTS notify_virtual_data_centers_change ON virtual_data_centers;
+DROP TRIGGER IF EXISTS notify_catalogs_change ON catalogs;
+DROP TRIGGER IF EXISTS notify_templates_change ON templates;
+DROP TRIGGER IF EXISTS notify_virtual_machines_change ON virtual_machines;
+DROP TRIGGER IF EXISTS notify_organization_catalog_sources_change ON organization_catalog_sources;
+DROP FUNCTION IF EXISTS ovim_notify_change();
@@ -0,0 +1,102 @@
+-- ============================================================================
+-- OVIM Database Migration: 005 - Change Feed
+-- ============================================================================
+--
+-- Notifies the ovim_changes channel of every change to a record, so that
+-- every server sharing the database can stream changes to its watchers.
+-- Notifications are only delivered once the transaction commits. The payload
+-- identifies the record, watchers read the record itself if they need it.
+--
+-- Soft deleting a record is reported as deleted and restoring it as created.
+-- Changes to records in the trash, purging included, are not reported.
+--
+-- ============================================================================
+
+CREATE OR REPLACE FUNCTION ovim_notify_change()
+RETURNS TRIGGER AS $$
+DECLARE
+    rec JSONB;
+    change TEXT;
+    org TEXT;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        rec := to_jsonb(NEW);
+        change := 'created';
+    ELSIF TG_OP = 'DELETE' THEN
+        rec := to_jsonb(OLD);
+        IF rec->>'deleted_at' IS NOT NULL THEN
+            RETURN NULL;
+        END IF;
+        change := 'deleted';
+    ELSE
+        rec := to_jsonb(NEW);
+        IF to_jsonb(OLD)->>'deleted_at' IS NULL AND rec->>'deleted_at' IS NOT NULL THEN
+            change := 'deleted';
+        ELSIF to_jsonb(OLD)->>'deleted_at' IS NOT NULL AND rec->>'deleted_at' IS NULL THEN
+            change := 'created';
+        ELSIF rec->>'deleted_at' IS NOT NULL THEN
+            RETURN NULL;
+        ELSE
+            change := 'updated';
+        END IF;
+    END IF;
+
+    -- Organizations belong to themselves
+    org := rec->>'org_id';
+    IF TG_ARGV[0] = 'organization' THEN
+        org := rec->>'id';
+    END IF;
+
+    PERFORM pg_notify('ovim_changes', json_build_object(
+        'type', change,
+        'kind', TG_ARGV[0],
+        'id', rec->>'id',
+        'org_id', org,
+        'owner_id', rec->>'owner_id',
+        'resource_version', (rec->>'resource_version')::BIGINT
+    )::TEXT);
+    RETURN NULL;
+END;
+$$ LANGUAGE 'plpgsql';
+
+DROP TRIGGER IF EXISTS notify_users_change ON users;
+CREATE TRIGGER notify_users_change
+    AFTER INSERT OR UPDATE OR DELETE ON users
+    FOR EACH ROW
+    EXECUTE FUNCTION ovim_notify_change('user');
+
+DROP TRIGGER IF EXISTS notify_organizations_change ON organizations;
+CREATE TRIGGER notify_organizations_change
+    AFTER INSERT OR UPDATE OR DELETE ON organizations
+    FOR EACH ROW
+    EXECUTE FUNCTION ovim_notify_change('organization');
+
+DROP TRIGGER IF EXISTS notify_virtual_data_centers_change ON virtual_data_centers;
+CREATE TRIGGER notify_virtual_data_centers_change
+    AFTER INSERT OR UPDATE OR DELETE ON virtual_data_centers
+    FOR EACH ROW
+    EXECUTE FUNCTION ovim_notify_change('vdc');
+
+DROP TRIGGER IF EXISTS notify_catalogs_change ON catalogs;
+CREATE TRIGGER notify_catalogs_change
+    AFTER INSERT OR UPDATE OR DELETE ON catalogs
+    FOR EACH ROW
+    EXECUTE FUNCTION ovim_notify_change('catalog');
+
+DROP TRIGGER IF EXISTS notify_templates_change ON templates;
+CREATE TRIGGER notify_templates_change
+    AFTER INSERT OR UPDATE OR DELETE ON templates
+    FOR EACH ROW
+    EXECUTE FUNCTION ovim_notify_change('template');
+
+DROP TRIGGER IF EXISTS notify_virtual_machines_change ON virtual_machines;
+CREATE TRIGGER notify_virtual_machines_change
+    AFTER INSERT OR UPDATE OR DELETE ON virtual_machines
+    FOR EACH ROW
+    EXECUTE FUNCTION ovim_notify_change('vm');
+
+DROP TRIGGER IF EXISTS notify_organization_catalog_sources_change ON organization_catalog_sources;
+CREATE TRIGGER notify_organization_catalog_sources_change
+    AFTER INSERT OR UPDATE OR DELETE ON organization_catalog_sources
+    FOR EACH ROW
+    EXECUTE FUNCTION ovim_notify_change('catalog_source');
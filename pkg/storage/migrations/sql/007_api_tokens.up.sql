@@ -0,0 +1,26 @@
+-- ============================================================================
+-- OVIM Database Migration: 007 - API Tokens
+-- ============================================================================
+--
+-- Stores the personal access tokens that scripts and pipelines use instead of
+-- logging in. Only the hash of a token is stored, and tokens go away with
+-- their user.
+--
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    token_hash TEXT NOT NULL,
+    scopes JSONB NULL,
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    UNIQUE (user_id, name)  -- Token names must be unique per user
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_tokens_token_hash ON api_tokens(token_hash);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
@@ -0,0 +1,5 @@
+-- ============================================================================
+-- OVIM Database Rollback: 008 - Organization Roles
+-- ============================================================================
+
+DROP TABLE IF EXISTS org_roles;
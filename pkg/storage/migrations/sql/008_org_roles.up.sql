@@ -0,0 +1,21 @@
+-- ============================================================================
+-- OVIM Database Migration: 008 - Organization Roles
+-- ============================================================================
+--
+-- Stores the custom roles organizations define for their users. A role grants
+-- a list of verbs within its organization and goes away with it.
+--
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS org_roles (
+    id TEXT PRIMARY KEY,
+    org_id TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    verbs JSONB NULL,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    UNIQUE (org_id, name)  -- Role names must be unique per organization
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_roles_org_id ON org_roles(org_id);
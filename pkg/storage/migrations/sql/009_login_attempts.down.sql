@@ -0,0 +1,5 @@
+-- ============================================================================
+-- OVIM Database Rollback: 009 - Login Attempts
+-- ============================================================================
+
+DROP TABLE IF EXISTS login_attempts;
@@ -0,0 +1,17 @@
+-- ============================================================================
+-- OVIM Database Migration: 009 - Login Attempts
+-- ============================================================================
+--
+-- Tracks the recent failed password logins of accounts and client addresses,
+-- so that every server replica delays and locks out the same guessing.
+--
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id TEXT PRIMARY KEY,  -- "user:<username>" or "ip:<address>"
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_last_failure_at ON login_attempts(last_failure_at);
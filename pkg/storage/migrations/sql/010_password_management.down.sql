@@ -0,0 +1,9 @@
+-- ============================================================================
+-- OVIM Database Rollback: 010 - Password Management
+-- ============================================================================
+
+DROP TABLE IF EXISTS password_reset_tokens;
+
+ALTER TABLE users DROP COLUMN IF EXISTS password_history;
+ALTER TABLE users DROP COLUMN IF EXISTS password_changed_at;
+ALTER TABLE users DROP COLUMN IF EXISTS must_change_password;
@@ -0,0 +1,10 @@
+-- ============================================================================
+-- OVIM Database Rollback: 011 - Multi-Factor Authentication
+-- ============================================================================
+
+ALTER TABLE organizations DROP COLUMN IF EXISTS require_mfa;
+
+ALTER TABLE users DROP COLUMN IF EXISTS mfa_recovery_codes;
+ALTER TABLE users DROP COLUMN IF EXISTS mfa_last_counter;
+ALTER TABLE users DROP COLUMN IF EXISTS mfa_secret;
+ALTER TABLE users DROP COLUMN IF EXISTS mfa_enabled;
@@ -0,0 +1,17 @@
+-- ============================================================================
+-- OVIM Database Migration: 011 - Multi-Factor Authentication
+-- ============================================================================
+--
+-- Stores the TOTP secret of users enrolling or enrolled in multi-factor
+-- authentication, the period of the last code they used, so that codes
+-- cannot be replayed, and the hashes of their unused recovery codes.
+-- Organizations can require their users to enroll.
+--
+-- ============================================================================
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_last_counter BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS mfa_recovery_codes JSONB NULL;
+
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS require_mfa BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,5 @@
+-- ============================================================================
+-- OVIM Database Rollback: 012 - Impersonations
+-- ============================================================================
+
+DROP TABLE IF EXISTS impersonations;
@@ -0,0 +1,23 @@
+-- ============================================================================
+-- OVIM Database Migration: 012 - Impersonations
+-- ============================================================================
+--
+-- Records every time a system administrator impersonated a user, so that the
+-- user can see it. Records go away with the impersonated user; token_id is
+-- the jti of the impersonation token, revoked when the impersonation ends.
+--
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS impersonations (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    impersonator_id TEXT NOT NULL,
+    impersonator_username TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    token_id TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ,
+    ended_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_impersonations_user_id ON impersonations(user_id);
@@ -0,0 +1,21 @@
+-- ============================================================================
+-- OVIM Database Migration: 013 - Organization Memberships
+-- ============================================================================
+--
+-- Lets a user belong to organizations besides its own, with a role in each.
+-- A membership goes away with its user or organization, and a user is a
+-- member of an organization at most once.
+--
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS org_memberships (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    org_id TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    role TEXT NOT NULL,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    UNIQUE (user_id, org_id)  -- One membership per user and organization
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_memberships_org_id ON org_memberships(org_id);
@@ -0,0 +1,5 @@
+-- ============================================================================
+-- OVIM Database Rollback: 014 - Invitations
+-- ============================================================================
+
+DROP TABLE IF EXISTS invitations;
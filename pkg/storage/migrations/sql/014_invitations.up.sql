@@ -0,0 +1,27 @@
+-- ============================================================================
+-- OVIM Database Migration: 014 - Invitations
+-- ============================================================================
+--
+-- Records the invitations of organization administrators to join their
+-- organization with a role. Invitations go away with their organization;
+-- token_id is the jti of the last token sent, and user_id the user who
+-- accepted the invitation, kept as NULL once that user is deleted.
+--
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS invitations (
+    id TEXT PRIMARY KEY,
+    org_id TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    role TEXT NOT NULL,
+    invited_by TEXT NOT NULL DEFAULT '',
+    token_id TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    accepted_at TIMESTAMPTZ,
+    user_id TEXT REFERENCES users(id) ON DELETE SET NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_invitations_org_id ON invitations(org_id);
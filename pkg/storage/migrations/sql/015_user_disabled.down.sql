@@ -0,0 +1,5 @@
+-- ============================================================================
+-- OVIM Database Rollback: 015 - Disabled Users
+-- ============================================================================
+
+ALTER TABLE users DROP COLUMN IF EXISTS disabled;
@@ -0,0 +1,10 @@
+-- ============================================================================
+-- OVIM Database Migration: 015 - Disabled Users
+-- ============================================================================
+--
+-- Lets users be disabled without deleting them, such as when their identity
+-- provider deprovisions them. Disabled users cannot authenticate.
+--
+-- ============================================================================
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT FALSE;
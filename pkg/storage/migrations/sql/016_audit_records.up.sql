@@ -0,0 +1,38 @@
+-- ============================================================================
+-- OVIM Database Migration: 016 - Audit Records
+-- ============================================================================
+--
+-- Records every mutating API call with its actor, the administrator
+-- impersonating the actor if any, the organization and resource it targeted,
+-- the redacted request, the changes it made and its outcome. Records are
+-- append-only and reference nothing, so that they outlive the users and
+-- organizations they name.
+--
+-- ============================================================================
+
+CREATE TABLE IF NOT EXISTS audit_records (
+    id TEXT PRIMARY KEY,
+    actor_id TEXT NOT NULL DEFAULT '',
+    actor_username TEXT NOT NULL DEFAULT '',
+    actor_role TEXT NOT NULL DEFAULT '',
+    impersonator_id TEXT NOT NULL DEFAULT '',
+    impersonator_username TEXT NOT NULL DEFAULT '',
+    org_id TEXT NOT NULL DEFAULT '',
+    method TEXT NOT NULL,
+    route TEXT NOT NULL,
+    path TEXT NOT NULL,
+    resource_type TEXT NOT NULL DEFAULT '',
+    resource_id TEXT NOT NULL DEFAULT '',
+    request JSONB NULL,
+    changes JSONB NULL,
+    status_code INTEGER NOT NULL,
+    outcome TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    client_ip TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_records_created_at ON audit_records(created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_records_actor_id ON audit_records(actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_records_org_id ON audit_records(org_id);
+CREATE INDEX IF NOT EXISTS idx_audit_records_resource_id ON audit_records(resource_id);
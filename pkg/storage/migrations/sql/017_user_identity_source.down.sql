@@ -0,0 +1,7 @@
+-- ============================================================================
+-- OVIM Database Rollback: 017 - User Identity Sources
+-- ============================================================================
+
+DROP INDEX IF EXISTS idx_users_external_id;
+ALTER TABLE users DROP COLUMN IF EXISTS external_id;
+ALTER TABLE users DROP COLUMN IF EXISTS auth_source;
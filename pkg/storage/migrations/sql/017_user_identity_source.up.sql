@@ -0,0 +1,19 @@
+-- ============================================================================
+-- OVIM Database Migration: 017 - User Identity Sources
+-- ============================================================================
+--
+-- Records where the identity of each user comes from (local, oidc, ldap or
+-- scim) and its subject there, so that logins through a source only match the
+-- users of that source. Users without a password could only be created by
+-- OIDC logins before, with the OIDC subject as their ID.
+--
+-- ============================================================================
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS auth_source VARCHAR(20) NOT NULL DEFAULT 'local';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS external_id VARCHAR(512) NOT NULL DEFAULT '';
+
+UPDATE users SET auth_source = 'oidc', external_id = id
+WHERE COALESCE(password_hash, '') = '' AND external_id = '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_external_id ON users (auth_source, external_id)
+WHERE external_id <> '';
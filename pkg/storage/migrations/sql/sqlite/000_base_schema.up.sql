@@ -0,0 +1,97 @@
+-- ============================================================================
+-- OVIM SQLite Migration: 000 - Base Schema
+-- ============================================================================
+--
+-- SQLite counterpart of sql/000_base_schema.up.sql. JSON columns are stored
+-- as TEXT and timestamps as TIMESTAMP, which the driver maps to time.Time.
+--
+-- ============================================================================
+
+CREATE TABLE users (
+    id TEXT PRIMARY KEY,
+    username TEXT,
+    email TEXT,
+    password_hash TEXT,
+    role TEXT,
+    org_id TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_users_username ON users(username);
+CREATE UNIQUE INDEX idx_users_email ON users(email);
+
+CREATE TABLE organizations (
+    id TEXT PRIMARY KEY,
+    name TEXT,
+    description TEXT,
+    namespace TEXT,
+    is_enabled BOOLEAN DEFAULT TRUE,
+    cpu_quota INTEGER DEFAULT 0,
+    memory_quota INTEGER DEFAULT 0,
+    storage_quota INTEGER DEFAULT 0,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_organizations_name ON organizations(name);
+CREATE UNIQUE INDEX idx_organizations_namespace ON organizations(namespace);
+
+CREATE TABLE templates (
+    id TEXT PRIMARY KEY,
+    name TEXT,
+    template_name TEXT,
+    description TEXT,
+    os_type TEXT,
+    os_version TEXT,
+    cpu INTEGER,
+    memory TEXT,
+    disk_size TEXT,
+    image_url TEXT,
+    icon_class TEXT,
+    org_id TEXT,
+    source TEXT DEFAULT 'global',
+    source_vendor TEXT DEFAULT 'Red Hat',
+    category TEXT DEFAULT 'Operating System',
+    namespace TEXT,
+    featured BOOLEAN,
+    metadata TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP
+);
+
+CREATE INDEX idx_templates_org_id ON templates(org_id);
+
+CREATE TABLE virtual_machines (
+    id TEXT PRIMARY KEY,
+    name TEXT,
+    org_id TEXT,
+    template_id TEXT,
+    owner_id TEXT,
+    status TEXT,
+    cpu INTEGER,
+    memory TEXT,
+    disk_size TEXT,
+    ip_address TEXT,
+    metadata TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP
+);
+
+CREATE INDEX idx_virtual_machines_org_id ON virtual_machines(org_id);
+CREATE INDEX idx_virtual_machines_template_id ON virtual_machines(template_id);
+CREATE INDEX idx_virtual_machines_owner_id ON virtual_machines(owner_id);
+CREATE INDEX idx_virtual_machines_status ON virtual_machines(status);
+
+CREATE TABLE organization_catalog_sources (
+    id TEXT PRIMARY KEY,
+    org_id TEXT,
+    source_type TEXT,
+    source_name TEXT,
+    source_namespace TEXT,
+    enabled BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP
+);
+
+CREATE INDEX idx_organization_catalog_sources_org_id ON organization_catalog_sources(org_id);
@@ -0,0 +1,29 @@
+-- ============================================================================
+-- OVIM SQLite Rollback: 001 - Organization and VDC CRD Architecture
+-- ============================================================================
+--
+-- WARNING: This rollback script will DESTROY VDC and Catalog data!
+--
+-- ============================================================================
+
+DROP INDEX IF EXISTS idx_template_catalog_id;
+ALTER TABLE templates DROP COLUMN content_type;
+ALTER TABLE templates DROP COLUMN catalog_id;
+
+DROP INDEX IF EXISTS idx_vm_vdc_id;
+ALTER TABLE virtual_machines DROP COLUMN vdc_id;
+
+DROP TABLE IF EXISTS catalogs;
+DROP TABLE IF EXISTS virtual_data_centers;
+
+DROP INDEX IF EXISTS idx_organizations_cr_name;
+ALTER TABLE organizations DROP COLUMN display_name;
+ALTER TABLE organizations DROP COLUMN cr_name;
+ALTER TABLE organizations DROP COLUMN cr_namespace;
+ALTER TABLE organizations DROP COLUMN vdc_count;
+ALTER TABLE organizations DROP COLUMN last_rbac_sync;
+ALTER TABLE organizations DROP COLUMN observed_generation;
+
+ALTER TABLE organizations ADD COLUMN cpu_quota INTEGER DEFAULT 0;
+ALTER TABLE organizations ADD COLUMN memory_quota INTEGER DEFAULT 0;
+ALTER TABLE organizations ADD COLUMN storage_quota INTEGER DEFAULT 0;
@@ -0,0 +1,186 @@
+-- ============================================================================
+-- OVIM SQLite Migration: 001 - Organization and VDC CRD Architecture
+-- ============================================================================
+--
+-- SQLite counterpart of sql/001_org_vdc_crd.up.sql. SQLite cannot add a
+-- UNIQUE column, so organizations.cr_name gets a unique index instead, and
+-- updated_at is maintained by the application rather than by triggers.
+--
+-- ============================================================================
+
+-- ============================================================================
+-- 1. UPDATE ORGANIZATIONS TABLE (Remove quotas, add CRD fields)
+-- ============================================================================
+
+ALTER TABLE organizations DROP COLUMN cpu_quota;
+ALTER TABLE organizations DROP COLUMN memory_quota;
+ALTER TABLE organizations DROP COLUMN storage_quota;
+
+ALTER TABLE organizations ADD COLUMN display_name VARCHAR(255);
+ALTER TABLE organizations ADD COLUMN cr_name VARCHAR(255);
+ALTER TABLE organizations ADD COLUMN cr_namespace VARCHAR(255) DEFAULT 'default';
+ALTER TABLE organizations ADD COLUMN vdc_count INTEGER DEFAULT 0;
+ALTER TABLE organizations ADD COLUMN last_rbac_sync TIMESTAMP NULL;
+ALTER TABLE organizations ADD COLUMN observed_generation BIGINT DEFAULT 0;
+
+UPDATE organizations
+SET cr_name = name,
+    display_name = COALESCE(display_name, name)
+WHERE cr_name IS NULL;
+
+CREATE UNIQUE INDEX idx_organizations_cr_name ON organizations(cr_name);
+
+-- ============================================================================
+-- 2. CREATE VIRTUAL_DATA_CENTERS TABLE
+-- ============================================================================
+
+CREATE TABLE virtual_data_centers (
+    id VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    org_id VARCHAR(255) NOT NULL,
+
+    -- CRD integration
+    display_name VARCHAR(255),
+    cr_name VARCHAR(255) NOT NULL,
+    cr_namespace VARCHAR(255) NOT NULL,
+    workload_namespace VARCHAR(255) UNIQUE NOT NULL,
+
+    -- Resource quotas
+    cpu_quota INTEGER NOT NULL DEFAULT 0,
+    memory_quota INTEGER NOT NULL DEFAULT 0,
+    storage_quota INTEGER NOT NULL DEFAULT 0,
+    pods_quota INTEGER NOT NULL DEFAULT 100,
+    vms_quota INTEGER NOT NULL DEFAULT 50,
+
+    -- VM LimitRange
+    min_cpu INTEGER NULL,
+    max_cpu INTEGER NULL,
+    min_memory INTEGER NULL,
+    max_memory INTEGER NULL,
+
+    -- Network and configuration
+    network_policy VARCHAR(100) DEFAULT 'default',
+    custom_network_config TEXT NULL,
+    catalog_restrictions TEXT NULL,
+
+    -- Status tracking
+    phase VARCHAR(50) DEFAULT 'Pending',
+    conditions TEXT NULL,
+    observed_generation BIGINT DEFAULT 0,
+
+    -- Resource usage tracking
+    cpu_used INTEGER DEFAULT 0,
+    memory_used INTEGER DEFAULT 0,
+    storage_used INTEGER DEFAULT 0,
+    cpu_percentage DECIMAL(5,2) DEFAULT 0.0,
+    memory_percentage DECIMAL(5,2) DEFAULT 0.0,
+    storage_percentage DECIMAL(5,2) DEFAULT 0.0,
+
+    -- Workload counts
+    total_pods INTEGER DEFAULT 0,
+    running_pods INTEGER DEFAULT 0,
+    total_vms INTEGER DEFAULT 0,
+    running_vms INTEGER DEFAULT 0,
+
+    last_metrics_update TIMESTAMP NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE CASCADE,
+    UNIQUE (org_id, name)
+);
+
+CREATE INDEX idx_vdc_org_id ON virtual_data_centers(org_id);
+CREATE INDEX idx_vdc_workload_namespace ON virtual_data_centers(workload_namespace);
+CREATE INDEX idx_vdc_cr_name ON virtual_data_centers(cr_name);
+CREATE INDEX idx_vdc_cr_namespace ON virtual_data_centers(cr_namespace);
+CREATE INDEX idx_vdc_phase ON virtual_data_centers(phase);
+
+-- ============================================================================
+-- 3. CREATE CATALOGS TABLE
+-- ============================================================================
+
+CREATE TABLE catalogs (
+    id VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    org_id VARCHAR(255) NOT NULL,
+
+    -- CRD integration
+    display_name VARCHAR(255),
+    cr_name VARCHAR(255) NOT NULL,
+    cr_namespace VARCHAR(255) NOT NULL,
+
+    -- Catalog configuration
+    type VARCHAR(50) DEFAULT 'vm-template',
+    source_type VARCHAR(50) NOT NULL,
+    source_url TEXT NOT NULL,
+    source_branch VARCHAR(255) DEFAULT 'main',
+    source_path VARCHAR(1000) DEFAULT '/',
+    source_credentials VARCHAR(255) NULL,
+    insecure_skip_tls_verify BOOLEAN DEFAULT FALSE,
+    refresh_interval VARCHAR(50) DEFAULT '1h',
+
+    -- Content filtering
+    include_patterns TEXT NULL,
+    exclude_patterns TEXT NULL,
+    required_tags TEXT NULL,
+
+    -- Permissions
+    allowed_vdcs TEXT NULL,
+    allowed_groups TEXT NULL,
+    read_only BOOLEAN DEFAULT TRUE,
+
+    -- Status
+    is_enabled BOOLEAN DEFAULT TRUE,
+    phase VARCHAR(50) DEFAULT 'Pending',
+    conditions TEXT NULL,
+
+    -- Content summary
+    total_items INTEGER DEFAULT 0,
+    vm_templates INTEGER DEFAULT 0,
+    application_stacks INTEGER DEFAULT 0,
+    categories TEXT NULL,
+
+    -- Sync status
+    last_sync TIMESTAMP NULL,
+    last_sync_attempt TIMESTAMP NULL,
+    sync_errors TEXT NULL,
+    next_sync_scheduled TIMESTAMP NULL,
+
+    observed_generation BIGINT DEFAULT 0,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE CASCADE,
+    UNIQUE (org_id, name)
+);
+
+CREATE INDEX idx_catalog_org_id ON catalogs(org_id);
+CREATE INDEX idx_catalog_cr_name ON catalogs(cr_name);
+CREATE INDEX idx_catalog_cr_namespace ON catalogs(cr_namespace);
+CREATE INDEX idx_catalog_type ON catalogs(type);
+CREATE INDEX idx_catalog_phase ON catalogs(phase);
+CREATE INDEX idx_catalog_is_enabled ON catalogs(is_enabled);
+
+-- ============================================================================
+-- 4. UPDATE VIRTUAL_MACHINES AND TEMPLATES TABLES
+-- ============================================================================
+
+ALTER TABLE virtual_machines ADD COLUMN vdc_id VARCHAR(255) NULL;
+CREATE INDEX idx_vm_vdc_id ON virtual_machines(vdc_id);
+
+ALTER TABLE templates ADD COLUMN catalog_id VARCHAR(255) NULL;
+ALTER TABLE templates ADD COLUMN content_type VARCHAR(50) DEFAULT 'vm-template';
+CREATE INDEX idx_template_catalog_id ON templates(catalog_id);
+
+-- ============================================================================
+-- 5. DATA CONSISTENCY CHECKS
+-- ============================================================================
+
+UPDATE organizations
+SET namespace = 'org-' || name
+WHERE namespace IS NULL OR namespace = '';
@@ -0,0 +1,9 @@
+-- ============================================================================
+-- OVIM SQLite Rollback: 002 - Resource Versions
+-- ============================================================================
+
+ALTER TABLE virtual_machines DROP COLUMN resource_version;
+ALTER TABLE templates DROP COLUMN resource_version;
+ALTER TABLE virtual_data_centers DROP COLUMN resource_version;
+ALTER TABLE organizations DROP COLUMN resource_version;
+ALTER TABLE users DROP COLUMN resource_version;
@@ -0,0 +1,9 @@
+-- ============================================================================
+-- OVIM SQLite Migration: 002 - Resource Versions
+-- ============================================================================
+
+ALTER TABLE users ADD COLUMN resource_version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE organizations ADD COLUMN resource_version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE virtual_data_centers ADD COLUMN resource_version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE templates ADD COLUMN resource_version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE virtual_machines ADD COLUMN resource_version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,11 @@
+-- ============================================================================
+-- OVIM SQLite Rollback: 003 - Soft Delete
+-- ============================================================================
+
+DROP INDEX IF EXISTS idx_virtual_machines_deleted_at;
+DROP INDEX IF EXISTS idx_virtual_data_centers_deleted_at;
+DROP INDEX IF EXISTS idx_organizations_deleted_at;
+
+ALTER TABLE virtual_machines DROP COLUMN deleted_at;
+ALTER TABLE virtual_data_centers DROP COLUMN deleted_at;
+ALTER TABLE organizations DROP COLUMN deleted_at;
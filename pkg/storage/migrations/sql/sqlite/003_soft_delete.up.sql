@@ -0,0 +1,11 @@
+-- ============================================================================
+-- OVIM SQLite Migration: 003 - Soft Delete
+-- ============================================================================
+
+ALTER TABLE organizations ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE virtual_data_centers ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE virtual_machines ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX idx_organizations_deleted_at ON organizations(deleted_at);
+CREATE INDEX idx_virtual_data_centers_deleted_at ON virtual_data_centers(deleted_at);
+CREATE INDEX idx_virtual_machines_deleted_at ON virtual_machines(deleted_at);
@@ -0,0 +1,52 @@
+-- ============================================================================
+-- OVIM SQLite Rollback: 004 - Catalogs
+-- ============================================================================
+--
+-- SQLite cannot drop a column that references another table, so the
+-- templates table is rebuilt without the constraint.
+--
+-- ============================================================================
+
+CREATE TABLE templates_unlinked (
+    id TEXT PRIMARY KEY,
+    name TEXT,
+    template_name TEXT,
+    description TEXT,
+    os_type TEXT,
+    os_version TEXT,
+    cpu INTEGER,
+    memory TEXT,
+    disk_size TEXT,
+    image_url TEXT,
+    icon_class TEXT,
+    org_id TEXT,
+    source TEXT DEFAULT 'global',
+    source_vendor TEXT DEFAULT 'Red Hat',
+    category TEXT DEFAULT 'Operating System',
+    namespace TEXT,
+    featured BOOLEAN,
+    metadata TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    catalog_id VARCHAR(255) NULL,
+    content_type VARCHAR(50) DEFAULT 'vm-template',
+    resource_version BIGINT NOT NULL DEFAULT 1
+);
+
+INSERT INTO templates_unlinked (
+    id, name, template_name, description, os_type, os_version, cpu, memory, disk_size,
+    image_url, icon_class, org_id, source, source_vendor, category, namespace, featured,
+    metadata, created_at, updated_at, catalog_id, content_type, resource_version
+)
+SELECT
+    id, name, template_name, description, os_type, os_version, cpu, memory, disk_size,
+    image_url, icon_class, org_id, source, source_vendor, category, namespace, featured,
+    metadata, created_at, updated_at, catalog_id, content_type, resource_version
+FROM templates;
+
+DROP TABLE templates;
+ALTER TABLE templates_unlinked RENAME TO templates;
+CREATE INDEX idx_templates_org_id ON templates(org_id);
+CREATE INDEX idx_template_catalog_id ON templates(catalog_id);
+
+ALTER TABLE catalogs DROP COLUMN resource_version;
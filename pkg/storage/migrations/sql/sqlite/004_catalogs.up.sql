@@ -0,0 +1,18 @@
+-- ============================================================================
+-- OVIM SQLite Migration: 004 - Catalogs
+-- ============================================================================
+--
+-- SQLite cannot add a constraint to an existing column, so catalog_id is
+-- replaced by a new column that references catalogs.
+--
+-- ============================================================================
+
+ALTER TABLE catalogs ADD COLUMN resource_version BIGINT NOT NULL DEFAULT 1;
+
+DROP INDEX IF EXISTS idx_template_catalog_id;
+ALTER TABLE templates RENAME COLUMN catalog_id TO unlinked_catalog_id;
+ALTER TABLE templates ADD COLUMN catalog_id VARCHAR(255) NULL REFERENCES catalogs(id) ON DELETE SET NULL;
+UPDATE templates SET catalog_id = unlinked_catalog_id
+WHERE unlinked_catalog_id IN (SELECT id FROM catalogs);
+ALTER TABLE templates DROP COLUMN unlinked_catalog_id;
+CREATE INDEX idx_template_catalog_id ON templates(catalog_id);
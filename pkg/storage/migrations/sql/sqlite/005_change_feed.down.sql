@@ -0,0 +1,27 @@
+-- ============================================================================
+-- OVIM SQLite Rollback: 005 - Change Feed
+-- ============================================================================
+
+DROP TRIGGER IF EXISTS users_change_insert;
+DROP TRIGGER IF EXISTS users_change_update;
+DROP TRIGGER IF EXISTS users_change_delete;
+DROP TRIGGER IF EXISTS organizations_change_insert;
+DROP TRIGGER IF EXISTS organizations_change_update;
+DROP TRIGGER IF EXISTS organizations_change_delete;
+DROP TRIGGER IF EXISTS virtual_data_centers_change_insert;
+DROP TRIGGER IF EXISTS virtual_data_centers_change_update;
+DROP TRIGGER IF EXISTS virtual_data_centers_change_delete;
+DROP TRIGGER IF EXISTS catalogs_change_insert;
+DROP TRIGGER IF EXISTS catalogs_change_update;
+DROP TRIGGER IF EXISTS catalogs_change_delete;
+DROP TRIGGER IF EXISTS templates_change_insert;
+DROP TRIGGER IF EXISTS templates_change_update;
+DROP TRIGGER IF EXISTS templates_change_delete;
+DROP TRIGGER IF EXISTS virtual_machines_change_insert;
+DROP TRIGGER IF EXISTS virtual_machines_change_update;
+DROP TRIGGER IF EXISTS virtual_machines_change_delete;
+DROP TRIGGER IF EXISTS organization_catalog_sources_change_insert;
+DROP TRIGGER IF EXISTS organization_catalog_sources_change_update;
+DROP TRIGGER IF EXISTS organization_catalog_sources_change_delete;
+
+DROP TABLE IF EXISTS change_log;
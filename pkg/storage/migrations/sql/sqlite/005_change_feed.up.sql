@@ -0,0 +1,172 @@
+-- ============================================================================
+-- OVIM SQLite Migration: 005 - Change Feed
+-- ============================================================================
+--
+-- SQLite has no notifications, so triggers append every change to the
+-- change_log table within the writing transaction and servers poll it. Only
+-- the most recent 10000 entries are kept, a watcher that falls further behind
+-- notices the gap and starts over.
+--
+-- Soft deleting a record is logged as deleted and restoring it as created.
+-- Changes to records in the trash, purging included, are not logged.
+--
+-- ============================================================================
+
+CREATE TABLE change_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    change_type VARCHAR(16) NOT NULL,
+    kind VARCHAR(32) NOT NULL,
+    record_id VARCHAR(255) NOT NULL,
+    org_id VARCHAR(255),
+    owner_id VARCHAR(255),
+    resource_version BIGINT
+);
+
+CREATE TRIGGER change_log_prune AFTER INSERT ON change_log
+BEGIN
+    DELETE FROM change_log WHERE id <= NEW.id - 10000;
+END;
+
+CREATE TRIGGER users_change_insert AFTER INSERT ON users
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES ('created', 'user', NEW.id, NEW.org_id, NEW.resource_version);
+END;
+
+CREATE TRIGGER users_change_update AFTER UPDATE ON users
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES ('updated', 'user', NEW.id, NEW.org_id, NEW.resource_version);
+END;
+
+CREATE TRIGGER users_change_delete AFTER DELETE ON users
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES ('deleted', 'user', OLD.id, OLD.org_id, OLD.resource_version);
+END;
+
+CREATE TRIGGER organizations_change_insert AFTER INSERT ON organizations
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES ('created', 'organization', NEW.id, NEW.id, NEW.resource_version);
+END;
+
+CREATE TRIGGER organizations_change_update AFTER UPDATE ON organizations
+WHEN OLD.deleted_at IS NULL OR NEW.deleted_at IS NULL
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES (CASE
+                WHEN NEW.deleted_at IS NOT NULL THEN 'deleted'
+                WHEN OLD.deleted_at IS NOT NULL THEN 'created'
+                ELSE 'updated'
+            END, 'organization', NEW.id, NEW.id, NEW.resource_version);
+END;
+
+CREATE TRIGGER organizations_change_delete AFTER DELETE ON organizations
+WHEN OLD.deleted_at IS NULL
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES ('deleted', 'organization', OLD.id, OLD.id, OLD.resource_version);
+END;
+
+CREATE TRIGGER virtual_data_centers_change_insert AFTER INSERT ON virtual_data_centers
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES ('created', 'vdc', NEW.id, NEW.org_id, NEW.resource_version);
+END;
+
+CREATE TRIGGER virtual_data_centers_change_update AFTER UPDATE ON virtual_data_centers
+WHEN OLD.deleted_at IS NULL OR NEW.deleted_at IS NULL
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES (CASE
+                WHEN NEW.deleted_at IS NOT NULL THEN 'deleted'
+                WHEN OLD.deleted_at IS NOT NULL THEN 'created'
+                ELSE 'updated'
+            END, 'vdc', NEW.id, NEW.org_id, NEW.resource_version);
+END;
+
+CREATE TRIGGER virtual_data_centers_change_delete AFTER DELETE ON virtual_data_centers
+WHEN OLD.deleted_at IS NULL
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES ('deleted', 'vdc', OLD.id, OLD.org_id, OLD.resource_version);
+END;
+
+CREATE TRIGGER catalogs_change_insert AFTER INSERT ON catalogs
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES ('created', 'catalog', NEW.id, NEW.org_id, NEW.resource_version);
+END;
+
+CREATE TRIGGER catalogs_change_update AFTER UPDATE ON catalogs
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES ('updated', 'catalog', NEW.id, NEW.org_id, NEW.resource_version);
+END;
+
+CREATE TRIGGER catalogs_change_delete AFTER DELETE ON catalogs
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES ('deleted', 'catalog', OLD.id, OLD.org_id, OLD.resource_version);
+END;
+
+CREATE TRIGGER templates_change_insert AFTER INSERT ON templates
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES ('created', 'template', NEW.id, NEW.org_id, NEW.resource_version);
+END;
+
+CREATE TRIGGER templates_change_update AFTER UPDATE ON templates
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES ('updated', 'template', NEW.id, NEW.org_id, NEW.resource_version);
+END;
+
+CREATE TRIGGER templates_change_delete AFTER DELETE ON templates
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES ('deleted', 'template', OLD.id, OLD.org_id, OLD.resource_version);
+END;
+
+CREATE TRIGGER virtual_machines_change_insert AFTER INSERT ON virtual_machines
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, owner_id, resource_version)
+    VALUES ('created', 'vm', NEW.id, NEW.org_id, NEW.owner_id, NEW.resource_version);
+END;
+
+CREATE TRIGGER virtual_machines_change_update AFTER UPDATE ON virtual_machines
+WHEN OLD.deleted_at IS NULL OR NEW.deleted_at IS NULL
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, owner_id, resource_version)
+    VALUES (CASE
+                WHEN NEW.deleted_at IS NOT NULL THEN 'deleted'
+                WHEN OLD.deleted_at IS NOT NULL THEN 'created'
+                ELSE 'updated'
+            END, 'vm', NEW.id, NEW.org_id, NEW.owner_id, NEW.resource_version);
+END;
+
+CREATE TRIGGER virtual_machines_change_delete AFTER DELETE ON virtual_machines
+WHEN OLD.deleted_at IS NULL
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, owner_id, resource_version)
+    VALUES ('deleted', 'vm', OLD.id, OLD.org_id, OLD.owner_id, OLD.resource_version);
+END;
+
+CREATE TRIGGER organization_catalog_sources_change_insert AFTER INSERT ON organization_catalog_sources
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES ('created', 'catalog_source', NEW.id, NEW.org_id, NULL);
+END;
+
+CREATE TRIGGER organization_catalog_sources_change_update AFTER UPDATE ON organization_catalog_sources
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES ('updated', 'catalog_source', NEW.id, NEW.org_id, NULL);
+END;
+
+CREATE TRIGGER organization_catalog_sources_change_delete AFTER DELETE ON organization_catalog_sources
+BEGIN
+    INSERT INTO change_log (change_type, kind, record_id, org_id, resource_version)
+    VALUES ('deleted', 'catalog_source', OLD.id, OLD.org_id, NULL);
+END;
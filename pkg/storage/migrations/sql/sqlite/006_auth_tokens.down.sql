@@ -0,0 +1,6 @@
+-- ============================================================================
+-- OVIM SQLite Rollback: 006 - Auth Tokens
+-- ============================================================================
+
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
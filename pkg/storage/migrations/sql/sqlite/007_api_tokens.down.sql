@@ -0,0 +1,5 @@
+-- ============================================================================
+-- OVIM SQLite Rollback: 007 - API Tokens
+-- ============================================================================
+
+DROP TABLE IF EXISTS api_tokens;
@@ -0,0 +1,24 @@
+-- ============================================================================
+-- OVIM SQLite Migration: 007 - API Tokens
+-- ============================================================================
+--
+-- SQLite counterpart of sql/007_api_tokens.up.sql.
+--
+-- ============================================================================
+
+CREATE TABLE api_tokens (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    token_hash TEXT NOT NULL,
+    scopes TEXT NULL,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    UNIQUE (user_id, name)
+);
+
+CREATE UNIQUE INDEX idx_api_tokens_token_hash ON api_tokens(token_hash);
+CREATE INDEX idx_api_tokens_user_id ON api_tokens(user_id);
@@ -0,0 +1,20 @@
+-- ============================================================================
+-- OVIM SQLite Migration: 008 - Organization Roles
+-- ============================================================================
+--
+-- SQLite counterpart of sql/008_org_roles.up.sql.
+--
+-- ============================================================================
+
+CREATE TABLE org_roles (
+    id TEXT PRIMARY KEY,
+    org_id TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    verbs TEXT NULL,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    UNIQUE (org_id, name)
+);
+
+CREATE INDEX idx_org_roles_org_id ON org_roles(org_id);
@@ -0,0 +1,5 @@
+-- ============================================================================
+-- OVIM SQLite Rollback: 009 - Login Attempts
+-- ============================================================================
+
+DROP TABLE IF EXISTS login_attempts;
@@ -0,0 +1,16 @@
+-- ============================================================================
+-- OVIM SQLite Migration: 009 - Login Attempts
+-- ============================================================================
+--
+-- SQLite counterpart of sql/009_login_attempts.up.sql.
+--
+-- ============================================================================
+
+CREATE TABLE login_attempts (
+    id TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP
+);
+
+CREATE INDEX idx_login_attempts_last_failure_at ON login_attempts(last_failure_at);
@@ -0,0 +1,9 @@
+-- ============================================================================
+-- OVIM SQLite Rollback: 010 - Password Management
+-- ============================================================================
+
+DROP TABLE IF EXISTS password_reset_tokens;
+
+ALTER TABLE users DROP COLUMN password_history;
+ALTER TABLE users DROP COLUMN password_changed_at;
+ALTER TABLE users DROP COLUMN must_change_password;
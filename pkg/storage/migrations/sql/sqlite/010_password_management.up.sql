@@ -0,0 +1,25 @@
+-- ============================================================================
+-- OVIM SQLite Migration: 010 - Password Management
+-- ============================================================================
+--
+-- SQLite counterpart of sql/010_password_management.up.sql.
+--
+-- ============================================================================
+
+ALTER TABLE users ADD COLUMN must_change_password BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN password_changed_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN password_history TEXT NULL;
+
+CREATE TABLE password_reset_tokens (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL,
+    created_by TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP,
+    used_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_password_reset_tokens_token_hash ON password_reset_tokens(token_hash);
+CREATE INDEX idx_password_reset_tokens_user_id ON password_reset_tokens(user_id);
+CREATE INDEX idx_password_reset_tokens_expires_at ON password_reset_tokens(expires_at);
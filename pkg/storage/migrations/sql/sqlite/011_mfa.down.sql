@@ -0,0 +1,10 @@
+-- ============================================================================
+-- OVIM SQLite Rollback: 011 - Multi-Factor Authentication
+-- ============================================================================
+
+ALTER TABLE organizations DROP COLUMN require_mfa;
+
+ALTER TABLE users DROP COLUMN mfa_recovery_codes;
+ALTER TABLE users DROP COLUMN mfa_last_counter;
+ALTER TABLE users DROP COLUMN mfa_secret;
+ALTER TABLE users DROP COLUMN mfa_enabled;
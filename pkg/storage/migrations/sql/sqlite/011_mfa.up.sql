@@ -0,0 +1,14 @@
+-- ============================================================================
+-- OVIM SQLite Migration: 011 - Multi-Factor Authentication
+-- ============================================================================
+--
+-- SQLite counterpart of sql/011_mfa.up.sql.
+--
+-- ============================================================================
+
+ALTER TABLE users ADD COLUMN mfa_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN mfa_secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN mfa_last_counter INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN mfa_recovery_codes TEXT NULL;
+
+ALTER TABLE organizations ADD COLUMN require_mfa BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,5 @@
+-- ============================================================================
+-- OVIM SQLite Rollback: 012 - Impersonations
+-- ============================================================================
+
+DROP TABLE IF EXISTS impersonations;
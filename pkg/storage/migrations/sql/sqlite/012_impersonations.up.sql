@@ -0,0 +1,21 @@
+-- ============================================================================
+-- OVIM SQLite Migration: 012 - Impersonations
+-- ============================================================================
+--
+-- SQLite counterpart of sql/012_impersonations.up.sql.
+--
+-- ============================================================================
+
+CREATE TABLE impersonations (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    impersonator_id TEXT NOT NULL,
+    impersonator_username TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    token_id TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP,
+    ended_at TIMESTAMP
+);
+
+CREATE INDEX idx_impersonations_user_id ON impersonations(user_id);
@@ -0,0 +1,5 @@
+-- ============================================================================
+-- OVIM SQLite Rollback: 013 - Organization Memberships
+-- ============================================================================
+
+DROP TABLE IF EXISTS org_memberships;
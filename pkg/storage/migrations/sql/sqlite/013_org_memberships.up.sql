@@ -0,0 +1,19 @@
+-- ============================================================================
+-- OVIM SQLite Migration: 013 - Organization Memberships
+-- ============================================================================
+--
+-- SQLite counterpart of sql/013_org_memberships.up.sql.
+--
+-- ============================================================================
+
+CREATE TABLE org_memberships (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    org_id TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    role TEXT NOT NULL,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    UNIQUE (user_id, org_id)
+);
+
+CREATE INDEX idx_org_memberships_org_id ON org_memberships(org_id);
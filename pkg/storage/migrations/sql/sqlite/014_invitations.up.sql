@@ -0,0 +1,24 @@
+-- ============================================================================
+-- OVIM SQLite Migration: 014 - Invitations
+-- ============================================================================
+--
+-- SQLite counterpart of sql/014_invitations.up.sql.
+--
+-- ============================================================================
+
+CREATE TABLE invitations (
+    id TEXT PRIMARY KEY,
+    org_id TEXT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    role TEXT NOT NULL,
+    invited_by TEXT NOT NULL DEFAULT '',
+    token_id TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    accepted_at TIMESTAMP,
+    user_id TEXT REFERENCES users(id) ON DELETE SET NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX idx_invitations_org_id ON invitations(org_id);
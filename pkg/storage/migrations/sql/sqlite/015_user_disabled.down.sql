@@ -0,0 +1,5 @@
+-- ============================================================================
+-- OVIM SQLite Rollback: 015 - Disabled Users
+-- ============================================================================
+
+ALTER TABLE users DROP COLUMN disabled;
@@ -0,0 +1,9 @@
+-- ============================================================================
+-- OVIM SQLite Migration: 015 - Disabled Users
+-- ============================================================================
+--
+-- SQLite counterpart of sql/015_user_disabled.up.sql.
+--
+-- ============================================================================
+
+ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
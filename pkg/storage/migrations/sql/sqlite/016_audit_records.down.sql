@@ -0,0 +1,5 @@
+-- ============================================================================
+-- OVIM SQLite Rollback: 016 - Audit Records
+-- ============================================================================
+
+DROP TABLE IF EXISTS audit_records;
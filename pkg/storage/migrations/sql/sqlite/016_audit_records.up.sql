@@ -0,0 +1,34 @@
+-- ============================================================================
+-- OVIM SQLite Migration: 016 - Audit Records
+-- ============================================================================
+--
+-- SQLite counterpart of sql/016_audit_records.up.sql.
+--
+-- ============================================================================
+
+CREATE TABLE audit_records (
+    id TEXT PRIMARY KEY,
+    actor_id TEXT NOT NULL DEFAULT '',
+    actor_username TEXT NOT NULL DEFAULT '',
+    actor_role TEXT NOT NULL DEFAULT '',
+    impersonator_id TEXT NOT NULL DEFAULT '',
+    impersonator_username TEXT NOT NULL DEFAULT '',
+    org_id TEXT NOT NULL DEFAULT '',
+    method TEXT NOT NULL,
+    route TEXT NOT NULL,
+    path TEXT NOT NULL,
+    resource_type TEXT NOT NULL DEFAULT '',
+    resource_id TEXT NOT NULL DEFAULT '',
+    request TEXT NULL,
+    changes TEXT NULL,
+    status_code INTEGER NOT NULL,
+    outcome TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    client_ip TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_audit_records_created_at ON audit_records(created_at);
+CREATE INDEX idx_audit_records_actor_id ON audit_records(actor_id);
+CREATE INDEX idx_audit_records_org_id ON audit_records(org_id);
+CREATE INDEX idx_audit_records_resource_id ON audit_records(resource_id);
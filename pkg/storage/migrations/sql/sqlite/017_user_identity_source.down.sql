@@ -0,0 +1,7 @@
+-- ============================================================================
+-- OVIM SQLite Rollback: 017 - User Identity Sources
+-- ============================================================================
+
+DROP INDEX IF EXISTS idx_users_external_id;
+ALTER TABLE users DROP COLUMN external_id;
+ALTER TABLE users DROP COLUMN auth_source;
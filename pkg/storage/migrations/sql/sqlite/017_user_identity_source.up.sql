@@ -0,0 +1,16 @@
+-- ============================================================================
+-- OVIM SQLite Migration: 017 - User Identity Sources
+-- ============================================================================
+--
+-- SQLite counterpart of sql/017_user_identity_source.up.sql.
+--
+-- ============================================================================
+
+ALTER TABLE users ADD COLUMN auth_source TEXT NOT NULL DEFAULT 'local';
+ALTER TABLE users ADD COLUMN external_id TEXT NOT NULL DEFAULT '';
+
+UPDATE users SET auth_source = 'oidc', external_id = id
+WHERE COALESCE(password_hash, '') = '' AND external_id = '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_external_id ON users (auth_source, external_id)
+WHERE external_id <> '';
@@ -1,25 +1,71 @@
 package storage
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 	"k8s.io/klog/v2"
 
 	"github.com/eliorerz/ovim-updated/pkg/auth"
 	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage/migrations"
 )
 
 // PostgresStorage implements the Storage interface using PostgreSQL with GORM
 type PostgresStorage struct {
-	db *gorm.DB
+	db      *gorm.DB
+	changes *pgChangeFeed
 }
 
 // NewPostgresStorage creates a new PostgreSQL storage instance
 func NewPostgresStorage(dsn string) (Storage, error) {
+	db, err := openPostgres(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := &PostgresStorage{db: db, changes: newPGChangeFeed(db)}
+
+	// Run migrations
+	if err := storage.migrate(); err != nil {
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	// Seed initial data
+	if err := storage.seedData(); err != nil {
+		return nil, fmt.Errorf("failed to seed data: %w", err)
+	}
+
+	klog.Info("Initialized PostgreSQL storage with migrations and seed data")
+	return storage, nil
+}
+
+// NewPostgresMigrator connects to the database without applying migrations.
+// The caller is responsible for closing the returned migrator.
+func NewPostgresMigrator(dsn string) (*migrations.Migrator, error) {
+	db, err := openPostgres(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		if sqlDB, dbErr := db.DB(); dbErr == nil {
+			sqlDB.Close()
+		}
+		return nil, err
+	}
+	return migrator, nil
+}
+
+// openPostgres opens a GORM connection with the logger and pool settings shared by all callers
+func openPostgres(dsn string) (*gorm.DB, error) {
 	// Configure GORM logger to use klog
 	gormLogger := logger.New(
 		&klogWriter{},
@@ -48,20 +94,7 @@ func NewPostgresStorage(dsn string) (Storage, error) {
 	sqlDB.SetMaxIdleConns(10)
 	sqlDB.SetConnMaxLifetime(time.Hour)
 
-	storage := &PostgresStorage{db: db}
-
-	// Run migrations
-	if err := storage.migrate(); err != nil {
-		return nil, fmt.Errorf("failed to run migrations: %w", err)
-	}
-
-	// Seed initial data
-	if err := storage.seedData(); err != nil {
-		return nil, fmt.Errorf("failed to seed data: %w", err)
-	}
-
-	klog.Info("Initialized PostgreSQL storage with migrations and seed data")
-	return storage, nil
+	return db, nil
 }
 
 // klogWriter implements the writer interface for GORM logger
@@ -71,16 +104,21 @@ func (w *klogWriter) Printf(format string, args ...interface{}) {
 	klog.V(4).Infof(format, args...)
 }
 
-// migrate runs database migrations
+// migrate applies pending schema migrations, refusing to run against a newer schema
 func (s *PostgresStorage) migrate() error {
-	return s.db.AutoMigrate(
-		&models.User{},
-		&models.Organization{},
-		&models.VirtualDataCenter{},
-		&models.Template{},
-		&models.VirtualMachine{},
-		&models.OrganizationCatalogSource{},
-	)
+	migrator, err := migrations.New(s.db)
+	if err != nil {
+		return err
+	}
+
+	applied, err := migrator.Up()
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 {
+		klog.Infof("Applied %d database migrations, schema is at version %03d", len(applied), migrator.Latest())
+	}
+	return nil
 }
 
 // seedData populates the database with initial test data if it's empty
@@ -93,10 +131,10 @@ func (s *PostgresStorage) seedData() error {
 
 	if userCount > 0 {
 		klog.Info("Database already contains data, skipping seeding")
-		return nil
+		return s.expireSeedPassword()
 	}
 
-	adminHash, err := auth.HashPassword("adminpassword")
+	adminHash, err := auth.HashPassword(seedAdminPassword)
 	if err != nil {
 		return fmt.Errorf("failed to hash admin password: %w", err)
 	}
@@ -106,13 +144,17 @@ func (s *PostgresStorage) seedData() error {
 	// Seed users
 	users := []*models.User{
 		{
-			ID:           "user-admin",
-			Username:     "admin",
-			Email:        "admin@ovim.local",
-			PasswordHash: adminHash,
-			Role:         models.RoleSystemAdmin,
-			CreatedAt:    now,
-			UpdatedAt:    now,
+			ID:              seedAdminID,
+			Username:        "admin",
+			Email:           "admin@ovim.local",
+			PasswordHash:    adminHash,
+			Role:            models.RoleSystemAdmin,
+			AuthSource:      models.AuthSourceLocal,
+			CreatedAt:       now,
+			UpdatedAt:       now,
+			ResourceVersion: 1,
+			// The well-known seed password has to be replaced at the first login
+			MustChangePassword: true,
 		},
 	}
 
@@ -133,6 +175,36 @@ func (s *PostgresStorage) seedData() error {
 	return nil
 }
 
+// expireSeedPassword requires the seeded admin to change its password at the next login if it
+// still has the well-known seed password. Databases seeded before the requirement existed have
+// the admin without it.
+func (s *PostgresStorage) expireSeedPassword() error {
+	var admin models.User
+	err := s.db.Where("id = ? AND must_change_password = ?", seedAdminID, false).First(&admin).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		return fmt.Errorf("failed to get seeded admin: %w", err)
+	}
+	if admin.PasswordHash == "" {
+		return nil
+	}
+	if ok, err := auth.VerifyPassword(seedAdminPassword, admin.PasswordHash); err != nil || !ok {
+		return nil
+	}
+
+	err = s.db.Model(&models.User{}).Where("id = ?", admin.ID).Updates(map[string]interface{}{
+		"must_change_password": true,
+		"resource_version":     gorm.Expr("resource_version + 1"),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to expire the seed password of %s: %w", admin.Username, err)
+	}
+	klog.Warningf("User %s still has the seed password, it has to be changed at the next login", admin.Username)
+	return nil
+}
+
 // User operations
 func (s *PostgresStorage) GetUserByUsername(username string) (*models.User, error) {
 	var user models.User
@@ -158,13 +230,32 @@ func (s *PostgresStorage) GetUserByID(id string) (*models.User, error) {
 	return &user, nil
 }
 
+func (s *PostgresStorage) GetUserByExternalID(source, externalID string) (*models.User, error) {
+	if externalID == "" {
+		return nil, ErrNotFound
+	}
+	var user models.User
+	err := s.db.Where("auth_source = ? AND external_id = ?", source, externalID).First(&user).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
 func (s *PostgresStorage) CreateUser(user *models.User) error {
 	if user == nil || user.ID == "" {
 		return ErrInvalidInput
 	}
+	if user.AuthSource == "" {
+		user.AuthSource = models.AuthSourceLocal
+	}
 
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = user.CreatedAt
+	user.ResourceVersion = 1
 
 	err := s.db.Create(user).Error
 	if err != nil {
@@ -183,14 +274,7 @@ func (s *PostgresStorage) UpdateUser(user *models.User) error {
 	}
 
 	user.UpdatedAt = time.Now()
-	result := s.db.Save(user)
-	if result.Error != nil {
-		return result.Error
-	}
-	if result.RowsAffected == 0 {
-		return ErrNotFound
-	}
-	return nil
+	return s.updateVersioned(user, "users", user.ID, &user.ResourceVersion)
 }
 
 func (s *PostgresStorage) DeleteUser(id string) error {
@@ -206,26 +290,82 @@ func (s *PostgresStorage) DeleteUser(id string) error {
 
 func (s *PostgresStorage) ListUsers() ([]*models.User, error) {
 	var users []*models.User
-	err := s.db.Find(&users).Error
+	err := s.db.Scopes(byCreation).Find(&users).Error
 	return users, err
 }
 
 func (s *PostgresStorage) ListUsersByOrg(orgID string) ([]*models.User, error) {
 	var users []*models.User
-	err := s.db.Where("org_id = ?", orgID).Find(&users).Error
+	err := s.db.Scopes(byCreation).Where("org_id = ?", orgID).Find(&users).Error
 	return users, err
 }
 
+func (s *PostgresStorage) QueryUsers(opts ListOptions) ([]*models.User, string, int, error) {
+	page, err := opts.resolve(userSortColumns)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	query := s.db.Model(&models.User{})
+	if opts.OrgID != "" {
+		query = query.Where("org_id = ?", opts.OrgID)
+	}
+	if opts.Role != "" {
+		query = query.Where("role = ?", opts.Role)
+	}
+	if opts.Name != "" {
+		query = query.Where("LOWER(username) LIKE ? ESCAPE '\\'", likePattern(opts.Name))
+	}
+
+	var users []*models.User
+	total, err := findPage(query, page, &users)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	n, next := page.trim(len(users))
+	return users[:n], next, total, nil
+}
+
 // Organization operations
 func (s *PostgresStorage) ListOrganizations() ([]*models.Organization, error) {
 	var orgs []*models.Organization
-	err := s.db.Find(&orgs).Error
+	err := s.db.Scopes(notDeleted, byCreation).Find(&orgs).Error
 	return orgs, err
 }
 
+func (s *PostgresStorage) QueryOrganizations(opts ListOptions) ([]*models.Organization, string, int, error) {
+	page, err := opts.resolve(organizationSortColumns)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	enabled, filterEnabled, err := orgEnabledFilter(opts.Status)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	query := s.db.Model(&models.Organization{}).Scopes(notDeleted)
+	if opts.OrgID != "" {
+		query = query.Where("id = ?", opts.OrgID)
+	}
+	if filterEnabled {
+		query = query.Where("is_enabled = ?", enabled)
+	}
+	if opts.Name != "" {
+		query = query.Where("LOWER(name) LIKE ? ESCAPE '\\'", likePattern(opts.Name))
+	}
+
+	var orgs []*models.Organization
+	total, err := findPage(query, page, &orgs)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	n, next := page.trim(len(orgs))
+	return orgs[:n], next, total, nil
+}
+
 func (s *PostgresStorage) GetOrganization(id string) (*models.Organization, error) {
 	var org models.Organization
-	err := s.db.Where("id = ?", id).First(&org).Error
+	err := s.db.Scopes(notDeleted).Where("id = ?", id).First(&org).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, ErrNotFound
@@ -242,6 +382,7 @@ func (s *PostgresStorage) CreateOrganization(org *models.Organization) error {
 
 	org.CreatedAt = time.Now()
 	org.UpdatedAt = org.CreatedAt
+	org.ResourceVersion = 1
 
 	err := s.db.Create(org).Error
 	if err != nil {
@@ -260,18 +401,14 @@ func (s *PostgresStorage) UpdateOrganization(org *models.Organization) error {
 	}
 
 	org.UpdatedAt = time.Now()
-	result := s.db.Save(org)
-	if result.Error != nil {
-		return result.Error
-	}
-	if result.RowsAffected == 0 {
-		return ErrNotFound
-	}
-	return nil
+	return s.updateVersioned(org, "organizations", org.ID, &org.ResourceVersion, notDeleted)
 }
 
 func (s *PostgresStorage) DeleteOrganization(id string) error {
-	result := s.db.Delete(&models.Organization{}, "id = ?", id)
+	result := s.db.Model(&models.Organization{}).Scopes(notDeleted).Where("id = ?", id).Updates(map[string]interface{}{
+		"deleted_at":       time.Now(),
+		"resource_version": gorm.Expr("resource_version + 1"),
+	})
 	if result.Error != nil {
 		return result.Error
 	}
@@ -284,7 +421,7 @@ func (s *PostgresStorage) DeleteOrganization(id string) error {
 // VDC operations
 func (s *PostgresStorage) ListVDCs(orgID string) ([]*models.VirtualDataCenter, error) {
 	var vdcs []*models.VirtualDataCenter
-	query := s.db
+	query := s.db.Scopes(notDeleted, byCreation)
 	if orgID != "" {
 		query = query.Where("org_id = ?", orgID)
 	}
@@ -292,9 +429,35 @@ func (s *PostgresStorage) ListVDCs(orgID string) ([]*models.VirtualDataCenter, e
 	return vdcs, err
 }
 
+func (s *PostgresStorage) QueryVDCs(opts ListOptions) ([]*models.VirtualDataCenter, string, int, error) {
+	page, err := opts.resolve(vdcSortColumns)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	query := s.db.Model(&models.VirtualDataCenter{}).Scopes(notDeleted)
+	if opts.OrgID != "" {
+		query = query.Where("org_id = ?", opts.OrgID)
+	}
+	if opts.Status != "" {
+		query = query.Where("phase = ?", opts.Status)
+	}
+	if opts.Name != "" {
+		query = query.Where("LOWER(name) LIKE ? ESCAPE '\\'", likePattern(opts.Name))
+	}
+
+	var vdcs []*models.VirtualDataCenter
+	total, err := findPage(query, page, &vdcs)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	n, next := page.trim(len(vdcs))
+	return vdcs[:n], next, total, nil
+}
+
 func (s *PostgresStorage) GetVDC(id string) (*models.VirtualDataCenter, error) {
 	var vdc models.VirtualDataCenter
-	err := s.db.Where("id = ?", id).First(&vdc).Error
+	err := s.db.Scopes(notDeleted).Where("id = ?", id).First(&vdc).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, ErrNotFound
@@ -311,6 +474,7 @@ func (s *PostgresStorage) CreateVDC(vdc *models.VirtualDataCenter) error {
 
 	vdc.CreatedAt = time.Now()
 	vdc.UpdatedAt = vdc.CreatedAt
+	vdc.ResourceVersion = 1
 
 	err := s.db.Create(vdc).Error
 	if err != nil {
@@ -328,7 +492,14 @@ func (s *PostgresStorage) UpdateVDC(vdc *models.VirtualDataCenter) error {
 	}
 
 	vdc.UpdatedAt = time.Now()
-	result := s.db.Save(vdc)
+	return s.updateVersioned(vdc, "virtual_data_centers", vdc.ID, &vdc.ResourceVersion, notDeleted)
+}
+
+func (s *PostgresStorage) DeleteVDC(id string) error {
+	result := s.db.Model(&models.VirtualDataCenter{}).Scopes(notDeleted).Where("id = ?", id).Updates(map[string]interface{}{
+		"deleted_at":       time.Now(),
+		"resource_version": gorm.Expr("resource_version + 1"),
+	})
 	if result.Error != nil {
 		return result.Error
 	}
@@ -338,8 +509,63 @@ func (s *PostgresStorage) UpdateVDC(vdc *models.VirtualDataCenter) error {
 	return nil
 }
 
-func (s *PostgresStorage) DeleteVDC(id string) error {
-	result := s.db.Delete(&models.VirtualDataCenter{}, "id = ?", id)
+// Catalog operations
+func (s *PostgresStorage) ListCatalogs(orgID string) ([]*models.Catalog, error) {
+	var catalogs []*models.Catalog
+	query := s.db.Scopes(byCreation)
+	if orgID != "" {
+		query = query.Where("org_id = ?", orgID)
+	}
+	err := query.Find(&catalogs).Error
+	return catalogs, err
+}
+
+func (s *PostgresStorage) GetCatalog(id string) (*models.Catalog, error) {
+	var catalog models.Catalog
+	err := s.db.Where("id = ?", id).First(&catalog).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &catalog, nil
+}
+
+func (s *PostgresStorage) CreateCatalog(catalog *models.Catalog) error {
+	if catalog == nil || catalog.ID == "" {
+		return ErrInvalidInput
+	}
+
+	catalog.CreatedAt = time.Now()
+	catalog.UpdatedAt = catalog.CreatedAt
+	catalog.ResourceVersion = 1
+
+	err := s.db.Omit(clause.Associations).Create(catalog).Error
+	if err != nil {
+		if isDuplicateKeyError(err) {
+			return ErrAlreadyExists
+		}
+		if isForeignKeyError(err) {
+			return ErrInvalidInput
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *PostgresStorage) UpdateCatalog(catalog *models.Catalog) error {
+	if catalog == nil || catalog.ID == "" {
+		return ErrInvalidInput
+	}
+
+	catalog.UpdatedAt = time.Now()
+	return s.updateVersioned(catalog, "catalogs", catalog.ID, &catalog.ResourceVersion)
+}
+
+func (s *PostgresStorage) DeleteCatalog(id string) error {
+	// The foreign key clears the catalog of its templates
+	result := s.db.Delete(&models.Catalog{}, "id = ?", id)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -352,16 +578,39 @@ func (s *PostgresStorage) DeleteVDC(id string) error {
 // Template operations
 func (s *PostgresStorage) ListTemplates() ([]*models.Template, error) {
 	var templates []*models.Template
-	err := s.db.Find(&templates).Error
+	err := s.db.Scopes(byCreation).Find(&templates).Error
 	return templates, err
 }
 
 func (s *PostgresStorage) ListTemplatesByOrg(orgID string) ([]*models.Template, error) {
 	var templates []*models.Template
-	err := s.db.Where("org_id = ?", orgID).Find(&templates).Error
+	err := s.db.Scopes(byCreation).Where("org_id = ?", orgID).Find(&templates).Error
 	return templates, err
 }
 
+func (s *PostgresStorage) QueryTemplates(opts ListOptions) ([]*models.Template, string, int, error) {
+	page, err := opts.resolve(templateSortColumns)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	query := s.db.Model(&models.Template{})
+	if opts.OrgID != "" {
+		query = query.Where("org_id = ?", opts.OrgID)
+	}
+	if opts.Name != "" {
+		query = query.Where("LOWER(name) LIKE ? ESCAPE '\\'", likePattern(opts.Name))
+	}
+
+	var templates []*models.Template
+	total, err := findPage(query, page, &templates)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	n, next := page.trim(len(templates))
+	return templates[:n], next, total, nil
+}
+
 func (s *PostgresStorage) GetTemplate(id string) (*models.Template, error) {
 	var template models.Template
 	err := s.db.Where("id = ?", id).First(&template).Error
@@ -381,12 +630,16 @@ func (s *PostgresStorage) CreateTemplate(template *models.Template) error {
 
 	template.CreatedAt = time.Now()
 	template.UpdatedAt = template.CreatedAt
+	template.ResourceVersion = 1
 
 	err := s.db.Create(template).Error
 	if err != nil {
 		if isDuplicateKeyError(err) {
 			return ErrAlreadyExists
 		}
+		if isForeignKeyError(err) {
+			return ErrInvalidInput
+		}
 		return err
 	}
 	return nil
@@ -398,14 +651,7 @@ func (s *PostgresStorage) UpdateTemplate(template *models.Template) error {
 	}
 
 	template.UpdatedAt = time.Now()
-	result := s.db.Save(template)
-	if result.Error != nil {
-		return result.Error
-	}
-	if result.RowsAffected == 0 {
-		return ErrNotFound
-	}
-	return nil
+	return s.updateVersioned(template, "templates", template.ID, &template.ResourceVersion)
 }
 
 func (s *PostgresStorage) DeleteTemplate(id string) error {
@@ -422,7 +668,7 @@ func (s *PostgresStorage) DeleteTemplate(id string) error {
 // VM operations
 func (s *PostgresStorage) ListVMs(orgID string) ([]*models.VirtualMachine, error) {
 	var vms []*models.VirtualMachine
-	query := s.db
+	query := s.db.Scopes(notDeleted, byCreation)
 	if orgID != "" {
 		query = query.Where("org_id = ?", orgID)
 	}
@@ -430,9 +676,41 @@ func (s *PostgresStorage) ListVMs(orgID string) ([]*models.VirtualMachine, error
 	return vms, err
 }
 
+func (s *PostgresStorage) QueryVMs(opts ListOptions) ([]*models.VirtualMachine, string, int, error) {
+	page, err := opts.resolve(vmSortColumns)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	query := s.db.Model(&models.VirtualMachine{}).Scopes(notDeleted)
+	if opts.OrgID != "" {
+		query = query.Where("org_id = ?", opts.OrgID)
+	}
+	if opts.OwnerID != "" {
+		query = query.Where("owner_id = ?", opts.OwnerID)
+	}
+	if opts.VDCID != "" {
+		query = query.Where("vdc_id = ?", opts.VDCID)
+	}
+	if opts.Status != "" {
+		query = query.Where("status = ?", opts.Status)
+	}
+	if opts.Name != "" {
+		query = query.Where("LOWER(name) LIKE ? ESCAPE '\\'", likePattern(opts.Name))
+	}
+
+	var vms []*models.VirtualMachine
+	total, err := findPage(query, page, &vms)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	n, next := page.trim(len(vms))
+	return vms[:n], next, total, nil
+}
+
 func (s *PostgresStorage) GetVM(id string) (*models.VirtualMachine, error) {
 	var vm models.VirtualMachine
-	err := s.db.Where("id = ?", id).First(&vm).Error
+	err := s.db.Scopes(notDeleted).Where("id = ?", id).First(&vm).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, ErrNotFound
@@ -449,6 +727,7 @@ func (s *PostgresStorage) CreateVM(vm *models.VirtualMachine) error {
 
 	vm.CreatedAt = time.Now()
 	vm.UpdatedAt = vm.CreatedAt
+	vm.ResourceVersion = 1
 
 	err := s.db.Create(vm).Error
 	if err != nil {
@@ -466,7 +745,14 @@ func (s *PostgresStorage) UpdateVM(vm *models.VirtualMachine) error {
 	}
 
 	vm.UpdatedAt = time.Now()
-	result := s.db.Save(vm)
+	return s.updateVersioned(vm, "virtual_machines", vm.ID, &vm.ResourceVersion, notDeleted)
+}
+
+func (s *PostgresStorage) DeleteVM(id string) error {
+	result := s.db.Model(&models.VirtualMachine{}).Scopes(notDeleted).Where("id = ?", id).Updates(map[string]interface{}{
+		"deleted_at":       time.Now(),
+		"resource_version": gorm.Expr("resource_version + 1"),
+	})
 	if result.Error != nil {
 		return result.Error
 	}
@@ -476,8 +762,67 @@ func (s *PostgresStorage) UpdateVM(vm *models.VirtualMachine) error {
 	return nil
 }
 
-func (s *PostgresStorage) DeleteVM(id string) error {
-	result := s.db.Delete(&models.VirtualMachine{}, "id = ?", id)
+// Trash operations
+func (s *PostgresStorage) ListDeletedOrganizations() ([]*models.Organization, error) {
+	var orgs []*models.Organization
+	err := s.db.Where("deleted_at IS NOT NULL").Order("deleted_at").Find(&orgs).Error
+	return orgs, err
+}
+
+func (s *PostgresStorage) RestoreOrganization(id string) error {
+	return s.restore(&models.Organization{}, id)
+}
+
+func (s *PostgresStorage) PurgeOrganization(id string) error {
+	return s.purge(&models.Organization{}, id)
+}
+
+func (s *PostgresStorage) ListDeletedVDCs() ([]*models.VirtualDataCenter, error) {
+	var vdcs []*models.VirtualDataCenter
+	err := s.db.Where("deleted_at IS NOT NULL").Order("deleted_at").Find(&vdcs).Error
+	return vdcs, err
+}
+
+func (s *PostgresStorage) RestoreVDC(id string) error {
+	return s.restore(&models.VirtualDataCenter{}, id)
+}
+
+func (s *PostgresStorage) PurgeVDC(id string) error {
+	return s.purge(&models.VirtualDataCenter{}, id)
+}
+
+func (s *PostgresStorage) ListDeletedVMs() ([]*models.VirtualMachine, error) {
+	var vms []*models.VirtualMachine
+	err := s.db.Where("deleted_at IS NOT NULL").Order("deleted_at").Find(&vms).Error
+	return vms, err
+}
+
+func (s *PostgresStorage) RestoreVM(id string) error {
+	return s.restore(&models.VirtualMachine{}, id)
+}
+
+func (s *PostgresStorage) PurgeVM(id string) error {
+	return s.purge(&models.VirtualMachine{}, id)
+}
+
+// restore clears the tombstone of a deleted record
+func (s *PostgresStorage) restore(model interface{}, id string) error {
+	result := s.db.Model(model).Where("id = ? AND deleted_at IS NOT NULL", id).Updates(map[string]interface{}{
+		"deleted_at":       nil,
+		"resource_version": gorm.Expr("resource_version + 1"),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// purge permanently removes a record, whether or not it was deleted before
+func (s *PostgresStorage) purge(model interface{}, id string) error {
+	result := s.db.Where("id = ?", id).Delete(model)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -487,6 +832,14 @@ func (s *PostgresStorage) DeleteVM(id string) error {
 	return nil
 }
 
+// WithTx runs fn in a database transaction, committing it only if fn succeeds.
+// Nested calls use savepoints. The transactional storage passed to fn must not be closed.
+func (s *PostgresStorage) WithTx(fn func(tx Storage) error) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&PostgresStorage{db: tx, changes: s.changes})
+	})
+}
+
 // Health operations
 func (s *PostgresStorage) Ping() error {
 	sqlDB, err := s.db.DB()
@@ -496,7 +849,14 @@ func (s *PostgresStorage) Ping() error {
 	return sqlDB.Ping()
 }
 
+// Watch relays the notifications of the change triggers, which every server sharing the
+// database receives
+func (s *PostgresStorage) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	return s.changes.watch(ctx)
+}
+
 func (s *PostgresStorage) Close() error {
+	s.changes.close()
 	sqlDB, err := s.db.DB()
 	if err != nil {
 		return err
@@ -505,18 +865,87 @@ func (s *PostgresStorage) Close() error {
 	return sqlDB.Close()
 }
 
-// Helper function to check for duplicate key errors
-func isDuplicateKeyError(err error) bool {
-	// PostgreSQL error codes for unique violation
-	return err != nil && (
-	// Check for common PostgreSQL unique constraint violation patterns
-	contains(err.Error(), "duplicate key") ||
-		contains(err.Error(), "unique constraint") ||
-		contains(err.Error(), "UNIQUE constraint"))
+// pagedQuery applies the ordering and bounds of a page to query, fetching one extra row
+func pagedQuery(query *gorm.DB, page listPage) *gorm.DB {
+	return query.Order(page.orderClause()).Offset(page.offset).Limit(page.limit + 1)
 }
 
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr ||
+// findPage fetches the page of query into dest and returns the number of rows matching query
+func findPage(query *gorm.DB, page listPage, dest interface{}) (int, error) {
+	query = query.Session(&gorm.Session{})
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return 0, err
+	}
+	if err := pagedQuery(query, page).Find(dest).Error; err != nil {
+		return 0, err
+	}
+	return int(total), nil
+}
+
+// byCreation orders the records of list operations oldest first
+func byCreation(db *gorm.DB) *gorm.DB {
+	return db.Order("created_at, id")
+}
+
+// notDeleted excludes organizations, VDCs and VMs that were moved to the trash
+func notDeleted(db *gorm.DB) *gorm.DB {
+	return db.Where("deleted_at IS NULL")
+}
+
+// updateVersioned saves a resource while holding a row lock, after checking the stored resource
+// version against the one the caller read. On success version holds the new resource version.
+func (s *PostgresStorage) updateVersioned(value interface{}, table, id string, version *int64, scopes ...func(*gorm.DB) *gorm.DB) error {
+	expected := *version
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var current []int64
+		err := tx.Table(table).Scopes(scopes...).Where("id = ?", id).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Pluck("resource_version", &current).Error
+		if err != nil {
+			return err
+		}
+		if len(current) == 0 {
+			return ErrNotFound
+		}
+
+		next, err := nextVersion(expected, current[0])
+		if err != nil {
+			return err
+		}
+		*version = next
+		return tx.Omit(clause.Associations).Save(value).Error
+	})
+	if err != nil {
+		*version = expected
+		if isDuplicateKeyError(err) {
+			return ErrAlreadyExists
+		}
+		if isForeignKeyError(err) {
+			return ErrInvalidInput
+		}
+	}
+	return err
+}
+
+// Helper function to check for duplicate key errors
+func isDuplicateKeyError(err error) bool {
+	// PostgreSQL error codes for unique violation
+	return err != nil && (
+	// Check for common PostgreSQL unique constraint violation patterns
+	contains(err.Error(), "duplicate key") ||
+		contains(err.Error(), "unique constraint") ||
+		contains(err.Error(), "UNIQUE constraint"))
+}
+
+// isForeignKeyError reports whether err is a PostgreSQL or SQLite foreign key violation
+func isForeignKeyError(err error) bool {
+	return err != nil && (contains(err.Error(), "foreign key constraint") ||
+		contains(err.Error(), "FOREIGN KEY constraint"))
+}
+
+func contains(s, substr string) bool {
+	return len(s) >= len(substr) && (s == substr ||
 		len(s) > len(substr) && (s[:len(substr)] == substr ||
 			s[len(s)-len(substr):] == substr ||
 			indexOfSubstring(s, substr) >= 0))
@@ -534,46 +963,23 @@ func indexOfSubstring(s, substr string) int {
 // NewPostgresStorageForTest creates a PostgreSQL storage instance for testing
 // This version clears all data and doesn't seed initial data, providing a clean slate for tests
 func NewPostgresStorageForTest(dsn string) (Storage, error) {
-	// Configure GORM logger to use klog
-	gormLogger := logger.New(
-		&klogWriter{},
-		logger.Config{
-			SlowThreshold:             time.Second,
-			LogLevel:                  logger.Info,
-			IgnoreRecordNotFoundError: true,
-			Colorful:                  false,
-		},
-	)
-
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: gormLogger,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
-	}
-
-	// Configure connection pool
-	sqlDB, err := db.DB()
+	db, err := openPostgres(dsn)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get underlying sql.DB: %w", err)
+		return nil, err
 	}
 
-	sqlDB.SetMaxOpenConns(25)
-	sqlDB.SetMaxIdleConns(10)
-	sqlDB.SetConnMaxLifetime(time.Hour)
+	storage := &PostgresStorage{db: db, changes: newPGChangeFeed(db)}
 
-	storage := &PostgresStorage{db: db}
+	// Run migrations first so that the tables exist on a fresh database
+	if err := storage.migrate(); err != nil {
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
 
 	// Clear all existing data for a clean test environment
 	if err := storage.clearAllData(); err != nil {
 		return nil, fmt.Errorf("failed to clear test data: %w", err)
 	}
 
-	// Run migrations
-	if err := storage.migrate(); err != nil {
-		return nil, fmt.Errorf("failed to run migrations: %w", err)
-	}
-
 	klog.Info("Initialized PostgreSQL storage for testing with clean database")
 	return storage, nil
 }
@@ -582,8 +988,19 @@ func NewPostgresStorageForTest(dsn string) (Storage, error) {
 func (s *PostgresStorage) clearAllData() error {
 	// Delete all data in reverse order to respect foreign key constraints
 	tables := []string{
+		"audit_records",
+		"invitations",
+		"impersonations",
+		"org_memberships",
+		"password_reset_tokens",
+		"login_attempts",
+		"org_roles",
+		"api_tokens",
+		"revoked_tokens",
+		"refresh_tokens",
 		"virtual_machines",
 		"templates",
+		"catalogs",
 		"organization_catalog_sources",
 		"virtual_data_centers",
 		"organizations",
@@ -604,7 +1021,7 @@ func (s *PostgresStorage) clearAllData() error {
 
 func (s *PostgresStorage) ListOrganizationCatalogSources(orgID string) ([]*models.OrganizationCatalogSource, error) {
 	var sources []*models.OrganizationCatalogSource
-	if err := s.db.Where("org_id = ?", orgID).Find(&sources).Error; err != nil {
+	if err := s.db.Scopes(byCreation).Where("org_id = ?", orgID).Find(&sources).Error; err != nil {
 		return nil, fmt.Errorf("failed to list organization catalog sources: %w", err)
 	}
 	return sources, nil
@@ -622,22 +1039,810 @@ func (s *PostgresStorage) GetOrganizationCatalogSource(id string) (*models.Organ
 }
 
 func (s *PostgresStorage) CreateOrganizationCatalogSource(source *models.OrganizationCatalogSource) error {
+	if source == nil || source.ID == "" {
+		return ErrInvalidInput
+	}
+
+	source.CreatedAt = time.Now()
+	source.UpdatedAt = source.CreatedAt
 	if err := s.db.Create(source).Error; err != nil {
+		if isDuplicateKeyError(err) {
+			return ErrAlreadyExists
+		}
 		return fmt.Errorf("failed to create organization catalog source: %w", err)
 	}
 	return nil
 }
 
 func (s *PostgresStorage) UpdateOrganizationCatalogSource(source *models.OrganizationCatalogSource) error {
-	if err := s.db.Save(source).Error; err != nil {
-		return fmt.Errorf("failed to update organization catalog source: %w", err)
+	if source == nil || source.ID == "" {
+		return ErrInvalidInput
+	}
+
+	// Save would insert a missing source, update only an existing row and keep its creation time
+	source.UpdatedAt = time.Now()
+	result := s.db.Model(source).Select("*").Omit("id", "created_at").Updates(source)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update organization catalog source: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
 	}
 	return nil
 }
 
 func (s *PostgresStorage) DeleteOrganizationCatalogSource(id string) error {
-	if err := s.db.Delete(&models.OrganizationCatalogSource{}, "id = ?", id).Error; err != nil {
-		return fmt.Errorf("failed to delete organization catalog source: %w", err)
+	result := s.db.Delete(&models.OrganizationCatalogSource{}, "id = ?", id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete organization catalog source: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Token operations. Token times are stored in UTC, SQLite compares them as text.
+
+func (s *PostgresStorage) CreateRefreshToken(token *models.RefreshToken) error {
+	if token == nil || token.ID == "" {
+		return ErrInvalidInput
+	}
+
+	token.CreatedAt = time.Now().UTC()
+	token.AccessTokenExpiresAt = token.AccessTokenExpiresAt.UTC()
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	if err := s.db.Create(token).Error; err != nil {
+		if isDuplicateKeyError(err) {
+			return ErrAlreadyExists
+		}
+		if isForeignKeyError(err) {
+			return ErrInvalidInput
+		}
+		return fmt.Errorf("failed to create refresh token: %w", err)
 	}
 	return nil
 }
+
+func (s *PostgresStorage) GetRefreshTokenByHash(hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	if err := s.db.First(&token, "token_hash = ?", hash).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	return &token, nil
+}
+
+func (s *PostgresStorage) RevokeRefreshToken(id string) error {
+	result := s.db.Model(&models.RefreshToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now().UTC())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke refresh token: %w", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return nil
+	}
+
+	var count int64
+	if err := s.db.Model(&models.RefreshToken{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrConflict
+}
+
+func (s *PostgresStorage) RevokeSession(sessionID string) error {
+	return s.revokeRefreshTokens("session_id", sessionID)
+}
+
+func (s *PostgresStorage) RevokeUserTokens(userID string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		txStorage := &PostgresStorage{db: tx}
+		if err := txStorage.revokeRefreshTokens("user_id", userID); err != nil {
+			return err
+		}
+		return txStorage.endImpersonations(userID)
+	})
+}
+
+// endImpersonations ends the ongoing impersonations of or by a user and revokes their tokens
+func (s *PostgresStorage) endImpersonations(userID string) error {
+	now := time.Now().UTC()
+	err := s.db.Exec(`INSERT INTO revoked_tokens (id, user_id, expires_at, revoked_at)
+		SELECT token_id, user_id, expires_at, ?
+		FROM impersonations WHERE (user_id = ? OR impersonator_id = ?) AND ended_at IS NULL AND expires_at > ?
+		ON CONFLICT (id) DO NOTHING`, now, userID, userID, now).Error
+	if err != nil {
+		return fmt.Errorf("failed to revoke impersonation tokens: %w", err)
+	}
+	err = s.db.Model(&models.Impersonation{}).
+		Where("(user_id = ? OR impersonator_id = ?) AND ended_at IS NULL", userID, userID).
+		Update("ended_at", now).Error
+	if err != nil {
+		return fmt.Errorf("failed to end impersonations: %w", err)
+	}
+	return nil
+}
+
+// revokeRefreshTokens revokes the refresh tokens whose column has the given value, together with
+// the access tokens issued with them
+func (s *PostgresStorage) revokeRefreshTokens(column, value string) error {
+	now := time.Now().UTC()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(fmt.Sprintf(`INSERT INTO revoked_tokens (id, user_id, expires_at, revoked_at)
+			SELECT access_token_id, user_id, access_token_expires_at, ?
+			FROM refresh_tokens WHERE %s = ? AND access_token_expires_at > ?
+			ON CONFLICT (id) DO NOTHING`, column), now, value, now).Error
+		if err != nil {
+			return fmt.Errorf("failed to revoke access tokens: %w", err)
+		}
+		err = tx.Model(&models.RefreshToken{}).
+			Where(column+" = ? AND revoked_at IS NULL", value).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *PostgresStorage) RevokeToken(token *models.RevokedToken) error {
+	if token == nil || token.ID == "" {
+		return ErrInvalidInput
+	}
+
+	token.RevokedAt = time.Now().UTC()
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(token).Error; err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) IsTokenRevoked(id string) (bool, error) {
+	var count int64
+	if err := s.db.Model(&models.RevokedToken{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	return count > 0, nil
+}
+
+func (s *PostgresStorage) PurgeExpiredTokens(before time.Time) (int, error) {
+	before = before.UTC()
+	purged := 0
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&models.RefreshToken{}, "expires_at < ?", before)
+		if result.Error != nil {
+			return fmt.Errorf("failed to purge refresh tokens: %w", result.Error)
+		}
+		purged += int(result.RowsAffected)
+
+		result = tx.Delete(&models.RevokedToken{}, "expires_at < ?", before)
+		if result.Error != nil {
+			return fmt.Errorf("failed to purge revoked tokens: %w", result.Error)
+		}
+		purged += int(result.RowsAffected)
+
+		result = tx.Delete(&models.PasswordResetToken{}, "expires_at < ?", before)
+		if result.Error != nil {
+			return fmt.Errorf("failed to purge password reset tokens: %w", result.Error)
+		}
+		purged += int(result.RowsAffected)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// Password reset token operations
+
+func (s *PostgresStorage) CreatePasswordResetToken(token *models.PasswordResetToken) error {
+	if token == nil || token.ID == "" {
+		return ErrInvalidInput
+	}
+
+	token.CreatedAt = time.Now().UTC()
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	if err := s.db.Create(token).Error; err != nil {
+		if isDuplicateKeyError(err) {
+			return ErrAlreadyExists
+		}
+		if isForeignKeyError(err) {
+			return ErrInvalidInput
+		}
+		return fmt.Errorf("failed to create password reset token: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) GetPasswordResetTokenByHash(hash string) (*models.PasswordResetToken, error) {
+	var token models.PasswordResetToken
+	if err := s.db.First(&token, "token_hash = ?", hash).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get password reset token: %w", err)
+	}
+	return &token, nil
+}
+
+func (s *PostgresStorage) UsePasswordResetToken(id string, usedAt time.Time) error {
+	result := s.db.Model(&models.PasswordResetToken{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", usedAt.UTC())
+	if result.Error != nil {
+		return fmt.Errorf("failed to use password reset token: %w", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return nil
+	}
+
+	var count int64
+	if err := s.db.Model(&models.PasswordResetToken{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to use password reset token: %w", err)
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrConflict
+}
+
+// Organization membership operations
+
+func (s *PostgresStorage) CreateOrgMembership(membership *models.OrgMembership) error {
+	if membership == nil || membership.ID == "" {
+		return ErrInvalidInput
+	}
+
+	membership.CreatedAt = time.Now().UTC()
+	membership.UpdatedAt = membership.CreatedAt
+	if err := s.db.Create(membership).Error; err != nil {
+		if isDuplicateKeyError(err) {
+			return ErrAlreadyExists
+		}
+		if isForeignKeyError(err) {
+			return ErrInvalidInput
+		}
+		return fmt.Errorf("failed to create membership: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) GetOrgMembership(userID, orgID string) (*models.OrgMembership, error) {
+	var membership models.OrgMembership
+	if err := s.db.Where("user_id = ? AND org_id = ?", userID, orgID).First(&membership).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get membership: %w", err)
+	}
+	return &membership, nil
+}
+
+func (s *PostgresStorage) ListOrgMemberships(userID, orgID string) ([]*models.OrgMembership, error) {
+	var memberships []*models.OrgMembership
+	query := s.db.Scopes(byCreation)
+	if userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if orgID != "" {
+		query = query.Where("org_id = ?", orgID)
+	}
+	if err := query.Find(&memberships).Error; err != nil {
+		return nil, fmt.Errorf("failed to list memberships: %w", err)
+	}
+	return memberships, nil
+}
+
+func (s *PostgresStorage) UpdateOrgMembership(membership *models.OrgMembership) error {
+	if membership == nil || membership.ID == "" {
+		return ErrInvalidInput
+	}
+
+	updatedAt := time.Now().UTC()
+	result := s.db.Model(&models.OrgMembership{}).Where("id = ?", membership.ID).Updates(map[string]interface{}{
+		"role":       membership.Role,
+		"updated_at": updatedAt,
+	})
+	if result.Error != nil {
+		return fmt.Errorf("failed to update membership: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	membership.UpdatedAt = updatedAt
+	return nil
+}
+
+func (s *PostgresStorage) DeleteOrgMembership(userID, orgID string) error {
+	result := s.db.Delete(&models.OrgMembership{}, "user_id = ? AND org_id = ?", userID, orgID)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete membership: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Impersonation operations
+
+func (s *PostgresStorage) CreateImpersonation(impersonation *models.Impersonation) error {
+	if impersonation == nil || impersonation.ID == "" {
+		return ErrInvalidInput
+	}
+
+	impersonation.CreatedAt = time.Now().UTC()
+	impersonation.ExpiresAt = impersonation.ExpiresAt.UTC()
+	if err := s.db.Create(impersonation).Error; err != nil {
+		if isDuplicateKeyError(err) {
+			return ErrAlreadyExists
+		}
+		if isForeignKeyError(err) {
+			return ErrInvalidInput
+		}
+		return fmt.Errorf("failed to create impersonation: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) ListImpersonations(userID string) ([]*models.Impersonation, error) {
+	var impersonations []*models.Impersonation
+	query := s.db.Scopes(byCreation)
+	if userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.Find(&impersonations).Error; err != nil {
+		return nil, fmt.Errorf("failed to list impersonations: %w", err)
+	}
+	return impersonations, nil
+}
+
+func (s *PostgresStorage) GetImpersonation(id string) (*models.Impersonation, error) {
+	var impersonation models.Impersonation
+	if err := s.db.First(&impersonation, "id = ?", id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get impersonation: %w", err)
+	}
+	return &impersonation, nil
+}
+
+func (s *PostgresStorage) EndImpersonation(id string, endedAt time.Time) error {
+	result := s.db.Model(&models.Impersonation{}).
+		Where("id = ? AND ended_at IS NULL", id).
+		Update("ended_at", endedAt.UTC())
+	if result.Error != nil {
+		return fmt.Errorf("failed to end impersonation: %w", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return nil
+	}
+
+	var count int64
+	if err := s.db.Model(&models.Impersonation{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to end impersonation: %w", err)
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrConflict
+}
+
+// Invitation operations
+
+func (s *PostgresStorage) CreateInvitation(invitation *models.Invitation) error {
+	if invitation == nil || invitation.ID == "" {
+		return ErrInvalidInput
+	}
+
+	invitation.CreatedAt = time.Now().UTC()
+	invitation.UpdatedAt = invitation.CreatedAt
+	invitation.ExpiresAt = invitation.ExpiresAt.UTC()
+	if err := s.db.Create(invitation).Error; err != nil {
+		if isDuplicateKeyError(err) {
+			return ErrAlreadyExists
+		}
+		if isForeignKeyError(err) {
+			return ErrInvalidInput
+		}
+		return fmt.Errorf("failed to create invitation: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) GetInvitation(id string) (*models.Invitation, error) {
+	var invitation models.Invitation
+	if err := s.db.First(&invitation, "id = ?", id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get invitation: %w", err)
+	}
+	return &invitation, nil
+}
+
+func (s *PostgresStorage) ListInvitations(orgID string) ([]*models.Invitation, error) {
+	var invitations []*models.Invitation
+	query := s.db.Scopes(byCreation)
+	if orgID != "" {
+		query = query.Where("org_id = ?", orgID)
+	}
+	if err := query.Find(&invitations).Error; err != nil {
+		return nil, fmt.Errorf("failed to list invitations: %w", err)
+	}
+	return invitations, nil
+}
+
+func (s *PostgresStorage) RenewInvitation(id, tokenID string, expiresAt time.Time) error {
+	return s.updatePendingInvitation(id, map[string]interface{}{
+		"token_id":   tokenID,
+		"expires_at": expiresAt.UTC(),
+	})
+}
+
+func (s *PostgresStorage) AcceptInvitation(id, userID string, acceptedAt time.Time) error {
+	return s.updatePendingInvitation(id, map[string]interface{}{
+		"accepted_at": acceptedAt.UTC(),
+		"user_id":     userID,
+	})
+}
+
+func (s *PostgresStorage) RevokeInvitation(id string, revokedAt time.Time) error {
+	return s.updatePendingInvitation(id, map[string]interface{}{
+		"revoked_at": revokedAt.UTC(),
+	})
+}
+
+// updatePendingInvitation updates an invitation that was neither accepted nor revoked
+func (s *PostgresStorage) updatePendingInvitation(id string, updates map[string]interface{}) error {
+	updates["updated_at"] = time.Now().UTC()
+	result := s.db.Model(&models.Invitation{}).
+		Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", id).
+		Updates(updates)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update invitation: %w", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return nil
+	}
+
+	var count int64
+	if err := s.db.Model(&models.Invitation{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to update invitation: %w", err)
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrConflict
+}
+
+// API token operations
+
+func (s *PostgresStorage) CreateAPIToken(token *models.APIToken) error {
+	if token == nil || token.ID == "" {
+		return ErrInvalidInput
+	}
+
+	token.CreatedAt = time.Now().UTC()
+	token.UpdatedAt = token.CreatedAt
+	if token.ExpiresAt != nil {
+		expiresAt := token.ExpiresAt.UTC()
+		token.ExpiresAt = &expiresAt
+	}
+	if err := s.db.Create(token).Error; err != nil {
+		if isDuplicateKeyError(err) {
+			return ErrAlreadyExists
+		}
+		if isForeignKeyError(err) {
+			return ErrInvalidInput
+		}
+		return fmt.Errorf("failed to create API token: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) ListAPITokens(userID string) ([]*models.APIToken, error) {
+	var tokens []*models.APIToken
+	query := s.db.Scopes(byCreation)
+	if userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.Find(&tokens).Error; err != nil {
+		return nil, fmt.Errorf("failed to list API tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+func (s *PostgresStorage) GetAPIToken(id string) (*models.APIToken, error) {
+	return s.getAPIToken("id = ?", id)
+}
+
+func (s *PostgresStorage) GetAPITokenByHash(hash string) (*models.APIToken, error) {
+	return s.getAPIToken("token_hash = ?", hash)
+}
+
+func (s *PostgresStorage) getAPIToken(condition string, value string) (*models.APIToken, error) {
+	var token models.APIToken
+	if err := s.db.First(&token, condition, value).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get API token: %w", err)
+	}
+	return &token, nil
+}
+
+func (s *PostgresStorage) UpdateAPIToken(token *models.APIToken) error {
+	if token == nil || token.ID == "" {
+		return ErrInvalidInput
+	}
+
+	var expiresAt *time.Time
+	if token.ExpiresAt != nil {
+		utc := token.ExpiresAt.UTC()
+		expiresAt = &utc
+	}
+	updatedAt := time.Now().UTC()
+	result := s.db.Model(&models.APIToken{}).Where("id = ?", token.ID).Updates(map[string]interface{}{
+		"name":       token.Name,
+		"scopes":     token.Scopes,
+		"expires_at": expiresAt,
+		"updated_at": updatedAt,
+	})
+	if result.Error != nil {
+		if isDuplicateKeyError(result.Error) {
+			return ErrAlreadyExists
+		}
+		return fmt.Errorf("failed to update API token: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	token.UpdatedAt = updatedAt
+	return nil
+}
+
+func (s *PostgresStorage) TouchAPIToken(id string, usedAt time.Time) error {
+	result := s.db.Model(&models.APIToken{}).Where("id = ?", id).UpdateColumn("last_used_at", usedAt.UTC())
+	if result.Error != nil {
+		return fmt.Errorf("failed to update API token: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStorage) DeleteAPIToken(id string) error {
+	result := s.db.Delete(&models.APIToken{}, "id = ?", id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete API token: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Custom role operations
+
+func (s *PostgresStorage) ListOrgRoles(orgID string) ([]*models.OrgRole, error) {
+	var roles []*models.OrgRole
+	if err := s.db.Scopes(byCreation).Where("org_id = ?", orgID).Find(&roles).Error; err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+	return roles, nil
+}
+
+func (s *PostgresStorage) GetOrgRole(id string) (*models.OrgRole, error) {
+	return s.getOrgRole("id = ?", id)
+}
+
+func (s *PostgresStorage) GetOrgRoleByName(orgID, name string) (*models.OrgRole, error) {
+	return s.getOrgRole("org_id = ? AND name = ?", orgID, name)
+}
+
+func (s *PostgresStorage) getOrgRole(condition string, values ...interface{}) (*models.OrgRole, error) {
+	var role models.OrgRole
+	if err := s.db.Where(condition, values...).First(&role).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+	return &role, nil
+}
+
+func (s *PostgresStorage) CreateOrgRole(role *models.OrgRole) error {
+	if role == nil || role.ID == "" {
+		return ErrInvalidInput
+	}
+
+	role.CreatedAt = time.Now().UTC()
+	role.UpdatedAt = role.CreatedAt
+	if err := s.db.Create(role).Error; err != nil {
+		if isDuplicateKeyError(err) {
+			return ErrAlreadyExists
+		}
+		if isForeignKeyError(err) {
+			return ErrInvalidInput
+		}
+		return fmt.Errorf("failed to create role: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) UpdateOrgRole(role *models.OrgRole) error {
+	if role == nil || role.ID == "" {
+		return ErrInvalidInput
+	}
+
+	updatedAt := time.Now().UTC()
+	result := s.db.Model(&models.OrgRole{}).Where("id = ?", role.ID).Updates(map[string]interface{}{
+		"name":        role.Name,
+		"description": role.Description,
+		"verbs":       role.Verbs,
+		"updated_at":  updatedAt,
+	})
+	if result.Error != nil {
+		if isDuplicateKeyError(result.Error) {
+			return ErrAlreadyExists
+		}
+		return fmt.Errorf("failed to update role: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	role.UpdatedAt = updatedAt
+	return nil
+}
+
+func (s *PostgresStorage) DeleteOrgRole(id string) error {
+	result := s.db.Delete(&models.OrgRole{}, "id = ?", id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete role: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Audit record operations
+
+func (s *PostgresStorage) CreateAuditRecord(record *models.AuditRecord) error {
+	if record == nil || record.ID == "" {
+		return ErrInvalidInput
+	}
+
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+	record.CreatedAt = record.CreatedAt.UTC()
+	if err := s.db.Create(record).Error; err != nil {
+		if isDuplicateKeyError(err) {
+			return ErrAlreadyExists
+		}
+		return fmt.Errorf("failed to create audit record: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) QueryAuditRecords(opts ListOptions) ([]*models.AuditRecord, string, int, error) {
+	page, err := opts.resolve(auditSortColumns)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	query := s.db.Model(&models.AuditRecord{})
+	if opts.OrgID != "" {
+		query = query.Where("org_id = ?", opts.OrgID)
+	}
+	if opts.ActorID != "" {
+		query = query.Where("actor_id = ?", opts.ActorID)
+	}
+	if opts.ResourceType != "" {
+		query = query.Where("resource_type = ?", opts.ResourceType)
+	}
+	if opts.ResourceID != "" {
+		query = query.Where("resource_id = ?", opts.ResourceID)
+	}
+	if !opts.Since.IsZero() {
+		query = query.Where("created_at >= ?", opts.Since.UTC())
+	}
+	if !opts.Until.IsZero() {
+		query = query.Where("created_at < ?", opts.Until.UTC())
+	}
+
+	var records []*models.AuditRecord
+	total, err := findPage(query, page, &records)
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("failed to query audit records: %w", err)
+	}
+	n, next := page.trim(len(records))
+	return records[:n], next, total, nil
+}
+
+// Login attempt operations
+
+func (s *PostgresStorage) GetLoginAttempt(id string) (*models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	if err := s.db.Where("id = ?", id).First(&attempt).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get login attempt: %w", err)
+	}
+	return &attempt, nil
+}
+
+// recordLoginFailure counts a failure in a single statement, which PostgreSQL and SQLite both
+// evaluate against the stored row, so that concurrent failures on other replicas are not lost
+const recordLoginFailure = `
+INSERT INTO login_attempts (id, failures, last_failure_at) VALUES (@id, 1, @at)
+ON CONFLICT (id) DO UPDATE SET
+	failures = CASE
+		WHEN login_attempts.last_failure_at < @since OR login_attempts.locked_until <= @at THEN 1
+		ELSE login_attempts.failures + 1
+	END,
+	locked_until = CASE
+		WHEN login_attempts.last_failure_at < @since OR login_attempts.locked_until <= @at THEN NULL
+		ELSE login_attempts.locked_until
+	END,
+	last_failure_at = excluded.last_failure_at`
+
+func (s *PostgresStorage) RecordLoginFailure(id string, at, since time.Time) (*models.LoginAttempt, error) {
+	if id == "" {
+		return nil, ErrInvalidInput
+	}
+
+	var attempt models.LoginAttempt
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(recordLoginFailure, sql.Named("id", id), sql.Named("at", at.UTC()), sql.Named("since", since.UTC())).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).First(&attempt).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record login failure: %w", err)
+	}
+	return &attempt, nil
+}
+
+func (s *PostgresStorage) LockLogin(id string, until time.Time) error {
+	result := s.db.Model(&models.LoginAttempt{}).Where("id = ?", id).UpdateColumn("locked_until", until.UTC())
+	if result.Error != nil {
+		return fmt.Errorf("failed to lock login: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStorage) ResetLoginAttempts(id string) error {
+	if err := s.db.Delete(&models.LoginAttempt{}, "id = ?", id).Error; err != nil {
+		return fmt.Errorf("failed to reset login attempts: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) PurgeLoginAttempts(before time.Time) (int, error) {
+	before = before.UTC()
+	result := s.db.Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)", before, before).
+		Delete(&models.LoginAttempt{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge login attempts: %w", result.Error)
+	}
+	return int(result.RowsAffected), nil
+}
@@ -154,6 +154,78 @@ func TestPostgresStorage_UserOperations(t *testing.T) {
 	})
 }
 
+func TestPostgresStorage_QueryUsers(t *testing.T) {
+	storage := setupTestPostgresStorage(t)
+	defer storage.Close()
+
+	suffix := time.Now().UnixNano()
+	orgID := fmt.Sprintf("query-org-%d", suffix)
+	for i := 1; i <= 3; i++ {
+		user := &models.User{
+			ID:       fmt.Sprintf("query-user-%d-%d", suffix, i),
+			Username: fmt.Sprintf("query_%d_user%d", suffix, i),
+			Email:    fmt.Sprintf("query-%d-%d@example.com", suffix, i),
+			Role:     "org_user",
+			OrgID:    stringPtr(orgID),
+		}
+		require.NoError(t, storage.CreateUser(user))
+		defer storage.DeleteUser(user.ID)
+	}
+
+	first, next, _, err := storage.QueryUsers(ListOptions{OrgID: orgID, Limit: 2, Sort: "-name"})
+	require.NoError(t, err)
+	require.Len(t, first, 2)
+	require.NotEmpty(t, next)
+	assert.Equal(t, fmt.Sprintf("query_%d_user3", suffix), first[0].Username)
+
+	rest, next, _, err := storage.QueryUsers(ListOptions{OrgID: orgID, Limit: 2, Sort: "-name", Cursor: next})
+	require.NoError(t, err)
+	require.Len(t, rest, 1)
+	assert.Empty(t, next)
+	assert.Equal(t, fmt.Sprintf("query_%d_user1", suffix), rest[0].Username)
+
+	// Underscores in the name filter are matched literally
+	matched, _, _, err := storage.QueryUsers(ListOptions{Name: fmt.Sprintf("QUERY_%d_USER2", suffix)})
+	require.NoError(t, err)
+	require.Len(t, matched, 1)
+}
+
+func TestPostgresStorage_ResourceVersions(t *testing.T) {
+	storage := setupTestPostgresStorage(t)
+	defer storage.Close()
+
+	user := &models.User{
+		ID:       fmt.Sprintf("version-user-%d", time.Now().UnixNano()),
+		Username: fmt.Sprintf("version_user_%d", time.Now().UnixNano()),
+		Email:    fmt.Sprintf("version-%d@example.com", time.Now().UnixNano()),
+		Role:     "org_user",
+	}
+	require.NoError(t, storage.CreateUser(user))
+	defer storage.DeleteUser(user.ID)
+	assert.Equal(t, int64(1), user.ResourceVersion)
+
+	first, err := storage.GetUserByID(user.ID)
+	require.NoError(t, err)
+	second, err := storage.GetUserByID(user.ID)
+	require.NoError(t, err)
+
+	first.Role = "org_admin"
+	require.NoError(t, storage.UpdateUser(first))
+	assert.Equal(t, int64(2), first.ResourceVersion)
+
+	second.Email = "lost-update@example.com"
+	assert.ErrorIs(t, storage.UpdateUser(second), ErrConflict)
+	assert.Equal(t, int64(1), second.ResourceVersion)
+
+	stored, err := storage.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "org_admin", stored.Role)
+	assert.Equal(t, int64(2), stored.ResourceVersion)
+
+	missing := &models.User{ID: "version-user-missing", Username: "missing", Email: "missing@example.com"}
+	assert.ErrorIs(t, storage.UpdateUser(missing), ErrNotFound)
+}
+
 func TestPostgresStorage_OrganizationOperations(t *testing.T) {
 	storage := setupTestPostgresStorage(t)
 	defer storage.Close()
@@ -726,6 +798,108 @@ func TestPostgresStorage_TransactionHandling(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestPostgresStorage_WithTx(t *testing.T) {
+	storage := setupTestPostgresStorage(t)
+	defer storage.Close()
+
+	suffix := time.Now().UnixNano()
+	user := &models.User{
+		ID:       fmt.Sprintf("withtx-user-%d", suffix),
+		Username: fmt.Sprintf("withtx_user_%d", suffix),
+		Email:    fmt.Sprintf("withtx-%d@example.com", suffix),
+		Role:     "org_user",
+		OrgID:    stringPtr("withtx-org"),
+	}
+	require.NoError(t, storage.CreateUser(user))
+	defer storage.DeleteUser(user.ID)
+
+	failure := fmt.Errorf("step failed")
+	err := storage.WithTx(func(tx Storage) error {
+		stored, err := tx.GetUserByID(user.ID)
+		if err != nil {
+			return err
+		}
+		stored.OrgID = nil
+		if err := tx.UpdateUser(stored); err != nil {
+			return err
+		}
+		return failure
+	})
+	assert.ErrorIs(t, err, failure)
+
+	stored, err := storage.GetUserByID(user.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored.OrgID)
+	assert.Equal(t, int64(1), stored.ResourceVersion)
+
+	err = storage.WithTx(func(tx Storage) error {
+		stored.OrgID = nil
+		return tx.UpdateUser(stored)
+	})
+	require.NoError(t, err)
+
+	stored, err = storage.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Nil(t, stored.OrgID)
+}
+
+func TestPostgresStorage_Trash(t *testing.T) {
+	storage := setupTestPostgresStorage(t)
+	defer storage.Close()
+
+	suffix := time.Now().UnixNano()
+	org := &models.Organization{
+		ID:          fmt.Sprintf("trash-org-%d", suffix),
+		Name:        fmt.Sprintf("trash-org-%d", suffix),
+		CRName:      fmt.Sprintf("trash-org-%d", suffix),
+		CRNamespace: "ovim-system",
+	}
+	vm := &models.VirtualMachine{
+		ID:    fmt.Sprintf("trash-vm-%d", suffix),
+		Name:  "trash-vm",
+		OrgID: org.ID,
+	}
+	require.NoError(t, storage.CreateOrganization(org))
+	defer storage.PurgeOrganization(org.ID)
+	require.NoError(t, storage.CreateVM(vm))
+	defer storage.PurgeVM(vm.ID)
+
+	require.NoError(t, storage.DeleteOrganization(org.ID))
+	assert.ErrorIs(t, storage.DeleteOrganization(org.ID), ErrNotFound)
+
+	_, err := storage.GetOrganization(org.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, storage.UpdateOrganization(&models.Organization{ID: org.ID, Name: org.Name, CRName: org.CRName}), ErrNotFound)
+
+	deleted, err := storage.ListDeletedOrganizations()
+	require.NoError(t, err)
+	var found *models.Organization
+	for _, o := range deleted {
+		if o.ID == org.ID {
+			found = o
+		}
+	}
+	require.NotNil(t, found)
+	assert.NotNil(t, found.DeletedAt)
+	assert.Equal(t, int64(2), found.ResourceVersion)
+
+	require.NoError(t, storage.RestoreOrganization(org.ID))
+	assert.ErrorIs(t, storage.RestoreOrganization(org.ID), ErrNotFound)
+	restored, err := storage.GetOrganization(org.ID)
+	require.NoError(t, err)
+	assert.Nil(t, restored.DeletedAt)
+	assert.Equal(t, int64(3), restored.ResourceVersion)
+
+	require.NoError(t, storage.DeleteVM(vm.ID))
+	vms, err := storage.ListVMs(org.ID)
+	require.NoError(t, err)
+	assert.Empty(t, vms)
+
+	require.NoError(t, storage.PurgeVM(vm.ID))
+	assert.ErrorIs(t, storage.PurgeVM(vm.ID), ErrNotFound)
+	assert.ErrorIs(t, storage.RestoreVM(vm.ID), ErrNotFound)
+}
+
 func TestPostgresStorage_ErrorHandling(t *testing.T) {
 	storage := setupTestPostgresStorage(t)
 	defer storage.Close()
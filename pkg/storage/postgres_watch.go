@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/gorm"
+	"k8s.io/klog/v2"
+)
+
+// changeChannel is the channel notified by the change triggers of migration 005
+const changeChannel = "ovim_changes"
+
+// pgChangeFeed relays the notifications of the change triggers to the watchers of a storage.
+// It listens on a dedicated connection from the first watch on. When that connection fails the
+// notifications sent meanwhile are lost, so every watcher is dropped and the next watch listens
+// again.
+type pgChangeFeed struct {
+	db      *gorm.DB
+	changes *changeHub
+
+	mutex     sync.Mutex
+	listening *sql.Conn
+	stop      context.CancelFunc
+}
+
+func newPGChangeFeed(db *gorm.DB) *pgChangeFeed {
+	return &pgChangeFeed{db: db, changes: newChangeHub()}
+}
+
+// watch subscribes once the feed is listening, so that no change committed after it returns
+// is missed
+func (f *pgChangeFeed) watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.listening == nil {
+		if err := f.listen(); err != nil {
+			return nil, err
+		}
+	}
+	return f.changes.subscribe(ctx)
+}
+
+// listen takes a connection out of the pool for LISTEN. Callers hold the mutex.
+func (f *pgChangeFeed) listen() error {
+	sqlDB, err := f.db.DB()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		stop()
+		return fmt.Errorf("failed to get a connection for change notifications: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "LISTEN "+changeChannel); err != nil {
+		conn.Close()
+		stop()
+		return fmt.Errorf("failed to listen for change notifications: %w", err)
+	}
+
+	f.listening = conn
+	f.stop = stop
+	go f.relay(ctx, conn)
+	return nil
+}
+
+// relay publishes notifications until the connection fails or the feed is closed
+func (f *pgChangeFeed) relay(ctx context.Context, conn *sql.Conn) {
+	var failure error
+	conn.Raw(func(driverConn any) error {
+		pgConn := driverConn.(*stdlib.Conn).Conn()
+		for {
+			notification, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				failure = err
+				// A listening connection must not go back to the pool
+				return driver.ErrBadConn
+			}
+
+			var event ChangeEvent
+			if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+				klog.Warningf("Ignoring malformed change notification %q: %v", notification.Payload, err)
+				continue
+			}
+			f.changes.publish(event)
+		}
+	})
+	conn.Close()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.listening != conn {
+		return
+	}
+	f.listening = nil
+	f.stop()
+	if ctx.Err() == nil {
+		klog.Errorf("Lost the change notification connection: %v", failure)
+		f.changes.reset()
+	}
+}
+
+// close stops listening and drops every watcher
+func (f *pgChangeFeed) close() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.listening != nil {
+		f.listening = nil
+		f.stop()
+	}
+	f.changes.close()
+}
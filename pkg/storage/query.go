@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultListLimit is the page size used when ListOptions.Limit is zero
+	DefaultListLimit = 100
+	// MaxListLimit is the largest page size a single query may request
+	MaxListLimit = 1000
+
+	// cursorPrefix marks the encoded offset inside an opaque cursor
+	cursorPrefix = "o:"
+
+	// OrgStatusEnabled and OrgStatusDisabled are the status filter values for organizations
+	OrgStatusEnabled  = "enabled"
+	OrgStatusDisabled = "disabled"
+)
+
+// ListOptions holds filtering, sorting and pagination parameters for the Query* operations.
+// Filters that do not apply to an entity are ignored.
+type ListOptions struct {
+	Limit   int    // Maximum number of items to return (0 means DefaultListLimit)
+	Cursor  string // Opaque cursor returned as nextCursor by a previous query
+	Sort    string // Sort field (id, name, status, created_at, updated_at), "-" prefix for descending
+	OrgID   string // Filter by organization
+	OwnerID string // Filter VMs by owner
+	VDCID   string // Filter VMs by VDC
+	Status  string // Filter VMs by status, VDCs by phase and organizations by OrgStatusEnabled/OrgStatusDisabled
+	Role    string // Filter users by role
+	Name    string // Case-insensitive substring match on the name (username for users)
+
+	ActorID      string    // Filter audit records by the user who made the call
+	ResourceType string    // Filter audit records by the kind of resource, such as "vm"
+	ResourceID   string    // Filter audit records by the resource
+	Since        time.Time // Filter audit records created at or after this time, if not zero
+	Until        time.Time // Filter audit records created before this time, if not zero
+}
+
+// sortColumns maps the public sort fields of an entity to its database columns
+type sortColumns map[string]string
+
+var (
+	userSortColumns = sortColumns{
+		"id": "id", "name": "username", "created_at": "created_at", "updated_at": "updated_at",
+	}
+	organizationSortColumns = sortColumns{
+		"id": "id", "name": "name", "created_at": "created_at", "updated_at": "updated_at",
+	}
+	vdcSortColumns = sortColumns{
+		"id": "id", "name": "name", "status": "phase", "created_at": "created_at", "updated_at": "updated_at",
+	}
+	templateSortColumns = sortColumns{
+		"id": "id", "name": "name", "created_at": "created_at", "updated_at": "updated_at",
+	}
+	vmSortColumns = sortColumns{
+		"id": "id", "name": "name", "status": "status", "created_at": "created_at", "updated_at": "updated_at",
+	}
+	auditSortColumns = sortColumns{
+		"id": "id", "created_at": "created_at",
+	}
+)
+
+// listPage is the resolved form of ListOptions used by storage implementations
+type listPage struct {
+	offset int
+	limit  int
+	field  string
+	column string
+	desc   bool
+}
+
+// resolve validates the options against the sortable columns of an entity
+func (o ListOptions) resolve(columns sortColumns) (listPage, error) {
+	page := listPage{limit: o.Limit, field: "created_at"}
+
+	if page.limit < 0 {
+		return page, fmt.Errorf("%w: limit must not be negative", ErrInvalidInput)
+	}
+	if page.limit == 0 {
+		page.limit = DefaultListLimit
+	}
+	if page.limit > MaxListLimit {
+		page.limit = MaxListLimit
+	}
+
+	offset, err := decodeCursor(o.Cursor)
+	if err != nil {
+		return page, err
+	}
+	page.offset = offset
+
+	if sort := strings.TrimSpace(o.Sort); sort != "" {
+		if strings.HasPrefix(sort, "-") {
+			page.desc = true
+			sort = sort[1:]
+		}
+		page.field = sort
+	}
+
+	column, ok := columns[page.field]
+	if !ok {
+		return page, fmt.Errorf("%w: unsupported sort field %q", ErrInvalidInput, page.field)
+	}
+	page.column = column
+	return page, nil
+}
+
+// orderClause returns the SQL ORDER BY clause, using the ID as a tie-breaker for stable pages
+func (p listPage) orderClause() string {
+	direction := "ASC"
+	if p.desc {
+		direction = "DESC"
+	}
+	if p.column == "id" {
+		return "id " + direction
+	}
+	return fmt.Sprintf("%s %s, id %s", p.column, direction, direction)
+}
+
+// window returns the slice bounds of the page within total items and the cursor of the next page
+func (p listPage) window(total int) (start, end int, nextCursor string) {
+	start = p.offset
+	if start > total {
+		start = total
+	}
+	end = start + p.limit
+	if end < total {
+		nextCursor = encodeCursor(end)
+	} else {
+		end = total
+	}
+	return start, end, nextCursor
+}
+
+// trim returns how many of the n fetched rows belong to the page and the cursor of the next page.
+// SQL backends fetch limit+1 rows so that the presence of a further page can be detected.
+func (p listPage) trim(n int) (int, string) {
+	if n > p.limit {
+		return p.limit, encodeCursor(p.offset + p.limit)
+	}
+	return n, ""
+}
+
+// less compares two sort keys, falling back to the IDs so that ordering is total
+func (p listPage) less(keyA, idA, keyB, idB string) bool {
+	if keyA == keyB {
+		if p.desc {
+			return idA > idB
+		}
+		return idA < idB
+	}
+	if p.desc {
+		return keyA > keyB
+	}
+	return keyA < keyB
+}
+
+// orgEnabledFilter translates the status filter of an organization query into an is_enabled value.
+// The second return value is false when no status filter was requested.
+func orgEnabledFilter(status string) (bool, bool, error) {
+	switch status {
+	case "":
+		return false, false, nil
+	case OrgStatusEnabled:
+		return true, true, nil
+	case OrgStatusDisabled:
+		return false, true, nil
+	default:
+		return false, false, fmt.Errorf("%w: unsupported organization status %q", ErrInvalidInput, status)
+	}
+}
+
+// timeSortKey formats a timestamp so that lexical order matches chronological order
+func timeSortKey(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000")
+}
+
+// matchesName reports whether value contains the name filter, ignoring case
+func matchesName(value, filter string) bool {
+	return filter == "" || strings.Contains(strings.ToLower(value), strings.ToLower(filter))
+}
+
+// likePattern builds a case-insensitive SQL LIKE pattern for a substring match
+func likePattern(filter string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(filter))
+	return "%" + escaped + "%"
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(raw), cursorPrefix) {
+		return 0, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+	}
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(raw), cursorPrefix))
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+	}
+	return offset, nil
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"k8s.io/klog/v2"
+
+	"github.com/eliorerz/ovim-updated/pkg/storage/migrations"
+)
+
+// SQLiteURLScheme prefixes database URLs that select the embedded SQLite backend,
+// e.g. sqlite:///var/lib/ovim/ovim.db or sqlite://ovim.db for a relative path
+const SQLiteURLScheme = "sqlite://"
+
+// SQLiteStorage implements the Storage interface using an embedded SQLite database.
+// The GORM queries of PostgresStorage are portable, only the connection and the
+// migration scripts differ.
+type SQLiteStorage struct {
+	PostgresStorage
+	changeLog *sqliteChangeFeed
+}
+
+// SQLitePath returns the database file of a sqlite:// URL. It reports false for
+// any other URL.
+func SQLitePath(databaseURL string) (string, bool) {
+	if !strings.HasPrefix(databaseURL, SQLiteURLScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(databaseURL, SQLiteURLScheme), true
+}
+
+// NewSQLiteStorage creates a new SQLite storage instance, creating the database file if needed
+func NewSQLiteStorage(path string) (Storage, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := &SQLiteStorage{PostgresStorage: PostgresStorage{db: db}, changeLog: newSQLiteChangeFeed(db)}
+
+	if err := storage.migrate(); err != nil {
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	if err := storage.seedData(); err != nil {
+		return nil, fmt.Errorf("failed to seed data: %w", err)
+	}
+
+	klog.Infof("Initialized SQLite storage at %s with migrations and seed data", path)
+	return storage, nil
+}
+
+// NewSQLiteMigrator opens the database without applying migrations.
+// The caller is responsible for closing the returned migrator.
+func NewSQLiteMigrator(path string) (*migrations.Migrator, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		if sqlDB, dbErr := db.DB(); dbErr == nil {
+			sqlDB.Close()
+		}
+		return nil, err
+	}
+	return migrator, nil
+}
+
+// NewSQLiteStorageForTest creates a migrated, empty SQLite storage instance for testing
+func NewSQLiteStorageForTest(path string) (Storage, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := &SQLiteStorage{PostgresStorage: PostgresStorage{db: db}, changeLog: newSQLiteChangeFeed(db)}
+	if err := storage.migrate(); err != nil {
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+	return storage, nil
+}
+
+// openSQLite opens a GORM connection to a SQLite database file. Foreign keys are enforced,
+// the WAL journal lets readers run alongside the single writer, and transactions take the
+// write lock up front so that concurrent writers wait for each other instead of failing
+// when upgrading from a read lock.
+func openSQLite(path string) (*gorm.DB, error) {
+	if path == "" {
+		return nil, fmt.Errorf("SQLite database path is empty")
+	}
+
+	gormLogger := logger.New(
+		&klogWriter{},
+		logger.Config{
+			SlowThreshold:             time.Second,
+			LogLevel:                  logger.Info,
+			IgnoreRecordNotFoundError: true,
+			Colorful:                  false,
+		},
+	)
+
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	dsn := path + separator + "_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate"
+
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: gormLogger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get underlying sql.DB: %w", err)
+	}
+
+	// Every connection to :memory: gets its own empty database
+	if path == ":memory:" {
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	return db, nil
+}
+
+// WithTx runs fn in a database transaction, committing it only if fn succeeds.
+// Nested calls use savepoints. The transactional storage passed to fn must not be closed.
+func (s *SQLiteStorage) WithTx(fn func(tx Storage) error) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&SQLiteStorage{PostgresStorage: PostgresStorage{db: tx}, changeLog: s.changeLog})
+	})
+}
+
+// Watch relays the change log, which the writes of every process using the database fill
+func (s *SQLiteStorage) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	return s.changeLog.watch(ctx)
+}
+
+func (s *SQLiteStorage) Close() error {
+	s.changeLog.close()
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	klog.Info("Closing SQLite storage connection")
+	return sqlDB.Close()
+}
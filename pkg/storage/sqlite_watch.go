@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"k8s.io/klog/v2"
+)
+
+// changePollInterval is how often the SQLite change log is read once anyone has watched
+const changePollInterval = 250 * time.Millisecond
+
+// changeLogEntry is a row of the change log filled by the triggers of migration 005
+type changeLogEntry struct {
+	ID              int64
+	ChangeType      string
+	Kind            string
+	RecordID        string
+	OrgID           *string
+	OwnerID         *string
+	ResourceVersion *int64
+}
+
+// sqliteChangeFeed relays the change log to the watchers of a storage. The triggers write the
+// log in the transaction of the change, so the feed sees changes of every process once they
+// commit, in commit order.
+type sqliteChangeFeed struct {
+	db      *gorm.DB
+	changes *changeHub
+
+	mutex sync.Mutex
+	stop  context.CancelFunc
+}
+
+func newSQLiteChangeFeed(db *gorm.DB) *sqliteChangeFeed {
+	return &sqliteChangeFeed{db: db, changes: newChangeHub()}
+}
+
+// watch subscribes once the feed knows where the log ends, so that no change committed after it
+// returns is missed
+func (f *sqliteChangeFeed) watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.stop == nil {
+		var last int64
+		if err := f.db.Table("change_log").Select("COALESCE(MAX(id), 0)").Scan(&last).Error; err != nil {
+			return nil, err
+		}
+		pollCtx, stop := context.WithCancel(context.Background())
+		f.stop = stop
+		go f.poll(pollCtx, last)
+	}
+	return f.changes.subscribe(ctx)
+}
+
+// poll publishes the entries logged after last until the feed is closed
+func (f *sqliteChangeFeed) poll(ctx context.Context, last int64) {
+	ticker := time.NewTicker(changePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var entries []changeLogEntry
+		err := f.db.WithContext(ctx).Table("change_log").Where("id > ?", last).Order("id").Limit(1000).Find(&entries).Error
+		if err != nil {
+			if ctx.Err() == nil {
+				klog.Errorf("Failed to read the change log: %v", err)
+			}
+			continue
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		// Entries are numbered without gaps, a gap means the log was pruned past unread entries
+		if entries[0].ID != last+1 {
+			klog.Warningf("Change log entries %d to %d were pruned before they were read", last+1, entries[0].ID-1)
+			f.changes.reset()
+		}
+		events := make([]ChangeEvent, 0, len(entries))
+		for _, entry := range entries {
+			event := ChangeEvent{Type: ChangeType(entry.ChangeType), Kind: entry.Kind, ID: entry.RecordID}
+			if entry.OrgID != nil {
+				event.OrgID = *entry.OrgID
+			}
+			if entry.OwnerID != nil {
+				event.OwnerID = *entry.OwnerID
+			}
+			if entry.ResourceVersion != nil {
+				event.ResourceVersion = *entry.ResourceVersion
+			}
+			events = append(events, event)
+		}
+		f.changes.publish(events...)
+		last = entries[len(entries)-1].ID
+	}
+}
+
+// close stops polling and drops every watcher
+func (f *sqliteChangeFeed) close() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.stop != nil {
+		f.stop()
+		f.stop = nil
+	}
+	f.changes.close()
+}
@@ -0,0 +1,127 @@
+package storagetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// newAuditRecord returns a record of a successful update of a VM of an organization by an actor
+func newAuditRecord(id, actorID, orgID, resourceID string, createdAt time.Time) *models.AuditRecord {
+	return &models.AuditRecord{
+		ID:            id,
+		ActorID:       actorID,
+		ActorUsername: "user " + actorID,
+		ActorRole:     models.RoleOrgAdmin,
+		OrgID:         orgID,
+		Method:        "PUT",
+		Route:         "/api/v1/vms/:id/power",
+		Path:          "/api/v1/vms/" + resourceID + "/power",
+		ResourceType:  "vm",
+		ResourceID:    resourceID,
+		Request:       models.JSONBMap{"action": "start"},
+		Changes:       models.JSONBMap{"status": map[string]interface{}{"old": "stopped", "new": "running"}},
+		StatusCode:    200,
+		Outcome:       models.AuditOutcomeSuccess,
+		ClientIP:      "192.0.2.10",
+		CreatedAt:     createdAt,
+	}
+}
+
+func auditRecordIDs(records []*models.AuditRecord) []string {
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.ID
+	}
+	return ids
+}
+
+func testAuditRecords(t *testing.T, s storage.Storage) {
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, s.CreateAuditRecord(newAuditRecord("audit-1", "user-1", "org-1", "vm-1", start)))
+	require.NoError(t, s.CreateAuditRecord(newAuditRecord("audit-2", "user-2", "org-1", "vm-2", start.Add(time.Minute))))
+	require.NoError(t, s.CreateAuditRecord(newAuditRecord("audit-3", "user-1", "org-2", "vm-3", start.Add(2*time.Minute))))
+
+	// Records need no user nor organization, and get a creation time if they have none
+	failed := newAuditRecord("audit-4", "", "", "", time.Time{})
+	failed.Method, failed.Route, failed.Path = "POST", "/api/v1/auth/login", "/api/v1/auth/login"
+	failed.ResourceType, failed.Request, failed.Changes = "", nil, nil
+	failed.StatusCode, failed.Outcome, failed.Error = 401, models.AuditOutcomeDenied, "Invalid credentials"
+	require.NoError(t, s.CreateAuditRecord(failed))
+	assert.False(t, failed.CreatedAt.IsZero())
+
+	records, next, _, err := s.QueryAuditRecords(storage.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, next)
+	assert.Equal(t, []string{"audit-1", "audit-2", "audit-3", "audit-4"}, auditRecordIDs(records))
+
+	got := records[0]
+	assert.Equal(t, "user user-1", got.ActorUsername)
+	assert.Equal(t, models.RoleOrgAdmin, got.ActorRole)
+	assert.Equal(t, "/api/v1/vms/:id/power", got.Route)
+	assert.Equal(t, "/api/v1/vms/vm-1/power", got.Path)
+	assert.Equal(t, models.JSONBMap{"action": "start"}, got.Request)
+	assert.Equal(t, models.JSONBMap{"status": map[string]interface{}{"old": "stopped", "new": "running"}}, got.Changes)
+	assert.Equal(t, 200, got.StatusCode)
+	assert.Equal(t, "192.0.2.10", got.ClientIP)
+	assert.True(t, start.Equal(got.CreatedAt), "creation time is kept")
+	assert.Nil(t, records[3].Request)
+	assert.Equal(t, "Invalid credentials", records[3].Error)
+
+	// Returned records are copies
+	got.Request["action"] = "stop"
+	records, _, _, err = s.QueryAuditRecords(storage.ListOptions{ResourceID: "vm-1"})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "start", records[0].Request["action"])
+
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreateAuditRecord(newAuditRecord("audit-1", "user-1", "org-1", "vm-1", start)))
+	assertSentinel(t, storage.ErrInvalidInput, s.CreateAuditRecord(nil))
+	assertSentinel(t, storage.ErrInvalidInput, s.CreateAuditRecord(newAuditRecord("", "user-1", "org-1", "vm-1", start)))
+
+	t.Run("Filters", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			opts     storage.ListOptions
+			expected []string
+		}{
+			{"organization", storage.ListOptions{OrgID: "org-1"}, []string{"audit-1", "audit-2"}},
+			{"actor", storage.ListOptions{ActorID: "user-1"}, []string{"audit-1", "audit-3"}},
+			{"resource type", storage.ListOptions{ResourceType: "vm"}, []string{"audit-1", "audit-2", "audit-3"}},
+			{"resource", storage.ListOptions{ResourceID: "vm-2"}, []string{"audit-2"}},
+			{"since is inclusive", storage.ListOptions{Since: start.Add(time.Minute)}, []string{"audit-2", "audit-3", "audit-4"}},
+			{"until is exclusive", storage.ListOptions{Until: start.Add(2 * time.Minute)}, []string{"audit-1", "audit-2"}},
+			{"time range", storage.ListOptions{Since: start.Add(30 * time.Second), Until: start.Add(90 * time.Second)}, []string{"audit-2"}},
+			{"combined", storage.ListOptions{OrgID: "org-1", ActorID: "user-1"}, []string{"audit-1"}},
+			{"no match", storage.ListOptions{ActorID: "user-9"}, []string{}},
+			{"newest first", storage.ListOptions{Sort: "-created_at", ActorID: "user-1"}, []string{"audit-3", "audit-1"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				records, _, _, err := s.QueryAuditRecords(tt.opts)
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, auditRecordIDs(records))
+			})
+		}
+
+		_, _, _, err := s.QueryAuditRecords(storage.ListOptions{Sort: "name"})
+		assert.ErrorIs(t, err, storage.ErrInvalidInput)
+	})
+
+	t.Run("Pages", func(t *testing.T) {
+		records, next, _, err := s.QueryAuditRecords(storage.ListOptions{Limit: 3, Sort: "-created_at"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"audit-4", "audit-3", "audit-2"}, auditRecordIDs(records))
+		require.NotEmpty(t, next)
+
+		records, next, _, err = s.QueryAuditRecords(storage.ListOptions{Limit: 3, Sort: "-created_at", Cursor: next})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"audit-1"}, auditRecordIDs(records))
+		assert.Empty(t, next)
+	})
+}
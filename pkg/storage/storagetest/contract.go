@@ -0,0 +1,372 @@
+package storagetest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+func testInvalidInput(t *testing.T, s storage.Storage) {
+	checks := map[string]error{
+		"CreateUser(nil)":                      s.CreateUser(nil),
+		"CreateUser(no ID)":                    s.CreateUser(&models.User{Username: "nobody"}),
+		"UpdateUser(nil)":                      s.UpdateUser(nil),
+		"UpdateUser(no ID)":                    s.UpdateUser(&models.User{Username: "nobody"}),
+		"CreateOrganization(nil)":              s.CreateOrganization(nil),
+		"CreateOrganization(no ID)":            s.CreateOrganization(&models.Organization{Name: "nameless"}),
+		"UpdateOrganization(nil)":              s.UpdateOrganization(nil),
+		"CreateVDC(nil)":                       s.CreateVDC(nil),
+		"CreateVDC(no ID)":                     s.CreateVDC(&models.VirtualDataCenter{Name: "nameless"}),
+		"UpdateVDC(nil)":                       s.UpdateVDC(nil),
+		"CreateCatalog(nil)":                   s.CreateCatalog(nil),
+		"CreateCatalog(no ID)":                 s.CreateCatalog(&models.Catalog{Name: "nameless"}),
+		"UpdateCatalog(nil)":                   s.UpdateCatalog(nil),
+		"CreateTemplate(nil)":                  s.CreateTemplate(nil),
+		"CreateTemplate(no ID)":                s.CreateTemplate(&models.Template{Name: "nameless"}),
+		"UpdateTemplate(nil)":                  s.UpdateTemplate(nil),
+		"CreateVM(nil)":                        s.CreateVM(nil),
+		"CreateVM(no ID)":                      s.CreateVM(&models.VirtualMachine{Name: "nameless"}),
+		"UpdateVM(nil)":                        s.UpdateVM(nil),
+		"CreateOrganizationCatalogSource(nil)": s.CreateOrganizationCatalogSource(nil),
+		"CreateOrganizationCatalogSource(no ID)": s.CreateOrganizationCatalogSource(
+			&models.OrganizationCatalogSource{OrgID: "org-1"}),
+		"UpdateOrganizationCatalogSource(nil)": s.UpdateOrganizationCatalogSource(nil),
+	}
+	for call, err := range checks {
+		assertSentinel(t, storage.ErrInvalidInput, err, call)
+	}
+
+	users, err := s.ListUsers()
+	require.NoError(t, err)
+	assert.Empty(t, users, "invalid input must not be stored")
+}
+
+func testNotFound(t *testing.T, s storage.Storage) {
+	const missing = "missing"
+
+	_, err := s.GetUserByID(missing)
+	assertSentinel(t, storage.ErrNotFound, err, "GetUserByID")
+	_, err = s.GetUserByUsername(missing)
+	assertSentinel(t, storage.ErrNotFound, err, "GetUserByUsername")
+	_, err = s.GetOrganization(missing)
+	assertSentinel(t, storage.ErrNotFound, err, "GetOrganization")
+	_, err = s.GetVDC(missing)
+	assertSentinel(t, storage.ErrNotFound, err, "GetVDC")
+	_, err = s.GetCatalog(missing)
+	assertSentinel(t, storage.ErrNotFound, err, "GetCatalog")
+	_, err = s.GetTemplate(missing)
+	assertSentinel(t, storage.ErrNotFound, err, "GetTemplate")
+	_, err = s.GetVM(missing)
+	assertSentinel(t, storage.ErrNotFound, err, "GetVM")
+	_, err = s.GetOrganizationCatalogSource(missing)
+	assertSentinel(t, storage.ErrNotFound, err, "GetOrganizationCatalogSource")
+
+	assertSentinel(t, storage.ErrNotFound, s.UpdateUser(newUser(missing)), "UpdateUser")
+	assertSentinel(t, storage.ErrNotFound, s.UpdateOrganization(newOrganization(missing)), "UpdateOrganization")
+	assertSentinel(t, storage.ErrNotFound, s.UpdateVDC(newVDC(missing, "org-1")), "UpdateVDC")
+	assertSentinel(t, storage.ErrNotFound, s.UpdateCatalog(newCatalog(missing, "org-1")), "UpdateCatalog")
+	assertSentinel(t, storage.ErrNotFound, s.UpdateTemplate(newTemplate(missing, "org-1")), "UpdateTemplate")
+	assertSentinel(t, storage.ErrNotFound, s.UpdateVM(newVM(missing, "org-1")), "UpdateVM")
+	assertSentinel(t, storage.ErrNotFound, s.UpdateOrganizationCatalogSource(newCatalogSource(missing, "org-1")), "UpdateOrganizationCatalogSource")
+
+	assertSentinel(t, storage.ErrNotFound, s.DeleteUser(missing), "DeleteUser")
+	assertSentinel(t, storage.ErrNotFound, s.DeleteOrganization(missing), "DeleteOrganization")
+	assertSentinel(t, storage.ErrNotFound, s.DeleteVDC(missing), "DeleteVDC")
+	assertSentinel(t, storage.ErrNotFound, s.DeleteCatalog(missing), "DeleteCatalog")
+	assertSentinel(t, storage.ErrNotFound, s.DeleteTemplate(missing), "DeleteTemplate")
+	assertSentinel(t, storage.ErrNotFound, s.DeleteVM(missing), "DeleteVM")
+	assertSentinel(t, storage.ErrNotFound, s.DeleteOrganizationCatalogSource(missing), "DeleteOrganizationCatalogSource")
+
+	assertSentinel(t, storage.ErrNotFound, s.RestoreOrganization(missing), "RestoreOrganization")
+	assertSentinel(t, storage.ErrNotFound, s.RestoreVDC(missing), "RestoreVDC")
+	assertSentinel(t, storage.ErrNotFound, s.RestoreVM(missing), "RestoreVM")
+	assertSentinel(t, storage.ErrNotFound, s.PurgeOrganization(missing), "PurgeOrganization")
+	assertSentinel(t, storage.ErrNotFound, s.PurgeVDC(missing), "PurgeVDC")
+	assertSentinel(t, storage.ErrNotFound, s.PurgeVM(missing), "PurgeVM")
+
+	// Updating an unknown record must not create it
+	_, err = s.GetOrganizationCatalogSource(missing)
+	assertSentinel(t, storage.ErrNotFound, err)
+}
+
+func testEmptyLists(t *testing.T, s storage.Storage) {
+	users, err := s.ListUsers()
+	require.NoError(t, err)
+	assert.NotNil(t, users, "ListUsers")
+	assert.Empty(t, users)
+	byOrg, err := s.ListUsersByOrg("org-1")
+	require.NoError(t, err)
+	assert.NotNil(t, byOrg, "ListUsersByOrg")
+	orgs, err := s.ListOrganizations()
+	require.NoError(t, err)
+	assert.NotNil(t, orgs, "ListOrganizations")
+	vdcs, err := s.ListVDCs("")
+	require.NoError(t, err)
+	assert.NotNil(t, vdcs, "ListVDCs")
+	catalogs, err := s.ListCatalogs("")
+	require.NoError(t, err)
+	assert.NotNil(t, catalogs, "ListCatalogs")
+	templates, err := s.ListTemplates()
+	require.NoError(t, err)
+	assert.NotNil(t, templates, "ListTemplates")
+	orgTemplates, err := s.ListTemplatesByOrg("org-1")
+	require.NoError(t, err)
+	assert.NotNil(t, orgTemplates, "ListTemplatesByOrg")
+	vms, err := s.ListVMs("")
+	require.NoError(t, err)
+	assert.NotNil(t, vms, "ListVMs")
+	sources, err := s.ListOrganizationCatalogSources("org-1")
+	require.NoError(t, err)
+	assert.NotNil(t, sources, "ListOrganizationCatalogSources")
+
+	deletedOrgs, err := s.ListDeletedOrganizations()
+	require.NoError(t, err)
+	assert.NotNil(t, deletedOrgs, "ListDeletedOrganizations")
+	deletedVDCs, err := s.ListDeletedVDCs()
+	require.NoError(t, err)
+	assert.NotNil(t, deletedVDCs, "ListDeletedVDCs")
+	deletedVMs, err := s.ListDeletedVMs()
+	require.NoError(t, err)
+	assert.NotNil(t, deletedVMs, "ListDeletedVMs")
+
+	queriedUsers, next, _, err := s.QueryUsers(storage.ListOptions{})
+	require.NoError(t, err)
+	assert.NotNil(t, queriedUsers, "QueryUsers")
+	assert.Empty(t, next)
+	queriedOrgs, _, _, err := s.QueryOrganizations(storage.ListOptions{})
+	require.NoError(t, err)
+	assert.NotNil(t, queriedOrgs, "QueryOrganizations")
+	queriedVDCs, _, _, err := s.QueryVDCs(storage.ListOptions{})
+	require.NoError(t, err)
+	assert.NotNil(t, queriedVDCs, "QueryVDCs")
+	queriedTemplates, _, _, err := s.QueryTemplates(storage.ListOptions{})
+	require.NoError(t, err)
+	assert.NotNil(t, queriedTemplates, "QueryTemplates")
+	queriedVMs, _, _, err := s.QueryVMs(storage.ListOptions{})
+	require.NoError(t, err)
+	assert.NotNil(t, queriedVMs, "QueryVMs")
+}
+
+// testOrdering creates records with IDs in reverse order, so only ordering by creation time
+// returns them in the expected order
+func testOrdering(t *testing.T, s storage.Storage) {
+	ids := []string{"c", "b", "a"}
+	for _, id := range ids {
+		user := newUser(id)
+		user.OrgID = stringPtr("org-a")
+		require.NoError(t, s.CreateUser(user))
+		require.NoError(t, s.CreateOrganization(newOrganization(id)))
+		require.NoError(t, s.CreateTemplate(newTemplate(id, "org-a")))
+		require.NoError(t, s.CreateVM(newVM(id, "org-a")))
+		require.NoError(t, s.CreateOrganizationCatalogSource(newCatalogSource(id, "org-a")))
+		pause()
+	}
+	for _, id := range ids {
+		require.NoError(t, s.CreateVDC(newVDC("vdc-"+id, "a")))
+		require.NoError(t, s.CreateCatalog(newCatalog("catalog-"+id, "a")))
+		pause()
+	}
+
+	users, err := s.ListUsers()
+	require.NoError(t, err)
+	assert.Equal(t, ids, userIDs(users), "ListUsers")
+	users, err = s.ListUsersByOrg("org-a")
+	require.NoError(t, err)
+	assert.Equal(t, ids, userIDs(users), "ListUsersByOrg")
+
+	orgs, err := s.ListOrganizations()
+	require.NoError(t, err)
+	orgIDs := make([]string, len(orgs))
+	for i, org := range orgs {
+		orgIDs[i] = org.ID
+	}
+	assert.Equal(t, ids, orgIDs, "ListOrganizations")
+
+	vdcs, err := s.ListVDCs("a")
+	require.NoError(t, err)
+	vdcIDs := make([]string, len(vdcs))
+	for i, vdc := range vdcs {
+		vdcIDs[i] = vdc.ID
+	}
+	assert.Equal(t, []string{"vdc-c", "vdc-b", "vdc-a"}, vdcIDs, "ListVDCs")
+
+	catalogs, err := s.ListCatalogs("a")
+	require.NoError(t, err)
+	catalogIDs := make([]string, len(catalogs))
+	for i, catalog := range catalogs {
+		catalogIDs[i] = catalog.ID
+	}
+	assert.Equal(t, []string{"catalog-c", "catalog-b", "catalog-a"}, catalogIDs, "ListCatalogs")
+
+	for name, list := range map[string]func() ([]*models.Template, error){
+		"ListTemplates":      s.ListTemplates,
+		"ListTemplatesByOrg": func() ([]*models.Template, error) { return s.ListTemplatesByOrg("org-a") },
+	} {
+		templates, err := list()
+		require.NoError(t, err)
+		templateIDs := make([]string, len(templates))
+		for i, template := range templates {
+			templateIDs[i] = template.ID
+		}
+		assert.Equal(t, ids, templateIDs, name)
+	}
+
+	vms, err := s.ListVMs("org-a")
+	require.NoError(t, err)
+	vmIDs := make([]string, len(vms))
+	for i, vm := range vms {
+		vmIDs[i] = vm.ID
+	}
+	assert.Equal(t, ids, vmIDs, "ListVMs")
+
+	sources, err := s.ListOrganizationCatalogSources("org-a")
+	require.NoError(t, err)
+	sourceIDs := make([]string, len(sources))
+	for i, source := range sources {
+		sourceIDs[i] = source.ID
+	}
+	assert.Equal(t, ids, sourceIDs, "ListOrganizationCatalogSources")
+}
+
+func testQuery(t *testing.T, s storage.Storage) {
+	names := []string{"web-1", "web-2", "db-1", "db-2", "cache"}
+	for i, name := range names {
+		vm := newVM(name, "org-1")
+		vm.Name = name
+		if i%2 == 0 {
+			vm.Status = models.VMStatusRunning
+		}
+		require.NoError(t, s.CreateVM(vm))
+		pause()
+	}
+
+	t.Run("Pages", func(t *testing.T) {
+		var seen []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, len(names), "pagination does not terminate")
+			vms, next, total, err := s.QueryVMs(storage.ListOptions{Limit: 2, Cursor: cursor})
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(vms), 2)
+			assert.Equal(t, len(names), total, "the total counts every page")
+			for _, vm := range vms {
+				seen = append(seen, vm.ID)
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		assert.Equal(t, names, seen, "default order is oldest first")
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		vms, _, _, err := s.QueryVMs(storage.ListOptions{Status: models.VMStatusRunning})
+		require.NoError(t, err)
+		assert.Len(t, vms, 3)
+
+		vms, _, _, err = s.QueryVMs(storage.ListOptions{Name: "WEB"})
+		require.NoError(t, err)
+		assert.Len(t, vms, 2, "name filter ignores case")
+
+		vms, _, total, err := s.QueryVMs(storage.ListOptions{Status: models.VMStatusRunning, Limit: 1})
+		require.NoError(t, err)
+		assert.Len(t, vms, 1)
+		assert.Equal(t, 3, total, "the total counts filtered results")
+
+		vms, _, _, err = s.QueryVMs(storage.ListOptions{Name: "%"})
+		require.NoError(t, err)
+		assert.Empty(t, vms, "name filter is not a pattern")
+
+		vms, _, _, err = s.QueryVMs(storage.ListOptions{OrgID: "org-2"})
+		require.NoError(t, err)
+		assert.Empty(t, vms)
+	})
+
+	t.Run("Sort", func(t *testing.T) {
+		vms, _, _, err := s.QueryVMs(storage.ListOptions{Sort: "-name"})
+		require.NoError(t, err)
+		require.Len(t, vms, len(names))
+		assert.Equal(t, "web-2", vms[0].ID)
+		assert.Equal(t, "cache", vms[len(vms)-1].ID)
+	})
+
+	t.Run("InvalidOptions", func(t *testing.T) {
+		_, _, _, err := s.QueryVMs(storage.ListOptions{Sort: "password"})
+		assert.ErrorIs(t, err, storage.ErrInvalidInput)
+		_, _, _, err = s.QueryVMs(storage.ListOptions{Limit: -1})
+		assert.ErrorIs(t, err, storage.ErrInvalidInput)
+		_, _, _, err = s.QueryVMs(storage.ListOptions{Cursor: "not a cursor"})
+		assert.ErrorIs(t, err, storage.ErrInvalidInput)
+		_, _, _, err = s.QueryOrganizations(storage.ListOptions{Status: "sleeping"})
+		assert.ErrorIs(t, err, storage.ErrInvalidInput)
+	})
+}
+
+func testResourceVersions(t *testing.T, s storage.Storage) {
+	require.NoError(t, s.CreateOrganization(newOrganization("org-1")))
+
+	first, err := s.GetOrganization("org-1")
+	require.NoError(t, err)
+	second, err := s.GetOrganization("org-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), first.ResourceVersion)
+
+	first.Description = "first"
+	require.NoError(t, s.UpdateOrganization(first))
+	assert.Equal(t, int64(2), first.ResourceVersion)
+
+	second.Description = "second"
+	assertSentinel(t, storage.ErrConflict, s.UpdateOrganization(second))
+	assert.Equal(t, int64(1), second.ResourceVersion, "a failed update keeps the version")
+
+	// Version 0 skips the check
+	second.ResourceVersion = 0
+	second.RequireMFA = true
+	require.NoError(t, s.UpdateOrganization(second))
+	assert.Equal(t, int64(3), second.ResourceVersion)
+
+	stored, err := s.GetOrganization("org-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stored.ResourceVersion)
+	assert.Equal(t, "second", stored.Description)
+	assert.True(t, stored.RequireMFA)
+
+	user := newUser("user-1")
+	require.NoError(t, s.CreateUser(user))
+	user.ResourceVersion = 7
+	assertSentinel(t, storage.ErrConflict, s.UpdateUser(user))
+}
+
+func testCopies(t *testing.T, s storage.Storage) {
+	org := newOrganization("org-1")
+	require.NoError(t, s.CreateOrganization(org))
+	org.Name = "changed after create"
+
+	stored, err := s.GetOrganization("org-1")
+	require.NoError(t, err)
+	assert.Equal(t, "Organization org-1", stored.Name)
+
+	stored.Name = "changed after get"
+	orgs, err := s.ListOrganizations()
+	require.NoError(t, err)
+	require.Len(t, orgs, 1)
+	assert.Equal(t, "Organization org-1", orgs[0].Name)
+	assert.Equal(t, int64(1), orgs[0].ResourceVersion)
+}
+
+func userIDs(users []*models.User) []string {
+	ids := make([]string, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	return ids
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
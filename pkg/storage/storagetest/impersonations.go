@@ -0,0 +1,111 @@
+package storagetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// newImpersonation returns an impersonation of a user by user-3 whose token is valid for 15 minutes
+func newImpersonation(id, userID string) *models.Impersonation {
+	return &models.Impersonation{
+		ID:                   id,
+		UserID:               userID,
+		ImpersonatorID:       "user-3",
+		ImpersonatorUsername: "admin",
+		Reason:               "ticket 42",
+		TokenID:              "access-" + id,
+		ExpiresAt:            time.Now().Add(15 * time.Minute),
+	}
+}
+
+func testImpersonations(t *testing.T, s storage.Storage) {
+	require.NoError(t, s.CreateUser(newUser("user-1")))
+	require.NoError(t, s.CreateUser(newUser("user-2")))
+	require.NoError(t, s.CreateUser(newUser("user-3")))
+
+	impersonation := newImpersonation("imp-1", "user-1")
+	require.NoError(t, s.CreateImpersonation(impersonation))
+	assert.False(t, impersonation.CreatedAt.IsZero())
+	require.NoError(t, s.CreateImpersonation(newImpersonation("imp-2", "user-1")))
+	require.NoError(t, s.CreateImpersonation(newImpersonation("imp-3", "user-2")))
+
+	got, err := s.GetImpersonation("imp-1")
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", got.UserID)
+	assert.Equal(t, "admin", got.ImpersonatorUsername)
+	assert.Equal(t, "ticket 42", got.Reason)
+	assert.Equal(t, "access-imp-1", got.TokenID)
+	assert.WithinDuration(t, impersonation.ExpiresAt, got.ExpiresAt, time.Second)
+	assert.Nil(t, got.EndedAt)
+
+	listed, err := s.ListImpersonations("user-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"imp-1", "imp-2"}, impersonationIDs(listed))
+	listed, err = s.ListImpersonations("")
+	require.NoError(t, err)
+	assert.Len(t, listed, 3)
+
+	_, err = s.GetImpersonation("missing")
+	assertSentinel(t, storage.ErrNotFound, err)
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreateImpersonation(newImpersonation("imp-1", "user-1")))
+	assertSentinel(t, storage.ErrInvalidInput, s.CreateImpersonation(newImpersonation("imp-5", "missing")))
+	assertSentinel(t, storage.ErrInvalidInput, s.CreateImpersonation(nil))
+	assertSentinel(t, storage.ErrInvalidInput, s.CreateImpersonation(newImpersonation("", "user-1")))
+
+	t.Run("End", func(t *testing.T) {
+		endedAt := time.Now().Truncate(time.Second)
+		require.NoError(t, s.EndImpersonation("imp-1", endedAt))
+		assertSentinel(t, storage.ErrConflict, s.EndImpersonation("imp-1", endedAt))
+		assertSentinel(t, storage.ErrNotFound, s.EndImpersonation("missing", endedAt))
+
+		got, err := s.GetImpersonation("imp-1")
+		require.NoError(t, err)
+		require.NotNil(t, got.EndedAt)
+		assert.True(t, endedAt.Equal(*got.EndedAt))
+	})
+
+	t.Run("RevokeUserTokens", func(t *testing.T) {
+		// Revoking the tokens of a user ends its ongoing impersonations and revokes their tokens
+		require.NoError(t, s.RevokeUserTokens("user-1"))
+		assertRevoked(t, s, true, "access-imp-2")
+		assertRevoked(t, s, false, "access-imp-1", "access-imp-3")
+
+		got, err := s.GetImpersonation("imp-2")
+		require.NoError(t, err)
+		assert.NotNil(t, got.EndedAt)
+		got, err = s.GetImpersonation("imp-3")
+		require.NoError(t, err)
+		assert.Nil(t, got.EndedAt)
+	})
+
+	t.Run("RevokeImpersonatorTokens", func(t *testing.T) {
+		// Revoking the tokens of the impersonator ends its impersonations of every user
+		require.NoError(t, s.CreateImpersonation(newImpersonation("imp-4", "user-2")))
+		require.NoError(t, s.RevokeUserTokens("user-3"))
+		assertRevoked(t, s, true, "access-imp-3", "access-imp-4")
+
+		got, err := s.GetImpersonation("imp-3")
+		require.NoError(t, err)
+		assert.NotNil(t, got.EndedAt)
+	})
+
+	t.Run("DeleteUser", func(t *testing.T) {
+		require.NoError(t, s.DeleteUser("user-2"))
+		_, err := s.GetImpersonation("imp-3")
+		assertSentinel(t, storage.ErrNotFound, err)
+	})
+}
+
+func impersonationIDs(impersonations []*models.Impersonation) []string {
+	ids := make([]string, 0, len(impersonations))
+	for _, impersonation := range impersonations {
+		ids = append(ids, impersonation.ID)
+	}
+	return ids
+}
@@ -0,0 +1,132 @@
+package storagetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// newInvitation returns an invitation to join an organization as org_user, valid for three days
+func newInvitation(id, orgID string) *models.Invitation {
+	return &models.Invitation{
+		ID:        id,
+		OrgID:     orgID,
+		Email:     id + "@example.com",
+		Role:      models.RoleOrgUser,
+		InvitedBy: "admin",
+		TokenID:   "token-" + id,
+		ExpiresAt: time.Now().Add(72 * time.Hour),
+	}
+}
+
+func testInvitations(t *testing.T, s storage.Storage) {
+	require.NoError(t, s.CreateOrganization(newOrganization("org-1")))
+	require.NoError(t, s.CreateOrganization(newOrganization("org-2")))
+	require.NoError(t, s.CreateUser(newUser("user-1")))
+
+	invitation := newInvitation("invite-1", "org-1")
+	require.NoError(t, s.CreateInvitation(invitation))
+	assert.False(t, invitation.CreatedAt.IsZero())
+	pause()
+	require.NoError(t, s.CreateInvitation(newInvitation("invite-2", "org-1")))
+	pause()
+	require.NoError(t, s.CreateInvitation(newInvitation("invite-3", "org-2")))
+
+	got, err := s.GetInvitation("invite-1")
+	require.NoError(t, err)
+	assert.Equal(t, "org-1", got.OrgID)
+	assert.Equal(t, "invite-1@example.com", got.Email)
+	assert.Equal(t, models.RoleOrgUser, got.Role)
+	assert.Equal(t, "admin", got.InvitedBy)
+	assert.Equal(t, "token-invite-1", got.TokenID)
+	assert.WithinDuration(t, invitation.ExpiresAt, got.ExpiresAt, time.Second)
+	assert.Nil(t, got.AcceptedAt)
+	assert.Nil(t, got.UserID)
+	assert.Nil(t, got.RevokedAt)
+
+	listed, err := s.ListInvitations("org-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"invite-1", "invite-2"}, invitationIDs(listed))
+	listed, err = s.ListInvitations("")
+	require.NoError(t, err)
+	assert.Len(t, listed, 3)
+
+	_, err = s.GetInvitation("missing")
+	assertSentinel(t, storage.ErrNotFound, err)
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreateInvitation(newInvitation("invite-1", "org-1")))
+	assertSentinel(t, storage.ErrInvalidInput, s.CreateInvitation(newInvitation("invite-5", "missing")))
+	assertSentinel(t, storage.ErrInvalidInput, s.CreateInvitation(nil))
+	assertSentinel(t, storage.ErrInvalidInput, s.CreateInvitation(newInvitation("", "org-1")))
+
+	t.Run("Renew", func(t *testing.T) {
+		expiresAt := time.Now().Add(96 * time.Hour).Truncate(time.Second)
+		require.NoError(t, s.RenewInvitation("invite-1", "token-renewed", expiresAt))
+		assertSentinel(t, storage.ErrNotFound, s.RenewInvitation("missing", "token-renewed", expiresAt))
+
+		got, err := s.GetInvitation("invite-1")
+		require.NoError(t, err)
+		assert.Equal(t, "token-renewed", got.TokenID)
+		assert.True(t, expiresAt.Equal(got.ExpiresAt))
+	})
+
+	t.Run("Accept", func(t *testing.T) {
+		acceptedAt := time.Now().Truncate(time.Second)
+		require.NoError(t, s.AcceptInvitation("invite-1", "user-1", acceptedAt))
+		assertSentinel(t, storage.ErrConflict, s.AcceptInvitation("invite-1", "user-1", acceptedAt))
+		assertSentinel(t, storage.ErrConflict, s.RenewInvitation("invite-1", "token-again", acceptedAt))
+		assertSentinel(t, storage.ErrConflict, s.RevokeInvitation("invite-1", acceptedAt))
+		assertSentinel(t, storage.ErrNotFound, s.AcceptInvitation("missing", "user-1", acceptedAt))
+
+		got, err := s.GetInvitation("invite-1")
+		require.NoError(t, err)
+		require.NotNil(t, got.AcceptedAt)
+		assert.True(t, acceptedAt.Equal(*got.AcceptedAt))
+		require.NotNil(t, got.UserID)
+		assert.Equal(t, "user-1", *got.UserID)
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		revokedAt := time.Now().Truncate(time.Second)
+		require.NoError(t, s.RevokeInvitation("invite-2", revokedAt))
+		assertSentinel(t, storage.ErrConflict, s.RevokeInvitation("invite-2", revokedAt))
+		assertSentinel(t, storage.ErrConflict, s.AcceptInvitation("invite-2", "user-1", revokedAt))
+		assertSentinel(t, storage.ErrNotFound, s.RevokeInvitation("missing", revokedAt))
+
+		got, err := s.GetInvitation("invite-2")
+		require.NoError(t, err)
+		require.NotNil(t, got.RevokedAt)
+		assert.True(t, revokedAt.Equal(*got.RevokedAt))
+		assert.Nil(t, got.AcceptedAt)
+	})
+
+	t.Run("DeleteUser", func(t *testing.T) {
+		// The invitation stays accepted once the user who accepted it is deleted
+		require.NoError(t, s.DeleteUser("user-1"))
+		got, err := s.GetInvitation("invite-1")
+		require.NoError(t, err)
+		assert.NotNil(t, got.AcceptedAt)
+		assert.Nil(t, got.UserID)
+	})
+
+	t.Run("PurgeOrganization", func(t *testing.T) {
+		require.NoError(t, s.PurgeOrganization("org-1"))
+		_, err := s.GetInvitation("invite-2")
+		assertSentinel(t, storage.ErrNotFound, err)
+		listed, err := s.ListInvitations("")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"invite-3"}, invitationIDs(listed))
+	})
+}
+
+func invitationIDs(invitations []*models.Invitation) []string {
+	ids := make([]string, 0, len(invitations))
+	for _, invitation := range invitations {
+		ids = append(ids, invitation.ID)
+	}
+	return ids
+}
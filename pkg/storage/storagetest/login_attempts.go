@@ -0,0 +1,94 @@
+package storagetest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+func testLoginAttempts(t *testing.T, s storage.Storage) {
+	now := time.Now().Truncate(time.Second)
+	window := now.Add(-15 * time.Minute)
+
+	_, err := s.GetLoginAttempt("user:alice")
+	assertSentinel(t, storage.ErrNotFound, err)
+	_, err = s.RecordLoginFailure("", now, window)
+	assertSentinel(t, storage.ErrInvalidInput, err)
+	assertSentinel(t, storage.ErrNotFound, s.LockLogin("user:alice", now.Add(time.Minute)))
+
+	attempt, err := s.RecordLoginFailure("user:alice", now.Add(-time.Minute), window)
+	require.NoError(t, err)
+	assert.Equal(t, 1, attempt.Failures)
+	attempt, err = s.RecordLoginFailure("user:alice", now, window)
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempt.Failures)
+	assert.True(t, now.Equal(attempt.LastFailureAt))
+	assert.Nil(t, attempt.LockedUntil)
+
+	lockedUntil := now.Add(10 * time.Minute)
+	require.NoError(t, s.LockLogin("user:alice", lockedUntil))
+	got, err := s.GetLoginAttempt("user:alice")
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.Failures)
+	require.NotNil(t, got.LockedUntil)
+	assert.True(t, lockedUntil.Equal(*got.LockedUntil))
+
+	t.Run("StartsOver", func(t *testing.T) {
+		// Failures after the lock ended start over, and so do failures older than the window
+		attempt, err := s.RecordLoginFailure("user:alice", lockedUntil, window)
+		require.NoError(t, err)
+		assert.Equal(t, 1, attempt.Failures)
+		assert.Nil(t, attempt.LockedUntil)
+
+		_, err = s.RecordLoginFailure("ip:192.0.2.10", now.Add(-time.Hour), window.Add(-time.Hour))
+		require.NoError(t, err)
+		attempt, err = s.RecordLoginFailure("ip:192.0.2.10", now, window)
+		require.NoError(t, err)
+		assert.Equal(t, 1, attempt.Failures)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := s.RecordLoginFailure("user:bob", now, window)
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+
+		got, err := s.GetLoginAttempt("user:bob")
+		require.NoError(t, err)
+		assert.Equal(t, 10, got.Failures, "no failure is lost")
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		require.NoError(t, s.ResetLoginAttempts("user:bob"))
+		_, err := s.GetLoginAttempt("user:bob")
+		assertSentinel(t, storage.ErrNotFound, err)
+		require.NoError(t, s.ResetLoginAttempts("user:bob"), "resetting twice is not an error")
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		_, err := s.RecordLoginFailure("user:carol", now.Add(-time.Hour), window.Add(-time.Hour))
+		require.NoError(t, err)
+		_, err = s.RecordLoginFailure("user:dave", now.Add(-time.Hour), window.Add(-time.Hour))
+		require.NoError(t, err)
+		require.NoError(t, s.LockLogin("user:dave", now.Add(time.Hour)))
+
+		purged, err := s.PurgeLoginAttempts(window)
+		require.NoError(t, err)
+		assert.Equal(t, 1, purged)
+		_, err = s.GetLoginAttempt("user:carol")
+		assertSentinel(t, storage.ErrNotFound, err)
+		_, err = s.GetLoginAttempt("user:dave")
+		assert.NoError(t, err, "locked attempts are kept until the lock ends")
+	})
+}
@@ -0,0 +1,110 @@
+package storagetest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// newOrgMembership returns a membership giving a user the org_user role in an organization
+func newOrgMembership(id, userID, orgID string) *models.OrgMembership {
+	return &models.OrgMembership{
+		ID:     id,
+		UserID: userID,
+		OrgID:  orgID,
+		Role:   models.RoleOrgUser,
+	}
+}
+
+func testOrgMemberships(t *testing.T, s storage.Storage) {
+	require.NoError(t, s.CreateOrganization(newOrganization("org-1")))
+	require.NoError(t, s.CreateOrganization(newOrganization("org-2")))
+	require.NoError(t, s.CreateUser(newUser("user-1")))
+	require.NoError(t, s.CreateUser(newUser("user-2")))
+
+	membership := newOrgMembership("member-1", "user-1", "org-1")
+	require.NoError(t, s.CreateOrgMembership(membership))
+	assert.False(t, membership.CreatedAt.IsZero())
+	pause()
+	require.NoError(t, s.CreateOrgMembership(newOrgMembership("member-2", "user-1", "org-2")))
+	pause()
+	require.NoError(t, s.CreateOrgMembership(newOrgMembership("member-3", "user-2", "org-1")))
+
+	got, err := s.GetOrgMembership("user-1", "org-1")
+	require.NoError(t, err)
+	assert.Equal(t, "member-1", got.ID)
+	assert.Equal(t, models.RoleOrgUser, got.Role)
+
+	memberships, err := s.ListOrgMemberships("user-1", "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"member-1", "member-2"}, membershipIDs(memberships))
+	memberships, err = s.ListOrgMemberships("", "org-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"member-1", "member-3"}, membershipIDs(memberships))
+	memberships, err = s.ListOrgMemberships("user-2", "org-2")
+	require.NoError(t, err)
+	assert.NotNil(t, memberships)
+	assert.Empty(t, memberships)
+	memberships, err = s.ListOrgMemberships("", "")
+	require.NoError(t, err)
+	assert.Len(t, memberships, 3)
+
+	t.Run("Update", func(t *testing.T) {
+		update := &models.OrgMembership{ID: "member-1", UserID: "user-2", OrgID: "org-2", Role: models.RoleOrgAdmin}
+		require.NoError(t, s.UpdateOrgMembership(update))
+		got, err := s.GetOrgMembership("user-1", "org-1")
+		require.NoError(t, err)
+		assert.Equal(t, models.RoleOrgAdmin, got.Role)
+		assert.False(t, got.UpdatedAt.Before(got.CreatedAt))
+		_, err = s.GetOrgMembership("user-2", "org-2")
+		assertSentinel(t, storage.ErrNotFound, err, "the user and organization cannot change")
+
+		assertSentinel(t, storage.ErrNotFound, s.UpdateOrgMembership(newOrgMembership("missing", "user-1", "org-1")))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		assertSentinel(t, storage.ErrAlreadyExists, s.CreateOrgMembership(newOrgMembership("member-2", "user-2", "org-2")))
+		assertSentinel(t, storage.ErrAlreadyExists, s.CreateOrgMembership(newOrgMembership("member-4", "user-1", "org-1")))
+		assertSentinel(t, storage.ErrInvalidInput, s.CreateOrgMembership(newOrgMembership("member-5", "missing", "org-1")))
+		assertSentinel(t, storage.ErrInvalidInput, s.CreateOrgMembership(newOrgMembership("member-6", "user-2", "missing")))
+		assertSentinel(t, storage.ErrInvalidInput, s.CreateOrgMembership(nil))
+		assertSentinel(t, storage.ErrInvalidInput, s.UpdateOrgMembership(&models.OrgMembership{}))
+
+		_, err := s.GetOrgMembership("user-2", "org-2")
+		assertSentinel(t, storage.ErrNotFound, err)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		require.NoError(t, s.DeleteOrgMembership("user-1", "org-2"))
+		_, err := s.GetOrgMembership("user-1", "org-2")
+		assertSentinel(t, storage.ErrNotFound, err)
+		assertSentinel(t, storage.ErrNotFound, s.DeleteOrgMembership("user-1", "org-2"))
+	})
+
+	t.Run("Cascade", func(t *testing.T) {
+		require.NoError(t, s.CreateOrgMembership(newOrgMembership("member-7", "user-2", "org-2")))
+		require.NoError(t, s.DeleteOrganization("org-2"))
+		_, err := s.GetOrgMembership("user-2", "org-2")
+		require.NoError(t, err, "memberships are kept while their organization is in the trash")
+		require.NoError(t, s.PurgeOrganization("org-2"))
+		_, err = s.GetOrgMembership("user-2", "org-2")
+		assertSentinel(t, storage.ErrNotFound, err)
+
+		require.NoError(t, s.DeleteUser("user-2"))
+		memberships, err := s.ListOrgMemberships("", "org-1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"member-1"}, membershipIDs(memberships))
+	})
+}
+
+func membershipIDs(memberships []*models.OrgMembership) []string {
+	ids := make([]string, 0, len(memberships))
+	for _, membership := range memberships {
+		ids = append(ids, membership.ID)
+	}
+	return ids
+}
@@ -0,0 +1,287 @@
+package storagetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+func testOrganizations(t *testing.T, s storage.Storage) {
+	org := newOrganization("org-1")
+	require.NoError(t, s.CreateOrganization(org))
+	assert.False(t, org.CreatedAt.IsZero())
+	assert.Equal(t, int64(1), org.ResourceVersion)
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreateOrganization(newOrganization("org-1")))
+
+	stored, err := s.GetOrganization("org-1")
+	require.NoError(t, err)
+	assert.Equal(t, "Organization org-1", stored.Name)
+	assert.Equal(t, "org-org-1", stored.Namespace)
+	assert.True(t, stored.IsEnabled)
+	assert.Nil(t, stored.DeletedAt)
+
+	stored.Description = "updated"
+	stored.IsEnabled = false
+	require.NoError(t, s.UpdateOrganization(stored))
+	updated, err := s.GetOrganization("org-1")
+	require.NoError(t, err)
+	assert.Equal(t, "updated", updated.Description)
+	assert.False(t, updated.IsEnabled)
+	assert.False(t, updated.UpdatedAt.Before(updated.CreatedAt))
+
+	orgs, err := s.ListOrganizations()
+	require.NoError(t, err)
+	require.Len(t, orgs, 1)
+	assert.Equal(t, "org-1", orgs[0].ID)
+}
+
+func testVDCs(t *testing.T, s storage.Storage) {
+	require.NoError(t, s.CreateOrganization(newOrganization("org-1")))
+	require.NoError(t, s.CreateOrganization(newOrganization("org-2")))
+
+	vdc := newVDC("vdc-1", "org-1")
+	vdc.CustomNetworkConfig = models.JSONBMap{"vlan": "100"}
+	vdc.CatalogRestrictions = models.JSONBArray{"catalog-a"}
+	require.NoError(t, s.CreateVDC(vdc))
+	assert.Equal(t, int64(1), vdc.ResourceVersion)
+	require.NoError(t, s.CreateVDC(newVDC("vdc-2", "org-2")))
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreateVDC(newVDC("vdc-1", "org-1")))
+
+	stored, err := s.GetVDC("vdc-1")
+	require.NoError(t, err)
+	assert.Equal(t, "org-1", stored.OrgID)
+	assert.Equal(t, "vdc-org-1-vdc-1", stored.WorkloadNamespace)
+	assert.Equal(t, 4, stored.CPUQuota)
+	assert.Equal(t, models.JSONBMap{"vlan": "100"}, stored.CustomNetworkConfig)
+	assert.Equal(t, models.JSONBArray{"catalog-a"}, stored.CatalogRestrictions)
+
+	stored.CPUQuota = 16
+	stored.Phase = models.VDCPhaseSuspended
+	require.NoError(t, s.UpdateVDC(stored))
+	updated, err := s.GetVDC("vdc-1")
+	require.NoError(t, err)
+	assert.Equal(t, 16, updated.CPUQuota)
+	assert.Equal(t, models.VDCPhaseSuspended, updated.Phase)
+
+	all, err := s.ListVDCs("")
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	byOrg, err := s.ListVDCs("org-2")
+	require.NoError(t, err)
+	require.Len(t, byOrg, 1)
+	assert.Equal(t, "vdc-2", byOrg[0].ID)
+}
+
+func testCatalogs(t *testing.T, s storage.Storage) {
+	require.NoError(t, s.CreateOrganization(newOrganization("org-1")))
+	require.NoError(t, s.CreateOrganization(newOrganization("org-2")))
+
+	catalog := newCatalog("catalog-1", "org-1")
+	catalog.IncludePatterns = models.JSONBArray{"rhel-*"}
+	catalog.Conditions.SetCondition("Synced", "False", "Pending", "Waiting for first sync")
+	require.NoError(t, s.CreateCatalog(catalog))
+	assert.Equal(t, int64(1), catalog.ResourceVersion)
+	assert.False(t, catalog.CreatedAt.IsZero())
+	require.NoError(t, s.CreateCatalog(newCatalog("catalog-2", "org-2")))
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreateCatalog(newCatalog("catalog-1", "org-1")), "duplicate ID")
+
+	sameName := newCatalog("catalog-3", "org-1")
+	sameName.Name = catalog.Name
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreateCatalog(sameName), "duplicate name in the organization")
+	sameName.OrgID = "org-2"
+	require.NoError(t, s.CreateCatalog(sameName), "names are unique per organization only")
+
+	assertSentinel(t, storage.ErrInvalidInput, s.CreateCatalog(newCatalog("orphan", "missing")), "unknown organization")
+
+	// Flags are stored as given, not replaced by column defaults
+	disabled := newCatalog("catalog-4", "org-1")
+	disabled.IsEnabled = false
+	disabled.ReadOnly = false
+	require.NoError(t, s.CreateCatalog(disabled))
+	stored, err := s.GetCatalog("catalog-4")
+	require.NoError(t, err)
+	assert.False(t, stored.IsEnabled)
+	assert.False(t, stored.ReadOnly)
+
+	stored, err = s.GetCatalog("catalog-1")
+	require.NoError(t, err)
+	assert.Equal(t, "org-1", stored.OrgID)
+	assert.Equal(t, models.CatalogSourceGit, stored.SourceType)
+	assert.Equal(t, models.JSONBArray{"rhel-*"}, stored.IncludePatterns)
+	require.Len(t, stored.Conditions, 1)
+	assert.Equal(t, "Pending", stored.Conditions[0].Reason)
+
+	stored.UpdateSyncStatus(true, "")
+	stored.TotalItems = 3
+	require.NoError(t, s.UpdateCatalog(stored))
+	assert.Equal(t, int64(2), stored.ResourceVersion)
+	updated, err := s.GetCatalog("catalog-1")
+	require.NoError(t, err)
+	assert.Equal(t, models.CatalogPhaseReady, updated.Phase)
+	assert.Equal(t, 3, updated.TotalItems)
+	require.NotNil(t, updated.LastSync)
+
+	stale := newCatalog("catalog-1", "org-1")
+	stale.ResourceVersion = 1
+	assertSentinel(t, storage.ErrConflict, s.UpdateCatalog(stale))
+
+	renamed, err := s.GetCatalog("catalog-4")
+	require.NoError(t, err)
+	renamed.Name = catalog.Name
+	assertSentinel(t, storage.ErrAlreadyExists, s.UpdateCatalog(renamed), "rename to a taken name")
+
+	all, err := s.ListCatalogs("")
+	require.NoError(t, err)
+	assert.Len(t, all, 4)
+
+	byOrg, err := s.ListCatalogs("org-2")
+	require.NoError(t, err)
+	require.Len(t, byOrg, 2)
+	assert.Equal(t, "catalog-2", byOrg[0].ID)
+	assert.Equal(t, "catalog-3", byOrg[1].ID)
+
+	require.NoError(t, s.DeleteCatalog("catalog-1"))
+	_, err = s.GetCatalog("catalog-1")
+	assertSentinel(t, storage.ErrNotFound, err)
+	assertSentinel(t, storage.ErrNotFound, s.DeleteCatalog("catalog-1"))
+}
+
+func testCatalogTemplates(t *testing.T, s storage.Storage) {
+	require.NoError(t, s.CreateOrganization(newOrganization("org-1")))
+	require.NoError(t, s.CreateCatalog(newCatalog("catalog-1", "org-1")))
+
+	catalogID := "catalog-1"
+	linked := newTemplate("template-1", "org-1")
+	linked.CatalogID = &catalogID
+	require.NoError(t, s.CreateTemplate(linked))
+	require.NoError(t, s.CreateTemplate(newTemplate("template-2", "org-1")))
+
+	missing := "missing"
+	dangling := newTemplate("template-3", "org-1")
+	dangling.CatalogID = &missing
+	assertSentinel(t, storage.ErrInvalidInput, s.CreateTemplate(dangling), "unknown catalog")
+
+	unlinked, err := s.GetTemplate("template-2")
+	require.NoError(t, err)
+	unlinked.CatalogID = &missing
+	assertSentinel(t, storage.ErrInvalidInput, s.UpdateTemplate(unlinked), "unknown catalog")
+
+	stored, err := s.GetTemplate("template-1")
+	require.NoError(t, err)
+	require.NotNil(t, stored.CatalogID)
+	assert.Equal(t, catalogID, *stored.CatalogID)
+
+	// Deleting the catalog keeps its templates
+	require.NoError(t, s.DeleteCatalog(catalogID))
+	stored, err = s.GetTemplate("template-1")
+	require.NoError(t, err)
+	assert.Nil(t, stored.CatalogID)
+}
+
+func testTemplates(t *testing.T, s storage.Storage) {
+	template := newTemplate("template-1", "org-1")
+	template.Metadata = models.StringMap{"os": "fedora"}
+	require.NoError(t, s.CreateTemplate(template))
+	assert.Equal(t, int64(1), template.ResourceVersion)
+	require.NoError(t, s.CreateTemplate(newTemplate("template-2", "org-2")))
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreateTemplate(newTemplate("template-1", "org-1")))
+
+	stored, err := s.GetTemplate("template-1")
+	require.NoError(t, err)
+	assert.Equal(t, "template-template-1", stored.Name)
+	assert.Equal(t, 2, stored.CPU)
+	assert.Equal(t, "fedora", stored.Metadata["os"])
+
+	stored.CPU = 4
+	require.NoError(t, s.UpdateTemplate(stored))
+	updated, err := s.GetTemplate("template-1")
+	require.NoError(t, err)
+	assert.Equal(t, 4, updated.CPU)
+
+	all, err := s.ListTemplates()
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	byOrg, err := s.ListTemplatesByOrg("org-1")
+	require.NoError(t, err)
+	require.Len(t, byOrg, 1)
+	assert.Equal(t, "template-1", byOrg[0].ID)
+
+	// Templates are deleted permanently
+	require.NoError(t, s.DeleteTemplate("template-1"))
+	_, err = s.GetTemplate("template-1")
+	assertSentinel(t, storage.ErrNotFound, err)
+	assertSentinel(t, storage.ErrNotFound, s.DeleteTemplate("template-1"))
+}
+
+func testVMs(t *testing.T, s storage.Storage) {
+	vdcID := "vdc-1"
+	vm := newVM("vm-1", "org-1")
+	vm.VDCID = &vdcID
+	require.NoError(t, s.CreateVM(vm))
+	assert.Equal(t, int64(1), vm.ResourceVersion)
+	require.NoError(t, s.CreateVM(newVM("vm-2", "org-2")))
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreateVM(newVM("vm-1", "org-1")))
+
+	stored, err := s.GetVM("vm-1")
+	require.NoError(t, err)
+	assert.Equal(t, "vm-vm-1", stored.Name)
+	assert.Equal(t, models.VMStatusStopped, stored.Status)
+	require.NotNil(t, stored.VDCID)
+	assert.Equal(t, vdcID, *stored.VDCID)
+
+	stored.Status = models.VMStatusRunning
+	stored.IPAddress = "10.0.0.10"
+	require.NoError(t, s.UpdateVM(stored))
+	updated, err := s.GetVM("vm-1")
+	require.NoError(t, err)
+	assert.Equal(t, models.VMStatusRunning, updated.Status)
+	assert.Equal(t, "10.0.0.10", updated.IPAddress)
+
+	all, err := s.ListVMs("")
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	byOrg, err := s.ListVMs("org-2")
+	require.NoError(t, err)
+	require.Len(t, byOrg, 1)
+	assert.Equal(t, "vm-2", byOrg[0].ID)
+}
+
+func testCatalogSources(t *testing.T, s storage.Storage) {
+	source := newCatalogSource("source-1", "org-1")
+	require.NoError(t, s.CreateOrganizationCatalogSource(source))
+	require.NoError(t, s.CreateOrganizationCatalogSource(newCatalogSource("source-2", "org-2")))
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreateOrganizationCatalogSource(newCatalogSource("source-1", "org-1")))
+
+	stored, err := s.GetOrganizationCatalogSource("source-1")
+	require.NoError(t, err)
+	assert.Equal(t, "org-1", stored.OrgID)
+	assert.Equal(t, "source-source-1", stored.SourceName)
+	assert.True(t, stored.Enabled)
+	assert.False(t, stored.CreatedAt.IsZero())
+
+	stored.Enabled = false
+	stored.SourceName = "renamed"
+	require.NoError(t, s.UpdateOrganizationCatalogSource(stored))
+	updated, err := s.GetOrganizationCatalogSource("source-1")
+	require.NoError(t, err)
+	assert.False(t, updated.Enabled)
+	assert.Equal(t, "renamed", updated.SourceName)
+	assert.WithinDuration(t, stored.CreatedAt, updated.CreatedAt, time.Millisecond)
+
+	sources, err := s.ListOrganizationCatalogSources("org-1")
+	require.NoError(t, err)
+	require.Len(t, sources, 1)
+	assert.Equal(t, "source-1", sources[0].ID)
+
+	require.NoError(t, s.DeleteOrganizationCatalogSource("source-1"))
+	_, err = s.GetOrganizationCatalogSource("source-1")
+	assertSentinel(t, storage.ErrNotFound, err)
+}
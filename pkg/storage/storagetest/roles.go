@@ -0,0 +1,97 @@
+package storagetest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// newOrgRole returns a custom role that can read VMs
+func newOrgRole(id, orgID, name string) *models.OrgRole {
+	return &models.OrgRole{
+		ID:    id,
+		OrgID: orgID,
+		Name:  name,
+		Verbs: models.JSONBArray{"vm:list", "vm:get"},
+	}
+}
+
+func testOrgRoles(t *testing.T, s storage.Storage) {
+	require.NoError(t, s.CreateOrganization(newOrganization("org-1")))
+	require.NoError(t, s.CreateOrganization(newOrganization("org-2")))
+
+	role := newOrgRole("role-1", "org-1", "viewer")
+	role.Description = "Reads VMs"
+	require.NoError(t, s.CreateOrgRole(role))
+	assert.False(t, role.CreatedAt.IsZero())
+	require.NoError(t, s.CreateOrgRole(newOrgRole("role-2", "org-1", "operator")))
+	require.NoError(t, s.CreateOrgRole(newOrgRole("role-3", "org-2", "viewer")), "names are unique per organization")
+
+	got, err := s.GetOrgRoleByName("org-1", "viewer")
+	require.NoError(t, err)
+	assert.Equal(t, "role-1", got.ID)
+	assert.Equal(t, "Reads VMs", got.Description)
+	assert.Equal(t, models.JSONBArray{"vm:list", "vm:get"}, got.Verbs)
+
+	roles, err := s.ListOrgRoles("org-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"role-1", "role-2"}, orgRoleIDs(roles))
+	roles, err = s.ListOrgRoles("missing")
+	require.NoError(t, err)
+	assert.NotNil(t, roles)
+	assert.Empty(t, roles)
+
+	t.Run("Update", func(t *testing.T) {
+		update := &models.OrgRole{ID: "role-1", OrgID: "org-2", Name: "auditor", Verbs: models.JSONBArray{"vm:*"}}
+		require.NoError(t, s.UpdateOrgRole(update))
+		got, err := s.GetOrgRole("role-1")
+		require.NoError(t, err)
+		assert.Equal(t, "auditor", got.Name)
+		assert.Empty(t, got.Description)
+		assert.Equal(t, models.JSONBArray{"vm:*"}, got.Verbs)
+		assert.Equal(t, "org-1", got.OrgID, "the organization cannot change")
+
+		update.Name = "operator"
+		assertSentinel(t, storage.ErrAlreadyExists, s.UpdateOrgRole(update))
+		assertSentinel(t, storage.ErrNotFound, s.UpdateOrgRole(newOrgRole("missing", "org-1", "missing")))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		assertSentinel(t, storage.ErrAlreadyExists, s.CreateOrgRole(newOrgRole("role-2", "org-2", "other")))
+		assertSentinel(t, storage.ErrAlreadyExists, s.CreateOrgRole(newOrgRole("role-4", "org-1", "operator")))
+		assertSentinel(t, storage.ErrInvalidInput, s.CreateOrgRole(newOrgRole("role-5", "missing", "viewer")))
+		assertSentinel(t, storage.ErrInvalidInput, s.CreateOrgRole(nil))
+		assertSentinel(t, storage.ErrInvalidInput, s.UpdateOrgRole(&models.OrgRole{}))
+
+		_, err := s.GetOrgRole("missing")
+		assertSentinel(t, storage.ErrNotFound, err)
+		_, err = s.GetOrgRoleByName("org-2", "operator")
+		assertSentinel(t, storage.ErrNotFound, err)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		require.NoError(t, s.DeleteOrgRole("role-2"))
+		_, err := s.GetOrgRole("role-2")
+		assertSentinel(t, storage.ErrNotFound, err)
+		assertSentinel(t, storage.ErrNotFound, s.DeleteOrgRole("role-2"))
+
+		require.NoError(t, s.DeleteOrganization("org-2"))
+		_, err = s.GetOrgRole("role-3")
+		require.NoError(t, err, "roles are kept while their organization is in the trash")
+		require.NoError(t, s.PurgeOrganization("org-2"))
+		_, err = s.GetOrgRole("role-3")
+		assertSentinel(t, storage.ErrNotFound, err)
+	})
+}
+
+func orgRoleIDs(roles []*models.OrgRole) []string {
+	ids := make([]string, 0, len(roles))
+	for _, role := range roles {
+		ids = append(ids, role.ID)
+	}
+	return ids
+}
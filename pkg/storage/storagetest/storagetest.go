@@ -0,0 +1,172 @@
+// Package storagetest is the conformance suite of the storage.Storage contract. Every storage
+// backend runs it from its own tests with a factory for empty storages:
+//
+//	func TestMemoryStorageConformance(t *testing.T) {
+//		storagetest.Run(t, func() storage.Storage {
+//			s, _ := storage.NewMemoryStorageForTest()
+//			return s
+//		})
+//	}
+package storagetest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// Run checks the Storage contract against storages created by newStorage. Every call to
+// newStorage must return a storage without any data. Each subtest gets its own storage,
+// which is closed when the subtest ends.
+func Run(t *testing.T, newStorage func() storage.Storage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Storage)
+	}{
+		{"Users", testUsers},
+		{"UserUniqueness", testUserUniqueness},
+		{"UserIdentities", testUserIdentities},
+		{"Organizations", testOrganizations},
+		{"VDCs", testVDCs},
+		{"Catalogs", testCatalogs},
+		{"CatalogTemplates", testCatalogTemplates},
+		{"Templates", testTemplates},
+		{"VMs", testVMs},
+		{"CatalogSources", testCatalogSources},
+		{"InvalidInput", testInvalidInput},
+		{"NotFound", testNotFound},
+		{"EmptyLists", testEmptyLists},
+		{"Ordering", testOrdering},
+		{"Query", testQuery},
+		{"ResourceVersions", testResourceVersions},
+		{"Copies", testCopies},
+		{"Trash", testTrash},
+		{"Cascade", testCascade},
+		{"Transactions", testTransactions},
+		{"ConcurrentWrites", testConcurrentWrites},
+		{"RefreshTokens", testRefreshTokens},
+		{"TokenRevocation", testTokenRevocation},
+		{"TokenTransactions", testTokenTransactions},
+		{"PasswordResetTokens", testPasswordResetTokens},
+		{"APITokens", testAPITokens},
+		{"OrgRoles", testOrgRoles},
+		{"OrgMemberships", testOrgMemberships},
+		{"LoginAttempts", testLoginAttempts},
+		{"Impersonations", testImpersonations},
+		{"Invitations", testInvitations},
+		{"AuditRecords", testAuditRecords},
+		{"Watch", testWatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStorage()
+			t.Cleanup(func() { s.Close() })
+			tt.test(t, s)
+		})
+	}
+}
+
+// assertSentinel checks that err is exactly want. Handlers compare storage errors with ==,
+// so the sentinel errors must not be wrapped.
+func assertSentinel(t *testing.T, want, err error, msgAndArgs ...interface{}) bool {
+	t.Helper()
+	return assert.Equal(t, want, err, msgAndArgs...)
+}
+
+// The fixtures derive every unique column from the ID, so any number of them can coexist
+
+func newUser(id string) *models.User {
+	return &models.User{
+		ID:       id,
+		Username: "user-" + id,
+		Email:    id + "@example.com",
+		Role:     models.RoleOrgUser,
+	}
+}
+
+func newOrganization(id string) *models.Organization {
+	return &models.Organization{
+		ID:          id,
+		Name:        "Organization " + id,
+		Namespace:   "org-" + id,
+		IsEnabled:   true,
+		CRName:      id,
+		CRNamespace: "default",
+	}
+}
+
+func newVDC(id, orgID string) *models.VirtualDataCenter {
+	return &models.VirtualDataCenter{
+		ID:                id,
+		Name:              "vdc-" + id,
+		OrgID:             orgID,
+		CRName:            id,
+		CRNamespace:       "org-" + orgID,
+		WorkloadNamespace: fmt.Sprintf("vdc-%s-%s", orgID, id),
+		Phase:             models.VDCPhaseActive,
+		CPUQuota:          4,
+		MemoryQuota:       8,
+		StorageQuota:      100,
+	}
+}
+
+func newCatalog(id, orgID string) *models.Catalog {
+	return &models.Catalog{
+		ID:          id,
+		Name:        "catalog-" + id,
+		OrgID:       orgID,
+		CRName:      id,
+		CRNamespace: "org-" + orgID,
+		Type:        models.CatalogTypeVMTemplate,
+		SourceType:  models.CatalogSourceGit,
+		SourceURL:   "https://example.com/" + id + ".git",
+		IsEnabled:   true,
+		Phase:       models.CatalogPhasePending,
+	}
+}
+
+func newTemplate(id, orgID string) *models.Template {
+	return &models.Template{
+		ID:     id,
+		Name:   "template-" + id,
+		OrgID:  orgID,
+		OSType: "Linux",
+		CPU:    2,
+		Memory: "4Gi",
+	}
+}
+
+func newVM(id, orgID string) *models.VirtualMachine {
+	return &models.VirtualMachine{
+		ID:         id,
+		Name:       "vm-" + id,
+		OrgID:      orgID,
+		TemplateID: "template-1",
+		OwnerID:    "owner-1",
+		Status:     models.VMStatusStopped,
+		CPU:        2,
+		Memory:     "4Gi",
+	}
+}
+
+func newCatalogSource(id, orgID string) *models.OrganizationCatalogSource {
+	return &models.OrganizationCatalogSource{
+		ID:              id,
+		OrgID:           orgID,
+		SourceType:      "redhat-operators",
+		SourceName:      "source-" + id,
+		SourceNamespace: "openshift-marketplace",
+		Enabled:         true,
+	}
+}
+
+// pause makes sure consecutive records get distinct timestamps on every backend
+func pause() {
+	time.Sleep(2 * time.Millisecond)
+}
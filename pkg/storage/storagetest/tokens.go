@@ -0,0 +1,327 @@
+package storagetest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// newRefreshToken returns a refresh token whose access token is valid for an hour
+func newRefreshToken(id, userID, sessionID string) *models.RefreshToken {
+	now := time.Now()
+	return &models.RefreshToken{
+		ID:                   id,
+		UserID:               userID,
+		SessionID:            sessionID,
+		TokenHash:            "hash-" + id,
+		AccessTokenID:        "access-" + id,
+		AccessTokenExpiresAt: now.Add(time.Hour),
+		ExpiresAt:            now.Add(24 * time.Hour),
+	}
+}
+
+// assertRevoked checks whether the access tokens with the given IDs are revoked
+func assertRevoked(t *testing.T, s storage.Storage, revoked bool, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		got, err := s.IsTokenRevoked(id)
+		require.NoError(t, err)
+		assert.Equal(t, revoked, got, "access token %s", id)
+	}
+}
+
+func testRefreshTokens(t *testing.T, s storage.Storage) {
+	require.NoError(t, s.CreateUser(newUser("user-1")))
+	token := newRefreshToken("token-1", "user-1", "session-1")
+	require.NoError(t, s.CreateRefreshToken(token))
+	assert.False(t, token.CreatedAt.IsZero())
+
+	got, err := s.GetRefreshTokenByHash("hash-token-1")
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", got.ID)
+	assert.Equal(t, "session-1", got.SessionID)
+	assert.Equal(t, "access-token-1", got.AccessTokenID)
+	assert.WithinDuration(t, token.ExpiresAt, got.ExpiresAt, time.Second)
+	assert.Nil(t, got.RevokedAt)
+
+	// Rotation revokes a token once, a second use is a conflict
+	require.NoError(t, s.RevokeRefreshToken("token-1"))
+	assertSentinel(t, storage.ErrConflict, s.RevokeRefreshToken("token-1"))
+	got, err = s.GetRefreshTokenByHash("hash-token-1")
+	require.NoError(t, err)
+	assert.NotNil(t, got.RevokedAt, "revoked tokens are still found")
+	assertRevoked(t, s, false, "access-token-1")
+
+	assertSentinel(t, storage.ErrNotFound, s.RevokeRefreshToken("missing"))
+	_, err = s.GetRefreshTokenByHash("missing")
+	assertSentinel(t, storage.ErrNotFound, err)
+
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreateRefreshToken(newRefreshToken("token-1", "user-1", "session-1")))
+	duplicateHash := newRefreshToken("token-2", "user-1", "session-1")
+	duplicateHash.TokenHash = "hash-token-1"
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreateRefreshToken(duplicateHash))
+	assertSentinel(t, storage.ErrInvalidInput, s.CreateRefreshToken(newRefreshToken("token-3", "missing", "session-3")))
+	assertSentinel(t, storage.ErrInvalidInput, s.CreateRefreshToken(nil))
+	assertSentinel(t, storage.ErrInvalidInput, s.CreateRefreshToken(newRefreshToken("", "user-1", "session-1")))
+}
+
+func testTokenRevocation(t *testing.T, s storage.Storage) {
+	require.NoError(t, s.CreateUser(newUser("user-1")))
+	require.NoError(t, s.CreateUser(newUser("user-2")))
+	for _, token := range []*models.RefreshToken{
+		newRefreshToken("token-1a", "user-1", "session-1"),
+		newRefreshToken("token-1b", "user-1", "session-1"),
+		newRefreshToken("token-2", "user-1", "session-2"),
+		newRefreshToken("token-3", "user-2", "session-3"),
+	} {
+		require.NoError(t, s.CreateRefreshToken(token))
+	}
+	// The access token of an old refresh token may have expired already
+	expired := newRefreshToken("token-4", "user-2", "session-4")
+	expired.AccessTokenExpiresAt = time.Now().Add(-time.Minute)
+	require.NoError(t, s.CreateRefreshToken(expired))
+
+	t.Run("Session", func(t *testing.T) {
+		require.NoError(t, s.RevokeRefreshToken("token-1a"))
+		require.NoError(t, s.RevokeSession("session-1"))
+		assertRevoked(t, s, true, "access-token-1a", "access-token-1b")
+		assertRevoked(t, s, false, "access-token-2", "access-token-3")
+		assertSentinel(t, storage.ErrConflict, s.RevokeRefreshToken("token-1b"))
+
+		require.NoError(t, s.RevokeSession("session-1"), "revoking twice is not an error")
+		require.NoError(t, s.RevokeSession("missing"))
+	})
+
+	t.Run("User", func(t *testing.T) {
+		require.NoError(t, s.RevokeUserTokens("user-2"))
+		assertRevoked(t, s, true, "access-token-3")
+		assertRevoked(t, s, false, "access-token-2", "access-token-4")
+		assertSentinel(t, storage.ErrConflict, s.RevokeRefreshToken("token-4"))
+		require.NoError(t, s.RevokeUserTokens("missing"))
+	})
+
+	t.Run("Token", func(t *testing.T) {
+		token := &models.RevokedToken{ID: "access-5", UserID: "user-1", ExpiresAt: time.Now().Add(time.Hour)}
+		require.NoError(t, s.RevokeToken(token))
+		require.NoError(t, s.RevokeToken(token), "revoking twice is not an error")
+		assertRevoked(t, s, true, "access-5")
+		assertSentinel(t, storage.ErrInvalidInput, s.RevokeToken(nil))
+		assertSentinel(t, storage.ErrInvalidInput, s.RevokeToken(&models.RevokedToken{}))
+	})
+
+	t.Run("DeleteUser", func(t *testing.T) {
+		// Deleted users lose their refresh tokens, their revoked access tokens stay revoked
+		require.NoError(t, s.DeleteUser("user-2"))
+		_, err := s.GetRefreshTokenByHash("hash-token-3")
+		assertSentinel(t, storage.ErrNotFound, err)
+		assertRevoked(t, s, true, "access-token-3")
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		purged, err := s.PurgeExpiredTokens(time.Now())
+		require.NoError(t, err)
+		assert.Zero(t, purged)
+
+		// Every remaining token expires within two days
+		purged, err = s.PurgeExpiredTokens(time.Now().Add(48 * time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, 7, purged, "three refresh tokens of user-1 and four revoked access tokens")
+		_, err = s.GetRefreshTokenByHash("hash-token-2")
+		assertSentinel(t, storage.ErrNotFound, err)
+		assertRevoked(t, s, false, "access-token-1a", "access-token-1b", "access-token-3", "access-5")
+	})
+}
+
+func testTokenTransactions(t *testing.T, s storage.Storage) {
+	require.NoError(t, s.CreateUser(newUser("user-1")))
+	require.NoError(t, s.CreateRefreshToken(newRefreshToken("token-1", "user-1", "session-1")))
+
+	// A failed rotation keeps the old token usable
+	err := s.WithTx(func(tx storage.Storage) error {
+		if err := tx.RevokeRefreshToken("token-1"); err != nil {
+			return err
+		}
+		if err := tx.CreateRefreshToken(newRefreshToken("token-2", "user-1", "session-1")); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	require.Error(t, err)
+	got, err := s.GetRefreshTokenByHash("hash-token-1")
+	require.NoError(t, err)
+	assert.Nil(t, got.RevokedAt)
+	_, err = s.GetRefreshTokenByHash("hash-token-2")
+	assertSentinel(t, storage.ErrNotFound, err)
+
+	err = s.WithTx(func(tx storage.Storage) error {
+		return tx.RevokeSession("session-1")
+	})
+	require.NoError(t, err)
+	assertRevoked(t, s, true, "access-token-1")
+}
+
+// newAPIToken returns an API token that can read VMs
+func newAPIToken(id, userID, name string) *models.APIToken {
+	return &models.APIToken{
+		ID:        id,
+		UserID:    userID,
+		Name:      name,
+		Prefix:    "ovim_" + id,
+		TokenHash: "hash-" + id,
+		Scopes:    models.JSONBArray{"GET /api/v1/vms"},
+	}
+}
+
+func testAPITokens(t *testing.T, s storage.Storage) {
+	require.NoError(t, s.CreateUser(newUser("user-1")))
+	require.NoError(t, s.CreateUser(newUser("user-2")))
+
+	expiresAt := time.Now().Add(24 * time.Hour)
+	token := newAPIToken("token-1", "user-1", "ci")
+	token.ExpiresAt = &expiresAt
+	require.NoError(t, s.CreateAPIToken(token))
+	assert.False(t, token.CreatedAt.IsZero())
+	require.NoError(t, s.CreateAPIToken(newAPIToken("token-2", "user-1", "backup")))
+	require.NoError(t, s.CreateAPIToken(newAPIToken("token-3", "user-2", "ci")), "names are unique per user")
+
+	got, err := s.GetAPITokenByHash("hash-token-1")
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", got.ID)
+	assert.Equal(t, "ci", got.Name)
+	assert.Equal(t, models.JSONBArray{"GET /api/v1/vms"}, got.Scopes)
+	require.NotNil(t, got.ExpiresAt)
+	assert.WithinDuration(t, expiresAt, *got.ExpiresAt, time.Second)
+	assert.Nil(t, got.LastUsedAt)
+
+	tokens, err := s.ListAPITokens("user-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"token-1", "token-2"}, apiTokenIDs(tokens))
+	tokens, err = s.ListAPITokens("")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"token-1", "token-2", "token-3"}, apiTokenIDs(tokens))
+	tokens, err = s.ListAPITokens("missing")
+	require.NoError(t, err)
+	assert.NotNil(t, tokens)
+	assert.Empty(t, tokens)
+
+	t.Run("Update", func(t *testing.T) {
+		update := &models.APIToken{ID: "token-1", Name: "deploy", Scopes: models.JSONBArray{"* /api/v1/vms"}, TokenHash: "changed", UserID: "user-2"}
+		require.NoError(t, s.UpdateAPIToken(update))
+		got, err := s.GetAPIToken("token-1")
+		require.NoError(t, err)
+		assert.Equal(t, "deploy", got.Name)
+		assert.Equal(t, models.JSONBArray{"* /api/v1/vms"}, got.Scopes)
+		assert.Nil(t, got.ExpiresAt)
+		assert.Equal(t, "hash-token-1", got.TokenHash, "the secret cannot change")
+		assert.Equal(t, "user-1", got.UserID, "the owner cannot change")
+
+		update.Name = "backup"
+		assertSentinel(t, storage.ErrAlreadyExists, s.UpdateAPIToken(update))
+		assertSentinel(t, storage.ErrNotFound, s.UpdateAPIToken(newAPIToken("missing", "user-1", "missing")))
+	})
+
+	t.Run("Touch", func(t *testing.T) {
+		usedAt := time.Now()
+		require.NoError(t, s.TouchAPIToken("token-2", usedAt))
+		got, err := s.GetAPIToken("token-2")
+		require.NoError(t, err)
+		require.NotNil(t, got.LastUsedAt)
+		assert.WithinDuration(t, usedAt, *got.LastUsedAt, time.Second)
+		assertSentinel(t, storage.ErrNotFound, s.TouchAPIToken("missing", usedAt))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		assertSentinel(t, storage.ErrAlreadyExists, s.CreateAPIToken(newAPIToken("token-2", "user-2", "other")))
+		duplicateName := newAPIToken("token-4", "user-1", "backup")
+		assertSentinel(t, storage.ErrAlreadyExists, s.CreateAPIToken(duplicateName))
+		duplicateHash := newAPIToken("token-5", "user-1", "other")
+		duplicateHash.TokenHash = "hash-token-2"
+		assertSentinel(t, storage.ErrAlreadyExists, s.CreateAPIToken(duplicateHash))
+		assertSentinel(t, storage.ErrInvalidInput, s.CreateAPIToken(newAPIToken("token-6", "missing", "ci")))
+		assertSentinel(t, storage.ErrInvalidInput, s.CreateAPIToken(nil))
+		assertSentinel(t, storage.ErrInvalidInput, s.UpdateAPIToken(&models.APIToken{}))
+
+		_, err := s.GetAPIToken("missing")
+		assertSentinel(t, storage.ErrNotFound, err)
+		_, err = s.GetAPITokenByHash("missing")
+		assertSentinel(t, storage.ErrNotFound, err)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		require.NoError(t, s.DeleteAPIToken("token-2"))
+		_, err := s.GetAPIToken("token-2")
+		assertSentinel(t, storage.ErrNotFound, err)
+		assertSentinel(t, storage.ErrNotFound, s.DeleteAPIToken("token-2"))
+
+		require.NoError(t, s.DeleteUser("user-2"))
+		_, err = s.GetAPITokenByHash("hash-token-3")
+		assertSentinel(t, storage.ErrNotFound, err)
+	})
+}
+
+func apiTokenIDs(tokens []*models.APIToken) []string {
+	ids := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		ids = append(ids, token.ID)
+	}
+	return ids
+}
+
+func testPasswordResetTokens(t *testing.T, s storage.Storage) {
+	require.NoError(t, s.CreateUser(newUser("user-1")))
+	newResetToken := func(id string, expiresAt time.Time) *models.PasswordResetToken {
+		return &models.PasswordResetToken{ID: id, UserID: "user-1", TokenHash: "hash-" + id, CreatedBy: "admin", ExpiresAt: expiresAt}
+	}
+	token := newResetToken("reset-1", time.Now().Add(time.Hour))
+	require.NoError(t, s.CreatePasswordResetToken(token))
+	assert.False(t, token.CreatedAt.IsZero())
+
+	got, err := s.GetPasswordResetTokenByHash("hash-reset-1")
+	require.NoError(t, err)
+	assert.Equal(t, "reset-1", got.ID)
+	assert.Equal(t, "user-1", got.UserID)
+	assert.Equal(t, "admin", got.CreatedBy)
+	assert.WithinDuration(t, token.ExpiresAt, got.ExpiresAt, time.Second)
+	assert.Nil(t, got.UsedAt)
+
+	// A token is used once
+	require.NoError(t, s.UsePasswordResetToken("reset-1", time.Now()))
+	assertSentinel(t, storage.ErrConflict, s.UsePasswordResetToken("reset-1", time.Now()))
+	got, err = s.GetPasswordResetTokenByHash("hash-reset-1")
+	require.NoError(t, err)
+	assert.NotNil(t, got.UsedAt, "used tokens are still found")
+
+	assertSentinel(t, storage.ErrNotFound, s.UsePasswordResetToken("missing", time.Now()))
+	_, err = s.GetPasswordResetTokenByHash("missing")
+	assertSentinel(t, storage.ErrNotFound, err)
+
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreatePasswordResetToken(newResetToken("reset-1", time.Now().Add(time.Hour))))
+	duplicateHash := newResetToken("reset-2", time.Now().Add(time.Hour))
+	duplicateHash.TokenHash = "hash-reset-1"
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreatePasswordResetToken(duplicateHash))
+	missingUser := newResetToken("reset-3", time.Now().Add(time.Hour))
+	missingUser.UserID = "missing"
+	assertSentinel(t, storage.ErrInvalidInput, s.CreatePasswordResetToken(missingUser))
+	assertSentinel(t, storage.ErrInvalidInput, s.CreatePasswordResetToken(nil))
+
+	t.Run("Purge", func(t *testing.T) {
+		require.NoError(t, s.CreatePasswordResetToken(newResetToken("reset-4", time.Now().Add(-time.Minute))))
+		purged, err := s.PurgeExpiredTokens(time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, 1, purged)
+		_, err = s.GetPasswordResetTokenByHash("hash-reset-4")
+		assertSentinel(t, storage.ErrNotFound, err)
+	})
+
+	t.Run("DeleteUser", func(t *testing.T) {
+		require.NoError(t, s.DeleteUser("user-1"))
+		_, err := s.GetPasswordResetTokenByHash("hash-reset-1")
+		assertSentinel(t, storage.ErrNotFound, err)
+	})
+}
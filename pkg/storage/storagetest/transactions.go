@@ -0,0 +1,175 @@
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+func testTransactions(t *testing.T, s storage.Storage) {
+	t.Run("Commit", func(t *testing.T) {
+		err := s.WithTx(func(tx storage.Storage) error {
+			if err := tx.CreateOrganization(newOrganization("org-1")); err != nil {
+				return err
+			}
+			// Writes are visible inside the transaction
+			if _, err := tx.GetOrganization("org-1"); err != nil {
+				return err
+			}
+			return tx.CreateUser(newUser("user-1"))
+		})
+		require.NoError(t, err)
+
+		_, err = s.GetOrganization("org-1")
+		assert.NoError(t, err)
+		_, err = s.GetUserByID("user-1")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		failure := errors.New("failure")
+		err := s.WithTx(func(tx storage.Storage) error {
+			if err := tx.CreateOrganization(newOrganization("org-2")); err != nil {
+				return err
+			}
+			if err := tx.DeleteUser("user-1"); err != nil {
+				return err
+			}
+			return failure
+		})
+		assert.Same(t, failure, err, "the error of fn is returned unchanged")
+
+		_, err = s.GetOrganization("org-2")
+		assertSentinel(t, storage.ErrNotFound, err)
+		_, err = s.GetUserByID("user-1")
+		assert.NoError(t, err)
+	})
+
+	t.Run("StorageError", func(t *testing.T) {
+		err := s.WithTx(func(tx storage.Storage) error {
+			if err := tx.CreateOrganization(newOrganization("org-3")); err != nil {
+				return err
+			}
+			return tx.CreateUser(newUser("user-1"))
+		})
+		assertSentinel(t, storage.ErrAlreadyExists, err)
+
+		_, err = s.GetOrganization("org-3")
+		assertSentinel(t, storage.ErrNotFound, err)
+	})
+
+	t.Run("Nested", func(t *testing.T) {
+		failure := errors.New("inner failure")
+		err := s.WithTx(func(tx storage.Storage) error {
+			if err := tx.CreateOrganization(newOrganization("org-4")); err != nil {
+				return err
+			}
+			return tx.WithTx(func(inner storage.Storage) error {
+				if err := inner.CreateOrganization(newOrganization("org-5")); err != nil {
+					return err
+				}
+				return failure
+			})
+		})
+		assert.Same(t, failure, err)
+
+		_, err = s.GetOrganization("org-4")
+		assertSentinel(t, storage.ErrNotFound, err)
+		_, err = s.GetOrganization("org-5")
+		assertSentinel(t, storage.ErrNotFound, err)
+	})
+}
+
+func testConcurrentWrites(t *testing.T, s storage.Storage) {
+	const writers = 10
+
+	// run calls write from several goroutines at once and returns the error of every call
+	run := func(n int, write func(i int) error) []error {
+		errs := make([]error, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = write(i)
+			}(i)
+		}
+		wg.Wait()
+		return errs
+	}
+
+	t.Run("DistinctCreates", func(t *testing.T) {
+		errs := run(2*writers, func(i int) error {
+			return s.CreateUser(newUser(fmt.Sprintf("distinct-%d", i)))
+		})
+		for _, err := range errs {
+			assert.NoError(t, err)
+		}
+		users, err := s.ListUsers()
+		require.NoError(t, err)
+		assert.Len(t, users, 2*writers)
+	})
+
+	t.Run("SameUsername", func(t *testing.T) {
+		errs := run(writers, func(i int) error {
+			user := newUser(fmt.Sprintf("contender-%d", i))
+			user.Username = "contended"
+			return s.CreateUser(user)
+		})
+		created := 0
+		for _, err := range errs {
+			if err == nil {
+				created++
+				continue
+			}
+			assertSentinel(t, storage.ErrAlreadyExists, err)
+		}
+		assert.Equal(t, 1, created, "exactly one create wins")
+	})
+
+	t.Run("SameVersion", func(t *testing.T) {
+		require.NoError(t, s.CreateOrganization(newOrganization("org-1")))
+		errs := run(writers, func(i int) error {
+			org := newOrganization("org-1")
+			org.Description = fmt.Sprintf("writer %d", i)
+			org.ResourceVersion = 1
+			return s.UpdateOrganization(org)
+		})
+		updated := 0
+		for _, err := range errs {
+			if err == nil {
+				updated++
+				continue
+			}
+			assertSentinel(t, storage.ErrConflict, err)
+		}
+		assert.Equal(t, 1, updated, "exactly one update wins")
+
+		stored, err := s.GetOrganization("org-1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), stored.ResourceVersion)
+	})
+
+	t.Run("Unconditional", func(t *testing.T) {
+		require.NoError(t, s.CreateOrganization(newOrganization("org-2")))
+		errs := run(writers, func(i int) error {
+			org := newOrganization("org-2")
+			org.Description = fmt.Sprintf("writer %d", i)
+			return s.UpdateOrganization(org)
+		})
+		for _, err := range errs {
+			assert.NoError(t, err)
+		}
+
+		// Every update counts, none is lost
+		stored, err := s.GetOrganization("org-2")
+		require.NoError(t, err)
+		assert.Equal(t, int64(writers+1), stored.ResourceVersion)
+	})
+}
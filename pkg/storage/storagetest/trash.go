@@ -0,0 +1,160 @@
+package storagetest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+func testTrash(t *testing.T, s storage.Storage) {
+	require.NoError(t, s.CreateOrganization(newOrganization("org-1")))
+	require.NoError(t, s.CreateOrganization(newOrganization("org-2")))
+	require.NoError(t, s.CreateVDC(newVDC("vdc-1", "org-1")))
+	require.NoError(t, s.CreateVM(newVM("vm-1", "org-1")))
+
+	// Deleted last, listed last
+	require.NoError(t, s.DeleteOrganization("org-2"))
+	pause()
+	require.NoError(t, s.DeleteOrganization("org-1"))
+	require.NoError(t, s.DeleteVDC("vdc-1"))
+	require.NoError(t, s.DeleteVM("vm-1"))
+
+	t.Run("Hidden", func(t *testing.T) {
+		_, err := s.GetOrganization("org-1")
+		assertSentinel(t, storage.ErrNotFound, err)
+		_, err = s.GetVDC("vdc-1")
+		assertSentinel(t, storage.ErrNotFound, err)
+		_, err = s.GetVM("vm-1")
+		assertSentinel(t, storage.ErrNotFound, err)
+
+		orgs, err := s.ListOrganizations()
+		require.NoError(t, err)
+		assert.Empty(t, orgs)
+		vdcs, err := s.ListVDCs("")
+		require.NoError(t, err)
+		assert.Empty(t, vdcs)
+		vms, err := s.ListVMs("")
+		require.NoError(t, err)
+		assert.Empty(t, vms)
+
+		assertSentinel(t, storage.ErrNotFound, s.DeleteOrganization("org-1"), "double delete")
+		assertSentinel(t, storage.ErrNotFound, s.DeleteVDC("vdc-1"), "double delete")
+		assertSentinel(t, storage.ErrNotFound, s.DeleteVM("vm-1"), "double delete")
+		assertSentinel(t, storage.ErrNotFound, s.UpdateOrganization(newOrganization("org-1")), "update of a deleted record")
+	})
+
+	t.Run("ListDeleted", func(t *testing.T) {
+		orgs, err := s.ListDeletedOrganizations()
+		require.NoError(t, err)
+		require.Len(t, orgs, 2)
+		assert.Equal(t, "org-2", orgs[0].ID)
+		assert.Equal(t, "org-1", orgs[1].ID)
+		require.NotNil(t, orgs[1].DeletedAt)
+
+		vdcs, err := s.ListDeletedVDCs()
+		require.NoError(t, err)
+		require.Len(t, vdcs, 1)
+		assert.Equal(t, "vdc-1", vdcs[0].ID)
+
+		vms, err := s.ListDeletedVMs()
+		require.NoError(t, err)
+		require.Len(t, vms, 1)
+		assert.Equal(t, "vm-1", vms[0].ID)
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		require.NoError(t, s.RestoreOrganization("org-1"))
+		require.NoError(t, s.RestoreVDC("vdc-1"))
+		require.NoError(t, s.RestoreVM("vm-1"))
+
+		// Deleting and restoring are both updates of the record
+		org, err := s.GetOrganization("org-1")
+		require.NoError(t, err)
+		assert.Nil(t, org.DeletedAt)
+		assert.Equal(t, int64(3), org.ResourceVersion)
+		vdc, err := s.GetVDC("vdc-1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), vdc.ResourceVersion)
+		vm, err := s.GetVM("vm-1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), vm.ResourceVersion)
+
+		assertSentinel(t, storage.ErrNotFound, s.RestoreOrganization("org-1"), "restore of a live record")
+		assertSentinel(t, storage.ErrNotFound, s.RestoreVDC("vdc-1"), "restore of a live record")
+		assertSentinel(t, storage.ErrNotFound, s.RestoreVM("vm-1"), "restore of a live record")
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		// Purging works on live and deleted records alike
+		require.NoError(t, s.PurgeOrganization("org-2"))
+		require.NoError(t, s.PurgeVM("vm-1"))
+		require.NoError(t, s.PurgeVDC("vdc-1"))
+
+		orgs, err := s.ListDeletedOrganizations()
+		require.NoError(t, err)
+		assert.Empty(t, orgs)
+		assertSentinel(t, storage.ErrNotFound, s.RestoreOrganization("org-2"))
+		assertSentinel(t, storage.ErrNotFound, s.PurgeOrganization("org-2"))
+		assertSentinel(t, storage.ErrNotFound, s.PurgeVDC("vdc-1"))
+		assertSentinel(t, storage.ErrNotFound, s.PurgeVM("vm-1"))
+
+		// The ID of a purged record is free again
+		require.NoError(t, s.CreateOrganization(newOrganization("org-2")))
+	})
+}
+
+func testCascade(t *testing.T, s storage.Storage) {
+	orgID := "org-1"
+	require.NoError(t, s.CreateOrganization(newOrganization(orgID)))
+	require.NoError(t, s.CreateOrganization(newOrganization("org-2")))
+	require.NoError(t, s.CreateVDC(newVDC("vdc-1", orgID)))
+	require.NoError(t, s.CreateVDC(newVDC("vdc-2", orgID)))
+	require.NoError(t, s.CreateVDC(newVDC("vdc-3", "org-2")))
+	require.NoError(t, s.CreateVM(newVM("vm-1", orgID)))
+	require.NoError(t, s.CreateCatalog(newCatalog("catalog-1", orgID)))
+	require.NoError(t, s.CreateCatalog(newCatalog("catalog-2", "org-2")))
+	catalogID := "catalog-1"
+	template := newTemplate("template-1", orgID)
+	template.CatalogID = &catalogID
+	require.NoError(t, s.CreateTemplate(template))
+	member := newUser("user-1")
+	member.OrgID = &orgID
+	require.NoError(t, s.CreateUser(member))
+
+	// Soft deletion leaves the records of the organization alone
+	require.NoError(t, s.DeleteOrganization(orgID))
+	vdcs, err := s.ListVDCs(orgID)
+	require.NoError(t, err)
+	assert.Len(t, vdcs, 2)
+	vms, err := s.ListVMs(orgID)
+	require.NoError(t, err)
+	assert.Len(t, vms, 1)
+
+	require.NoError(t, s.DeleteVDC("vdc-2"))
+	require.NoError(t, s.PurgeOrganization(orgID))
+
+	_, err = s.GetVDC("vdc-1")
+	assertSentinel(t, storage.ErrNotFound, err, "live VDC of a purged organization")
+	deleted, err := s.ListDeletedVDCs()
+	require.NoError(t, err)
+	assert.Empty(t, deleted, "deleted VDC of a purged organization")
+	_, err = s.GetVDC("vdc-3")
+	assert.NoError(t, err, "VDC of another organization")
+
+	_, err = s.GetCatalog("catalog-1")
+	assertSentinel(t, storage.ErrNotFound, err, "catalog of a purged organization")
+	_, err = s.GetCatalog("catalog-2")
+	assert.NoError(t, err, "catalog of another organization")
+	stored, err := s.GetTemplate("template-1")
+	require.NoError(t, err, "templates outlive their catalog")
+	assert.Nil(t, stored.CatalogID)
+
+	// VMs, templates and users only reference the organization by ID
+	_, err = s.GetVM("vm-1")
+	assert.NoError(t, err)
+	_, err = s.GetUserByID("user-1")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,155 @@
+package storagetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+func testUsers(t *testing.T, s storage.Storage) {
+	orgID := "org-1"
+	user := newUser("user-1")
+	user.OrgID = &orgID
+	require.NoError(t, s.CreateUser(user))
+	assert.False(t, user.CreatedAt.IsZero())
+	assert.Equal(t, int64(1), user.ResourceVersion)
+	require.NoError(t, s.CreateUser(newUser("user-2")))
+
+	byID, err := s.GetUserByID("user-1")
+	require.NoError(t, err)
+	assert.Equal(t, "user-user-1", byID.Username)
+	assert.Equal(t, "user-1@example.com", byID.Email)
+	assert.Equal(t, models.RoleOrgUser, byID.Role)
+	require.NotNil(t, byID.OrgID)
+	assert.Equal(t, orgID, *byID.OrgID)
+
+	byName, err := s.GetUserByUsername("user-user-1")
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", byName.ID)
+
+	users, err := s.ListUsers()
+	require.NoError(t, err)
+	assert.Len(t, users, 2)
+
+	members, err := s.ListUsersByOrg(orgID)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, "user-1", members[0].ID)
+
+	assert.False(t, byID.MustChangePassword)
+	assert.Nil(t, byID.PasswordChangedAt)
+	assert.False(t, byID.Disabled)
+
+	changedAt := time.Now()
+	byID.Role = models.RoleOrgAdmin
+	byID.OrgID = nil
+	byID.MustChangePassword = true
+	byID.PasswordChangedAt = &changedAt
+	byID.PasswordHistory = models.JSONBArray{"hash-2", "hash-1"}
+	byID.MFAEnabled = true
+	byID.MFASecret = "secret"
+	byID.MFALastCounter = 42
+	byID.MFARecoveryCodes = models.JSONBArray{"code-1", "code-2"}
+	byID.Disabled = true
+	require.NoError(t, s.UpdateUser(byID))
+	updated, err := s.GetUserByID("user-1")
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleOrgAdmin, updated.Role)
+	assert.Nil(t, updated.OrgID)
+	assert.True(t, updated.MustChangePassword)
+	require.NotNil(t, updated.PasswordChangedAt)
+	assert.WithinDuration(t, changedAt, *updated.PasswordChangedAt, time.Second)
+	assert.Equal(t, models.JSONBArray{"hash-2", "hash-1"}, updated.PasswordHistory)
+	assert.True(t, updated.MFAEnabled)
+	assert.Equal(t, "secret", updated.MFASecret)
+	assert.Equal(t, int64(42), updated.MFALastCounter)
+	assert.Equal(t, models.JSONBArray{"code-1", "code-2"}, updated.MFARecoveryCodes)
+	assert.True(t, updated.Disabled)
+
+	updated.MustChangePassword = false
+	updated.Disabled = false
+	require.NoError(t, s.UpdateUser(updated))
+	updated, err = s.GetUserByID("user-1")
+	require.NoError(t, err)
+	assert.False(t, updated.MustChangePassword)
+	assert.False(t, updated.Disabled)
+
+	members, err = s.ListUsersByOrg(orgID)
+	require.NoError(t, err)
+	assert.Empty(t, members)
+
+	require.NoError(t, s.DeleteUser("user-1"))
+	_, err = s.GetUserByID("user-1")
+	assertSentinel(t, storage.ErrNotFound, err)
+	_, err = s.GetUserByUsername("user-user-1")
+	assertSentinel(t, storage.ErrNotFound, err)
+}
+
+func testUserUniqueness(t *testing.T, s storage.Storage) {
+	require.NoError(t, s.CreateUser(newUser("alice")))
+
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreateUser(newUser("alice")), "duplicate ID")
+
+	sameUsername := newUser("other-1")
+	sameUsername.Username = "user-alice"
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreateUser(sameUsername), "duplicate username")
+
+	sameEmail := newUser("other-2")
+	sameEmail.Email = "alice@example.com"
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreateUser(sameEmail), "duplicate email")
+
+	bob := newUser("bob")
+	require.NoError(t, s.CreateUser(bob))
+	bob.Username = "user-alice"
+	assertSentinel(t, storage.ErrAlreadyExists, s.UpdateUser(bob), "update to a taken username")
+
+	stored, err := s.GetUserByID("bob")
+	require.NoError(t, err)
+	assert.Equal(t, "user-bob", stored.Username)
+	assert.Equal(t, int64(1), stored.ResourceVersion)
+
+	// A freed username can be taken again
+	require.NoError(t, s.DeleteUser("alice"))
+	require.NoError(t, s.CreateUser(sameUsername))
+
+	users, err := s.ListUsers()
+	require.NoError(t, err)
+	assert.Len(t, users, 2)
+}
+
+func testUserIdentities(t *testing.T, s storage.Storage) {
+	local := newUser("local")
+	require.NoError(t, s.CreateUser(local))
+	stored, err := s.GetUserByID("local")
+	require.NoError(t, err)
+	assert.Equal(t, models.AuthSourceLocal, stored.AuthSource, "users without a source are local")
+	assert.Empty(t, stored.ExternalID)
+
+	alice := newUser("alice")
+	alice.AuthSource, alice.ExternalID = models.AuthSourceLDAP, "uid=alice,ou=people,dc=example,dc=com"
+	require.NoError(t, s.CreateUser(alice))
+	bob := newUser("bob")
+	bob.AuthSource, bob.ExternalID = models.AuthSourceOIDC, "uid=alice,ou=people,dc=example,dc=com"
+	require.NoError(t, s.CreateUser(bob), "subjects are unique within a source")
+
+	byIdentity, err := s.GetUserByExternalID(models.AuthSourceLDAP, "uid=alice,ou=people,dc=example,dc=com")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", byIdentity.ID)
+	byIdentity, err = s.GetUserByExternalID(models.AuthSourceOIDC, "uid=alice,ou=people,dc=example,dc=com")
+	require.NoError(t, err)
+	assert.Equal(t, "bob", byIdentity.ID)
+
+	_, err = s.GetUserByExternalID(models.AuthSourceSCIM, "uid=alice,ou=people,dc=example,dc=com")
+	assertSentinel(t, storage.ErrNotFound, err)
+	_, err = s.GetUserByExternalID(models.AuthSourceLocal, "")
+	assertSentinel(t, storage.ErrNotFound, err, "local users have no subject")
+
+	duplicate := newUser("carol")
+	duplicate.AuthSource, duplicate.ExternalID = models.AuthSourceLDAP, "uid=alice,ou=people,dc=example,dc=com"
+	assertSentinel(t, storage.ErrAlreadyExists, s.CreateUser(duplicate), "duplicate subject")
+}
@@ -0,0 +1,144 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// watchTimeout bounds the wait for an event, backends that poll deliver them with a delay
+const watchTimeout = 5 * time.Second
+
+// nextEvent returns the next event of a watch, failing the test if none arrives
+func nextEvent(t *testing.T, events <-chan storage.ChangeEvent) storage.ChangeEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "watch channel closed")
+		return event
+	case <-time.After(watchTimeout):
+		require.FailNow(t, "no change event received")
+		return storage.ChangeEvent{}
+	}
+}
+
+// assertEvent checks the identity of the next event, ignoring its resource version
+func assertEvent(t *testing.T, events <-chan storage.ChangeEvent, change storage.ChangeType, kind, id, orgID string) storage.ChangeEvent {
+	t.Helper()
+	event := nextEvent(t, events)
+	assert.Equal(t, storage.ChangeEvent{Type: change, Kind: kind, ID: id, OrgID: orgID}, storage.ChangeEvent{Type: event.Type, Kind: event.Kind, ID: event.ID, OrgID: event.OrgID})
+	return event
+}
+
+func testWatch(t *testing.T, s storage.Storage) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := s.Watch(ctx)
+	require.NoError(t, err)
+
+	t.Run("Lifecycle", func(t *testing.T) {
+		require.NoError(t, s.CreateOrganization(newOrganization("org-1")))
+		event := assertEvent(t, events, storage.ChangeCreated, storage.KindOrganization, "org-1", "org-1")
+		assert.Equal(t, int64(1), event.ResourceVersion)
+
+		org := newOrganization("org-1")
+		org.Description = "updated"
+		require.NoError(t, s.UpdateOrganization(org))
+		event = assertEvent(t, events, storage.ChangeUpdated, storage.KindOrganization, "org-1", "org-1")
+		assert.Equal(t, int64(2), event.ResourceVersion)
+
+		// The trash hides records, so watchers see them go and come back
+		require.NoError(t, s.DeleteOrganization("org-1"))
+		assertEvent(t, events, storage.ChangeDeleted, storage.KindOrganization, "org-1", "org-1")
+		require.NoError(t, s.RestoreOrganization("org-1"))
+		assertEvent(t, events, storage.ChangeCreated, storage.KindOrganization, "org-1", "org-1")
+	})
+
+	t.Run("Kinds", func(t *testing.T) {
+		orgID := "org-1"
+		user := newUser("user-1")
+		user.OrgID = &orgID
+		require.NoError(t, s.CreateUser(user))
+		assertEvent(t, events, storage.ChangeCreated, storage.KindUser, "user-1", orgID)
+		require.NoError(t, s.CreateVDC(newVDC("vdc-1", orgID)))
+		assertEvent(t, events, storage.ChangeCreated, storage.KindVDC, "vdc-1", orgID)
+		require.NoError(t, s.CreateCatalog(newCatalog("catalog-1", orgID)))
+		assertEvent(t, events, storage.ChangeCreated, storage.KindCatalog, "catalog-1", orgID)
+		require.NoError(t, s.CreateTemplate(newTemplate("template-1", orgID)))
+		assertEvent(t, events, storage.ChangeCreated, storage.KindTemplate, "template-1", orgID)
+		vm := newVM("vm-1", orgID)
+		require.NoError(t, s.CreateVM(vm))
+		event := assertEvent(t, events, storage.ChangeCreated, storage.KindVM, "vm-1", orgID)
+		assert.Equal(t, vm.OwnerID, event.OwnerID)
+		require.NoError(t, s.CreateOrganizationCatalogSource(newCatalogSource("source-1", orgID)))
+		assertEvent(t, events, storage.ChangeCreated, storage.KindCatalogSource, "source-1", orgID)
+
+		require.NoError(t, s.DeleteOrganizationCatalogSource("source-1"))
+		assertEvent(t, events, storage.ChangeDeleted, storage.KindCatalogSource, "source-1", orgID)
+		require.NoError(t, s.DeleteTemplate("template-1"))
+		assertEvent(t, events, storage.ChangeDeleted, storage.KindTemplate, "template-1", orgID)
+		require.NoError(t, s.DeleteCatalog("catalog-1"))
+		assertEvent(t, events, storage.ChangeDeleted, storage.KindCatalog, "catalog-1", orgID)
+		require.NoError(t, s.DeleteUser("user-1"))
+		assertEvent(t, events, storage.ChangeDeleted, storage.KindUser, "user-1", orgID)
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		// Purging a live record deletes it, purging a trashed one changes nothing visible
+		require.NoError(t, s.PurgeVM("vm-1"))
+		assertEvent(t, events, storage.ChangeDeleted, storage.KindVM, "vm-1", "org-1")
+		require.NoError(t, s.DeleteVDC("vdc-1"))
+		assertEvent(t, events, storage.ChangeDeleted, storage.KindVDC, "vdc-1", "org-1")
+		require.NoError(t, s.PurgeVDC("vdc-1"))
+
+		require.NoError(t, s.CreateOrganization(newOrganization("org-2")))
+		assertEvent(t, events, storage.ChangeCreated, storage.KindOrganization, "org-2", "org-2")
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		err := s.WithTx(func(tx storage.Storage) error {
+			if err := tx.CreateOrganization(newOrganization("org-3")); err != nil {
+				return err
+			}
+			return errors.New("rollback")
+		})
+		require.Error(t, err)
+
+		err = s.WithTx(func(tx storage.Storage) error {
+			if err := tx.CreateOrganization(newOrganization("org-4")); err != nil {
+				return err
+			}
+			return tx.CreateVDC(newVDC("vdc-4", "org-4"))
+		})
+		require.NoError(t, err)
+
+		// Rolled back changes are never reported, committed ones in order
+		assertEvent(t, events, storage.ChangeCreated, storage.KindOrganization, "org-4", "org-4")
+		assertEvent(t, events, storage.ChangeCreated, storage.KindVDC, "vdc-4", "org-4")
+	})
+
+	t.Run("Watchers", func(t *testing.T) {
+		otherCtx, otherCancel := context.WithCancel(context.Background())
+		other, err := s.Watch(otherCtx)
+		require.NoError(t, err)
+
+		// Every watcher gets every event
+		require.NoError(t, s.CreateOrganization(newOrganization("org-5")))
+		assertEvent(t, events, storage.ChangeCreated, storage.KindOrganization, "org-5", "org-5")
+		assertEvent(t, other, storage.ChangeCreated, storage.KindOrganization, "org-5", "org-5")
+
+		otherCancel()
+		select {
+		case _, ok := <-other:
+			assert.False(t, ok, "no event is pending")
+		case <-time.After(watchTimeout):
+			assert.Fail(t, "the channel of a cancelled watch is closed")
+		}
+	})
+}
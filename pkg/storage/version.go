@@ -0,0 +1,17 @@
+package storage
+
+// nextVersion returns the resource version to store when updating a resource currently
+// at the given version. An expected version of 0 skips the optimistic concurrency check.
+func nextVersion(expected, current int64) (int64, error) {
+	if expected != 0 && expected != current {
+		return 0, ErrConflict
+	}
+	return current + 1, nil
+}
+
+// clone returns a shallow copy of a stored resource so callers cannot modify
+// the in-memory state, and with it the resource version, without an update
+func clone[T any](v *T) *T {
+	c := *v
+	return &c
+}
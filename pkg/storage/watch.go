@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/eliorerz/ovim-updated/pkg/models"
+)
+
+// ChangeType tells how a record changed
+type ChangeType string
+
+const (
+	ChangeCreated ChangeType = "created"
+	ChangeUpdated ChangeType = "updated"
+	ChangeDeleted ChangeType = "deleted"
+)
+
+// Kinds of records reported by change events
+const (
+	KindUser          = "user"
+	KindOrganization  = "organization"
+	KindVDC           = "vdc"
+	KindCatalog       = "catalog"
+	KindTemplate      = "template"
+	KindVM            = "vm"
+	KindCatalogSource = "catalog_source"
+)
+
+// ChangeEvent reports a committed change to a record. It identifies the record rather than
+// carrying it, watchers get the records they are interested in.
+type ChangeEvent struct {
+	Type ChangeType `json:"type"`
+	Kind string     `json:"kind"`
+	ID   string     `json:"id"`
+	// OrgID is the organization the record belongs to, the record's own ID for organizations
+	// and empty for records of no organization
+	OrgID string `json:"org_id,omitempty"`
+	// OwnerID is the user owning the record, only VMs have owners
+	OwnerID string `json:"owner_id,omitempty"`
+	// ResourceVersion is the version after the change, zero for records without versions
+	ResourceVersion int64 `json:"resource_version,omitempty"`
+}
+
+// watchBuffer is how many events a watcher may fall behind before it is dropped
+const watchBuffer = 256
+
+var errWatchClosed = errors.New("storage is closed")
+
+// changeHub fans change events out to the watchers of one storage
+type changeHub struct {
+	mutex    sync.Mutex
+	watchers map[chan ChangeEvent]struct{}
+	closed   bool
+}
+
+func newChangeHub() *changeHub {
+	return &changeHub{watchers: make(map[chan ChangeEvent]struct{})}
+}
+
+// subscribe adds a watcher that is removed, and its channel closed, once ctx is done
+func (h *changeHub) subscribe(ctx context.Context) (<-chan ChangeEvent, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.closed {
+		return nil, errWatchClosed
+	}
+	events := make(chan ChangeEvent, watchBuffer)
+	h.watchers[events] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		h.remove(events)
+	}()
+	return events, nil
+}
+
+// publish delivers events to every watcher without blocking. A watcher whose buffer is full
+// has missed events, it is dropped so that it notices and starts over.
+func (h *changeHub) publish(events ...ChangeEvent) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for watcher := range h.watchers {
+		for _, event := range events {
+			if !send(watcher, event) {
+				h.remove(watcher)
+				break
+			}
+		}
+	}
+}
+
+// send delivers an event unless the channel is full
+func send(watcher chan ChangeEvent, event ChangeEvent) bool {
+	select {
+	case watcher <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// reset drops every watcher, for when events may have been lost
+func (h *changeHub) reset() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for watcher := range h.watchers {
+		h.remove(watcher)
+	}
+}
+
+// close drops every watcher and refuses new ones
+func (h *changeHub) close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for watcher := range h.watchers {
+		h.remove(watcher)
+	}
+	h.closed = true
+}
+
+// remove closes the channel of a watcher unless it was removed already. Callers hold the mutex.
+func (h *changeHub) remove(watcher chan ChangeEvent) {
+	if _, exists := h.watchers[watcher]; exists {
+		delete(h.watchers, watcher)
+		close(watcher)
+	}
+}
+
+func userEvent(change ChangeType, user *models.User) ChangeEvent {
+	event := ChangeEvent{Type: change, Kind: KindUser, ID: user.ID, ResourceVersion: user.ResourceVersion}
+	if user.OrgID != nil {
+		event.OrgID = *user.OrgID
+	}
+	return event
+}
+
+func organizationEvent(change ChangeType, org *models.Organization) ChangeEvent {
+	return ChangeEvent{Type: change, Kind: KindOrganization, ID: org.ID, OrgID: org.ID, ResourceVersion: org.ResourceVersion}
+}
+
+func vdcEvent(change ChangeType, vdc *models.VirtualDataCenter) ChangeEvent {
+	return ChangeEvent{Type: change, Kind: KindVDC, ID: vdc.ID, OrgID: vdc.OrgID, ResourceVersion: vdc.ResourceVersion}
+}
+
+func catalogEvent(change ChangeType, catalog *models.Catalog) ChangeEvent {
+	return ChangeEvent{Type: change, Kind: KindCatalog, ID: catalog.ID, OrgID: catalog.OrgID, ResourceVersion: catalog.ResourceVersion}
+}
+
+func templateEvent(change ChangeType, template *models.Template) ChangeEvent {
+	return ChangeEvent{Type: change, Kind: KindTemplate, ID: template.ID, OrgID: template.OrgID, ResourceVersion: template.ResourceVersion}
+}
+
+func vmEvent(change ChangeType, vm *models.VirtualMachine) ChangeEvent {
+	return ChangeEvent{Type: change, Kind: KindVM, ID: vm.ID, OrgID: vm.OrgID, OwnerID: vm.OwnerID, ResourceVersion: vm.ResourceVersion}
+}
+
+func catalogSourceEvent(change ChangeType, source *models.OrganizationCatalogSource) ChangeEvent {
+	return ChangeEvent{Type: change, Kind: KindCatalogSource, ID: source.ID, OrgID: source.OrgID}
+}
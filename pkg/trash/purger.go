@@ -0,0 +1,226 @@
+package trash
+
+import (
+	"context"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ovimv1 "github.com/eliorerz/ovim-updated/pkg/api/v1"
+	"github.com/eliorerz/ovim-updated/pkg/kubevirt"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+// Purger permanently removes organizations, VDCs and VMs that have been in the trash for longer
+// than the retention period, together with the cluster resources that were kept for them
+type Purger struct {
+	storage     storage.Storage
+	k8sClient   client.Client
+	provisioner kubevirt.VMProvisioner
+	retention   time.Duration
+	now         func() time.Time
+}
+
+// NewPurger creates a new trash purger. The Kubernetes client and the provisioner are optional,
+// without them only database records are purged.
+func NewPurger(storage storage.Storage, k8sClient client.Client, provisioner kubevirt.VMProvisioner, retention time.Duration) *Purger {
+	return &Purger{
+		storage:     storage,
+		k8sClient:   k8sClient,
+		provisioner: provisioner,
+		retention:   retention,
+		now:         time.Now,
+	}
+}
+
+// Run purges expired items every interval until the context is cancelled. A retention of zero
+// keeps deleted items forever, in which case Run returns immediately.
+func (p *Purger) Run(ctx context.Context, interval time.Duration) {
+	if p.retention <= 0 {
+		klog.Info("Trash retention is unlimited, purger disabled")
+		return
+	}
+
+	klog.Infof("Trash purger started with retention %s", p.retention)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if purged := p.PurgeExpired(ctx); purged > 0 {
+			klog.Infof("Purged %d expired items from the trash", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeExpired purges every expired item and returns how many were purged. VMs go first, then
+// VDCs, then organizations, so children are gone before their parents. Items that fail to purge
+// are logged and retried on the next run.
+func (p *Purger) PurgeExpired(ctx context.Context) int {
+	cutoff := p.now().Add(-p.retention)
+	purged := 0
+
+	vdcs, err := p.storage.ListDeletedVDCs()
+	if err != nil {
+		klog.Errorf("Failed to list deleted VDCs: %v", err)
+		return 0
+	}
+	deletedVDCs := make(map[string]*models.VirtualDataCenter, len(vdcs))
+	for _, vdc := range vdcs {
+		deletedVDCs[vdc.ID] = vdc
+	}
+
+	vms, err := p.storage.ListDeletedVMs()
+	if err != nil {
+		klog.Errorf("Failed to list deleted VMs: %v", err)
+		return 0
+	}
+	for _, vm := range vms {
+		if !expired(vm.DeletedAt, cutoff) {
+			continue
+		}
+		if err := p.purgeVM(ctx, vm, deletedVDCs); err != nil {
+			klog.Errorf("Failed to purge VM %s: %v", vm.ID, err)
+			continue
+		}
+		purged++
+	}
+
+	for _, vdc := range vdcs {
+		if !expired(vdc.DeletedAt, cutoff) {
+			continue
+		}
+		if err := p.purgeVDC(ctx, vdc); err != nil {
+			klog.Errorf("Failed to purge VDC %s: %v", vdc.ID, err)
+			continue
+		}
+		purged++
+	}
+
+	orgs, err := p.storage.ListDeletedOrganizations()
+	if err != nil {
+		klog.Errorf("Failed to list deleted organizations: %v", err)
+		return purged
+	}
+	for _, org := range orgs {
+		if !expired(org.DeletedAt, cutoff) {
+			continue
+		}
+		if err := p.purgeOrganization(ctx, org); err != nil {
+			klog.Errorf("Failed to purge organization %s: %v", org.ID, err)
+			continue
+		}
+		purged++
+	}
+
+	return purged
+}
+
+// purgeVM deletes the VM from the cluster, then its record. When the VDC is gone its namespace,
+// and the VM with it, was already deleted.
+func (p *Purger) purgeVM(ctx context.Context, vm *models.VirtualMachine, deletedVDCs map[string]*models.VirtualDataCenter) error {
+	if p.provisioner != nil && vm.VDCID != nil {
+		vdc, err := p.storage.GetVDC(*vm.VDCID)
+		if err == storage.ErrNotFound {
+			vdc, err = deletedVDCs[*vm.VDCID], nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if vdc != nil && vdc.WorkloadNamespace != "" {
+			// A VM that never made it to the cluster has nothing to delete
+			if _, err := p.provisioner.GetVMStatus(ctx, vm.ID, vdc.WorkloadNamespace); err != nil {
+				klog.V(4).Infof("VM %s not found in namespace %s, purging record only: %v", vm.ID, vdc.WorkloadNamespace, err)
+			} else if err := p.provisioner.DeleteVM(ctx, vm.ID, vdc.WorkloadNamespace); err != nil {
+				return err
+			}
+		}
+	}
+
+	return ignoreNotFound(p.storage.PurgeVM(vm.ID))
+}
+
+// purgeVDC purges the VDC record and deletes its CRD. The CRD finalizer removes the workload
+// namespace.
+func (p *Purger) purgeVDC(ctx context.Context, vdc *models.VirtualDataCenter) error {
+	return p.storage.WithTx(func(tx storage.Storage) error {
+		if err := ignoreNotFound(tx.PurgeVDC(vdc.ID)); err != nil {
+			return err
+		}
+
+		vdcCR := &ovimv1.VirtualDataCenter{}
+		return p.deleteCR(ctx, client.ObjectKey{Name: vdc.CRName, Namespace: vdc.CRNamespace}, vdcCR)
+	})
+}
+
+// purgeOrganization detaches the members and catalog sources of the organization, purges its
+// record and deletes its CRD. The CRD finalizer removes the organization namespace.
+func (p *Purger) purgeOrganization(ctx context.Context, org *models.Organization) error {
+	return p.storage.WithTx(func(tx storage.Storage) error {
+		users, err := tx.ListUsersByOrg(org.ID)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			user.OrgID = nil
+			user.UpdatedAt = p.now()
+			if err := tx.UpdateUser(user); err != nil {
+				return err
+			}
+		}
+
+		sources, err := tx.ListOrganizationCatalogSources(org.ID)
+		if err != nil {
+			return err
+		}
+		for _, source := range sources {
+			if err := tx.DeleteOrganizationCatalogSource(source.ID); err != nil {
+				return err
+			}
+		}
+
+		if err := ignoreNotFound(tx.PurgeOrganization(org.ID)); err != nil {
+			return err
+		}
+
+		orgCR := &ovimv1.Organization{}
+		return p.deleteCR(ctx, client.ObjectKey{Name: org.ID}, orgCR)
+	})
+}
+
+// deleteCR deletes a CRD if it still exists
+func (p *Purger) deleteCR(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if p.k8sClient == nil {
+		return nil
+	}
+	if err := p.k8sClient.Get(ctx, key, obj); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if err := p.k8sClient.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func expired(deletedAt *time.Time, cutoff time.Time) bool {
+	return deletedAt != nil && deletedAt.Before(cutoff)
+}
+
+func ignoreNotFound(err error) error {
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	return err
+}
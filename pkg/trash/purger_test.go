@@ -0,0 +1,134 @@
+package trash
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	ovimv1 "github.com/eliorerz/ovim-updated/pkg/api/v1"
+	"github.com/eliorerz/ovim-updated/pkg/kubevirt"
+	"github.com/eliorerz/ovim-updated/pkg/models"
+	"github.com/eliorerz/ovim-updated/pkg/storage"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func setupPurgerTest(t *testing.T) (*Purger, storage.Storage, client.Client, kubevirt.VMProvisioner) {
+	ctx := context.Background()
+
+	s := runtime.NewScheme()
+	require.NoError(t, scheme.AddToScheme(s))
+	require.NoError(t, ovimv1.AddToScheme(s))
+	k8sClient := fake.NewClientBuilder().WithScheme(s).WithObjects(
+		&ovimv1.Organization{ObjectMeta: metav1.ObjectMeta{Name: "org-1"}},
+		&ovimv1.VirtualDataCenter{ObjectMeta: metav1.ObjectMeta{Name: "vdc-1", Namespace: "org-org-1"}},
+	).Build()
+
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+	org := &models.Organization{ID: "org-1", Name: "Org 1", CRName: "org-1"}
+	vdc := &models.VirtualDataCenter{ID: "vdc-1", OrgID: "org-1", CRName: "vdc-1", CRNamespace: "org-org-1", WorkloadNamespace: "vdc-org-1-vdc-1"}
+	vm := &models.VirtualMachine{ID: "vm-1", OrgID: "org-1", VDCID: stringPtr("vdc-1")}
+	require.NoError(t, store.CreateOrganization(org))
+	require.NoError(t, store.CreateVDC(vdc))
+	require.NoError(t, store.CreateVM(vm))
+	require.NoError(t, store.CreateUser(&models.User{ID: "member", Username: "member", Role: models.RoleOrgUser, OrgID: stringPtr("org-1")}))
+	require.NoError(t, store.CreateOrganizationCatalogSource(&models.OrganizationCatalogSource{ID: "source-1", OrgID: "org-1"}))
+
+	provisioner := kubevirt.NewMockClient()
+	require.NoError(t, provisioner.CreateVM(ctx, vm, vdc, &models.Template{ID: "template-1"}))
+
+	require.NoError(t, store.DeleteVM("vm-1"))
+	require.NoError(t, store.DeleteVDC("vdc-1"))
+	require.NoError(t, store.DeleteOrganization("org-1"))
+
+	return NewPurger(store, k8sClient, provisioner, 24*time.Hour), store, k8sClient, provisioner
+}
+
+func TestPurger_PurgeExpired(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("WithinRetention", func(t *testing.T) {
+		purger, store, _, _ := setupPurgerTest(t)
+		purger.now = func() time.Time { return time.Now().Add(12 * time.Hour) }
+
+		assert.Equal(t, 0, purger.PurgeExpired(ctx))
+
+		orgs, err := store.ListDeletedOrganizations()
+		require.NoError(t, err)
+		assert.Len(t, orgs, 1)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		purger, store, k8sClient, provisioner := setupPurgerTest(t)
+		purger.now = func() time.Time { return time.Now().Add(48 * time.Hour) }
+
+		assert.Equal(t, 3, purger.PurgeExpired(ctx))
+
+		orgs, err := store.ListDeletedOrganizations()
+		require.NoError(t, err)
+		assert.Empty(t, orgs)
+		vdcs, err := store.ListDeletedVDCs()
+		require.NoError(t, err)
+		assert.Empty(t, vdcs)
+		vms, err := store.ListDeletedVMs()
+		require.NoError(t, err)
+		assert.Empty(t, vms)
+
+		// Cluster resources are gone
+		_, err = provisioner.GetVMStatus(ctx, "vm-1", "vdc-org-1-vdc-1")
+		assert.Error(t, err)
+		err = k8sClient.Get(ctx, client.ObjectKey{Name: "vdc-1", Namespace: "org-org-1"}, &ovimv1.VirtualDataCenter{})
+		assert.True(t, apierrors.IsNotFound(err))
+		err = k8sClient.Get(ctx, client.ObjectKey{Name: "org-1"}, &ovimv1.Organization{})
+		assert.True(t, apierrors.IsNotFound(err))
+
+		// Members are detached and catalog sources removed
+		user, err := store.GetUserByID("member")
+		require.NoError(t, err)
+		assert.Nil(t, user.OrgID)
+		_, err = store.GetOrganizationCatalogSource("source-1")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("MissingClusterResources", func(t *testing.T) {
+		purger, store, _, _ := setupPurgerTest(t)
+		purger.k8sClient = nil
+		purger.provisioner = kubevirt.NewMockClient()
+		purger.now = func() time.Time { return time.Now().Add(48 * time.Hour) }
+
+		assert.Equal(t, 3, purger.PurgeExpired(ctx))
+
+		vms, err := store.ListDeletedVMs()
+		require.NoError(t, err)
+		assert.Empty(t, vms)
+	})
+}
+
+func TestPurger_RunDisabled(t *testing.T) {
+	store, err := storage.NewMemoryStorageForTest()
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		NewPurger(store, nil, nil, 0).Run(context.Background(), time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return with unlimited retention")
+	}
+}
@@ -8,10 +8,9 @@ CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
 CREATE EXTENSION IF NOT EXISTS "pgcrypto";
 
 -- Create initial schemas if needed
--- GORM will handle table creation via AutoMigrate
+-- Tables are created by the versioned migrations embedded in ovim-server
 
 -- Set timezone
 SET timezone = 'UTC';
 
--- Create indexes that GORM might not create automatically
--- These will be created after GORM migration in the application
\ No newline at end of file
+-- Indexes are created by the same migrations when the application starts
\ No newline at end of file